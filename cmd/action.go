@@ -27,13 +27,15 @@ import (
 	"os"
 	"strings"
 
-	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
 	"github.com/bizflycloud/bizflyctl/formatter"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
 	actionID           string
+	followAction       bool
 	listActionsHeaders = []string{"ID", "Action", "Status", "RecoveryPointID", "PolicyID", "Progress", "Message"}
 )
 
@@ -79,7 +81,7 @@ var listActionCmd = &cobra.Command{
 
 		defer resp.Body.Close()
 
-		var rla backupapi.ListActivity
+		var rla server.ListActionResponse
 		if err := json.NewDecoder(resp.Body).Decode(&rla); err != nil {
 			_, err := fmt.Fprintln(os.Stderr, err.Error())
 			if err != nil {
@@ -102,6 +104,9 @@ var listActionCmd = &cobra.Command{
 
 			data = append(data, []string{ac.ID, ac.Action, ac.Status, ac.RecoveryPoint.ID, ac.PolicyID, progress, ac.Message})
 		}
+		for _, q := range rla.Queued {
+			data = append(data, []string{q.ActionID, "BACKUP", "QUEUED", q.RecoveryPointID, q.PolicyID, "0%", fmt.Sprintf("position %d in queue", q.Position)})
+		}
 
 		formatter.Output(listActionsHeaders, data)
 	},
@@ -147,10 +152,118 @@ var stopActionCmd = &cobra.Command{
 	},
 }
 
+var resumeActionCmd = &cobra.Command{
+	Use:   "resume <action_id>",
+	Short: "Resume a restore paused as its destination was full or read-only.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		urlRequest := strings.Join([]string{addr, "actions", args[0], "resume"}, "/")
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodPost, urlRequest, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		_, _ = io.Copy(os.Stderr, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			os.Exit(1)
+		}
+	},
+}
+
+var eventsActionCmd = &cobra.Command{
+	Use:   "events <action_id>",
+	Short: "Follow the status and progress messages of a running action until it ends, as lines of JSON.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		urlRequest := strings.Join([]string{addr, "actions", args[0], "events"}, "/")
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// call request
+		resp, err := httpc.Get(urlRequest)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+		if err := server.FollowActionEvents(resp.Body, os.Stdout); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// dialProgressFeed connects to the progress feed of agent for --follow, before the action is requested so its start
+// is not missed.
+func dialProgressFeed() io.ReadCloser {
+	path := viper.GetString("progress_socket")
+	if path == "" {
+		logger.Error("--follow needs progress_socket of agent config")
+		os.Exit(1)
+	}
+	feed, err := server.DialFeed(path)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	return feed
+}
+
+// followProgressFeed renders the action requested by resp from feed with follow until it ends.
+func followProgressFeed(feed io.ReadCloser, resp *http.Response, follow func(r io.Reader, w io.Writer, id string) error, id string) {
+	defer feed.Close()
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+	if err := follow(feed, os.Stdout, id); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
 func init() {
 	stopActionCmd.PersistentFlags().StringVar(&actionID, "action_id", "", "The action_id of action want stop.")
 	_ = stopActionCmd.MarkPersistentFlagRequired("action_id")
 	actionCmd.AddCommand(listActionCmd)
 	actionCmd.AddCommand(stopActionCmd)
+	actionCmd.AddCommand(resumeActionCmd)
+	actionCmd.AddCommand(eventsActionCmd)
 	rootCmd.AddCommand(actionCmd)
 }
@@ -25,6 +25,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,9 +35,14 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
+	"github.com/bizflycloud/bizfly-backup/pkg/agentversion"
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 	"github.com/bizflycloud/bizfly-backup/pkg/broker/mqtt"
+	"github.com/bizflycloud/bizfly-backup/pkg/compression"
+	"github.com/bizflycloud/bizfly-backup/pkg/crash"
 	"github.com/bizflycloud/bizfly-backup/pkg/server"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
 )
 
 // agentCmd represents the agent command
@@ -49,11 +56,56 @@ var agentCmd = &cobra.Command{
 			panic(err)
 		}
 
+		// low-memory mode sets its defaults before config is read
+		totalMemory, err := support.TotalMemory()
+		if err != nil {
+			logger.Warn("failed to detect memory of machine, low-memory mode is off", zap.Error(err))
+		}
+		memoryMode := server.NewMemoryMode(totalMemory)
+		if memoryMode.LowMemory {
+			logger.Info("running in low-memory mode", zap.Uint64("total_memory_mb", memoryMode.TotalMemoryMb), zap.Int("low_memory_mb", memoryMode.ThresholdMb))
+			memoryMode.ApplyDefaults()
+		}
+
 		machineID := viper.GetString("machine_id")
 		accessKey := viper.GetString("access_key")
 		secretKey := viper.GetString("secret_key")
 		apiUrl := viper.GetString("api_url")
 		numGoroutine := viper.GetInt("num_goroutine")
+		labels, groups, err := agentLabels()
+		if err != nil {
+			logger.Fatal("invalid labels", zap.Error(err))
+		}
+		if err := compression.Check(viper.GetString("compression")); err != nil {
+			logger.Fatal("invalid compression", zap.Error(err))
+		}
+		if _, err := backupapi.ConfigChunking(); err != nil {
+			logger.Fatal("invalid chunking", zap.Error(err))
+		}
+		if _, err := backupapi.ConfigPackSize(); err != nil {
+			logger.Fatal("invalid pack size", zap.Error(err))
+		}
+
+		// write crash report to cache directory when agent panics
+		_, cachePath, err := support.CheckPath()
+		if err != nil {
+			logger.Fatal("failed to get cache path", zap.Error(err))
+		}
+		crashDir := filepath.Join(cachePath, crashReportDir)
+		crashReporter := crash.NewReporter(crashDir, agentversion.Version(), configSummary())
+		defer crashReporter.Recover()
+
+		shutdownTracing, err := tracing.Setup(tracingConfig(machineID))
+		if err != nil {
+			logger.Fatal("invalid tracing config", zap.Error(err))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				logger.Warn("failed to export remaining spans", zap.Error(err))
+			}
+		}()
 
 		backupClient, err := backupapi.NewClient(
 			backupapi.WithAccessKey(accessKey),
@@ -61,6 +113,11 @@ var agentCmd = &cobra.Command{
 			backupapi.WithServerURL(apiUrl),
 			backupapi.WithID(machineID),
 			backupapi.WithNumGoroutine(numGoroutine),
+			backupapi.WithCacheTTL(apiCacheTTL()),
+			backupapi.WithMemoryLimit(viper.GetInt("max_memory_mb")),
+			backupapi.WithHashWorkers(viper.GetInt("hash_workers")),
+			backupapi.WithLabels(labels),
+			backupapi.WithGroups(groups...),
 		)
 		if err != nil {
 			logger.Error("failed to create new backup client", zap.Error(err))
@@ -76,6 +133,11 @@ var agentCmd = &cobra.Command{
 				break
 			}
 			logger.Error("failed to update machine info", zap.Error(err))
+			if errors.Is(err, backupapi.ErrUnauthorized) {
+				// retrying with the same credential does not help
+				logger.Error("access_key and secret_key are rejected by backup server, run the init command to configure them")
+				os.Exit(1)
+			}
 			d := bo.NextBackOff()
 			if d == backoff.Stop {
 				os.Exit(1)
@@ -83,6 +145,17 @@ var agentCmd = &cobra.Command{
 			time.Sleep(d)
 		}
 
+		// upload crash reports of previous runs
+		if err := crash.UploadPending(crashDir, func(report *crash.Report) (string, error) {
+			crr, err := backupClient.UploadCrashReport(report)
+			if err != nil {
+				return "", err
+			}
+			return crr.ID, nil
+		}, logger); err != nil {
+			logger.Error("failed to upload crash reports", zap.Error(err))
+		}
+
 		mqttUrl := brokerUrl
 		agentID := machineID
 		b, err := mqtt.NewBroker(
@@ -101,11 +174,13 @@ var agentCmd = &cobra.Command{
 		s, err := server.New(
 			server.WithAddr(addr),
 			server.WithBroker(b),
-			server.WithSubscribeTopics("agent/default", "agent/"+agentID),
+			server.WithSubscribeTopics(append([]string{"agent/default", "agent/" + agentID}, groupTopics(labels, groups)...)...),
 			server.WithPublishTopics("agent/"+agentID, "agent/recovery-points/"+agentID),
 			server.WithBackupClient(backupClient),
 			server.WithLogger(logger),
 			server.WithNumGoroutine(numGoroutine),
+			server.WithMemoryMode(memoryMode),
+			server.WithCrashReporter(crashReporter),
 		)
 		if err != nil {
 			logger.Fatal("failed to create new server", zap.Error(err))
@@ -118,6 +193,80 @@ var agentCmd = &cobra.Command{
 	},
 }
 
+const crashReportDir = "crash"
+
+// configSummary returns agent config included in crash reports, secrets are left out.
+func configSummary() map[string]string {
+	summary := make(map[string]string)
+	for _, key := range []string{"machine_id", "api_url", "num_goroutine", "limit_upload", "limit_download", "port"} {
+		summary[key] = viper.GetString(key)
+	}
+	summary["config_file"] = viper.ConfigFileUsed()
+	return summary
+}
+
+const tracingShutdownTimeout = 10 * time.Second
+
+// tracingConfig returns the tracing config set by "otlp_endpoint", "otlp_headers" and "trace_sample_ratio",
+// which defaults to 1 to record every trace.
+func tracingConfig(machineID string) tracing.Config {
+	ratio := 1.0
+	if viper.IsSet("trace_sample_ratio") {
+		ratio = viper.GetFloat64("trace_sample_ratio")
+	}
+	return tracing.Config{
+		Endpoint:       viper.GetString("otlp_endpoint"),
+		Headers:        viper.GetStringMapString("otlp_headers"),
+		SampleRatio:    ratio,
+		ServiceVersion: agentversion.Version(),
+		MachineID:      machineID,
+	}
+}
+
+// apiCacheTTL returns how long API responses are memoized, set by "api_cache_ttl" in seconds.
+func apiCacheTTL() time.Duration {
+	if !viper.IsSet("api_cache_ttl") {
+		return backupapi.DefaultCacheTTL
+	}
+	return time.Duration(viper.GetInt("api_cache_ttl")) * time.Second
+}
+
+// agentLabels returns labels and groups of machine set by "labels" and "groups". They are levels of MQTT topics,
+// so they must not be empty or contain "/", "+" and "#".
+func agentLabels() (map[string]string, []string, error) {
+	labels := viper.GetStringMapString("labels")
+	groups := viper.GetStringSlice("groups")
+	for k, v := range labels {
+		if !validTopicLevel(k) || !validTopicLevel(v) {
+			return nil, nil, fmt.Errorf("invalid label %q=%q", k, v)
+		}
+	}
+	for _, group := range groups {
+		if !validTopicLevel(group) {
+			return nil, nil, fmt.Errorf("invalid group %q", group)
+		}
+	}
+	return labels, groups, nil
+}
+
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#")
+}
+
+// groupTopics returns the topics backup server publishes config updates of groups of machines to,
+// "agent/groups/<group>" for each group and "agent/labels/<key>/<value>" for each label.
+func groupTopics(labels map[string]string, groups []string) []string {
+	topics := make([]string, 0, len(labels)+len(groups))
+	for _, group := range groups {
+		topics = append(topics, "agent/groups/"+group)
+	}
+	for k, v := range labels {
+		topics = append(topics, "agent/labels/"+k+"/"+v)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
 var agentVersionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version of agent server.",
@@ -0,0 +1,65 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func Test_agentLabels(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("labels", map[string]string{"role": "db", "env": "prod"})
+	viper.Set("groups", []string{"hn1"})
+	labels, groups, err := agentLabels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"agent/groups/hn1", "agent/labels/env/prod", "agent/labels/role/db"}
+	if got := groupTopics(labels, groups); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupTopics() = %v, want %v", got, want)
+	}
+
+	viper.Set("labels", map[string]string{"role": "db/primary"})
+	if _, _, err := agentLabels(); err == nil {
+		t.Error("agentLabels() with \"/\" in label value must fail")
+	}
+	viper.Set("labels", nil)
+	viper.Set("groups", []string{"#"})
+	if _, _, err := agentLabels(); err == nil {
+		t.Error("agentLabels() with wildcard group must fail")
+	}
+}
+
+func Test_tracingConfig(t *testing.T) {
+	defer viper.Reset()
+
+	if got := tracingConfig("mc"); got.SampleRatio != 1 || got.Endpoint != "" || got.MachineID != "mc" {
+		t.Errorf("tracingConfig() = %+v", got)
+	}
+	viper.Set("otlp_endpoint", "http://localhost:4318")
+	viper.Set("otlp_headers", map[string]string{"authorization": "Bearer token"})
+	viper.Set("trace_sample_ratio", 0.1)
+	got := tracingConfig("mc")
+	if got.Endpoint != "http://localhost:4318" || got.Headers["authorization"] != "Bearer token" || got.SampleRatio != 0.1 {
+		t.Errorf("tracingConfig() = %+v", got)
+	}
+}
@@ -28,7 +28,10 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -38,15 +41,33 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/manifest"
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
 )
 
 var (
 	listBackupHeaders         = []string{"ID", "Name", "Path", "PolicyID", "Pattern", "Limit Upload", "Retentions", "Activated"}
 	listRecoveryPointsHeaders = []string{"ID", "Name", "Status", "Type", "CREATED AT"}
+	dryRunHeaders             = []string{"Latest Recovery Point", "Files", "Bytes", "Changed Files", "Changed Bytes", "Chunks"}
+	streamHeaders             = []string{"Recovery Point", "Path", "Size", "Storage Size"}
+	lsHeaders                 = []string{"Path", "Type", "Size", "Mode", "Modified"}
 	backupID                  string
 	backupName                string
+	backupOnlyPaths           []string
+	backupDryRun              bool
+	backupPolicyID            string
+	backupStorageVaultID      string
 	recoveryPointID           string
 	backupDownloadOutFile     string
+	backupDownloadFormat      string
+	manifestFormat            string
+	manifestStorageVaultID    string
+	manifestOutFile           string
+	inventoryStorageVaultID   string
+	lsPrefix                  string
+	lsStorageVaultID          string
+	streamName                string
+	streamCommand             string
 )
 
 // backupCmd represents the backup command
@@ -166,8 +187,9 @@ var backupListRecoveryPointCmd = &cobra.Command{
 }
 
 var backupDeleteRecoveryPointCmd = &cobra.Command{
-	Use:   "delete-recovery-points",
-	Short: "Delete a recovery points.",
+	Use:        "delete-recovery-points",
+	Short:      "Delete a recovery points.",
+	Deprecated: "use \"recovery-point delete\" instead.",
 	Run: func(cmd *cobra.Command, args []string) {
 		// make url
 		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID}, "/")
@@ -204,9 +226,16 @@ var backupDeleteRecoveryPointCmd = &cobra.Command{
 var backupDownloadRecoveryPointCmd = &cobra.Command{
 	Use:   "download",
 	Short: "Download backup at given recovery point.",
+	Long: `Download the files of a recovery point as an archive, built by the agent from the index and chunks of
+recovery point in the storage vault, so it can be extracted without the agent:
+  zip     zip archive, the default
+  tar.gz  gzip compressed tar archive, keeping owners and hard links`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// make url
-		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "download"}, "/")
+		query := url.Values{}
+		query.Set("format", backupDownloadFormat)
+		query.Set("storage_vault_id", backupStorageVaultID)
+		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "download"}, "/") + "?" + query.Encode()
 
 		// create client
 		httpc := http.Client{
@@ -245,8 +274,14 @@ var backupDownloadRecoveryPointCmd = &cobra.Command{
 
 		defer resp.Body.Close()
 
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+
 		if backupDownloadOutFile == "" {
-			backupDownloadOutFile = recoveryPointID + ".zip"
+			backupDownloadOutFile = recoveryPointID + "." + backupDownloadFormat
 		}
 
 		f, err := os.Create(backupDownloadOutFile)
@@ -268,6 +303,249 @@ var backupDownloadRecoveryPointCmd = &cobra.Command{
 	},
 }
 
+// backupExportManifestCmd represents the backup export-manifest command
+var backupExportManifestCmd = &cobra.Command{
+	Use:   "export-manifest",
+	Short: "Export the list of files protected by a recovery point.",
+	Long: `Export the list of files protected by a recovery point in a format understood by third-party tools:
+  restic     JSON lines like "restic ls --json"
+  borg       JSON lines like "borg list --json-lines"
+  sha256sum  "<sha256>  <path>" lines, checkable by "sha256sum -c"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		query := url.Values{}
+		query.Set("format", manifestFormat)
+		if manifestStorageVaultID != "" {
+			query.Set("storage_vault_id", manifestStorageVaultID)
+		}
+		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "manifest"}, "/") + "?" + query.Encode()
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodGet, urlRequest, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+
+		var out io.Writer = os.Stdout
+		if manifestOutFile != "" {
+			f, err := os.Create(manifestOutFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// backupLsCmd represents the backup ls command
+var backupLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the files of a recovery point with their sizes and modification times.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		var data [][]string
+		for offset := 0; ; {
+			// make url
+			query := url.Values{}
+			query.Set("offset", strconv.Itoa(offset))
+			if lsPrefix != "" {
+				query.Set("prefix", lsPrefix)
+			}
+			if lsStorageVaultID != "" {
+				query.Set("storage_vault_id", lsStorageVaultID)
+			}
+			urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "files"}, "/") + "?" + query.Encode()
+
+			// call request
+			resp, err := httpc.Get(urlRequest)
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			if resp.StatusCode != http.StatusOK {
+				_, _ = io.Copy(os.Stderr, resp.Body)
+				fmt.Fprintln(os.Stderr)
+				os.Exit(1)
+			}
+			var listing backupapi.FileListing
+			err = json.NewDecoder(resp.Body).Decode(&listing)
+			resp.Body.Close()
+			if err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			for _, f := range listing.Files {
+				data = append(data, []string{f.Path, f.Type, strconv.FormatUint(f.Size, 10), f.Mode, f.ModTime.Format(time.RFC3339)})
+			}
+			if listing.NextOffset == 0 {
+				break
+			}
+			offset = listing.NextOffset
+		}
+		formatter.Output(lsHeaders, data)
+	},
+}
+
+// backupInventoryCmd represents the backup inventory command
+var backupInventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Show the system inventory captured with a recovery point.",
+	Long: `Show the installed packages, enabled services, crontabs and listening ports of machine captured with a
+recovery point as JSON. Recovery points carry an inventory when agent runs with "inventory: true".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		query := url.Values{}
+		query.Set("storage_vault_id", inventoryStorageVaultID)
+		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "inventory"}, "/") + "?" + query.Encode()
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodGet, urlRequest, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+
+		var out io.Writer = os.Stdout
+		if manifestOutFile != "" {
+			f, err := os.Create(manifestOutFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// backupInstanceMetadataCmd represents the backup instance-metadata command
+var backupInstanceMetadataCmd = &cobra.Command{
+	Use:   "instance-metadata",
+	Short: "Show the metadata of cloud server captured with a recovery point.",
+	Long: `Show the instance ID, flavor, network config and cloud-init user data of the cloud server captured with a
+recovery point as JSON, to rebuild the server with its original configuration. Recovery points carry them when agent
+runs with "instance_metadata: true".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		query := url.Values{}
+		query.Set("storage_vault_id", inventoryStorageVaultID)
+		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "instance-metadata"}, "/") + "?" + query.Encode()
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodGet, urlRequest, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+
+		var out io.Writer = os.Stdout
+		if manifestOutFile != "" {
+			f, err := os.Create(manifestOutFile)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
 // backupRunCmd represents the backup run command
 var backupRunCmd = &cobra.Command{
 	Use:   "run",
@@ -275,6 +553,16 @@ var backupRunCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		// make url
 		urlRequest := strings.Join([]string{addr, "backups"}, "/")
+		if backupDryRun {
+			query := url.Values{"dry_run": {"true"}}
+			if backupPolicyID != "" {
+				query.Set("policy_id", backupPolicyID)
+			}
+			if backupStorageVaultID != "" {
+				query.Set("storage_vault_id", backupStorageVaultID)
+			}
+			urlRequest += "?" + query.Encode()
+		}
 
 		// create client
 		httpc := http.Client{
@@ -287,15 +575,22 @@ var backupRunCmd = &cobra.Command{
 
 		// init body
 		var body struct {
-			ID          string `json:"id"`
-			BackupName  string `json:"name"`
-			StorageType string `json:"storage_type"`
+			ID          string   `json:"id"`
+			BackupName  string   `json:"name"`
+			StorageType string   `json:"storage_type"`
+			OnlyPaths   []string `json:"only_paths,omitempty"`
 		}
 		body.ID = backupID
 		body.BackupName = backupName
 		body.StorageType = "S3"
+		body.OnlyPaths = backupOnlyPaths
 		buf, _ := json.Marshal(body)
 
+		var feed io.ReadCloser
+		if followAction && !backupDryRun {
+			feed = dialProgressFeed()
+		}
+
 		// make request
 		req, err := http.NewRequest(http.MethodPost, urlRequest, bytes.NewBuffer(buf))
 		if err != nil {
@@ -315,7 +610,106 @@ var backupRunCmd = &cobra.Command{
 
 		defer resp.Body.Close()
 
+		if backupDryRun {
+			if resp.StatusCode != http.StatusOK {
+				_, _ = io.Copy(os.Stderr, resp.Body)
+				os.Exit(1)
+			}
+			var report server.DryRunReport
+			if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			formatter.Output(dryRunHeaders, [][]string{{report.LatestRecoveryPointID, strconv.FormatInt(report.Files, 10),
+				strconv.FormatUint(report.Bytes, 10), strconv.FormatInt(report.ChangedFiles, 10),
+				strconv.FormatUint(report.ChangedBytes, 10), strconv.FormatUint(report.Chunks, 10)}})
+			return
+		}
 		_, _ = io.Copy(os.Stderr, resp.Body)
+		if feed != nil {
+			followProgressFeed(feed, resp, server.FollowBackup, backupID)
+		}
+	},
+}
+
+// backupStreamCmd represents the backup stream command
+var backupStreamCmd = &cobra.Command{
+	Use:   "stream",
+	Short: "Back up a byte stream read from stdin or produced by a command.",
+	Long: `Back up the output of --cmd, or stdin when it is not given, as file --name of backup directory to a new
+recovery point. The recovery point holds this file only, it is restored in the backup directory:
+  bizfly-backup backup stream --backup-id <id> --name db.sql --cmd "mysqldump --all-databases"
+  pg_dumpall | bizfly-backup backup stream --backup-id <id> --name pg.sql
+The backup fails when the command exits with an error.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		query := url.Values{"name": {streamName}}
+		if backupPolicyID != "" {
+			query.Set("policy_id", backupPolicyID)
+		}
+		urlRequest := strings.Join([]string{addr, "backups", backupID, "stream"}, "/") + "?" + query.Encode()
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// the stream is sent while it is produced, a command failing aborts the request
+		var body io.Reader = os.Stdin
+		if streamCommand != "" {
+			pr, pw := io.Pipe()
+			command := shellCommand(streamCommand)
+			command.Stdout = pw
+			command.Stderr = os.Stderr
+			if err := command.Start(); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			go func() {
+				err := command.Wait()
+				if err != nil {
+					err = fmt.Errorf("%s: %w", streamCommand, err)
+				}
+				_ = pw.CloseWithError(err)
+			}()
+			body = pr
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodPost, urlRequest, body)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// update header
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			_, _ = io.Copy(os.Stderr, resp.Body)
+			fmt.Fprintln(os.Stderr)
+			os.Exit(1)
+		}
+		var stream server.StreamBackupResponse
+		if err := json.NewDecoder(resp.Body).Decode(&stream); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		formatter.Output(streamHeaders, [][]string{{stream.RecoveryPointID, stream.Path,
+			strconv.FormatUint(stream.Size, 10), strconv.FormatUint(stream.StorageSize, 10)}})
 	},
 }
 
@@ -372,6 +766,9 @@ func init() {
 
 	backupDownloadRecoveryPointCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
 	backupDownloadRecoveryPointCmd.PersistentFlags().StringVar(&backupDownloadOutFile, "outfile", "", "Output backup download to file")
+	backupDownloadRecoveryPointCmd.PersistentFlags().StringVar(&backupDownloadFormat, "format", backupapi.ArchiveZip, "Format of archive: zip or tar.gz")
+	backupDownloadRecoveryPointCmd.PersistentFlags().StringVar(&backupStorageVaultID, "storage-vault-id", "", "The ID of storage vault to download the index and chunks of recovery point from")
+	_ = backupDownloadRecoveryPointCmd.MarkPersistentFlagRequired("storage-vault-id")
 	_ = backupDownloadRecoveryPointCmd.MarkPersistentFlagRequired("recovery-point-id")
 	backupCmd.AddCommand(backupListRecoveryPointCmd)
 	backupCmd.AddCommand(backupDownloadRecoveryPointCmd)
@@ -380,9 +777,57 @@ func init() {
 	_ = backupRunCmd.MarkPersistentFlagRequired("backup-id")
 	backupRunCmd.PersistentFlags().StringVar(&backupName, "backup-name", "", "The Name of recovery point backup")
 	_ = backupRunCmd.MarkPersistentFlagRequired("backup-name")
+	backupRunCmd.PersistentFlags().StringArrayVar(&backupOnlyPaths, "only", nil, "Back up only this path of backup directory, can be repeated")
+	backupRunCmd.PersistentFlags().BoolVar(&backupDryRun, "dry-run", false, "Scan and detect changes only, report what the backup would upload without creating a recovery point")
+	backupRunCmd.PersistentFlags().StringVar(&backupPolicyID, "policy-id", "", "The ID of policy whose limits and chunking the dry run applies")
+	backupRunCmd.PersistentFlags().StringVar(&backupStorageVaultID, "storage-vault-id", "", "The ID of storage vault to download the index of latest recovery point from for the dry run when it is not cached")
+	backupRunCmd.PersistentFlags().BoolVar(&followAction, "follow", false, "Follow the progress of the backup per phase until it ends, from progress_socket of agent")
 	backupCmd.AddCommand(backupRunCmd)
 
 	backupCmd.AddCommand(backupSyncCmd)
+
+	backupStreamCmd.PersistentFlags().StringVar(&backupID, "backup-id", "", "The ID of backup directory")
+	_ = backupStreamCmd.MarkPersistentFlagRequired("backup-id")
+	backupStreamCmd.PersistentFlags().StringVar(&streamName, "name", "", "The file name of stream in backup directory, and name of recovery point")
+	_ = backupStreamCmd.MarkPersistentFlagRequired("name")
+	backupStreamCmd.PersistentFlags().StringVar(&streamCommand, "cmd", "", "The command whose output is backed up, run by the shell, stdin is backed up when it is not given")
+	backupStreamCmd.PersistentFlags().StringVar(&backupPolicyID, "policy-id", "", "The ID of policy whose chunking the backup applies")
+	backupCmd.AddCommand(backupStreamCmd)
+
+	backupExportManifestCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
+	_ = backupExportManifestCmd.MarkPersistentFlagRequired("recovery-point-id")
+	backupExportManifestCmd.PersistentFlags().StringVar(&manifestFormat, "format", manifest.FormatRestic, "Manifest format: "+strings.Join(manifest.Formats, ", "))
+	backupExportManifestCmd.PersistentFlags().StringVar(&manifestStorageVaultID, "storage-vault-id", "", "The ID of storage vault to download index from when it is not cached")
+	backupExportManifestCmd.PersistentFlags().StringVar(&manifestOutFile, "outfile", "", "Output manifest to file instead of stdout")
+	backupCmd.AddCommand(backupExportManifestCmd)
+
+	backupLsCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
+	_ = backupLsCmd.MarkPersistentFlagRequired("recovery-point-id")
+	backupLsCmd.PersistentFlags().StringVar(&lsPrefix, "prefix", "", "List only the files under this path, absolute or relative to the backup directory")
+	backupLsCmd.PersistentFlags().StringVar(&lsStorageVaultID, "storage-vault-id", "", "The ID of storage vault to download index from when it is not cached")
+	backupCmd.AddCommand(backupLsCmd)
+
+	backupInventoryCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
+	_ = backupInventoryCmd.MarkPersistentFlagRequired("recovery-point-id")
+	backupInventoryCmd.PersistentFlags().StringVar(&inventoryStorageVaultID, "storage-vault-id", "", "The ID of storage vault of recovery point")
+	_ = backupInventoryCmd.MarkPersistentFlagRequired("storage-vault-id")
+	backupInventoryCmd.PersistentFlags().StringVar(&manifestOutFile, "outfile", "", "Output inventory to file instead of stdout")
+	backupCmd.AddCommand(backupInventoryCmd)
+
+	backupInstanceMetadataCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
+	_ = backupInstanceMetadataCmd.MarkPersistentFlagRequired("recovery-point-id")
+	backupInstanceMetadataCmd.PersistentFlags().StringVar(&inventoryStorageVaultID, "storage-vault-id", "", "The ID of storage vault of recovery point")
+	_ = backupInstanceMetadataCmd.MarkPersistentFlagRequired("storage-vault-id")
+	backupInstanceMetadataCmd.PersistentFlags().StringVar(&manifestOutFile, "outfile", "", "Output instance metadata to file instead of stdout")
+	backupCmd.AddCommand(backupInstanceMetadataCmd)
+}
+
+// shellCommand returns the command running line by the shell of the platform.
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
 }
 
 func restoreSessionKey(key, machineID, createdAt, recoveryPointID string) string {
@@ -0,0 +1,328 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/broker/mqtt"
+)
+
+const (
+	configFileMode = 0600
+	configDirMode  = 0700
+
+	systemdUnitPath = "/etc/systemd/system/bizfly-backup.service"
+	systemdUnit     = `[Unit]
+Description=Backup Agent Service
+[Service]
+Restart=always
+Type=simple
+ExecStart=%s agent --config=%s
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+var (
+	initInstallService bool
+	initSkipCheck      bool
+)
+
+// initField is a config key prompted by init command.
+type initField struct {
+	key      string
+	prompt   string
+	required bool
+	// number fields are written as integers
+	number bool
+	check  func(string) error
+}
+
+var initFields = []initField{
+	{key: "machine_id", prompt: "Machine ID", required: true},
+	{key: "access_key", prompt: "Access key", required: true},
+	{key: "secret_key", prompt: "Secret key", required: true},
+	{key: "api_url", prompt: "API URL", required: true, check: checkURL},
+	{key: "port", prompt: "Listening port", number: true, check: checkPort},
+	{key: "cache_dir", prompt: "Cache directory (empty for default)"},
+	{key: "limit_upload", prompt: "Upload limit in KiB/s (0 for unlimited)", number: true, check: checkNonNegative},
+	{key: "limit_download", prompt: "Download limit in KiB/s (0 for unlimited)", number: true, check: checkNonNegative},
+}
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up agent config interactively.",
+	Long: `Set up agent config interactively: machine credentials, API URL, listening port, cache directory and
+bandwidth limits. The machine is registered and the broker connection is checked before the config is written.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := initConfigPath()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		current := make(map[string]string, len(initFields))
+		for _, f := range initFields {
+			current[f.key] = viper.GetString(f.key)
+		}
+		if current["port"] == "" || current["port"] == "0" {
+			current["port"] = strconv.Itoa(defaultPort)
+		}
+
+		out := cmd.OutOrStdout()
+		_, _ = fmt.Fprintf(out, "Setting up %s, press Enter to keep the value in brackets.\n", path)
+		values, err := promptConfig(bufio.NewReader(cmd.InOrStdin()), out, current)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		if !initSkipCheck {
+			_, _ = fmt.Fprintln(out, "Checking connectivity...")
+			if err := checkConnectivity(values); err != nil {
+				logger.Error("connectivity check failed: " + err.Error())
+				os.Exit(1)
+			}
+		}
+
+		if err := writeConfig(path, values); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		_, _ = fmt.Fprintf(out, "Wrote %s\n", path)
+
+		if initInstallService {
+			if err := installService(path); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			_, _ = fmt.Fprintln(out, "Installed and started bizfly-backup service")
+		}
+	},
+}
+
+// initConfigPath returns the config file written by init command: --config, the config file in use,
+// or $HOME/.bizfly-backup.yaml.
+func initConfigPath() (string, error) {
+	if cfgFile != "" {
+		return filepath.Abs(cfgFile)
+	}
+	if used := viper.ConfigFileUsed(); used != "" {
+		return used, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".bizfly-backup.yaml"), nil
+}
+
+// promptConfig prompts initFields on out and reads them from in. An empty answer keeps the current value,
+// invalid answers are prompted again.
+func promptConfig(in *bufio.Reader, out io.Writer, current map[string]string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(initFields))
+	for _, f := range initFields {
+		for {
+			if current[f.key] != "" {
+				_, _ = fmt.Fprintf(out, "%s [%s]: ", f.prompt, current[f.key])
+			} else {
+				_, _ = fmt.Fprintf(out, "%s: ", f.prompt)
+			}
+			line, err := in.ReadString('\n')
+			if err != nil && (err != io.EOF || line == "") {
+				return nil, fmt.Errorf("read %s: %w", f.key, err)
+			}
+			answer := strings.TrimSpace(line)
+			if answer == "" {
+				answer = current[f.key]
+			}
+			if answer == "" && f.required {
+				_, _ = fmt.Fprintf(out, "%s is required\n", f.prompt)
+				continue
+			}
+			if answer != "" && f.check != nil {
+				if err := f.check(answer); err != nil {
+					_, _ = fmt.Fprintf(out, "invalid %s: %v\n", f.prompt, err)
+					continue
+				}
+			}
+			if answer == "" {
+				break
+			}
+			if f.number {
+				n, _ := strconv.Atoi(answer)
+				values[f.key] = n
+			} else {
+				values[f.key] = answer
+			}
+			break
+		}
+	}
+	return values, nil
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http(s) URL")
+	}
+	return nil
+}
+
+func checkPort(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return errors.New("must be a number between 1 and 65535")
+	}
+	return nil
+}
+
+func checkNonNegative(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return errors.New("must be a non-negative number")
+	}
+	return nil
+}
+
+// checkConnectivity registers the machine to backup server and connects to the broker it returns.
+func checkConnectivity(values map[string]interface{}) error {
+	str := func(key string) string {
+		s, _ := values[key].(string)
+		return s
+	}
+	client, err := backupapi.NewClient(
+		backupapi.WithAccessKey(str("access_key")),
+		backupapi.WithSecretKey(str("secret_key")),
+		backupapi.WithServerURL(str("api_url")),
+		backupapi.WithID(str("machine_id")),
+	)
+	if err != nil {
+		return err
+	}
+	umr, err := client.UpdateMachine()
+	if err != nil {
+		return fmt.Errorf("register machine: %w", err)
+	}
+	b, err := mqtt.NewBroker(
+		mqtt.WithURL(umr.BrokerUrl),
+		// a client ID of its own, so a running agent is not disconnected
+		mqtt.WithClientID(str("machine_id")+"-init"),
+		mqtt.WithUsername(str("access_key")),
+		mqtt.WithPassword(str("secret_key")),
+		mqtt.WithLogger(logger),
+	)
+	if err != nil {
+		return err
+	}
+	if err := b.Connect(); err != nil {
+		return fmt.Errorf("connect to broker: %w", err)
+	}
+	return b.Disconnect()
+}
+
+// writeConfig sets values in config file path, keeping its other keys. The file is only readable by its owner
+// as it holds the secret key.
+func writeConfig(path string, values map[string]interface{}) error {
+	config := make(map[string]interface{})
+	buf, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(buf, &config); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	for k, v := range values {
+		config[k] = v
+	}
+	buf, err = yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, configDirMode); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".bizfly-backup-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(configFileMode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// installService installs and starts the systemd service running agent with config file path.
+func installService(path string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("installing service is not supported on %s, use the script in scripts directory", runtime.GOOS)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(systemdUnitPath, []byte(fmt.Sprintf(systemdUnit, exe, path)), 0644); err != nil {
+		return err
+	}
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "bizfly-backup"}, {"restart", "bizfly-backup"}} {
+		if out, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+func init() {
+	initCmd.PersistentFlags().BoolVar(&initInstallService, "install-service", false, "install and start the systemd service running agent (Linux only)")
+	initCmd.PersistentFlags().BoolVar(&initSkipCheck, "skip-check", false, "write config without checking connectivity")
+	rootCmd.AddCommand(initCmd)
+}
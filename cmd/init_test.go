@@ -0,0 +1,101 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPromptConfig(t *testing.T) {
+	input := strings.Join([]string{
+		"",          // machine_id is required
+		"machine",   // machine_id
+		"",          // keep access_key
+		"secret",    // secret_key
+		"not a url", // invalid api_url
+		"https://backup.example.com",
+		"70000", // invalid port
+		"",      // keep port
+		"",      // no cache_dir
+		"-1",    // invalid limit_upload
+		"100",
+		"0",
+	}, "\n")
+	current := map[string]string{"access_key": "access", "port": "29999"}
+	var out bytes.Buffer
+
+	values, err := promptConfig(bufio.NewReader(strings.NewReader(input)), &out, current)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"machine_id":     "machine",
+		"access_key":     "access",
+		"secret_key":     "secret",
+		"api_url":        "https://backup.example.com",
+		"port":           29999,
+		"limit_upload":   100,
+		"limit_download": 0,
+	}, values)
+	assert.Contains(t, out.String(), "Machine ID is required")
+	assert.Contains(t, out.String(), "Access key [access]: ")
+	assert.Contains(t, out.String(), "invalid API URL")
+	assert.Contains(t, out.String(), "invalid Listening port")
+	assert.Contains(t, out.String(), "invalid Upload limit")
+}
+
+func TestPromptConfigEOF(t *testing.T) {
+	_, err := promptConfig(bufio.NewReader(strings.NewReader("machine\n")), ioutil.Discard, map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestWriteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bizfly-backup-init")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "conf", "agent.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+	require.NoError(t, ioutil.WriteFile(path, []byte("machine_id: old\nnum_goroutine: 3\n"), 0644))
+
+	require.NoError(t, writeConfig(path, map[string]interface{}{"machine_id": "new", "port": 29998}))
+
+	buf, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	config := make(map[string]interface{})
+	require.NoError(t, yaml.Unmarshal(buf, &config))
+	assert.Equal(t, map[string]interface{}{"machine_id": "new", "num_goroutine": 3, "port": 29998}, config)
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(configFileMode), fi.Mode().Perm())
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
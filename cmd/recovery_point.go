@@ -0,0 +1,160 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
+)
+
+var (
+	rpDeleteID             string
+	rpDeletePurgeStorage   bool
+	rpDeleteStorageVaultID string
+	rpDeleteYes            bool
+)
+
+// recoveryPointCmd represents the recovery-point command
+var recoveryPointCmd = &cobra.Command{
+	Use:   "recovery-point",
+	Short: "Perform recovery point tasks.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cmd.Help(); err != nil {
+			logger.Error(err.Error())
+		}
+	},
+}
+
+// recoveryPointDeleteCmd represents the recovery-point delete command
+var recoveryPointDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a recovery point.",
+	Long: `Delete a recovery point. With --purge-storage, its index, chunk list and file list are removed from the storage
+vault and the cache directory too. Chunks may be shared with other recovery points, they are left to the backup server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if rpDeleteID == "" {
+			logger.Error("--id is required")
+			os.Exit(1)
+		}
+		if rpDeletePurgeStorage && rpDeleteStorageVaultID == "" {
+			logger.Error("--storage-vault-id is required with --purge-storage")
+			os.Exit(1)
+		}
+
+		question := fmt.Sprintf("Delete recovery point %s", rpDeleteID)
+		if rpDeletePurgeStorage {
+			question += fmt.Sprintf(" and its objects in storage vault %s", rpDeleteStorageVaultID)
+		}
+		if !rpDeleteYes && !confirm(bufio.NewReader(cmd.InOrStdin()), cmd.OutOrStdout(), question) {
+			fmt.Fprintln(cmd.OutOrStdout(), "Aborted")
+			return
+		}
+
+		// make url
+		query := url.Values{}
+		if rpDeletePurgeStorage {
+			query.Set("purge_storage", "true")
+			query.Set("storage_vault_id", rpDeleteStorageVaultID)
+		}
+		urlRequest := strings.Join([]string{addr, "recovery-points", rpDeleteID}, "/")
+		if len(query) > 0 {
+			urlRequest += "?" + query.Encode()
+		}
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// make request
+		req, err := http.NewRequest(http.MethodDelete, urlRequest, nil)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			fmt.Fprintln(os.Stderr, string(b))
+			os.Exit(1)
+		}
+
+		var result server.DeleteRecoveryPointResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		printDeleteRecoveryPoint(cmd.OutOrStdout(), result)
+	},
+}
+
+// confirm asks question on out and reports whether the answer read from in is yes.
+func confirm(in *bufio.Reader, out io.Writer, question string) bool {
+	_, _ = fmt.Fprintf(out, "%s? [y/N]: ", question)
+	answer, _ := in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func printDeleteRecoveryPoint(out io.Writer, result server.DeleteRecoveryPointResponse) {
+	_, _ = fmt.Fprintf(out, "Deleted recovery point %s\n", result.RecoveryPointID)
+	for _, key := range result.RemovedObjects {
+		_, _ = fmt.Fprintf(out, "Removed object %s\n", key)
+	}
+	if result.RemovedCache != "" {
+		_, _ = fmt.Fprintf(out, "Removed cache directory %s\n", result.RemovedCache)
+	}
+}
+
+func init() {
+	recoveryPointDeleteCmd.PersistentFlags().StringVar(&rpDeleteID, "id", "", "The ID of recovery point")
+	recoveryPointDeleteCmd.PersistentFlags().BoolVar(&rpDeletePurgeStorage, "purge-storage", false, "Also remove objects of recovery point from storage vault and cache")
+	recoveryPointDeleteCmd.PersistentFlags().StringVar(&rpDeleteStorageVaultID, "storage-vault-id", "", "The ID of storage vault of recovery point, required with --purge-storage")
+	recoveryPointDeleteCmd.PersistentFlags().BoolVarP(&rpDeleteYes, "yes", "y", false, "Do not ask for confirmation")
+	recoveryPointCmd.AddCommand(recoveryPointDeleteCmd)
+	rootCmd.AddCommand(recoveryPointCmd)
+}
@@ -0,0 +1,62 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
+)
+
+func Test_confirm(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   bool
+	}{
+		{answer: "y\n", want: true},
+		{answer: "YES\n", want: true},
+		{answer: "n\n", want: false},
+		{answer: "\n", want: false},
+		{answer: "", want: false},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		got := confirm(bufio.NewReader(strings.NewReader(tt.answer)), &out, "Delete recovery point rp")
+		assert.Equal(t, tt.want, got, tt.answer)
+		assert.Equal(t, "Delete recovery point rp? [y/N]: ", out.String())
+	}
+}
+
+func Test_printDeleteRecoveryPoint(t *testing.T) {
+	var out bytes.Buffer
+	printDeleteRecoveryPoint(&out, server.DeleteRecoveryPointResponse{
+		RecoveryPointID: "rp",
+		RemovedObjects:  []string{"mc/rp/index.json", "mc/rp/chunk.json"},
+		RemovedCache:    "/cache/mc/rp",
+	})
+	assert.Equal(t, `Deleted recovery point rp
+Removed object mc/rp/index.json
+Removed object mc/rp/chunk.json
+Removed cache directory /cache/mc/rp
+`, out.String())
+}
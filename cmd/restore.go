@@ -21,68 +21,320 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/bizflycloud/bizflyctl/formatter"
 	"github.com/spf13/cobra"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
 )
 
 const postContentType = "application/octet-stream"
 
-var restoreDir string
+var (
+	restoreDir    string
+	priorityPaths []string
+	includePaths  []string
+	excludePaths  []string
+	forceRestore  bool
+	// sourceMachineID is the machine which backed up the recovery point, when it is restored from another machine.
+	sourceMachineID string
+	// conflictPolicy is what restore does with existing files which differ from the backed up ones.
+	conflictPolicy string
+	// noSymlinkEscape restores no symlink pointing outside dest-directory and writes no file through one.
+	noSymlinkEscape bool
+	// restoreChown, restoreUIDMap and restoreGIDMap change the owners of restored files.
+	restoreChown  string
+	restoreUIDMap []string
+	restoreGIDMap []string
+	// skipXattrs restores no extended attributes, ACLs and security descriptors.
+	skipXattrs bool
+	// restoreLimitDownload is the KiB per second the restore downloads at instead of limit_download of agent.
+	restoreLimitDownload int
+	// atomicRestore restores into a staging directory swapped with dest-directory once the restore succeeds.
+	atomicRestore bool
+	// restoreFilePath is the file restored by restore file.
+	restoreFilePath string
+	// s3Target is the bucket files are restored to instead of dest-directory.
+	s3Target backupapi.RestoreTarget
+	// restoreDryRun previews the restore without downloading, restoreStorageVaultID is where its index is
+	// downloaded from when it is not cached.
+	restoreDryRun         bool
+	restoreStorageVaultID string
+	// restoreLatest restores the latest completed recovery point of backup directory backup-id.
+	restoreLatest bool
+
+	restorePreviewHeaders      = []string{"Path", "Action", "Size"}
+	restorePreviewTotalHeaders = []string{"Action", "Files", "Bytes"}
+)
 
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
 	Short: "Restore a backup.",
 	Run: func(cmd *cobra.Command, args []string) {
-		// make url
-		urlRequest := strings.Join([]string{addr, "recovery-points", recoveryPointID, "restore"}, "/")
-
-		// create client
-		httpc := http.Client{
-			Transport: &http.Transport{
-				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
-				},
-			},
+		if restoreLatest == (recoveryPointID != "") || (restoreLatest && backupID == "") {
+			logger.Error("Give either --recovery-point-id, or --backup-id with --latest")
+			os.Exit(1)
 		}
-
 		// init body
 		if restoreDir == "" {
-			restoreDir = strings.Join([]string{"bizfly-restore", recoveryPointID}, "/")
+			id := recoveryPointID
+			if restoreLatest {
+				id = backupID
+			}
+			restoreDir = strings.Join([]string{"bizfly-restore", id}, "/")
 		}
 		var body struct {
-			Path string `json:"path"`
+			SourceMachineID string                   `json:"source_machine_id,omitempty"`
+			Path            string                   `json:"path"`
+			PriorityPaths   []string                 `json:"priority_paths,omitempty"`
+			IncludePaths    []string                 `json:"include_paths,omitempty"`
+			ExcludePaths    []string                 `json:"exclude_paths,omitempty"`
+			Force           bool                     `json:"force,omitempty"`
+			Target          *backupapi.RestoreTarget `json:"target,omitempty"`
+			Conflict        string                   `json:"conflict,omitempty"`
+			NoSymlinkEscape bool                     `json:"no_symlink_escape,omitempty"`
+			backupapi.OwnerMap
+			SkipXattrs    bool `json:"skip_xattrs,omitempty"`
+			Atomic        bool `json:"atomic,omitempty"`
+			LimitDownload *int `json:"limit_download,omitempty"`
 		}
+		body.SourceMachineID = sourceMachineID
 		body.Path = restoreDir
-		buf, _ := json.Marshal(body)
-		// make request
-		req, err := http.NewRequest(http.MethodPost, urlRequest, bytes.NewBuffer(buf))
-		if err != nil {
-			logger.Error(err.Error())
-			os.Exit(1)
+		body.PriorityPaths = priorityPaths
+		body.IncludePaths = includePaths
+		body.ExcludePaths = excludePaths
+		body.Force = forceRestore
+		body.Conflict = conflictPolicy
+		body.NoSymlinkEscape = noSymlinkEscape
+		body.OwnerMap = ownerMap()
+		body.SkipXattrs = skipXattrs
+		body.Atomic = atomicRestore
+		body.LimitDownload = limitDownload(cmd)
+		if s3Target.Bucket != "" {
+			// the credential of bucket is not given by flags, so it does not show in process list
+			s3Target.Type = backupapi.RestoreTargetS3
+			s3Target.Credential.AwsAccessKeyId = os.Getenv("AWS_ACCESS_KEY_ID")
+			s3Target.Credential.AwsSecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			s3Target.Credential.Token = os.Getenv("AWS_SESSION_TOKEN")
+			if err := s3Target.Check(); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+			body.Target = &s3Target
+		}
+		if restoreDryRun {
+			previewRestore(body)
+			return
 		}
+		postRestore("restore", body)
+	},
+}
 
-		// call request
-		resp, err := httpc.Do(req)
-		if err != nil {
-			logger.Error(err.Error())
+// restoreFileCmd restores a single file of a recovery point.
+var restoreFileCmd = &cobra.Command{
+	Use:   "file",
+	Short: "Restore a single file of a backup, only its chunks are downloaded.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if recoveryPointID == "" {
+			logger.Error("--recovery-point-id is required")
 			os.Exit(1)
 		}
+		if restoreDir == "" {
+			restoreDir = strings.Join([]string{"bizfly-restore", recoveryPointID}, "/")
+		}
+		postRestore("restore-file", struct {
+			SourceMachineID string `json:"source_machine_id,omitempty"`
+			Path            string `json:"path"`
+			DestDirectory   string `json:"dest_directory"`
+			Force           bool   `json:"force,omitempty"`
+			Conflict        string `json:"conflict,omitempty"`
+			NoSymlinkEscape bool   `json:"no_symlink_escape,omitempty"`
+			backupapi.OwnerMap
+			SkipXattrs    bool `json:"skip_xattrs,omitempty"`
+			LimitDownload *int `json:"limit_download,omitempty"`
+		}{
+			SourceMachineID: sourceMachineID,
+			Path:            restoreFilePath,
+			DestDirectory:   restoreDir,
+			Force:           forceRestore,
+			Conflict:        conflictPolicy,
+			NoSymlinkEscape: noSymlinkEscape,
+			OwnerMap:        ownerMap(),
+			SkipXattrs:      skipXattrs,
+			LimitDownload:   limitDownload(cmd),
+		})
+	},
+}
 
-		defer resp.Body.Close()
+// limitDownload returns the download limit of the restore flags, nil when the restore keeps limit_download of agent.
+func limitDownload(cmd *cobra.Command) *int {
+	if !cmd.Flags().Changed("limit-download") {
+		return nil
+	}
+	if restoreLimitDownload < 0 {
+		logger.Error("--limit-download must not be negative")
+		os.Exit(1)
+	}
+	return &restoreLimitDownload
+}
+
+// ownerMap returns the owner mapping of the restore flags.
+func ownerMap() backupapi.OwnerMap {
+	uids, err := backupapi.ParseIDMap(restoreUIDMap)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	gids, err := backupapi.ParseIDMap(restoreGIDMap)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	return backupapi.OwnerMap{Chown: restoreChown, UIDs: uids, GIDs: gids}
+}
+
+// restoreClient returns a client of agent.
+func restoreClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+			},
+		},
+	}
+}
+
+// restoreURL returns the URL of endpoint of agent restoring recoveryPointID, or the latest completed recovery point
+// of backupID with --latest.
+func restoreURL(endpoint string) string {
+	if restoreLatest {
+		return strings.Join([]string{addr, "backups", backupID, "restore-latest"}, "/")
+	}
+	return strings.Join([]string{addr, "recovery-points", recoveryPointID, endpoint}, "/")
+}
+
+// previewRestore posts body to the restore endpoint of recoveryPointID on agent as a dry run, and prints the files
+// the restore would create, overwrite, rename or skip with their totals.
+func previewRestore(body interface{}) {
+	query := url.Values{"dry_run": {"true"}}
+	if restoreStorageVaultID != "" {
+		query.Set("storage_vault_id", restoreStorageVaultID)
+	}
+	urlRequest := restoreURL("restore") + "?" + query.Encode()
+	buf, _ := json.Marshal(body)
+	resp, err := restoreClient().Post(urlRequest, postContentType, bytes.NewBuffer(buf))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
 		_, _ = io.Copy(os.Stderr, resp.Body)
-	},
+		os.Exit(1)
+	}
+	var preview backupapi.RestorePreview
+	if err := json.NewDecoder(resp.Body).Decode(&preview); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	var data [][]string
+	for _, f := range preview.Files {
+		data = append(data, []string{f.Path, f.Action, strconv.FormatUint(f.Size, 10)})
+	}
+	formatter.Output(restorePreviewHeaders, data)
+	var totals [][]string
+	for _, t := range []struct {
+		action string
+		total  backupapi.PreviewTotal
+	}{
+		{backupapi.PreviewCreate, preview.Create},
+		{backupapi.PreviewOverwrite, preview.Overwrite},
+		{backupapi.PreviewRename, preview.Rename},
+		{backupapi.PreviewSkip, preview.Skip},
+	} {
+		totals = append(totals, []string{t.action, strconv.FormatInt(t.total.Files, 10), strconv.FormatUint(t.total.Bytes, 10)})
+	}
+	formatter.Output(restorePreviewTotalHeaders, totals)
+}
+
+// postRestore posts body to endpoint of recoveryPointID on agent, and follows the restore when requested.
+func postRestore(endpoint string, body interface{}) {
+	// make url
+	urlRequest := restoreURL(endpoint)
+
+	// create client
+	httpc := restoreClient()
+	buf, _ := json.Marshal(body)
+
+	var feed io.ReadCloser
+	if followAction {
+		feed = dialProgressFeed()
+	}
+	// make request
+	req, err := http.NewRequest(http.MethodPost, urlRequest, bytes.NewBuffer(buf))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// call request
+	resp, err := httpc.Do(req)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	defer resp.Body.Close()
+
+	if id := resp.Header.Get("X-Recovery-Point-Id"); id != "" {
+		// the latest recovery point restored
+		recoveryPointID = id
+		fmt.Fprintf(os.Stderr, "Restore recovery point %s\n", id)
+	}
+	_, _ = io.Copy(os.Stderr, resp.Body)
+	if feed != nil {
+		followProgressFeed(feed, resp, server.FollowRestore, recoveryPointID)
+	}
 }
 
 func init() {
 	restoreCmd.PersistentFlags().StringVar(&restoreDir, "dest-directory", "", "The destination directory to restore")
+	restoreCmd.PersistentFlags().StringSliceVar(&priorityPaths, "priority", nil, "Files to restore first, in the given order (repeatable or comma-separated)")
+	restoreCmd.PersistentFlags().StringSliceVar(&includePaths, "include", nil, "Restore only the files and directories under these paths (repeatable or comma-separated)")
+	restoreCmd.PersistentFlags().StringSliceVar(&excludePaths, "exclude", nil, "Do not restore the files and directories under these paths (repeatable or comma-separated)")
+	restoreCmd.PersistentFlags().StringVar(&conflictPolicy, "conflict", "", "What to do with existing files which differ from the backup: overwrite (default), skip or rename (to <name>.bak)")
+	restoreCmd.PersistentFlags().BoolVar(&noSymlinkEscape, "no-symlink-escape", false, "Do not restore symlinks pointing outside the destination directory, nor write files through one")
+	restoreCmd.PersistentFlags().StringVar(&restoreChown, "chown", "", "Restore all files owned by user:group of this machine, names or ids, either part may be omitted")
+	restoreCmd.PersistentFlags().StringSliceVar(&restoreUIDMap, "uid-map", nil, "Restore files owned by a user id as owned by another, as <old uid>:<new uid> (repeatable or comma-separated)")
+	restoreCmd.PersistentFlags().StringSliceVar(&restoreGIDMap, "gid-map", nil, "Restore files owned by a group id as owned by another, as <old gid>:<new gid> (repeatable or comma-separated)")
+	restoreCmd.PersistentFlags().BoolVar(&skipXattrs, "skip-xattrs", false, "Do not restore extended attributes, ACLs and security descriptors, e.g. when the destination filesystem does not support them")
+	restoreCmd.PersistentFlags().IntVar(&restoreLimitDownload, "limit-download", 0, "Download at this KiB/s instead of limit_download of agent for this restore, 0 for unlimited")
+	restoreCmd.PersistentFlags().BoolVar(&forceRestore, "force", false, "Restore even when the recovery point shows tampering or corruption")
+	restoreCmd.PersistentFlags().StringVar(&s3Target.Bucket, "s3-bucket", "", "Restore files as objects to the bucket instead of the destination directory, with credential of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	restoreCmd.PersistentFlags().StringVar(&s3Target.Prefix, "s3-prefix", "", "The key prefix of objects restored to the bucket")
+	restoreCmd.PersistentFlags().StringVar(&s3Target.Credential.AwsLocation, "s3-endpoint", "", "The endpoint URL of the bucket")
+	restoreCmd.PersistentFlags().StringVar(&s3Target.Credential.Region, "s3-region", "", "The region of the bucket")
 	restoreCmd.PersistentFlags().StringVar(&recoveryPointID, "recovery-point-id", "", "The ID of recovery point")
-	_ = restoreCmd.MarkPersistentFlagRequired("recovery-point-id")
+	restoreCmd.PersistentFlags().StringVar(&sourceMachineID, "source-machine-id", "", "The ID of machine which made the recovery point, to restore it from another machine to this one")
+	restoreCmd.Flags().BoolVar(&restoreDryRun, "dry-run", false, "Report which files the restore would create, overwrite, rename or skip without downloading them")
+	restoreCmd.Flags().BoolVar(&atomicRestore, "atomic", false, "Restore into <dest-directory>.restore-tmp and swap it with dest-directory once the restore succeeds, so a failed restore leaves dest-directory untouched")
+	restoreCmd.Flags().StringVar(&restoreStorageVaultID, "storage-vault-id", "", "The ID of storage vault to download the index of recovery point from for the dry run when it is not cached")
+	restoreCmd.PersistentFlags().BoolVar(&followAction, "follow", false, "Follow the progress of the restore per phase until it ends, from progress_socket of agent")
+	restoreCmd.Flags().BoolVar(&restoreLatest, "latest", false, "Restore the latest completed recovery point of --backup-id instead of --recovery-point-id")
+	restoreCmd.Flags().StringVar(&backupID, "backup-id", "", "The ID of backup directory whose latest completed recovery point --latest restores")
+	restoreFileCmd.Flags().StringVar(&restoreFilePath, "path", "", "The path of the file in the recovery point, absolute or relative to the backup directory")
+	_ = restoreFileCmd.MarkFlagRequired("path")
+	restoreCmd.AddCommand(restoreFileCmd)
 	rootCmd.AddCommand(restoreCmd)
 }
@@ -19,6 +19,7 @@ package cmd
 
 import (
 	"context"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -59,6 +60,12 @@ var upgradeCmd = &cobra.Command{
 		}
 
 		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			logger.Error("upgrade failed: " + string(b))
+			os.Exit(1)
+		}
 	},
 }
 
@@ -0,0 +1,132 @@
+// This file is part of bizfly-backup
+//
+// Copyright (C) 2020  BizFly Cloud
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bizflycloud/bizflyctl/formatter"
+	"github.com/spf13/cobra"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/server"
+)
+
+var (
+	vaultTestHeaders = []string{"Operation", "Latency (ms)", "Status", "Permission Denied"}
+	storageVaultID   string
+)
+
+// vaultCmd represents the vault command
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "Perform storage vault tasks.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cmd.Help(); err != nil {
+			logger.Error(err.Error())
+		}
+	},
+}
+
+// vaultTestCmd represents the vault test command
+var vaultTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Test connectivity and credential of a storage vault.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// make url
+		urlRequest := strings.Join([]string{addr, "storage-vaults", "test"}, "/")
+
+		// create client
+		httpc := http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial(tcpProtocol, strings.TrimPrefix(addr, httpPrefix))
+				},
+			},
+		}
+
+		// init body
+		var body struct {
+			StorageVaultID string `json:"storage_vault_id"`
+		}
+		body.StorageVaultID = storageVaultID
+		buf, _ := json.Marshal(body)
+
+		// make request
+		req, err := http.NewRequest(http.MethodPost, urlRequest, bytes.NewBuffer(buf))
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		// call request
+		resp, err := httpc.Do(req)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			fmt.Fprintln(os.Stderr, string(b))
+			os.Exit(1)
+		}
+
+		var result server.TestStorageVaultResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		var data [][]string
+		for _, step := range result.Steps {
+			status := "OK"
+			if step.Error != "" {
+				status = step.Error
+			}
+			row := []string{step.Operation, strconv.FormatInt(step.LatencyMs, 10), status, strconv.FormatBool(step.PermissionDenied)}
+			data = append(data, row)
+		}
+		formatter.Output(vaultTestHeaders, data)
+		if result.Scope != nil && result.Scope.OverPrivileged {
+			fmt.Fprintf(os.Stderr, "WARNING: credential is over-privileged, it allows %s outside key prefix %q\n",
+				strings.Join(result.Scope.Allowed, ", "), result.Scope.Prefix)
+		}
+
+		if !result.OK {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	vaultTestCmd.PersistentFlags().StringVar(&storageVaultID, "storage-vault-id", "", "The ID of storage vault")
+	_ = vaultTestCmd.MarkPersistentFlagRequired("storage-vault-id")
+	vaultCmd.AddCommand(vaultTestCmd)
+	rootCmd.AddCommand(vaultCmd)
+}
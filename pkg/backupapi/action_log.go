@@ -0,0 +1,54 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	actionLogPath = "/agent/action-logs"
+)
+
+// ActionLog is the slice of agent log written while a failed action ran.
+type ActionLog struct {
+	ActionID string    `json:"action_id"`
+	Reason   string    `json:"reason"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Log      string    `json:"log"`
+}
+
+// ActionLogResponse is the server response when upload action log.
+type ActionLogResponse struct {
+	ID string `json:"id"`
+}
+
+// UploadActionLog uploads log of a failed action, returns its reference ID.
+func (c *Client) UploadActionLog(log *ActionLog) (*ActionLogResponse, error) {
+	req, err := c.NewRequest(http.MethodPost, actionLogPath, log)
+	if err != nil {
+		c.logger.Error("c.NewRequest() ", zap.Error(err))
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		c.logger.Error("c.Do() ", zap.Error(err))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		return nil, err
+	}
+
+	var alr ActionLogResponse
+	if err := json.NewDecoder(resp.Body).Decode(&alr); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		return nil, err
+	}
+	return &alr, nil
+}
@@ -0,0 +1,30 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_UploadActionLog(t *testing.T) {
+	setUp()
+	defer tearDown()
+
+	mux.HandleFunc(path.Join("/api/v1", actionLogPath), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		var log ActionLog
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&log))
+		assert.Equal(t, "action-1", log.ActionID)
+		assert.Equal(t, "line 1\n", log.Log)
+		require.NoError(t, json.NewEncoder(w).Encode(&ActionLogResponse{ID: "log-ref"}))
+	})
+
+	alr, err := client.UploadActionLog(&ActionLog{ActionID: "action-1", Log: "line 1\n"})
+	require.NoError(t, err)
+	assert.Equal(t, "log-ref", alr.ID)
+}
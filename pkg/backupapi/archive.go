@@ -0,0 +1,128 @@
+package backupapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// Tiers of recovery points.
+const (
+	TierHot     = "HOT"
+	TierArchive = "ARCHIVE"
+)
+
+// DefaultArchiveStorageClass is the storage class recovery points are archived to when their policy does not set one.
+const DefaultArchiveStorageClass = "GLACIER"
+
+// RecoveryPointTier is the tier manifest of a recovery point, stored next to its index. Recovery points without it
+// are in hot tier.
+type RecoveryPointTier struct {
+	Tier         string    `json:"tier"`
+	StorageClass string    `json:"storage_class,omitempty"`
+	ArchivedAt   time.Time `json:"archived_at,omitempty"`
+	// Chunks is the number of chunks moved to StorageClass, chunks shared with hot recovery points are not moved.
+	Chunks int `json:"chunks"`
+}
+
+// TierKey returns the key of tier manifest of recovery point in storage vault.
+func TierKey(machineID, recoveryPointID string) string {
+	return filepath.Join(machineID, recoveryPointID, "tier.json")
+}
+
+// GetRecoveryPointTier reads tier manifest of recovery point from storage vault.
+func (c *Client) GetRecoveryPointTier(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string) (*RecoveryPointTier, error) {
+	key := TierKey(machineID, recoveryPointID)
+	isExist, _, err := storageVault.HeadObject(ctx, key)
+	if storage_vault.ErrorCode(err) == "NotFound" || (err == nil && !isExist) {
+		return &RecoveryPointTier{Tier: TierHot}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf, err := storageVault.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var tier RecoveryPointTier
+	if err := json.Unmarshal(buf, &tier); err != nil {
+		return nil, fmt.Errorf("invalid tier of recovery point %s: %w", recoveryPointID, err)
+	}
+	return &tier, nil
+}
+
+// IndexChunks returns the keys of chunks and packfiles holding content of items of index, the chunks listed by chunk
+// maps are read from storageVault. The pages of chunk maps are not returned, they stay in hot tier so the chunks they
+// list can be retrieved.
+func (c *Client) IndexChunks(ctx context.Context, storageVault storage_vault.StorageVault, index *cache.Index) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	for _, item := range index.Items {
+		err := c.eachChunk(ctx, storageVault, nil, item, func(chunk *cache.ChunkInfo) error {
+			keys[chunk.ObjectKey()] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, stream := range item.Streams {
+			for _, chunk := range stream.Content {
+				keys[chunk.ObjectKey()] = true
+			}
+		}
+	}
+	return keys, nil
+}
+
+// ArchiveRecoveryPoint moves chunks of index to storage class, except the ones in keep which are used by hot
+// recovery points, then records the tier of recovery point. Its index and file lists stay in hot tier.
+func (c *Client) ArchiveRecoveryPoint(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string, index *cache.Index, keep map[string]bool, class string) (*RecoveryPointTier, error) {
+	tier := &RecoveryPointTier{Tier: TierArchive, StorageClass: strings.ToUpper(class)}
+	keys, err := c.IndexChunks(ctx, storageVault, index)
+	if err != nil {
+		return nil, err
+	}
+	for key := range keys {
+		if keep[key] {
+			continue
+		}
+		if err := storage_vault.Archive(ctx, storageVault, key, class); err != nil {
+			return nil, fmt.Errorf("archive chunk %s: %w", key, err)
+		}
+		tier.Chunks++
+	}
+	tier.ArchivedAt = time.Now().UTC()
+	buf, err := json.Marshal(tier)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.PutObject(ctx, storageVault, TierKey(machineID, recoveryPointID), buf); err != nil {
+		return nil, err
+	}
+	return tier, nil
+}
+
+// RetrieveChunks requests a readable copy of archived chunks of index for days, it returns the number of chunks
+// which can not be read yet.
+func (c *Client) RetrieveChunks(ctx context.Context, storageVault storage_vault.StorageVault, index *cache.Index, days int) (int, error) {
+	keys, err := c.IndexChunks(ctx, storageVault, index)
+	if err != nil {
+		return 0, err
+	}
+	var pending int
+	for key := range keys {
+		ready, err := storage_vault.Retrieve(ctx, storageVault, key, days)
+		if err != nil {
+			return 0, fmt.Errorf("retrieve chunk %s: %w", key, err)
+		}
+		if !ready {
+			pending++
+		}
+	}
+	return pending, nil
+}
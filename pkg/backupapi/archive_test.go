@@ -0,0 +1,84 @@
+package backupapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// tieredVault is a memoryVault whose archived objects are readable once retrieved twice.
+type tieredVault struct {
+	*memoryVault
+	classes   map[string]string
+	retrieved map[string]int
+}
+
+func (v *tieredVault) Archive(ctx context.Context, key, class string) error {
+	v.classes[key] = class
+	return nil
+}
+
+func (v *tieredVault) Retrieve(ctx context.Context, key string, days int) (bool, error) {
+	if v.classes[key] == "" {
+		return true, nil
+	}
+	v.retrieved[key]++
+	return v.retrieved[key] > 1, nil
+}
+
+func TestArchiveRecoveryPoint(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := &tieredVault{memoryVault: newMemoryVault(), classes: map[string]string{}, retrieved: map[string]int{}}
+
+	tier, err := c.GetRecoveryPointTier(context.Background(), vault, "mc", "rp")
+	require.NoError(t, err)
+	assert.Equal(t, TierHot, tier.Tier)
+
+	index := &cache.Index{Items: map[string]*cache.Node{
+		"/data/a": {Type: "file", Content: []*cache.ChunkInfo{{Etag: "shared"}, {Etag: "old"}}},
+		"/data/b": {Type: "file", Streams: []*cache.Stream{{Name: "ads", Content: []*cache.ChunkInfo{{Etag: "stream"}}}}},
+		"/data":   {Type: "dir"},
+	}}
+	keys, err := c.IndexChunks(context.Background(), vault, index)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"shared": true, "old": true, "stream": true}, keys)
+
+	tier, err = c.ArchiveRecoveryPoint(context.Background(), vault, "mc", "rp", index, map[string]bool{"shared": true}, "deep_archive")
+	require.NoError(t, err)
+	assert.Equal(t, TierArchive, tier.Tier)
+	assert.Equal(t, "DEEP_ARCHIVE", tier.StorageClass)
+	assert.Equal(t, 2, tier.Chunks)
+	assert.Equal(t, map[string]string{"old": "deep_archive", "stream": "deep_archive"}, vault.classes)
+
+	got, err := c.GetRecoveryPointTier(context.Background(), vault, "mc", "rp")
+	require.NoError(t, err)
+	assert.Equal(t, TierArchive, got.Tier)
+	assert.Equal(t, 2, got.Chunks)
+
+	pending, err := c.RetrieveChunks(context.Background(), vault, index, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pending)
+	pending, err = c.RetrieveChunks(context.Background(), vault, index, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pending)
+}
+
+func TestArchiveRecoveryPointUnsupported(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	index := &cache.Index{Items: map[string]*cache.Node{"/data/a": {Type: "file", Content: []*cache.ChunkInfo{{Etag: "old"}}}}}
+
+	_, err = c.ArchiveRecoveryPoint(context.Background(), newMemoryVault(), "mc", "rp", index, nil, DefaultArchiveStorageClass)
+	assert.ErrorIs(t, err, storage_vault.ErrTieringUnsupported)
+
+	pending, err := c.RetrieveChunks(context.Background(), newMemoryVault(), index, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pending)
+}
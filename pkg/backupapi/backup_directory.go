@@ -18,6 +18,29 @@ type BackupDirectory struct {
 	Size        int    `json:"size"`
 	MachineID   string `json:"machine_id"`
 	TenantID    string `json:"tenant_id"`
+	// Paths are the root paths of a backup directory with several of them, backed up into each recovery point
+	// together. Path is the first of them.
+	Paths []string `json:"paths,omitempty"`
+	// ExcludePatterns and IncludePatterns select the paths which are backed up, see package filter.
+	ExcludePatterns []string `json:"exclude_patterns,omitempty"`
+	IncludePatterns []string `json:"include_patterns,omitempty"`
+	// OneFileSystem skips mount points inside Path, e.g. NFS shares or bind mounts.
+	OneFileSystem bool `json:"one_file_system,omitempty"`
+	// ExcludeCaches skips directories tagged by CACHEDIR.TAG, ExcludeNoDump skips items with the nodump flag.
+	ExcludeCaches bool `json:"exclude_caches,omitempty"`
+	ExcludeNoDump bool `json:"exclude_nodump,omitempty"`
+}
+
+// Roots returns the root paths of backup directory, Path when it has only one.
+func (bd *BackupDirectory) Roots() []string {
+	return roots(bd.Path, bd.Paths)
+}
+
+func roots(path string, paths []string) []string {
+	if len(paths) == 0 {
+		return []string{path}
+	}
+	return paths
 }
 
 // ListBackupDirectory ...
@@ -30,6 +53,8 @@ type CreateManualBackupRequest struct {
 	Action      string `json:"action"`
 	StorageType string `json:"storage_type"`
 	Name        string `json:"name"`
+	// OnlyPaths limits the backup to the given paths of the backup directory.
+	OnlyPaths []string `json:"only_paths,omitempty"`
 }
 
 // UpdateState ...
@@ -56,8 +81,12 @@ func (c *Client) listBackupDirectoryPath() string {
 	return "/agent/backup-directories"
 }
 
-// GetBackupDirectory retrieves a backup directory by given id.
+// GetBackupDirectory retrieves a backup directory by given id, the response is memoized for a short time.
 func (c *Client) GetBackupDirectory(id string) (*BackupDirectory, error) {
+	if v, ok := c.cache.get(cacheKeyBackupDirectory + id); ok {
+		bd := v.(BackupDirectory)
+		return &bd, nil
+	}
 	req, err := c.NewRequest(http.MethodGet, c.backupDirectoryPath(id), nil)
 	if err != nil {
 		c.logger.Error("err ", zap.Error(err))
@@ -79,6 +108,7 @@ func (c *Client) GetBackupDirectory(id string) (*BackupDirectory, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&bd); err != nil {
 		return nil, err
 	}
+	c.cache.set(cacheKeyBackupDirectory+id, bd)
 	return &bd, err
 }
 
@@ -111,3 +111,10 @@ func TestClient_ListBackupDirectory(t *testing.T) {
 	require.NoError(t, err)
 	assert.NotEmpty(t, lbd.Directories[0].Path)
 }
+
+func TestBackupDirectory_Roots(t *testing.T) {
+	bd := BackupDirectory{Path: "/etc"}
+	assert.Equal(t, []string{"/etc"}, bd.Roots())
+	bd.Paths = []string{"/etc", "/var/www"}
+	assert.Equal(t, []string{"/etc", "/var/www"}, bd.Roots())
+}
@@ -0,0 +1,108 @@
+package backupapi
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/restic/chunker"
+)
+
+// chunkerPolynomial is the polynomial of DefaultChunking, chunks of the same content must have the same boundaries
+// across backups.
+const chunkerPolynomial = 0x3dea92648f6e83
+
+// chunkBufferPools pool the buffers of chunk data by capacity, from MinChunkSize doubled up to MaxChunkSize, so a
+// chunk does not hold a buffer more than twice its length while it is uploaded. Buffers are shared by the files
+// chunked concurrently and reused across files, which spares the garbage collector most of the content of a backup.
+var chunkBufferPools = func() []*sync.Pool {
+	var pools []*sync.Pool
+	for size := MinChunkSize; size <= MaxChunkSize; size *= 2 {
+		size := size
+		pools = append(pools, &sync.Pool{New: func() interface{} {
+			buf := make([]byte, size)
+			return &buf
+		}})
+	}
+	return pools
+}()
+
+// chunkBufferPool returns the pool of the smallest buffers of capacity at least n with their capacity, or nil when n
+// is larger than MaxChunkSize.
+func chunkBufferPool(n int) (*sync.Pool, int) {
+	size := MinChunkSize
+	for _, pool := range chunkBufferPools {
+		if n <= size {
+			return pool, size
+		}
+		size *= 2
+	}
+	return nil, 0
+}
+
+// chunkBufferSize returns the capacity of the buffer of n bytes getChunkBuffer returns.
+func chunkBufferSize(n int) int {
+	if _, size := chunkBufferPool(n); size > 0 {
+		return size
+	}
+	return n
+}
+
+// getChunkBuffer returns a buffer of length n from the pools, it is given back by putChunkBuffer once its data is
+// not used anymore.
+func getChunkBuffer(n int) []byte {
+	pool, _ := chunkBufferPool(n)
+	if pool == nil {
+		return make([]byte, n)
+	}
+	return (*pool.Get().(*[]byte))[:n]
+}
+
+// putChunkBuffer gives buf back to the pools, it must not be used after. Buffers not from the pools are dropped.
+func putChunkBuffer(buf []byte) {
+	pool, size := chunkBufferPool(cap(buf))
+	if pool == nil || size != cap(buf) {
+		return
+	}
+	buf = buf[:size]
+	pool.Put(&buf)
+}
+
+// getChunkData returns a buffer of length n for chunk data once its capacity fits in the memory budget of c, it is
+// given back by putChunkData.
+func (c *Client) getChunkData(ctx context.Context, n int) ([]byte, error) {
+	if err := c.memory.Acquire(ctx, int64(chunkBufferSize(n))); err != nil {
+		return nil, err
+	}
+	return getChunkBuffer(n), nil
+}
+
+// putChunkData gives buf back to the pools and its capacity back to the memory budget of c.
+func (c *Client) putChunkData(buf []byte) {
+	c.memory.Release(int64(cap(buf)))
+	putChunkBuffer(buf)
+}
+
+// chunkers are the chunkers of files, with their read buffer.
+var chunkers = sync.Pool{New: func() interface{} {
+	return chunker.New(nil, chunkerPolynomial)
+}}
+
+// newChunker returns a chunker of r by chunking c, content defined chunkers are from chunkers and given back by
+// putChunker.
+func newChunker(r io.Reader, c Chunking) fileChunker {
+	if c.Fixed {
+		return &fixedChunker{r: r, size: c.AverageSize}
+	}
+	chk := chunkers.Get().(*chunker.Chunker)
+	chk.ResetWithBoundaries(r, c.Polynomial, c.MinSize, c.MaxSize)
+	chk.SetAverageBits(c.averageBits())
+	return chk
+}
+
+func putChunker(chk fileChunker) {
+	if chk, ok := chk.(*chunker.Chunker); ok {
+		chk.Reset(nil, chunkerPolynomial)
+		chunkers.Put(chk)
+	}
+}
@@ -0,0 +1,59 @@
+package backupapi
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/restic/chunker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkBuffer(t *testing.T) {
+	tests := []struct {
+		n       int
+		wantCap int
+	}{
+		{n: 10, wantCap: MinChunkSize},
+		{n: MinChunkSize, wantCap: MinChunkSize},
+		{n: MinChunkSize + 1, wantCap: 2 * MinChunkSize},
+		{n: 3 << 20, wantCap: 4 << 20},
+		{n: MaxChunkSize, wantCap: MaxChunkSize},
+		{n: MaxChunkSize + 1, wantCap: MaxChunkSize + 1},
+	}
+	for _, tt := range tests {
+		buf := getChunkBuffer(tt.n)
+		assert.Len(t, buf, tt.n)
+		assert.Equal(t, tt.wantCap, cap(buf))
+		putChunkBuffer(buf)
+	}
+
+	// buffers of other capacities are not pooled
+	putChunkBuffer(make([]byte, 10))
+	buf := getChunkBuffer(10)
+	assert.Equal(t, MinChunkSize, cap(buf))
+}
+
+func TestChunkerReuse(t *testing.T) {
+	data := make([]byte, 20<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	boundaries := func() []uint {
+		chk := newChunker(bytes.NewReader(data), DefaultChunking)
+		defer putChunker(chk)
+		var lengths []uint
+		buf := getChunkBuffer(chunker.MaxSize)
+		defer putChunkBuffer(buf)
+		for {
+			chunk, err := chk.Next(buf)
+			if err != nil {
+				break
+			}
+			lengths = append(lengths, chunk.Length)
+		}
+		return lengths
+	}
+	first := boundaries()
+	require.NotEmpty(t, first)
+	assert.Equal(t, first, boundaries(), "a reused chunker finds the same boundaries")
+}
@@ -0,0 +1,136 @@
+package backupapi
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// defaultChunkCacheMb is the MiB of chunk content kept in memory by restores when restore_chunk_cache_mb is not set.
+const defaultChunkCacheMb = 64
+
+// chunkCache keeps the content of the chunks downloaded most recently by restores, by their etag, so chunks shared by
+// several files of a restore are downloaded once. Etags are hashes of chunk content, so restores from any storage
+// vault share the cache. It holds chunks while at least one restore runs, and up to maxBytes of content.
+type chunkCache struct {
+	mu       sync.Mutex
+	users    int
+	maxBytes int
+	bytes    int
+	lru      *list.List
+	entries  map[string]*list.Element
+}
+
+type chunkCacheEntry struct {
+	etag string
+	data []byte
+}
+
+func newChunkCache() *chunkCache {
+	return &chunkCache{lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// start enables the cache with maxBytes for a restore, the returned function stops it. The chunks are dropped when
+// the last restore stops.
+func (cc *chunkCache) start(maxBytes int) func() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.users++
+	if cc.users == 1 {
+		cc.maxBytes = maxBytes
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			cc.users--
+			if cc.users == 0 {
+				cc.lru.Init()
+				cc.entries = make(map[string]*list.Element)
+				cc.bytes = 0
+			}
+		})
+	}
+}
+
+// get returns the content of chunk etag, nil when it is not cached.
+func (cc *chunkCache) get(etag string) []byte {
+	if cc == nil {
+		return nil
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	e, ok := cc.entries[etag]
+	if !ok {
+		return nil
+	}
+	cc.lru.MoveToFront(e)
+	return e.Value.(*chunkCacheEntry).data
+}
+
+// add keeps the content of chunk etag while a restore runs, the chunks used least recently are evicted to stay
+// within maxBytes.
+func (cc *chunkCache) add(etag string, data []byte) {
+	if cc == nil {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.users == 0 || len(data) > cc.maxBytes {
+		return
+	}
+	if _, ok := cc.entries[etag]; ok {
+		return
+	}
+	cc.entries[etag] = cc.lru.PushFront(&chunkCacheEntry{etag: etag, data: data})
+	cc.bytes += len(data)
+	for cc.bytes > cc.maxBytes {
+		e := cc.lru.Back()
+		entry := cc.lru.Remove(e).(*chunkCacheEntry)
+		delete(cc.entries, entry.etag)
+		cc.bytes -= len(entry.data)
+	}
+}
+
+// CacheChunks keeps the chunks downloaded by restores in memory until the returned function is called, up to
+// restore_chunk_cache_mb MiB, 64 by default, 0 disables it. A restore calls it for its duration.
+func (c *Client) CacheChunks() func() {
+	mb := defaultChunkCacheMb
+	if viper.IsSet("restore_chunk_cache_mb") {
+		mb = viper.GetInt("restore_chunk_cache_mb")
+	}
+	if mb <= 0 || c.chunks == nil {
+		return func() {}
+	}
+	return c.chunks.start(mb << 20)
+}
+
+// getCachedChunk is GetChunk reusing the content of chunks cached by CacheChunks, it reports whether chunk was
+// cached. The content returned is shared, it must not be modified.
+func (c *Client) getCachedChunk(ctx context.Context, storageVault storage_vault.StorageVault, chunk *cache.ChunkInfo, restoreKey *AuthRestore) ([]byte, bool, error) {
+	if data := c.chunks.get(chunk.Etag); data != nil {
+		return data, true, nil
+	}
+	data, err := c.GetChunk(ctx, storageVault, chunk, restoreKey)
+	if err != nil {
+		return nil, false, err
+	}
+	c.chunks.add(chunk.Etag, data)
+	return data, false, nil
+}
+
+// chunkStat is the progress of restoring chunk, its object is not downloaded when it was cached.
+func chunkStat(chunk *cache.ChunkInfo, cached bool) progress.Stat {
+	s := progress.Stat{Bytes: uint64(chunk.Length)}
+	if !cached {
+		s.Storage = uint64(chunk.ObjectLength())
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+package backupapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestChunkCacheEviction(t *testing.T) {
+	cc := newChunkCache()
+	cc.add("a", []byte("aaaa"))
+	assert.Nil(t, cc.get("a"), "no restore runs")
+
+	stop := cc.start(8)
+	cc.add("a", []byte("aaaa"))
+	cc.add("b", []byte("bbbb"))
+	assert.Equal(t, []byte("aaaa"), cc.get("a"))
+	cc.add("c", []byte("cccc"))
+	assert.Nil(t, cc.get("b"), "least recently used")
+	assert.NotNil(t, cc.get("a"))
+	assert.NotNil(t, cc.get("c"))
+	cc.add("d", []byte("larger than cache"))
+	assert.Nil(t, cc.get("d"))
+
+	stopOther := cc.start(8)
+	stop()
+	stop()
+	assert.NotNil(t, cc.get("a"), "a restore still runs")
+	stopOther()
+	assert.Nil(t, cc.get("a"))
+	assert.Zero(t, cc.bytes)
+}
+
+func TestGetCachedChunk(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+	data := []byte("chunk shared by files")
+	chunk := &cache.ChunkInfo{Etag: chunkKey(data), Length: uint(len(data))}
+	require.NoError(t, vault.PutObject(context.Background(), chunk.Etag, data))
+
+	stop := c.CacheChunks()
+	got, cached, err := c.getCachedChunk(context.Background(), vault, chunk, nil)
+	require.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, data, got)
+
+	require.NoError(t, vault.DeleteObject(context.Background(), chunk.Etag))
+	got, cached, err = c.getCachedChunk(context.Background(), vault, chunk, nil)
+	require.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, data, got)
+	stop()
+
+	viper.Set("restore_chunk_cache_mb", 0)
+	defer viper.Set("restore_chunk_cache_mb", nil)
+	require.NoError(t, vault.PutObject(context.Background(), chunk.Etag, data))
+	stop = c.CacheChunks()
+	defer stop()
+	_, _, err = c.getCachedChunk(context.Background(), vault, chunk, nil)
+	require.NoError(t, err)
+	assert.Nil(t, c.chunks.get(chunk.Etag), "cache is disabled")
+}
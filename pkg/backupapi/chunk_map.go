@@ -0,0 +1,80 @@
+package backupapi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// chunkMapPageSize is the number of chunks of a page of chunk map, cache.ChunkMapPageSize except in tests.
+var chunkMapPageSize = cache.ChunkMapPageSize
+
+// pageChunks moves the chunks of the content of itemInfo to a new page of its chunk map once they are
+// chunkMapPageSize, or when last is set and the file already has a chunk map, so the chunk list of a large file is
+// never held in memory as a whole. Chunks are only complete once they are stored, so it waits for the chunk jobs of
+// wg, then stores the page like a chunk. It returns the bytes stored.
+func (c *Client) pageChunks(ctx context.Context, wg *sync.WaitGroup, chErr *error, itemInfo *cache.Node, last bool,
+	storageVault storage_vault.StorageVault, packer *Packer, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+	if len(itemInfo.Content) == 0 || (last && len(itemInfo.ChunkMap) == 0) || (!last && len(itemInfo.Content) < chunkMapPageSize) {
+		return 0, nil
+	}
+	wg.Wait()
+	if *chErr != nil {
+		return 0, *chErr
+	}
+	data, err := cache.EncodeChunkMapPage(itemInfo.Content)
+	if err != nil {
+		return 0, err
+	}
+	page := &cache.ChunkInfo{Start: itemInfo.Content[0].Start, Length: uint(len(data))}
+	object, err := c.prepareChunk(data, page)
+	if err != nil {
+		return 0, err
+	}
+	size, err := c.backupChunk(ctx, data, object, page, storageVault, packer, pipe, rpID, bdID)
+	if err != nil {
+		return 0, err
+	}
+	itemInfo.ChunkMap = append(itemInfo.ChunkMap, page)
+	itemInfo.Chunks += uint64(len(itemInfo.Content))
+	if last {
+		itemInfo.Content = nil
+	} else {
+		itemInfo.Content = itemInfo.Content[:0]
+	}
+	return size, nil
+}
+
+// eachChunk calls fn with the chunks of the content of file item in order, it stops at the first error. The pages of
+// the chunk map of a large file are downloaded from storageVault one at a time, so only a page of its chunk list is
+// held in memory.
+func (c *Client) eachChunk(ctx context.Context, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, item *cache.Node,
+	fn func(*cache.ChunkInfo) error) error {
+	for _, info := range item.Content {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	for _, page := range item.ChunkMap {
+		data, err := c.GetChunk(ctx, storageVault, page, restoreKey)
+		if err != nil {
+			return err
+		}
+		if err := cache.DecodeChunkMapPage(data, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reuseChunkMap counts a reference of recovery point rpID to the pages of the chunk map of item and to the objects of
+// their chunks, the pages are read from storageVault one at a time.
+func (c *Client) reuseChunkMap(ctx context.Context, storageVault storage_vault.StorageVault, item *cache.Node, pipe chan<- *cache.Chunk, rpID, bdID string) error {
+	reuseContent(item.ChunkMap, pipe, rpID, bdID)
+	return c.eachChunk(ctx, storageVault, nil, item, func(info *cache.ChunkInfo) error {
+		reuseContent([]*cache.ChunkInfo{info}, pipe, rpID, bdID)
+		return nil
+	})
+}
@@ -0,0 +1,148 @@
+package backupapi
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/restic/chunker"
+	"github.com/spf13/viper"
+)
+
+const (
+	// MinChunkSize and MaxChunkSize bound the chunk sizes of Chunking.
+	MinChunkSize = 64 << 10
+	MaxChunkSize = 64 << 20
+)
+
+// Chunking is the parameters of content defined chunking of files: chunks are cut where the Rabin fingerprint of
+// their last bytes by Polynomial has its lowest bits zero, so they are AverageSize long on average, and never shorter
+// than MinSize nor longer than MaxSize except the last chunk of a file. Files chunked by other parameters do not share
+// chunks, changing them only deduplicates files already backed up when they are unchanged.
+type Chunking struct {
+	MinSize     uint
+	AverageSize uint
+	MaxSize     uint
+	Polynomial  chunker.Pol
+	// Fixed cuts chunks of AverageSize at fixed offsets instead, which spares the fingerprint of every byte for files
+	// changed in place like VM images and database files, whose content does not shift.
+	Fixed bool
+}
+
+// Chunk modes of chunk_mode config and policy.
+const (
+	ChunkModeContent = "content"
+	ChunkModeFixed   = "fixed"
+)
+
+// fileChunker cuts the content of a file into chunks, the data of chunks returned by Next is in buf.
+type fileChunker interface {
+	Next(buf []byte) (chunker.Chunk, error)
+}
+
+// fixedChunker cuts chunks of size bytes from r.
+type fixedChunker struct {
+	r     io.Reader
+	size  uint
+	start uint
+}
+
+// Next returns the next chunk of r, or io.EOF once r is read entirely. buf must hold size bytes.
+func (f *fixedChunker) Next(buf []byte) (chunker.Chunk, error) {
+	n, err := io.ReadFull(f.r, buf[:f.size])
+	switch {
+	case n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF):
+		return chunker.Chunk{}, io.EOF
+	case err != nil && err != io.ErrUnexpectedEOF:
+		return chunker.Chunk{}, err
+	}
+	chunk := chunker.Chunk{Start: f.start, Length: uint(n), Data: buf[:n]}
+	f.start += uint(n)
+	return chunk, nil
+}
+
+// DefaultChunking is the chunking of files when neither agent config nor policy set it.
+var DefaultChunking = Chunking{
+	MinSize:     chunker.MinSize,
+	AverageSize: 1 << 20,
+	MaxSize:     chunker.MaxSize,
+	Polynomial:  chunkerPolynomial,
+}
+
+// Check returns an error when chunk sizes are out of bounds or misordered, AverageSize is not a power of two or
+// Polynomial is not irreducible.
+func (c Chunking) Check() error {
+	if c.MinSize < MinChunkSize || c.MaxSize > MaxChunkSize {
+		return fmt.Errorf("chunk sizes must be between %d KiB and %d KiB", MinChunkSize>>10, MaxChunkSize>>10)
+	}
+	if c.MinSize > c.AverageSize || c.AverageSize > c.MaxSize {
+		return fmt.Errorf("chunk sizes must be min %d KiB <= average %d KiB <= max %d KiB", c.MinSize>>10, c.AverageSize>>10, c.MaxSize>>10)
+	}
+	if bits.OnesCount(c.AverageSize) != 1 {
+		return fmt.Errorf("average chunk size %d KiB is not a power of two", c.AverageSize>>10)
+	}
+	if c.Polynomial.Deg() != 53 || !c.Polynomial.Irreducible() {
+		return fmt.Errorf("chunk polynomial %#x is not an irreducible polynomial of degree 53", uint64(c.Polynomial))
+	}
+	return nil
+}
+
+// averageBits returns the number of low bits of fingerprint which are zero at a chunk boundary.
+func (c Chunking) averageBits() int {
+	return bits.TrailingZeros(c.AverageSize)
+}
+
+// EstimateChunks returns the number of chunks a file of size bytes is cut into, on average for content defined chunking.
+func (c Chunking) EstimateChunks(size uint64) uint64 {
+	if size == 0 {
+		return 0
+	}
+	return (size + uint64(c.AverageSize) - 1) / uint64(c.AverageSize)
+}
+
+// chunking returns c, or DefaultChunking when c is nil.
+func (c *Chunking) chunking() Chunking {
+	if c == nil {
+		return DefaultChunking
+	}
+	return *c
+}
+
+// With returns c with the mode, the sizes in KiB and the hex polynomial which are set, the others are left unchanged.
+func (c Chunking) With(mode string, minKb, averageKb, maxKb int, polynomial string) (Chunking, error) {
+	switch mode {
+	case "":
+	case ChunkModeContent:
+		c.Fixed = false
+	case ChunkModeFixed:
+		c.Fixed = true
+	default:
+		return Chunking{}, fmt.Errorf("invalid chunk mode %q, must be %s or %s", mode, ChunkModeContent, ChunkModeFixed)
+	}
+	if minKb > 0 {
+		c.MinSize = uint(minKb) << 10
+	}
+	if averageKb > 0 {
+		c.AverageSize = uint(averageKb) << 10
+	}
+	if maxKb > 0 {
+		c.MaxSize = uint(maxKb) << 10
+	}
+	if polynomial != "" {
+		pol, err := strconv.ParseUint(strings.TrimPrefix(polynomial, "0x"), 16, 64)
+		if err != nil {
+			return Chunking{}, fmt.Errorf("invalid chunk polynomial %q", polynomial)
+		}
+		c.Polynomial = chunker.Pol(pol)
+	}
+	return c, c.Check()
+}
+
+// ConfigChunking returns the chunking of files set by chunk_mode, chunk_min_size, chunk_avg_size, chunk_max_size (KiB)
+// and chunk_polynomial of agent config, on top of DefaultChunking.
+func ConfigChunking() (Chunking, error) {
+	return DefaultChunking.With(viper.GetString("chunk_mode"), viper.GetInt("chunk_min_size"), viper.GetInt("chunk_avg_size"), viper.GetInt("chunk_max_size"),
+		viper.GetString("chunk_polynomial"))
+}
@@ -0,0 +1,101 @@
+package backupapi
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunking_With(t *testing.T) {
+	require.NoError(t, DefaultChunking.Check())
+
+	tests := []struct {
+		name                string
+		mode                string
+		minKb, avgKb, maxKb int
+		polynomial          string
+		want                Chunking
+		wantErr             bool
+	}{
+		{name: "default", want: DefaultChunking},
+		{
+			name:  "small chunks",
+			minKb: 64, avgKb: 128, maxKb: 1024,
+			want: Chunking{MinSize: 64 << 10, AverageSize: 128 << 10, MaxSize: 1 << 20, Polynomial: chunkerPolynomial},
+		},
+		{
+			name:  "large chunks",
+			minKb: 4096, avgKb: 16384, maxKb: 65536, polynomial: "0x3DA3358B4DC173",
+			want: Chunking{MinSize: 4 << 20, AverageSize: 16 << 20, MaxSize: 64 << 20, Polynomial: 0x3DA3358B4DC173},
+		},
+		{
+			name: "fixed",
+			mode: ChunkModeFixed, avgKb: 4096,
+			want: Chunking{MinSize: DefaultChunking.MinSize, AverageSize: 4 << 20, MaxSize: DefaultChunking.MaxSize, Polynomial: chunkerPolynomial, Fixed: true},
+		},
+		{name: "invalid mode", mode: "rolling", wantErr: true},
+		{name: "min below bound", minKb: 32, avgKb: 64, wantErr: true},
+		{name: "max above bound", maxKb: 128 << 10, wantErr: true},
+		{name: "average below min", minKb: 1024, avgKb: 512, wantErr: true},
+		{name: "average not power of two", avgKb: 1000, wantErr: true},
+		{name: "invalid polynomial", polynomial: "not hex", wantErr: true},
+		{name: "reducible polynomial", polynomial: "0x20000000000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DefaultChunking.With(tt.mode, tt.minKb, tt.avgKb, tt.maxKb, tt.polynomial)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestConfigChunking(t *testing.T) {
+	viper.Set("chunk_avg_size", 2048)
+	defer viper.Set("chunk_avg_size", 0)
+	c, err := ConfigChunking()
+	require.NoError(t, err)
+	assert.Equal(t, uint(2<<20), c.AverageSize)
+	assert.Equal(t, 21, c.averageBits())
+	assert.Equal(t, DefaultChunking.MinSize, c.MinSize)
+
+	viper.Set("chunk_mode", ChunkModeFixed)
+	defer viper.Set("chunk_mode", "")
+	c, err = ConfigChunking()
+	require.NoError(t, err)
+	assert.True(t, c.Fixed)
+
+	var nilChunking *Chunking
+	assert.Equal(t, DefaultChunking, nilChunking.chunking())
+}
+
+func TestFixedChunker(t *testing.T) {
+	data := make([]byte, 2*MinChunkSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	chk := newChunker(bytes.NewReader(data), Chunking{AverageSize: MinChunkSize, Fixed: true})
+	defer putChunker(chk)
+	buf := make([]byte, MinChunkSize)
+	var lengths []uint
+	var restored []byte
+	for {
+		chunk, err := chk.Next(buf)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.Equal(t, uint(len(restored)), chunk.Start)
+		lengths = append(lengths, chunk.Length)
+		restored = append(restored, chunk.Data...)
+	}
+	assert.Equal(t, []uint{MinChunkSize, MinChunkSize, 100}, lengths)
+	assert.Equal(t, data, restored)
+}
@@ -6,18 +6,23 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"runtime"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.uber.org/zap"
 
 	"github.com/cenkalti/backoff"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/proxy"
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
 )
 
 const (
@@ -35,8 +40,18 @@ type Client struct {
 	accessKey    string
 	secretKey    string
 	numGoroutine int
+	// labels and groups of machine let backup server target config updates to groups of machines.
+	labels map[string]string
+	groups []string
 
 	userAgent string
+	cache     *responseCache
+	// memory bounds the chunk data held in memory by backups of all directories.
+	memory *limiter.MemoryLimiter
+	// hashers bounds the chunks hashed and compressed at once by backups of all directories.
+	hashers chan struct{}
+	// chunks keeps chunk content downloaded by restores, see CacheChunks.
+	chunks *chunkCache
 
 	logger *zap.Logger
 }
@@ -47,6 +62,7 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	c := &Client{
 		client: &http.Client{
 			Transport: &http.Transport{
+				Proxy: proxy.Default(),
 				DialContext: (&net.Dialer{
 					Timeout:   30 * time.Second,
 					KeepAlive: 30 * time.Second,
@@ -59,6 +75,9 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 		},
 		ServerURL: serverUrl,
 		userAgent: userAgent,
+		cache:     newResponseCache(DefaultCacheTTL),
+		hashers:   make(chan struct{}, runtime.NumCPU()),
+		chunks:    newChunkCache(),
 	}
 
 	for _, opt := range opts {
@@ -136,6 +155,58 @@ func WithNumGoroutine(num int) ClientOption {
 	}
 }
 
+// WithLabels sets the labels (e.g. role=db, env=prod) of machine for Client.
+func WithLabels(labels map[string]string) ClientOption {
+	return func(c *Client) error {
+		c.labels = labels
+		return nil
+	}
+}
+
+// WithGroups sets the groups of machine for Client.
+func WithGroups(groups ...string) ClientOption {
+	return func(c *Client) error {
+		c.groups = groups
+		return nil
+	}
+}
+
+// WithCacheTTL sets how long responses of recovery point and config reads are memoized, zero disables it.
+func WithCacheTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.cache = newResponseCache(ttl)
+		return nil
+	}
+}
+
+// WithMemoryLimit bounds the chunk data held in memory by backups to mb MiB, zero is unlimited.
+func WithMemoryLimit(mb int) ClientOption {
+	return func(c *Client) error {
+		c.memory = limiter.NewMemoryLimiter(mb)
+		return nil
+	}
+}
+
+// WithHashWorkers sets the number of chunks hashed and compressed at once by backups of all directories, the number
+// of CPUs when n is not positive.
+func WithHashWorkers(n int) ClientOption {
+	return func(c *Client) error {
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		c.hashers = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithLogger sets the logger for Client.
+func WithLogger(logger *zap.Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
 // NewRequest create new http request
 func (c *Client) NewRequest(method, relPath string, body interface{}) (*http.Request, error) {
 	buf := new(bytes.Buffer)
@@ -161,6 +232,7 @@ func (c *Client) NewRequest(method, relPath string, body interface{}) (*http.Req
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	var err error
 	var resp *http.Response
+	var apiErr *APIError
 
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = maxRetry
@@ -179,6 +251,12 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 				return resp, nil
 			}
 			c.logger.Error("Request StatusCode ", zap.Int("StatusCode", resp.StatusCode))
+			apiErr = newAPIError(resp)
+			resp.Body.Close()
+			if !apiErr.Temporary() {
+				// sending again a request rejected by backup server does not help
+				return nil, apiErr
+			}
 		} else {
 			c.logger.Error("Request error ", zap.Error(err))
 		}
@@ -196,11 +274,9 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
-	if resp.StatusCode >= 400 {
-		var b bytes.Buffer
-		_, _ = io.Copy(&b, resp.Body)
-		c.logger.Error("Request error ", zap.Int("StatusCode", resp.StatusCode), zap.String("Body Response", b.String()))
-		return nil, fmt.Errorf(fmt.Sprintf("StatusCode %d Body response %s", resp.StatusCode, b.String()))
+	if apiErr != nil {
+		c.logger.Error("Request error ", zap.Error(apiErr))
+		return nil, apiErr
 	}
 
 	return resp, nil
@@ -215,7 +291,20 @@ func (c *Client) do(httpClient *http.Client, req *http.Request, contentType stri
 	req.Header.Add("Date", now)
 	req.Header.Add("Authorization", c.authorizationHeaderValue(req.Method, now))
 	req.Header.Add("Content-Type", contentType)
-	return httpClient.Do(req)
+
+	ctx, span := tracing.Start(req.Context(), "api "+req.Method+" "+req.URL.Path,
+		attribute.String("http.method", req.Method),
+		attribute.String("http.target", req.URL.Path))
+	tracing.Inject(ctx, req.Header)
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	tracing.End(span, err)
+	return resp, err
 }
 
 func (c *Client) authorizationHeaderValue(method, now string) string {
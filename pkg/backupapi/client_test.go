@@ -8,6 +8,7 @@ import (
 	"path"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -46,6 +47,9 @@ func TestClientOptions(t *testing.T) {
 		{"invalid server url", WithServerURL("https://:foo.bar/api/v1"), true, nil},
 		{"access key", WithAccessKey("access_key"), false, func(c *Client) bool { return c.accessKey == "access_key" }},
 		{"secret key", WithSecretKey("secret_key"), false, func(c *Client) bool { return c.secretKey == "secret_key" }},
+		{"cache ttl", WithCacheTTL(time.Minute), false, func(c *Client) bool { return c.cache.ttl == time.Minute }},
+		{"labels", WithLabels(map[string]string{"role": "db"}), false, func(c *Client) bool { return c.Labels()["role"] == "db" }},
+		{"groups", WithGroups("hn1", "db"), false, func(c *Client) bool { return len(c.Groups()) == 2 }},
 	}
 
 	for _, tc := range tests {
@@ -2,10 +2,16 @@ package backupapi
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
 )
 
 // BackupDirectoryConfig is the cron policies for given directory.
@@ -15,6 +21,13 @@ type BackupDirectoryConfig struct {
 	Path      string                        `json:"path" yaml:"path"`
 	Policies  []BackupDirectoryConfigPolicy `json:"policies" yaml:"policies"`
 	Activated bool                          `json:"activated" yaml:"activated"`
+	// Paths are the root paths of a backup directory with several of them, see BackupDirectory.
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+}
+
+// Roots returns the root paths of backup directory, Path when it has only one.
+func (bd *BackupDirectoryConfig) Roots() []string {
+	return roots(bd.Path, bd.Paths)
 }
 
 // BackupDirectoryConfigPolicy is the cron policy.
@@ -24,6 +37,111 @@ type BackupDirectoryConfigPolicy struct {
 	SchedulePattern string `json:"schedule_pattern" yaml:"schedule_pattern"`
 	Retentions      string `json:"retentions" yaml:"retentions"`
 	LimitUpload     int    `json:"limit_upload" yaml:"limit_upload"`
+	ChangeDetection string `json:"change_detection" yaml:"change_detection"`
+	// LimitDiskRead and LimitDiskIOPS limit the KiB and read operations per second of files read by backups of the
+	// policy, the agent limit_disk_read and limit_disk_iops config apply when both are 0.
+	LimitDiskRead int `json:"limit_disk_read,omitempty" yaml:"limit_disk_read,omitempty"`
+	LimitDiskIOPS int `json:"limit_disk_iops,omitempty" yaml:"limit_disk_iops,omitempty"`
+	// StorageClass is the S3 storage class of objects uploaded by the policy, given to the agent in storage vault
+	// of created recovery points.
+	StorageClass string `json:"storage_class,omitempty" yaml:"storage_class,omitempty"`
+	// MaxFileSize skips files larger than the given number of bytes.
+	MaxFileSize int64 `json:"max_file_size,omitempty" yaml:"max_file_size,omitempty"`
+	// MinMtime skips files modified before the given RFC 3339 time.
+	MinMtime string `json:"min_mtime,omitempty" yaml:"min_mtime,omitempty"`
+	// SkipOlderThan skips files modified more than the given duration (e.g. "72h" or "30d") before the backup.
+	SkipOlderThan string `json:"skip_older_than,omitempty" yaml:"skip_older_than,omitempty"`
+	// ArchiveAfterDays moves recovery points of the policy older than the given number of days to
+	// ArchiveStorageClass, they are not archived when it is 0.
+	ArchiveAfterDays int `json:"archive_after_days,omitempty" yaml:"archive_after_days,omitempty"`
+	// ArchiveStorageClass is the S3 storage class of archived recovery points, DefaultArchiveStorageClass when empty.
+	ArchiveStorageClass string `json:"archive_storage_class,omitempty" yaml:"archive_storage_class,omitempty"`
+	// ChunkMode is the chunking of files backed up by the policy, content defined or fixed. ChunkMinSize, ChunkAvgSize
+	// and ChunkMaxSize are the KiB sizes of their chunks, and ChunkPolynomial the hex polynomial cutting them, the agent
+	// chunk config applies to those which are unset.
+	ChunkMode       string `json:"chunk_mode,omitempty" yaml:"chunk_mode,omitempty"`
+	ChunkMinSize    int    `json:"chunk_min_size,omitempty" yaml:"chunk_min_size,omitempty"`
+	ChunkAvgSize    int    `json:"chunk_avg_size,omitempty" yaml:"chunk_avg_size,omitempty"`
+	ChunkMaxSize    int    `json:"chunk_max_size,omitempty" yaml:"chunk_max_size,omitempty"`
+	ChunkPolynomial string `json:"chunk_polynomial,omitempty" yaml:"chunk_polynomial,omitempty"`
+	// Priority is the share of files and chunks uploaded by backups of the policy while other backups run, a backup of
+	// priority 2 uploads twice as many as one of priority 1. It is 1 when 0.
+	Priority int `json:"priority,omitempty" yaml:"priority,omitempty"`
+	// MaxDuration stops backups of the policy running longer than the given duration (e.g. "4h"), see Deadline.
+	MaxDuration string `json:"max_duration,omitempty" yaml:"max_duration,omitempty"`
+	// BackupWindow is the daily range of local time of machine backups of the policy run in (e.g. "22:00-06:00"),
+	// see Deadline.
+	BackupWindow string `json:"backup_window,omitempty" yaml:"backup_window,omitempty"`
+}
+
+// ArchiveClass returns the storage class recovery points of the policy are archived to.
+func (p BackupDirectoryConfigPolicy) ArchiveClass() string {
+	if p.ArchiveStorageClass == "" {
+		return DefaultArchiveStorageClass
+	}
+	return p.ArchiveStorageClass
+}
+
+// Limits returns the size and age limits of files backed up by the policy at now.
+func (p BackupDirectoryConfigPolicy) Limits(now time.Time) (filter.Limits, error) {
+	limits := filter.Limits{MaxFileSize: p.MaxFileSize}
+	if p.MinMtime != "" {
+		t, err := time.Parse(time.RFC3339, p.MinMtime)
+		if err != nil {
+			return filter.Limits{}, fmt.Errorf("invalid min_mtime of policy %s: %w", p.ID, err)
+		}
+		limits.MinModTime = t
+	}
+	if p.SkipOlderThan != "" {
+		d, err := parseAge(p.SkipOlderThan)
+		if err != nil {
+			return filter.Limits{}, fmt.Errorf("invalid skip_older_than of policy %s: %w", p.ID, err)
+		}
+		if t := now.Add(-d); t.After(limits.MinModTime) {
+			limits.MinModTime = t
+		}
+	}
+	return limits, nil
+}
+
+// Chunking returns base with the chunk sizes and polynomial set by the policy.
+func (p BackupDirectoryConfigPolicy) Chunking(base Chunking) (Chunking, error) {
+	c, err := base.With(p.ChunkMode, p.ChunkMinSize, p.ChunkAvgSize, p.ChunkMaxSize, p.ChunkPolynomial)
+	if err != nil {
+		return Chunking{}, fmt.Errorf("invalid chunking of policy %s: %w", p.ID, err)
+	}
+	return c, nil
+}
+
+// parseAge parses a duration of time.ParseDuration, or a number of days with suffix "d".
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		err = fmt.Errorf("negative duration %q", s)
+	}
+	return d, err
+}
+
+// Policy returns the policy policyID of backup directory backupDirectoryID, or nil when it is not in the config.
+func (c *Config) Policy(backupDirectoryID, policyID string) *BackupDirectoryConfigPolicy {
+	for _, bd := range c.BackupDirectories {
+		if bd.ID != backupDirectoryID {
+			continue
+		}
+		for i := range bd.Policies {
+			if bd.Policies[i].ID == policyID {
+				return &bd.Policies[i]
+			}
+		}
+	}
+	return nil
 }
 
 type Config struct {
@@ -34,7 +152,12 @@ func (c *Client) configPath() string {
 	return "/agent/config"
 }
 
+// GetConfig gets backup directories and policies of machine, the response is memoized for a short time.
 func (c *Client) GetConfig(ctx context.Context) (*Config, error) {
+	if v, ok := c.cache.get(cacheKeyConfig); ok {
+		cfg := v.(Config)
+		return &cfg, nil
+	}
 	req, err := c.NewRequest(http.MethodGet, c.configPath(), nil)
 	if err != nil {
 		c.logger.Error("err ", zap.Error(err))
@@ -57,6 +180,7 @@ func (c *Client) GetConfig(ctx context.Context) (*Config, error) {
 		c.logger.Error("err ", zap.Error(err))
 		return nil, err
 	}
+	c.cache.set(cacheKeyConfig, cfg)
 
 	return &cfg, nil
 }
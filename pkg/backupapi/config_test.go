@@ -4,9 +4,13 @@ import (
 	"context"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
 )
 
 const configContent = `
@@ -49,3 +53,105 @@ func TestClient_GetConfig(t *testing.T) {
 		assert.Len(t, bd.Policies, 1)
 	}
 }
+
+func TestClient_GetConfigCached(t *testing.T) {
+	setUp()
+	defer tearDown()
+
+	var calls int
+	mux.HandleFunc("/api/v1/agent/config", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Add("Content-Type", "application/yaml")
+		_, _ = w.Write([]byte(configContent))
+	})
+
+	for i := 0; i < 3; i++ {
+		cfg, err := client.GetConfig(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, cfg.BackupDirectories, 2)
+	}
+	assert.Equal(t, 1, calls)
+
+	client.InvalidateCache()
+	_, err := client.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestBackupDirectoryConfigPolicy_Limits(t *testing.T) {
+	now := time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		policy  BackupDirectoryConfigPolicy
+		want    filter.Limits
+		wantErr bool
+	}{
+		{name: "no limits"},
+		{
+			name:   "max file size",
+			policy: BackupDirectoryConfigPolicy{MaxFileSize: 1 << 30},
+			want:   filter.Limits{MaxFileSize: 1 << 30},
+		},
+		{
+			name:   "min mtime",
+			policy: BackupDirectoryConfigPolicy{MinMtime: "2020-01-01T00:00:00Z"},
+			want:   filter.Limits{MinModTime: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:   "skip older than days",
+			policy: BackupDirectoryConfigPolicy{SkipOlderThan: "30d"},
+			want:   filter.Limits{MinModTime: now.Add(-30 * 24 * time.Hour)},
+		},
+		{
+			name:   "later of min mtime and skip older than",
+			policy: BackupDirectoryConfigPolicy{MinMtime: "2022-06-09T12:00:00Z", SkipOlderThan: "72h"},
+			want:   filter.Limits{MinModTime: time.Date(2022, 6, 9, 12, 0, 0, 0, time.UTC)},
+		},
+		{name: "invalid min mtime", policy: BackupDirectoryConfigPolicy{MinMtime: "yesterday"}, wantErr: true},
+		{name: "invalid skip older than", policy: BackupDirectoryConfigPolicy{SkipOlderThan: "-3d"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Limits(now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.MaxFileSize, got.MaxFileSize)
+			assert.True(t, tt.want.MinModTime.Equal(got.MinModTime), got.MinModTime)
+		})
+	}
+}
+
+func TestConfig_Policy(t *testing.T) {
+	var c Config
+	require.NoError(t, yaml.Unmarshal([]byte(configContent), &c))
+	policy := c.Policy("dbf88cc0-947d-493f-8cb4-44dfefaa0628", "c9312fff-457b-4e4b-8703-139c270a53ce")
+	require.NotNil(t, policy)
+	assert.Equal(t, "backup daily", policy.Name)
+	assert.Nil(t, c.Policy("6dd19ea8-a690-4fa0-8935-2b04f3c663ef", "c9312fff-457b-4e4b-8703-139c270a53ce"))
+}
+
+func TestBackupDirectoryConfigPolicy_Chunking(t *testing.T) {
+	var policy BackupDirectoryConfigPolicy
+	require.NoError(t, yaml.Unmarshal([]byte("id: p1\nchunk_mode: fixed\nchunk_avg_size: 256\nchunk_min_size: 128\n"), &policy))
+	c, err := policy.Chunking(DefaultChunking)
+	require.NoError(t, err)
+	assert.Equal(t, uint(128<<10), c.MinSize)
+	assert.Equal(t, uint(256<<10), c.AverageSize)
+	assert.Equal(t, DefaultChunking.MaxSize, c.MaxSize)
+	assert.True(t, c.Fixed)
+
+	policy.ChunkAvgSize = 300
+	_, err = policy.Chunking(DefaultChunking)
+	assert.Error(t, err)
+}
+
+func TestBackupDirectoryConfigPolicy_ArchiveClass(t *testing.T) {
+	var policy BackupDirectoryConfigPolicy
+	require.NoError(t, yaml.Unmarshal([]byte("archive_after_days: 30\narchive_storage_class: DEEP_ARCHIVE\n"), &policy))
+	assert.Equal(t, 30, policy.ArchiveAfterDays)
+	assert.Equal(t, "DEEP_ARCHIVE", policy.ArchiveClass())
+	assert.Equal(t, DefaultArchiveStorageClass, BackupDirectoryConfigPolicy{}.ArchiveClass())
+}
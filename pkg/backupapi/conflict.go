@@ -0,0 +1,80 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ConflictPolicy is what a restore does with a file already at the path a file is restored to, when the file
+// differs from the backed up one.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite replaces the existing file, it is the default. A file whose change and modification times
+	// match the backed up ones is kept.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps any existing file as it is.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename renames the existing file with suffix ConflictSuffix before the file is restored.
+	ConflictRename ConflictPolicy = "rename"
+
+	// ConflictSuffix is appended to the name of files renamed by ConflictRename, followed by a number when a file
+	// with the suffix exists already.
+	ConflictSuffix = ".bak"
+)
+
+// ErrInvalidConflictPolicy is returned for an unknown conflict policy.
+var ErrInvalidConflictPolicy = errors.New("invalid conflict policy")
+
+// errConflictSkipped is returned when an existing file is kept by ConflictSkip.
+var errConflictSkipped = errors.New("existing file skipped by conflict policy")
+
+// Check returns ErrInvalidConflictPolicy when the policy is not known. An empty policy is ConflictOverwrite.
+func (cp ConflictPolicy) Check() error {
+	switch cp {
+	case "", ConflictOverwrite, ConflictSkip, ConflictRename:
+		return nil
+	}
+	return fmt.Errorf("%w: %s, expected %s, %s or %s", ErrInvalidConflictPolicy, cp, ConflictOverwrite, ConflictSkip, ConflictRename)
+}
+
+// renameExisting renames the file at path with ConflictSuffix, numbered when the name is taken, and returns its new
+// path.
+func renameExisting(path string) (string, error) {
+	for i := 0; ; i++ {
+		renamed := path + ConflictSuffix
+		if i > 0 {
+			renamed += "." + strconv.Itoa(i)
+		}
+		if _, err := os.Lstat(renamed); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		return renamed, os.Rename(path, renamed)
+	}
+}
+
+// linkConflict handles a file at target before it is restored as a hard link to source. It returns
+// errConflictSkipped when the file is kept.
+func linkConflict(source, target string, conflict ConflictPolicy) error {
+	tfi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if sfi, err := os.Stat(source); err == nil && os.SameFile(sfi, tfi) {
+		return nil
+	}
+	switch conflict {
+	case ConflictSkip:
+		return errConflictSkipped
+	case ConflictRename:
+		_, err := renameExisting(target)
+		return err
+	}
+	return nil
+}
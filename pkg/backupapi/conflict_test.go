@@ -0,0 +1,83 @@
+package backupapi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestConflictPolicyCheck(t *testing.T) {
+	for _, cp := range []ConflictPolicy{"", ConflictOverwrite, ConflictSkip, ConflictRename} {
+		assert.NoError(t, cp.Check())
+	}
+	assert.ErrorIs(t, ConflictPolicy("merge").Check(), ErrInvalidConflictPolicy)
+}
+
+func TestRestoreFileConflict(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	// the backed up file is empty and older than the existing one
+	item := cache.Node{Type: "file", Mode: 0600, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+		ModTime: time.Now().Add(-time.Hour), AccessTime: time.Now().Add(-time.Hour)}
+	restore := func(conflict ConflictPolicy) string {
+		target := filepath.Join(t.TempDir(), "a")
+		require.NoError(t, ioutil.WriteFile(target, []byte("existing"), 0600))
+		require.NoError(t, c.restoreFile(context.Background(), target, item, nil, nil, nil, nil, conflict))
+		return target
+	}
+	content := func(path string) string {
+		buf, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	for _, cp := range []ConflictPolicy{"", ConflictOverwrite} {
+		target := restore(cp)
+		assert.Empty(t, content(target))
+		_, err := os.Stat(target + ConflictSuffix)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	target := restore(ConflictSkip)
+	assert.Equal(t, "existing", content(target))
+
+	target = restore(ConflictRename)
+	assert.Empty(t, content(target))
+	assert.Equal(t, "existing", content(target+ConflictSuffix))
+
+	// the name with suffix is taken
+	require.NoError(t, ioutil.WriteFile(target, []byte("changed"), 0600))
+	require.NoError(t, c.restoreFile(context.Background(), target, item, nil, nil, nil, nil, ConflictRename))
+	assert.Equal(t, "existing", content(target+ConflictSuffix))
+	assert.Equal(t, "changed", content(target+ConflictSuffix+".1"))
+}
+
+func TestLinkConflict(t *testing.T) {
+	dir := t.TempDir()
+	source, target := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	require.NoError(t, ioutil.WriteFile(source, []byte("a"), 0600))
+
+	assert.NoError(t, linkConflict(source, target, ConflictSkip), "missing target")
+	require.NoError(t, os.Link(source, target))
+	assert.NoError(t, linkConflict(source, target, ConflictSkip), "target is linked already")
+
+	require.NoError(t, os.Remove(target))
+	require.NoError(t, ioutil.WriteFile(target, []byte("b"), 0600))
+	assert.ErrorIs(t, linkConflict(source, target, ConflictSkip), errConflictSkipped)
+	assert.NoError(t, linkConflict(source, target, ConflictOverwrite))
+	require.NoError(t, linkConflict(source, target, ConflictRename))
+	_, err := os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(target + ConflictSuffix)
+	assert.NoError(t, err)
+}
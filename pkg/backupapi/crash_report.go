@@ -0,0 +1,46 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/crash"
+)
+
+const (
+	crashReportPath = "/agent/crash-reports"
+)
+
+// CrashReportResponse is the server response when upload crash report.
+type CrashReportResponse struct {
+	ID string `json:"id"`
+}
+
+// UploadCrashReport uploads crash report of agent, returns its reference ID.
+func (c *Client) UploadCrashReport(report *crash.Report) (*CrashReportResponse, error) {
+	req, err := c.NewRequest(http.MethodPost, crashReportPath, report)
+	if err != nil {
+		c.logger.Error("c.NewRequest() ", zap.Error(err))
+		return nil, err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		c.logger.Error("c.Do() ", zap.Error(err))
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		return nil, err
+	}
+
+	var crr CrashReportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&crr); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		return nil, err
+	}
+	return &crr, nil
+}
@@ -0,0 +1,32 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/crash"
+)
+
+func TestClient_UploadCrashReport(t *testing.T) {
+	setUp()
+	defer tearDown()
+
+	mux.HandleFunc(path.Join("/api/v1", crashReportPath), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+
+		var report crash.Report
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		assert.Equal(t, "boom", report.Panic)
+		assert.Equal(t, []string{"action-1"}, report.LastActions)
+		require.NoError(t, json.NewEncoder(w).Encode(&CrashReportResponse{ID: "crash-ref"}))
+	})
+
+	crr, err := client.UploadCrashReport(&crash.Report{Panic: "boom", LastActions: []string{"action-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "crash-ref", crr.ID)
+}
@@ -0,0 +1,83 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotFound is matched by APIError of a resource which does not exist, e.g. a deleted backup directory.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is matched by APIError of a request whose credential is rejected by backup server.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrConflict is matched by APIError of a request conflicting with the state of backup server, e.g. an action
+	// already running.
+	ErrConflict = errors.New("conflict")
+)
+
+// APIError is an error response of backup server.
+type APIError struct {
+	StatusCode int
+	// Code and Message are given by the JSON body of the response, Message is the whole body otherwise.
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("backup server responded %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is matches the sentinel error of the status code of e, so callers check errors.Is(err, ErrNotFound).
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// Temporary reports whether the request may succeed when it is sent again.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+// newAPIError reads the error response resp of backup server.
+func newAPIError(resp *http.Response) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode}
+	buf, _ := ioutil.ReadAll(resp.Body)
+	var body struct {
+		Code      string `json:"code"`
+		ErrorCode string `json:"error_code"`
+		Message   string `json:"message"`
+		Error     string `json:"error"`
+	}
+	if json.Unmarshal(buf, &body) == nil {
+		e.Code = body.Code
+		if e.Code == "" {
+			e.Code = body.ErrorCode
+		}
+		e.Message = body.Message
+		if e.Message == "" {
+			e.Message = body.Error
+		}
+	}
+	if e.Code == "" && e.Message == "" {
+		e.Message = strings.TrimSpace(string(buf))
+	}
+	return e
+}
@@ -0,0 +1,72 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		sentinel   error
+		temporary  bool
+	}{
+		{statusCode: http.StatusNotFound, sentinel: ErrNotFound},
+		{statusCode: http.StatusUnauthorized, sentinel: ErrUnauthorized},
+		{statusCode: http.StatusForbidden, sentinel: ErrUnauthorized},
+		{statusCode: http.StatusConflict, sentinel: ErrConflict},
+		{statusCode: http.StatusTooManyRequests, temporary: true},
+		{statusCode: http.StatusBadGateway, temporary: true},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("wrapped: %w", &APIError{StatusCode: tt.statusCode})
+		for _, sentinel := range []error{ErrNotFound, ErrUnauthorized, ErrConflict} {
+			assert.Equal(t, sentinel == tt.sentinel, errors.Is(err, sentinel), "%d is %v", tt.statusCode, sentinel)
+		}
+		var apiErr *APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, tt.temporary, apiErr.Temporary(), tt.statusCode)
+	}
+}
+
+func TestDoAPIError(t *testing.T) {
+	setUp()
+	defer tearDown()
+
+	var conflicts int32
+	mux.HandleFunc("/api/v1/conflict", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&conflicts, 1)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"code": "ACTION_RUNNING", "message": "a recovery point is running"}`))
+	})
+	mux.HandleFunc("/api/v1/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backup directory not found", http.StatusNotFound)
+	})
+
+	req, err := client.NewRequest(http.MethodPost, "/conflict", nil)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = client.Do(req)
+	assert.Less(t, time.Since(start), time.Second, "client errors are not retried")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conflicts))
+	assert.True(t, errors.Is(err, ErrConflict))
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "ACTION_RUNNING", apiErr.Code)
+	assert.Equal(t, "a recovery point is running", apiErr.Message)
+
+	req, err = client.NewRequest(http.MethodGet, "/missing", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	err = checkResponse(resp)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, "backup server responded 404 Not Found: backup directory not found", err.Error())
+}
@@ -0,0 +1,179 @@
+package backupapi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// Formats of recovery point archives.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ArchiveFormats are the formats ExportArchive supports.
+var ArchiveFormats = []string{ArchiveZip, ArchiveTarGz}
+
+// ErrUnsupportedArchiveFormat is returned by ExportArchive for an unknown format.
+var ErrUnsupportedArchiveFormat = errors.New("unsupported archive format")
+
+// ArchiveContentType returns the content type of archives of format.
+func ArchiveContentType(format string) string {
+	if format == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// ExportArchive writes the items of index to w as an archive of format, zip or tar.gz, named by their path relative
+// to the backup directory. The content of files is streamed from their chunks in storageVault, in order, without
+// holding a file in memory. Hard links are links of tar archives, their content is written again in zip archives.
+func (c *Client) ExportArchive(ctx context.Context, w io.Writer, index cache.Index, format string, storageVault storage_vault.StorageVault,
+	restoreKey *AuthRestore, p *progress.Progress) error {
+	var aw archiveWriter
+	switch format {
+	case ArchiveZip:
+		aw = &zipArchive{w: zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		aw = &tarArchive{gw: gw, w: tar.NewWriter(gw)}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedArchiveFormat, format)
+	}
+
+	// hard links come after the files they link to, so tar extracts them
+	paths := make([]string, 0, len(index.Items))
+	for name := range index.Items {
+		paths = append(paths, name)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		li, lj := index.Items[paths[i]].HardLink != "", index.Items[paths[j]].HardLink != ""
+		if li != lj {
+			return lj
+		}
+		return paths[i] < paths[j]
+	})
+	for _, name := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item := *index.Items[name]
+		var link *cache.Node
+		if item.Type == "file" {
+			link = index.HardLinkTarget(&item)
+		}
+		content := item
+		if link != nil {
+			content = *link
+		}
+		body, err := aw.create(item, link)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			if err := c.writeContent(ctx, body, content, storageVault, restoreKey, p); err != nil {
+				return err
+			}
+		}
+		p.Report(progress.Stat{Items: 1})
+	}
+	return aw.Close()
+}
+
+// archiveWriter adds items to an archive.
+type archiveWriter interface {
+	// create adds the header of item, it returns the writer of its content, nil when item has no content in the
+	// archive. link is the item file item is a hard link to, or nil.
+	create(item cache.Node, link *cache.Node) (io.Writer, error)
+	io.Closer
+}
+
+type tarArchive struct {
+	gw *gzip.Writer
+	w  *tar.Writer
+}
+
+func (a *tarArchive) create(item cache.Node, link *cache.Node) (io.Writer, error) {
+	hdr := &tar.Header{
+		Name:       restoreObjectKey(item),
+		Mode:       int64(item.Mode.Perm()),
+		Uid:        int(item.UID),
+		Gid:        int(item.GID),
+		Uname:      item.User,
+		Gname:      item.Group,
+		ModTime:    item.ModTime,
+		AccessTime: item.AccessTime,
+		ChangeTime: item.ChangeTime,
+		Format:     tar.FormatPAX,
+	}
+	switch {
+	case item.Type == "dir":
+		hdr.Typeflag, hdr.Name = tar.TypeDir, hdr.Name+"/"
+	case item.Type == "symlink":
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, item.LinkTarget
+	case link != nil:
+		hdr.Typeflag, hdr.Linkname = tar.TypeLink, restoreObjectKey(*link)
+	default:
+		hdr.Typeflag, hdr.Size = tar.TypeReg, int64(item.Size)
+	}
+	if err := a.w.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil, nil
+	}
+	return a.w, nil
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gw.Close()
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) create(item cache.Node, link *cache.Node) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: restoreObjectKey(item), Method: zip.Deflate, Modified: item.ModTime}
+	switch item.Type {
+	case "dir":
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+		hdr.SetMode(os.ModeDir | item.Mode.Perm())
+	case "symlink":
+		hdr.SetMode(os.ModeSymlink | item.Mode.Perm())
+	default:
+		hdr.SetMode(item.Mode.Perm())
+	}
+	body, err := a.w.CreateHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+	switch item.Type {
+	case "dir":
+		return nil, nil
+	case "symlink":
+		// zip stores the target of symlinks as their content
+		_, err := io.WriteString(body, item.LinkTarget)
+		return nil, err
+	}
+	return body, nil
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
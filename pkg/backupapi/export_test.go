@@ -0,0 +1,112 @@
+package backupapi
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func exportIndex(t *testing.T, vault *memoryVault) cache.Index {
+	chunk := func(data []byte, start uint64) *cache.ChunkInfo {
+		key := chunkKey(data)
+		require.NoError(t, vault.PutObject(context.Background(), key, data))
+		return &cache.ChunkInfo{Etag: key, Start: start, Length: uint(len(data))}
+	}
+	return cache.Index{Items: map[string]*cache.Node{
+		"/data/sub":   {Type: "dir", AbsolutePath: "/data/sub", RelativePath: "sub", Mode: os.ModeDir | 0750},
+		"/data/a.txt": {Type: "file", AbsolutePath: "/data/a.txt", RelativePath: "a.txt", Mode: 0640, Size: 11, Content: []*cache.ChunkInfo{chunk([]byte("world"), 6), chunk([]byte("hello "), 0)}},
+		"/data/sub/b": {Type: "file", AbsolutePath: "/data/sub/b", RelativePath: "sub/b", Mode: 0640, Size: 11, HardLink: "/data/a.txt"},
+		"/data/link":  {Type: "symlink", AbsolutePath: "/data/link", RelativePath: "link", Mode: os.ModeSymlink | 0777, LinkTarget: "a.txt"},
+	}}
+}
+
+func TestClient_ExportArchive_TarGz(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.ExportArchive(context.Background(), &buf, exportIndex(t, vault), ArchiveTarGz, vault, nil, nil))
+
+	gr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		switch hdr.Name {
+		case "a.txt":
+			assert.Equal(t, int64(0640), hdr.Mode)
+			data, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			assert.Equal(t, "hello world", string(data))
+		case "sub/":
+			assert.Equal(t, byte(tar.TypeDir), hdr.Typeflag)
+		case "link":
+			assert.Equal(t, byte(tar.TypeSymlink), hdr.Typeflag)
+			assert.Equal(t, "a.txt", hdr.Linkname)
+		case "sub/b":
+			assert.Equal(t, byte(tar.TypeLink), hdr.Typeflag)
+			assert.Equal(t, "a.txt", hdr.Linkname)
+		}
+	}
+	// the hard link comes after the file it links to
+	assert.Equal(t, []string{"a.txt", "link", "sub/", "sub/b"}, names)
+}
+
+func TestClient_ExportArchive_Zip(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+
+	var buf bytes.Buffer
+	require.NoError(t, c.ExportArchive(context.Background(), &buf, exportIndex(t, vault), ArchiveZip, vault, nil, nil))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	require.Len(t, files, 4)
+	read := func(name string) string {
+		rc, err := files[name].Open()
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "hello world", read("a.txt"))
+	assert.Equal(t, "hello world", read("sub/b"), "hard links have the content of their file")
+	assert.True(t, files["sub/"].Mode().IsDir())
+	assert.Equal(t, os.ModeSymlink, files["link"].Mode()&os.ModeType)
+	assert.Equal(t, "a.txt", read("link"))
+}
+
+func TestClient_ExportArchive_UnsupportedFormat(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+
+	err = c.ExportArchive(context.Background(), ioutil.Discard, exportIndex(t, vault), "rar", vault, nil, nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedArchiveFormat))
+}
@@ -2,11 +2,9 @@ package backupapi
 
 import (
 	"context"
-	"crypto/md5"
 	"crypto/sha256"
-	"encoding/hex"
 	"errors"
-	"hash"
+	"fmt"
 	"io"
 	"io/fs"
 	"net/url"
@@ -14,23 +12,27 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/compression"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
 	"github.com/bizflycloud/bizfly-backup/pkg/progress"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
 	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
 	"github.com/bizflycloud/bizfly-backup/pkg/vss"
 	"github.com/cenkalti/backoff"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
-	"github.com/panjf2000/ants/v2"
 	"github.com/restic/chunker"
 	"github.com/spf13/viper"
 )
@@ -39,10 +41,25 @@ const (
 	ChunkUploadLowerBound  = chunker.MaxSize
 	IntervalTimeRetryChunk = 30 * time.Second
 	MaxTimesRetryChunk     = 3
+	// MaxTimesRetryCorruptedChunk is the number of times a corrupted chunk is downloaded from each storage vault
+	// endpoint before restoring its file fails.
+	MaxTimesRetryCorruptedChunk = 3
+
+	// defaultRestoreChunkWorkers is the number of chunks of a file downloaded at once when restore_chunk_workers is
+	// not set.
+	defaultRestoreChunkWorkers = 4
 )
 
 var (
 	ErrorGotCancelRequest = errors.New("got cancel request")
+	// ErrCorruptedChunk is returned when every download of a chunk has content which does not match its checksum.
+	ErrCorruptedChunk = errors.New("corrupted chunk")
+	// ErrNotEnoughSpace is returned by CheckRestoreSpace when a filesystem of the destination can not hold the restore.
+	ErrNotEnoughSpace = errors.New("not enough space to restore")
+	// ErrDestinationFull is returned when restoring an item fails as there is no space left on the destination.
+	ErrDestinationFull = errors.New("restore destination is full")
+	// ErrDestinationReadOnly is returned when restoring an item fails as the destination is mounted read-only.
+	ErrDestinationReadOnly = errors.New("restore destination is read-only")
 )
 
 func (c *Client) urlStringFromRelPath(relPath string) (string, error) {
@@ -59,33 +76,75 @@ func (c *Client) urlStringFromRelPath(relPath string) (string, error) {
 	return u.String(), nil
 }
 
-func (c *Client) backupChunk(ctx context.Context, data []byte, chunk *cache.ChunkInfo, cacheWriter *cache.Repository, storageVault storage_vault.StorageVault, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+// prepareChunk computes the key of chunk of data and compresses it, it returns the object to put to storage vault.
+func (c *Client) prepareChunk(data []byte, chunk *cache.ChunkInfo) ([]byte, error) {
+	key := chunkKey(data)
+
+	object, err := c.compressChunk(data, chunk)
+	if err != nil {
+		c.logger.Error("err compress chunk", zap.Error(err))
+		return nil, err
+	}
+	if chunk.Compression != compression.None {
+		// compressed and plain objects of the same content must not overwrite each other
+		key += "." + chunk.Compression
+	}
+	chunk.Etag = key
+	return object, nil
+}
+
+// backupChunk puts object of chunk to storageVault, or adds it to a packfile of packer when it is small.
+func (c *Client) backupChunk(ctx context.Context, data []byte, object []byte, chunk *cache.ChunkInfo, storageVault storage_vault.StorageVault, packer *Packer, pipe chan<- *cache.Chunk, rpID, bdID string) (_ uint64, err error) {
 	select {
 	case <-ctx.Done():
 		return 0, ErrorGotCancelRequest
 	default:
+		ctx, span := tracing.Start(ctx, "backup_chunk", attribute.Int("chunk.length", len(data)))
+		defer func() { tracing.End(span, err) }()
 		var stat uint64
 
-		hash := md5.Sum(data)
-		key := hex.EncodeToString(hash[:])
-		chunk.Etag = key
+		if packer.packs(object) {
+			// the packfile is reported to pipe once it is stored
+			return packer.Add(ctx, chunk, object)
+		}
 
 		chunks := cache.NewChunk(bdID, rpID)
-		chunks.Chunks[key] = []string{strconv.Itoa(1), strconv.Itoa(int(chunk.Length))}
+		chunks.Chunks[chunk.Etag] = []string{strconv.Itoa(1), strconv.Itoa(len(object))}
 
 		// Put object
-		err := c.PutObject(storageVault, key, data)
+		err = c.PutObject(ctx, storageVault, chunk.Etag, object)
 		if err != nil {
 			c.logger.Error("err put object", zap.Error(err))
 			return stat, err
 		}
 
 		pipe <- chunks
-		stat += uint64(chunk.Length)
+		stat += uint64(len(object))
 		return stat, nil
 	}
 }
 
+// compressChunk compresses data by the compression in config and records it in chunk. Data which does not get
+// smaller is stored as it is.
+func (c *Client) compressChunk(data []byte, chunk *cache.ChunkInfo) ([]byte, error) {
+	chunk.Compression = compression.None
+	chunk.StoredLength = 0
+	algorithm := viper.GetString("compression")
+	if algorithm == compression.None {
+		return data, nil
+	}
+	compressed, err := compression.Compress(algorithm, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(data) {
+		return data, nil
+	}
+	chunk.Compression = algorithm
+	chunk.StoredLength = uint(len(compressed))
+	return compressed, nil
+}
+
 func (c *Client) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
 	file, err := os.Open(path)
 	// Try to create vss snapshot of file to back up if open error
@@ -109,8 +168,10 @@ func (c *Client) OpenFile(ctx context.Context, path string) (io.ReadCloser, erro
 	return file, err
 }
 
-func (c *Client) ChunkFileToBackup(ctx context.Context, pool *ants.Pool, itemInfo *cache.Node, cacheWriter *cache.Repository,
-	storageVault storage_vault.StorageVault, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+// ChunkFileToBackup chunks content of file itemInfo by chunking, DefaultChunking when it is nil, and uploads its
+// chunks to storageVault, reads of the file are paced by diskLimiter.
+func (c *Client) ChunkFileToBackup(ctx context.Context, pool limiter.Pool, itemInfo *cache.Node, cacheWriter *cache.Repository,
+	storageVault storage_vault.StorageVault, diskLimiter *limiter.DiskLimiter, chunking *Chunking, packer *Packer, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	select {
@@ -122,8 +183,7 @@ func (c *Client) ChunkFileToBackup(ctx context.Context, pool *ants.Pool, itemInf
 		var errBackupChunk error
 		var wg sync.WaitGroup
 		var stat uint64
-		var chunk chunker.Chunk
-		var fileHash hash.Hash
+		var fileSum []byte
 		var errChunk error
 
 		bo := backoff.WithMaxRetries(backoff.NewConstantBackOff(IntervalTimeRetryChunk), MaxTimesRetryChunk)
@@ -143,38 +203,53 @@ func (c *Client) ChunkFileToBackup(ctx context.Context, pool *ants.Pool, itemInf
 				}
 			}
 			defer file.Close()
-			chk := chunker.New(file, 0x3dea92648f6e83)
-			buf := make([]byte, ChunkUploadLowerBound)
-			fileHash = sha256.New()
-			for {
-				chunk, err = chk.Next(buf)
-				if err == io.EOF {
-					break
+			segments, err := c.fileSegments(file, itemInfo)
+			if err != nil {
+				c.logger.Error("detect holes err ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+				return 0, err
+			}
+			params := chunking.chunking()
+			buf := getChunkBuffer(int(params.MaxSize))
+			defer putChunkBuffer(buf)
+			hasher := newFileHasher()
+
+			// chunker reports offset as uint which overflows at 4GiB on 32-bit platforms,
+			// so track offset of chunk in file ourselves.
+			var offset uint64
+			itemInfo.Content = make([]*cache.ChunkInfo, 0, estimateChunks(itemInfo.Size, params.AverageSize))
+			itemInfo.ChunkMap, itemInfo.Chunks = nil, 0
+			submit := func(data []byte, chunkToBackup *cache.ChunkInfo) error {
+				wg.Add(1)
+				hasher.chunk(data, func() {
+					c.submitChunk(ctx, pool, cancel, &wg, &errBackupChunk, &stat, data, chunkToBackup, storageVault, packer, p, pipe, rpID, bdID)
+				})
+				size, err := c.pageChunks(ctx, &wg, &errBackupChunk, itemInfo, false, storageVault, packer, pipe, rpID, bdID)
+				if size > 0 {
+					// chunk jobs are done once a page is stored
+					stat += size
 				}
+				return err
+			}
+			for _, segment := range segments {
+				// holes are hashed as zeros, so the hash of a sparse file is the hash of its content
+				c.skipHole(hasher, segment.start-offset, p)
+				offset = segment.start
+				offset, err = c.chunkReader(ctx, diskLimiter.Reader(ctx, segment.r), offset, params, buf, itemInfo, submit)
 				if err != nil {
-					c.logger.Error("next chunk err ", zap.Error(err))
-					break
-				}
-
-				temp := make([]byte, chunk.Length)
-				length := copy(temp, chunk.Data)
-				if uint(length) != chunk.Length {
-					c.logger.Error("compare error: ", zap.Uint("length", uint(length)), zap.Uint("chunk length", chunk.Length))
-					c.logger.Sugar().Errorf("compare error when chunk file %s", itemInfo.AbsolutePath)
-					err = errors.New("copy chunk data error")
 					break
 				}
-				chunkToBackup := cache.ChunkInfo{
-					Start:  chunk.Start,
-					Length: chunk.Length,
-				}
-				fileHash.Write(temp)
-				itemInfo.Content = append(itemInfo.Content, &chunkToBackup)
-				wg.Add(1)
-				_ = pool.Submit(c.backupChunkJob(ctx, cancel, &wg, &errBackupChunk, &stat, temp, &chunkToBackup, cacheWriter, storageVault, p, pipe, rpID, bdID))
 			}
+			if err == nil && itemInfo.Sparse && offset < itemInfo.Size {
+				c.skipHole(hasher, itemInfo.Size-offset, p)
+			}
+			fileSum = hasher.sum()
 
 			if err != nil && err != io.EOF {
+				if ctx.Err() != nil {
+					// a chunk job failed or backup is cancelled while waiting for memory, file is not read again
+					errChunk = err
+					break
+				}
 				d := bo.NextBackOff()
 				if d == backoff.Stop {
 					c.logger.Sugar().Debugf("chunk file error: %s, Retry time out", err)
@@ -188,25 +263,287 @@ func (c *Client) ChunkFileToBackup(ctx context.Context, pool *ants.Pool, itemInf
 		}
 		wg.Wait()
 
+		if errBackupChunk != nil {
+			c.logger.Error("err backup chunk ", zap.Error(errBackupChunk))
+			return 0, errBackupChunk
+		}
+
 		if errChunk != nil {
 			return 0, errChunk
 		}
+		pageSize, err := c.pageChunks(ctx, &wg, &errBackupChunk, itemInfo, true, storageVault, packer, pipe, rpID, bdID)
+		if err != nil {
+			c.logger.Error("err backup chunk map ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+			return 0, err
+		}
+		stat += pageSize
+		itemInfo.Sha256Hash = fileSum
 
-		if errBackupChunk != nil {
-			c.logger.Error("err backup chunk ", zap.Error(errBackupChunk))
-			return 0, errBackupChunk
+		if len(itemInfo.Streams) > 0 {
+			streamSize, err := c.chunkStreamsToBackup(ctx, pool, itemInfo, cacheWriter, storageVault, diskLimiter, chunking, packer, p, pipe, rpID, bdID)
+			if err != nil {
+				c.logger.Error("err backup alternate data streams ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+				return 0, err
+			}
+			stat += streamSize
 		}
-		itemInfo.Sha256Hash = fileHash.Sum(nil)
 		return stat, nil
 	}
 }
 
+// ChunkReaderToBackup chunks r, the content of itemInfo read from a stream such as the output of a command, by
+// chunking, DefaultChunking when it is nil, and uploads its chunks to storageVault like ChunkFileToBackup. A stream
+// can be read once, so chunking is not retried; the size and hash of itemInfo are set once r is read to its end.
+func (c *Client) ChunkReaderToBackup(ctx context.Context, pool limiter.Pool, itemInfo *cache.Node, r io.Reader,
+	storageVault storage_vault.StorageVault, chunking *Chunking, packer *Packer, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		return 0, ErrorGotCancelRequest
+	}
+
+	var errBackupChunk error
+	var wg sync.WaitGroup
+	var stat uint64
+
+	params := chunking.chunking()
+	buf := getChunkBuffer(int(params.MaxSize))
+	defer putChunkBuffer(buf)
+	hasher := newFileHasher()
+	itemInfo.Content, itemInfo.ChunkMap, itemInfo.Chunks = nil, nil, 0
+	size, err := c.chunkReader(ctx, r, 0, params, buf, itemInfo, func(data []byte, chunkToBackup *cache.ChunkInfo) error {
+		wg.Add(1)
+		hasher.chunk(data, func() {
+			c.submitChunk(ctx, pool, cancel, &wg, &errBackupChunk, &stat, data, chunkToBackup, storageVault, packer, p, pipe, rpID, bdID)
+		})
+		pageSize, err := c.pageChunks(ctx, &wg, &errBackupChunk, itemInfo, false, storageVault, packer, pipe, rpID, bdID)
+		if pageSize > 0 {
+			// chunk jobs are done once a page is stored
+			stat += pageSize
+		}
+		return err
+	})
+	sum := hasher.sum()
+	if err != nil {
+		// chunks submitted are not waited for when reading failed
+		cancel()
+	}
+	wg.Wait()
+
+	if errBackupChunk != nil {
+		c.logger.Error("err backup chunk ", zap.Error(errBackupChunk))
+		return 0, errBackupChunk
+	}
+	if err != nil {
+		c.logger.Error("chunk stream error", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+		return 0, err
+	}
+	pageSize, err := c.pageChunks(ctx, &wg, &errBackupChunk, itemInfo, true, storageVault, packer, pipe, rpID, bdID)
+	if err != nil {
+		c.logger.Error("err backup chunk map ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+		return 0, err
+	}
+	stat += pageSize
+	itemInfo.Size = size
+	itemInfo.Sha256Hash = sum
+	return stat, nil
+}
+
+// chunkReader chunks r, the content of itemInfo from offset, by params into buf. Chunks are appended to the content of
+// itemInfo and given to submit with a copy of their data, reading stops at the first error of submit. It returns the
+// offset after the content read.
+func (c *Client) chunkReader(ctx context.Context, r io.Reader, offset uint64, params Chunking, buf []byte, itemInfo *cache.Node,
+	submit func(data []byte, chunk *cache.ChunkInfo) error) (uint64, error) {
+	chk := newChunker(r, params)
+	defer putChunker(chk)
+	for {
+		chunk, err := chk.Next(buf)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			c.logger.Error("next chunk err ", zap.Error(err))
+			return offset, err
+		}
+
+		// the buffer is given back by the chunk job
+		temp, err := c.getChunkData(ctx, int(chunk.Length))
+		if err != nil {
+			return offset, err
+		}
+		length := copy(temp, chunk.Data)
+		if uint(length) != chunk.Length {
+			c.logger.Error("compare error: ", zap.Uint("length", uint(length)), zap.Uint("chunk length", chunk.Length))
+			c.logger.Sugar().Errorf("compare error when chunk file %s", itemInfo.AbsolutePath)
+			c.putChunkData(temp)
+			return offset, errors.New("copy chunk data error")
+		}
+		chunkToBackup := cache.ChunkInfo{
+			Start:  offset,
+			Length: chunk.Length,
+		}
+		offset += uint64(chunk.Length)
+		itemInfo.Content = append(itemInfo.Content, &chunkToBackup)
+		if err := submit(temp, &chunkToBackup); err != nil {
+			return offset, err
+		}
+	}
+}
+
+// fileSegment is a part of file content chunked separately, starting at offset start of file.
+type fileSegment struct {
+	r     io.Reader
+	start uint64
+}
+
+// fileSegments returns the data ranges of r, the content of file item, when it is sparse so holes are not read nor
+// stored, otherwise the whole content. Item is marked sparse and its size is the size of file when it has holes.
+func (c *Client) fileSegments(r io.Reader, item *cache.Node) ([]fileSegment, error) {
+	item.Sparse = false
+	file, ok := r.(*os.File)
+	if !ok {
+		return []fileSegment{{r: r}}, nil
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ranges, sparse, err := support.DataRanges(file, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if !sparse {
+		return []fileSegment{{r: file}}, nil
+	}
+	item.Sparse = true
+	item.Size = uint64(fi.Size())
+	segments := make([]fileSegment, len(ranges))
+	for i, r := range ranges {
+		segments[i] = fileSegment{r: io.NewSectionReader(file, r.Offset, r.Length), start: uint64(r.Offset)}
+	}
+	return segments, nil
+}
+
+// zeros is hashed in place of holes of sparse files.
+var zeros = make([]byte, 1<<20)
+
+// skipHole hashes a hole of n bytes as zeros, and reports it as done to p.
+func (c *Client) skipHole(h *fileHasher, n uint64, p *progress.Progress) {
+	if n == 0 {
+		return
+	}
+	p.Report(progress.Stat{Bytes: n})
+	h.hole(n)
+}
+
+// chunkStreamsToBackup backs up content of NTFS alternate data streams of file itemInfo, like file content.
+func (c *Client) chunkStreamsToBackup(ctx context.Context, pool limiter.Pool, itemInfo *cache.Node, cacheWriter *cache.Repository,
+	storageVault storage_vault.StorageVault, diskLimiter *limiter.DiskLimiter, chunking *Chunking, packer *Packer, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errBackupChunk error
+	var wg sync.WaitGroup
+	var stat uint64
+	var err error
+	for _, stream := range itemInfo.Streams {
+		file, errOpen := os.Open(support.StreamPath(itemInfo.AbsolutePath, stream.Name))
+		if errOpen != nil {
+			err = errOpen
+			break
+		}
+		params := chunking.chunking()
+		chk := newChunker(diskLimiter.Reader(ctx, file), params)
+		buf := getChunkBuffer(int(params.MaxSize))
+		streamHash := newFileHasher()
+		var offset uint64
+		stream.Content = nil
+		for {
+			chunk, errNext := chk.Next(buf)
+			if errNext == io.EOF {
+				break
+			}
+			if errNext != nil {
+				err = errNext
+				break
+			}
+			// the buffer is given back by the chunk job
+			data, errData := c.getChunkData(ctx, int(chunk.Length))
+			if errData != nil {
+				err = errData
+				break
+			}
+			copy(data, chunk.Data)
+			chunkToBackup := cache.ChunkInfo{
+				Start:  offset,
+				Length: chunk.Length,
+			}
+			offset += uint64(chunk.Length)
+			stream.Content = append(stream.Content, &chunkToBackup)
+			wg.Add(1)
+			streamHash.chunk(data, func() {
+				c.submitChunk(ctx, pool, cancel, &wg, &errBackupChunk, &stat, data, &chunkToBackup, storageVault, packer, p, pipe, rpID, bdID)
+			})
+		}
+		file.Close()
+		putChunker(chk)
+		putChunkBuffer(buf)
+		streamSum := streamHash.sum()
+		if err != nil {
+			break
+		}
+		stream.Size = offset
+		stream.Sha256Hash = streamSum
+	}
+	if err != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	if errBackupChunk != nil {
+		return 0, errBackupChunk
+	}
+	if err != nil {
+		return 0, err
+	}
+	return stat, nil
+}
+
+// sameStreams reports whether nodes have alternate data streams of same names and sizes.
+func sameStreams(a, b *cache.Node) bool {
+	if len(a.Streams) != len(b.Streams) {
+		return false
+	}
+	for i := range a.Streams {
+		if a.Streams[i].Name != b.Streams[i].Name || a.Streams[i].Size != b.Streams[i].Size {
+			return false
+		}
+	}
+	return true
+}
+
+// estimateChunks returns the expected number of chunks of average size of file with given size, up to a page of
+// chunk map as the content of a larger file is paged.
+func estimateChunks(size uint64, average uint) int {
+	n := size/uint64(average) + 1
+	if n > uint64(chunkMapPageSize) {
+		n = uint64(chunkMapPageSize)
+	}
+	return int(n)
+}
+
 type chunkJob func()
 
+// backupChunkJob puts object of chunk of data to storageVault, data is given back to the chunk buffer pools and the
+// memory budget once the job is done.
 func (c *Client) backupChunkJob(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, chErr *error, size *uint64,
-	data []byte, chunk *cache.ChunkInfo, cacheWriter *cache.Repository, storageVault storage_vault.StorageVault, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) chunkJob {
+	data []byte, object []byte, chunk *cache.ChunkInfo, storageVault storage_vault.StorageVault, packer *Packer, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) chunkJob {
 	return func() {
 		defer func() {
+			c.putChunkData(data)
 			wg.Done()
 		}()
 
@@ -215,7 +552,7 @@ func (c *Client) backupChunkJob(ctx context.Context, cancel context.CancelFunc,
 			return
 		default:
 			s := progress.Stat{}
-			saveSize, err := c.backupChunk(ctx, data, chunk, cacheWriter, storageVault, pipe, rpID, bdID)
+			saveSize, err := c.backupChunk(ctx, data, object, chunk, storageVault, packer, pipe, rpID, bdID)
 			if err != nil {
 				c.logger.Error("backupChunk err ", zap.Error(err))
 				*chErr = err
@@ -232,8 +569,10 @@ func (c *Client) backupChunkJob(ctx context.Context, cancel context.CancelFunc,
 	}
 }
 
-func (c *Client) UploadFile(ctx context.Context, pool *ants.Pool, lastInfo *cache.Node, itemInfo *cache.Node, cacheWriter *cache.Repository,
-	storageVault storage_vault.StorageVault, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
+// UploadFile backs up file itemInfo, lastInfo is its node in the latest recovery point. A changed file with the content
+// of a file of contents reuses its chunks, the others are chunked and added to contents.
+func (c *Client) UploadFile(ctx context.Context, pool limiter.Pool, lastInfo *cache.Node, itemInfo *cache.Node, cacheWriter *cache.Repository,
+	storageVault storage_vault.StorageVault, diskLimiter *limiter.DiskLimiter, chunking *Chunking, packer *Packer, detector cache.ChangeDetector, contents *cache.ContentMap, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) (uint64, error) {
 
 	select {
 	case <-ctx.Done():
@@ -241,33 +580,261 @@ func (c *Client) UploadFile(ctx context.Context, pool *ants.Pool, lastInfo *cach
 	default:
 		s := progress.Stat{}
 
-		// backup item with item change mtime
-		if lastInfo == nil || !strings.EqualFold(timeToString(lastInfo.ModTime), timeToString(itemInfo.ModTime)) {
-			storageSize, err := c.ChunkFileToBackup(ctx, pool, itemInfo, cacheWriter, storageVault, p, pipe, rpID, bdID)
+		// backup changed item
+		if c.FileChanged(detector, lastInfo, itemInfo) {
+			if same := c.sameContent(ctx, contents, itemInfo, diskLimiter); same != nil {
+				reuseContent(same.Content, pipe, rpID, bdID)
+				itemInfo.Content = same.Content
+				itemInfo.Sha256Hash = same.Sha256Hash
+				s.Bytes = itemInfo.Size
+				p.Report(s)
+				return 0, nil
+			}
+			storageSize, err := c.ChunkFileToBackup(ctx, pool, itemInfo, cacheWriter, storageVault, diskLimiter, chunking, packer, p, pipe, rpID, bdID)
 			if err != nil {
 				c.logger.Error("c.ChunkFileToBackup ", zap.Error(err))
 				s.Errors = true
 				p.Report(s)
 				return 0, err
 			}
+			contents.Add(itemInfo)
 			p.Report(s)
 			return storageSize, nil
 		} else {
-			for _, content := range lastInfo.Content {
-				chunks := cache.NewChunk(bdID, rpID)
-				chunks.Chunks[content.Etag] = []string{strconv.Itoa(1), strconv.Itoa(int(content.Length))}
-				pipe <- chunks
+			reuseContent(lastInfo.Content, pipe, rpID, bdID)
+			if err := c.reuseChunkMap(ctx, storageVault, lastInfo, pipe, rpID, bdID); err != nil {
+				c.logger.Error("err reuse chunk map ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+				s.Errors = true
+				p.Report(s)
+				return 0, err
+			}
+			for _, stream := range lastInfo.Streams {
+				reuseContent(stream.Content, pipe, rpID, bdID)
 			}
 
 			itemInfo.Content = lastInfo.Content
+			itemInfo.ChunkMap, itemInfo.Chunks = lastInfo.ChunkMap, lastInfo.Chunks
 			itemInfo.Sha256Hash = lastInfo.Sha256Hash
+			itemInfo.Streams = lastInfo.Streams
 		}
 		p.Report(s)
 		return 0, nil
 	}
 }
 
-func (c *Client) RestoreDirectory(ctx context.Context, index cache.Index, destDir string, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
+// reuseContent counts a reference of recovery point rpID to the objects of chunks of content.
+func reuseContent(content []*cache.ChunkInfo, pipe chan<- *cache.Chunk, rpID, bdID string) {
+	for _, info := range content {
+		if info.Pack != "" {
+			pipe <- packedChunk(info, rpID, bdID)
+			continue
+		}
+		chunks := cache.NewChunk(bdID, rpID)
+		chunks.Chunks[info.Etag] = []string{strconv.Itoa(1), strconv.Itoa(int(info.ObjectLength()))}
+		pipe <- chunks
+	}
+}
+
+// sameContent returns the file of contents with the content of file itemInfo, or nil when there is none. itemInfo is
+// only read when a file of its size is in contents; a file which can not be read is chunked as usual.
+func (c *Client) sameContent(ctx context.Context, contents *cache.ContentMap, itemInfo *cache.Node, diskLimiter *limiter.DiskLimiter) *cache.Node {
+	if itemInfo.Size == 0 || len(itemInfo.Streams) > 0 || !contents.HasSize(itemInfo.Size) {
+		return nil
+	}
+	file, err := c.OpenFile(ctx, itemInfo.AbsolutePath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, diskLimiter.Reader(ctx, file))
+	if err != nil || uint64(n) != itemInfo.Size {
+		return nil
+	}
+	return contents.Lookup(itemInfo.Size, h.Sum(nil))
+}
+
+// FileChanged reports whether file itemInfo is changed since lastInfo, its node in the latest recovery point, by
+// detector or its alternate data streams. A file whose change can not be detected is changed.
+func (c *Client) FileChanged(detector cache.ChangeDetector, lastInfo, itemInfo *cache.Node) bool {
+	changed, err := detector.Changed(lastInfo, itemInfo)
+	if err != nil {
+		c.logger.Error("detector.Changed ", zap.Error(err), zap.String("path", itemInfo.AbsolutePath))
+		// fallback to backup item when change can not be detected
+		return true
+	}
+	return changed || !sameStreams(lastInfo, itemInfo)
+}
+
+// SplitPriorityItems returns items of index matching priorityPaths in the given order, and the other items.
+// A path matches the absolute path of item at backup time or its path relative to the backup directory.
+func SplitPriorityItems(index cache.Index, priorityPaths []string) ([]*cache.Node, []*cache.Node) {
+	if len(priorityPaths) == 0 {
+		items := make([]*cache.Node, 0, len(index.Items))
+		for _, item := range index.Items {
+			items = append(items, item)
+		}
+		return nil, items
+	}
+
+	byPath := make(map[string]*cache.Node, 2*len(index.Items))
+	for _, item := range index.Items {
+		byPath[filepath.Clean(item.AbsolutePath)] = item
+		byPath[filepath.Clean(item.RelativePath)] = item
+	}
+	picked := make(map[*cache.Node]bool, len(priorityPaths))
+	var priority []*cache.Node
+	for _, path := range priorityPaths {
+		item, ok := byPath[filepath.Clean(path)]
+		if !ok || picked[item] {
+			continue
+		}
+		picked[item] = true
+		priority = append(priority, item)
+	}
+
+	others := make([]*cache.Node, 0, len(index.Items)-len(priority))
+	for _, item := range index.Items {
+		if !picked[item] {
+			others = append(others, item)
+		}
+	}
+	return priority, others
+}
+
+// RestoreDirectory restores items of index to destDir. Items matching priorityPaths are restored first in the given order,
+// the others start after all of them are done. Progress of priority items is reported to pPriority, which reports to p too.
+// Items in restored are skipped, the items restored are added to it. When the destination becomes full or read-only,
+// the restore stops with ErrDestinationFull or ErrDestinationReadOnly and can be resumed with the same restored.
+// Existing files which differ from the backed up ones are handled by conflict. It fails with ErrUnsafePath when an item
+// would be restored outside destDir, with noSymlinkEscape symlinks pointing outside destDir are not restored and no
+// item is written through one.
+func (c *Client) RestoreDirectory(ctx context.Context, index cache.Index, destDir string, storageVault storage_vault.StorageVault, restoreKey *AuthRestore,
+	p *progress.Progress, priorityPaths []string, pPriority *progress.Progress, restored *RestoredItems, conflict ConflictPolicy, noSymlinkEscape bool) error {
+	if err := CheckRestorePaths(index, destDir); err != nil {
+		return err
+	}
+	priority, others := SplitPriorityItems(index, priorityPaths)
+	priority, priorityLinks := splitHardLinks(index, priority)
+	others, links := splitHardLinks(index, others)
+	if len(priority) > 0 {
+		c.logger.Sugar().Infof("Restore %d priority items", len(priority))
+		pItems := pPriority
+		if pItems == nil {
+			pItems = p
+		}
+		if err := c.restoreItems(ctx, priority, destDir, storageVault, restoreKey, pItems, restored, conflict, noSymlinkEscape); err != nil {
+			return err
+		}
+		pPriority.Done()
+	}
+	if err := c.restoreItems(ctx, others, destDir, storageVault, restoreKey, p, restored, conflict, noSymlinkEscape); err != nil {
+		return err
+	}
+	return c.restoreHardLinks(ctx, index, append(priorityLinks, links...), destDir, storageVault, restoreKey, p, restored, conflict, noSymlinkEscape)
+}
+
+// splitHardLinks separates the items whose content is restored by linking
+// to another item of index.
+func splitHardLinks(index cache.Index, items []*cache.Node) ([]*cache.Node, []*cache.Node) {
+	var rest, links []*cache.Node
+	for _, item := range items {
+		if index.HardLinkTarget(item) != nil {
+			links = append(links, item)
+		} else {
+			rest = append(rest, item)
+		}
+	}
+	return rest, links
+}
+
+// restoreHardLinks recreates links as hard links to their restored targets.
+// When the link can not be created, e.g the target is on other file system,
+// the item is restored from its own content instead.
+func (c *Client) restoreHardLinks(ctx context.Context, index cache.Index, links []*cache.Node, destDir string, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress, restored *RestoredItems, conflict ConflictPolicy, noSymlinkEscape bool) error {
+	for _, item := range links {
+		select {
+		case <-ctx.Done():
+			return ErrorGotCancelRequest
+		default:
+		}
+		if restored.Has(item.AbsolutePath) {
+			p.Report(progress.Stat{Items: 1, Bytes: item.Size})
+			continue
+		}
+		source := restoreTarget(destDir, *index.HardLinkTarget(item))
+		target := restoreTarget(destDir, *item)
+		if noSymlinkEscape && !resolvesWithin(destDir, target) {
+			return fmt.Errorf("%w: %s resolves outside %s through a symlink", ErrUnsafePath, target, destDir)
+		}
+		if err := linkConflict(source, target, conflict); err != nil {
+			if errors.Is(err, errConflictSkipped) {
+				restored.Add(item.AbsolutePath)
+				p.Report(progress.Stat{Items: 1, Bytes: item.Size})
+				continue
+			}
+			return destinationError(err)
+		}
+		if err := linkFile(source, target); err != nil {
+			c.logger.Sugar().Warnf("Create hard link %s to %s error, restore its content: %v", target, source, err)
+			if err := c.RestoreItem(ctx, destDir, *item, storageVault, restoreKey, p, restored, conflict, noSymlinkEscape); err != nil {
+				return destinationError(err)
+			}
+			restored.Add(item.AbsolutePath)
+			continue
+		}
+		restored.Add(item.AbsolutePath)
+		p.Report(progress.Stat{Items: 1, Bytes: item.Size})
+	}
+	return nil
+}
+
+func linkFile(source, target string) error {
+	sfi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if tfi, err := os.Lstat(target); err == nil {
+		if os.SameFile(sfi, tfi) {
+			return nil
+		}
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// RestoreDirectoryTimes sets the access and modification times of the directories of index restored to destDir,
+// which are changed by restoring their entries after them. Deeper directories are done first, so setting the times
+// of a directory does not change the ones of its parent. It reports one item to p per directory.
+func (c *Client) RestoreDirectoryTimes(ctx context.Context, index cache.Index, destDir string, p *progress.Progress) error {
+	var dirs []*cache.Node
+	for _, item := range index.Items {
+		if item.Type == "dir" {
+			dirs = append(dirs, item)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i].AbsolutePath) > len(dirs[j].AbsolutePath) })
+	for _, item := range dirs {
+		select {
+		case <-ctx.Done():
+			return ErrorGotCancelRequest
+		default:
+		}
+		target := restoreTarget(destDir, *item)
+		if err := os.Chtimes(target, item.AccessTime, item.ModTime); err != nil && !os.IsNotExist(err) {
+			c.logger.Sugar().Warnf("Set times of directory %s error: %v", target, err)
+		}
+		p.Report(progress.Stat{Items: 1})
+	}
+	return nil
+}
+
+func (c *Client) restoreItems(ctx context.Context, items []*cache.Node, destDir string, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress, restored *RestoredItems, conflict ConflictPolicy, noSymlinkEscape bool) error {
 	s := progress.Stat{}
 	numGoroutine := viper.GetInt("num_goroutine")
 	if numGoroutine == 0 {
@@ -279,13 +846,17 @@ func (c *Client) RestoreDirectory(ctx context.Context, index cache.Index, destDi
 	sem := semaphore.NewWeighted(int64(numGoroutine))
 	group, ctx := errgroup.WithContext(ctx)
 
-	for _, item := range index.Items {
+	for _, item := range items {
 		select {
 		case <-ctx.Done():
 			p.Cancel()
 			break
 		default:
 			item := item
+			if restored.Has(item.AbsolutePath) {
+				p.Report(progress.Stat{Items: 1, Bytes: item.Size})
+				continue
+			}
 			err := sem.Acquire(ctx, 1)
 			if err != nil {
 				c.logger.Error("err ", zap.Error(err))
@@ -293,13 +864,14 @@ func (c *Client) RestoreDirectory(ctx context.Context, index cache.Index, destDi
 			}
 			group.Go(func() error {
 				defer sem.Release(1)
-				err := c.RestoreItem(ctx, destDir, *item, storageVault, restoreKey, p)
+				err := c.RestoreItem(ctx, destDir, *item, storageVault, restoreKey, p, restored, conflict, noSymlinkEscape)
 				if err != nil {
 					c.logger.Error("Restore file error ", zap.Error(err), zap.String("item name", item.AbsolutePath))
 					s.Errors = true
 					p.Report(s)
-					return err
+					return destinationError(err)
 				}
+				restored.Add(item.AbsolutePath)
 				return nil
 			})
 		}
@@ -312,20 +884,36 @@ func (c *Client) RestoreDirectory(ctx context.Context, index cache.Index, destDi
 	return nil
 }
 
-func (c *Client) RestoreItem(ctx context.Context, destDir string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
+// restoreTarget returns the path item is restored to in destDir.
+func restoreTarget(destDir string, item cache.Node) string {
+	if destDir == item.BasePath {
+		return item.AbsolutePath
+	}
+	return filepath.Join(destDir, item.RelativePath)
+}
+
+func (c *Client) RestoreItem(ctx context.Context, destDir string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress,
+	restored *RestoredItems, conflict ConflictPolicy, noSymlinkEscape bool) (err error) {
 	select {
 	case <-ctx.Done():
 		return ErrorGotCancelRequest
 	default:
+		ctx, span := tracing.Start(ctx, "restore_item",
+			attribute.String("path", item.AbsolutePath),
+			attribute.String("type", item.Type),
+			attribute.Int64("size", int64(item.Size)))
+		defer func() { tracing.End(span, err) }()
 		s := progress.Stat{}
-		var pathItem string
-		if destDir == item.BasePath {
-			pathItem = item.AbsolutePath
-		} else {
-			pathItem = filepath.Join(destDir, item.RelativePath)
+		pathItem := restoreTarget(destDir, item)
+		if noSymlinkEscape && !resolvesWithin(destDir, pathItem) {
+			return fmt.Errorf("%w: %s resolves outside %s through a symlink", ErrUnsafePath, pathItem, destDir)
 		}
 		switch item.Type {
 		case "symlink":
+			if noSymlinkEscape && symlinkEscapes(destDir, pathItem, item.LinkTarget) {
+				c.logger.Sugar().Warnf("Skip symlink %s to %s outside of destination", pathItem, item.LinkTarget)
+				break
+			}
 			err := c.restoreSymlink(ctx, pathItem, item, p)
 			if err != nil {
 				c.logger.Error("Error restore symlink ", zap.Error(err))
@@ -344,7 +932,7 @@ func (c *Client) RestoreItem(ctx context.Context, destDir string, item cache.Nod
 			}
 			p.Report(s)
 		case "file":
-			err := c.restoreFile(ctx, pathItem, item, storageVault, restoreKey, p)
+			err := c.restoreFile(ctx, pathItem, item, storageVault, restoreKey, p, restored, conflict)
 			if err != nil {
 				c.logger.Error("Error restore file ", zap.Error(err))
 				s.Errors = true
@@ -369,7 +957,12 @@ func (c *Client) restoreSymlink(ctx context.Context, target string, item cache.N
 		if err != nil {
 			if os.IsNotExist(err) {
 				c.logger.Sugar().Info("symlink not exist, create ", target)
-				err := c.createSymlink(item.LinkTarget, target, item.Mode, int(item.UID), int(item.GID))
+				var err error
+				if item.ReparseTag == support.ReparseTagMountPoint && support.NTFSSupported {
+					err = c.createJunction(target, item)
+				} else {
+					err = c.createSymlink(item.LinkTarget, target, item.Mode, int(item.UID), int(item.GID))
+				}
 				if err != nil {
 					c.logger.Error("err ", zap.Error(err))
 					s.Errors = true
@@ -384,8 +977,7 @@ func (c *Client) restoreSymlink(ctx context.Context, target string, item cache.N
 				return err
 			}
 		}
-		_, ctimeLocal, _, _, _, _ := support.ItemLocal(fi)
-		if !strings.EqualFold(timeToString(ctimeLocal), timeToString(item.ChangeTime)) {
+		if !strings.EqualFold(timeToString(support.FileMetadata(fi).Ctime), timeToString(item.ChangeTime)) {
 			c.logger.Sugar().Info("symlink change ctime. update mode, uid, gid ", item.Name)
 			err = os.Chmod(target, item.Mode)
 			if err != nil {
@@ -395,6 +987,7 @@ func (c *Client) restoreSymlink(ctx context.Context, target string, item cache.N
 				return err
 			}
 			_ = support.SetChownItem(target, int(item.UID), int(item.GID))
+			c.setExtendedAttributes(target, item)
 		}
 		return nil
 	}
@@ -417,6 +1010,8 @@ func (c *Client) restoreDirectory(ctx context.Context, target string, item cache
 					p.Report(s)
 					return err
 				}
+				c.setAttributes(target, item)
+				c.setExtendedAttributes(target, item)
 				return nil
 			} else {
 				c.logger.Error("err ", zap.Error(err))
@@ -425,8 +1020,7 @@ func (c *Client) restoreDirectory(ctx context.Context, target string, item cache
 				return err
 			}
 		}
-		_, ctimeLocal, _, _, _, _ := support.ItemLocal(fi)
-		if !strings.EqualFold(timeToString(ctimeLocal), timeToString(item.ChangeTime)) {
+		if !strings.EqualFold(timeToString(support.FileMetadata(fi).Ctime), timeToString(item.ChangeTime)) {
 			c.logger.Sugar().Info("dir change ctime. update mode, uid, gid ", item.Name)
 			err = os.Chmod(target, os.ModeDir|item.Mode)
 			if err != nil {
@@ -436,17 +1030,28 @@ func (c *Client) restoreDirectory(ctx context.Context, target string, item cache
 				return err
 			}
 			_ = support.SetChownItem(target, int(item.UID), int(item.GID))
+			c.setExtendedAttributes(target, item)
 		}
 		return nil
 	}
 }
 
-func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
+// restoreFile restores file item to target. An existing target which differs from item is handled by conflict, unless
+// it was written partially by restored, then its chunks which are missing are downloaded.
+func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress,
+	restored *RestoredItems, conflict ConflictPolicy) error {
 	select {
 	case <-ctx.Done():
 		return ErrorGotCancelRequest
 	default:
 		s := progress.Stat{}
+		if restored.Partial(item.AbsolutePath) {
+			if _, err := os.Lstat(target); err == nil {
+				return c.resumeFile(ctx, target, item, storageVault, restoreKey, p, restored)
+			}
+			// the partial file is gone, it is written from its start
+			restored.DropChunks(item.AbsolutePath)
+		}
 		fi, err := os.Stat(target)
 		if err != nil {
 			if os.IsNotExist(err) {
@@ -459,7 +1064,7 @@ func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node
 					return err
 				}
 
-				err = c.downloadFile(ctx, file, item, storageVault, restoreKey, p)
+				err = c.downloadFile(ctx, file, item, storageVault, restoreKey, p, restored)
 				if err != nil {
 					c.logger.Error("downloadFile error ", zap.Error(err))
 					s.Errors = true
@@ -476,11 +1081,24 @@ func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node
 			}
 		}
 		c.logger.Sugar().Info("file exist ", target)
-		_, ctimeLocal, mtimeLocal, _, _, _ := support.ItemLocal(fi)
-		if !strings.EqualFold(timeToString(ctimeLocal), timeToString(item.ChangeTime)) {
-			if !strings.EqualFold(timeToString(mtimeLocal), timeToString(item.ModTime)) {
+		if conflict == ConflictSkip {
+			c.logger.Sugar().Info("file exist. skip by conflict policy ", target)
+			return nil
+		}
+		sameCtime, sameMtime := sameTimes(fi, item)
+		if !sameCtime {
+			if !sameMtime {
 				c.logger.Sugar().Info("file change mtime, ctime ", target)
-				if err = os.Remove(target); err != nil {
+				if conflict == ConflictRename {
+					renamed, err := renameExisting(target)
+					if err != nil {
+						c.logger.Error("err ", zap.Error(err))
+						s.Errors = true
+						p.Report(s)
+						return err
+					}
+					c.logger.Sugar().Info("file renamed by conflict policy to ", renamed)
+				} else if err = os.Remove(target); err != nil {
 					c.logger.Error("err ", zap.Error(err))
 					s.Errors = true
 					p.Report(s)
@@ -495,7 +1113,7 @@ func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node
 					return err
 				}
 
-				err = c.downloadFile(ctx, file, item, storageVault, restoreKey, p)
+				err = c.downloadFile(ctx, file, item, storageVault, restoreKey, p, restored)
 				if err != nil {
 					c.logger.Error("downloadFile error ", zap.Error(err))
 					s.Errors = true
@@ -520,6 +1138,8 @@ func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node
 					p.Report(s)
 					return err
 				}
+				c.setAttributes(target, item)
+				c.setExtendedAttributes(target, item)
 			}
 		} else {
 			c.logger.Sugar().Info("file not change. not restore", target)
@@ -529,36 +1149,55 @@ func (c *Client) restoreFile(ctx context.Context, target string, item cache.Node
 	}
 }
 
-func (c *Client) downloadFile(ctx context.Context, file *os.File, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
-	s := progress.Stat{}
-	for _, info := range item.Content {
-		select {
-		case <-ctx.Done():
-			return ErrorGotCancelRequest
-		default:
-			offset := info.Start
-			key := info.Etag
-			length := info.Length
+// resumeFile writes the chunks of file item missing from target, which was written partially by restored. When target
+// can not be opened, it is written again from its start.
+func (c *Client) resumeFile(ctx context.Context, target string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress,
+	restored *RestoredItems) error {
+	c.logger.Sugar().Info("file restored partially. resume ", target)
+	file, err := os.OpenFile(target, os.O_WRONLY, 0)
+	if err != nil {
+		c.logger.Warn("Open partially restored file error, restore it again", zap.Error(err), zap.String("path", target))
+		restored.DropChunks(item.AbsolutePath)
+		if err := os.Remove(target); err != nil {
+			return err
+		}
+		if file, err = c.createFile(target, item.Mode, int(item.UID), int(item.GID)); err != nil {
+			return err
+		}
+	}
+	defer file.Close()
+	return c.downloadFile(ctx, file, item, storageVault, restoreKey, p, restored)
+}
 
-			data, err := c.GetObject(storageVault, key, restoreKey)
-			if err != nil {
-				c.logger.Error("err ", zap.Error(err))
-				s.Errors = true
-				p.Report(s)
-				return err
-			}
-			s.Bytes = uint64(length)
-			s.Storage = uint64(length)
+// downloadFile writes the content of file item to file and restores its metadata. Chunks already written by restored
+// are skipped, the chunks written are added to it.
+func (c *Client) downloadFile(ctx context.Context, file *os.File, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress,
+	restored *RestoredItems) error {
+	s := progress.Stat{}
+	if item.Sparse {
+		// holes are left by content written at offsets of the truncated file
+		if err := file.Truncate(int64(item.Size)); err != nil {
+			c.logger.Error("err truncate sparse file ", zap.Error(err))
+			s.Errors = true
 			p.Report(s)
-			_, errWriteFile := file.WriteAt(data, int64(offset))
-			if errWriteFile != nil {
-				c.logger.Error("err write file ", zap.Error(errWriteFile))
-				s.Errors = true
-				p.Report(s)
-				return errWriteFile
-			}
+			return err
 		}
 	}
+	if err := c.downloadChunks(ctx, file, &item, restoreChunkWorkers(), storageVault, restoreKey, p, restored); err != nil {
+		if err != ErrorGotCancelRequest {
+			c.logger.Error("err download chunks ", zap.Error(err), zap.String("path", file.Name()))
+			s.Errors = true
+			p.Report(s)
+		}
+		return err
+	}
+
+	if err := c.restoreStreams(ctx, file.Name(), item, storageVault, restoreKey); err != nil {
+		c.logger.Error("err restore alternate data streams ", zap.Error(err))
+		s.Errors = true
+		p.Report(s)
+		return err
+	}
 
 	err := os.Chmod(file.Name(), item.Mode)
 	if err != nil {
@@ -575,9 +1214,176 @@ func (c *Client) downloadFile(ctx context.Context, file *os.File, item cache.Nod
 		p.Report(s)
 		return err
 	}
+	c.setAttributes(file.Name(), item)
+	c.setExtendedAttributes(file.Name(), item)
+	return nil
+}
+
+// downloadChunks writes the chunks of file item to file at their offset, up to workers chunks are downloaded at once.
+// Chunks are scheduled in order from the chunk list of item, which is read page by page for a file with a chunk map,
+// so the chunks held in memory are bounded by workers and a page whatever the size of the file; the file is written
+// from its start and downloads share the rate limit of storageVault. The first error stops the other downloads.
+// Chunks of item already written by restored are skipped, the chunks written are added to it. The holes of a sparse
+// item are reported done once its chunks are scheduled.
+func (c *Client) downloadChunks(ctx context.Context, file *os.File, item *cache.Node, workers int, storageVault storage_vault.StorageVault,
+	restoreKey *AuthRestore, p *progress.Progress, restored *RestoredItems) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if uint64(workers) > item.ChunkCount() {
+		workers = int(item.ChunkCount())
+	}
+	itemPath := item.AbsolutePath
+
+	var once sync.Once
+	var errDownload error
+	fail := func(err error) {
+		once.Do(func() {
+			errDownload = err
+			cancel()
+		})
+	}
+	jobs := make(chan *cache.ChunkInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for info := range jobs {
+				data, cached, err := c.getCachedChunk(ctx, storageVault, info, restoreKey)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				p.Report(chunkStat(info, cached))
+				if _, err := file.WriteAt(data, int64(info.Start)); err != nil {
+					fail(err)
+					continue
+				}
+				restored.AddChunk(itemPath, info.Start)
+			}
+		}()
+	}
+	var data uint64
+	err := c.eachChunk(ctx, storageVault, restoreKey, item, func(info *cache.ChunkInfo) error {
+		data += uint64(info.Length)
+		if restored.HasChunk(itemPath, info.Start) {
+			p.Report(progress.Stat{Bytes: uint64(info.Length)})
+			return nil
+		}
+		select {
+		case jobs <- info:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		fail(err)
+	}
+	close(jobs)
+	wg.Wait()
+	if err == nil && item.Sparse && item.Size > data {
+		p.Report(progress.Stat{Bytes: item.Size - data})
+	}
+
+	if parent.Err() != nil {
+		return ErrorGotCancelRequest
+	}
+	return errDownload
+}
+
+// restoreChunkWorkers returns the number of chunks of a file downloaded at once by a restore, restore_chunk_workers
+// config or defaultRestoreChunkWorkers.
+func restoreChunkWorkers() int {
+	if n := viper.GetInt("restore_chunk_workers"); n > 0 {
+		return n
+	}
+	return defaultRestoreChunkWorkers
+}
+
+// restoreStreams writes NTFS alternate data streams of item to file name, they are skipped on other platforms.
+func (c *Client) restoreStreams(ctx context.Context, name string, item cache.Node, storageVault storage_vault.StorageVault, restoreKey *AuthRestore) error {
+	for _, stream := range item.Streams {
+		streamPath := support.StreamPath(name, stream.Name)
+		if streamPath == "" {
+			c.logger.Sugar().Infof("Skip alternate data stream %s of %s, not supported on this platform", stream.Name, name)
+			continue
+		}
+		f, err := os.OpenFile(streamPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, item.Mode)
+		if err != nil {
+			return err
+		}
+		for _, info := range stream.Content {
+			var data []byte
+			data, _, err = c.getCachedChunk(ctx, storageVault, info, restoreKey)
+			if err != nil {
+				break
+			}
+			if _, err = f.WriteAt(data, int64(info.Start)); err != nil {
+				break
+			}
+		}
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// setExtendedAttributes restores extended attributes and POSIX ACLs of item, a failure is only logged like ownership.
+// When the destination filesystem does not support them, the other attributes of item are skipped.
+func (c *Client) setExtendedAttributes(name string, item cache.Node) {
+	for _, attr := range item.ExtendedAttributes {
+		err := support.SetExtendedAttribute(name, support.ExtendedAttribute{Name: attr.Name, Value: attr.Value})
+		if support.UnsupportedXattr(err) {
+			c.logger.Sugar().Infof("Skip extended attributes of %s, not supported by the destination filesystem", name)
+			return
+		}
+		if err != nil {
+			c.logger.Warn("Set extended attribute error", zap.Error(err), zap.String("path", name), zap.String("name", attr.Name))
+		}
+	}
+}
+
+// DropExtendedAttributes removes the extended attributes, POSIX ACLs and security descriptors of the items of index,
+// so a restore of index leaves the ones of the destination as they are.
+func DropExtendedAttributes(index *cache.Index) {
+	for _, item := range index.Items {
+		item.ExtendedAttributes = nil
+		item.SecurityDescriptor = ""
+	}
+}
+
+// setAttributes restores NTFS attributes and security descriptor of item backed up on Windows, a failure is only
+// logged like ownership.
+func (c *Client) setAttributes(name string, item cache.Node) {
+	if item.Attributes != 0 {
+		if err := support.SetFileAttributes(name, item.Attributes); err != nil {
+			c.logger.Warn("Set file attributes error", zap.Error(err), zap.String("path", name))
+		}
+	}
+	c.setSecurityDescriptor(name, item)
+}
+
+// setSecurityDescriptor restores owner, group and DACL of item backed up on Windows, they are skipped on other
+// platforms.
+func (c *Client) setSecurityDescriptor(name string, item cache.Node) {
+	if item.SecurityDescriptor == "" {
+		return
+	}
+	if !support.NTFSSupported {
+		c.logger.Sugar().Infof("Skip security descriptor of %s, not supported on this platform", name)
+		return
+	}
+	if err := support.SetSecurityDescriptor(name, item.SecurityDescriptor); err != nil {
+		c.logger.Warn("Set security descriptor error", zap.Error(err), zap.String("path", name))
+	}
+}
+
 func (c *Client) createSymlink(symlinkPath string, path string, mode fs.FileMode, uid int, gid int) error {
 	dirName := filepath.Dir(path)
 	if _, err := os.Stat(dirName); os.IsNotExist(err) {
@@ -600,6 +1406,20 @@ func (c *Client) createSymlink(symlinkPath string, path string, mode fs.FileMode
 	return nil
 }
 
+// createJunction restores the directory junction item on Windows, from its reparse point.
+func (c *Client) createJunction(path string, item cache.Node) error {
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		return err
+	}
+	if err := support.WriteReparsePoint(path, item.ReparseData); err != nil {
+		c.logger.Error("err ", zap.Error(err))
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
 func (c *Client) createDir(path string, mode fs.FileMode, uid int, gid int, atime time.Time, mtime time.Time) error {
 	err := os.MkdirAll(path, os.ModePerm)
 	if err != nil {
@@ -652,3 +1472,10 @@ func (c *Client) createFile(path string, mode fs.FileMode, uid int, gid int) (*o
 func timeToString(time time.Time) string {
 	return time.Format("2006-01-02 15:04:05.000000")
 }
+
+// sameTimes reports whether the change and modification times of existing file fi match the ones of item.
+func sameTimes(fi os.FileInfo, item cache.Node) (bool, bool) {
+	md := support.FileMetadata(fi)
+	return strings.EqualFold(timeToString(md.Ctime), timeToString(item.ChangeTime)),
+		strings.EqualFold(timeToString(md.Mtime), timeToString(item.ModTime))
+}
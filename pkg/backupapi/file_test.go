@@ -1,10 +1,33 @@
 package backupapi
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
 	"io/fs"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/compression"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
 )
 
 func Test_createDir(t *testing.T) {
@@ -77,3 +100,652 @@ func Test_createFile(t *testing.T) {
 		})
 	}
 }
+
+type memoryVault struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryVault() *memoryVault {
+	return &memoryVault{objects: make(map[string][]byte)}
+}
+
+func (m *memoryVault) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.objects[key]
+	return ok, key, nil
+}
+
+func (m *memoryVault) PutObject(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (m *memoryVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return m.PutObject(ctx, key, data)
+}
+
+func (m *memoryVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	data, err := m.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (m *memoryVault) DeleteObject(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memoryVault) RefreshCredential(credential storage_vault.Credential) error {
+	return nil
+}
+
+func (m *memoryVault) ID() (string, string) {
+	return "memory", ""
+}
+
+func (m *memoryVault) Type() storage_vault.Type {
+	return storage_vault.Type{StorageVaultType: "MEMORY"}
+}
+
+func TestChunkFileToBackup(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 10*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	size, err := c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+
+	// chunks are contiguous and restore the original content
+	var offset uint64
+	restored := make([]byte, 0, len(data))
+	for _, chunk := range item.Content {
+		assert.Equal(t, offset, chunk.Start)
+		offset += uint64(chunk.Length)
+		buf, err := vault.GetObject(context.Background(), chunk.Etag)
+		require.NoError(t, err)
+		restored = append(restored, buf...)
+	}
+	assert.Equal(t, uint64(len(data)), offset)
+	assert.Equal(t, data, restored)
+	hash := sha256.Sum256(data)
+	assert.Equal(t, hash[:], []byte(item.Sha256Hash))
+}
+
+func TestUploadFileSameContent(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	var refs int
+	pipe := make(chan *cache.Chunk)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range pipe {
+			refs++
+		}
+	}()
+
+	detector, err := cache.NewChangeDetector(cache.ChangeDetectionMtime)
+	require.NoError(t, err)
+	contents := cache.NewContentMap()
+	vault := newMemoryVault()
+	a := &cache.Node{AbsolutePath: filepath.Join(dir, "a"), Size: uint64(len(data)), Type: "file"}
+	size, err := c.UploadFile(context.Background(), pool, nil, a, nil, vault, nil, nil, nil, detector, contents, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+
+	// b has the content of a, its chunks are not uploaded again
+	b := &cache.Node{AbsolutePath: filepath.Join(dir, "b"), Size: uint64(len(data)), Type: "file"}
+	size, err = c.UploadFile(context.Background(), pool, nil, b, nil, vault, nil, nil, nil, detector, contents, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	close(pipe)
+	<-done
+	assert.Zero(t, size)
+	assert.Equal(t, a.Content, b.Content)
+	assert.Equal(t, a.Sha256Hash, b.Sha256Hash)
+	assert.Equal(t, 2*len(a.Content), refs, "chunks of b are referenced")
+}
+
+func TestChunkReaderToBackup(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 10*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: "/backup/dump.sql", Type: "file"}
+	size, err := c.ChunkReaderToBackup(context.Background(), pool, item, bytes.NewReader(data), vault, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+	assert.Equal(t, uint64(len(data)), item.Size)
+
+	restored := make([]byte, 0, len(data))
+	for _, chunk := range item.Content {
+		buf, err := vault.GetObject(context.Background(), chunk.Etag)
+		require.NoError(t, err)
+		restored = append(restored, buf...)
+	}
+	assert.Equal(t, data, restored)
+	hash := sha256.Sum256(data)
+	assert.Equal(t, hash[:], []byte(item.Sha256Hash))
+
+	// a stream failing is not read again
+	errStream := errors.New("command exited")
+	r := io.MultiReader(bytes.NewReader(data[:1<<20]), iotest.ErrReader(errStream))
+	_, err = c.ChunkReaderToBackup(context.Background(), pool, &cache.Node{Type: "file"}, r, vault, nil, nil, nil, pipe, "rp", "bd")
+	assert.ErrorIs(t, err, errStream)
+}
+
+func TestChunkFileToBackupChunking(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 4*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	chunking, err := DefaultChunking.With("", 64, 128, 256, "")
+	require.NoError(t, err)
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	size, err := c.ChunkFileToBackup(context.Background(), pool, item, nil, newMemoryVault(), nil, &chunking, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+
+	// chunks are within the sizes of chunking, but the last one
+	assert.Greater(t, len(item.Content), len(data)/(256<<10))
+	for i, chunk := range item.Content {
+		assert.LessOrEqual(t, chunk.Length, uint(256<<10))
+		if i < len(item.Content)-1 {
+			assert.GreaterOrEqual(t, chunk.Length, uint(64<<10))
+		}
+	}
+}
+
+func TestChunkFileToBackupMemoryLimit(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()), WithMemoryLimit(1))
+	require.NoError(t, err)
+
+	data := make([]byte, 10*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(4)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	// chunks larger than the budget are uploaded one at a time
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	size, err := c.ChunkFileToBackup(context.Background(), pool, item, nil, newMemoryVault(), nil, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(len(data)), size)
+
+	// the budget is given back
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, c.memory.Acquire(ctx, 1<<20))
+}
+
+func TestChunkFileToBackupSparse(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	const size = 32 << 20
+	data := make([]byte, 1<<20)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "sparse")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(size))
+	_, err = f.WriteAt(data, 8<<20)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: path, Size: size, Type: "file", Mode: 0600}
+	_, err = c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	if !item.Sparse {
+		t.Skip("holes are not detected on this filesystem")
+	}
+	hash := sha256.Sum256(content)
+	assert.Equal(t, hash[:], []byte(item.Sha256Hash), "holes are hashed as zeros")
+	var stored uint64
+	for _, chunk := range item.Content {
+		stored += uint64(chunk.Length)
+	}
+	assert.Less(t, stored, uint64(size/2), "holes are not stored")
+
+	restorePath := filepath.Join(t.TempDir(), "restored")
+	file, err := os.Create(restorePath)
+	require.NoError(t, err)
+	require.NoError(t, c.downloadFile(context.Background(), file, *item, vault, nil, nil, nil))
+	require.NoError(t, file.Close())
+	restored, err := ioutil.ReadFile(restorePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, restored)
+}
+
+func TestDownloadChunks(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 10*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	_, err = c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	require.Greater(t, len(item.Content), 1)
+
+	for _, workers := range []int{1, 4, 100} {
+		file, err := os.Create(filepath.Join(t.TempDir(), "restored"))
+		require.NoError(t, err)
+		require.NoError(t, c.downloadChunks(context.Background(), file, item, workers, vault, nil, nil, nil))
+		require.NoError(t, file.Close())
+		restored, err := ioutil.ReadFile(file.Name())
+		require.NoError(t, err)
+		assert.Equal(t, data, restored, workers)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	file, err := os.Create(filepath.Join(t.TempDir(), "cancelled"))
+	require.NoError(t, err)
+	defer file.Close()
+	assert.Equal(t, ErrorGotCancelRequest, c.downloadChunks(ctx, file, item, 4, vault, nil, nil, nil))
+
+	// a resumed download skips the chunks written already, the corrupted one is not downloaded
+	first := item.Content[0]
+	require.NoError(t, vault.PutObject(context.Background(), first.ObjectKey(), []byte("corrupted")))
+	resumed, err := os.Create(filepath.Join(t.TempDir(), "resumed"))
+	require.NoError(t, err)
+	defer resumed.Close()
+	_, err = resumed.WriteAt(data[first.Start:first.Start+uint64(first.Length)], int64(first.Start))
+	require.NoError(t, err)
+	restored := NewRestoredItems()
+	restored.AddChunk(path, first.Start)
+	require.NoError(t, c.downloadChunks(context.Background(), resumed, item, 4, vault, nil, nil, restored))
+	buf, err := ioutil.ReadFile(resumed.Name())
+	require.NoError(t, err)
+	assert.Equal(t, data, buf)
+	assert.True(t, restored.HasChunk(path, item.Content[1].Start))
+
+	// a corrupted chunk stops the download
+	require.NoError(t, vault.PutObject(context.Background(), item.Content[1].ObjectKey(), []byte("corrupted")))
+	assert.ErrorIs(t, c.downloadChunks(context.Background(), file, item, 4, vault, nil, nil, nil), ErrCorruptedChunk)
+}
+
+func TestChunkFileToBackupCompressed(t *testing.T) {
+	viper.Set("compression", compression.LZ4)
+	defer viper.Reset()
+
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("text-heavy content of a log file\n"), 100000)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	pipe := make(chan *cache.Chunk)
+	go func() {
+		for range pipe {
+		}
+	}()
+	defer close(pipe)
+
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	size, err := c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, nil, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Less(t, size, uint64(len(data)/2))
+
+	restored := make([]byte, 0, len(data))
+	for _, chunk := range item.Content {
+		assert.Equal(t, compression.LZ4, chunk.Compression)
+		assert.Less(t, chunk.StoredLength, chunk.Length)
+		buf, err := c.GetChunk(context.Background(), vault, chunk, nil)
+		require.NoError(t, err)
+		restored = append(restored, buf...)
+	}
+	assert.Equal(t, data, restored)
+}
+
+func Test_estimateChunks(t *testing.T) {
+	assert.Equal(t, 1, estimateChunks(0, 1<<20))
+	assert.Equal(t, 11, estimateChunks(10<<20, 1<<20))
+	assert.Equal(t, 41, estimateChunks(10<<20, 256<<10))
+	assert.Equal(t, cache.ChunkMapPageSize, estimateChunks(5<<40, 1<<20))
+}
+
+func TestChunkMap(t *testing.T) {
+	defer func(size int) { chunkMapPageSize = size }(chunkMapPageSize)
+	chunkMapPageSize = 4
+
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := make([]byte, 10*1024*1024)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	keys := make(map[string]int)
+	pipe := make(chan *cache.Chunk)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for chunks := range pipe {
+			for key := range chunks.Chunks {
+				keys[key]++
+			}
+		}
+	}()
+
+	// 10 chunks of 1MiB are paged by 4
+	chunking, err := DefaultChunking.With(ChunkModeFixed, 512, 1024, 8192, "")
+	require.NoError(t, err)
+	vault := newMemoryVault()
+	item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+	_, err = c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, &chunking, nil, nil, pipe, "rp", "bd")
+	require.NoError(t, err)
+	assert.Empty(t, item.Content)
+	require.Len(t, item.ChunkMap, 3)
+	assert.Equal(t, uint64(10), item.Chunks)
+	assert.Equal(t, uint64(10), item.ChunkCount())
+	assert.Equal(t, uint64(8<<20), item.ChunkMap[2].Start)
+
+	var chunks []*cache.ChunkInfo
+	require.NoError(t, c.eachChunk(context.Background(), vault, nil, item, func(chunk *cache.ChunkInfo) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}))
+	require.Len(t, chunks, 10)
+	for i, chunk := range chunks {
+		assert.Equal(t, uint64(i)<<20, chunk.Start)
+	}
+
+	// the file is restored from its chunk map
+	for _, workers := range []int{1, 4} {
+		file, err := os.Create(filepath.Join(t.TempDir(), "restored"))
+		require.NoError(t, err)
+		require.NoError(t, c.downloadChunks(context.Background(), file, item, workers, vault, nil, nil, nil))
+		require.NoError(t, file.Close())
+		restored, err := ioutil.ReadFile(file.Name())
+		require.NoError(t, err)
+		assert.Equal(t, data, restored, workers)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, c.writeContent(context.Background(), &buf, *item, vault, nil, nil))
+	assert.Equal(t, data, buf.Bytes())
+
+	// an unchanged file references its pages and their chunks again
+	detector, err := cache.NewChangeDetector(cache.ChangeDetectionMtime)
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	item.ModTime = fi.ModTime()
+	unchanged := &cache.Node{AbsolutePath: path, Size: item.Size, ModTime: item.ModTime, Type: "file"}
+	_, err = c.UploadFile(context.Background(), pool, item, unchanged, nil, vault, nil, &chunking, nil, detector, nil, nil, pipe, "rp2", "bd")
+	require.NoError(t, err)
+	close(pipe)
+	<-done
+	assert.Equal(t, item.ChunkMap, unchanged.ChunkMap)
+	assert.Equal(t, item.Chunks, unchanged.Chunks)
+	assert.Len(t, keys, 13)
+	for key, refs := range keys {
+		assert.Equal(t, 2, refs, key)
+	}
+}
+
+func TestSplitPriorityItems(t *testing.T) {
+	index := cache.Index{Items: map[string]*cache.Node{}}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		index.Items["/data/"+name] = &cache.Node{AbsolutePath: "/data/" + name, RelativePath: filepath.Join("data", name)}
+	}
+
+	priority, others := SplitPriorityItems(index, []string{"data/c", "/data/a", "/data/missing", "/data/c"})
+	require.Len(t, priority, 2)
+	assert.Equal(t, "/data/c", priority[0].AbsolutePath)
+	assert.Equal(t, "/data/a", priority[1].AbsolutePath)
+	assert.Len(t, others, 2)
+
+	priority, others = SplitPriorityItems(index, nil)
+	assert.Empty(t, priority)
+	assert.Len(t, others, 4)
+}
+
+func Test_sameStreams(t *testing.T) {
+	a := &cache.Node{Streams: []*cache.Stream{{Name: "Zone.Identifier", Size: 26}}}
+	assert.True(t, sameStreams(a, &cache.Node{Streams: []*cache.Stream{{Name: "Zone.Identifier", Size: 26}}}))
+	assert.False(t, sameStreams(a, &cache.Node{Streams: []*cache.Stream{{Name: "Zone.Identifier", Size: 27}}}))
+	assert.False(t, sameStreams(a, &cache.Node{}))
+	assert.True(t, sameStreams(&cache.Node{}, &cache.Node{}))
+}
+
+func TestRestoreHardLinks(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	destDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "data", "sub"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(destDir, "data", "a"), []byte("content"), 0600))
+	// existing file is replaced by the link
+	require.NoError(t, ioutil.WriteFile(filepath.Join(destDir, "data", "sub", "c"), []byte("old"), 0600))
+
+	index := cache.Index{Items: map[string]*cache.Node{}}
+	for _, name := range []string{"a", "b", "sub/c"} {
+		index.Items["/data/"+name] = &cache.Node{Type: "file", AbsolutePath: "/data/" + name, RelativePath: filepath.Join("data", name),
+			BasePath: "/data", Size: 7}
+	}
+	index.Items["/data/b"].HardLink = "/data/a"
+	index.Items["/data/sub/c"].HardLink = "/data/a"
+
+	rest, links := splitHardLinks(index, []*cache.Node{index.Items["/data/a"], index.Items["/data/b"], index.Items["/data/sub/c"]})
+	require.Len(t, rest, 1)
+	require.Len(t, links, 2)
+
+	require.NoError(t, c.restoreHardLinks(context.Background(), index, links, destDir, nil, nil, nil, nil, "", false))
+	source, err := os.Stat(filepath.Join(destDir, "data", "a"))
+	require.NoError(t, err)
+	for _, name := range []string{"b", "sub/c"} {
+		fi, err := os.Stat(filepath.Join(destDir, "data", name))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(source, fi), name)
+	}
+	// restoring again keeps the links
+	require.NoError(t, c.restoreHardLinks(context.Background(), index, links, destDir, nil, nil, nil, nil, "", false))
+}
+
+func TestRestoreDirectoryTimes(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	destDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "data", "sub"), 0700))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	index := cache.Index{Items: map[string]*cache.Node{}}
+	for _, name := range []string{"", "sub", "missing"} {
+		path := filepath.Join("/data", name)
+		index.Items[path] = &cache.Node{Type: "dir", AbsolutePath: path, RelativePath: filepath.Join("data", name),
+			BasePath: "/data", AccessTime: mtime, ModTime: mtime}
+	}
+	index.Items["/data/sub/a"] = &cache.Node{Type: "file", AbsolutePath: "/data/sub/a", RelativePath: "data/sub/a", BasePath: "/data"}
+
+	p := progress.NewProgress(time.Hour)
+	p.Start()
+	var done progress.Stat
+	p.OnDone = func(s progress.Stat, _ time.Duration, _ bool) { done = s }
+	require.NoError(t, c.RestoreDirectoryTimes(context.Background(), index, destDir, p))
+	p.Done()
+	assert.Equal(t, uint64(3), done.Items, "missing directories are skipped")
+	for _, name := range []string{"data", "data/sub"} {
+		fi, err := os.Stat(filepath.Join(destDir, name))
+		require.NoError(t, err)
+		assert.True(t, mtime.Equal(fi.ModTime()), name)
+	}
+}
+
+func TestSetExtendedAttributes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("extended attributes are not backed up on Windows")
+	}
+	viper.Set("xattrs", true)
+	defer viper.Set("xattrs", false)
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source")
+	require.NoError(t, ioutil.WriteFile(source, nil, 0600))
+	attr := support.ExtendedAttribute{Name: "user.bizfly.test", Value: []byte("value")}
+	if err := support.SetExtendedAttribute(source, attr); err != nil {
+		t.Skip("filesystem does not support user extended attributes: ", err)
+	}
+	fi, err := os.Lstat(source)
+	require.NoError(t, err)
+	node, err := cache.NodeFromFileInfo(dir, source, fi)
+	require.NoError(t, err)
+	require.Equal(t, []cache.ExtendedAttribute{{Name: attr.Name, Value: attr.Value}}, node.ExtendedAttributes)
+
+	target := filepath.Join(dir, "target")
+	require.NoError(t, ioutil.WriteFile(target, nil, 0600))
+	// the attributes stored are restored even when the restoring agent does not back them up
+	viper.Set("xattrs", false)
+	c.setExtendedAttributes(target, *node)
+	attrs, err := support.ExtendedAttributes(target)
+	require.NoError(t, err)
+	assert.Equal(t, []support.ExtendedAttribute{attr}, attrs)
+
+	index := cache.Index{Items: map[string]*cache.Node{node.AbsolutePath: node}}
+	node.SecurityDescriptor = "O:BAG:BAD:(A;;FA;;;BA)"
+	DropExtendedAttributes(&index)
+	assert.Empty(t, node.ExtendedAttributes)
+	assert.Empty(t, node.SecurityDescriptor)
+}
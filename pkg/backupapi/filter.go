@@ -0,0 +1,112 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+var (
+	// ErrNoPathMatched is returned when the path filter of a restore selects no item of the recovery point.
+	ErrNoPathMatched = errors.New("no item of recovery point matches the path filter")
+	// ErrNotAFile is returned when the single file restored from a recovery point is not a regular file.
+	ErrNotAFile = errors.New("path of recovery point is not a regular file")
+)
+
+// PathFilter selects the items of a recovery point which are restored. An item is restored when it is under one of
+// Include, or Include is empty, and it is not under one of Exclude. Like priority paths, a path matches the absolute
+// path of item at backup time or its path relative to the backup directory. When File is set, only the regular file
+// at this path is restored, without its directories, and Include and Exclude are ignored.
+type PathFilter struct {
+	Include []string `json:"include_paths,omitempty"`
+	Exclude []string `json:"exclude_paths,omitempty"`
+	File    string   `json:"file_path,omitempty"`
+}
+
+// IsEmpty reports whether the filter selects every item.
+func (f PathFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0 && f.File == ""
+}
+
+// Apply removes the items of index which are not selected by the filter. The directories containing a selected
+// item are kept, so their permissions and times are restored too. It returns ErrNoPathMatched and
+// leaves index unchanged when no item is selected.
+func (f PathFilter) Apply(index *cache.Index) error {
+	if f.IsEmpty() {
+		return nil
+	}
+	if f.File != "" {
+		return f.applyFile(index)
+	}
+	selected := make(map[string]*cache.Node)
+	for key, item := range index.Items {
+		if len(f.Include) > 0 && !matchPaths(item, f.Include) {
+			continue
+		}
+		if matchPaths(item, f.Exclude) {
+			continue
+		}
+		selected[key] = item
+	}
+	if len(selected) == 0 {
+		return ErrNoPathMatched
+	}
+
+	parents := make(map[string]bool)
+	for _, item := range selected {
+		for dir := filepath.Dir(filepath.Clean(item.AbsolutePath)); !parents[dir]; dir = filepath.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+	for key, item := range index.Items {
+		if _, ok := selected[key]; ok {
+			continue
+		}
+		if item.Type == "dir" && parents[filepath.Clean(item.AbsolutePath)] {
+			selected[key] = item
+		}
+	}
+	index.Items = selected
+	return nil
+}
+
+// applyFile removes the items of index other than the regular file f.File.
+func (f PathFilter) applyFile(index *cache.Index) error {
+	path := filepath.Clean(f.File)
+	for key, item := range index.Items {
+		if filepath.Clean(item.AbsolutePath) != path && filepath.Clean(item.RelativePath) != path {
+			continue
+		}
+		if item.Type != "file" {
+			return fmt.Errorf("%w: %s is a %s", ErrNotAFile, f.File, item.Type)
+		}
+		index.Items = map[string]*cache.Node{key: item}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNoPathMatched, f.File)
+}
+
+// matchPaths reports whether item is one of paths or under it.
+func matchPaths(item *cache.Node, paths []string) bool {
+	for _, path := range paths {
+		if underPath(item.AbsolutePath, path) || underPath(item.RelativePath, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath reports whether path is dir or under it.
+func underPath(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
@@ -0,0 +1,76 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestPathFilter(t *testing.T) {
+	newIndex := func() *cache.Index {
+		index := cache.NewIndex("bd", "rp")
+		for _, item := range []struct{ path, typ string }{
+			{"/etc", "dir"},
+			{"/etc/hosts", "file"},
+			{"/etc/nginx", "dir"},
+			{"/etc/nginx/nginx.conf", "file"},
+			{"/etc/nginx/cache", "dir"},
+			{"/etc/nginx/cache/a", "file"},
+			{"/etc/nginx-old", "dir"},
+			{"/etc/ssh", "dir"},
+			{"/etc/ssh/sshd_config", "file"},
+		} {
+			rel, _ := filepath.Rel("/", item.path)
+			index.Items[item.path] = &cache.Node{Type: item.typ, AbsolutePath: item.path, RelativePath: rel}
+		}
+		return index
+	}
+	paths := func(index *cache.Index) []string {
+		var paths []string
+		for path := range index.Items {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	index := newIndex()
+	require.NoError(t, PathFilter{}.Apply(index))
+	assert.Len(t, index.Items, 9)
+
+	index = newIndex()
+	require.NoError(t, PathFilter{Include: []string{"/etc/nginx/"}, Exclude: []string{"etc/nginx/cache"}}.Apply(index))
+	assert.Equal(t, []string{"/etc", "/etc/nginx", "/etc/nginx/nginx.conf"}, paths(index), "parents are kept, siblings are not")
+
+	index = newIndex()
+	require.NoError(t, PathFilter{Exclude: []string{"/etc/nginx", "/etc/hosts"}}.Apply(index))
+	assert.Equal(t, []string{"/etc", "/etc/nginx-old", "/etc/ssh", "/etc/ssh/sshd_config"}, paths(index))
+
+	index = newIndex()
+	require.NoError(t, PathFilter{Include: []string{"etc/ssh"}}.Apply(index))
+	assert.Equal(t, []string{"/etc", "/etc/ssh", "/etc/ssh/sshd_config"}, paths(index))
+
+	index = newIndex()
+	assert.ErrorIs(t, PathFilter{Include: []string{"/var"}}.Apply(index), ErrNoPathMatched)
+	assert.ErrorIs(t, PathFilter{Include: []string{"/etc/hosts"}, Exclude: []string{"/etc"}}.Apply(index), ErrNoPathMatched)
+	assert.Len(t, index.Items, 9)
+
+	index = newIndex()
+	require.NoError(t, PathFilter{File: "etc/nginx/nginx.conf", Include: []string{"/etc/ssh"}}.Apply(index))
+	assert.Equal(t, []string{"/etc/nginx/nginx.conf"}, paths(index), "only the file is restored")
+	index = newIndex()
+	assert.ErrorIs(t, PathFilter{File: "/etc/nginx"}.Apply(index), ErrNotAFile)
+	assert.ErrorIs(t, PathFilter{File: "/etc/nginx/missing.conf"}.Apply(index), ErrNoPathMatched)
+	assert.Len(t, index.Items, 9)
+
+	buf, err := json.Marshal(CreateRestoreRequest{PathFilter: PathFilter{Include: []string{"/etc/nginx"}}})
+	require.NoError(t, err)
+	assert.Contains(t, string(buf), `"include_paths":["/etc/nginx"]`)
+	assert.NotContains(t, string(buf), "exclude_paths")
+}
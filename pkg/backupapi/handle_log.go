@@ -1,9 +1,12 @@
 package backupapi
 
 import (
+	"bufio"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/support"
@@ -13,6 +16,12 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// logTimeLayout is the format of time starting each line of agent log.
+const logTimeLayout = "2006-01-02 15:04:05"
+
+// logReadLimit bounds the number of bytes read from the end of log file by ReadLog.
+const logReadLimit = 64 << 20
+
 func getEncoder() zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(zapcore.EncoderConfig{
 		MessageKey:   "message",
@@ -26,7 +35,7 @@ func getEncoder() zapcore.Encoder {
 }
 
 func SyslogTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-	enc.AppendString(t.Format("2006-01-02 15:04:05"))
+	enc.AppendString(t.Format(logTimeLayout))
 }
 
 func CustomLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
@@ -105,3 +114,68 @@ func createLogFile(path string, mode fs.FileMode) (*os.File, error) {
 
 	return file, nil
 }
+
+// ReadLog returns the lines of agent log file written between from and to, only the latest ones when they are
+// longer than maxSize bytes. Lines without time, e.g. stack traces, belong to the line before them.
+func ReadLog(from, to time.Time, maxSize int) (string, error) {
+	path, _, err := support.CheckPath()
+	if err != nil {
+		return "", err
+	}
+	return readLogFile(path, from, to, maxSize)
+}
+
+func readLogFile(path string, from, to time.Time, maxSize int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	partial := false
+	if offset := fi.Size() - logReadLimit; offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		partial = true
+	}
+
+	from = from.Truncate(time.Second)
+	var lines []string
+	size := 0
+	in := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if partial {
+			// skip the end of the line cut by seek
+			partial = false
+			continue
+		}
+		if len(line) >= len(logTimeLayout) {
+			if t, err := time.ParseInLocation(logTimeLayout, line[:len(logTimeLayout)], time.Local); err == nil {
+				in = !t.Before(from) && !t.After(to)
+			}
+		}
+		if !in {
+			continue
+		}
+		lines = append(lines, line)
+		size += len(line) + 1
+		for size > maxSize && len(lines) > 0 {
+			size -= len(lines[0]) + 1
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
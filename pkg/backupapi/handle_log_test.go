@@ -1,9 +1,12 @@
 package backupapi
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/support"
 	"go.uber.org/zap/zapcore"
@@ -42,3 +45,40 @@ func Test_logWriter(t *testing.T) {
 		})
 	}
 }
+
+func Test_readLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	content := `2022-06-10 09:59:00	[INFO]	server.go:1	before
+2022-06-10 10:00:00	[INFO]	server.go:2	start
+2022-06-10 10:00:30	[ERROR]	server.go:3	panic
+goroutine 1 [running]:
+2022-06-10 10:01:00	[INFO]	server.go:4	failed
+2022-06-10 10:02:00	[INFO]	server.go:5	after
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2022, 6, 10, 10, 0, 0, 500, time.Local)
+	to := time.Date(2022, 6, 10, 10, 1, 0, 0, time.Local)
+
+	got, err := readLogFile(path, from, to, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `2022-06-10 10:00:00	[INFO]	server.go:2	start
+2022-06-10 10:00:30	[ERROR]	server.go:3	panic
+goroutine 1 [running]:
+2022-06-10 10:01:00	[INFO]	server.go:4	failed
+`
+	if got != want {
+		t.Errorf("readLogFile() = %q, want %q", got, want)
+	}
+
+	got, err = readLogFile(path, from, to, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2022-06-10 10:01:00\t[INFO]\tserver.go:4\tfailed\n"; got != want {
+		t.Errorf("readLogFile() with max size = %q, want %q", got, want)
+	}
+}
@@ -1,8 +1,6 @@
 package backupapi
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -39,15 +37,12 @@ func (pc *ProgressWriter) report() {
 	_, _ = fmt.Fprintf(pc.w, "\rTotal: %s done", humanize.Bytes(pc.total))
 }
 
+// checkResponse returns an APIError when resp is not successful.
 func checkResponse(resp *http.Response) error {
 	if resp.StatusCode >= http.StatusOK && resp.StatusCode < 300 {
 		return nil
 	}
-
-	var buf bytes.Buffer
-	_, _ = io.Copy(&buf, resp.Body)
-
-	return errors.New(buf.String())
+	return newAPIError(resp)
 }
 
 func getOutboundIP() string {
@@ -0,0 +1,37 @@
+package backupapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/instance"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// ErrNoInstanceMetadata is returned when a recovery point was made without capturing the metadata of instance.
+var ErrNoInstanceMetadata = errors.New("recovery point has no instance metadata")
+
+// InstanceMetadataKey returns the key of instance metadata of recovery point in storage vault.
+func InstanceMetadataKey(machineID, recoveryPointID string) string {
+	return filepath.Join(machineID, recoveryPointID, "instance.json")
+}
+
+// PutInstanceMetadata stores the metadata of instance of recovery point next to its index.
+func (c *Client) PutInstanceMetadata(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string, m *instance.Metadata) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return c.PutObject(ctx, storageVault, InstanceMetadataKey(machineID, recoveryPointID), buf)
+}
+
+// GetInstanceMetadata reads the metadata of instance of recovery point from storage vault.
+func (c *Client) GetInstanceMetadata(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string) (*instance.Metadata, error) {
+	var m instance.Metadata
+	if err := getRecoveryPointJSON(ctx, storageVault, InstanceMetadataKey(machineID, recoveryPointID), &m, ErrNoInstanceMetadata); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,33 @@
+package backupapi
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/instance"
+)
+
+func TestInstanceMetadata(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+
+	_, err = c.GetInstanceMetadata(context.Background(), vault, "mc", "rp")
+	assert.ErrorIs(t, err, ErrNoInstanceMetadata)
+
+	m := &instance.Metadata{
+		InstanceID:  "0c7a3c51",
+		Flavor:      "2c_4g_basic",
+		NetworkData: json.RawMessage(`{"links":[]}`),
+		UserData:    "#cloud-config\n",
+	}
+	require.NoError(t, c.PutInstanceMetadata(context.Background(), vault, "mc", "rp", m))
+	got, err := c.GetInstanceMetadata(context.Background(), vault, "mc", "rp")
+	require.NoError(t, err)
+	assert.Equal(t, m, got)
+}
@@ -0,0 +1,57 @@
+package backupapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/inventory"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// ErrNoInventory is returned when a recovery point was made without capturing the system inventory.
+var ErrNoInventory = errors.New("recovery point has no inventory")
+
+// InventoryKey returns the key of system inventory of recovery point in storage vault.
+func InventoryKey(machineID, recoveryPointID string) string {
+	return filepath.Join(machineID, recoveryPointID, "inventory.json")
+}
+
+// PutInventory stores the system inventory of recovery point next to its index.
+func (c *Client) PutInventory(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string, inv *inventory.Inventory) error {
+	buf, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return c.PutObject(ctx, storageVault, InventoryKey(machineID, recoveryPointID), buf)
+}
+
+// GetInventory reads the system inventory of recovery point from storage vault.
+func (c *Client) GetInventory(ctx context.Context, storageVault storage_vault.StorageVault, machineID, recoveryPointID string) (*inventory.Inventory, error) {
+	var inv inventory.Inventory
+	if err := getRecoveryPointJSON(ctx, storageVault, InventoryKey(machineID, recoveryPointID), &inv, ErrNoInventory); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// getRecoveryPointJSON reads JSON object key stored with a recovery point into v, errMissing when there is none.
+func getRecoveryPointJSON(ctx context.Context, storageVault storage_vault.StorageVault, key string, v interface{}, errMissing error) error {
+	isExist, _, err := storageVault.HeadObject(ctx, key)
+	if storage_vault.ErrorCode(err) == "NotFound" || (err == nil && !isExist) {
+		return errMissing
+	}
+	if err != nil {
+		return err
+	}
+	buf, err := storageVault.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package backupapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/inventory"
+)
+
+func TestInventory(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	vault := newMemoryVault()
+
+	_, err = c.GetInventory(context.Background(), vault, "mc", "rp")
+	assert.ErrorIs(t, err, ErrNoInventory)
+
+	inv := &inventory.Inventory{
+		Hostname: "db1",
+		Packages: []inventory.Package{{Name: "postgresql", Version: "14.5", Manager: "dpkg"}},
+		Ports:    []inventory.Port{{Protocol: "tcp", Address: "0.0.0.0", Port: 5432}},
+	}
+	require.NoError(t, c.PutInventory(context.Background(), vault, "mc", "rp", inv))
+	got, err := c.GetInventory(context.Background(), vault, "mc", "rp")
+	require.NoError(t, err)
+	assert.Equal(t, inv, got)
+}
@@ -0,0 +1,63 @@
+package backupapi
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// FileListing is a page of the items of a recovery point, in order of path. NextOffset is the offset of the next
+// page, 0 on the last page.
+type FileListing struct {
+	RecoveryPointID string       `json:"recovery_point_id"`
+	Files           []ListedFile `json:"files"`
+	Total           int          `json:"total"`
+	NextOffset      int          `json:"next_offset,omitempty"`
+}
+
+// ListedFile is an item of a recovery point.
+type ListedFile struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    uint64    `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// ListFiles returns limit items of index from offset, in order of path. When prefix is given, only the items under
+// it are listed, it matches the absolute path at backup time or the path relative to the backup directory, like the
+// path filters of restores.
+func ListFiles(index cache.Index, prefix string, offset, limit int) FileListing {
+	items := make([]*cache.Node, 0, len(index.Items))
+	for _, item := range index.Items {
+		if prefix != "" && !matchPaths(item, []string{prefix}) {
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].AbsolutePath < items[j].AbsolutePath })
+
+	listing := FileListing{RecoveryPointID: index.RecoveryPointID, Files: []ListedFile{}, Total: len(items)}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	end := len(items)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+		listing.NextOffset = end
+	}
+	for _, item := range items[offset:end] {
+		listing.Files = append(listing.Files, ListedFile{
+			Path:    item.AbsolutePath,
+			Type:    item.Type,
+			Size:    item.Size,
+			Mode:    item.Mode.String(),
+			ModTime: item.ModTime,
+		})
+	}
+	return listing
+}
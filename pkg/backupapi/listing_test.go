@@ -0,0 +1,51 @@
+package backupapi
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestListFiles(t *testing.T) {
+	mtime := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	index := cache.Index{RecoveryPointID: "rp1", Items: map[string]*cache.Node{
+		"/data":           {Type: "dir", AbsolutePath: "/data", RelativePath: ".", Mode: os.ModeDir | 0755},
+		"/data/a.txt":     {Type: "file", AbsolutePath: "/data/a.txt", RelativePath: "a.txt", Size: 11, Mode: 0644, ModTime: mtime},
+		"/data/etc":       {Type: "dir", AbsolutePath: "/data/etc", RelativePath: "etc", Mode: os.ModeDir | 0755},
+		"/data/etc/hosts": {Type: "file", AbsolutePath: "/data/etc/hosts", RelativePath: "etc/hosts", Size: 5, Mode: 0644},
+		"/data/etcetera":  {Type: "file", AbsolutePath: "/data/etcetera", RelativePath: "etcetera", Size: 1, Mode: 0600},
+	}}
+
+	all := ListFiles(index, "", 0, 0)
+	assert.Equal(t, "rp1", all.RecoveryPointID)
+	assert.Equal(t, 5, all.Total)
+	assert.Zero(t, all.NextOffset)
+	require.Len(t, all.Files, 5)
+	assert.Equal(t, ListedFile{Path: "/data/a.txt", Type: "file", Size: 11, Mode: "-rw-r--r--", ModTime: mtime}, all.Files[1])
+
+	page := ListFiles(index, "", 0, 2)
+	assert.Equal(t, []string{"/data", "/data/a.txt"}, listedPaths(page))
+	assert.Equal(t, 2, page.NextOffset)
+	page = ListFiles(index, "", 4, 2)
+	assert.Equal(t, []string{"/data/etcetera"}, listedPaths(page))
+	assert.Zero(t, page.NextOffset, "last page")
+	assert.Empty(t, ListFiles(index, "", 10, 2).Files)
+
+	// prefixes are paths, absolute or relative to the backup directory
+	assert.Equal(t, []string{"/data/etc", "/data/etc/hosts"}, listedPaths(ListFiles(index, "etc", 0, 0)))
+	assert.Equal(t, []string{"/data/etc", "/data/etc/hosts"}, listedPaths(ListFiles(index, "/data/etc/", 0, 0)))
+	assert.Equal(t, 0, ListFiles(index, "/srv", 0, 0).Total)
+}
+
+func listedPaths(listing FileListing) []string {
+	paths := []string{}
+	for _, f := range listing.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
@@ -31,6 +31,9 @@ type Machine struct {
 	TenantID     string `json:"tenant_id"`
 	OSMachineID  string `json:"os_machine_id"`
 	NumGoroutine int    `json:"num_goroutine"`
+	// Labels and Groups are used by backup server to target config updates to groups of machines.
+	Labels map[string]string `json:"labels,omitempty"`
+	Groups []string          `json:"groups,omitempty"`
 }
 
 // UpdateMachineResponse is the server response when update machine info
@@ -60,6 +63,16 @@ func osName() string {
 	}
 }
 
+// Labels returns the labels of machine.
+func (c *Client) Labels() map[string]string {
+	return c.labels
+}
+
+// Groups returns the groups of machine.
+func (c *Client) Groups() []string {
+	return c.groups
+}
+
 // UpdateMachine updates machine information.
 func (c *Client) UpdateMachine() (*UpdateMachineResponse, error) {
 	hostname, err := os.Hostname()
@@ -73,6 +86,8 @@ func (c *Client) UpdateMachine() (*UpdateMachineResponse, error) {
 		AgentVersion: agentversion.Version(),
 		IPAddress:    getOutboundIP(),
 		NumGoroutine: c.numGoroutine,
+		Labels:       c.labels,
+		Groups:       c.groups,
 	}
 
 	req, err := c.NewRequest(http.MethodPatch, updateMachinePath, m)
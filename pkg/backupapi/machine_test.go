@@ -33,3 +33,21 @@ func TestClient_UpdateMachine(t *testing.T) {
 	assert.NotEmpty(t, umr.BrokerUrl)
 	assert.NoError(t, err)
 }
+
+func TestClient_UpdateMachineLabels(t *testing.T) {
+	setUp()
+	defer tearDown()
+
+	require.NoError(t, WithLabels(map[string]string{"role": "db", "env": "prod"})(client))
+	require.NoError(t, WithGroups("hn1")(client))
+
+	mux.HandleFunc(path.Join("/api/v1", updateMachinePath), func(w http.ResponseWriter, r *http.Request) {
+		var m Machine
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&m))
+		assert.Equal(t, map[string]string{"role": "db", "env": "prod"}, m.Labels)
+		assert.Equal(t, []string{"hn1"}, m.Groups)
+		require.NoError(t, json.NewEncoder(w).Encode(&UpdateMachineResponse{BrokerUrl: "broker-url"}))
+	})
+	_, err := client.UpdateMachine()
+	require.NoError(t, err)
+}
@@ -0,0 +1,140 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// ErrInvalidOwner is returned for an owner mapping whose user or group is unknown on the restoring machine, or
+// which is malformed.
+var ErrInvalidOwner = errors.New("invalid owner mapping")
+
+// OwnerMap changes the owners of the items of a recovery point restored on a machine with other user and group ids.
+// Chown is "user:group", either part may be omitted and each is a name or a numeric id of the restoring machine; it
+// sets the owner of every item. Otherwise UIDs and GIDs map the ids of items at backup time to other ids, ids which
+// are not mapped are kept.
+type OwnerMap struct {
+	Chown string            `json:"chown,omitempty"`
+	UIDs  map[uint32]uint32 `json:"uid_map,omitempty"`
+	GIDs  map[uint32]uint32 `json:"gid_map,omitempty"`
+}
+
+// IsEmpty reports whether the mapping keeps the owners of every item.
+func (m OwnerMap) IsEmpty() bool {
+	return m.Chown == "" && len(m.UIDs) == 0 && len(m.GIDs) == 0
+}
+
+// Check returns ErrInvalidOwner when the user or group of Chown is not known on this machine.
+func (m OwnerMap) Check() error {
+	_, _, err := m.resolveChown()
+	return err
+}
+
+// Apply sets the owners of the items of index by the mapping. The user and group names of items are updated with
+// Chown, and cleared for mapped ids, as they were the names of the ids of the backed up machine.
+func (m OwnerMap) Apply(index *cache.Index) error {
+	if m.IsEmpty() {
+		return nil
+	}
+	uid, gid, err := m.resolveChown()
+	if err != nil {
+		return err
+	}
+	owner, group := m.chownParts()
+	for _, item := range index.Items {
+		if uid != nil {
+			item.UID, item.User = *uid, owner
+		} else if id, ok := m.UIDs[item.UID]; ok {
+			item.UID, item.User = id, ""
+		}
+		if gid != nil {
+			item.GID, item.Group = *gid, group
+		} else if id, ok := m.GIDs[item.GID]; ok {
+			item.GID, item.Group = id, ""
+		}
+	}
+	return nil
+}
+
+// chownParts splits Chown into its user and group.
+func (m OwnerMap) chownParts() (string, string) {
+	owner, group := m.Chown, ""
+	if i := strings.Index(m.Chown, ":"); i >= 0 {
+		owner, group = m.Chown[:i], m.Chown[i+1:]
+	}
+	return owner, group
+}
+
+// resolveChown returns the ids of the user and group of Chown, nil for a part which is omitted.
+func (m OwnerMap) resolveChown() (*uint32, *uint32, error) {
+	owner, group := m.chownParts()
+	uid, err := resolveID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: user %s: %v", ErrInvalidOwner, owner, err)
+	}
+	gid, err := resolveID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: group %s: %v", ErrInvalidOwner, group, err)
+	}
+	return uid, gid, nil
+}
+
+// resolveID returns the numeric id of name, looked up by lookup unless name is numeric, or nil for an empty name.
+func resolveID(name string, lookup func(string) (string, error)) (*uint32, error) {
+	if name == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		s, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		if id, err = strconv.ParseUint(s, 10, 32); err != nil {
+			return nil, err
+		}
+	}
+	id32 := uint32(id)
+	return &id32, nil
+}
+
+// ParseIDMap parses pairs of ids "old:new" into a map of ids.
+func ParseIDMap(pairs []string) (map[uint32]uint32, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	ids := make(map[uint32]uint32, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: %s, expected <old id>:<new id>", ErrInvalidOwner, pair)
+		}
+		old, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOwner, pair, err)
+		}
+		id, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOwner, pair, err)
+		}
+		ids[uint32(old)] = uint32(id)
+	}
+	return ids, nil
+}
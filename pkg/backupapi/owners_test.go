@@ -0,0 +1,63 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestOwnerMapApply(t *testing.T) {
+	newIndex := func() *cache.Index {
+		return &cache.Index{Items: map[string]*cache.Node{
+			"/a": {UID: 1000, GID: 100, User: "alice", Group: "users"},
+			"/b": {UID: 1001, GID: 101, User: "bob", Group: "staff"},
+		}}
+	}
+
+	index := newIndex()
+	require.NoError(t, OwnerMap{UIDs: map[uint32]uint32{1000: 2000}, GIDs: map[uint32]uint32{101: 201}}.Apply(index))
+	assert.Equal(t, cache.Node{UID: 2000, GID: 100, Group: "users"}, *index.Items["/a"])
+	assert.Equal(t, cache.Node{UID: 1001, GID: 201, User: "bob"}, *index.Items["/b"])
+
+	index = newIndex()
+	require.NoError(t, OwnerMap{Chown: "3000:"}.Apply(index))
+	for _, item := range index.Items {
+		assert.Equal(t, uint32(3000), item.UID)
+	}
+	assert.Equal(t, uint32(100), index.Items["/a"].GID)
+
+	current, err := user.Current()
+	require.NoError(t, err)
+	index = newIndex()
+	require.NoError(t, OwnerMap{Chown: current.Username + ":300", UIDs: map[uint32]uint32{1000: 2000}}.Apply(index))
+	assert.Equal(t, current.Uid, strconv.FormatUint(uint64(index.Items["/a"].UID), 10), "chown wins over uid map")
+	assert.Equal(t, current.Username, index.Items["/a"].User)
+	assert.Equal(t, uint32(300), index.Items["/b"].GID)
+
+	assert.ErrorIs(t, OwnerMap{Chown: "no-such-user-of-test"}.Check(), ErrInvalidOwner)
+	assert.ErrorIs(t, OwnerMap{Chown: ":no-such-group-of-test"}.Apply(newIndex()), ErrInvalidOwner)
+}
+
+func TestParseIDMap(t *testing.T) {
+	ids, err := ParseIDMap([]string{"1000:2000", "0:65534"})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint32]uint32{1000: 2000, 0: 65534}, ids)
+
+	// the mapping is sent in restore requests and events
+	buf, err := json.Marshal(OwnerMap{UIDs: ids})
+	require.NoError(t, err)
+	var m OwnerMap
+	require.NoError(t, json.Unmarshal(buf, &m))
+	assert.Equal(t, ids, m.UIDs)
+
+	for _, pairs := range [][]string{{"1000"}, {"a:1"}, {"1:2:3"}, {"1:-1"}} {
+		_, err := ParseIDMap(pairs)
+		assert.ErrorIs(t, err, ErrInvalidOwner, pairs)
+	}
+}
@@ -0,0 +1,194 @@
+package backupapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+const (
+	// MinPackSize and MaxPackSize bound the MiB of packfiles.
+	MinPackSize = 16
+	MaxPackSize = 64
+
+	// PackChunkSize bounds the objects of chunks which are packed, larger chunks are objects of their own.
+	PackChunkSize = 512 << 10
+)
+
+// Packer aggregates objects of small chunks backed up by a backup into packfiles, so millions of tiny files are not
+// stored as millions of tiny objects. A chunk is appended to the open packfile, which is put to storage vault once it
+// holds size bytes. Chunks record the key of their packfile and their offset in it, which is the offset table of
+// packfiles in the index of recovery point. It is safe for concurrent use, a nil Packer packs nothing.
+type Packer struct {
+	c            *Client
+	storageVault storage_vault.StorageVault
+	size         int
+	pipe         chan<- *cache.Chunk
+	rpID, bdID   string
+
+	mu  sync.Mutex
+	key string
+	buf []byte
+	// packed are the chunks packed by the backup by their key, the same content is packed once.
+	packed map[string]cache.ChunkInfo
+	// previous are the chunks packed by earlier backups by their key, they are not packed again.
+	previous map[string]cache.ChunkInfo
+}
+
+// NewPacker returns a Packer putting packfiles of sizeMb MiB to storageVault, the packfiles are reported to pipe like
+// chunks. It returns nil when sizeMb is zero.
+func (c *Client) NewPacker(storageVault storage_vault.StorageVault, sizeMb int, pipe chan<- *cache.Chunk, rpID, bdID string) *Packer {
+	if sizeMb == 0 {
+		return nil
+	}
+	return &Packer{
+		c:            c,
+		storageVault: storageVault,
+		size:         sizeMb << 20,
+		pipe:         pipe,
+		rpID:         rpID,
+		bdID:         bdID,
+		packed:       make(map[string]cache.ChunkInfo),
+		previous:     make(map[string]cache.ChunkInfo),
+	}
+}
+
+// AddIndex adds the packed chunks of index, the index of an earlier recovery point in the storage vault of the
+// Packer, so chunks with their content reuse the place of their object in its packfiles. It must be called before
+// chunks are added.
+func (p *Packer) AddIndex(index *cache.Index) {
+	if p == nil {
+		return
+	}
+	add := func(content []*cache.ChunkInfo) {
+		for _, chunk := range content {
+			if chunk.Pack != "" {
+				p.previous[chunk.Etag] = *chunk
+			}
+		}
+	}
+	for _, item := range index.Items {
+		add(item.Content)
+		for _, stream := range item.Streams {
+			add(stream.Content)
+		}
+	}
+}
+
+// CheckPackSize returns an error when sizeMb is neither zero, which disables packfiles, nor between MinPackSize and
+// MaxPackSize.
+func CheckPackSize(sizeMb int) error {
+	if sizeMb != 0 && (sizeMb < MinPackSize || sizeMb > MaxPackSize) {
+		return fmt.Errorf("pack size must be between %d MiB and %d MiB, or 0 to disable packfiles", MinPackSize, MaxPackSize)
+	}
+	return nil
+}
+
+// ConfigPackSize returns the MiB of packfiles set by pack_size of agent config.
+func ConfigPackSize() (int, error) {
+	size := viper.GetInt("pack_size")
+	return size, CheckPackSize(size)
+}
+
+// packs reports whether object of a chunk is packed.
+func (p *Packer) packs(object []byte) bool {
+	return p != nil && len(object) < PackChunkSize
+}
+
+// Add appends object of chunk to the open packfile and records its place in chunk, object is copied. It puts the
+// packfile to storage vault once it is full and returns the bytes stored for chunk. A chunk packed by an earlier
+// backup records its place in the packfile of that backup, which is reported to pipe as referenced, and nothing is
+// stored.
+func (p *Packer) Add(ctx context.Context, chunk *cache.ChunkInfo, object []byte) (uint64, error) {
+	p.mu.Lock()
+	if packed, ok := p.packed[chunk.Etag]; ok {
+		chunk.Pack, chunk.Offset = packed.Pack, packed.Offset
+		p.mu.Unlock()
+		return 0, nil
+	}
+	if previous, ok := p.previous[chunk.Etag]; ok {
+		chunk.Pack, chunk.Offset = previous.Pack, previous.Offset
+		p.packed[chunk.Etag] = *chunk
+		p.mu.Unlock()
+		p.pipe <- packedChunk(chunk, p.rpID, p.bdID)
+		return 0, nil
+	}
+	if p.key == "" {
+		key, err := newPackKey()
+		if err != nil {
+			p.mu.Unlock()
+			return 0, err
+		}
+		p.key = key
+		p.buf = make([]byte, 0, p.size+PackChunkSize)
+	}
+	chunk.Pack, chunk.Offset = p.key, uint64(len(p.buf))
+	p.buf = append(p.buf, object...)
+	p.packed[chunk.Etag] = *chunk
+	var key string
+	var full []byte
+	if len(p.buf) >= p.size {
+		key, full = p.key, p.buf
+		p.key, p.buf = "", nil
+	}
+	p.mu.Unlock()
+
+	if full != nil {
+		if err := p.put(ctx, key, full); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(len(object)), nil
+}
+
+// Flush puts the open packfile to storage vault, it must be called once all chunks of the backup are added.
+func (p *Packer) Flush(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	key, buf := p.key, p.buf
+	p.key, p.buf = "", nil
+	p.mu.Unlock()
+	if len(buf) == 0 {
+		return nil
+	}
+	return p.put(ctx, key, buf)
+}
+
+func (p *Packer) put(ctx context.Context, key string, buf []byte) error {
+	if err := p.c.PutObject(ctx, p.storageVault, key, buf); err != nil {
+		p.c.logger.Error("err put packfile", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	chunks := cache.NewChunk(p.bdID, p.rpID)
+	chunks.Chunks[key] = []string{strconv.Itoa(1), strconv.Itoa(len(buf))}
+	p.pipe <- chunks
+	return nil
+}
+
+// packedChunk returns the reference of recovery point rpID to the packfile of chunk, reported with the size of chunk.
+func packedChunk(chunk *cache.ChunkInfo, rpID, bdID string) *cache.Chunk {
+	chunks := cache.NewChunk(bdID, rpID)
+	chunks.Chunks[chunk.Pack] = []string{strconv.Itoa(1), strconv.Itoa(int(chunk.ObjectLength()))}
+	chunks.Packed = true
+	return chunks
+}
+
+// newPackKey returns a random key of packfile, as chunks record it before its content is complete.
+func newPackKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "pack-" + hex.EncodeToString(b), nil
+}
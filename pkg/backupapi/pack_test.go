@@ -0,0 +1,156 @@
+package backupapi
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestCheckPackSize(t *testing.T) {
+	assert.NoError(t, CheckPackSize(0))
+	assert.NoError(t, CheckPackSize(MinPackSize))
+	assert.NoError(t, CheckPackSize(MaxPackSize))
+	assert.Error(t, CheckPackSize(1))
+	assert.Error(t, CheckPackSize(MaxPackSize+1))
+}
+
+func TestPacker(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	assert.Nil(t, c.NewPacker(newMemoryVault(), 0, nil, "rp", "bd"))
+
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	var mu sync.Mutex
+	reported := make(map[string]string)
+	pipe := make(chan *cache.Chunk)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for chunks := range pipe {
+			mu.Lock()
+			for key, value := range chunks.Chunks {
+				reported[key] = value[1]
+			}
+			mu.Unlock()
+		}
+	}()
+
+	vault := newMemoryVault()
+	packer := c.NewPacker(vault, MinPackSize, pipe, "rp", "bd")
+	// packfiles of 1MiB hold a few files each
+	packer.size = 1 << 20
+
+	dir := t.TempDir()
+	chunking, err := DefaultChunking.With("", 64, 128, 256, "")
+	require.NoError(t, err)
+	var items []*cache.Node
+	var contents [][]byte
+	for i := 0; i < 8; i++ {
+		data := make([]byte, 300<<10)
+		// the last file has the content of the first, its chunks are packed once
+		_, err := rand.New(rand.NewSource(int64(i % 7))).Read(data)
+		require.NoError(t, err)
+		path := filepath.Join(dir, string(rune('a'+i)))
+		require.NoError(t, ioutil.WriteFile(path, data, 0600))
+		item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+		_, err = c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, &chunking, packer, nil, pipe, "rp", "bd")
+		require.NoError(t, err)
+		items = append(items, item)
+		contents = append(contents, data)
+	}
+	require.NoError(t, packer.Flush(context.Background()))
+	close(pipe)
+	<-done
+
+	packs := make(map[string]bool)
+	for i, item := range items {
+		restored := make([]byte, 0, item.Size)
+		for _, chunk := range item.Content {
+			require.NotEmpty(t, chunk.Pack)
+			packs[chunk.Pack] = true
+			data, err := c.GetChunk(context.Background(), vault, chunk, nil)
+			require.NoError(t, err)
+			restored = append(restored, data...)
+		}
+		assert.Equal(t, contents[i], restored)
+	}
+	assert.Equal(t, items[0].Content[0].Pack, items[7].Content[0].Pack)
+	assert.Equal(t, items[0].Content[0].Offset, items[7].Content[0].Offset)
+
+	// only packfiles are stored and reported
+	assert.Len(t, vault.objects, len(packs))
+	assert.True(t, len(packs) > 1 && len(packs) < 8, len(packs))
+	assert.Len(t, reported, len(packs))
+	for key := range packs {
+		assert.Equal(t, strconv.Itoa(len(vault.objects[key])), reported[key])
+	}
+}
+
+func TestPackerReusesPreviousPacks(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	pool, err := ants.NewPool(2)
+	require.NoError(t, err)
+	defer pool.Release()
+
+	vault := newMemoryVault()
+	chunking, err := DefaultChunking.With("", 64, 128, 256, "")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "a")
+	data := make([]byte, 300<<10)
+	_, err = rand.New(rand.NewSource(1)).Read(data)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	backup := func(rpID string, previous *cache.Index) (*cache.Node, []*cache.Chunk) {
+		var reported []*cache.Chunk
+		pipe := make(chan *cache.Chunk)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for chunks := range pipe {
+				reported = append(reported, chunks)
+			}
+		}()
+		packer := c.NewPacker(vault, MinPackSize, pipe, rpID, "bd")
+		packer.AddIndex(previous)
+		item := &cache.Node{AbsolutePath: path, Size: uint64(len(data)), Type: "file"}
+		_, err := c.ChunkFileToBackup(context.Background(), pool, item, nil, vault, nil, &chunking, packer, nil, pipe, rpID, "bd")
+		require.NoError(t, err)
+		require.NoError(t, packer.Flush(context.Background()))
+		close(pipe)
+		<-done
+		return item, reported
+	}
+
+	first, _ := backup("rp1", &cache.Index{})
+	require.Len(t, vault.objects, 1)
+
+	// the file changed back to its content of rp1, its chunks are not packed again
+	second, reported := backup("rp2", &cache.Index{Items: map[string]*cache.Node{path: first}})
+	assert.Len(t, vault.objects, 1)
+	require.Len(t, second.Content, len(first.Content))
+	for i, chunk := range second.Content {
+		assert.Equal(t, first.Content[i].Pack, chunk.Pack)
+		assert.Equal(t, first.Content[i].Offset, chunk.Offset)
+	}
+	require.NotEmpty(t, reported)
+	for _, chunks := range reported {
+		assert.True(t, chunks.Packed)
+		assert.Contains(t, chunks.Chunks, first.Content[0].Pack)
+	}
+}
@@ -0,0 +1,111 @@
+package backupapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"hash"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// fileHasherQueue is the number of chunks read from a file ahead of its hash.
+const fileHasherQueue = 4
+
+// Backing up a file is a pipeline: the goroutine reading the file cuts its chunks, a fileHasher adds them to the hash
+// of the file in order, hashers of c compute their keys and compress them, and the workers of the upload pool put them
+// to the storage vault. Each stage waits while the next one is busy, so compute and IO overlap without reading the
+// file far ahead of its uploads.
+
+// fileHasher computes the SHA-256 of the content of a file on its own goroutine, so reading the next chunk does not
+// wait for the hash of the previous one. A chunk is handed to the next stage once it is hashed.
+type fileHasher struct {
+	hash  hash.Hash
+	parts chan filePart
+	done  chan struct{}
+}
+
+// filePart is a chunk of data, or a hole of sparse file which is hashed as zeros.
+type filePart struct {
+	data []byte
+	hole uint64
+	next func()
+}
+
+func newFileHasher() *fileHasher {
+	h := &fileHasher{hash: sha256.New(), parts: make(chan filePart, fileHasherQueue), done: make(chan struct{})}
+	go h.run()
+	return h
+}
+
+func (h *fileHasher) run() {
+	defer close(h.done)
+	for part := range h.parts {
+		if part.data != nil {
+			h.hash.Write(part.data)
+		}
+		for n := part.hole; n > 0; {
+			size := uint64(len(zeros))
+			if n < size {
+				size = n
+			}
+			h.hash.Write(zeros[:size])
+			n -= size
+		}
+		if part.next != nil {
+			part.next()
+		}
+	}
+}
+
+// chunk hashes data, then calls next. data must not change until next is called.
+func (h *fileHasher) chunk(data []byte, next func()) {
+	h.parts <- filePart{data: data, next: next}
+}
+
+// hole hashes n zeros.
+func (h *fileHasher) hole(n uint64) {
+	if n > 0 {
+		h.parts <- filePart{hole: n}
+	}
+}
+
+// sum waits until the parts are hashed and returns the hash, the hasher must not be used after.
+func (h *fileHasher) sum() []byte {
+	close(h.parts)
+	<-h.done
+	return h.hash.Sum(nil)
+}
+
+// submitChunk backs up chunk of data: it waits for a hasher of c, which computes the key of chunk and compresses it,
+// then submits its upload to pool. data is given back once the chunk is uploaded, or failed. A failure is set to
+// chErr and cancels the backup of the file.
+func (c *Client) submitChunk(ctx context.Context, pool limiter.Pool, cancel context.CancelFunc, wg *sync.WaitGroup, chErr *error, size *uint64,
+	data []byte, chunk *cache.ChunkInfo, storageVault storage_vault.StorageVault, packer *Packer, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) {
+	hashers := c.hashers
+	hashers <- struct{}{}
+	go func() {
+		defer func() { <-hashers }()
+		if ctx.Err() == nil {
+			object, err := c.prepareChunk(data, chunk)
+			if err != nil {
+				c.logger.Error("prepare chunk err ", zap.Error(err))
+				*chErr = err
+				p.Report(progress.Stat{Errors: true})
+				cancel()
+				c.putChunkData(data)
+				wg.Done()
+				return
+			}
+			_ = pool.Submit(c.backupChunkJob(ctx, cancel, wg, chErr, size, data, object, chunk, storageVault, packer, p, pipe, rpID, bdID))
+			return
+		}
+		c.putChunkData(data)
+		wg.Done()
+	}()
+}
@@ -0,0 +1,31 @@
+package backupapi
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileHasher(t *testing.T) {
+	h := newFileHasher()
+	var order []int
+	h.chunk([]byte("hello"), func() { order = append(order, 1) })
+	h.hole(3)
+	h.hole(0)
+	h.chunk([]byte("world"), func() { order = append(order, 2) })
+
+	want := sha256.Sum256([]byte("hello\x00\x00\x00world"))
+	assert.Equal(t, want[:], h.sum())
+	assert.Equal(t, []int{1, 2}, order, "chunks are handed on in order once hashed")
+}
+
+func TestWithHashWorkers(t *testing.T) {
+	c, err := NewClient(WithHashWorkers(3))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cap(c.hashers))
+
+	c, err = NewClient(WithHashWorkers(0))
+	assert.NoError(t, err)
+	assert.Greater(t, cap(c.hashers), 0)
+}
@@ -0,0 +1,101 @@
+package backupapi
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// Actions a restore takes on a file, as reported by PreviewRestore.
+const (
+	// PreviewCreate creates a file missing from the destination.
+	PreviewCreate = "create"
+	// PreviewOverwrite replaces an existing file which differs.
+	PreviewOverwrite = "overwrite"
+	// PreviewRename renames an existing file which differs and creates the file, by ConflictRename.
+	PreviewRename = "rename"
+	// PreviewSkip keeps an existing file, as it is unchanged or by ConflictSkip. Its mode, owner and times may
+	// still be restored.
+	PreviewSkip = "skip"
+)
+
+// RestorePreview is what a restore would do with the files of its destination, without downloading anything.
+type RestorePreview struct {
+	RecoveryPointID string       `json:"recovery_point_id"`
+	Create          PreviewTotal `json:"create"`
+	Overwrite       PreviewTotal `json:"overwrite"`
+	Rename          PreviewTotal `json:"rename"`
+	Skip            PreviewTotal `json:"skip"`
+	// Files are the files restored by their path in the destination, directories and symlinks are not listed.
+	Files []PreviewFile `json:"files"`
+}
+
+// PreviewTotal is the number of files and their bytes of an action.
+type PreviewTotal struct {
+	Files int64  `json:"files"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// PreviewFile is the action a restore takes on a file.
+type PreviewFile struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Size   uint64 `json:"size"`
+}
+
+// PreviewRestore walks the files of index against destDir and returns the action a restore to destDir takes on each
+// of them with conflict, like restoreFile decides it. Files are sorted by path.
+func PreviewRestore(ctx context.Context, index cache.Index, destDir string, conflict ConflictPolicy) (*RestorePreview, error) {
+	preview := &RestorePreview{RecoveryPointID: index.RecoveryPointID, Files: []PreviewFile{}}
+	for _, item := range index.Items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if item.Type != "file" {
+			continue
+		}
+		target := restoreTarget(destDir, *item)
+		action, err := previewAction(target, *item, conflict)
+		if err != nil {
+			return nil, err
+		}
+		var total *PreviewTotal
+		switch action {
+		case PreviewCreate:
+			total = &preview.Create
+		case PreviewOverwrite:
+			total = &preview.Overwrite
+		case PreviewRename:
+			total = &preview.Rename
+		default:
+			total = &preview.Skip
+		}
+		total.Files++
+		total.Bytes += item.Size
+		preview.Files = append(preview.Files, PreviewFile{Path: target, Action: action, Size: item.Size})
+	}
+	sort.Slice(preview.Files, func(i, j int) bool { return preview.Files[i].Path < preview.Files[j].Path })
+	return preview, nil
+}
+
+// previewAction returns the action restoring file item to target takes.
+func previewAction(target string, item cache.Node, conflict ConflictPolicy) (string, error) {
+	fi, err := os.Stat(target)
+	if os.IsNotExist(err) {
+		return PreviewCreate, nil
+	} else if err != nil {
+		return "", err
+	}
+	if conflict == ConflictSkip {
+		return PreviewSkip, nil
+	}
+	if sameCtime, sameMtime := sameTimes(fi, item); sameCtime || sameMtime {
+		return PreviewSkip, nil
+	}
+	if conflict == ConflictRename {
+		return PreviewRename, nil
+	}
+	return PreviewOverwrite, nil
+}
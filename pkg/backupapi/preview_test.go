@@ -0,0 +1,58 @@
+package backupapi
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestPreviewRestore(t *testing.T) {
+	destDir := t.TempDir()
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	node := func(name string, size uint64) *cache.Node {
+		return &cache.Node{Type: "file", Size: size, ModTime: mtime, ChangeTime: mtime,
+			AbsolutePath: "/data/" + name, BasePath: "/data", RelativePath: filepath.Join("data", name)}
+	}
+	index := cache.Index{RecoveryPointID: "rp1", Items: map[string]*cache.Node{
+		"/data":       {Type: "dir", AbsolutePath: "/data", BasePath: "/data", RelativePath: "data"},
+		"/data/new":   node("new", 10),
+		"/data/same":  node("same", 20),
+		"/data/other": node("other", 30),
+	}}
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "data"), 0700))
+	for _, name := range []string{"same", "other"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(destDir, "data", name), []byte("existing"), 0600))
+	}
+	// same is unchanged since the backup by its modification time
+	require.NoError(t, os.Chtimes(filepath.Join(destDir, "data", "same"), mtime, mtime))
+
+	preview, err := PreviewRestore(context.Background(), index, destDir, "")
+	require.NoError(t, err)
+	assert.Equal(t, "rp1", preview.RecoveryPointID)
+	assert.Equal(t, []PreviewFile{
+		{Path: filepath.Join(destDir, "data", "new"), Action: PreviewCreate, Size: 10},
+		{Path: filepath.Join(destDir, "data", "other"), Action: PreviewOverwrite, Size: 30},
+		{Path: filepath.Join(destDir, "data", "same"), Action: PreviewSkip, Size: 20},
+	}, preview.Files)
+	assert.Equal(t, PreviewTotal{Files: 1, Bytes: 10}, preview.Create)
+	assert.Equal(t, PreviewTotal{Files: 1, Bytes: 30}, preview.Overwrite)
+	assert.Equal(t, PreviewTotal{Files: 1, Bytes: 20}, preview.Skip)
+
+	preview, err = PreviewRestore(context.Background(), index, destDir, ConflictRename)
+	require.NoError(t, err)
+	assert.Equal(t, PreviewTotal{Files: 1, Bytes: 30}, preview.Rename)
+	assert.Zero(t, preview.Overwrite)
+
+	preview, err = PreviewRestore(context.Background(), index, destDir, ConflictSkip)
+	require.NoError(t, err)
+	assert.Equal(t, PreviewTotal{Files: 2, Bytes: 50}, preview.Skip)
+	assert.Equal(t, PreviewTotal{Files: 1, Bytes: 10}, preview.Create)
+}
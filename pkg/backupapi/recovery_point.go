@@ -14,6 +14,10 @@ import (
 const (
 	RecoveryPointTypePoint          = "RECOVERY_POINT"
 	RecoveryPointTypeInitialReplica = "INITIAL_REPLICA"
+	// RecoveryPointTypePartial is a recovery point protecting only some paths of the backup directory.
+	RecoveryPointTypePartial = "PARTIAL"
+	// RecoveryPointTypeStream is a recovery point holding a single byte stream, e.g. the output of a command.
+	RecoveryPointTypeStream = "STREAM"
 
 	RecoveryPointStatusCreated   = "CREATED"
 	RecoveryPointStatusCompleted = "COMPLETED"
@@ -50,12 +54,37 @@ type CreateRecoveryPointRequest struct {
 	PolicyID          string `json:"policy_id"`
 	Name              string `json:"name"`
 	RecoveryPointType string `json:"recovery_point_type"`
+	// IncludedPaths are the paths protected by a partial recovery point, relative to the backup directory.
+	IncludedPaths []string `json:"included_paths,omitempty"`
 }
 
 // CreateRestoreRequest represents a request manual backup.
 type CreateRestoreRequest struct {
 	MachineID string `json:"machine_id"`
-	Path      string `json:"path"`
+	// SourceMachineID is the machine which backed up the recovery point when it is not MachineID, the machine the
+	// recovery point is restored to.
+	SourceMachineID string   `json:"source_machine_id,omitempty"`
+	Path            string   `json:"path"`
+	PriorityPaths   []string `json:"priority_paths,omitempty"`
+	// PathFilter restores only the selected items of the recovery point.
+	PathFilter
+	// Conflict is the policy for existing files which differ from the backed up ones.
+	Conflict ConflictPolicy `json:"conflict,omitempty"`
+	// NoSymlinkEscape restores no symlink pointing outside Path and writes no item through one.
+	NoSymlinkEscape bool `json:"no_symlink_escape,omitempty"`
+	// OwnerMap changes the owners of the restored items.
+	OwnerMap
+	// SkipXattrs restores no extended attributes, POSIX ACLs and security descriptors.
+	SkipXattrs bool `json:"skip_xattrs,omitempty"`
+	// Atomic restores into a staging directory swapped with Path once the restore succeeds.
+	Atomic bool `json:"atomic,omitempty"`
+	// LimitDownload is the KiB per second the restore downloads at instead of limit_download of agent, 0 is
+	// unlimited.
+	LimitDownload *int `json:"limit_download,omitempty"`
+	// Force restores the recovery point even when its trust check fails.
+	Force bool `json:"force,omitempty"`
+	// Target restores the files as objects to a bucket instead of Path.
+	Target *RestoreTarget `json:"target,omitempty"`
 }
 
 // UpdateRecoveryPointRequest represents a request to update a recovery point.
@@ -72,6 +101,10 @@ type RecoveryPointResponse struct {
 	CreatedAt         string `json:"created_at"`
 	UpdatedAt         string `json:"updated_at"`
 	IndexHash         string `json:"index_hash"`
+	PolicyID          string `json:"policy_id,omitempty"`
+	// BaseRecoveryPointID is the recovery point whose full index the delta index of recovery point depends on, as
+	// recorded when recovery point completed.
+	BaseRecoveryPointID string `json:"base_recovery_point_id,omitempty"`
 }
 
 // ListRecoveryPointsResponse get a list recovery point of backup directory id
@@ -95,7 +128,12 @@ func (c *Client) latestRecoveryPointID(backupDirectoryID string) string {
 	return fmt.Sprintf("/agent/backup-directories/%s/latest-recovery-points", backupDirectoryID)
 }
 
+// GetRecoveryPointInfo gets the recovery point, the response is memoized for a short time.
 func (c *Client) GetRecoveryPointInfo(recoveryPointID string) (*RecoveryPointResponse, error) {
+	if v, ok := c.cache.get(cacheKeyRecoveryPoint + recoveryPointID); ok {
+		rp := v.(RecoveryPointResponse)
+		return &rp, nil
+	}
 	req, err := c.NewRequest(http.MethodGet, c.recoveryPointInfo(recoveryPointID), nil)
 	if err != nil {
 		c.logger.Error("err ", zap.Error(err))
@@ -116,6 +154,7 @@ func (c *Client) GetRecoveryPointInfo(recoveryPointID string) (*RecoveryPointRes
 		c.logger.Error("err ", zap.Error(err))
 		return nil, err
 	}
+	c.cache.set(cacheKeyRecoveryPoint+recoveryPointID, lrp)
 	return &lrp, nil
 }
 
@@ -215,6 +254,7 @@ func (c *Client) DeleteRecoveryPoints(ctx context.Context, recoveryPointID strin
 		return err
 	}
 	defer resp.Body.Close()
+	c.cache.invalidate(cacheKeyRecoveryPoint + recoveryPointID)
 
 	return nil
 }
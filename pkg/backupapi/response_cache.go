@@ -0,0 +1,84 @@
+package backupapi
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long responses of recovery point and config reads are reused.
+const DefaultCacheTTL = 30 * time.Second
+
+// Cache key prefixes of memoized responses.
+const (
+	cacheKeyConfig          = "config"
+	cacheKeyRecoveryPoint   = "recovery_point/"
+	cacheKeyBackupDirectory = "backup_directory/"
+)
+
+type cacheEntry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// responseCache memoizes API responses for a short time, so an action reading the same
+// recovery point or config many times only hits the server once.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cacheEntry
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (rc *responseCache) get(key string) (interface{}, bool) {
+	if rc == nil || rc.ttl <= 0 {
+		return nil, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !rc.now().Before(e.expireAt) {
+		delete(rc.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (rc *responseCache) set(key string, value interface{}) {
+	if rc == nil || rc.ttl <= 0 {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries[key] = cacheEntry{value: value, expireAt: rc.now().Add(rc.ttl)}
+}
+
+// invalidate removes entries which key has the given prefix, an empty prefix removes all entries.
+func (rc *responseCache) invalidate(prefix string) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for key := range rc.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+// InvalidateCache drops all memoized responses, it must be called when config of machine is updated.
+func (c *Client) InvalidateCache() {
+	c.cache.invalidate("")
+}
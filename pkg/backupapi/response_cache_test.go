@@ -0,0 +1,37 @@
+package backupapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	now := time.Now()
+	rc := newResponseCache(time.Minute)
+	rc.now = func() time.Time { return now }
+
+	rc.set(cacheKeyRecoveryPoint+"rp1", "foo")
+	rc.set(cacheKeyRecoveryPoint+"rp2", "bar")
+	rc.set(cacheKeyConfig, "config")
+
+	v, ok := rc.get(cacheKeyRecoveryPoint + "rp1")
+	assert.True(t, ok)
+	assert.Equal(t, "foo", v)
+
+	rc.invalidate(cacheKeyRecoveryPoint)
+	_, ok = rc.get(cacheKeyRecoveryPoint + "rp2")
+	assert.False(t, ok)
+	_, ok = rc.get(cacheKeyConfig)
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = rc.get(cacheKeyConfig)
+	assert.False(t, ok)
+
+	disabled := newResponseCache(0)
+	disabled.set(cacheKeyConfig, "config")
+	_, ok = disabled.get(cacheKeyConfig)
+	assert.False(t, ok)
+}
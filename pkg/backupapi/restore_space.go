@@ -0,0 +1,103 @@
+package backupapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/dustin/go-humanize"
+)
+
+// MountUsage is the space a restore needs on one filesystem of the destination.
+type MountUsage struct {
+	MountPoint string
+	Required   uint64
+	Free       uint64
+}
+
+func (u MountUsage) String() string {
+	return fmt.Sprintf("%s needs %s, %s free", u.MountPoint, humanize.Bytes(u.Required), humanize.Bytes(u.Free))
+}
+
+// CheckRestoreSpace simulates the restore of index to destDir and returns the bytes written on each filesystem of
+// the destination, which spans several when mount points are inside it. Holes of sparse files take no space, a file
+// already in place only needs the bytes it grows by, as it is kept or replaced. ErrNotEnoughSpace is returned with
+// the breakdown of all filesystems when one of them has not enough free space.
+func CheckRestoreSpace(ctx context.Context, index cache.Index, destDir string) ([]MountUsage, error) {
+	usages := make(map[string]*MountUsage)
+	mounts := make(map[string]string) // parent directory of items to mount point
+	for _, item := range index.Items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if item.Type != "file" || index.HardLinkTarget(item) != nil {
+			// hard links share space with their targets
+			continue
+		}
+		size := item.Size
+		if item.Sparse && len(item.ChunkMap) == 0 {
+			// holes take no space, the chunk map of a large file is not read so its holes are counted
+			size = 0
+			for _, info := range item.Content {
+				size += uint64(info.Length)
+			}
+		}
+		for _, stream := range item.Streams {
+			size += stream.Size
+		}
+		target := restoreTarget(destDir, *item)
+		if fi, err := os.Lstat(target); err == nil && fi.Mode().IsRegular() {
+			if uint64(fi.Size()) >= size {
+				continue
+			}
+			size -= uint64(fi.Size())
+		}
+		if size == 0 {
+			continue
+		}
+
+		dir := filepath.Dir(target)
+		mountPoint, ok := mounts[dir]
+		if !ok {
+			var err error
+			mountPoint, err = support.MountPoint(dir)
+			if err != nil {
+				return nil, err
+			}
+			mounts[dir] = mountPoint
+		}
+		usage, ok := usages[mountPoint]
+		if !ok {
+			free, err := support.FreeSpace(mountPoint)
+			if err != nil {
+				return nil, err
+			}
+			usage = &MountUsage{MountPoint: mountPoint, Free: free}
+			usages[mountPoint] = usage
+		}
+		usage.Required += size
+	}
+
+	result := make([]MountUsage, 0, len(usages))
+	enough := true
+	for _, usage := range usages {
+		result = append(result, *usage)
+		if usage.Required > usage.Free {
+			enough = false
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].MountPoint < result[j].MountPoint })
+	if !enough {
+		breakdown := make([]string, len(result))
+		for i, usage := range result {
+			breakdown[i] = usage.String()
+		}
+		return result, fmt.Errorf("%w: %s", ErrNotEnoughSpace, strings.Join(breakdown, "; "))
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package backupapi
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+func TestCheckRestoreSpace(t *testing.T) {
+	destDir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(destDir, "existing"), make([]byte, 100), 0600))
+	mountPoint, err := support.MountPoint(destDir)
+	require.NoError(t, err)
+
+	newIndex := func(sizes map[string]uint64) cache.Index {
+		index := cache.Index{Items: map[string]*cache.Node{
+			"/src/dir": {Type: "dir", BasePath: "/src", RelativePath: "dir"},
+		}}
+		for name, size := range sizes {
+			index.Items["/src/"+name] = &cache.Node{Type: "file", Size: size, BasePath: "/src", RelativePath: name}
+		}
+		return index
+	}
+
+	usages, err := CheckRestoreSpace(context.Background(), newIndex(map[string]uint64{"a": 10, "dir/b": 20, "existing": 150}), destDir)
+	require.NoError(t, err)
+	assert.Equal(t, []MountUsage{{MountPoint: mountPoint, Required: 80, Free: usages[0].Free}}, usages)
+
+	usages, err = CheckRestoreSpace(context.Background(), newIndex(map[string]uint64{"existing": 100}), destDir)
+	require.NoError(t, err)
+	assert.Empty(t, usages)
+
+	usages, err = CheckRestoreSpace(context.Background(), newIndex(map[string]uint64{"huge": 1 << 62}), filepath.Join(destDir, "missing", "dir"))
+	assert.True(t, errors.Is(err, ErrNotEnoughSpace))
+	assert.Contains(t, err.Error(), mountPoint+" needs")
+	require.Len(t, usages, 1)
+	assert.Equal(t, uint64(1<<62), usages[0].Required)
+
+	_, err = os.Stat(filepath.Join(destDir, "missing"))
+	assert.True(t, os.IsNotExist(err), "simulation must not create the destination")
+}
@@ -0,0 +1,193 @@
+package backupapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// RestoreTargetS3 restores files as whole objects to a bucket of S3 compatible storage.
+const RestoreTargetS3 = "S3"
+
+// restoreTargetID is the storage vault ID of restore targets, which are not storage vaults of backup server.
+const restoreTargetID = "restore-target"
+
+// ErrInvalidRestoreTarget is returned when a restore target is of unknown type or misses its bucket or credential.
+var ErrInvalidRestoreTarget = errors.New("invalid restore target")
+
+// RestoreTarget is where a recovery point is restored instead of the local disk of machine, e.g. a customer bucket
+// feeding a data lake. Files are reassembled from their chunks and written as whole objects keyed by their path
+// relative to the backup directory under Prefix, directories and symlinks are not restored.
+type RestoreTarget struct {
+	Type       string                   `json:"type"`
+	Bucket     string                   `json:"bucket"`
+	Prefix     string                   `json:"prefix,omitempty"`
+	Credential storage_vault.Credential `json:"credential"`
+}
+
+// Check returns ErrInvalidRestoreTarget when the target can not be restored to.
+func (t RestoreTarget) Check() error {
+	if t.Type != RestoreTargetS3 {
+		return fmt.Errorf("%w: type %q is not supported, only %s", ErrInvalidRestoreTarget, t.Type, RestoreTargetS3)
+	}
+	if t.Bucket == "" {
+		return fmt.Errorf("%w: missing bucket", ErrInvalidRestoreTarget)
+	}
+	if t.Credential.AwsAccessKeyId == "" || t.Credential.AwsSecretAccessKey == "" {
+		return fmt.Errorf("%w: missing credential", ErrInvalidRestoreTarget)
+	}
+	return nil
+}
+
+// StorageVault returns the target as a storage vault the restored objects are put to.
+func (t RestoreTarget) StorageVault() StorageVault {
+	prefix := t.Prefix
+	if prefix == "" {
+		// the root of bucket, key_prefix of agent is only for storage vaults of backup server
+		prefix = "/"
+	}
+	return StorageVault{
+		ID:               restoreTargetID,
+		Name:             t.Bucket,
+		StorageVaultType: t.Type,
+		StorageBucket:    t.Bucket,
+		KeyPrefix:        prefix,
+		Credential:       t.Credential,
+	}
+}
+
+// restoreObjectKey returns the key of the object item is restored to.
+func restoreObjectKey(item cache.Node) string {
+	return path.Clean(filepath.ToSlash(item.RelativePath))
+}
+
+// RestoreToVault restores files of index as objects of target, their chunks are downloaded from storageVault.
+// Items in restored are skipped, the items restored are added to it.
+func (c *Client) RestoreToVault(ctx context.Context, index cache.Index, target storage_vault.StorageVault, storageVault storage_vault.StorageVault,
+	restoreKey *AuthRestore, p *progress.Progress, restored *RestoredItems) error {
+	paths := make([]string, 0, len(index.Items))
+	for name := range index.Items {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	numGoroutine := viper.GetInt("num_goroutine")
+	if numGoroutine == 0 {
+		numGoroutine = int(float64(runtime.NumCPU()) * 0.2)
+		if numGoroutine <= 1 {
+			numGoroutine = 2
+		}
+	}
+	sem := semaphore.NewWeighted(int64(numGoroutine))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for _, name := range paths {
+		item := index.Items[name]
+		if item.Type != "file" || restored.Has(item.AbsolutePath) {
+			p.Report(progress.Stat{Items: 1, Bytes: item.Size})
+			continue
+		}
+		if err := sem.Acquire(groupCtx, 1); err != nil {
+			break
+		}
+		group.Go(func() error {
+			defer sem.Release(1)
+			if err := c.restoreObject(groupCtx, target, restoreObjectKey(*item), *item, storageVault, restoreKey, p); err != nil {
+				c.logger.Error("Restore object error", zap.Error(err), zap.String("item name", item.AbsolutePath))
+				p.Report(progress.Stat{Errors: true})
+				return err
+			}
+			restored.Add(item.AbsolutePath)
+			p.Report(progress.Stat{Items: 1})
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return ErrorGotCancelRequest
+	}
+	return nil
+}
+
+// restoreObject puts content of file item to key of target, it is streamed from its chunks in order without holding
+// the file in memory. Holes of sparse files are written as zeros.
+func (c *Client) restoreObject(ctx context.Context, target storage_vault.StorageVault, key string, item cache.Node,
+	storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(c.writeContent(ctx, w, item, storageVault, restoreKey, p))
+	}()
+	err := target.PutObjectStream(ctx, key, r, int64(item.Size))
+	_ = r.CloseWithError(err)
+	return err
+}
+
+// writeContent writes the content of file item to w, its chunks in order and its holes as zeros. The chunk map of a
+// large file is read page by page.
+func (c *Client) writeContent(ctx context.Context, w io.Writer, item cache.Node,
+	storageVault storage_vault.StorageVault, restoreKey *AuthRestore, p *progress.Progress) error {
+	content := make([]*cache.ChunkInfo, len(item.Content))
+	copy(content, item.Content)
+	sort.Slice(content, func(i, j int) bool { return content[i].Start < content[j].Start })
+	item.Content = content
+
+	var offset uint64
+	err := c.eachChunk(ctx, storageVault, restoreKey, &item, func(info *cache.ChunkInfo) error {
+		if hole := info.Start - offset; info.Start > offset {
+			if err := writeZeros(w, hole); err != nil {
+				return err
+			}
+			p.Report(progress.Stat{Bytes: hole})
+		}
+		data, cached, err := c.getCachedChunk(ctx, storageVault, info, restoreKey)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		offset = info.Start + uint64(len(data))
+		p.Report(chunkStat(info, cached))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if item.Size > offset {
+		if err := writeZeros(w, item.Size-offset); err != nil {
+			return err
+		}
+		p.Report(progress.Stat{Bytes: item.Size - offset})
+	}
+	return nil
+}
+
+func writeZeros(w io.Writer, n uint64) error {
+	zeros := make([]byte, 32<<10)
+	for n > 0 {
+		b := zeros
+		if n < uint64(len(b)) {
+			b = b[:n]
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= uint64(len(b))
+	}
+	return nil
+}
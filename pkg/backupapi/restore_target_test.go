@@ -0,0 +1,76 @@
+package backupapi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+func TestRestoreTarget_Check(t *testing.T) {
+	credential := storage_vault.Credential{AwsAccessKeyId: "key", AwsSecretAccessKey: "secret"}
+	assert.NoError(t, RestoreTarget{Type: RestoreTargetS3, Bucket: "lake", Credential: credential}.Check())
+	for _, target := range []RestoreTarget{
+		{Type: "FTP", Bucket: "lake", Credential: credential},
+		{Type: RestoreTargetS3, Credential: credential},
+		{Type: RestoreTargetS3, Bucket: "lake"},
+	} {
+		assert.True(t, errors.Is(target.Check(), ErrInvalidRestoreTarget), target)
+	}
+
+	vault := RestoreTarget{Type: RestoreTargetS3, Bucket: "lake", Credential: credential}.StorageVault()
+	assert.Equal(t, "lake", vault.StorageBucket)
+	assert.Equal(t, "a/b.txt", storage_vault.ObjectKey(vault.KeyPrefix, "a/b.txt"), "key_prefix of agent is not prepended")
+}
+
+func TestClient_RestoreToVault(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	source := newMemoryVault()
+	chunk := func(data []byte, start uint64) *cache.ChunkInfo {
+		key := chunkKey(data)
+		require.NoError(t, source.PutObject(context.Background(), key, data))
+		return &cache.ChunkInfo{Etag: key, Start: start, Length: uint(len(data))}
+	}
+	first, second := []byte("hello "), []byte("world")
+	index := cache.Index{Items: map[string]*cache.Node{
+		"/data":          {Type: "dir", AbsolutePath: "/data", RelativePath: "."},
+		"/data/a.txt":    {Type: "file", AbsolutePath: "/data/a.txt", RelativePath: "a.txt", Size: 11, Content: []*cache.ChunkInfo{chunk(second, 6), chunk(first, 0)}},
+		"/data/sub/b":    {Type: "file", AbsolutePath: "/data/sub/b", RelativePath: "sub/b", Size: 16, Sparse: true, Content: []*cache.ChunkInfo{chunk(first, 4)}},
+		"/data/link":     {Type: "symlink", AbsolutePath: "/data/link", RelativePath: "link", LinkTarget: "a.txt"},
+		"/data/restored": {Type: "file", AbsolutePath: "/data/restored", RelativePath: "restored", Size: 5, Content: []*cache.ChunkInfo{chunk(second, 0)}},
+	}}
+	restored := NewRestoredItems()
+	restored.Add("/data/restored")
+
+	target := newMemoryVault()
+	require.NoError(t, c.RestoreToVault(context.Background(), index, target, source, nil, nil, restored))
+
+	assert.Len(t, target.objects, 2)
+	assert.Equal(t, []byte("hello world"), target.objects["a.txt"])
+	want := append(append(make([]byte, 4), first...), make([]byte, 6)...)
+	assert.True(t, bytes.Equal(want, target.objects["sub/b"]), target.objects["sub/b"])
+	assert.True(t, restored.Has("/data/a.txt"))
+
+	// a failed upload fails the restore
+	err = c.RestoreToVault(context.Background(), index, failingPutVault{newMemoryVault()}, source, nil, nil, NewRestoredItems())
+	assert.Error(t, err)
+}
+
+// failingPutVault fails the objects put to it.
+type failingPutVault struct {
+	*memoryVault
+}
+
+func (v failingPutVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	return errors.New("access denied")
+}
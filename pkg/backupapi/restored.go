@@ -0,0 +1,163 @@
+package backupapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RestoredItems is the set of items restored by a restore, a resumed restore skips them. The chunks written to files
+// which are not restored completely are recorded too, a resumed restore only downloads the other chunks of these
+// files. Items are identified by their absolute path at backup time.
+type RestoredItems struct {
+	mu     sync.Mutex
+	paths  map[string]bool
+	chunks map[string]map[uint64]bool
+}
+
+// restoredItemsJSON is the JSON encoding of RestoredItems, chunks are given by their offset in file.
+type restoredItemsJSON struct {
+	Items  []string            `json:"items"`
+	Chunks map[string][]uint64 `json:"chunks,omitempty"`
+}
+
+// NewRestoredItems returns an empty set of restored items.
+func NewRestoredItems() *RestoredItems {
+	return &RestoredItems{paths: make(map[string]bool), chunks: make(map[string]map[uint64]bool)}
+}
+
+// Add records the item at path is restored. It is a no-op on a nil set.
+func (r *RestoredItems) Add(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths[path] = true
+	delete(r.chunks, path)
+}
+
+// AddChunk records the chunk at offset start of the file at path is written. It is a no-op on a nil set.
+func (r *RestoredItems) AddChunk(path string, start uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.chunks[path] == nil {
+		r.chunks[path] = make(map[uint64]bool)
+	}
+	r.chunks[path][start] = true
+}
+
+// HasChunk reports whether the chunk at offset start of the file at path is written.
+func (r *RestoredItems) HasChunk(path string, start uint64) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.chunks[path][start]
+}
+
+// Partial reports whether some chunks of the file at path are written, but the file is not restored completely.
+func (r *RestoredItems) Partial(path string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.chunks[path]) > 0
+}
+
+// DropChunks forgets the chunks written to the file at path, e.g. as the file is written again from its start.
+func (r *RestoredItems) DropChunks(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.chunks, path)
+}
+
+// Has reports whether the item at path is restored.
+func (r *RestoredItems) Has(path string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paths[path]
+}
+
+// Len returns the number of restored items.
+func (r *RestoredItems) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.paths)
+}
+
+// MarshalJSON encodes the restored items and chunks, so they can be saved to a restore state.
+func (r *RestoredItems) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := restoredItemsJSON{Items: make([]string, 0, len(r.paths))}
+	for path := range r.paths {
+		v.Items = append(v.Items, path)
+	}
+	if len(r.chunks) > 0 {
+		v.Chunks = make(map[string][]uint64, len(r.chunks))
+		for path, chunks := range r.chunks {
+			for start := range chunks {
+				v.Chunks[path] = append(v.Chunks[path], start)
+			}
+		}
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes restored items and chunks encoded by MarshalJSON.
+func (r *RestoredItems) UnmarshalJSON(b []byte) error {
+	var v restoredItemsJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paths = make(map[string]bool, len(v.Items))
+	for _, path := range v.Items {
+		r.paths[path] = true
+	}
+	r.chunks = make(map[string]map[uint64]bool, len(v.Chunks))
+	for path, starts := range v.Chunks {
+		r.chunks[path] = make(map[uint64]bool, len(starts))
+		for _, start := range starts {
+			r.chunks[path][start] = true
+		}
+	}
+	return nil
+}
+
+// IsDestinationUnwritable reports whether err is caused by a restore destination which is full or read-only, the
+// restore can be resumed once the destination is fixed.
+func IsDestinationUnwritable(err error) bool {
+	return errors.Is(err, ErrDestinationFull) || errors.Is(err, ErrDestinationReadOnly)
+}
+
+// destinationError wraps err with ErrDestinationFull or ErrDestinationReadOnly when the destination can not be
+// written.
+func destinationError(err error) error {
+	switch {
+	case err == nil || IsDestinationUnwritable(err):
+		return err
+	case isDestinationFull(err):
+		return fmt.Errorf("%w: %v", ErrDestinationFull, err)
+	case isDestinationReadOnly(err):
+		return fmt.Errorf("%w: %v", ErrDestinationReadOnly, err)
+	}
+	return err
+}
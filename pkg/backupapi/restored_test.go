@@ -0,0 +1,112 @@
+package backupapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestDestinationError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("errno of full and read-only disks differ on Windows")
+	}
+	full := destinationError(&os.PathError{Op: "write", Path: "/restore/a", Err: syscall.ENOSPC})
+	assert.True(t, errors.Is(full, ErrDestinationFull))
+	assert.True(t, IsDestinationUnwritable(full))
+	assert.Same(t, full, destinationError(full))
+
+	readOnly := destinationError(&os.PathError{Op: "open", Path: "/restore/a", Err: syscall.EROFS})
+	assert.True(t, errors.Is(readOnly, ErrDestinationReadOnly))
+	assert.True(t, IsDestinationUnwritable(readOnly))
+
+	assert.False(t, IsDestinationUnwritable(destinationError(ErrCorruptedChunk)))
+	assert.NoError(t, destinationError(nil))
+}
+
+func TestRestoreItemsSkipRestored(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	destDir := t.TempDir()
+	var items []*cache.Node
+	for _, name := range []string{"a", "b"} {
+		items = append(items, &cache.Node{Type: "dir", Mode: os.ModeDir | 0700, AbsolutePath: "/data/" + name,
+			RelativePath: filepath.Join("data", name), BasePath: "/data"})
+	}
+	restored := NewRestoredItems()
+	restored.Add("/data/a")
+
+	require.NoError(t, c.restoreItems(context.Background(), items, destDir, nil, nil, nil, restored, "", false))
+	_, err = os.Stat(filepath.Join(destDir, "data", "a"))
+	assert.True(t, os.IsNotExist(err), "restored item is skipped")
+	_, err = os.Stat(filepath.Join(destDir, "data", "b"))
+	assert.NoError(t, err)
+	assert.True(t, restored.Has("/data/b"))
+	assert.Equal(t, 2, restored.Len())
+}
+
+func TestRestoredItemsChunks(t *testing.T) {
+	restored := NewRestoredItems()
+	restored.Add("/data/a")
+	restored.AddChunk("/data/b", 0)
+	restored.AddChunk("/data/b", 4096)
+	assert.True(t, restored.Partial("/data/b"))
+	assert.True(t, restored.HasChunk("/data/b", 4096))
+	assert.False(t, restored.HasChunk("/data/b", 8192))
+
+	buf, err := json.Marshal(restored)
+	require.NoError(t, err)
+	decoded := NewRestoredItems()
+	require.NoError(t, json.Unmarshal(buf, decoded))
+	assert.True(t, decoded.Has("/data/a"))
+	assert.Equal(t, 1, decoded.Len())
+	assert.True(t, decoded.HasChunk("/data/b", 0))
+	assert.True(t, decoded.HasChunk("/data/b", 4096))
+
+	// a restored file does not need its chunks anymore
+	decoded.Add("/data/b")
+	assert.False(t, decoded.Partial("/data/b"))
+	decoded.AddChunk("/data/c", 0)
+	decoded.DropChunks("/data/c")
+	assert.False(t, decoded.Partial("/data/c"))
+
+	var none *RestoredItems
+	none.AddChunk("/data/b", 0)
+	assert.False(t, none.HasChunk("/data/b", 0))
+	assert.False(t, none.Partial("/data/b"))
+}
+
+func TestResumeFile(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	// a file whose chunks are written already is finished without downloading them
+	target := filepath.Join(t.TempDir(), "a")
+	require.NoError(t, ioutil.WriteFile(target, []byte("partial"), 0600))
+	item := cache.Node{Type: "file", AbsolutePath: "/data/a", Mode: 0600, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+		Size: 7, Content: []*cache.ChunkInfo{{Start: 0, Length: 7, Etag: "missing"}}}
+	restored := NewRestoredItems()
+	restored.AddChunk("/data/a", 0)
+	require.NoError(t, c.restoreFile(context.Background(), target, item, nil, nil, nil, restored, ConflictSkip))
+	buf, err := ioutil.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "partial", string(buf), "conflict policy does not apply to partial files")
+
+	// a partial file which is gone is written from its start
+	require.NoError(t, os.Remove(target))
+	item.Content = nil
+	require.NoError(t, c.restoreFile(context.Background(), target, item, nil, nil, nil, restored, ConflictSkip))
+	assert.False(t, restored.Partial("/data/a"))
+}
@@ -0,0 +1,77 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// ErrUnsafePath is returned when an item of an index would be restored outside the destination directory, e.g. an
+// index tampered with to hold ".." components or items under a symlink.
+var ErrUnsafePath = errors.New("unsafe path in index")
+
+// CheckRestorePaths returns ErrUnsafePath when an item of index would be restored outside destDir, or beneath a
+// symlink restored by index, which could point anywhere. A scan never descends into symlinks, so such items are
+// only found in tampered indexes.
+func CheckRestorePaths(index cache.Index, destDir string) error {
+	destDir = filepath.Clean(destDir)
+	symlinks := make(map[string]bool)
+	for _, item := range index.Items {
+		if item.Type == "symlink" {
+			symlinks[restoreTarget(destDir, *item)] = true
+		}
+	}
+	for _, item := range index.Items {
+		target := restoreTarget(destDir, *item)
+		if filepath.IsAbs(item.RelativePath) || !pathWithin(destDir, target) {
+			return fmt.Errorf("%w: %s is restored outside %s", ErrUnsafePath, item.AbsolutePath, destDir)
+		}
+		for dir := filepath.Dir(target); pathWithin(destDir, dir) && dir != destDir; dir = filepath.Dir(dir) {
+			if symlinks[dir] {
+				return fmt.Errorf("%w: %s is restored beneath symlink %s", ErrUnsafePath, item.AbsolutePath, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// pathWithin reports whether path is root or beneath it, both clean.
+func pathWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// symlinkEscapes reports whether symlink target restored in destDir points outside of it.
+func symlinkEscapes(destDir, target, linkTarget string) bool {
+	if !filepath.IsAbs(linkTarget) {
+		linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
+	}
+	return !pathWithin(destDir, filepath.Clean(linkTarget))
+}
+
+// resolvesWithin reports whether the parent directory of target, with the symlinks already on disk resolved, is in
+// destDir with its own symlinks resolved. It catches symlinks of the destination which point outside of it.
+func resolvesWithin(destDir, target string) bool {
+	return pathWithin(evalExisting(destDir), evalExisting(filepath.Dir(target)))
+}
+
+// evalExisting resolves the symlinks of the longest part of path which exists, the rest is kept as it is.
+func evalExisting(path string) string {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(path)
+		if parent == path || !os.IsNotExist(err) {
+			return filepath.Join(append([]string{path}, rest...)...)
+		}
+		rest = append([]string{filepath.Base(path)}, rest...)
+		path = parent
+	}
+}
@@ -0,0 +1,68 @@
+package backupapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestCheckRestorePaths(t *testing.T) {
+	item := func(path, relativePath, typ string) *cache.Node {
+		return &cache.Node{Type: typ, AbsolutePath: path, BasePath: "/data", RelativePath: relativePath}
+	}
+	index := func(items ...*cache.Node) cache.Index {
+		index := cache.Index{Items: map[string]*cache.Node{}}
+		for _, item := range items {
+			index.Items[item.AbsolutePath] = item
+		}
+		return index
+	}
+
+	safe := index(item("/data", "data", "dir"), item("/data/a", "data/a", "file"), item("/data/link", "data/link", "symlink"))
+	assert.NoError(t, CheckRestorePaths(safe, "/restore"))
+	assert.NoError(t, CheckRestorePaths(safe, "/data"))
+
+	assert.ErrorIs(t, CheckRestorePaths(index(item("/data/a", "data/../../etc/passwd", "file")), "/restore"), ErrUnsafePath)
+	assert.ErrorIs(t, CheckRestorePaths(index(item("/etc/passwd", "data/a", "file")), "/data"), ErrUnsafePath,
+		"restored in place outside of base path")
+	assert.ErrorIs(t, CheckRestorePaths(index(item("/data/a", "/data/a", "file")), "/restore"), ErrUnsafePath)
+	assert.ErrorIs(t, CheckRestorePaths(index(item("/data/link", "data/link", "symlink"), item("/data/link/passwd", "data/link/passwd", "file")), "/restore"),
+		ErrUnsafePath, "beneath a symlink")
+}
+
+func TestSymlinkEscapes(t *testing.T) {
+	assert.False(t, symlinkEscapes("/restore", "/restore/data/link", "a"))
+	assert.False(t, symlinkEscapes("/restore", "/restore/data/link", "../b"))
+	assert.False(t, symlinkEscapes("/restore", "/restore/data/link", "/restore/b"))
+	assert.True(t, symlinkEscapes("/restore", "/restore/data/link", "../../etc"))
+	assert.True(t, symlinkEscapes("/restore", "/restore/data/link", "/etc"))
+}
+
+func TestRestoreItemNoSymlinkEscape(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+	destDir, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(destDir, "data"), 0700))
+
+	link := cache.Node{Type: "symlink", Mode: os.ModeSymlink | 0777, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+		AbsolutePath: "/data/link", BasePath: "/data", RelativePath: "data/link", LinkTarget: outside}
+	require.NoError(t, c.RestoreItem(context.Background(), destDir, link, nil, nil, nil, nil, "", true))
+	_, err = os.Lstat(filepath.Join(destDir, "data", "link"))
+	assert.True(t, os.IsNotExist(err), "symlink outside of destination is skipped")
+
+	// a symlink of the destination pointing outside of it
+	require.NoError(t, os.Symlink(outside, filepath.Join(destDir, "data", "out")))
+	dir := cache.Node{Type: "dir", Mode: 0700, UID: uint32(os.Getuid()), GID: uint32(os.Getgid()),
+		AbsolutePath: "/data/out/dir", BasePath: "/data", RelativePath: "data/out/dir"}
+	assert.ErrorIs(t, c.RestoreItem(context.Background(), destDir, dir, nil, nil, nil, nil, "", true), ErrUnsafePath)
+	require.NoError(t, c.RestoreItem(context.Background(), destDir, dir, nil, nil, nil, nil, "", false))
+	_, err = os.Stat(filepath.Join(outside, "dir"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,55 @@
+package backupapi
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// sampleChunks returns up to n distinct chunks of the files and streams of index picked at random by r. The pages of
+// chunk maps are sampled, not the chunks they list.
+func sampleChunks(index cache.Index, n int, r *rand.Rand) []*cache.ChunkInfo {
+	paths := make([]string, 0, len(index.Items))
+	for path := range index.Items {
+		paths = append(paths, path)
+	}
+	// a sample only depends on r
+	sort.Strings(paths)
+	var chunks []*cache.ChunkInfo
+	seen := make(map[string]bool)
+	add := func(content []*cache.ChunkInfo) {
+		for _, chunk := range content {
+			if !seen[chunk.Etag] {
+				seen[chunk.Etag] = true
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+	for _, path := range paths {
+		item := index.Items[path]
+		add(item.Content)
+		add(item.ChunkMap)
+		for _, stream := range item.Streams {
+			add(stream.Content)
+		}
+	}
+	if n >= len(chunks) {
+		return chunks
+	}
+	r.Shuffle(len(chunks), func(i, j int) { chunks[i], chunks[j] = chunks[j], chunks[i] })
+	return chunks[:n]
+}
+
+// SpotCheckChunks downloads n chunks of index picked at random by r and checks their content against their keys,
+// as GetChunk does. It returns ErrCorruptedChunk when one of them is corrupted.
+func (c *Client) SpotCheckChunks(ctx context.Context, storageVault storage_vault.StorageVault, index cache.Index, n int, r *rand.Rand, restoreKey *AuthRestore) error {
+	for _, chunk := range sampleChunks(index, n, r) {
+		if _, err := c.GetChunk(ctx, storageVault, chunk, restoreKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
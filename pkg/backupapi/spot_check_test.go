@@ -0,0 +1,56 @@
+package backupapi
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func spotCheckIndex(t *testing.T, vault *memoryVault, contents ...string) cache.Index {
+	index := *cache.NewIndex("bd", "rp")
+	for i, content := range contents {
+		data := []byte(content)
+		key := chunkKey(data)
+		require.NoError(t, vault.PutObject(context.Background(), key, data))
+		chunk := &cache.ChunkInfo{Etag: key, Length: uint(len(data))}
+		path := string(rune('a' + i))
+		index.Items[path] = &cache.Node{Type: "file", Content: []*cache.ChunkInfo{chunk}}
+		// the same chunk in a stream is sampled once
+		index.Items[path+".dup"] = &cache.Node{Type: "file", Streams: []*cache.Stream{{Name: "s", Content: []*cache.ChunkInfo{chunk}}}}
+	}
+	return index
+}
+
+func Test_sampleChunks(t *testing.T) {
+	index := spotCheckIndex(t, newMemoryVault(), "one", "two", "three", "four")
+
+	all := sampleChunks(index, 10, rand.New(rand.NewSource(1)))
+	assert.Len(t, all, 4)
+
+	sample := sampleChunks(index, 2, rand.New(rand.NewSource(1)))
+	require.Len(t, sample, 2)
+	assert.NotEqual(t, sample[0].Etag, sample[1].Etag)
+	assert.Equal(t, sample, sampleChunks(index, 2, rand.New(rand.NewSource(1))))
+
+	assert.Empty(t, sampleChunks(index, 0, rand.New(rand.NewSource(1))))
+}
+
+func TestClient_SpotCheckChunks(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	vault := newMemoryVault()
+	index := spotCheckIndex(t, vault, "one", "two", "three")
+	require.NoError(t, c.SpotCheckChunks(context.Background(), vault, index, 2, rand.New(rand.NewSource(1)), nil))
+
+	corrupt := &corruptVault{memoryVault: vault}
+	err = c.SpotCheckChunks(context.Background(), corrupt, index, 2, rand.New(rand.NewSource(1)), nil)
+	assert.True(t, errors.Is(err, ErrCorruptedChunk))
+}
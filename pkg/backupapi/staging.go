@@ -0,0 +1,106 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// ErrAtomicRestore is returned when the destination of an atomic restore cannot be swapped with its staging
+// directory.
+var ErrAtomicRestore = errors.New("atomic restore not possible")
+
+// StagingDir returns the directory an atomic restore to destDir writes to, it is swapped with destDir once the
+// restore succeeds.
+func StagingDir(destDir string) string {
+	return filepath.Clean(destDir) + ".restore-tmp"
+}
+
+// CheckAtomic returns ErrAtomicRestore when a restore with filter and priorityPaths of a recovery point of
+// recoveryPointType can not be atomic. The swap replaces the whole destination, so the restore must bring back every
+// item of a complete recovery point, and priority paths are pointless as nothing shows before the swap.
+func CheckAtomic(filter PathFilter, priorityPaths []string, recoveryPointType string) error {
+	switch {
+	case !filter.IsEmpty():
+		return fmt.Errorf("%w: path filters would remove the files they leave out", ErrAtomicRestore)
+	case len(priorityPaths) > 0:
+		return fmt.Errorf("%w: priority paths are not restored before the swap", ErrAtomicRestore)
+	case recoveryPointType == RecoveryPointTypePartial:
+		return fmt.Errorf("%w: partial recovery point would remove the files it misses", ErrAtomicRestore)
+	}
+	return nil
+}
+
+// PrepareStaging checks destDir can be swapped and creates its staging directory. A staging directory left by a
+// failed restore is removed unless keep is set, when the restore resumes into it.
+func PrepareStaging(destDir string, keep bool) (string, error) {
+	destDir = filepath.Clean(destDir)
+	if filepath.Dir(destDir) == destDir {
+		return "", fmt.Errorf("%w: %s is the root of filesystem", ErrAtomicRestore, destDir)
+	}
+	mode := os.FileMode(0755)
+	fi, err := os.Stat(destDir)
+	switch {
+	case err == nil && !fi.IsDir():
+		return "", fmt.Errorf("%w: %s is not a directory", ErrAtomicRestore, destDir)
+	case err == nil:
+		mode = fi.Mode().Perm()
+		if mounted, err := isMountPoint(destDir, fi); err != nil {
+			return "", err
+		} else if mounted {
+			return "", fmt.Errorf("%w: %s is a mount point", ErrAtomicRestore, destDir)
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	staging := StagingDir(destDir)
+	if !keep {
+		if err := os.RemoveAll(staging); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(staging, mode); err != nil {
+		return "", err
+	}
+	return staging, nil
+}
+
+// isMountPoint reports whether directory dir with info fi is on another device than its parent. It is false where
+// devices are not known.
+func isMountPoint(dir string, fi os.FileInfo) (bool, error) {
+	device, ok := support.DeviceID(fi)
+	if !ok {
+		return false, nil
+	}
+	pfi, err := os.Stat(filepath.Dir(dir))
+	if err != nil {
+		return false, err
+	}
+	parent, _ := support.DeviceID(pfi)
+	return parent != device, nil
+}
+
+// SwapStaging replaces destDir by its staging directory, atomically where the filesystem supports it. The previous
+// content of destDir is left in the staging directory, for RemoveStaging. destDir is left untouched when the swap
+// fails.
+func SwapStaging(destDir string) error {
+	destDir = filepath.Clean(destDir)
+	staging := StagingDir(destDir)
+	if _, err := os.Lstat(destDir); os.IsNotExist(err) {
+		return os.Rename(staging, destDir)
+	} else if err != nil {
+		return err
+	}
+	if err := support.ExchangePaths(staging, destDir); err != nil {
+		return fmt.Errorf("%w: swap %s with %s: %v", ErrAtomicRestore, staging, destDir, err)
+	}
+	return nil
+}
+
+// RemoveStaging removes the staging directory of destDir, which holds the previous content of destDir after a swap.
+func RemoveStaging(destDir string) error {
+	return os.RemoveAll(StagingDir(destDir))
+}
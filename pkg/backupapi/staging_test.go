@@ -0,0 +1,100 @@
+package backupapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareStaging(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "www")
+	require.NoError(t, os.Mkdir(dest, 0750))
+
+	staging, err := PrepareStaging(dest, false)
+	require.NoError(t, err)
+	assert.Equal(t, dest+".restore-tmp", staging)
+	fi, err := os.Stat(staging)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), fi.Mode().Perm(), "staging directory has the mode of destination")
+
+	// a resumed restore keeps what it restored, another one starts over
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "a"), []byte("a"), 0600))
+	_, err = PrepareStaging(dest, true)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(staging, "a"))
+	_, err = PrepareStaging(dest, false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(staging, "a"))
+
+	_, err = PrepareStaging(string(filepath.Separator), false)
+	assert.True(t, errors.Is(err, ErrAtomicRestore))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600))
+	_, err = PrepareStaging(filepath.Join(dir, "file"), false)
+	assert.True(t, errors.Is(err, ErrAtomicRestore))
+}
+
+func TestSwapStaging(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "www")
+	require.NoError(t, os.Mkdir(dest, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "old"), []byte("old"), 0600))
+
+	staging, err := PrepareStaging(dest, false)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(staging, "new"), []byte("new"), 0600))
+	require.NoError(t, SwapStaging(dest))
+
+	assert.FileExists(t, filepath.Join(dest, "new"))
+	assert.NoFileExists(t, filepath.Join(dest, "old"))
+	assert.FileExists(t, filepath.Join(staging, "old"), "previous content is kept until the staging directory is removed")
+	require.NoError(t, RemoveStaging(dest))
+	assert.NoDirExists(t, staging)
+
+	// a destination which does not exist yet is the staging directory renamed
+	missing := filepath.Join(dir, "missing")
+	staging, err = PrepareStaging(missing, false)
+	require.NoError(t, err)
+	require.NoError(t, SwapStaging(missing))
+	assert.DirExists(t, missing)
+	assert.NoDirExists(t, staging)
+}
+
+func TestCheckAtomic(t *testing.T) {
+	assert.NoError(t, CheckAtomic(PathFilter{}, nil, RecoveryPointTypePoint))
+	assert.NoError(t, CheckAtomic(PathFilter{}, nil, RecoveryPointTypeInitialReplica))
+
+	for _, err := range []error{
+		CheckAtomic(PathFilter{Include: []string{"/srv/www/html"}}, nil, RecoveryPointTypePoint),
+		CheckAtomic(PathFilter{Exclude: []string{"*.log"}}, nil, RecoveryPointTypePoint),
+		CheckAtomic(PathFilter{File: "/srv/www/index.html"}, nil, RecoveryPointTypePoint),
+		CheckAtomic(PathFilter{}, []string{"/srv/www/html"}, RecoveryPointTypePoint),
+		CheckAtomic(PathFilter{}, nil, RecoveryPointTypePartial),
+	} {
+		assert.True(t, errors.Is(err, ErrAtomicRestore))
+	}
+}
+
+func TestIsMountPoint(t *testing.T) {
+	dir := t.TempDir()
+	fi, err := os.Stat(dir)
+	require.NoError(t, err)
+	mounted, err := isMountPoint(dir, fi)
+	require.NoError(t, err)
+	assert.False(t, mounted)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	fi, err = os.Stat("/proc")
+	require.NoError(t, err)
+	mounted, err = isMountPoint("/proc", fi)
+	require.NoError(t, err)
+	assert.True(t, mounted)
+}
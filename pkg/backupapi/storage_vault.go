@@ -1,15 +1,25 @@
 package backupapi
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/cenkalti/backoff"
 
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/compression"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -25,7 +35,19 @@ type StorageVault struct {
 	UpdatedAt        string                   `json:"updated_at"`
 	Deleted          bool                     `json:"deleted"`
 	EncryptionKey    string                   `json:"encryption_key"`
+	KeyPrefix        string                   `json:"key_prefix,omitempty"`
+	LocalPath        string                   `json:"local_path,omitempty"`
 	Credential       storage_vault.Credential `json:"credential"`
+	// Mirrors are storage vaults which keep a second copy of every object.
+	Mirrors []StorageVault `json:"mirrors,omitempty"`
+	// StorageClass is the S3 storage class (e.g. STANDARD_IA, GLACIER_IR) of uploaded objects, set by the policy
+	// of recovery point.
+	StorageClass string `json:"storage_class,omitempty"`
+	// ObjectLockMode (GOVERNANCE or COMPLIANCE) and ObjectLockRetentionDays set the retention of objects uploaded
+	// to an Object Lock enabled bucket, ObjectLockLegalHold places a legal hold on them.
+	ObjectLockMode          string `json:"object_lock_mode,omitempty"`
+	ObjectLockRetentionDays int    `json:"object_lock_retention_days,omitempty"`
+	ObjectLockLegalHold     bool   `json:"object_lock_legal_hold,omitempty"`
 }
 
 type AuthRestore struct {
@@ -113,20 +135,20 @@ func (c *Client) GetCredentialStorageVault(storageVaultID string, actionID strin
 	return &vault, nil
 }
 
-// PutObject stores the data to the storage vault.
-func (c *Client) PutObject(storageVault storage_vault.StorageVault, key string, data []byte) error {
+// PutObject stores the data to the storage vault, it stops retrying when ctx is done.
+func (c *Client) PutObject(ctx context.Context, storageVault storage_vault.StorageVault, key string, data []byte) error {
 	var err error
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = maxRetry
 	bo.MaxElapsedTime = maxRetry
 
 	for {
-		err = storageVault.PutObject(key, data)
-		if err == nil {
+		err = storageVault.PutObject(ctx, key, data)
+		if err == nil || errors.Is(err, context.Canceled) || ctx.Err() != nil {
 			break
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			if (aerr.Code() == "Forbidden" || aerr.Code() == "AccessDenied") && storageVault.Type().CredentialType == "DEFAULT" {
+		if code := storage_vault.ErrorCode(err); code != "" {
+			if (code == "Forbidden" || code == "AccessDenied") && storageVault.Type().CredentialType == "DEFAULT" {
 				c.logger.Sugar().Info("GetCredential for refreshing session s3")
 				storageVaultID, actID := storageVault.ID()
 
@@ -151,24 +173,43 @@ func (c *Client) PutObject(storageVault storage_vault.StorageVault, key string,
 			break
 		}
 		c.logger.Sugar().Info("Put object error. Retry in ", d)
+		if errSleep := sleepContext(ctx, d); errSleep != nil {
+			return errSleep
+		}
 	}
 	return err
 }
 
-// GetObject downloads the object by name in storage vault.
-func (c *Client) GetObject(storageVault storage_vault.StorageVault, key string, restoreKey *AuthRestore) ([]byte, error) {
+// GetObject downloads the object by name in storage vault, it stops retrying when ctx is done.
+func (c *Client) GetObject(ctx context.Context, storageVault storage_vault.StorageVault, key string, restoreKey *AuthRestore) ([]byte, error) {
+	return c.retryGet(ctx, storageVault, restoreKey, func() ([]byte, error) {
+		return storageVault.GetObject(ctx, key)
+	})
+}
+
+// retryGet downloads by get from storage vault, its errors are retried like GetObject.
+func (c *Client) retryGet(ctx context.Context, storageVault storage_vault.StorageVault, restoreKey *AuthRestore, get func() ([]byte, error)) ([]byte, error) {
 	var err error
+	var data []byte
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = maxRetry
 	bo.MaxElapsedTime = maxRetry
 
 	for {
-		data, err := storageVault.GetObject(key)
+		data, err = get()
 		if err == nil {
 			return data, nil
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			if (aerr.Code() == "Forbidden" || aerr.Code() == "AccessDenied") && storageVault.Type().CredentialType == "DEFAULT" {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, storage_vault.ErrChecksumMismatch) {
+			// retried by GetChunk
+			return nil, err
+		}
+		if code := storage_vault.ErrorCode(err); code != "" {
+			// backups read chunk maps without a restore session, their credential is not refreshed here
+			if (code == "Forbidden" || code == "AccessDenied") && storageVault.Type().CredentialType == "DEFAULT" && restoreKey != nil {
 				storageVaultID, actID := storageVault.ID()
 
 				// get new restore session key
@@ -206,6 +247,100 @@ func (c *Client) GetObject(storageVault storage_vault.StorageVault, key string,
 			break
 		}
 		c.logger.Sugar().Info("GetObject error. Retry in ", d)
+		if errSleep := sleepContext(ctx, d); errSleep != nil {
+			return nil, errSleep
+		}
 	}
 	return nil, err
 }
+
+// GetChunk downloads chunk, decompresses it and checks its content against its key, which is the hash of chunk content.
+// A corrupted chunk is downloaded again up to MaxTimesRetryCorruptedChunk times, then from alternate endpoints
+// and mirrors of storage vault, ErrCorruptedChunk is returned when none of them has the right content.
+func (c *Client) GetChunk(ctx context.Context, storageVault storage_vault.StorageVault, chunk *cache.ChunkInfo, restoreKey *AuthRestore) (_ []byte, err error) {
+	key := chunk.ObjectKey()
+	ctx, span := tracing.Start(ctx, "get_chunk", attribute.String("chunk.key", key), attribute.Int64("chunk.length", int64(chunk.Length)))
+	defer func() { tracing.End(span, err) }()
+	vaults := append([]storage_vault.StorageVault{storageVault}, storage_vault.Alternates(storageVault)...)
+	for i, vault := range vaults {
+		for attempt := 1; attempt <= MaxTimesRetryCorruptedChunk; attempt++ {
+			var data []byte
+			var err error
+			if i == 0 {
+				data, err = c.retryGet(ctx, vault, restoreKey, func() ([]byte, error) { return chunkObject(ctx, vault, chunk) })
+			} else {
+				// alternates are a last resort, their errors are not retried
+				data, err = chunkObject(ctx, vault, chunk)
+			}
+			if err == nil {
+				data, err = chunkContent(chunk, data)
+			}
+			if err == nil {
+				return data, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !errors.Is(err, storage_vault.ErrChecksumMismatch) {
+				if i == 0 {
+					return nil, err
+				}
+				c.logger.Warn("GetChunk error from alternate storage vault", zap.String("key", key), zap.Error(err))
+				break
+			}
+			c.logger.Warn("Corrupted chunk", zap.String("key", key), zap.Int("vault", i), zap.Int("attempt", attempt), zap.Error(err))
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCorruptedChunk, key)
+}
+
+// chunkObject downloads the object of chunk from vault, the part of its packfile when it is packed.
+func chunkObject(ctx context.Context, vault storage_vault.StorageVault, chunk *cache.ChunkInfo) ([]byte, error) {
+	if chunk.Pack == "" {
+		return vault.GetObject(ctx, chunk.Etag)
+	}
+	return storage_vault.GetObjectRange(ctx, vault, chunk.Pack, int64(chunk.Offset), int64(chunk.ObjectLength()))
+}
+
+// chunkKey returns the key of chunk data, the SHA-256 of its content. Chunks of recovery points made by older
+// agents are keyed by MD5, they are still read and reused by unchanged files.
+func chunkKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkContent decompresses object data of chunk and checks it against chunk key, a SHA-256 or MD5 hex digest.
+// Data which does not decompress is corrupted too.
+func chunkContent(chunk *cache.ChunkInfo, data []byte) ([]byte, error) {
+	key := strings.TrimSuffix(chunk.Etag, "."+chunk.Compression)
+	data, err := compression.Decompress(chunk.Compression, data, int(chunk.Length))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %s: %v", storage_vault.ErrChecksumMismatch, chunk.Etag, err)
+	}
+	var got string
+	switch len(key) {
+	case hex.EncodedLen(sha256.Size):
+		got = chunkKey(data)
+	case hex.EncodedLen(md5.Size):
+		sum := md5.Sum(data)
+		got = hex.EncodeToString(sum[:])
+	default:
+		return data, nil
+	}
+	if !strings.EqualFold(got, key) {
+		return nil, fmt.Errorf("%w: chunk hash %s, want %s", storage_vault.ErrChecksumMismatch, got, key)
+	}
+	return data, nil
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
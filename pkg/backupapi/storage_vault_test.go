@@ -1,11 +1,20 @@
 package backupapi
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"net/url"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
 )
 
 func TestClient_credentialStorageVaultPath(t *testing.T) {
@@ -54,3 +63,70 @@ func TestClient_credentialStorageVaultPath(t *testing.T) {
 		})
 	}
 }
+
+// corruptVault returns corrupted content of objects.
+type corruptVault struct {
+	*memoryVault
+	gets int
+}
+
+func (v *corruptVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	v.gets++
+	data, err := v.memoryVault.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{'x'}, data...), nil
+}
+
+func TestClient_GetChunk(t *testing.T) {
+	c, err := NewClient(WithLogger(zap.NewNop()))
+	require.NoError(t, err)
+
+	data := []byte("chunk content")
+	key := chunkKey(data)
+
+	primary := &corruptVault{memoryVault: newMemoryVault()}
+	require.NoError(t, primary.PutObject(context.Background(), key, data))
+
+	_, err = c.GetChunk(context.Background(), primary, &cache.ChunkInfo{Etag: key, Length: uint(len(data))}, nil)
+	assert.True(t, errors.Is(err, ErrCorruptedChunk))
+	assert.Equal(t, MaxTimesRetryCorruptedChunk, primary.gets)
+
+	// the alternate endpoint has the right content
+	alternate := newMemoryVault()
+	require.NoError(t, alternate.PutObject(context.Background(), key, data))
+	got, err := c.GetChunk(context.Background(), storage_vault.WithAlternates(primary, newMemoryVault(), alternate), &cache.ChunkInfo{Etag: key, Length: uint(len(data))}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func Test_chunkContent(t *testing.T) {
+	data := []byte("chunk content")
+	md5Sum := md5.Sum(data)
+	md5Key := hex.EncodeToString(md5Sum[:])
+
+	tests := []struct {
+		name    string
+		key     string
+		data    []byte
+		wantErr bool
+	}{
+		{name: "sha256", key: chunkKey(data), data: data},
+		{name: "md5 of older agents", key: md5Key, data: data},
+		{name: "sha256 mismatch", key: chunkKey(data), data: []byte("other"), wantErr: true},
+		{name: "md5 mismatch", key: md5Key, data: []byte("other"), wantErr: true},
+		{name: "opaque key", key: "key", data: data},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chunkContent(&cache.ChunkInfo{Etag: tt.key, Length: uint(len(tt.data))}, tt.data)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, storage_vault.ErrChecksumMismatch))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.data, got)
+		})
+	}
+}
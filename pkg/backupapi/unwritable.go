@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package backupapi
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDestinationFull reports whether err is caused by no space left on the disk.
+func isDestinationFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// isDestinationReadOnly reports whether err is caused by writing to a read-only file system.
+func isDestinationReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
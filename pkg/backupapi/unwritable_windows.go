@@ -0,0 +1,22 @@
+package backupapi
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorWriteProtect   syscall.Errno = 19
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// isDestinationFull reports whether err is caused by no space left on the disk.
+func isDestinationFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
+
+// isDestinationReadOnly reports whether err is caused by writing to a write protected disk.
+func isDestinationReadOnly(err error) bool {
+	return errors.Is(err, errorWriteProtect)
+}
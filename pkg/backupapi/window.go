@@ -0,0 +1,91 @@
+package backupapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrOutsideBackupWindow is returned for a backup started outside the backup window of its policy.
+	ErrOutsideBackupWindow = errors.New("outside the backup window of policy")
+	// ErrBackupWindowClosed stops a backup running when the backup window of its policy closes.
+	ErrBackupWindowClosed = errors.New("backup window of policy closed")
+	// ErrMaxDurationReached stops a backup running longer than the max duration of its policy.
+	ErrMaxDurationReached = errors.New("max duration of policy reached")
+)
+
+// BackupDeadline is when a backup is stopped, and the error it is stopped with.
+type BackupDeadline struct {
+	At     time.Time
+	Reason error
+}
+
+// Deadline returns the deadline of a backup of the policy started at now: the end of its backup window or the end of
+// its max duration, whichever comes first. The deadline is zero when the backup runs until it is done, and
+// ErrOutsideBackupWindow is returned when now is outside the backup window.
+func (p BackupDirectoryConfigPolicy) Deadline(now time.Time) (BackupDeadline, error) {
+	var deadline BackupDeadline
+	if p.MaxDuration != "" {
+		d, err := parseAge(p.MaxDuration)
+		if err != nil || d == 0 {
+			return BackupDeadline{}, fmt.Errorf("invalid max_duration of policy %s: %q", p.ID, p.MaxDuration)
+		}
+		deadline = BackupDeadline{At: now.Add(d), Reason: ErrMaxDurationReached}
+	}
+	if p.BackupWindow != "" {
+		start, end, err := parseWindow(p.BackupWindow)
+		if err != nil {
+			return BackupDeadline{}, fmt.Errorf("invalid backup_window of policy %s: %w", p.ID, err)
+		}
+		closes, ok := windowEnd(now, start, end)
+		if !ok {
+			return BackupDeadline{}, ErrOutsideBackupWindow
+		}
+		if deadline.At.IsZero() || closes.Before(deadline.At) {
+			deadline = BackupDeadline{At: closes, Reason: ErrBackupWindowClosed}
+		}
+	}
+	return deadline, nil
+}
+
+// parseWindow parses a backup window "HH:MM-HH:MM" to the time of day it opens and closes. A window closing before it
+// opens spans midnight.
+func parseWindow(s string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+	}
+	var times [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", s)
+		}
+		times[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if times[0] == times[1] {
+		return 0, 0, fmt.Errorf("window %q is empty", s)
+	}
+	return times[0], times[1], nil
+}
+
+// windowEnd returns when the window opening at start and closing at end of each day, in the location of now, closes
+// after now. It returns false when now is outside the window.
+func windowEnd(now time.Time, start, end time.Duration) (time.Time, bool) {
+	// times of day are wall clock times, days of daylight saving changes are not 24h
+	at := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	closesAt := func(days int, d time.Duration) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+days, int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, now.Location())
+	}
+	switch {
+	case start < end && at >= start && at < end:
+		return closesAt(0, end), true
+	case start > end && at >= start:
+		return closesAt(1, end), true
+	case start > end && at < end:
+		return closesAt(0, end), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,63 @@
+package backupapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupDirectoryConfigPolicy_Deadline(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2022, 6, day, hour, min, 0, 0, time.UTC)
+	}
+
+	deadline, err := BackupDirectoryConfigPolicy{}.Deadline(at(1, 23, 0))
+	require.NoError(t, err)
+	assert.True(t, deadline.At.IsZero())
+
+	deadline, err = BackupDirectoryConfigPolicy{MaxDuration: "4h"}.Deadline(at(1, 23, 0))
+	require.NoError(t, err)
+	assert.Equal(t, BackupDeadline{At: at(2, 3, 0), Reason: ErrMaxDurationReached}, deadline)
+
+	overnight := BackupDirectoryConfigPolicy{BackupWindow: "22:00-06:00"}
+	deadline, err = overnight.Deadline(at(1, 23, 0))
+	require.NoError(t, err)
+	assert.Equal(t, BackupDeadline{At: at(2, 6, 0), Reason: ErrBackupWindowClosed}, deadline)
+	deadline, err = overnight.Deadline(at(2, 1, 30))
+	require.NoError(t, err)
+	assert.Equal(t, at(2, 6, 0), deadline.At)
+	_, err = overnight.Deadline(at(2, 6, 0))
+	assert.ErrorIs(t, err, ErrOutsideBackupWindow)
+	_, err = overnight.Deadline(at(2, 12, 0))
+	assert.ErrorIs(t, err, ErrOutsideBackupWindow)
+
+	daytime := BackupDirectoryConfigPolicy{BackupWindow: "12:00 - 13:30"}
+	deadline, err = daytime.Deadline(at(1, 12, 10))
+	require.NoError(t, err)
+	assert.Equal(t, at(1, 13, 30), deadline.At)
+	_, err = daytime.Deadline(at(1, 11, 59))
+	assert.ErrorIs(t, err, ErrOutsideBackupWindow)
+
+	// the first of the window end and max duration stops the backup
+	both := BackupDirectoryConfigPolicy{BackupWindow: "22:00-06:00", MaxDuration: "2h"}
+	deadline, err = both.Deadline(at(1, 23, 0))
+	require.NoError(t, err)
+	assert.Equal(t, BackupDeadline{At: at(2, 1, 0), Reason: ErrMaxDurationReached}, deadline)
+	deadline, err = both.Deadline(at(2, 5, 0))
+	require.NoError(t, err)
+	assert.Equal(t, BackupDeadline{At: at(2, 6, 0), Reason: ErrBackupWindowClosed}, deadline)
+
+	for _, p := range []BackupDirectoryConfigPolicy{
+		{MaxDuration: "0s"},
+		{MaxDuration: "4"},
+		{BackupWindow: "22:00"},
+		{BackupWindow: "22:00-25:00"},
+		{BackupWindow: "06:00-06:00"},
+	} {
+		_, err := p.Deadline(at(1, 23, 0))
+		assert.Error(t, err, p)
+		assert.NotErrorIs(t, err, ErrOutsideBackupWindow, p)
+	}
+}
@@ -1,7 +1,10 @@
 package broker
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 )
@@ -22,13 +25,29 @@ const (
 	StatusNotify                        = "status_notify"
 	StopAction                          = "stop_action"
 	UpdateNumGoroutine                  = "update_num_goroutine"
+	ConfigUpdateActionAutoUpgrade       = "update_auto_upgrade"
+	ConfigUpdateActionDiskLimit         = "update_disk_limit"
+	WatchDirectory                      = "watch_directory"
 )
 
+// SchemaVersion is the version of message schema understood and published by the agent. Messages without
+// schema_version are of version 1, the flat format used before versioning.
+const SchemaVersion = 1
+
 // ErrUnknownEventType is raised when receiving unhandled event from broker.
 var ErrUnknownEventType = errors.New("unknown event type")
 
+// ErrUnsupportedSchemaVersion is raised when receiving a message of a newer schema than SchemaVersion.
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+// ErrInvalidMessage is raised when a message misses a field required by its event type.
+var ErrInvalidMessage = errors.New("invalid message")
+
 // Message is the message event format.
 type Message struct {
+	// SchemaVersion is the version of message schema, 0 when it is not given.
+	SchemaVersion Version `json:"schema_version,omitempty"`
+
 	EventType string `json:"event_type"`
 	MachineID string `json:"machine_id"`
 	CreatedAt string `json:"created_at"`
@@ -41,6 +60,13 @@ type Message struct {
 	BackupDirectoryID string `json:"backup_directory_id"`
 	PolicyID          string `json:"policy_id"`
 	Name              string `json:"name"`
+	ChangeDetection   string `json:"change_detection"`
+	// OnlyPaths limits a manual backup to the given paths of the backup directory.
+	OnlyPaths []string `json:"only_paths,omitempty"`
+	// Watch stops watching the backup directory when false, it is watched when missing. WatchDebounce is the number
+	// of seconds changes are accumulated before they are backed up, 0 is watch_debounce config.
+	Watch         *bool `json:"watch,omitempty"`
+	WatchDebounce int   `json:"watch_debounce,omitempty"`
 
 	// For performing restore.
 	SourceMachineID      string `json:"source_machine_id"`
@@ -51,9 +77,121 @@ type Message struct {
 	RestoreSessionKey    string `json:"restore_session_key"`
 	ActionId             string `json:"action_id"`
 	StorageVaultId       string `json:"storage_vault_id"`
+	// PriorityPaths are restored first in the given order.
+	PriorityPaths []string `json:"priority_paths,omitempty"`
+	// PathFilter restores only the selected items of the recovery point, include_paths and exclude_paths.
+	backupapi.PathFilter
+	// Conflict is what the restore does with existing files which differ from the backed up ones: overwrite, skip
+	// or rename, overwrite when empty.
+	Conflict backupapi.ConflictPolicy `json:"conflict,omitempty"`
+	// NoSymlinkEscape restores no symlink pointing outside dest_directory and writes no item through one.
+	NoSymlinkEscape bool `json:"no_symlink_escape,omitempty"`
+	// OwnerMap changes the owners of the restored items, chown, uid_map and gid_map.
+	backupapi.OwnerMap
+	// SkipXattrs restores no extended attributes, POSIX ACLs and security descriptors, e.g. when the filesystem of
+	// dest_directory does not support them.
+	SkipXattrs bool `json:"skip_xattrs,omitempty"`
+	// Atomic restores into dest_directory.restore-tmp, swapped with dest_directory once the restore succeeds, so
+	// dest_directory is left untouched by a failed restore.
+	Atomic bool `json:"atomic,omitempty"`
+	// LimitDownload is the KiB per second the restore downloads at instead of limit_download of agent config, 0 is
+	// unlimited, e.g. for an urgent restore. A missing one keeps limit_download.
+	LimitDownload *int `json:"limit_download,omitempty"`
+	// Force restores a recovery point whose trust check shows tampering or corruption.
+	Force bool `json:"force,omitempty"`
+	// RestoreTarget restores the files as objects to a bucket instead of dest_directory.
+	RestoreTarget *backupapi.RestoreTarget `json:"restore_target,omitempty"`
 
 	// For config update
 	BackupDirectories []backupapi.BackupDirectoryConfig `json:"backup_directories"`
 	Action            string                            `json:"action"`
 	NumGoroutine      int                               `json:"num_goroutine"`
+	AutoUpgrade       *bool                             `json:"auto_upgrade,omitempty"`
+	// LimitDiskRead and LimitDiskIOPS are the KiB and read operations per second of files read by backups, 0 is
+	// unlimited. A missing one is left unchanged.
+	LimitDiskRead *int `json:"limit_disk_read,omitempty"`
+	LimitDiskIOPS *int `json:"limit_disk_iops,omitempty"`
+}
+
+// Version is a schema version. It is decoded from a JSON number or a quoted number, as status messages are
+// published as string maps.
+type Version int
+
+func (v *Version) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%w: schema_version %s", ErrInvalidMessage, b)
+	}
+	*v = Version(n)
+	return nil
+}
+
+// requiredFields are the fields a message must have, by event type.
+var requiredFields = map[string][]string{
+	BackupManual:   {"backup_directory_id"},
+	RestoreManual:  {"recovery_point_id", "action_id", "dest_directory", "storage_vault_id"},
+	ConfigUpdate:   {"action"},
+	StopAction:     {"action_id"},
+	WatchDirectory: {"backup_directory_id"},
+}
+
+// DecodeMessage decodes a message received from broker. Unknown fields are ignored, so fields added by newer
+// backends do not break older agents, but a message of a newer schema version than SchemaVersion is rejected
+// with ErrUnsupportedSchemaVersion. Fields required by the event type are checked by Validate.
+func DecodeMessage(payload []byte) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return Message{}, err
+	}
+	if msg.SchemaVersion > SchemaVersion {
+		return Message{}, fmt.Errorf("%w: %d, agent supports up to %d", ErrUnsupportedSchemaVersion, msg.SchemaVersion, SchemaVersion)
+	}
+	if err := msg.Validate(); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// Validate checks that the message has an event type and the fields required by it.
+func (m Message) Validate() error {
+	if m.EventType == "" {
+		return fmt.Errorf("%w: missing event_type", ErrInvalidMessage)
+	}
+	for _, field := range requiredFields[m.EventType] {
+		if m.field(field) == "" {
+			return fmt.Errorf("%w: %s event missing %s", ErrInvalidMessage, m.EventType, field)
+		}
+	}
+	return nil
+}
+
+func (m Message) field(name string) string {
+	switch name {
+	case "backup_directory_id":
+		return m.BackupDirectoryID
+	case "recovery_point_id":
+		return m.RecoveryPointID
+	case "action_id":
+		return m.ActionId
+	case "dest_directory":
+		return m.DestinationDirectory
+	case "storage_vault_id":
+		return m.StorageVaultId
+	case "action":
+		return m.Action
+	}
+	return ""
+}
+
+// RestoreMachineID returns the machine which backed up the recovery point of a restore, source_machine_id when the
+// recovery point is restored from another machine, machine_id otherwise.
+func (m Message) RestoreMachineID() string {
+	if m.SourceMachineID != "" {
+		return m.SourceMachineID
+	}
+	return m.MachineID
 }
@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		wantErr error
+	}{
+		{
+			name:    "legacy message without schema version",
+			payload: `{"event_type": "backup_manual", "backup_directory_id": "bd"}`,
+		},
+		{
+			name:    "quoted schema version",
+			payload: `{"schema_version": "1", "event_type": "stop_action", "action_id": "a"}`,
+		},
+		{
+			name:    "unknown fields are ignored",
+			payload: `{"schema_version": 1, "event_type": "status_notify", "status": "ONLINE", "new_field": {"a": 1}}`,
+		},
+		{
+			name:    "newer schema version",
+			payload: `{"schema_version": 2, "event_type": "status_notify"}`,
+			wantErr: ErrUnsupportedSchemaVersion,
+		},
+		{
+			name:    "invalid schema version",
+			payload: `{"schema_version": "v1", "event_type": "status_notify"}`,
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name:    "missing event type",
+			payload: `{"schema_version": 1}`,
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name:    "missing required field",
+			payload: `{"event_type": "restore_manual", "recovery_point_id": "rp", "action_id": "a", "storage_vault_id": "sv"}`,
+			wantErr: ErrInvalidMessage,
+		},
+		{
+			name:    "watch directory without backup directory",
+			payload: `{"event_type": "watch_directory", "watch": false}`,
+			wantErr: ErrInvalidMessage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeMessage([]byte(tt.payload))
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("DecodeMessage() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecodeMessage() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestoreMachineID(t *testing.T) {
+	msg, err := DecodeMessage([]byte(`{"event_type": "restore_manual", "machine_id": "b", "recovery_point_id": "rp", "action_id": "a", "storage_vault_id": "sv", "dest_directory": "/restore"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.RestoreMachineID(); got != "b" {
+		t.Fatalf("RestoreMachineID() = %s, want b", got)
+	}
+	msg.SourceMachineID = "a"
+	if got := msg.RestoreMachineID(); got != "a" {
+		t.Fatalf("RestoreMachineID() = %s, want a", got)
+	}
+}
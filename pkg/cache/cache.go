@@ -16,8 +16,24 @@ import (
 const (
 	dirMode  = 0700
 	tempPath = "tmp"
+	// tempPattern is the name pattern of temp files, they are moved in place when written completely.
+	tempPattern = "temp-"
+
+	// TempFileMaxAge is the age of temp files left by failed writes before they are removed.
+	TempFileMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrDiskFull is returned when cache repository can not be written as there is no space left on the disk.
+	ErrDiskFull = errors.New("cache disk is full")
+	// ErrWriteTimeout is returned when a write to cache repository takes longer than WriteTimeout.
+	ErrWriteTimeout = errors.New("cache write timed out")
 )
 
+// WriteTimeout bounds each write to cache repository, so a hung disk or network mount fails the action
+// instead of blocking it forever.
+var WriteTimeout = 5 * time.Minute
+
 type Repository struct {
 	path string
 	mcID string
@@ -29,6 +45,9 @@ type Type int
 const (
 	INDEX = iota
 	CHUNK
+	FILES
+	CHECKPOINT
+	RESTORE_STATE
 )
 
 func (t Type) String() string {
@@ -37,6 +56,12 @@ func (t Type) String() string {
 		return "index.json"
 	case CHUNK:
 		return "chunk.json"
+	case FILES:
+		return "file.csv"
+	case CHECKPOINT:
+		return "checkpoint.json"
+	case RESTORE_STATE:
+		return "restore.json"
 	}
 
 	return fmt.Sprintf("unknown type %d", t)
@@ -76,13 +101,17 @@ func (r *Repository) create() error {
 
 // Return temp directory in correct directory for this repository.
 func (r *Repository) tempFile() (*os.File, error) {
-	return ioutil.TempFile(path.Join(r.path, r.mcID, r.rpID, tempPath), "temp-")
+	return ioutil.TempFile(path.Join(r.path, r.mcID, r.rpID, tempPath), tempPattern)
 }
 
 // Rename temp file to final name according to type and ID.
 func (r *Repository) renameFile(file *os.File, t Type) error {
 	filename := r.filename(t)
-	return os.Rename(file.Name(), filename)
+	if err := os.Rename(file.Name(), filename); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return nil
 }
 
 // Construct path for given Type and ID.
@@ -95,23 +124,7 @@ func (r *Repository) SaveIndex(index *Index) error {
 	if err != nil {
 		return err
 	}
-	f, err := r.tempFile()
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = f.Write(buf)
-	if err != nil {
-		return err
-	}
-	if err := f.Close(); err != nil {
-		return err
-	}
-	err = r.renameFile(f, INDEX)
-	if err != nil {
-		return err
-	}
-	return nil
+	return r.saveFile(buf, INDEX)
 }
 
 func (r *Repository) SaveChunk(chunk *Chunk) error {
@@ -119,23 +132,89 @@ func (r *Repository) SaveChunk(chunk *Chunk) error {
 	if err != nil {
 		return err
 	}
+	return r.saveFile(buf, CHUNK)
+}
+
+// saveFile writes buf to a temp file then moves it in place, the temp file is removed when writing fails.
+func (r *Repository) saveFile(buf []byte, t Type) error {
 	f, err := r.tempFile()
 	if err != nil {
-		return err
+		return writeError(err)
 	}
-	defer f.Close()
-	_, err = f.Write(buf)
-	if err != nil {
+	if err := writeFile(f, buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
 		return err
 	}
 	if err := f.Close(); err != nil {
-		return err
+		_ = os.Remove(f.Name())
+		return writeError(err)
+	}
+	return r.renameFile(f, t)
+}
+
+// writeFile writes buf to f, it fails with ErrWriteTimeout when the write takes longer than WriteTimeout.
+func writeFile(f *os.File, buf []byte) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := f.Write(buf)
+		errCh <- err
+	}()
+	t := time.NewTimer(WriteTimeout)
+	defer t.Stop()
+	select {
+	case err := <-errCh:
+		return writeError(err)
+	case <-t.C:
+		return fmt.Errorf("%w: %s", ErrWriteTimeout, f.Name())
+	}
+}
+
+// writeError wraps err with ErrDiskFull when there is no space left on the disk.
+func writeError(err error) error {
+	if err != nil && isDiskFull(err) {
+		return fmt.Errorf("%w: %v", ErrDiskFull, err)
+	}
+	return err
+}
+
+// fileWriter is an io.Writer writing to f by writeFile.
+type fileWriter struct {
+	f *os.File
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	if err := writeFile(w.f, p); err != nil {
+		return 0, err
 	}
-	err = r.renameFile(f, CHUNK)
+	return len(p), nil
+}
+
+// RemoveTempFiles removes temp files older than maxAge from repositories in cache directory cachePath,
+// they are left when writes failed. It returns the number of removed files.
+func RemoveTempFiles(cachePath string, maxAge time.Duration) (int, error) {
+	names, err := filepath.Glob(filepath.Join(cachePath, "*", "*", tempPath, tempPattern+"*"))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	var removed int
+	for _, name := range names {
+		fi, err := os.Lstat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		if !fi.Mode().IsRegular() || !isOld(fi.ModTime(), maxAge) {
+			continue
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
 }
 
 // listCacheDirs returns the list of cache directories.
@@ -179,8 +258,7 @@ func olderThan(cacheDir string, maxCacheAge time.Duration) ([]os.FileInfo, error
 
 	var oldCacheDirs []os.FileInfo
 	for _, fi := range entries {
-		_, _, mtime, _, _, _ := support.ItemLocal(fi)
-		if !isOld(mtime, maxCacheAge) {
+		if !isOld(support.FileMetadata(fi).Mtime, maxCacheAge) {
 			continue
 		}
 		oldCacheDirs = append(oldCacheDirs, fi)
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveTempFiles(t *testing.T) {
+	cachePath := t.TempDir()
+	r, err := NewRepository(cachePath, "mc", "rp")
+	require.NoError(t, err)
+
+	oldFile, err := r.tempFile()
+	require.NoError(t, err)
+	require.NoError(t, oldFile.Close())
+	old := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(oldFile.Name(), old, old))
+
+	newFile, err := r.tempFile()
+	require.NoError(t, err)
+	require.NoError(t, newFile.Close())
+
+	n, err := RemoveTempFiles(cachePath, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = os.Stat(oldFile.Name())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newFile.Name())
+	assert.NoError(t, err)
+
+	n, err = RemoveTempFiles(filepath.Join(cachePath, "missing"), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestWriteFileTimeout(t *testing.T) {
+	old := WriteTimeout
+	WriteTimeout = 50 * time.Millisecond
+	defer func() { WriteTimeout = old }()
+
+	// Nothing reads the pipe, so writing more than its buffer blocks.
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+	defer pr.Close()
+	defer pw.Close()
+
+	err = writeFile(pw, make([]byte, 4<<20))
+	assert.True(t, errors.Is(err, ErrWriteTimeout), "got %v", err)
+}
+
+func TestSaveIndexRemovesTempFileOnFailure(t *testing.T) {
+	r, err := NewRepository(t.TempDir(), "mc", "rp")
+	require.NoError(t, err)
+	require.NoError(t, r.SaveIndex(&Index{}))
+
+	// Renaming fails as index.json is replaced by a non-empty directory.
+	require.NoError(t, os.Remove(r.filename(INDEX)))
+	require.NoError(t, os.MkdirAll(filepath.Join(r.filename(INDEX), "x"), dirMode))
+	assert.Error(t, r.SaveIndex(&Index{}))
+
+	names, err := filepath.Glob(filepath.Join(r.path, r.mcID, r.rpID, tempPath, tempPattern+"*"))
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
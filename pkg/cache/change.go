@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Change detection methods of policy.
+const (
+	// ChangeDetectionMtime compares modification time, size, change time and inode, it is the default.
+	ChangeDetectionMtime = "mtime"
+	// ChangeDetectionQuick compares what ChangeDetectionMtime does and hash of first/last block of file.
+	ChangeDetectionQuick = "quick"
+	// ChangeDetectionFull compares sha256 hash of whole file content.
+	ChangeDetectionFull = "full"
+)
+
+// fingerprintBlockSize is size of the blocks at start and end of file hashed by quick change detection.
+const fingerprintBlockSize = 64 * 1024
+
+// ChangeDetector reports whether a file is changed since latest recovery point.
+type ChangeDetector interface {
+	// Changed reports whether item is changed compared to last, the node of latest recovery point.
+	// It may fill fingerprint fields of item, which are compared in next backup.
+	Changed(last, item *Node) (bool, error)
+}
+
+// NewChangeDetector returns the ChangeDetector of method, empty method is ChangeDetectionMtime.
+func NewChangeDetector(method string) (ChangeDetector, error) {
+	switch method {
+	case "", ChangeDetectionMtime:
+		return mtimeDetector{}, nil
+	case ChangeDetectionQuick:
+		return quickDetector{}, nil
+	case ChangeDetectionFull:
+		return fullDetector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown change detection method: %q", method)
+	}
+}
+
+type mtimeDetector struct{}
+
+func (mtimeDetector) Changed(last, item *Node) (bool, error) {
+	if last == nil {
+		return true, nil
+	}
+	return !sameStat(last, item), nil
+}
+
+type quickDetector struct{}
+
+func (quickDetector) Changed(last, item *Node) (bool, error) {
+	fingerprint, err := quickFingerprint(item.AbsolutePath, item.Size)
+	if err != nil {
+		return false, err
+	}
+	item.Fingerprint = fingerprint
+	if last == nil {
+		return true, nil
+	}
+	return !sameStat(last, item) || last.Fingerprint != item.Fingerprint, nil
+}
+
+type fullDetector struct{}
+
+func (fullDetector) Changed(last, item *Node) (bool, error) {
+	if last == nil || len(last.Sha256Hash) == 0 || last.Size != item.Size {
+		return true, nil
+	}
+	f, err := os.Open(item.AbsolutePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return Sha256Hash(h.Sum(nil)).String() != last.Sha256Hash.String(), nil
+}
+
+// quickFingerprint returns hash of size, first and last block of file.
+func quickFingerprint(path string, size uint64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, _ = io.WriteString(h, strconv.FormatUint(size, 10))
+	if _, err := io.CopyN(h, f, fingerprintBlockSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > fingerprintBlockSize {
+		offset := int64(size) - fingerprintBlockSize
+		if offset < fingerprintBlockSize {
+			offset = fingerprintBlockSize
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(h, f, fingerprintBlockSize); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameStat reports whether item has the same modification time, size, change time and inode as last. Tools like
+// rsync -t or cp -p keep modification time of changed content, but not its change time. Inode is not compared when
+// last has none, e.g. it was backed up by an older agent or on Windows, or when change_detection_ignore_inode is set
+// for file systems whose inodes are not stable, like some network file systems.
+func sameStat(last, item *Node) bool {
+	if !sameTime(last.ModTime, item.ModTime) || last.Size != item.Size {
+		return false
+	}
+	if !last.ChangeTime.IsZero() && !sameTime(last.ChangeTime, item.ChangeTime) {
+		return false
+	}
+	if last.Inode != 0 && !viper.GetBool("change_detection_ignore_inode") && (last.Inode != item.Inode || last.Device != item.Device) {
+		return false
+	}
+	return true
+}
+
+// sameTime compares a and b in microsecond precision, the precision of time kept by all supported OS.
+func sameTime(a, b time.Time) bool {
+	const layout = "2006-01-02 15:04:05.000000"
+	return a.Format(layout) == b.Format(layout)
+}
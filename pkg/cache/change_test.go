@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeDetector(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	mtime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	statNode := func() *Node {
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		node, err := NodeFromFileInfo(dir, path, fi)
+		require.NoError(t, err)
+		return node
+	}
+	writeNode := func(content string) *Node {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		// tools like rsync -t keep modification time after content changed
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+		return statNode()
+	}
+
+	tests := []struct {
+		method      string
+		wantChanged bool
+	}{
+		{ChangeDetectionMtime, true},
+		{ChangeDetectionQuick, true},
+		{ChangeDetectionFull, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.method, func(t *testing.T) {
+			detector, err := NewChangeDetector(tc.method)
+			require.NoError(t, err)
+
+			last := writeNode("foo")
+			changed, err := detector.Changed(nil, last)
+			require.NoError(t, err)
+			assert.True(t, changed)
+			last.Sha256Hash = Sha256Hash{0x2c, 0x26, 0xb4, 0x6b, 0x68, 0xff, 0xc6, 0x8f, 0xf9, 0x9b, 0x45, 0x3c, 0x1d, 0x30, 0x41, 0x34,
+				0x13, 0x42, 0x2d, 0x70, 0x64, 0x83, 0xbf, 0xa0, 0xf9, 0x8a, 0x5e, 0x88, 0x62, 0x66, 0xe7, 0xae}
+
+			item := statNode()
+			changed, err = detector.Changed(last, item)
+			require.NoError(t, err)
+			assert.False(t, changed)
+
+			item = writeNode("bar")
+			changed, err = detector.Changed(last, item)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantChanged, changed)
+		})
+	}
+
+	_, err := NewChangeDetector("unknown")
+	assert.Error(t, err)
+}
+
+func TestSameStat(t *testing.T) {
+	mtime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := &Node{ModTime: mtime, ChangeTime: mtime, Size: 3, Device: 1, Inode: 10}
+	same := *last
+	assert.True(t, sameStat(last, &same))
+
+	tests := []struct {
+		name   string
+		change func(n *Node)
+	}{
+		{"size", func(n *Node) { n.Size = 4 }},
+		{"mtime", func(n *Node) { n.ModTime = mtime.Add(time.Second) }},
+		{"ctime", func(n *Node) { n.ChangeTime = mtime.Add(time.Second) }},
+		{"inode", func(n *Node) { n.Inode = 11 }},
+		{"device", func(n *Node) { n.Device = 2 }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			item := *last
+			tc.change(&item)
+			assert.False(t, sameStat(last, &item))
+		})
+	}
+
+	// inode of items backed up without it, or on file systems with unstable inodes, is not compared
+	item := same
+	item.Inode = 11
+	assert.True(t, sameStat(&Node{ModTime: mtime, ChangeTime: mtime, Size: 3}, &item))
+	viper.Set("change_detection_ignore_inode", true)
+	defer viper.Set("change_detection_ignore_inode", false)
+	assert.True(t, sameStat(last, &item))
+}
+
+func TestQuickFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	data := make([]byte, 3*fingerprintBlockSize)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	before, err := quickFingerprint(path, uint64(len(data)))
+	require.NoError(t, err)
+
+	// change in the middle is not detected by quick fingerprint
+	data[fingerprintBlockSize+1] = 1
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	middle, err := quickFingerprint(path, uint64(len(data)))
+	require.NoError(t, err)
+	assert.Equal(t, before, middle)
+
+	data[len(data)-1] = 1
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	last, err := quickFingerprint(path, uint64(len(data)))
+	require.NoError(t, err)
+	assert.NotEqual(t, before, last)
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint is the progress of a backup, saved periodically to the repository of its recovery point so a backup
+// interrupted by an agent restart resumes the recovery point instead of uploading everything again. It is safe for
+// concurrent use.
+type Checkpoint struct {
+	mu sync.Mutex
+	// Key identifies the backup, only a backup of the same key resumes the checkpoint.
+	Key             string `json:"key"`
+	ActionID        string `json:"action_id"`
+	RecoveryPointID string `json:"recovery_point_id"`
+	// Action is the recovery point action as created by backup server.
+	Action  json.RawMessage `json:"action"`
+	SavedAt time.Time       `json:"saved_at"`
+	// Items are the files backed up completely, by their absolute path.
+	Items map[string]*Node `json:"items"`
+	// Chunks are the keys of chunks stored in the storage vault.
+	Chunks map[string]bool `json:"chunks"`
+	// Stopped is the reason the backup was stopped before it was done, e.g. the backup window of its policy closed.
+	// The checkpoint of a stopped backup is kept, the next backup of the same key resumes its recovery point.
+	Stopped string `json:"stopped,omitempty"`
+	// ResumeAt is when a stopped backup is resumed by Resume, the backup to run again, e.g. when the daily upload
+	// budget renews. They are kept across agent restarts.
+	ResumeAt time.Time       `json:"resume_at,omitempty"`
+	Resume   json.RawMessage `json:"resume,omitempty"`
+}
+
+// NewCheckpoint creates an empty checkpoint of backup key, which uploads the recovery point of action.
+func NewCheckpoint(key, actionID, rpID string, action json.RawMessage) *Checkpoint {
+	return &Checkpoint{
+		Key:             key,
+		ActionID:        actionID,
+		RecoveryPointID: rpID,
+		Action:          action,
+		Items:           make(map[string]*Node),
+		Chunks:          make(map[string]bool),
+	}
+}
+
+// AddItem records node as backed up, its content must not change anymore.
+func (c *Checkpoint) AddItem(node *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Items[node.AbsolutePath] = node
+}
+
+// AddChunk records chunk key as stored.
+func (c *Checkpoint) AddChunk(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Chunks[key] = true
+}
+
+// Item returns the node of path backed up before the checkpoint was saved, nil when there is none. A node with
+// chunks in packfiles which were not stored yet is not backed up, nor a node with a chunk map as the packfiles of
+// its chunks are not known without reading its pages.
+func (c *Checkpoint) Item(path string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.Items[path]
+	if node == nil || len(node.ChunkMap) > 0 {
+		return nil
+	}
+	for _, chunk := range node.Content {
+		if chunk.Pack != "" && !c.Chunks[chunk.Pack] {
+			return nil
+		}
+	}
+	for _, stream := range node.Streams {
+		for _, chunk := range stream.Content {
+			if chunk.Pack != "" && !c.Chunks[chunk.Pack] {
+				return nil
+			}
+		}
+	}
+	return node
+}
+
+// Stop records the backup is stopped for reason.
+func (c *Checkpoint) Stop(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Stopped = reason
+}
+
+// StopUntil records the backup is stopped for reason until resumeAt.
+func (c *Checkpoint) StopUntil(reason string, resumeAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Stopped = reason
+	c.ResumeAt = resumeAt
+}
+
+// ResumeTime returns when the stopped backup is resumed, zero when it is not resumed at a given time.
+func (c *Checkpoint) ResumeTime() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ResumeAt
+}
+
+// StopReason returns the reason the backup was stopped for, empty when it was not.
+func (c *Checkpoint) StopReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Stopped
+}
+
+// Stored reports whether chunk key was stored before the checkpoint was saved.
+func (c *Checkpoint) Stored(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Chunks[key]
+}
+
+// SaveCheckpoint writes checkpoint.json of the repository.
+func (r *Repository) SaveCheckpoint(c *Checkpoint) error {
+	c.mu.Lock()
+	c.SavedAt = time.Now()
+	buf, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return r.saveFile(buf, CHECKPOINT)
+}
+
+// RemoveCheckpoint removes checkpoint.json of the repository, once its backup finished.
+func (r *Repository) RemoveCheckpoint() error {
+	if err := os.Remove(r.filename(CHECKPOINT)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Checkpoints returns the checkpoints saved in repositories of machine mcID. Unreadable checkpoints are skipped.
+func Checkpoints(cachePath, mcID string) ([]*Checkpoint, error) {
+	names, err := filepath.Glob(filepath.Join(cachePath, mcID, "*", Type(CHECKPOINT).String()))
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []*Checkpoint
+	for _, name := range names {
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var c Checkpoint
+		if err := json.Unmarshal(buf, &c); err != nil || c.RecoveryPointID == "" {
+			continue
+		}
+		if c.Items == nil {
+			c.Items = make(map[string]*Node)
+		}
+		if c.Chunks == nil {
+			c.Chunks = make(map[string]bool)
+		}
+		checkpoints = append(checkpoints, &c)
+	}
+	return checkpoints, nil
+}
+
+// FindCheckpoint returns the latest checkpoint of backup key saved in repositories of machine mcID, nil when there
+// is none.
+func FindCheckpoint(cachePath, mcID, key string) (*Checkpoint, error) {
+	checkpoints, err := Checkpoints(cachePath, mcID)
+	if err != nil {
+		return nil, err
+	}
+	var found *Checkpoint
+	for _, c := range checkpoints {
+		if c.Key == key && (found == nil || c.SavedAt.After(found.SavedAt)) {
+			found = c
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	cachePath := t.TempDir()
+	r, err := NewRepository(cachePath, "mc", "rp1")
+	require.NoError(t, err)
+
+	c := NewCheckpoint("dir1", "action1", "rp1", json.RawMessage(`{"id":"action1"}`))
+	c.AddItem(&Node{AbsolutePath: "/data/a", Type: "file", Content: []*ChunkInfo{{Etag: "chunk1"}}})
+	c.AddChunk("chunk1")
+	assert.Empty(t, c.StopReason())
+	c.Stop("backup window closed")
+	require.NoError(t, r.SaveCheckpoint(c))
+
+	other, err := NewRepository(cachePath, "mc", "rp2")
+	require.NoError(t, err)
+	require.NoError(t, other.SaveCheckpoint(NewCheckpoint("dir2", "action2", "rp2", nil)))
+
+	found, err := FindCheckpoint(cachePath, "mc", "dir1")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "action1", found.ActionID)
+	assert.Equal(t, "rp1", found.RecoveryPointID)
+	assert.JSONEq(t, `{"id":"action1"}`, string(found.Action))
+	assert.False(t, found.SavedAt.IsZero())
+	require.NotNil(t, found.Item("/data/a"))
+	assert.Equal(t, "chunk1", found.Item("/data/a").Content[0].Etag)
+	assert.Nil(t, found.Item("/data/b"))
+	assert.True(t, found.Stored("chunk1"))
+	assert.False(t, found.Stored("chunk2"))
+	assert.Equal(t, "backup window closed", found.StopReason())
+
+	checkpoints, err := Checkpoints(cachePath, "mc")
+	require.NoError(t, err)
+	assert.Len(t, checkpoints, 2)
+
+	require.NoError(t, r.RemoveCheckpoint())
+	require.NoError(t, r.RemoveCheckpoint())
+	found, err = FindCheckpoint(cachePath, "mc", "dir1")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestCheckpoint_ItemPacked(t *testing.T) {
+	c := NewCheckpoint("dir1", "action1", "rp1", nil)
+	c.AddItem(&Node{AbsolutePath: "/data/a", Type: "file", Content: []*ChunkInfo{{Etag: "chunk1", Pack: "pack1"}}})
+	assert.Nil(t, c.Item("/data/a"), "packfile of chunk is not stored yet")
+	c.AddChunk("pack1")
+	assert.NotNil(t, c.Item("/data/a"))
+}
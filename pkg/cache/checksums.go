@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checksumsDir is the directory of checksum caches in the cache directory of a machine.
+const checksumsDir = "checksums"
+
+// ChecksumCache keeps the hash and content of files of a backup directory as last backed up to a storage vault,
+// by their path. It is kept in the cache directory across backups, unlike the index of recovery points, so a file
+// whose path, size, modification time, change time and inode did not change reuses its content without being read.
+// A nil ChecksumCache is disabled, it finds nothing. It is safe for concurrent use.
+type ChecksumCache struct {
+	mu   sync.Mutex
+	path string
+
+	StorageVaultID string           `json:"storage_vault_id"`
+	Items          map[string]*Node `json:"items"`
+}
+
+// OpenChecksumCache reads the checksum cache of backup directory bdID of machine mcID. The cache is empty when it does
+// not exist yet, can not be read or was made for another storage vault than storageVaultID, as the chunks of its
+// content may not be there. It returns nil when checksum_cache config is disabled.
+func OpenChecksumCache(cachePath, mcID, bdID, storageVaultID string) *ChecksumCache {
+	if !enabled("checksum_cache") {
+		return nil
+	}
+	c := &ChecksumCache{
+		path:           filepath.Join(cachePath, mcID, checksumsDir, bdID+".json"),
+		StorageVaultID: storageVaultID,
+		Items:          make(map[string]*Node),
+	}
+	buf, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return c
+	}
+	var saved ChecksumCache
+	if err := json.Unmarshal(buf, &saved); err != nil || saved.StorageVaultID != storageVaultID || saved.Items == nil {
+		return c
+	}
+	c.Items = saved.Items
+	return c
+}
+
+// Lookup returns the cached node of item, which has its content and hash, or nil when the file may have changed
+// since it was cached.
+func (c *ChecksumCache) Lookup(item *Node) *Node {
+	if c == nil || item.Type != "file" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.Items[item.AbsolutePath]
+	if !ok || !sameStat(cached, item) {
+		return nil
+	}
+	return cached
+}
+
+// Update caches the files of index, which are backed up completely. Files not in index are removed from the cache
+// unless partial is true, as a partial recovery point only has some files of the backup directory.
+func (c *ChecksumCache) Update(index *Index, partial bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !partial {
+		c.Items = make(map[string]*Node, len(index.Items))
+	}
+	for path, item := range index.Items {
+		if item.Type != "file" || item.HardLink != "" {
+			continue
+		}
+		c.Items[path] = &Node{
+			Type:         item.Type,
+			AbsolutePath: item.AbsolutePath,
+			ModTime:      item.ModTime,
+			ChangeTime:   item.ChangeTime,
+			Size:         item.Size,
+			Device:       item.Device,
+			Inode:        item.Inode,
+			Sha256Hash:   item.Sha256Hash,
+			Fingerprint:  item.Fingerprint,
+			Sparse:       item.Sparse,
+			Content:      item.Content,
+			ChunkMap:     item.ChunkMap,
+			Chunks:       item.Chunks,
+			Streams:      item.Streams,
+		}
+	}
+}
+
+// Save writes the checksum cache to the cache directory.
+func (c *ChecksumCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	buf, err := json.Marshal(c)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), dirMode); err != nil {
+		return writeError(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(c.path), tempPattern)
+	if err != nil {
+		return writeError(err)
+	}
+	if err := writeFile(f, buf); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return writeError(err)
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Detector returns a ChangeDetector reporting files unchanged without reading them when last is their cached node,
+// the other files are checked by detector. ChangeDetectionFull reads every file as asked, it is returned as is.
+func (c *ChecksumCache) Detector(detector ChangeDetector) ChangeDetector {
+	if _, full := detector.(fullDetector); c == nil || full {
+		return detector
+	}
+	return checksumDetector{cache: c, detector: detector}
+}
+
+type checksumDetector struct {
+	cache    *ChecksumCache
+	detector ChangeDetector
+}
+
+func (d checksumDetector) Changed(last, item *Node) (bool, error) {
+	if last != nil && last == d.cache.Lookup(item) {
+		item.Fingerprint = last.Fingerprint
+		return false, nil
+	}
+	return d.detector.Changed(last, item)
+}
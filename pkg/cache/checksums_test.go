@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumCache(t *testing.T) {
+	cachePath := t.TempDir()
+	mtime := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	file := func(path string, size uint64) *Node {
+		return &Node{Type: "file", AbsolutePath: path, ModTime: mtime, ChangeTime: mtime, Size: size, Inode: 10}
+	}
+
+	c := OpenChecksumCache(cachePath, "mc", "bd", "vault")
+	require.NotNil(t, c)
+	assert.Nil(t, c.Lookup(file("/data/a", 3)))
+
+	index := NewIndex("bd", "rp1")
+	index.Items["/data/a"] = file("/data/a", 3)
+	index.Items["/data/a"].Content = []*ChunkInfo{{Length: 3, Etag: "etag"}}
+	index.Items["/data/b"] = file("/data/b", 5)
+	index.Items["/data"] = &Node{Type: "dir", AbsolutePath: "/data"}
+	c.Update(index, false)
+	require.NoError(t, c.Save())
+
+	c = OpenChecksumCache(cachePath, "mc", "bd", "vault")
+	cached := c.Lookup(file("/data/a", 3))
+	require.NotNil(t, cached)
+	assert.Equal(t, "etag", cached.Content[0].Etag)
+	assert.Nil(t, c.Lookup(file("/data/a", 4)), "changed file is not found")
+	assert.Nil(t, c.Lookup(&Node{Type: "dir", AbsolutePath: "/data"}))
+
+	// the files a detector would read do not exist, cached files are not read
+	detector := c.Detector(mtimeDetector{})
+	changed, err := detector.Changed(cached, file("/data/a", 3))
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, full := c.Detector(fullDetector{}).(fullDetector)
+	assert.True(t, full, "full change detection reads every file")
+
+	partial := NewIndex("bd", "rp2")
+	partial.Items["/data/c"] = file("/data/c", 1)
+	c.Update(partial, true)
+	assert.NotNil(t, c.Lookup(file("/data/b", 5)))
+	assert.NotNil(t, c.Lookup(file("/data/c", 1)))
+	c.Update(partial, false)
+	assert.Nil(t, c.Lookup(file("/data/b", 5)), "files removed from the backup directory are not kept")
+
+	assert.Empty(t, OpenChecksumCache(cachePath, "mc", "bd", "other vault").Items)
+
+	viper.Set("checksum_cache", false)
+	defer viper.Set("checksum_cache", true)
+	assert.Nil(t, OpenChecksumCache(cachePath, "mc", "bd", "vault"))
+}
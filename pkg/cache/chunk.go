@@ -4,6 +4,9 @@ type Chunk struct {
 	BackupDirectoryID string              `json:"backup_directory_id"`
 	RecoveryPointID   string              `json:"recovery_point_id"`
 	Chunks            map[string][]string `json:"chunks"`
+	// Packed reports the object of Chunks is a packfile referenced by one of its chunks, whose size is the size of
+	// the chunk and not of the packfile.
+	Packed bool `json:"-"`
 }
 
 func NewChunk(bdID string, rpID string) *Chunk {
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// ChunkMapPageSize is the number of chunks of a page of chunk map. A file with more chunks keeps its chunk list in a
+// chunk map instead of its content, so at most a page of it is held in memory while the file is backed up or restored.
+const ChunkMapPageSize = 1 << 16
+
+// EncodeChunkMapPage encodes chunks as a page of chunk map, a JSON object per line in file order.
+func EncodeChunkMapPage(chunks []*ChunkInfo) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, chunk := range chunks {
+		if err := enc.Encode(chunk); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeChunkMapPage calls fn with the chunks of page data in order, they are decoded one at a time. It stops at the
+// first error of fn.
+func DecodeChunkMapPage(data []byte, fn func(*ChunkInfo) error) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var chunk ChunkInfo
+		err := dec.Decode(&chunk)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(&chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// ChunkCount returns the number of chunks of the content of file node.
+func (node *Node) ChunkCount() uint64 {
+	if len(node.ChunkMap) > 0 {
+		return node.Chunks
+	}
+	return uint64(len(node.Content))
+}
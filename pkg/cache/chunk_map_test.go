@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkMapPage(t *testing.T) {
+	chunks := []*ChunkInfo{
+		{Start: 0, Length: 1 << 20, Etag: "a"},
+		{Start: 1 << 20, Length: 100, Etag: "b", Pack: "pack-1", Offset: 1 << 33},
+		{Start: 1 << 40, Length: 1 << 20, Etag: "c.lz4", Compression: "lz4", StoredLength: 42},
+	}
+	data, err := EncodeChunkMapPage(chunks)
+	require.NoError(t, err)
+
+	var decoded []*ChunkInfo
+	require.NoError(t, DecodeChunkMapPage(data, func(chunk *ChunkInfo) error {
+		decoded = append(decoded, chunk)
+		return nil
+	}))
+	assert.Equal(t, chunks, decoded)
+
+	// decoding stops at the first error
+	errStop := errors.New("stop")
+	var n int
+	err = DecodeChunkMapPage(data, func(*ChunkInfo) error {
+		n++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, n)
+
+	assert.Error(t, DecodeChunkMapPage([]byte("{"), func(*ChunkInfo) error { return nil }))
+}
+
+func TestChunkCount(t *testing.T) {
+	assert.Equal(t, uint64(2), (&Node{Content: []*ChunkInfo{{}, {}}}).ChunkCount())
+	// chunk counts of chunk maps are not bounded by the size of a slice
+	assert.Equal(t, uint64(5<<32), (&Node{ChunkMap: []*ChunkInfo{{}}, Chunks: 5 << 32}).ChunkCount())
+}
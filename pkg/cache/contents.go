@@ -0,0 +1,83 @@
+package cache
+
+import "sync"
+
+// ContentMap maps the SHA-256 of files backed up to a storage vault to their content, so a changed file with the
+// content of another file, e.g. a copied config, reuses its chunks without being chunked and uploaded again. Files
+// are only hashed when a file of the same size is in the map. A nil ContentMap is disabled, it finds nothing. It is
+// safe for concurrent use.
+type ContentMap struct {
+	mu    sync.Mutex
+	sizes map[uint64]int
+	files map[string]*Node
+}
+
+// NewContentMap returns an empty content map, or nil when file_dedup config is disabled.
+func NewContentMap() *ContentMap {
+	if !enabled("file_dedup") {
+		return nil
+	}
+	return &ContentMap{sizes: make(map[uint64]int), files: make(map[string]*Node)}
+}
+
+// Add maps the hash of file node to its content. Sparse files and files without hash or content are not added, the
+// content of a sparse file does not cover its holes.
+func (m *ContentMap) Add(node *Node) {
+	if m == nil || node.Type != "file" || node.Sparse || len(node.Sha256Hash) == 0 || len(node.Content) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := string(node.Sha256Hash)
+	if _, ok := m.files[key]; ok {
+		return
+	}
+	m.files[key] = node
+	m.sizes[node.Size]++
+}
+
+// AddIndex adds the files of index.
+func (m *ContentMap) AddIndex(index *Index) {
+	if m == nil {
+		return
+	}
+	for _, item := range index.Items {
+		m.Add(item)
+	}
+}
+
+// AddChecksums adds the files of checksum cache c, their chunks are in the storage vault the cache was made for.
+func (m *ContentMap) AddChecksums(c *ChecksumCache) {
+	if m == nil || c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range c.Items {
+		m.Add(item)
+	}
+}
+
+// HasSize reports whether a file of size bytes is in the map, files of other sizes can not have the content of one.
+func (m *ContentMap) HasSize(size uint64) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sizes[size] > 0
+}
+
+// Lookup returns the file of size bytes with hash, or nil when there is none.
+func (m *ContentMap) Lookup(size uint64, hash Sha256Hash) *Node {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	node, ok := m.files[string(hash)]
+	if !ok || node.Size != size {
+		return nil
+	}
+	return node
+}
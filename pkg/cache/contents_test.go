@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentMap(t *testing.T) {
+	file := func(path string, size uint64, hash string) *Node {
+		return &Node{Type: "file", AbsolutePath: path, Size: size, Sha256Hash: Sha256Hash(hash),
+			Content: []*ChunkInfo{{Length: uint(size), Etag: "etag-" + hash}}}
+	}
+
+	m := NewContentMap()
+	require.NotNil(t, m)
+	assert.False(t, m.HasSize(3))
+
+	index := NewIndex("bd", "rp")
+	index.Items["/data/a"] = file("/data/a", 3, "h1")
+	index.Items["/data"] = &Node{Type: "dir", AbsolutePath: "/data"}
+	sparse := file("/data/sparse", 8, "h2")
+	sparse.Sparse = true
+	index.Items["/data/sparse"] = sparse
+	m.AddIndex(index)
+
+	checksums := &ChecksumCache{Items: map[string]*Node{"/data/b": file("/data/b", 5, "h3")}}
+	m.AddChecksums(checksums)
+	m.AddChecksums(nil)
+
+	assert.True(t, m.HasSize(3))
+	assert.True(t, m.HasSize(5))
+	assert.False(t, m.HasSize(8), "sparse files are not added")
+	found := m.Lookup(3, Sha256Hash("h1"))
+	require.NotNil(t, found)
+	assert.Equal(t, "etag-h1", found.Content[0].Etag)
+	assert.Nil(t, m.Lookup(4, Sha256Hash("h1")), "size must match")
+	assert.Nil(t, m.Lookup(3, Sha256Hash("h4")))
+
+	// the first file with a content is kept
+	m.Add(file("/data/c", 3, "h1"))
+	assert.Equal(t, "/data/a", m.Lookup(3, Sha256Hash("h1")).AbsolutePath)
+	m.Add(&Node{Type: "file", Size: 7, Sha256Hash: Sha256Hash("h5")})
+	assert.False(t, m.HasSize(7), "files without content are not added")
+
+	var disabled *ContentMap
+	disabled.Add(file("/data/a", 3, "h1"))
+	assert.False(t, disabled.HasSize(3))
+	assert.Nil(t, disabled.Lookup(3, Sha256Hash("h1")))
+
+	viper.Set("file_dedup", false)
+	defer viper.Set("file_dedup", true)
+	assert.Nil(t, NewContentMap())
+}
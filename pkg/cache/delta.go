@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// IsDelta reports whether idx is a delta index, which is applied to the full index of its base to get all items.
+func (idx *Index) IsDelta() bool {
+	return idx.Base != ""
+}
+
+// Delta returns the delta index of idx against base, the full index of recovery point baseID whose index.json has
+// hash baseHash. Items which only differ by access time are unchanged. previous is the number of delta indexes
+// already made since base.
+func (idx *Index) Delta(base *Index, baseID, baseHash string, previous int) *Index {
+	delta := NewIndex(idx.BackupDirectoryID, idx.RecoveryPointID)
+	delta.TotalFiles = idx.TotalFiles
+	delta.Base = baseID
+	delta.BaseHash = baseHash
+	delta.Sequence = previous + 1
+	for path, node := range idx.Items {
+		if !sameNode(base.Items[path], node) {
+			delta.Items[path] = node
+		}
+	}
+	for path := range base.Items {
+		if _, ok := idx.Items[path]; !ok {
+			delta.Removed = append(delta.Removed, path)
+		}
+	}
+	sort.Strings(delta.Removed)
+	return delta
+}
+
+// Apply returns the full index of delta index idx, applied to base, the full index of its base recovery point.
+// Items are shared with idx and base.
+func (idx *Index) Apply(base *Index) *Index {
+	full := NewIndex(idx.BackupDirectoryID, idx.RecoveryPointID)
+	full.TotalFiles = idx.TotalFiles
+	for path, node := range base.Items {
+		full.Items[path] = node
+	}
+	for _, path := range idx.Removed {
+		delete(full.Items, path)
+	}
+	for path, node := range idx.Items {
+		full.Items[path] = node
+	}
+	return full
+}
+
+// sameNode reports whether a and b are the same item, ignoring access time.
+func sameNode(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(nodeJSON(*a), nodeJSON(*b))
+}
+
+func nodeJSON(node Node) []byte {
+	node.AccessTime = time.Time{}
+	buf, _ := json.Marshal(node)
+	return buf
+}
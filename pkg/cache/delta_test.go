@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexDelta(t *testing.T) {
+	mtime := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	base := NewIndex("bd", "rp1")
+	base.Items["/data/same"] = &Node{AbsolutePath: "/data/same", Type: "file", ModTime: mtime, AccessTime: mtime}
+	base.Items["/data/changed"] = &Node{AbsolutePath: "/data/changed", Type: "file", ModTime: mtime, Size: 1}
+	base.Items["/data/removed"] = &Node{AbsolutePath: "/data/removed", Type: "file", ModTime: mtime}
+
+	index := NewIndex("bd", "rp2")
+	index.TotalFiles = 3
+	index.Items["/data/same"] = &Node{AbsolutePath: "/data/same", Type: "file", ModTime: mtime, AccessTime: mtime.Add(time.Hour)}
+	index.Items["/data/changed"] = &Node{AbsolutePath: "/data/changed", Type: "file", ModTime: mtime, Size: 2}
+	index.Items["/data/added"] = &Node{AbsolutePath: "/data/added", Type: "file", ModTime: mtime}
+
+	delta := index.Delta(base, "rp1", "hash", 2)
+	assert.True(t, delta.IsDelta())
+	assert.False(t, index.IsDelta())
+	assert.Equal(t, "rp1", delta.Base)
+	assert.Equal(t, "hash", delta.BaseHash)
+	assert.Equal(t, 3, delta.Sequence)
+	assert.Equal(t, int64(3), delta.TotalFiles)
+	assert.Len(t, delta.Items, 2)
+	assert.Contains(t, delta.Items, "/data/changed")
+	assert.Contains(t, delta.Items, "/data/added")
+	assert.Equal(t, []string{"/data/removed"}, delta.Removed)
+
+	full := delta.Apply(base)
+	assert.False(t, full.IsDelta())
+	assert.Equal(t, "rp2", full.RecoveryPointID)
+	assert.Equal(t, int64(3), full.TotalFiles)
+	assert.Len(t, full.Items, 3)
+	assert.Equal(t, uint64(2), full.Items["/data/changed"].Size)
+	assert.Contains(t, full.Items, "/data/same")
+	assert.NotContains(t, full.Items, "/data/removed")
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package cache
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull reports whether err is caused by no space left on the disk.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
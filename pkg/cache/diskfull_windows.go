@@ -0,0 +1,16 @@
+package cache
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// isDiskFull reports whether err is caused by no space left on the disk.
+func isDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// fileListFlushRows is the number of rows of file.csv buffered in memory before they are written to disk.
+const fileListFlushRows = 1000
+
+var fileListHeader = []string{"name", "hash", "path", "size", "type", "modify_time"}
+
+// FileList writes rows of file.csv while items are backed up, so the list is not built from the whole index
+// when the backup finishes. It is safe for concurrent use.
+type FileList struct {
+	mu   sync.Mutex
+	r    *Repository
+	f    *os.File
+	w    *csv.Writer
+	rows int
+}
+
+// NewFileList starts file.csv of the repository in a temp file, which is moved in place by Close.
+func (r *Repository) NewFileList() (*FileList, error) {
+	f, err := r.tempFile()
+	if err != nil {
+		return nil, writeError(err)
+	}
+	w := csv.NewWriter(fileWriter{f: f})
+	if err := w.Write(fileListHeader); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &FileList{r: r, f: f, w: w}, nil
+}
+
+// Add writes the row of node. Hash and size are only given for files, so a file must be added after it is uploaded.
+func (l *FileList) Add(node *Node) error {
+	var hash string
+	var size uint64
+	if node.Type == "file" {
+		hash = node.Sha256Hash.String()
+		size = node.Size
+	}
+	row := []string{node.Name, hash, node.AbsolutePath, strconv.FormatUint(size, 10), node.Type, node.ModTime.String()}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Write(row); err != nil {
+		return err
+	}
+	l.rows++
+	if l.rows%fileListFlushRows == 0 {
+		l.w.Flush()
+		return l.w.Error()
+	}
+	return nil
+}
+
+// Close flushes buffered rows and renames the temp file to file.csv. Calling Close again does nothing.
+func (l *FileList) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	f := l.f
+	l.f = nil
+
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return writeError(err)
+	}
+	return l.r.renameFile(f, FILES)
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileList(t *testing.T) {
+	r, err := NewRepository(t.TempDir(), "mc", "rp")
+	require.NoError(t, err)
+	list, err := r.NewFileList()
+	require.NoError(t, err)
+
+	mtime := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, list.Add(&Node{Name: "dir", AbsolutePath: "/data/dir", Type: "dir", Size: 4096, ModTime: mtime}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2*fileListFlushRows; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("file%d", i)
+			assert.NoError(t, list.Add(&Node{Name: name, AbsolutePath: "/data/dir/" + name, Type: "file", Size: 3, Sha256Hash: []byte{0xab}, ModTime: mtime}))
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = os.Stat(r.filename(FILES))
+	assert.True(t, os.IsNotExist(err), "file.csv must not exist before Close")
+	require.NoError(t, list.Close())
+	require.NoError(t, list.Close())
+
+	f, err := os.Open(filepath.Join(r.path, "mc", "rp", "file.csv"))
+	require.NoError(t, err)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2+2*fileListFlushRows)
+	assert.Equal(t, fileListHeader, rows[0])
+	assert.Equal(t, []string{"dir", "", "/data/dir", "0", "dir", mtime.String()}, rows[1])
+	assert.Equal(t, "ab", rows[2][1])
+	assert.Equal(t, "3", rows[2][3])
+}
@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/spf13/viper"
+
 	"github.com/bizflycloud/bizfly-backup/pkg/support"
 )
 
@@ -18,6 +20,55 @@ type Index struct {
 	RecoveryPointID   string           `json:"recovery_point_id"`
 	Items             map[string]*Node `json:"items"`
 	TotalFiles        int64            `json:"total_files"`
+
+	// Base is the recovery point of the full index a delta index applies to, BaseHash is the hash of its index.json.
+	// Items of a delta index are the items changed since the base, Removed are the paths of base items removed
+	// since. Base is empty for a full index.
+	Base     string   `json:"base,omitempty"`
+	BaseHash string   `json:"base_hash,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+	// Sequence counts the delta indexes made since the base, including this one.
+	Sequence int `json:"sequence,omitempty"`
+
+	// ChunkHash is the SHA-256 of chunk.json of the recovery point, the index hash kept by the backup server covers
+	// it. It is empty for recovery points made by older agents.
+	ChunkHash string `json:"chunk_hash,omitempty"`
+
+	// inodes maps device and inode of files with hard links to the first of them added to index.
+	inodes map[fileID]string
+}
+
+type fileID struct {
+	device, inode uint64
+}
+
+// TrackHardLink links file node with hard links to the first item of index with the same device and inode, by
+// setting its HardLink. Items must be tracked in a stable order, e.g. the order of a directory walk.
+func (idx *Index) TrackHardLink(node *Node) {
+	if node.Type != "file" || node.Links < 2 {
+		return
+	}
+	id := fileID{device: node.Device, inode: node.Inode}
+	if first, ok := idx.inodes[id]; ok {
+		node.HardLink = first
+		return
+	}
+	if idx.inodes == nil {
+		idx.inodes = make(map[fileID]string)
+	}
+	idx.inodes[id] = node.AbsolutePath
+}
+
+// HardLinkTarget returns the item node is a hard link of, or nil when node is not a hard link of an item of index.
+func (idx *Index) HardLinkTarget(node *Node) *Node {
+	if node.HardLink == "" {
+		return nil
+	}
+	target, ok := idx.Items[node.HardLink]
+	if !ok || target.Type != "file" || target.HardLink != "" {
+		return nil
+	}
+	return target
 }
 
 func NewIndex(bdID string, rpID string) *Index {
@@ -28,10 +79,35 @@ func NewIndex(bdID string, rpID string) *Index {
 	}
 }
 
+// ChunkInfo is a chunk of file content. Start is the offset of chunk in file, it is uint64 so
+// files larger than 4GiB are represented correctly on 32-bit platforms too.
 type ChunkInfo struct {
-	Start  uint   `json:"start"`
+	Start  uint64 `json:"start"`
 	Length uint   `json:"length"`
 	Etag   string `json:"etag"`
+	// Compression is the algorithm the object of chunk is compressed with, StoredLength is the size of the object.
+	Compression  string `json:"compression,omitempty"`
+	StoredLength uint   `json:"stored_length,omitempty"`
+	// Pack is the key of the packfile the object of a small chunk is stored in, at Offset. Chunks which are not
+	// packed are objects of their own keyed by Etag.
+	Pack   string `json:"pack,omitempty"`
+	Offset uint64 `json:"offset,omitempty"`
+}
+
+// ObjectKey returns the key of the object of storage vault holding chunk, its packfile when it is packed.
+func (c *ChunkInfo) ObjectKey() string {
+	if c.Pack != "" {
+		return c.Pack
+	}
+	return c.Etag
+}
+
+// ObjectLength returns the size of the object of chunk in storage vault.
+func (c *ChunkInfo) ObjectLength() uint {
+	if c.StoredLength > 0 {
+		return c.StoredLength
+	}
+	return c.Length
 }
 
 type Node struct {
@@ -52,6 +128,51 @@ type Node struct {
 	AbsolutePath string       `json:"path"`
 	BasePath     string       `json:"base_path"`
 	RelativePath string       `json:"relative_path"`
+	Fingerprint  string       `json:"fingerprint,omitempty"`
+	// ChunkMap holds the chunk list of a file with more than ChunkMapPageSize chunks in place of Content. Its pages
+	// are objects of storage vault stored like chunks, each the ChunkInfo of up to ChunkMapPageSize chunks of the file
+	// in order, encoded by EncodeChunkMapPage; Start of a page is the offset of its first chunk. Chunks is the number of
+	// chunks of a file with a chunk map.
+	ChunkMap []*ChunkInfo `json:"chunk_map,omitempty"`
+	Chunks   uint64       `json:"chunks,omitempty"`
+	// Device, Inode and Links identify files with several hard links, Device and Inode are compared to detect
+	// changed files too. HardLink is the absolute path of the first item of index linked to the same file, whose
+	// content is backed up once and restored as a hard link.
+	Device   uint64 `json:"device,omitempty"`
+	Inode    uint64 `json:"inode,omitempty"`
+	Links    uint64 `json:"links,omitempty"`
+	HardLink string `json:"hardlink,omitempty"`
+	// Sparse files have holes, which are not in Content and restored as holes, Size is the size with holes.
+	Sparse bool `json:"sparse,omitempty"`
+	// ExtendedAttributes of files and directories, with POSIX ACLs, are kept when "xattrs" config is enabled.
+	ExtendedAttributes []ExtendedAttribute `json:"xattrs,omitempty"`
+
+	// Attributes are NTFS file attributes (FILE_ATTRIBUTE_*) of items backed up on Windows.
+	Attributes uint32 `json:"attributes,omitempty"`
+	// Streams are NTFS alternate data streams of a file, their content is chunked like file content. They are kept
+	// unless "ntfs_streams" config is disabled.
+	Streams []*Stream `json:"streams,omitempty"`
+	// SecurityDescriptor is the owner, group and DACL of a file or directory backed up on Windows in SDDL, kept
+	// unless "ntfs_acl" config is disabled.
+	SecurityDescriptor string `json:"security_descriptor,omitempty"`
+	// ReparseTag and ReparseData are the reparse point of a directory junction backed up on Windows,
+	// which is restored as a junction instead of a symlink.
+	ReparseTag  uint32 `json:"reparse_tag,omitempty"`
+	ReparseData []byte `json:"reparse_data,omitempty"`
+}
+
+// ExtendedAttribute is an extended attribute of an item, e.g. "user.mime_type" or "system.posix_acl_access".
+type ExtendedAttribute struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+// Stream is an NTFS alternate data stream of a file.
+type Stream struct {
+	Name       string       `json:"name"`
+	Size       uint64       `json:"size"`
+	Sha256Hash Sha256Hash   `json:"sha256_hash,omitempty"`
+	Content    []*ChunkInfo `json:"content,omitempty"`
 }
 
 type Sha256Hash []byte
@@ -80,29 +201,68 @@ func (h *Sha256Hash) UnmarshalJSON(b []byte) error {
 }
 
 func (node *Node) fill_extra(path string, fi os.FileInfo) (err error) {
-	atime, ctime, _, uid, gid, size := support.ItemLocal(fi)
-	node.ChangeTime = ctime
-	node.AccessTime = atime
-	node.UID = uid
-	node.GID = gid
+	md := support.FileMetadata(fi)
+	node.ChangeTime = md.Ctime
+	node.AccessTime = md.Atime
+	node.UID = md.UID
+	node.GID = md.GID
+	node.Attributes = support.FileAttributes(fi)
 
-	if u, nil := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+	if u, err := user.LookupId(strconv.Itoa(int(md.UID))); err == nil {
 		node.User = u.Username
 	}
 
 	switch node.Type {
 	case "file":
-		node.Size = uint64(size)
+		node.Size = uint64(md.Size)
+		node.Device, node.Inode, node.Links = md.Device, md.Inode, md.Links
+		if enabled("ntfs_streams") {
+			var streams []support.Stream
+			streams, err = support.AlternateStreams(path)
+			for _, s := range streams {
+				node.Streams = append(node.Streams, &Stream{Name: s.Name, Size: uint64(s.Size)})
+			}
+		}
 	case "dir":
 		// nothing to do
 	case "symlink":
 		node.LinkTarget, err = os.Readlink(path)
+		if err == nil && node.Attributes&support.FileAttributeReparsePoint != 0 {
+			var tag uint32
+			var data []byte
+			tag, data, err = support.ReadReparsePoint(path)
+			if tag == support.ReparseTagMountPoint {
+				node.ReparseTag, node.ReparseData = tag, data
+			}
+		}
 	default:
 		fmt.Printf(" %s invalid node type %q", path, node.Type)
 	}
+	if err == nil && (node.Type == "file" || node.Type == "dir") && viper.GetBool("xattrs") {
+		err = node.fillExtendedAttributes(path)
+	}
+	if err == nil && (node.Type == "file" || node.Type == "dir") && enabled("ntfs_acl") {
+		node.SecurityDescriptor, err = support.SecurityDescriptor(path)
+	}
 	return err
 }
 
+// enabled reports whether boolean config key, which defaults to true, is enabled.
+func enabled(key string) bool {
+	return !viper.IsSet(key) || viper.GetBool(key)
+}
+
+func (node *Node) fillExtendedAttributes(path string) error {
+	attrs, err := support.ExtendedAttributes(path)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		node.ExtendedAttributes = append(node.ExtendedAttributes, ExtendedAttribute{Name: attr.Name, Value: attr.Value})
+	}
+	return nil
+}
+
 func NodeFromFileInfo(rootPath string, pathName string, fi os.FileInfo) (*Node, error) {
 	rel, err := filepath.Rel(rootPath, pathName)
 	if err != nil {
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackHardLink(t *testing.T) {
+	idx := NewIndex("bd", "rp")
+	first := &Node{Type: "file", AbsolutePath: "/data/a", Device: 1, Inode: 10, Links: 2}
+	second := &Node{Type: "file", AbsolutePath: "/data/b", Device: 1, Inode: 10, Links: 2}
+	other := &Node{Type: "file", AbsolutePath: "/data/c", Device: 2, Inode: 10, Links: 2}
+	single := &Node{Type: "file", AbsolutePath: "/data/d", Device: 1, Inode: 11, Links: 1}
+	for _, node := range []*Node{first, second, other, single} {
+		idx.TrackHardLink(node)
+		idx.Items[node.AbsolutePath] = node
+	}
+
+	assert.Empty(t, first.HardLink)
+	assert.Equal(t, "/data/a", second.HardLink)
+	assert.Empty(t, other.HardLink)
+	assert.Empty(t, single.HardLink)
+
+	assert.Nil(t, idx.HardLinkTarget(first))
+	assert.Same(t, first, idx.HardLinkTarget(second))
+
+	delete(idx.Items, "/data/a")
+	assert.Nil(t, idx.HardLinkTarget(second))
+}
+
+func TestEnabled(t *testing.T) {
+	defer viper.Reset()
+
+	assert.True(t, enabled("ntfs_acl"))
+	viper.Set("ntfs_acl", false)
+	assert.False(t, enabled("ntfs_acl"))
+	viper.Set("ntfs_acl", true)
+	assert.True(t, enabled("ntfs_acl"))
+}
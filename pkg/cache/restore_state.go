@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// RestoreState is the progress of a restore, saved periodically to the repository of its recovery point so a restore
+// interrupted by an agent restart or a failure resumes instead of downloading everything again.
+type RestoreState struct {
+	// Key identifies the restore, only a restore of the same key resumes the state.
+	Key             string    `json:"key"`
+	ActionID        string    `json:"action_id"`
+	RecoveryPointID string    `json:"recovery_point_id"`
+	SavedAt         time.Time `json:"saved_at"`
+	// Restored are the items and chunks restored, as encoded by the restore.
+	Restored json.RawMessage `json:"restored"`
+}
+
+// SaveRestoreState writes restore.json of the repository.
+func (r *Repository) SaveRestoreState(s *RestoreState) error {
+	s.SavedAt = time.Now()
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return r.saveFile(buf, RESTORE_STATE)
+}
+
+// RemoveRestoreState removes restore.json of the repository, once its restore finished.
+func (r *Repository) RemoveRestoreState() error {
+	if err := os.Remove(r.filename(RESTORE_STATE)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RestoreState returns the restore state saved in the repository, nil when there is none.
+func (r *Repository) RestoreState() (*RestoreState, error) {
+	buf, err := ioutil.ReadFile(r.filename(RESTORE_STATE))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var s RestoreState
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreState(t *testing.T) {
+	r, err := NewRepository(t.TempDir(), "mc", "rp1")
+	require.NoError(t, err)
+
+	state, err := r.RestoreState()
+	require.NoError(t, err)
+	assert.Nil(t, state)
+
+	require.NoError(t, r.SaveRestoreState(&RestoreState{Key: "/restore", ActionID: "action1", RecoveryPointID: "rp1",
+		Restored: json.RawMessage(`{"items":["/data/a"]}`)}))
+	state, err = r.RestoreState()
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, "/restore", state.Key)
+	assert.Equal(t, "action1", state.ActionID)
+	assert.False(t, state.SavedAt.IsZero())
+	assert.JSONEq(t, `{"items":["/data/a"]}`, string(state.Restored))
+
+	require.NoError(t, r.RemoveRestoreState())
+	require.NoError(t, r.RemoveRestoreState())
+	state, err = r.RestoreState()
+	require.NoError(t, err)
+	assert.Nil(t, state)
+}
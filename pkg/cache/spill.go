@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// SpilledItems are the items of an index spilled to a file, only the place of each item in the file stays in memory.
+// It is used by backups in low-memory mode for the index of the latest recovery point, whose items are only looked up
+// by path. The places of items are kept in memory, so its memory still grows with the number of items, by the length
+// of their paths. A nil SpilledItems has no items. It is safe for concurrent use.
+type SpilledItems struct {
+	f      *os.File
+	places map[string]spillPlace
+}
+
+// spillPlace is the offset and length of the JSON of an item in the spill file.
+type spillPlace struct {
+	offset int64
+	length int
+}
+
+// SpillItems writes items to a new file in directory dir, the file is removed by Close.
+func SpillItems(dir string, items map[string]*Node) (*SpilledItems, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(dir, "spill-")
+	if err != nil {
+		return nil, err
+	}
+	s := &SpilledItems{f: f, places: make(map[string]spillPlace, len(items))}
+	var offset int64
+	for path, item := range items {
+		buf, err := json.Marshal(item)
+		if err == nil {
+			_, err = f.Write(buf)
+		}
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+		s.places[path] = spillPlace{offset: offset, length: len(buf)}
+		offset += int64(len(buf))
+	}
+	return s, nil
+}
+
+// Len returns the number of items spilled.
+func (s *SpilledItems) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.places)
+}
+
+// Get reads the item of path back, it returns nil when there is none or it can not be read.
+func (s *SpilledItems) Get(path string) *Node {
+	if s == nil {
+		return nil
+	}
+	place, ok := s.places[path]
+	if !ok {
+		return nil
+	}
+	buf := make([]byte, place.length)
+	if _, err := s.f.ReadAt(buf, place.offset); err != nil {
+		return nil
+	}
+	var item Node
+	if err := json.Unmarshal(buf, &item); err != nil {
+		return nil
+	}
+	return &item
+}
+
+// Close removes the spill file.
+func (s *SpilledItems) Close() error {
+	if s == nil {
+		return nil
+	}
+	err := s.f.Close()
+	if errRemove := os.Remove(s.f.Name()); err == nil {
+		err = errRemove
+	}
+	return err
+}
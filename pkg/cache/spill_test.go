@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillItems(t *testing.T) {
+	dir := t.TempDir()
+	items := map[string]*Node{
+		"/data/a": {Name: "a", Type: "file", AbsolutePath: "/data/a", Size: 3, Content: []*ChunkInfo{{Etag: "e1", Length: 3}}},
+		"/data":   {Name: "data", Type: "dir", AbsolutePath: "/data"},
+	}
+	s, err := SpillItems(dir, items)
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, items["/data/a"], s.Get("/data/a"))
+	assert.Equal(t, items["/data"], s.Get("/data"))
+	assert.Nil(t, s.Get("/data/b"))
+
+	require.NoError(t, s.Close())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "spill file is removed")
+
+	var none *SpilledItems
+	assert.Nil(t, none.Get("/data/a"))
+	assert.NoError(t, none.Close())
+}
@@ -0,0 +1,53 @@
+// Package compression compresses chunks before they are uploaded to storage vault.
+package compression
+
+import (
+	"fmt"
+
+	lz4 "github.com/bkaradzic/go-lz4"
+)
+
+const (
+	// None stores chunks as they are.
+	None = ""
+	// LZ4 compresses chunks by LZ4 block format, fast enough to not slow down uploads.
+	LZ4 = "lz4"
+)
+
+// Check returns an error when algorithm is not supported.
+func Check(algorithm string) error {
+	switch algorithm {
+	case None, LZ4:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression %q", algorithm)
+}
+
+// Compress returns data compressed by algorithm.
+func Compress(algorithm string, data []byte) ([]byte, error) {
+	switch algorithm {
+	case None:
+		return data, nil
+	case LZ4:
+		return lz4.Encode(nil, data)
+	}
+	return nil, Check(algorithm)
+}
+
+// Decompress returns data decompressed by algorithm, length is the size of decompressed data.
+func Decompress(algorithm string, data []byte, length int) ([]byte, error) {
+	switch algorithm {
+	case None:
+		return data, nil
+	case LZ4:
+		out, err := lz4.Decode(make([]byte, length), data)
+		if err != nil {
+			return nil, fmt.Errorf("decompress lz4: %w", err)
+		}
+		if len(out) != length {
+			return nil, fmt.Errorf("decompress lz4: got %d bytes, want %d", len(out), length)
+		}
+		return out, nil
+	}
+	return nil, Check(algorithm)
+}
@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("some text which compresses well\n"), 1024)
+	for _, algorithm := range []string{None, LZ4} {
+		t.Run(algorithm, func(t *testing.T) {
+			require.NoError(t, Check(algorithm))
+			compressed, err := Compress(algorithm, data)
+			require.NoError(t, err)
+			if algorithm != None {
+				assert.Less(t, len(compressed), len(data)/10)
+			}
+			got, err := Decompress(algorithm, compressed, len(data))
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	data := bytes.Repeat([]byte("abc"), 1024)
+	compressed, err := Compress(LZ4, data)
+	require.NoError(t, err)
+
+	_, err = Decompress(LZ4, compressed[:len(compressed)/2], len(data))
+	assert.Error(t, err)
+	_, err = Decompress(LZ4, compressed, len(data)+1)
+	assert.Error(t, err)
+}
+
+func TestUnsupported(t *testing.T) {
+	assert.Error(t, Check("zip"))
+	_, err := Compress("zip", []byte("data"))
+	assert.Error(t, err)
+	_, err = Decompress("zip", []byte("data"), 4)
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+// Package crash writes crash reports of agent to disk when it panics, so they
+// can be uploaded to backup server on next startup.
+package crash
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	reportPrefix = "crash-"
+	reportExt    = ".json"
+
+	maxLastActions = 10
+	maxStackSize   = 1 << 20
+)
+
+// Report is the crash report of agent.
+type Report struct {
+	CreatedAt    time.Time         `json:"created_at"`
+	AgentVersion string            `json:"agent_version"`
+	OS           string            `json:"os"`
+	Arch         string            `json:"arch"`
+	Panic        string            `json:"panic"`
+	Stack        string            `json:"stack"`
+	LastActions  []string          `json:"last_actions"`
+	Config       map[string]string `json:"config"`
+}
+
+// Reporter records the recent actions of agent and writes crash report to dir on panic.
+type Reporter struct {
+	dir          string
+	agentVersion string
+	config       map[string]string
+
+	mu          sync.Mutex
+	lastActions []string
+}
+
+// NewReporter creates a Reporter which writes crash reports to dir.
+// config is a summary of agent config included in the reports, it must not contain secrets.
+func NewReporter(dir, agentVersion string, config map[string]string) *Reporter {
+	return &Reporter{
+		dir:          dir,
+		agentVersion: agentVersion,
+		config:       config,
+	}
+}
+
+// RecordAction remembers actionID as one of the last actions run by agent.
+func (r *Reporter) RecordAction(actionID string) {
+	if r == nil || actionID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastActions = append(r.lastActions, actionID)
+	if len(r.lastActions) > maxLastActions {
+		r.lastActions = r.lastActions[len(r.lastActions)-maxLastActions:]
+	}
+}
+
+// Recover writes a crash report then panics again, it must be called directly by defer.
+func (r *Reporter) Recover() {
+	if v := recover(); v != nil {
+		_, _ = r.Write(v)
+		panic(v)
+	}
+}
+
+// Write writes crash report of panic value v with stack traces of all goroutines.
+func (r *Reporter) Write(v interface{}) (string, error) {
+	if r == nil {
+		return "", nil
+	}
+	stack := make([]byte, maxStackSize)
+	stack = stack[:runtime.Stack(stack, true)]
+
+	r.mu.Lock()
+	lastActions := append([]string(nil), r.lastActions...)
+	r.mu.Unlock()
+
+	now := time.Now()
+	report := Report{
+		CreatedAt:    now.UTC(),
+		AgentVersion: r.agentVersion,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Panic:        fmt.Sprint(v),
+		Stack:        string(stack),
+		LastActions:  lastActions,
+		Config:       r.config,
+	}
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(r.dir, fmt.Sprintf("%s%d%s", reportPrefix, now.UnixNano(), reportExt))
+	if err := ioutil.WriteFile(name, buf, 0600); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Pending returns the paths of crash reports in dir which are not uploaded yet, oldest first.
+func Pending(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), reportPrefix) || !strings.HasSuffix(e.Name(), reportExt) {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, e.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// UploadPending uploads crash reports in dir by upload, which returns reference ID of report.
+// Uploaded reports are removed, the others are kept for next startup.
+func UploadPending(dir string, upload func(*Report) (string, error), logger *zap.Logger) error {
+	paths, err := Pending(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var report Report
+		if err := json.Unmarshal(buf, &report); err != nil {
+			logger.Error("Invalid crash report, remove it", zap.String("path", path), zap.Error(err))
+			_ = os.Remove(path)
+			continue
+		}
+		refID, err := upload(&report)
+		if err != nil {
+			return err
+		}
+		logger.Info("Uploaded crash report", zap.String("path", path), zap.String("reference_id", refID),
+			zap.Time("crashed_at", report.CreatedAt), zap.String("panic", report.Panic))
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
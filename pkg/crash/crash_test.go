@@ -0,0 +1,67 @@
+package crash
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReporter_Recover(t *testing.T) {
+	dir := t.TempDir()
+	r := NewReporter(dir, "v0.0.1", map[string]string{"machine_id": "m1"})
+	for i := 0; i < maxLastActions+2; i++ {
+		r.RecordAction(string(rune('a' + i)))
+	}
+
+	assert.PanicsWithValue(t, "boom", func() {
+		defer r.Recover()
+		panic("boom")
+	})
+
+	paths, err := Pending(dir)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+
+	var uploaded []*Report
+	err = UploadPending(dir, func(report *Report) (string, error) {
+		uploaded = append(uploaded, report)
+		return "ref-1", nil
+	}, zap.NewNop())
+	require.NoError(t, err)
+	require.Len(t, uploaded, 1)
+	assert.Equal(t, "boom", uploaded[0].Panic)
+	assert.Equal(t, "v0.0.1", uploaded[0].AgentVersion)
+	assert.Equal(t, "m1", uploaded[0].Config["machine_id"])
+	assert.Len(t, uploaded[0].LastActions, maxLastActions)
+	assert.Equal(t, "l", uploaded[0].LastActions[maxLastActions-1])
+	assert.Contains(t, uploaded[0].Stack, "TestReporter_Recover")
+
+	paths, err = Pending(dir)
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+}
+
+func TestUploadPending_KeepOnError(t *testing.T) {
+	dir := t.TempDir()
+	r := NewReporter(dir, "dev", nil)
+	_, err := r.Write("boom")
+	require.NoError(t, err)
+
+	err = UploadPending(dir, func(*Report) (string, error) {
+		return "", errors.New("unavailable")
+	}, zap.NewNop())
+	assert.Error(t, err)
+
+	paths, err := Pending(dir)
+	require.NoError(t, err)
+	assert.Len(t, paths, 1)
+}
+
+func TestPending_NotExist(t *testing.T) {
+	paths, err := Pending(t.TempDir() + "/missing")
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+}
@@ -0,0 +1,247 @@
+// Package filter selects the paths of a backup directory which are backed up, by exclude and include patterns.
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// IgnoreFile is the file in the root of a backup directory holding local patterns, one per line.
+const IgnoreFile = ".backupignore"
+
+// CacheDirTag is the file marking a cache directory, see https://bford.info/cachedir/.
+const CacheDirTag = "CACHEDIR.TAG"
+
+// cacheDirSignature starts the content of a valid CacheDirTag.
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// regexPrefix starts patterns which are regular expressions matched against the whole relative path.
+const regexPrefix = "re:"
+
+type pattern struct {
+	include bool
+	dirOnly bool
+	// anchored globs are matched against the relative path, others against the base name.
+	anchored bool
+	glob     string
+	re       *regexp.Regexp
+}
+
+// Filter decides whether a path of a backup directory is excluded. Like .gitignore, the last pattern matching a
+// path decides: exclude patterns come first, then include patterns, then lines of IgnoreFile.
+type Filter struct {
+	patterns []pattern
+
+	// device is the device of the backup directory when the walk stays on one filesystem.
+	device    uint64
+	oneDevice bool
+
+	limits Limits
+
+	excludeCaches bool
+	excludeNoDump bool
+}
+
+// Limits exclude regular files by size and modification time, zero values do not limit.
+type Limits struct {
+	// MaxFileSize is the size in bytes above which files are skipped.
+	MaxFileSize int64
+	// MinModTime is the modification time before which files are skipped.
+	MinModTime time.Time
+}
+
+// New returns a filter of exclude and include patterns.
+//
+// Patterns are globs (e.g. "*.log", "node_modules/", "/tmp/*.tmp") or regular expressions prefixed with "re:".
+// A glob without "/" matches the base name at any depth, otherwise the path relative to the backup directory.
+// A trailing "/" only matches directories, an excluded directory is skipped with all its content.
+func New(excludes, includes []string) (*Filter, error) {
+	f := &Filter{}
+	for _, p := range excludes {
+		if err := f.add(p, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range includes {
+		if err := f.add(p, true); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Load returns a filter of exclude and include patterns, followed by the lines of IgnoreFile in dir when it exists.
+// Lines starting with "#" are comments, lines starting with "!" are include patterns.
+func Load(dir string, excludes, includes []string) (*Filter, error) {
+	f, err := New(excludes, includes)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		include := strings.HasPrefix(line, "!")
+		if err := f.add(strings.TrimPrefix(line, "!"), include); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", IgnoreFile, n, err)
+		}
+	}
+	return f, scanner.Err()
+}
+
+// OneFileSystem restricts the filter to the filesystem of dir, so mount points inside dir are skipped. It does
+// nothing where device IDs are not known.
+func (f *Filter) OneFileSystem(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	f.device, f.oneDevice = support.DeviceID(fi)
+	return nil
+}
+
+// OtherFileSystem reports whether fi is on another filesystem than the backup directory restricted by
+// OneFileSystem. A nil filter allows all filesystems.
+func (f *Filter) OtherFileSystem(fi os.FileInfo) bool {
+	if f == nil || !f.oneDevice {
+		return false
+	}
+	device, ok := support.DeviceID(fi)
+	return ok && device != f.device
+}
+
+// SetLimits excludes regular files outside l.
+func (f *Filter) SetLimits(l Limits) {
+	f.limits = l
+}
+
+// ExceedsLimits reports whether fi is a regular file excluded by the limits of the filter. A nil filter excludes
+// nothing.
+func (f *Filter) ExceedsLimits(fi os.FileInfo) bool {
+	if f == nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if f.limits.MaxFileSize > 0 && fi.Size() > f.limits.MaxFileSize {
+		return true
+	}
+	return !f.limits.MinModTime.IsZero() && fi.ModTime().Before(f.limits.MinModTime)
+}
+
+// ExcludeCaches excludes directories holding a valid CacheDirTag, e.g. browser caches.
+func (f *Filter) ExcludeCaches() {
+	f.excludeCaches = true
+}
+
+// ExcludeNoDump excludes files and directories with the nodump flag set by chattr or chflags.
+func (f *Filter) ExcludeNoDump() {
+	f.excludeNoDump = true
+}
+
+// Tagged reports whether path with info fi is a cache directory or has the nodump flag, when the filter excludes
+// them. A nil filter excludes nothing.
+func (f *Filter) Tagged(path string, fi os.FileInfo) bool {
+	if f == nil {
+		return false
+	}
+	if f.excludeCaches && fi.IsDir() && isCacheDir(path) {
+		return true
+	}
+	return f.excludeNoDump && support.NoDump(path, fi)
+}
+
+// isCacheDir reports whether dir holds a CacheDirTag starting with its signature.
+func isCacheDir(dir string) bool {
+	file, err := os.Open(filepath.Join(dir, CacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(file, buf); err != nil {
+		return false
+	}
+	return string(buf) == cacheDirSignature
+}
+
+func (f *Filter) add(s string, include bool) error {
+	p := pattern{include: include}
+	if strings.HasPrefix(s, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(s, regexPrefix))
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", s, err)
+		}
+		p.re = re
+		f.patterns = append(f.patterns, p)
+		return nil
+	}
+
+	s = filepath.ToSlash(s)
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	s = strings.TrimPrefix(s, "**/")
+	if strings.Contains(s, "/") {
+		p.anchored = true
+		s = strings.TrimPrefix(s, "/")
+	}
+	if s == "" {
+		return fmt.Errorf("empty pattern")
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", s, err)
+	}
+	p.glob = s
+	f.patterns = append(f.patterns, p)
+	return nil
+}
+
+// Excluded reports whether path rel, relative to the backup directory, is excluded. A nil filter excludes nothing.
+func (f *Filter) Excluded(rel string, isDir bool) bool {
+	if f == nil || rel == "." || rel == "" {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	excluded := false
+	for _, p := range f.patterns {
+		if p.match(rel, isDir) {
+			excluded = !p.include
+		}
+	}
+	return excluded
+}
+
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	name := rel
+	if !p.anchored {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
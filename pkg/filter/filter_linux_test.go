@@ -0,0 +1,87 @@
+//go:build linux
+// +build linux
+
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+func TestOtherFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	dirInfo, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	procInfo, err := os.Lstat("/proc")
+	if err != nil {
+		t.Skip("no /proc")
+	}
+	dirDevice, _ := support.DeviceID(dirInfo)
+	procDevice, _ := support.DeviceID(procInfo)
+	if dirDevice == procDevice {
+		t.Skip("/proc is on the filesystem of the temp dir")
+	}
+
+	var none *Filter
+	if none.OtherFileSystem(procInfo) {
+		t.Error("nil filter must allow all filesystems")
+	}
+
+	f, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.OtherFileSystem(procInfo) {
+		t.Error("filter must allow all filesystems before OneFileSystem")
+	}
+	if err := f.OneFileSystem(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !f.OtherFileSystem(procInfo) {
+		t.Error("/proc must be on another filesystem")
+	}
+	if f.OtherFileSystem(dirInfo) {
+		t.Error("backup directory must be on its own filesystem")
+	}
+}
+
+func TestTaggedNoDump(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nodump")
+	if err := ioutil.WriteFile(path, []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("chattr", "+d", path).CombinedOutput(); err != nil {
+		t.Skipf("chattr: %v %s", err, out)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Tagged(path, fi) {
+		t.Error("nodump must not be excluded before ExcludeNoDump")
+	}
+	f.ExcludeNoDump()
+	if !f.Tagged(path, fi) {
+		t.Error("nodump must be excluded")
+	}
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Tagged(dir, dirInfo) {
+		t.Error("directory without nodump must not be excluded")
+	}
+}
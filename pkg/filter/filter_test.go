@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterExcluded(t *testing.T) {
+	f, err := New([]string{"*.log", "node_modules/", "/build/*.tmp", `re:^cache/\d+$`}, []string{"keep.log"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{rel: "app.log", want: true},
+		{rel: filepath.Join("a", "b", "app.log"), want: true},
+		{rel: filepath.Join("a", "keep.log"), want: false},
+		{rel: "app.txt", want: false},
+		{rel: filepath.Join("web", "node_modules"), isDir: true, want: true},
+		{rel: "node_modules", want: false},
+		{rel: filepath.Join("build", "a.tmp"), want: true},
+		{rel: filepath.Join("src", "build", "a.tmp"), want: false},
+		{rel: filepath.Join("cache", "123"), want: true},
+		{rel: filepath.Join("cache", "abc"), want: false},
+		{rel: ".", isDir: true, want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, f.Excluded(tt.rel, tt.isDir), tt.rel)
+	}
+
+	var nilFilter *Filter
+	assert.False(t, nilFilter.Excluded("app.log", false))
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New([]string{"re:("}, nil)
+	assert.Error(t, err)
+	_, err = New([]string{"[a"}, nil)
+	assert.Error(t, err)
+	_, err = New(nil, []string{"/"})
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	f, err := Load(dir, []string{"*.log"}, nil)
+	require.NoError(t, err)
+	assert.True(t, f.Excluded("app.log", false))
+
+	ignore := "# local patterns\n\n*.bak\n!important.log\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte(ignore), 0600))
+	f, err = Load(dir, []string{"*.log"}, nil)
+	require.NoError(t, err)
+	assert.True(t, f.Excluded("app.log", false))
+	assert.True(t, f.Excluded("db.bak", false))
+	assert.False(t, f.Excluded("important.log", false))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, IgnoreFile), []byte("ok\nre:(\n"), 0600))
+	_, err = Load(dir, nil, nil)
+	assert.EqualError(t, err, ".backupignore:2: invalid pattern \"re:(\": error parsing regexp: missing closing ): `(`")
+}
+
+func TestFilterExceedsLimits(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, modTime time.Time) os.FileInfo {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, size), 0600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		return fi
+	}
+	small := write("small", 10, now)
+	large := write("large", 100, now)
+	old := write("old", 10, now.Add(-48*time.Hour))
+	dirInfo, err := os.Stat(dir)
+	require.NoError(t, err)
+
+	f, err := New(nil, nil)
+	require.NoError(t, err)
+	assert.False(t, f.ExceedsLimits(large))
+	assert.False(t, f.ExceedsLimits(old))
+
+	f.SetLimits(Limits{MaxFileSize: 50, MinModTime: now.Add(-24 * time.Hour)})
+	assert.False(t, f.ExceedsLimits(small))
+	assert.True(t, f.ExceedsLimits(large))
+	assert.True(t, f.ExceedsLimits(old))
+	assert.False(t, f.ExceedsLimits(dirInfo), "directories are not limited")
+
+	var nilFilter *Filter
+	assert.False(t, nilFilter.ExceedsLimits(large))
+}
+
+func TestFilterTaggedCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	fakeDir := filepath.Join(dir, "fake")
+	require.NoError(t, os.Mkdir(cacheDir, 0700))
+	require.NoError(t, os.Mkdir(fakeDir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, CacheDirTag), []byte(cacheDirSignature+"\n# cache\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(fakeDir, CacheDirTag), []byte("not a cache"), 0600))
+	cacheInfo, err := os.Stat(cacheDir)
+	require.NoError(t, err)
+	fakeInfo, err := os.Stat(fakeDir)
+	require.NoError(t, err)
+
+	f, err := New(nil, nil)
+	require.NoError(t, err)
+	assert.False(t, f.Tagged(cacheDir, cacheInfo))
+
+	f.ExcludeCaches()
+	assert.True(t, f.Tagged(cacheDir, cacheInfo))
+	assert.False(t, f.Tagged(fakeDir, fakeInfo), "tag without signature is ignored")
+
+	var nilFilter *Filter
+	assert.False(t, nilFilter.Tagged(cacheDir, cacheInfo))
+}
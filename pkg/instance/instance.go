@@ -0,0 +1,183 @@
+// Package instance captures the metadata of the cloud server agent runs on (instance ID, flavor, network config and
+// cloud-init user data) stored with a recovery point, so a lost server can be rebuilt with its original configuration.
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMetadataURL is the metadata service of BizFly Cloud servers, as of OpenStack.
+	DefaultMetadataURL = "http://169.254.169.254"
+
+	// DefaultCloudDir is the directory cloud-init keeps the data of instance in.
+	DefaultCloudDir = "/var/lib/cloud"
+
+	// requestTimeout bounds each request to the metadata service, which is not reachable outside a cloud server.
+	requestTimeout = 5 * time.Second
+
+	// maxDocumentSize is the size of documents read, larger ones are truncated. User data are at most 64KiB.
+	maxDocumentSize = 1 << 20
+)
+
+// Metadata is the metadata of instance at the time of a backup.
+type Metadata struct {
+	CollectedAt      time.Time         `json:"collected_at"`
+	InstanceID       string            `json:"instance_id,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	Hostname         string            `json:"hostname,omitempty"`
+	Flavor           string            `json:"flavor,omitempty"`
+	AvailabilityZone string            `json:"availability_zone,omitempty"`
+	ProjectID        string            `json:"project_id,omitempty"`
+	Meta             map[string]string `json:"meta,omitempty"`
+	PublicKeys       map[string]string `json:"public_keys,omitempty"`
+	// NetworkData is network_data.json of metadata service as is: links, networks and services of instance.
+	NetworkData json.RawMessage `json:"network_data,omitempty"`
+	// UserData is the cloud-init user data of instance, it may hold secrets of the cloud-config.
+	UserData string `json:"user_data,omitempty"`
+	// Errors are the errors of collectors by their name, the other parts of metadata are still collected.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// metaData is the part of meta_data.json of OpenStack metadata service kept in Metadata.
+type metaData struct {
+	UUID             string            `json:"uuid"`
+	Name             string            `json:"name"`
+	Hostname         string            `json:"hostname"`
+	AvailabilityZone string            `json:"availability_zone"`
+	ProjectID        string            `json:"project_id"`
+	Meta             map[string]string `json:"meta"`
+	PublicKeys       map[string]string `json:"public_keys"`
+}
+
+// errNotFound is returned for a path which the metadata service does not have, e.g. user data of an instance created
+// without.
+var errNotFound = errors.New("not found")
+
+// Collect captures the metadata of instance from metadata service metadataURL, and from cloud-init directory cloudDir
+// for what the service does not give. A failed collector is recorded in Errors and does not fail the others.
+func Collect(ctx context.Context, metadataURL, cloudDir string) *Metadata {
+	m := &Metadata{CollectedAt: time.Now().UTC()}
+	// the metadata service is link-local, it is never reached through a proxy
+	c := &http.Client{Timeout: requestTimeout, Transport: &http.Transport{}}
+	if err := collectService(ctx, c, strings.TrimSuffix(metadataURL, "/"), m); err != nil {
+		m.addError("metadata_service", err)
+	}
+	if err := collectCloudInit(cloudDir, m); err != nil {
+		m.addError("cloud_init", err)
+	}
+	return m
+}
+
+func (m *Metadata) addError(name string, err error) {
+	if m.Errors == nil {
+		m.Errors = make(map[string]string)
+	}
+	m.Errors[name] = err.Error()
+}
+
+// collectService adds the metadata given by the metadata service at url. Once meta_data.json can not be read, the
+// service is not requested again.
+func collectService(ctx context.Context, c *http.Client, url string, m *Metadata) error {
+	buf, err := get(ctx, c, url+"/openstack/latest/meta_data.json")
+	if err != nil {
+		return err
+	}
+	var md metaData
+	if err := json.Unmarshal(buf, &md); err != nil {
+		return fmt.Errorf("invalid meta_data.json: %w", err)
+	}
+	m.InstanceID, m.Name, m.Hostname = md.UUID, md.Name, md.Hostname
+	m.AvailabilityZone, m.ProjectID = md.AvailabilityZone, md.ProjectID
+	m.Meta, m.PublicKeys = md.Meta, md.PublicKeys
+
+	var errs []string
+	// flavor is only given by the EC2 compatible API
+	if buf, err := get(ctx, c, url+"/latest/meta-data/instance-type"); err == nil {
+		m.Flavor = strings.TrimSpace(string(buf))
+	} else if err != errNotFound {
+		errs = append(errs, "instance-type: "+err.Error())
+	}
+	if buf, err := get(ctx, c, url+"/openstack/latest/network_data.json"); err == nil {
+		if json.Valid(buf) {
+			m.NetworkData = buf
+		} else {
+			errs = append(errs, "network_data.json: invalid JSON")
+		}
+	} else if err != errNotFound {
+		errs = append(errs, "network_data.json: "+err.Error())
+	}
+	if buf, err := get(ctx, c, url+"/openstack/latest/user_data"); err == nil {
+		m.UserData = string(buf)
+	} else if err != errNotFound {
+		errs = append(errs, "user_data: "+err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// collectCloudInit adds the instance ID and user data kept by cloud-init in dir, when the metadata service did not
+// give them. A missing directory is not an error, the server may not run cloud-init.
+func collectCloudInit(dir string, m *Metadata) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if m.InstanceID == "" {
+		buf, err := ioutil.ReadFile(filepath.Join(dir, "data", "instance-id"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		m.InstanceID = strings.TrimSpace(string(buf))
+	}
+	if m.UserData == "" {
+		data, err := readFile(filepath.Join(dir, "instance", "user-data.txt"), maxDocumentSize)
+		if err != nil && !os.IsNotExist(err) {
+			// user data is only readable by root
+			return err
+		}
+		m.UserData = data
+	}
+	return nil
+}
+
+// get returns the body of url, errNotFound when the metadata service does not have it.
+func get(ctx context.Context, c *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+}
+
+func readFile(path string, limit int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(f, limit))
+	return string(buf), err
+}
@@ -0,0 +1,64 @@
+package instance
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/openstack/latest/meta_data.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"uuid": "0c7a3c51", "name": "web1", "hostname": "web1.novalocal", "availability_zone": "HN1",
+			"project_id": "p1", "meta": {"role": "web"}, "public_keys": {"key1": "ssh-ed25519 AAAA"}, "launch_index": 0}`))
+	})
+	mux.HandleFunc("/latest/meta-data/instance-type", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("2c_4g_basic\n"))
+	})
+	mux.HandleFunc("/openstack/latest/network_data.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"links": [], "networks": [{"id": "network0", "type": "ipv4_dhcp"}]}`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// user data is not given by the service, it is read from cloud-init
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "instance"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "instance", "user-data.txt"), []byte("#cloud-config\n"), 0600))
+
+	m := Collect(context.Background(), srv.URL, dir)
+	assert.Empty(t, m.Errors)
+	assert.Equal(t, "0c7a3c51", m.InstanceID)
+	assert.Equal(t, "web1", m.Name)
+	assert.Equal(t, "2c_4g_basic", m.Flavor)
+	assert.Equal(t, "HN1", m.AvailabilityZone)
+	assert.Equal(t, map[string]string{"role": "web"}, m.Meta)
+	assert.JSONEq(t, `{"links": [], "networks": [{"id": "network0", "type": "ipv4_dhcp"}]}`, string(m.NetworkData))
+	assert.Equal(t, "#cloud-config\n", m.UserData)
+}
+
+func TestCollectWithoutService(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data", "instance-id"), []byte("i-0001\n"), 0600))
+
+	m := Collect(context.Background(), srv.URL, dir)
+	assert.Contains(t, m.Errors, "metadata_service")
+	assert.NotContains(t, m.Errors, "cloud_init")
+	assert.Equal(t, "i-0001", m.InstanceID)
+
+	// a server without cloud-init has no metadata of instance
+	m = Collect(context.Background(), srv.URL, filepath.Join(dir, "missing"))
+	assert.Empty(t, m.InstanceID)
+	assert.NotContains(t, m.Errors, "cloud_init")
+}
@@ -0,0 +1,175 @@
+// Package inventory captures the system inventory of machine (installed packages, enabled services, crontabs and
+// listening ports) stored with a recovery point, for incident forensics and rebuild runbooks.
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxCrontabSize is the size of crontab files read, larger files are truncated.
+const maxCrontabSize = 64 << 10
+
+// Inventory is the system inventory of machine at the time of a backup.
+type Inventory struct {
+	CollectedAt time.Time `json:"collected_at"`
+	Hostname    string    `json:"hostname"`
+	OS          string    `json:"os"`
+	Arch        string    `json:"arch"`
+	Packages    []Package `json:"packages"`
+	Services    []Service `json:"services"`
+	Crontabs    []Crontab `json:"crontabs"`
+	Ports       []Port    `json:"ports"`
+	// Errors are the errors of collectors by their name, the other parts of inventory are still collected.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Package is an installed package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Manager string `json:"manager"`
+}
+
+// Service is a service started on boot.
+type Service struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Crontab is a crontab file.
+type Crontab struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Port is a listening socket.
+type Port struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+}
+
+// collector adds a part of inventory, collectors of the OS are listed in collectors.
+type collector struct {
+	name    string
+	collect func(ctx context.Context, inv *Inventory) error
+}
+
+// Collect captures the inventory of machine. A failed collector is recorded in Errors and does not fail the others.
+func Collect(ctx context.Context) *Inventory {
+	inv := &Inventory{
+		CollectedAt: time.Now().UTC(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		Packages:    []Package{},
+		Services:    []Service{},
+		Crontabs:    []Crontab{},
+		Ports:       []Port{},
+	}
+	inv.Hostname, _ = os.Hostname()
+	for _, c := range collectors {
+		if err := c.collect(ctx, inv); err != nil {
+			if inv.Errors == nil {
+				inv.Errors = make(map[string]string)
+			}
+			inv.Errors[c.name] = err.Error()
+		}
+	}
+	sort.Slice(inv.Packages, func(i, j int) bool {
+		if inv.Packages[i].Manager != inv.Packages[j].Manager {
+			return inv.Packages[i].Manager < inv.Packages[j].Manager
+		}
+		return inv.Packages[i].Name < inv.Packages[j].Name
+	})
+	sort.Slice(inv.Services, func(i, j int) bool { return inv.Services[i].Name < inv.Services[j].Name })
+	sort.Slice(inv.Ports, func(i, j int) bool {
+		if inv.Ports[i].Protocol != inv.Ports[j].Protocol {
+			return inv.Ports[i].Protocol < inv.Ports[j].Protocol
+		}
+		return inv.Ports[i].Port < inv.Ports[j].Port
+	})
+	return inv
+}
+
+// output runs command name when it is installed, ok is false when it is not.
+func output(ctx context.Context, name string, args ...string) (out []byte, ok bool, err error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, false, nil
+	}
+	out, err = exec.CommandContext(ctx, path, args...).Output()
+	return out, true, err
+}
+
+// lines returns non-empty lines of out.
+func lines(out []byte) []string {
+	var ls []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			ls = append(ls, line)
+		}
+	}
+	return ls
+}
+
+// parseTabPackages parses "<name>\t<version>" lines of package manager.
+func parseTabPackages(out []byte, manager string) []Package {
+	var packages []Package
+	for _, line := range lines(out) {
+		name, version := line, ""
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			name, version = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		packages = append(packages, Package{Name: name, Version: version, Manager: manager})
+	}
+	return packages
+}
+
+// readCrontabs adds crontab files matching patterns to inventory. Files which can not be read are skipped, the first
+// error is returned.
+func readCrontabs(inv *Inventory, patterns ...string) error {
+	var firstErr error
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fi, err := os.Stat(path)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+			content, err := readFile(path, maxCrontabSize)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			inv.Crontabs = append(inv.Crontabs, Crontab{Path: path, Content: content})
+		}
+	}
+	return firstErr
+}
+
+func readFile(path string, limit int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(f, limit))
+	return string(buf), err
+}
@@ -0,0 +1,64 @@
+package inventory
+
+import (
+	"context"
+	"strings"
+)
+
+var collectors = []collector{
+	{name: "packages", collect: collectPackages},
+	{name: "services", collect: collectServices},
+	{name: "crontabs", collect: func(ctx context.Context, inv *Inventory) error {
+		return readCrontabs(inv, "/etc/crontab", "/usr/lib/cron/tabs/*")
+	}},
+	{name: "ports", collect: collectPorts},
+}
+
+// collectPackages lists Homebrew formulae and casks.
+func collectPackages(ctx context.Context, inv *Inventory) error {
+	for _, kind := range []string{"--formula", "--cask"} {
+		out, ok, err := output(ctx, "brew", "list", "--versions", kind)
+		if !ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, line := range lines(out) {
+			fields := strings.Fields(line)
+			inv.Packages = append(inv.Packages, Package{Name: fields[0], Version: strings.Join(fields[1:], " "), Manager: "brew"})
+		}
+	}
+	return nil
+}
+
+// collectServices lists launchd services loaded in the system domain.
+func collectServices(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "launchctl", "list")
+	if !ok || err != nil {
+		return err
+	}
+	for i, line := range lines(out) {
+		fields := strings.Fields(line)
+		// PID Status Label, PID is "-" when the service is not running
+		if i == 0 || len(fields) < 3 {
+			continue
+		}
+		state := "running"
+		if fields[0] == "-" {
+			state = "loaded"
+		}
+		inv.Services = append(inv.Services, Service{Name: fields[2], State: state})
+	}
+	return nil
+}
+
+// collectPorts lists listening sockets by netstat.
+func collectPorts(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "netstat", "-an")
+	if !ok || err != nil {
+		return err
+	}
+	inv.Ports = append(inv.Ports, parseNetstat(out)...)
+	return nil
+}
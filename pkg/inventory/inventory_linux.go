@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var collectors = []collector{
+	{name: "packages", collect: collectPackages},
+	{name: "services", collect: collectServices},
+	{name: "crontabs", collect: func(ctx context.Context, inv *Inventory) error {
+		return readCrontabs(inv, "/etc/crontab", "/etc/cron.d/*", "/var/spool/cron/*", "/var/spool/cron/crontabs/*")
+	}},
+	{name: "ports", collect: collectPorts},
+}
+
+// collectPackages lists packages of dpkg, rpm and apk, whichever are installed.
+func collectPackages(ctx context.Context, inv *Inventory) error {
+	managers := []struct {
+		name string
+		args []string
+	}{
+		{name: "dpkg-query", args: []string{"-W", "-f", "${Package}\t${Version}\n"}},
+		{name: "rpm", args: []string{"-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\n"}},
+		{name: "apk", args: []string{"list", "--installed"}},
+	}
+	var firstErr error
+	for _, m := range managers {
+		out, ok, err := output(ctx, m.name, m.args...)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if m.name == "apk" {
+			inv.Packages = append(inv.Packages, parseApkPackages(out)...)
+			continue
+		}
+		inv.Packages = append(inv.Packages, parseTabPackages(out, strings.TrimSuffix(m.name, "-query"))...)
+	}
+	return firstErr
+}
+
+// parseApkPackages parses "<name>-<version>-r<release> <arch> {<origin>} (<license>) [installed]" lines of apk.
+func parseApkPackages(out []byte) []Package {
+	var packages []Package
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		nameVersion := fields[0]
+		// version starts at the second last "-", e.g. "musl-1.2.3-r4"
+		i := strings.LastIndexByte(nameVersion, '-')
+		if i > 0 {
+			i = strings.LastIndexByte(nameVersion[:i], '-')
+		}
+		if i <= 0 {
+			packages = append(packages, Package{Name: nameVersion, Manager: "apk"})
+			continue
+		}
+		packages = append(packages, Package{Name: nameVersion[:i], Version: nameVersion[i+1:], Manager: "apk"})
+	}
+	return packages
+}
+
+// collectServices lists systemd services enabled on boot.
+func collectServices(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "systemctl", "list-unit-files", "--type=service", "--state=enabled", "--no-legend", "--no-pager")
+	if !ok || err != nil {
+		return err
+	}
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		inv.Services = append(inv.Services, Service{Name: strings.TrimSuffix(fields[0], ".service"), State: fields[1]})
+	}
+	return nil
+}
+
+// collectPorts lists listening sockets in /proc/net.
+func collectPorts(ctx context.Context, inv *Inventory) error {
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		data, err := ioutil.ReadFile("/proc/net/" + protocol)
+		if errors.Is(err, os.ErrNotExist) {
+			// IPv6 is disabled
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		inv.Ports = append(inv.Ports, parseProcNet(data, protocol)...)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package inventory
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcNet(t *testing.T) {
+	tcp := []byte(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0277 0100007F:D2C4 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 20 4 30 10 -1
+`)
+	assert.Equal(t, []Port{{Protocol: "tcp", Address: "127.0.0.1", Port: 631}}, parseProcNet(tcp, "tcp"))
+
+	tcp6 := []byte(`  sl  local_address                         remote_address                        st
+   0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A
+   1: 00000000000000000000000001000000:0277 00000000000000000000000000000000:0000 0A
+`)
+	assert.Equal(t, []Port{{Protocol: "tcp6", Address: "::", Port: 22}, {Protocol: "tcp6", Address: "::1", Port: 631}}, parseProcNet(tcp6, "tcp6"))
+
+	udp := []byte(`  sl  local_address rem_address   st
+  0: 00000000:0044 00000000:0000 07
+`)
+	assert.Equal(t, []Port{{Protocol: "udp", Address: "0.0.0.0", Port: 68}}, parseProcNet(udp, "udp"))
+}
+
+func TestParseNetstat(t *testing.T) {
+	darwin := []byte(`Active Internet connections (including servers)
+Proto Recv-Q Send-Q  Local Address          Foreign Address        (state)
+tcp4       0      0  127.0.0.1.631          *.*                    LISTEN
+tcp4       0      0  192.168.1.2.53412      17.57.146.20.443       ESTABLISHED
+tcp46      0      0  *.22                   *.*                    LISTEN
+udp4       0      0  *.5353                 *.*
+`)
+	assert.Equal(t, []Port{
+		{Protocol: "tcp4", Address: "127.0.0.1", Port: 631},
+		{Protocol: "tcp46", Address: "*", Port: 22},
+		{Protocol: "udp4", Address: "*", Port: 5353},
+	}, parseNetstat(darwin))
+
+	windows := []byte(`
+Active Connections
+
+  Proto  Local Address          Foreign Address        State
+  TCP    0.0.0.0:135            0.0.0.0:0              LISTENING
+  TCP    10.0.0.5:49712         20.42.65.90:443        ESTABLISHED
+  TCP    [::]:445               [::]:0                 LISTENING
+  UDP    0.0.0.0:123            *:*
+`)
+	assert.Equal(t, []Port{
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 135},
+		{Protocol: "tcp", Address: "::", Port: 445},
+		{Protocol: "udp", Address: "0.0.0.0", Port: 123},
+	}, parseNetstat(windows))
+}
+
+func TestParseTabPackages(t *testing.T) {
+	out := []byte("bash\t5.1-6ubuntu1\nlibc6\t2.35-0ubuntu3\n\nnoversion\n")
+	assert.Equal(t, []Package{
+		{Name: "bash", Version: "5.1-6ubuntu1", Manager: "dpkg"},
+		{Name: "libc6", Version: "2.35-0ubuntu3", Manager: "dpkg"},
+		{Name: "noversion", Manager: "dpkg"},
+	}, parseTabPackages(out, "dpkg"))
+}
+
+func TestReadCrontabs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "backup"), []byte("0 2 * * * root /usr/bin/backup\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "large"), make([]byte, maxCrontabSize+1), 0644))
+
+	var inv Inventory
+	require.NoError(t, readCrontabs(&inv, filepath.Join(dir, "*"), filepath.Join(dir, "missing")))
+	require.Len(t, inv.Crontabs, 2)
+	assert.Equal(t, "0 2 * * * root /usr/bin/backup\n", inv.Crontabs[0].Content)
+	assert.Len(t, inv.Crontabs[1].Content, maxCrontabSize)
+}
+
+func TestCollect(t *testing.T) {
+	inv := Collect(context.Background())
+	assert.Equal(t, runtime.GOOS, inv.OS)
+	assert.NotNil(t, inv.Packages)
+	assert.NotNil(t, inv.Ports)
+	assert.False(t, inv.CollectedAt.IsZero())
+}
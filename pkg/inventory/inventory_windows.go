@@ -0,0 +1,50 @@
+package inventory
+
+import (
+	"context"
+	"strings"
+)
+
+var collectors = []collector{
+	{name: "packages", collect: collectPackages},
+	{name: "services", collect: collectServices},
+	{name: "ports", collect: collectPorts},
+}
+
+// collectPackages lists programs registered for uninstall, like "Apps & features".
+func collectPackages(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command",
+		`Get-ItemProperty HKLM:\Software\Microsoft\Windows\CurrentVersion\Uninstall\*, HKLM:\Software\Wow6432Node\Microsoft\Windows\CurrentVersion\Uninstall\* -ErrorAction SilentlyContinue | Where-Object DisplayName | ForEach-Object { $_.DisplayName + "`+"`t"+`" + $_.DisplayVersion }`)
+	if !ok || err != nil {
+		return err
+	}
+	inv.Packages = append(inv.Packages, parseTabPackages(out, "windows")...)
+	return nil
+}
+
+// collectServices lists services started automatically.
+func collectServices(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command",
+		`Get-Service | Where-Object StartType -eq Automatic | ForEach-Object { $_.Name + "`+"`t"+`" + $_.Status }`)
+	if !ok || err != nil {
+		return err
+	}
+	for _, line := range lines(out) {
+		name, state := line, ""
+		if i := strings.IndexByte(line, '\t'); i >= 0 {
+			name, state = line[:i], strings.ToLower(strings.TrimSpace(line[i+1:]))
+		}
+		inv.Services = append(inv.Services, Service{Name: name, State: state})
+	}
+	return nil
+}
+
+// collectPorts lists listening sockets by netstat.
+func collectPorts(ctx context.Context, inv *Inventory) error {
+	out, ok, err := output(ctx, "netstat", "-an")
+	if !ok || err != nil {
+		return err
+	}
+	inv.Ports = append(inv.Ports, parseNetstat(out)...)
+	return nil
+}
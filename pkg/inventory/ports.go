@@ -0,0 +1,91 @@
+package inventory
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Socket states of /proc/net files.
+const (
+	procTCPListen = "0A"
+	procUDPClose  = "07"
+)
+
+// parseProcNet parses listening sockets of protocol in /proc/net/tcp, tcp6, udp or udp6.
+func parseProcNet(data []byte, protocol string) []Port {
+	state := procTCPListen
+	if strings.HasPrefix(protocol, "udp") {
+		state = procUDPClose
+	}
+	var ports []Port
+	for i, line := range lines(data) {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 4 || fields[3] != state {
+			continue
+		}
+		addr, port, ok := parseProcAddr(fields[1])
+		if !ok {
+			continue
+		}
+		ports = append(ports, Port{Protocol: protocol, Address: addr, Port: port})
+	}
+	return ports
+}
+
+// parseProcAddr parses "<hex ip>:<hex port>" of /proc/net files, the IP is stored as 32-bit words in host byte order.
+func parseProcAddr(s string) (string, int, bool) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, false
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return "", 0, false
+	}
+	ip := make(net.IP, len(raw))
+	for w := 0; w < len(raw); w += 4 {
+		binary.BigEndian.PutUint32(ip[w:], binary.LittleEndian.Uint32(raw[w:]))
+	}
+	return ip.String(), int(port), true
+}
+
+// parseNetstat parses listening sockets in "netstat -an" output of macOS and Windows.
+func parseNetstat(out []byte) []Port {
+	var ports []Port
+	for _, line := range lines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		protocol := strings.ToLower(fields[0])
+		if !strings.HasPrefix(protocol, "tcp") && !strings.HasPrefix(protocol, "udp") {
+			continue
+		}
+		if strings.HasPrefix(protocol, "tcp") && !strings.HasPrefix(fields[len(fields)-1], "LISTEN") {
+			continue
+		}
+		// macOS has Recv-Q and Send-Q columns before local address
+		local := fields[1]
+		if _, err := strconv.Atoi(fields[1]); err == nil && len(fields) > 3 {
+			local = fields[3]
+		}
+		sep := strings.LastIndexAny(local, ":.")
+		if sep < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(local[sep+1:])
+		if err != nil {
+			continue
+		}
+		addr := strings.Trim(local[:sep], "[]")
+		ports = append(ports, Port{Protocol: protocol, Address: addr, Port: port})
+	}
+	return ports
+}
@@ -0,0 +1,117 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+const (
+	// throttleInitialRate is the number of requests per second allowed after the first throttled request.
+	throttleInitialRate = 32
+	// throttleMinRate is the lowest number of requests per second.
+	throttleMinRate = 1
+	// throttleMaxRate is the number of requests per second above which requests are not limited anymore.
+	throttleMaxRate = 1024
+)
+
+// AdaptiveRate limits the number of requests per second sent to a storage backend which throttles requests
+// under load (e.g. S3 "SlowDown" or HTTP 429). Requests are not limited until one is throttled, then the rate
+// is halved on every throttled request, and raised by a tenth every second without throttling until it is
+// unlimited again.
+type AdaptiveRate struct {
+	mu        sync.Mutex
+	rate      float64 // requests per second, 0 means unlimited
+	bucket    *ratelimit.Bucket
+	changedAt time.Time
+	throttled int64
+
+	// now is used for testing.
+	now func() time.Time
+}
+
+// NewAdaptiveRate creates an AdaptiveRate which does not limit requests until one is throttled.
+func NewAdaptiveRate() *AdaptiveRate {
+	return &AdaptiveRate{now: time.Now}
+}
+
+// Wait blocks until a request can be sent, or ctx is done.
+func (a *AdaptiveRate) Wait(ctx context.Context) error {
+	a.mu.Lock()
+	bucket := a.bucket
+	a.mu.Unlock()
+	if bucket == nil {
+		return nil
+	}
+	d := bucket.Take(1)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Throttled lowers the rate after the backend throttled a request.
+func (a *AdaptiveRate) Throttled() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.throttled++
+	rate := a.rate / 2
+	if a.rate == 0 {
+		rate = throttleInitialRate
+	}
+	if rate < throttleMinRate {
+		rate = throttleMinRate
+	}
+	a.setRate(rate)
+}
+
+// Succeeded raises the rate when no request was throttled in the last second.
+func (a *AdaptiveRate) Succeeded() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rate == 0 || a.now().Sub(a.changedAt) < time.Second {
+		return
+	}
+	rate := a.rate * 1.1
+	if rate >= throttleMaxRate {
+		rate = 0
+	}
+	a.setRate(rate)
+}
+
+// Rate returns the number of requests allowed per second, 0 means unlimited.
+func (a *AdaptiveRate) Rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
+
+// Count returns the number of throttled requests.
+func (a *AdaptiveRate) Count() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.throttled
+}
+
+func (a *AdaptiveRate) setRate(rate float64) {
+	a.rate = rate
+	a.changedAt = a.now()
+	if rate == 0 {
+		a.bucket = nil
+		return
+	}
+	capacity := int64(rate)
+	if capacity < 1 {
+		capacity = 1
+	}
+	a.bucket = ratelimit.NewBucketWithRate(rate, capacity)
+}
@@ -0,0 +1,59 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	a := NewAdaptiveRate()
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	// unlimited until throttled
+	require.NoError(t, a.Wait(context.Background()))
+	a.Succeeded()
+	assert.Equal(t, float64(0), a.Rate())
+
+	a.Throttled()
+	assert.Equal(t, float64(throttleInitialRate), a.Rate())
+	a.Throttled()
+	assert.Equal(t, float64(throttleInitialRate/2), a.Rate())
+	assert.Equal(t, int64(2), a.Count())
+
+	// raised only after a second without throttling
+	a.Succeeded()
+	assert.Equal(t, float64(throttleInitialRate/2), a.Rate())
+	now = now.Add(time.Second)
+	a.Succeeded()
+	assert.InDelta(t, throttleInitialRate/2*1.1, a.Rate(), 0.001)
+
+	for i := 0; i < 10; i++ {
+		a.Throttled()
+	}
+	assert.Equal(t, float64(throttleMinRate), a.Rate())
+
+	for i := 0; i < 100 && a.Rate() != 0; i++ {
+		now = now.Add(time.Second)
+		a.Succeeded()
+	}
+	assert.Equal(t, float64(0), a.Rate())
+}
+
+func TestAdaptiveRateWait(t *testing.T) {
+	a := NewAdaptiveRate()
+	for i := 0; i < 10; i++ {
+		a.Throttled()
+	}
+	require.Equal(t, float64(throttleMinRate), a.Rate())
+
+	// the bucket holds a single token at the lowest rate
+	require.NoError(t, a.Wait(context.Background()))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, a.Wait(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// ErrDailyBudgetExhausted is returned when the upload budget of the day is spent.
+var ErrDailyBudgetExhausted = errors.New("daily upload budget exhausted")
+
+// DailyBudget limits the number of bytes uploaded per day. The usage of current day is
+// persisted to state file so restarting agent does not reset the budget.
+type DailyBudget struct {
+	limit     int64
+	statePath string
+
+	mu    sync.Mutex
+	state budgetState
+
+	// now is used for testing.
+	now func() time.Time
+}
+
+type budgetState struct {
+	Day  string `json:"day"`
+	Used int64  `json:"used"`
+}
+
+// NewDailyBudget creates a DailyBudget allows limit bytes per day. If statePath is not empty,
+// usage of the day is loaded from and saved to that file.
+func NewDailyBudget(limit int64, statePath string) (*DailyBudget, error) {
+	b := &DailyBudget{
+		limit:     limit,
+		statePath: statePath,
+		now:       time.Now,
+	}
+	if statePath == "" {
+		return b, nil
+	}
+	buf, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &b.state); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Limit returns number of bytes allowed per day.
+func (b *DailyBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns number of bytes used in current day.
+func (b *DailyBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	return b.state.Used
+}
+
+// Spend spends n bytes from the budget of the day, or returns ErrDailyBudgetExhausted when they do not fit in it.
+// A request bigger than the whole budget is allowed at the beginning of a day, so the budget always makes progress.
+func (b *DailyBudget) Spend(n int64) error {
+	if b == nil || b.limit <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover()
+	if b.state.Used != 0 && b.state.Used+n > b.limit {
+		return ErrDailyBudgetExhausted
+	}
+	b.state.Used += n
+	return b.save()
+}
+
+// RenewsAt returns the time the budget of the next day starts.
+func (b *DailyBudget) RenewsAt() time.Time {
+	return b.nextDay()
+}
+
+// rollover resets the usage when the day changed, must be called with mu held.
+func (b *DailyBudget) rollover() {
+	today := b.now().Format(dayLayout)
+	if b.state.Day != today {
+		b.state = budgetState{Day: today}
+	}
+}
+
+func (b *DailyBudget) nextDay() time.Time {
+	now := b.now()
+	year, month, day := now.Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, now.Location())
+}
+
+// save writes the state of budget to state file, must be called with mu held.
+func (b *DailyBudget) save() error {
+	if b.statePath == "" {
+		return nil
+	}
+	buf, err := json.Marshal(b.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.statePath), 0700); err != nil {
+		return err
+	}
+	tmp := b.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.statePath)
+}
@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDailyBudgetUnlimited(t *testing.T) {
+	b, err := NewDailyBudget(0, "")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, b.Spend(1<<30))
+	}
+}
+
+func TestDailyBudgetSpend(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "budget.json")
+	b, err := NewDailyBudget(100, statePath)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Spend(60))
+	require.NoError(t, b.Spend(40))
+	assert.Equal(t, int64(100), b.Used())
+
+	// budget exhausted, Spend fails at once and spends nothing
+	assert.ErrorIs(t, b.Spend(1), ErrDailyBudgetExhausted)
+	assert.Equal(t, int64(100), b.Used())
+	assert.True(t, b.RenewsAt().After(time.Now()))
+
+	// usage is restored from state file
+	restored, err := NewDailyBudget(100, statePath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), restored.Used())
+}
+
+func TestDailyBudgetRollover(t *testing.T) {
+	b, err := NewDailyBudget(100, "")
+	require.NoError(t, err)
+	now := time.Date(2022, 6, 1, 23, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	require.NoError(t, b.Spend(100))
+	assert.ErrorIs(t, b.Spend(1), ErrDailyBudgetExhausted)
+	assert.Equal(t, time.Date(2022, 6, 2, 0, 0, 0, 0, time.UTC), b.RenewsAt())
+
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, int64(0), b.Used())
+	require.NoError(t, b.Spend(100))
+}
+
+func TestDailyBudgetOversizeRequest(t *testing.T) {
+	b, err := NewDailyBudget(10, "")
+	require.NoError(t, err)
+	require.NoError(t, b.Spend(50))
+	assert.Equal(t, int64(50), b.Used())
+}
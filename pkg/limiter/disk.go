@@ -0,0 +1,111 @@
+package limiter
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+// DiskLimiter paces reads of local files, so chunking files does not saturate the disk of a busy machine whatever
+// the network limits are. It limits read bytes per second and read operations per second, its limits can be changed
+// while readers are in use. A nil DiskLimiter does not limit.
+type DiskLimiter struct {
+	mu     sync.Mutex
+	readKb int
+	iops   int
+	bytes  *ratelimit.Bucket
+	ops    *ratelimit.Bucket
+}
+
+// NewDiskLimiter creates a DiskLimiter reading at most readKb KiB and iops read operations per second, 0 is
+// unlimited.
+func NewDiskLimiter(readKb, iops int) *DiskLimiter {
+	l := &DiskLimiter{}
+	l.SetLimits(readKb, iops)
+	return l
+}
+
+// SetLimits changes the limits of l, readers already created are paced by the new limits.
+func (l *DiskLimiter) SetLimits(readKb, iops int) {
+	var bytes, ops *ratelimit.Bucket
+	if readKb > 0 {
+		bytes = ratelimit.NewBucketWithRate(toByteRate(readKb), int64(toByteRate(readKb)))
+	}
+	if iops > 0 {
+		ops = ratelimit.NewBucketWithRate(float64(iops), int64(iops))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.readKb, l.iops = readKb, iops
+	l.bytes, l.ops = bytes, ops
+}
+
+// Limits returns the read KiB and read operations per second of l, 0 is unlimited.
+func (l *DiskLimiter) Limits() (readKb, iops int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.readKb, l.iops
+}
+
+func (l *DiskLimiter) buckets() (bytes, ops *ratelimit.Bucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bytes, l.ops
+}
+
+// Reader returns r paced by l, waiting for l stops when ctx is done.
+func (l *DiskLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &diskReader{ctx: ctx, r: r, l: l}
+}
+
+type diskReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *DiskLimiter
+}
+
+func (r *diskReader) Read(p []byte) (int, error) {
+	bytes, ops := r.l.buckets()
+	if err := wait(r.ctx, ops, 1); err != nil {
+		return 0, err
+	}
+	if bytes != nil && int64(len(p)) > bytes.Capacity() {
+		// a read larger than the bucket would never be allowed
+		p = p[:bytes.Capacity()]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := wait(r.ctx, bytes, int64(n)); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// wait takes count tokens from bucket b, waiting until they are available or ctx is done.
+func wait(ctx context.Context, b *ratelimit.Bucket, count int64) error {
+	if b == nil {
+		return nil
+	}
+	d := b.Take(count)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package limiter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskLimiter(t *testing.T) {
+	data := make([]byte, 96*1024)
+	var nilLimiter *DiskLimiter
+	r := bytes.NewReader(data)
+	assert.Equal(t, io.Reader(r), nilLimiter.Reader(context.Background(), r))
+
+	l := NewDiskLimiter(64, 0)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, l.Reader(context.Background(), bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	// the first 64KiB are the burst of the bucket, the rest waits
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(400*time.Millisecond))
+
+	l.SetLimits(0, 10)
+	readKb, iops := l.Limits()
+	assert.Equal(t, 0, readKb)
+	assert.Equal(t, 10, iops)
+	start = time.Now()
+	buf := make([]byte, 1)
+	rd := l.Reader(context.Background(), bytes.NewReader(data))
+	for i := 0; i < 15; i++ {
+		_, err := rd.Read(buf)
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(400*time.Millisecond))
+}
+
+func TestDiskLimiterCancel(t *testing.T) {
+	l := NewDiskLimiter(1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	rd := l.Reader(ctx, bytes.NewReader(make([]byte, 64*1024)))
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := io.Copy(ioutil.Discard, rd)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
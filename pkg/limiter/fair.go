@@ -0,0 +1,103 @@
+package limiter
+
+import "sync"
+
+// Pool runs tasks on a bounded number of goroutines, Submit blocks until a goroutine takes the task. ants.Pool is a
+// Pool.
+type Pool interface {
+	Submit(task func()) error
+}
+
+// FairPool shares a Pool between actions running at once, e.g. backups of several directories scheduled at the same
+// minute. Each action submits to its own FairQueue, and the tasks waiting in queues are handed to the pool in turn: a
+// queue of weight w gets w tasks per turn, so an action with many files does not starve the others.
+type FairPool struct {
+	pool Pool
+
+	mu   sync.Mutex
+	ring []*FairQueue
+	wake chan struct{}
+}
+
+// FairQueue is the queue of tasks of an action in a FairPool.
+type FairQueue struct {
+	fair   *FairPool
+	weight int
+
+	// pending and turn are guarded by fair.mu, turn is the number of tasks handed to the pool in the current turn.
+	pending []fairTask
+	turn    int
+}
+
+type fairTask struct {
+	task      func()
+	submitted chan error
+}
+
+// NewFairPool creates a FairPool handing tasks to pool.
+func NewFairPool(pool Pool) *FairPool {
+	f := &FairPool{pool: pool, wake: make(chan struct{}, 1)}
+	go f.run()
+	return f
+}
+
+// Queue returns a new queue of tasks of weight, which is at least 1.
+func (f *FairPool) Queue(weight int) *FairQueue {
+	if weight < 1 {
+		weight = 1
+	}
+	return &FairQueue{fair: f, weight: weight}
+}
+
+// Submit waits for the turn of q, then submits task to the pool.
+func (q *FairQueue) Submit(task func()) error {
+	f := q.fair
+	t := fairTask{task: task, submitted: make(chan error, 1)}
+	f.mu.Lock()
+	if len(q.pending) == 0 {
+		f.ring = append(f.ring, q)
+	}
+	q.pending = append(q.pending, t)
+	f.mu.Unlock()
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return <-t.submitted
+}
+
+// run hands the waiting tasks to the pool in turn.
+func (f *FairPool) run() {
+	for {
+		t, ok := f.next()
+		if !ok {
+			<-f.wake
+			continue
+		}
+		t.submitted <- f.pool.Submit(t.task)
+	}
+}
+
+// next takes the next task of the queue at the head of ring. The queue goes to the back of ring once it had its
+// weight of tasks, or leaves it once it is empty.
+func (f *FairPool) next() (fairTask, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.ring) == 0 {
+		return fairTask{}, false
+	}
+	q := f.ring[0]
+	t := q.pending[0]
+	q.pending[0] = fairTask{}
+	q.pending = q.pending[1:]
+	q.turn++
+	switch {
+	case len(q.pending) == 0:
+		q.turn = 0
+		f.ring = f.ring[1:]
+	case q.turn >= q.weight:
+		q.turn = 0
+		f.ring = append(f.ring[1:], q)
+	}
+	return t, true
+}
@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatePool runs tasks one at a time once gate is closed.
+type gatePool struct {
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (p *gatePool) Submit(task func()) error {
+	select {
+	case p.entered <- struct{}{}:
+	default:
+	}
+	<-p.gate
+	task()
+	return nil
+}
+
+func waitPending(t *testing.T, f *FairPool, q *FairQueue, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(q.pending) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestFairPool(t *testing.T) {
+	pool := &gatePool{entered: make(chan struct{}, 1), gate: make(chan struct{})}
+	f := NewFairPool(pool)
+	a, b := f.Queue(2), f.Queue(0)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	submit := func(q *FairQueue, name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, q.Submit(func() {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+			}))
+		}()
+	}
+
+	// the pool is busy with a task of a while the others wait in queues
+	submit(a, "a")
+	<-pool.entered
+	for i := 0; i < 3; i++ {
+		submit(a, "a")
+	}
+	waitPending(t, f, a, 3)
+	for i := 0; i < 2; i++ {
+		submit(b, "b")
+	}
+	waitPending(t, f, b, 2)
+
+	close(pool.gate)
+	wg.Wait()
+	assert.Equal(t, []string{"a", "a", "a", "b", "a", "b"}, order)
+}
@@ -0,0 +1,48 @@
+package limiter
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// MemoryLimiter bounds the bytes of chunk data held in memory at once by backups, so chunking many large files
+// concurrently does not exhaust the memory of a small machine. A chunk waits until its bytes fit in the budget, it
+// holds them until it is uploaded. A nil MemoryLimiter does not limit.
+type MemoryLimiter struct {
+	limit int64
+	sem   *semaphore.Weighted
+}
+
+// NewMemoryLimiter creates a MemoryLimiter of mb MiB, or nil when mb is not positive.
+func NewMemoryLimiter(mb int) *MemoryLimiter {
+	if mb <= 0 {
+		return nil
+	}
+	limit := int64(mb) << 20
+	return &MemoryLimiter{limit: limit, sem: semaphore.NewWeighted(limit)}
+}
+
+// weight returns the bytes of budget held by n bytes, data larger than the budget takes all of it.
+func (l *MemoryLimiter) weight(n int64) int64 {
+	if n > l.limit {
+		return l.limit
+	}
+	return n
+}
+
+// Acquire waits until n bytes fit in the budget of l, or until ctx is done.
+func (l *MemoryLimiter) Acquire(ctx context.Context, n int64) error {
+	if l == nil {
+		return nil
+	}
+	return l.sem.Acquire(ctx, l.weight(n))
+}
+
+// Release gives back n bytes acquired by Acquire.
+func (l *MemoryLimiter) Release(n int64) {
+	if l == nil {
+		return
+	}
+	l.sem.Release(l.weight(n))
+}
@@ -0,0 +1,39 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	var nilLimiter *MemoryLimiter
+	require.NoError(t, nilLimiter.Acquire(context.Background(), 1<<30))
+	nilLimiter.Release(1 << 30)
+	assert.Nil(t, NewMemoryLimiter(0))
+
+	l := NewMemoryLimiter(1)
+	require.NoError(t, l.Acquire(context.Background(), 768<<10))
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.Acquire(context.Background(), 512<<10) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.Release(768 << 10)
+	require.NoError(t, <-acquired)
+	l.Release(512 << 10)
+
+	// data larger than the budget takes all of it
+	require.NoError(t, l.Acquire(context.Background(), 4<<20))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, l.Acquire(ctx, 1))
+	l.Release(4 << 20)
+	require.NoError(t, l.Acquire(context.Background(), 1<<20))
+}
@@ -0,0 +1,244 @@
+// Package manifest exports the index of a recovery point into manifest formats understood by third-party tools,
+// so customers can audit what is protected without the agent.
+//
+// Supported formats:
+//
+//   - restic: JSON lines like `restic ls --json`, a "snapshot" line followed by one "node" line per item.
+//   - borg: JSON lines like `borg list --json-lines`, one line per item.
+//   - sha256sum: "<sha256>  <path>" lines of regular files, checkable by `sha256sum -c`.
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// Manifest formats.
+const (
+	FormatRestic    = "restic"
+	FormatBorg      = "borg"
+	FormatSha256Sum = "sha256sum"
+)
+
+// Formats lists the supported manifest formats.
+var Formats = []string{FormatRestic, FormatBorg, FormatSha256Sum}
+
+// borgTimeLayout is the timestamp layout of borg json output.
+const borgTimeLayout = "2006-01-02T15:04:05.000000"
+
+// IsSupported reports whether format is a supported manifest format.
+func IsSupported(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentType returns the MIME type of manifest in format.
+func ContentType(format string) string {
+	if format == FormatSha256Sum {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Snapshot describes the recovery point a manifest is exported from.
+type Snapshot struct {
+	RecoveryPointID string
+	Hostname        string
+	Time            time.Time
+}
+
+// resticSnapshot is the first line of `restic ls --json`.
+type resticSnapshot struct {
+	Time       time.Time `json:"time"`
+	Paths      []string  `json:"paths"`
+	Hostname   string    `json:"hostname,omitempty"`
+	ID         string    `json:"id"`
+	ShortID    string    `json:"short_id"`
+	StructType string    `json:"struct_type"`
+}
+
+// resticNode is a node line of `restic ls --json`.
+type resticNode struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Path        string    `json:"path"`
+	UID         uint32    `json:"uid"`
+	GID         uint32    `json:"gid"`
+	Size        uint64    `json:"size,omitempty"`
+	Mode        uint32    `json:"mode,omitempty"`
+	Permissions string    `json:"permissions,omitempty"`
+	ModTime     time.Time `json:"mtime,omitempty"`
+	AccessTime  time.Time `json:"atime,omitempty"`
+	ChangeTime  time.Time `json:"ctime,omitempty"`
+	StructType  string    `json:"struct_type"`
+	// Sha256 is not part of restic output, it is added so content can be audited.
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// borgItem is a line of `borg list --json-lines`.
+type borgItem struct {
+	Type       string `json:"type"`
+	Mode       string `json:"mode"`
+	User       string `json:"user"`
+	Group      string `json:"group"`
+	UID        uint32 `json:"uid"`
+	GID        uint32 `json:"gid"`
+	Path       string `json:"path"`
+	Healthy    bool   `json:"healthy"`
+	Source     string `json:"source"`
+	LinkTarget string `json:"linktarget"`
+	Flags      *int   `json:"flags"`
+	MTime      string `json:"mtime"`
+	Size       uint64 `json:"size"`
+	// Sha256 is not part of borg default output, it is added so content can be audited.
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// Export writes manifest of index in format to w, items are sorted by path.
+func Export(w io.Writer, index *cache.Index, snapshot Snapshot, format string) error {
+	if !IsSupported(format) {
+		return fmt.Errorf("unsupported manifest format %q, supported formats: %s", format, strings.Join(Formats, ", "))
+	}
+
+	nodes := make([]*cache.Node, 0, len(index.Items))
+	for _, node := range index.Items {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].AbsolutePath < nodes[j].AbsolutePath })
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+
+	var err error
+	switch format {
+	case FormatRestic:
+		err = exportRestic(enc, nodes, snapshot)
+	case FormatBorg:
+		err = exportBorg(enc, nodes)
+	case FormatSha256Sum:
+		err = exportSha256Sum(bw, nodes)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func exportRestic(enc *json.Encoder, nodes []*cache.Node, snapshot Snapshot) error {
+	shortID := snapshot.RecoveryPointID
+	if len(shortID) > 8 {
+		shortID = shortID[:8]
+	}
+	if err := enc.Encode(resticSnapshot{
+		Time:       snapshot.Time,
+		Paths:      basePaths(nodes),
+		Hostname:   snapshot.Hostname,
+		ID:         snapshot.RecoveryPointID,
+		ShortID:    shortID,
+		StructType: "snapshot",
+	}); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		rn := resticNode{
+			Name:        node.Name,
+			Type:        node.Type,
+			Path:        filepath.ToSlash(node.AbsolutePath),
+			UID:         node.UID,
+			GID:         node.GID,
+			Mode:        uint32(node.Mode),
+			Permissions: node.Mode.String(),
+			ModTime:     node.ModTime,
+			AccessTime:  node.AccessTime,
+			ChangeTime:  node.ChangeTime,
+			StructType:  "node",
+		}
+		if node.Type == "file" {
+			rn.Size = node.Size
+			rn.Sha256 = node.Sha256Hash.String()
+		}
+		if err := enc.Encode(rn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportBorg(enc *json.Encoder, nodes []*cache.Node) error {
+	for _, node := range nodes {
+		typ := borgType(node)
+		item := borgItem{
+			Type:       typ,
+			Mode:       typ + node.Mode.Perm().String()[1:],
+			User:       node.User,
+			Group:      node.Group,
+			UID:        node.UID,
+			GID:        node.GID,
+			Path:       strings.TrimPrefix(filepath.ToSlash(node.AbsolutePath), "/"),
+			Healthy:    true,
+			LinkTarget: node.LinkTarget,
+			MTime:      node.ModTime.Format(borgTimeLayout),
+		}
+		if node.Type == "file" {
+			item.Size = node.Size
+			item.Sha256 = node.Sha256Hash.String()
+		}
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportSha256Sum(w io.Writer, nodes []*cache.Node) error {
+	for _, node := range nodes {
+		if node.Type != "file" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s  %s\n", node.Sha256Hash.String(), node.AbsolutePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// borgType returns the file type character borg uses in mode strings.
+func borgType(node *cache.Node) string {
+	switch {
+	case node.Type == "dir" || node.Mode&os.ModeDir != 0:
+		return "d"
+	case node.Type == "symlink" || node.Mode&os.ModeSymlink != 0:
+		return "l"
+	default:
+		return "-"
+	}
+}
+
+// basePaths returns the distinct backup directories of nodes.
+func basePaths(nodes []*cache.Node) []string {
+	seen := make(map[string]bool)
+	paths := []string{}
+	for _, node := range nodes {
+		if node.BasePath == "" || seen[node.BasePath] {
+			continue
+		}
+		seen[node.BasePath] = true
+		paths = append(paths, node.BasePath)
+	}
+	sort.Strings(paths)
+	return paths
+}
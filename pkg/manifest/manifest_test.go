@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func testIndex() *cache.Index {
+	mtime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	return &cache.Index{
+		RecoveryPointID: "0123456789abcdef",
+		Items: map[string]*cache.Node{
+			"/data/b.txt": {Name: "b.txt", Type: "file", AbsolutePath: "/data/b.txt", BasePath: "/data", Size: 3, Mode: 0644,
+				ModTime: mtime, Sha256Hash: cache.Sha256Hash{0xab, 0xcd}},
+			"/data": {Name: "data", Type: "dir", AbsolutePath: "/data", BasePath: "/data", Mode: 0755 | 1<<31, ModTime: mtime},
+			"/data/link": {Name: "link", Type: "symlink", AbsolutePath: "/data/link", BasePath: "/data", LinkTarget: "b.txt",
+				Mode: 0777 | 1<<27, ModTime: mtime},
+		},
+	}
+}
+
+func TestExport_Restic(t *testing.T) {
+	var buf bytes.Buffer
+	snapshot := Snapshot{RecoveryPointID: "0123456789abcdef", Hostname: "host", Time: time.Unix(0, 0).UTC()}
+	require.NoError(t, Export(&buf, testIndex(), snapshot, FormatRestic))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+
+	var snap resticSnapshot
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &snap))
+	assert.Equal(t, "snapshot", snap.StructType)
+	assert.Equal(t, "01234567", snap.ShortID)
+	assert.Equal(t, []string{"/data"}, snap.Paths)
+
+	var node resticNode
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &node))
+	assert.Equal(t, "/data/b.txt", node.Path)
+	assert.Equal(t, "node", node.StructType)
+	assert.Equal(t, "abcd", node.Sha256)
+	assert.Equal(t, uint64(3), node.Size)
+}
+
+func TestExport_Borg(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, testIndex(), Snapshot{}, FormatBorg))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	var items []borgItem
+	for _, line := range lines {
+		var item borgItem
+		require.NoError(t, json.Unmarshal([]byte(line), &item))
+		items = append(items, item)
+	}
+	assert.Equal(t, "data", items[0].Path)
+	assert.Equal(t, "drwxr-xr-x", items[0].Mode)
+	assert.Equal(t, "-rw-r--r--", items[1].Mode)
+	assert.Equal(t, "2021-06-01T10:00:00.000000", items[1].MTime)
+	assert.Equal(t, "l", items[2].Type)
+	assert.Equal(t, "b.txt", items[2].LinkTarget)
+}
+
+func TestExport_Sha256Sum(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Export(&buf, testIndex(), Snapshot{}, FormatSha256Sum))
+	assert.Equal(t, "abcd  /data/b.txt\n", buf.String())
+}
+
+func TestExport_Unsupported(t *testing.T) {
+	assert.Error(t, Export(&bytes.Buffer{}, testIndex(), Snapshot{}, "tar"))
+	assert.False(t, IsSupported("tar"))
+}
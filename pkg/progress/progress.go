@@ -25,6 +25,9 @@ type Progress struct {
 	lastUpdate   time.Time
 
 	running bool
+
+	// parent also receives the statistics reported to p.
+	parent *Progress
 }
 
 // Stat
@@ -106,7 +109,13 @@ func (p *Progress) reporter() {
 // Report adds the statistics from s to the current state and tries to report
 // the accumulated statistics via the feedback channel.
 func (p *Progress) Report(s Stat) {
-	if p == nil || !p.running {
+	if p == nil {
+		return
+	}
+	if p.parent != nil {
+		p.parent.Report(s)
+	}
+	if !p.running {
 		return
 	}
 
@@ -126,6 +135,14 @@ func (p *Progress) Report(s Stat) {
 	}
 }
 
+// SetParent makes p report its statistics to parent too, it is used for progress of a subset of items.
+func (p *Progress) SetParent(parent *Progress) {
+	if p == nil {
+		return
+	}
+	p.parent = parent
+}
+
 func (p *Progress) Done() {
 	if p == nil || !p.running {
 		return
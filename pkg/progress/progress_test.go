@@ -1,6 +1,9 @@
 package progress
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestStat_String(t *testing.T) {
 	type fields struct {
@@ -42,3 +45,24 @@ func TestStat_String(t *testing.T) {
 		})
 	}
 }
+
+func TestProgress_SetParent(t *testing.T) {
+	parent := NewProgress(time.Hour)
+	parent.Start()
+	defer parent.Done()
+	child := NewProgress(time.Hour)
+	child.SetParent(parent)
+	child.Start()
+
+	child.Report(Stat{Items: 1, Bytes: 10})
+	child.Done()
+	// parent still gets statistics after child is done
+	child.Report(Stat{Items: 1, Bytes: 5})
+
+	if parent.currentStat.Items != 2 || parent.currentStat.Bytes != 15 {
+		t.Errorf("parent stat = %v, want 2 items and 15 bytes", parent.currentStat)
+	}
+	if child.currentStat.Items != 1 {
+		t.Errorf("child stat = %v, want 1 item", child.currentStat)
+	}
+}
@@ -0,0 +1,59 @@
+// Package proxy selects the HTTP proxy of connections to backup server and storage vaults.
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Func is the proxy function of an http.Transport.
+type Func func(*http.Request) (*url.URL, error)
+
+// Config returns the proxy config of agent: http_proxy, https_proxy and no_proxy config keys, which take precedence
+// over HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func Config() *httpproxy.Config {
+	cfg := httpproxy.FromEnvironment()
+	if v := viper.GetString("http_proxy"); v != "" {
+		cfg.HTTPProxy = v
+	}
+	if v := viper.GetString("https_proxy"); v != "" {
+		cfg.HTTPSProxy = v
+	}
+	if v := viper.GetString("no_proxy"); v != "" {
+		cfg.NoProxy = v
+	}
+	return cfg
+}
+
+// Default returns the proxy function of connections to backup server.
+func Default() Func {
+	f := Config().ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return f(r.URL)
+	}
+}
+
+// ForStorageVault returns the proxy function of connections to storage vault vaultID. A proxy given to the vault in
+// storage_vault_proxy config (storage vault ID to proxy URL) is used for all its requests, otherwise it is Default.
+func ForStorageVault(vaultID string) (Func, error) {
+	// viper lowercases keys of maps
+	p := viper.GetStringMapString("storage_vault_proxy")[strings.ToLower(vaultID)]
+	if p == "" {
+		return Default(), nil
+	}
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy of storage vault %s: %w", vaultID, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy of storage vault %s: scheme %q not supported", vaultID, u.Scheme)
+	}
+	return http.ProxyURL(u), nil
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefault(t *testing.T) {
+	setenv(t, "HTTPS_PROXY", "http://env-proxy:3128")
+	setenv(t, "NO_PROXY", "")
+	defer viper.Reset()
+
+	req, _ := http.NewRequest(http.MethodGet, "https://backup.bizflycloud.vn/v1", nil)
+	u, err := Default()(req)
+	require.NoError(t, err)
+	require.NotNil(t, u)
+	assert.Equal(t, "env-proxy:3128", u.Host)
+
+	viper.Set("https_proxy", "http://config-proxy:3128")
+	u, err = Default()(req)
+	require.NoError(t, err)
+	require.NotNil(t, u)
+	assert.Equal(t, "config-proxy:3128", u.Host)
+
+	viper.Set("no_proxy", "bizflycloud.vn")
+	u, err = Default()(req)
+	require.NoError(t, err)
+	assert.Nil(t, u)
+}
+
+func TestForStorageVault(t *testing.T) {
+	setenv(t, "HTTPS_PROXY", "")
+	defer viper.Reset()
+	viper.Set("storage_vault_proxy", map[string]string{
+		"vault-a": "http://vault-proxy:8080",
+		"vault-b": "ftp://vault-proxy",
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "https://s3.example.com/bucket/key", nil)
+	f, err := ForStorageVault("Vault-A")
+	require.NoError(t, err)
+	u, err := f(req)
+	require.NoError(t, err)
+	require.NotNil(t, u)
+	assert.Equal(t, "vault-proxy:8080", u.Host)
+
+	_, err = ForStorageVault("vault-b")
+	assert.Error(t, err)
+
+	f, err = ForStorageVault("vault-c")
+	require.NoError(t, err)
+	u, err = f(req)
+	require.NoError(t, err)
+	assert.Nil(t, u)
+}
+
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
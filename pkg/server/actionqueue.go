@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+// QueuedBackup is a backup waiting for a running backup to finish, as max_concurrent_backups are running.
+type QueuedBackup struct {
+	ActionID          string    `json:"action_id"`
+	BackupDirectoryID string    `json:"backup_directory_id"`
+	PolicyID          string    `json:"policy_id"`
+	RecoveryPointID   string    `json:"recovery_point_id"`
+	QueuedAt          time.Time `json:"queued_at"`
+	// Position is the place of backup in the queue, the first one starts next.
+	Position int `json:"position"`
+}
+
+// ListActionResponse is the running actions of machine, as listed by backup server, and the backups queued by agent.
+type ListActionResponse struct {
+	backupapi.ListActivity
+	Queued []QueuedBackup `json:"queued,omitempty"`
+}
+
+// actionQueue bounds the backups running at once by max_concurrent_backups, the others wait in FIFO order. A limit of
+// 0 does not bound them. The limit is read as backups start and finish, so a lowered limit lets running backups finish.
+type actionQueue struct {
+	mu      sync.Mutex
+	running int
+	waiting []*queuedAction
+}
+
+type queuedAction struct {
+	backup QueuedBackup
+	ready  chan struct{}
+}
+
+// acquire waits for the turn of backup, it returns the error of ctx when ctx is done before. release must be called
+// once the backup is done.
+func (q *actionQueue) acquire(ctx context.Context, backup QueuedBackup) error {
+	q.mu.Lock()
+	if len(q.waiting) == 0 && q.free() {
+		q.running++
+		q.mu.Unlock()
+		return nil
+	}
+	backup.QueuedAt = time.Now()
+	a := &queuedAction{backup: backup, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, a)
+	q.mu.Unlock()
+
+	select {
+	case <-a.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	for i, w := range q.waiting {
+		if w == a {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+	// the turn came as ctx was done
+	q.release()
+	return ctx.Err()
+}
+
+// release ends a backup started by acquire, and starts the next ones waiting.
+func (q *actionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	for len(q.waiting) > 0 && q.free() {
+		a := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		close(a.ready)
+	}
+}
+
+// free reports whether another backup can run, q.mu must be held.
+func (q *actionQueue) free() bool {
+	limit := viper.GetInt("max_concurrent_backups")
+	return limit <= 0 || q.running < limit
+}
+
+// queued returns the backups waiting, in the order they start.
+func (q *actionQueue) queued() []QueuedBackup {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	backups := make([]QueuedBackup, 0, len(q.waiting))
+	for i, a := range q.waiting {
+		backup := a.backup
+		backup.Position = i + 1
+		backups = append(backups, backup)
+	}
+	return backups
+}
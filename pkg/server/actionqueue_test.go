@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionQueue(t *testing.T) {
+	viper.Set("max_concurrent_backups", 1)
+	defer viper.Set("max_concurrent_backups", 0)
+
+	var q actionQueue
+	require.NoError(t, q.acquire(context.Background(), QueuedBackup{ActionID: "a1"}))
+
+	started := make(chan string, 2)
+	for _, id := range []string{"a2", "a3"} {
+		id := id
+		go func() {
+			if err := q.acquire(context.Background(), QueuedBackup{ActionID: id}); err == nil {
+				started <- id
+			}
+		}()
+		require.Eventually(t, func() bool { return len(q.queued()) > 0 && q.queued()[len(q.queued())-1].ActionID == id }, time.Second, time.Millisecond)
+	}
+
+	queued := q.queued()
+	require.Len(t, queued, 2)
+	assert.Equal(t, "a2", queued[0].ActionID)
+	assert.Equal(t, 1, queued[0].Position)
+	assert.Equal(t, 2, queued[1].Position)
+	assert.False(t, queued[0].QueuedAt.IsZero())
+
+	// a stopped backup leaves the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() { errCh <- q.acquire(ctx, QueuedBackup{ActionID: "a4"}) }()
+	require.Eventually(t, func() bool { return len(q.queued()) == 3 }, time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+	assert.Len(t, q.queued(), 2)
+
+	// backups start in the order they were queued
+	q.release()
+	assert.Equal(t, "a2", <-started)
+	assert.Len(t, q.queued(), 1)
+	q.release()
+	assert.Equal(t, "a3", <-started)
+	assert.Empty(t, q.queued())
+	q.release()
+
+	viper.Set("max_concurrent_backups", 0)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.acquire(context.Background(), QueuedBackup{}))
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// stopOnBudgetExhausted returns a callback which stops the backup of actionID when the daily upload budget is
+// exhausted: its checkpoint is kept with the stop reason and the time the budget renews, and cancel stops its
+// workers. The backup is failed with DAILY_BUDGET_EXHAUSTED and resumed once the budget is renewed, see
+// scheduleBudgetResume.
+func (s *Server) stopOnBudgetExhausted(actionID string, checkpoint *cache.Checkpoint, cancel context.CancelFunc) func(used int64, resumeAt time.Time) {
+	notify := s.notifyBudgetExhausted(actionID)
+	return func(used int64, resumeAt time.Time) {
+		notify(used, resumeAt)
+		checkpoint.StopUntil(limiter.ErrDailyBudgetExhausted.Error(), resumeAt)
+		cancel()
+	}
+}
+
+// backupRequest is a backup run again to resume its recovery point, saved with the checkpoint of a backup stopped by
+// the daily upload budget.
+type backupRequest struct {
+	BackupDirectoryID string   `json:"backup_directory_id"`
+	PolicyID          string   `json:"policy_id,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	LimitUpload       int      `json:"limit_upload,omitempty"`
+	LimitDownload     int      `json:"limit_download,omitempty"`
+	RecoveryPointType string   `json:"recovery_point_type"`
+	ChangeDetection   string   `json:"change_detection,omitempty"`
+	OnlyPaths         []string `json:"only_paths,omitempty"`
+}
+
+// run runs the backup of req.
+func (req backupRequest) run(s *Server) error {
+	return s.backup(req.BackupDirectoryID, req.PolicyID, req.Name, req.LimitUpload, req.LimitDownload, req.RecoveryPointType, req.ChangeDetection, req.OnlyPaths, ioutil.Discard)
+}
+
+// scheduleBudgetResume runs backup at resumeAt, when the upload budget is renewed, to resume the recovery point of
+// backup key stopped by the budget. A resume already scheduled for key is replaced. The resume is saved with the
+// checkpoint of key, so it is scheduled again after a restart, see scheduleSavedBudgetResumes.
+func (s *Server) scheduleBudgetResume(key string, resumeAt time.Time, backup func() error) {
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+	if s.budgetResumes == nil {
+		s.budgetResumes = make(map[string]*time.Timer)
+	}
+	if timer := s.budgetResumes[key]; timer != nil {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(resumeAt), func() {
+		s.budgetMu.Lock()
+		if s.budgetResumes[key] == timer {
+			delete(s.budgetResumes, key)
+		}
+		s.budgetMu.Unlock()
+		s.logger.Info("Resume backup stopped by daily upload budget")
+		if err := backup(); err != nil {
+			s.logger.Error("Resume backup stopped by daily upload budget error", zap.Error(err))
+		}
+	})
+	s.budgetResumes[key] = timer
+}
+
+// scheduleSavedBudgetResumes schedules the resumes saved with the checkpoints of backups stopped by the daily upload
+// budget before the agent restarted. A resume whose time passed meanwhile runs at once.
+func (s *Server) scheduleSavedBudgetResumes() {
+	if s.backupClient == nil {
+		return
+	}
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return
+	}
+	checkpoints, err := cache.Checkpoints(cachePath, s.backupClient.Id)
+	if err != nil {
+		s.logger.Warn("failed to read checkpoints of backups stopped by daily upload budget", zap.Error(err))
+		return
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Stopped != limiter.ErrDailyBudgetExhausted.Error() || checkpoint.ResumeAt.IsZero() {
+			continue
+		}
+		var req backupRequest
+		if err := json.Unmarshal(checkpoint.Resume, &req); err != nil || req.BackupDirectoryID == "" {
+			s.logger.Warn("Invalid resume of backup stopped by daily upload budget", zap.String("recovery_point_id", checkpoint.RecoveryPointID))
+			continue
+		}
+		s.logger.Info("Resume backup stopped by daily upload budget before restart", zap.String("recovery_point_id", checkpoint.RecoveryPointID), zap.Time("resume_at", checkpoint.ResumeAt))
+		s.scheduleBudgetResume(checkpoint.Key, checkpoint.ResumeAt, func() error { return req.run(s) })
+	}
+}
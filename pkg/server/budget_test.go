@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+func TestStopOnBudgetExhausted(t *testing.T) {
+	ob := &offlineBroker{}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+	checkpoint := cache.NewCheckpoint("key", "action1", "rp1", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resumeAt := time.Now().Add(time.Hour)
+	stop := s.stopOnBudgetExhausted("action1", checkpoint, cancel)
+	stop(100, resumeAt)
+	stop(100, resumeAt)
+	assert.Equal(t, limiter.ErrDailyBudgetExhausted.Error(), checkpoint.StopReason(), "checkpoint is kept to resume")
+	assert.Equal(t, resumeAt, checkpoint.ResumeTime(), "resume time is saved with checkpoint")
+	assert.Error(t, ctx.Err(), "backup is stopped")
+	require.Len(t, ob.published, 1, "progress of the day is notified once")
+	assert.Contains(t, ob.published[0], `"daily_uploaded":"100"`)
+}
+
+func TestScheduleBudgetResume(t *testing.T) {
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+
+	replaced := make(chan struct{}, 1)
+	s.scheduleBudgetResume("key", time.Now().Add(time.Hour), func() error {
+		replaced <- struct{}{}
+		return nil
+	})
+	resumed := make(chan struct{}, 1)
+	s.scheduleBudgetResume("key", time.Now(), func() error {
+		resumed <- struct{}{}
+		return nil
+	})
+	select {
+	case <-resumed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backup is not resumed")
+	}
+	select {
+	case <-replaced:
+		t.Fatal("replaced resume ran")
+	default:
+	}
+	require.Eventually(t, func() bool {
+		s.budgetMu.Lock()
+		defer s.budgetMu.Unlock()
+		return len(s.budgetResumes) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestScheduleSavedBudgetResumes(t *testing.T) {
+	viper.Set("cache_dir", t.TempDir())
+	defer viper.Set("cache_dir", "")
+	client, err := backupapi.NewClient(backupapi.WithID("machine1"))
+	require.NoError(t, err)
+	s, err := New(WithBroker(&offlineBroker{}), WithBackupClient(client))
+	require.NoError(t, err)
+
+	save := func(key, rpID, stopped string, resumeAt time.Time) {
+		checkpoint := cache.NewCheckpoint(key, "action-"+rpID, rpID, nil)
+		checkpoint.StopUntil(stopped, resumeAt)
+		checkpoint.Resume, err = json.Marshal(backupRequest{BackupDirectoryID: "bd1", RecoveryPointType: backupapi.RecoveryPointTypeInitialReplica})
+		require.NoError(t, err)
+		repo, err := cache.NewRepository(viper.GetString("cache_dir"), "machine1", rpID)
+		require.NoError(t, err)
+		require.NoError(t, repo.SaveCheckpoint(checkpoint))
+	}
+	save("budget", "rp1", limiter.ErrDailyBudgetExhausted.Error(), time.Now().Add(time.Hour))
+	// backups stopped by their policy are resumed by the next backup of the policy
+	save("window", "rp2", backupapi.ErrBackupWindowClosed.Error(), time.Time{})
+
+	s.scheduleSavedBudgetResumes()
+	s.budgetMu.Lock()
+	defer s.budgetMu.Unlock()
+	assert.Len(t, s.budgetResumes, 1)
+	require.Contains(t, s.budgetResumes, "budget", "resume is scheduled again after restart")
+	s.budgetResumes["budget"].Stop()
+}
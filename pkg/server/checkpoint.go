@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+const (
+	// checkpointInterval is how often the progress of a backup is saved to its checkpoint.
+	checkpointInterval = time.Minute
+	// checkpointMaxAge is the age of checkpoints after which a backup creates a new recovery point.
+	checkpointMaxAge = 24 * time.Hour
+)
+
+// checkpointKey identifies backups which may resume each other's recovery point.
+func checkpointKey(backupDirectoryID, policyID, recoveryPointType string, onlyPaths []string) string {
+	return strings.Join(append([]string{backupDirectoryID, policyID, recoveryPointType}, onlyPaths...), "\x00")
+}
+
+// resumeCheckpoint returns the checkpoint of backup key and its recovery point action, when a backup of key was
+// interrupted by an agent restart and its recovery point can be resumed.
+func (s *Server) resumeCheckpoint(key string) (*cache.Checkpoint, *backupapi.CreateRecoveryPointResponse) {
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return nil, nil
+	}
+	checkpoint, err := cache.FindCheckpoint(cachePath, s.backupClient.Id, key)
+	if err != nil {
+		s.logger.Warn("failed to find checkpoint of interrupted backup", zap.Error(err))
+		return nil, nil
+	}
+	if checkpoint == nil {
+		return nil, nil
+	}
+	discard := func(reason string) {
+		s.logger.Info("Discard checkpoint of interrupted backup", zap.String("recovery_point_id", checkpoint.RecoveryPointID), zap.String("reason", reason))
+		if repo, err := cache.NewRepository(cachePath, s.backupClient.Id, checkpoint.RecoveryPointID); err == nil {
+			_ = repo.RemoveCheckpoint()
+		}
+	}
+	if time.Since(checkpoint.SavedAt) > checkpointMaxAge {
+		discard("checkpoint is too old")
+		return nil, nil
+	}
+	var action backupapi.CreateRecoveryPointResponse
+	if err := json.Unmarshal(checkpoint.Action, &action); err != nil || action.RecoveryPoint == nil || action.StorageVault == nil {
+		discard("invalid recovery point action")
+		return nil, nil
+	}
+	rp, err := s.backupClient.GetRecoveryPointInfo(action.RecoveryPoint.ID)
+	switch {
+	case errors.Is(err, backupapi.ErrNotFound):
+		discard("recovery point not found")
+		return nil, nil
+	case err != nil:
+		s.logger.Warn("failed to get recovery point of interrupted backup", zap.Error(err))
+		return nil, nil
+	case rp.Status == backupapi.RecoveryPointStatusCompleted:
+		discard("recovery point is " + rp.Status)
+		return nil, nil
+	case rp.Status == backupapi.RecoveryPointStatusFAILED && checkpoint.Stopped == "":
+		// a backup stopped by its policy failed its recovery point on purpose, it is resumed
+		discard("recovery point is " + rp.Status)
+		return nil, nil
+	}
+	checkpoint.Stopped, checkpoint.ResumeAt = "", time.Time{}
+	return checkpoint, &action
+}
+
+// startCheckpoint saves checkpoint to cacheWriter now and every checkpointInterval. The returned function stops
+// saving and removes the checkpoint, so it is only kept when agent exits while backing up or the backup is stopped by
+// its policy.
+func (s *Server) startCheckpoint(cacheWriter *cache.Repository, checkpoint *cache.Checkpoint) func() {
+	save := func() {
+		if err := cacheWriter.SaveCheckpoint(checkpoint); err != nil {
+			s.logger.Warn("failed to save checkpoint", zap.Error(err))
+		}
+	}
+	save()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		if checkpoint.StopReason() != "" {
+			save()
+			return
+		}
+		if err := cacheWriter.RemoveCheckpoint(); err != nil {
+			s.logger.Warn("failed to remove checkpoint", zap.Error(err))
+		}
+	}
+}
+
+// checkpointedActions returns the IDs of actions whose backup can be resumed from a checkpoint.
+func (s *Server) checkpointedActions() map[string]bool {
+	if s.backupClient == nil {
+		return nil
+	}
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return nil
+	}
+	checkpoints, err := cache.Checkpoints(cachePath, s.backupClient.Id)
+	if err != nil {
+		return nil
+	}
+	actions := make(map[string]bool, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		actions[checkpoint.ActionID] = true
+	}
+	return actions
+}
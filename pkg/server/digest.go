@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// eventTypeDigest is the event type of a batch of notifications.
+const eventTypeDigest = "notification_digest"
+
+// digestInterval returns notify_digest_interval config, how long non-critical notifications are batched before they
+// are published. Digests are opt-in, as backup servers have to understand them: 0, the default, publishes every
+// notification immediately.
+func digestInterval() time.Duration {
+	return time.Duration(viper.GetInt("notify_digest_interval")) * time.Second
+}
+
+// criticalStatus reports whether a notification of action status is published immediately. The other statuses
+// report progress of running actions, they are batched.
+func criticalStatus(status string) bool {
+	switch status {
+	case statusUploadFile, statusDownloading, statusRetrieving:
+		return false
+	}
+	return true
+}
+
+// notificationDigest batches non-critical notifications by topic and publishes them as one digest message per
+// topic once per interval. A notification replaces the batched one of the same key, only the latest progress of an
+// action matters. It is safe for concurrent use.
+type notificationDigest struct {
+	publish func(topic string, payload []byte) error
+	logger  *zap.Logger
+
+	// flushMu is held while a digest is published, so notifications published after a flush follow it.
+	flushMu sync.Mutex
+
+	mu      sync.Mutex
+	topics  []string
+	batches map[string]*digestBatch
+	timer   *time.Timer
+}
+
+// digestBatch are the notifications batched for a topic, in order of their keys.
+type digestBatch struct {
+	keys  []string
+	items map[string]interface{}
+}
+
+func newNotificationDigest(publish func(topic string, payload []byte) error, logger *zap.Logger) *notificationDigest {
+	return &notificationDigest{publish: publish, logger: logger, batches: make(map[string]*digestBatch)}
+}
+
+// add batches msg for topic under key, the digest is published after interval. A non-positive interval disables
+// digests: the batched notifications are flushed and msg is published at once on its own.
+func (d *notificationDigest) add(topic, key string, msg interface{}, interval time.Duration) {
+	if interval <= 0 {
+		payload, _ := json.Marshal(msg)
+		if err := d.publishNow(topic, payload); err != nil {
+			d.logger.Warn("failed to publish notification", zap.Error(err), zap.String("topic", topic))
+		}
+		return
+	}
+	d.mu.Lock()
+	batch, ok := d.batches[topic]
+	if !ok {
+		batch = &digestBatch{items: make(map[string]interface{})}
+		d.batches[topic] = batch
+		d.topics = append(d.topics, topic)
+	}
+	if _, ok := batch.items[key]; !ok {
+		batch.keys = append(batch.keys, key)
+	}
+	batch.items[key] = msg
+	if d.timer == nil {
+		d.timer = time.AfterFunc(interval, d.flush)
+	}
+	d.mu.Unlock()
+}
+
+// flush publishes the batched notifications, one digest message per topic.
+func (d *notificationDigest) flush() {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+	d.flushLocked()
+}
+
+// publishNow publishes a critical notification after the batched ones, so the backend sees the progress of an action
+// before its final status.
+func (d *notificationDigest) publishNow(topic string, payload []byte) error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+	d.flushLocked()
+	return d.publish(topic, payload)
+}
+
+// flushLocked publishes the batched notifications. flushMu must be held.
+func (d *notificationDigest) flushLocked() {
+	d.mu.Lock()
+	topics, batches := d.topics, d.batches
+	d.topics, d.batches = nil, make(map[string]*digestBatch)
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.mu.Unlock()
+
+	for _, topic := range topics {
+		batch := batches[topic]
+		notifications := make([]interface{}, 0, len(batch.keys))
+		for _, key := range batch.keys {
+			notifications = append(notifications, batch.items[key])
+		}
+		payload, _ := json.Marshal(map[string]interface{}{
+			"schema_version": schemaVersion,
+			"event_type":     eventTypeDigest,
+			"notifications":  notifications,
+		})
+		if err := d.publish(topic, payload); err != nil {
+			d.logger.Warn("failed to publish notification digest", zap.Error(err), zap.String("topic", topic), zap.Int("notifications", len(notifications)))
+		}
+	}
+}
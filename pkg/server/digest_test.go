@@ -0,0 +1,65 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerNotificationDigest(t *testing.T) {
+	viper.Set("notify_digest_interval", 3600)
+	defer viper.Set("notify_digest_interval", nil)
+	ob := &offlineBroker{}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+
+	s.notifyMsg(map[string]string{"action_id": "a", "status": statusUploadFile})
+	s.notifyMsg(map[string]string{"action_id": "b", "status": statusDownloading})
+	s.notifyMsg(map[string]string{"action_id": "a", "status": statusUploadFile, "daily_uploaded": "10"})
+	assert.Empty(t, ob.published, "progress statuses are batched")
+
+	s.notifyMsg(map[string]string{"action_id": "a", "status": statusComplete})
+	require.Len(t, ob.published, 2, "critical status is published after the digest")
+	assert.JSONEq(t, `{"schema_version": "1", "event_type": "notification_digest", "notifications": [
+		{"schema_version": "1", "action_id": "a", "status": "UPLOADING", "daily_uploaded": "10"},
+		{"schema_version": "1", "action_id": "b", "status": "DOWNLOADING"}]}`, ob.published[0])
+	assert.JSONEq(t, `{"schema_version": "1", "action_id": "a", "status": "COMPLETED"}`, ob.published[1])
+
+	s.notifyMsg(map[string]string{"action_id": "b", "status": statusFailed, "reason": "failed"})
+	assert.Len(t, ob.published, 3, "nothing batched, no digest")
+}
+
+func TestServerNotificationDigestDisabledByDefault(t *testing.T) {
+	ob := &offlineBroker{}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+
+	s.notifyMsg(map[string]string{"action_id": "a", "status": statusUploadFile})
+	require.Len(t, ob.published, 1, "progress status is published immediately")
+	assert.JSONEq(t, `{"schema_version": "1", "action_id": "a", "status": "UPLOADING"}`, ob.published[0])
+}
+
+func TestNotificationDigestInterval(t *testing.T) {
+	published := make(chan string, 10)
+	d := newNotificationDigest(func(topic string, payload []byte) error {
+		published <- topic
+		return nil
+	}, nil)
+	d.add("agent/progress/rp", "scan", map[string]string{"STATISTIC": "1"}, 10*time.Millisecond)
+	d.add("agent/progress/rp", "scan", map[string]string{"SCANNED": "2"}, 10*time.Millisecond)
+	select {
+	case topic := <-published:
+		assert.Equal(t, "agent/progress/rp", topic)
+	case <-time.After(5 * time.Second):
+		t.Fatal("digest is not published after interval")
+	}
+
+	d.add("agent/machine", "agent_update_state", map[string]string{}, 0)
+	assert.Equal(t, "agent/machine", <-published, "digest is disabled")
+	assert.Empty(t, published)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+// newDiskLimiter creates the disk limiter shared by backups from limit_disk_read and limit_disk_iops config.
+func newDiskLimiter() *limiter.DiskLimiter {
+	return limiter.NewDiskLimiter(viper.GetInt("limit_disk_read"), viper.GetInt("limit_disk_iops"))
+}
+
+// policyDiskLimiter returns the limiter pacing files read by a backup of policyID. A policy setting its own disk
+// limits has a limiter for the backup, other backups share the limiter tuned by update_disk_limit config updates,
+// so their reads add up to the limits of the machine.
+func (s *Server) policyDiskLimiter(ctx context.Context, backupDirectoryID, policyID string) *limiter.DiskLimiter {
+	if policyID == "" {
+		return s.diskLimiter
+	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		s.logger.Warn("Get config for disk limits of policy error, use the limits of agent", zap.Error(err))
+		return s.diskLimiter
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil || (policy.LimitDiskRead == 0 && policy.LimitDiskIOPS == 0) {
+		return s.diskLimiter
+	}
+	return limiter.NewDiskLimiter(policy.LimitDiskRead, policy.LimitDiskIOPS)
+}
+
+// updateDiskLimit changes the disk limits of agent, backups sharing the limiter of agent are paced by them at once.
+func (s *Server) updateDiskLimit(config broker.Message) {
+	if config.LimitDiskRead == nil && config.LimitDiskIOPS == nil {
+		return
+	}
+	if config.LimitDiskRead != nil {
+		viper.Set("limit_disk_read", *config.LimitDiskRead)
+	}
+	if config.LimitDiskIOPS != nil {
+		viper.Set("limit_disk_iops", *config.LimitDiskIOPS)
+	}
+	readKb, iops := viper.GetInt("limit_disk_read"), viper.GetInt("limit_disk_iops")
+	s.logger.Sugar().Infof("handleConfigUpdate: updating disk limits to %d KiB/s, %d IOPS", readKb, iops)
+	s.diskLimiter.SetLimits(readKb, iops)
+}
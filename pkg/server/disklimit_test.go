@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+)
+
+func TestServerUpdateDiskLimit(t *testing.T) {
+	viper.Set("limit_disk_read", 1024)
+	defer viper.Set("limit_disk_read", 0)
+	defer viper.Set("limit_disk_iops", 0)
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+	readKb, iops := s.diskLimiter.Limits()
+	assert.Equal(t, 1024, readKb)
+	assert.Equal(t, 0, iops)
+
+	limitIOPS := 200
+	require.NoError(t, s.handleConfigUpdate(broker.Message{
+		EventType:     broker.ConfigUpdate,
+		Action:        broker.ConfigUpdateActionDiskLimit,
+		LimitDiskIOPS: &limitIOPS,
+	}))
+	readKb, iops = s.diskLimiter.Limits()
+	assert.Equal(t, 1024, readKb, "missing limit is unchanged")
+	assert.Equal(t, 200, iops)
+	assert.Equal(t, 200, viper.GetInt("limit_disk_iops"))
+	assert.Same(t, s.diskLimiter, s.policyDiskLimiter(context.Background(), "bd", ""))
+}
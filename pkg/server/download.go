@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// errRecoveryPointArchived is returned when a recovery point to download is in archive tier.
+var errRecoveryPointArchived = errors.New("recovery point is archived, restore it to retrieve its chunks first")
+
+// DownloadRecoveryPoint streams the files of a recovery point as an archive of "format", zip by default or tar.gz.
+// The index is read from the cache or storage vault "storage_vault_id", the chunks from this storage vault with the
+// restore session key of X-Restore-Session-Key and X-Session-Created-At headers when they are given.
+func (s *Server) DownloadRecoveryPoint(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = backupapi.ArchiveZip
+	}
+	storageVaultID := r.URL.Query().Get("storage_vault_id")
+	if !isArchiveFormat(format) || storageVaultID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("storage_vault_id is required and format one of %s", strings.Join(backupapi.ArchiveFormats, ", "))))
+		return
+	}
+	var restoreKey *backupapi.AuthRestore
+	if key := r.Header.Get("X-Restore-Session-Key"); key != "" {
+		restoreKey = &backupapi.AuthRestore{
+			RecoveryPointID:   recoveryPointID,
+			CreatedAt:         r.Header.Get("X-Session-Created-At"),
+			RestoreSessionKey: key,
+		}
+	}
+
+	export, err := s.prepareDownload(r.Context(), recoveryPointID, storageVaultID, restoreKey)
+	if err != nil {
+		s.logger.Error("Error prepare download of recovery point", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+		if errors.Is(err, errRecoveryPointArchived) {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", backupapi.ArchiveContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", recoveryPointID+"."+format))
+	if err := export(w, format); err != nil {
+		// the response has started, the archive is left truncated
+		s.logger.Error("Error export archive of recovery point", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+	}
+}
+
+// prepareDownload reads the index of recovery point and opens its storage vault, it returns the function writing the
+// archive of recovery point.
+func (s *Server) prepareDownload(ctx context.Context, recoveryPointID, storageVaultID string, restoreKey *backupapi.AuthRestore) (func(w http.ResponseWriter, format string) error, error) {
+	rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+	if err != nil {
+		return nil, err
+	}
+	vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, "", restoreKey)
+	if err != nil {
+		return nil, err
+	}
+	storageVault, err := s.NewStorageVault(*vault, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	index, err := s.loadIndexFrom(ctx, rp, func() (storage_vault.StorageVault, error) { return storageVault, nil })
+	if err != nil {
+		return nil, err
+	}
+	tier, err := s.backupClient.GetRecoveryPointTier(ctx, storageVault, s.backupClient.Id, rp.ID)
+	if err != nil {
+		return nil, err
+	}
+	if tier.Tier == backupapi.TierArchive {
+		return nil, errRecoveryPointArchived
+	}
+	return func(w http.ResponseWriter, format string) error {
+		defer s.backupClient.CacheChunks()()
+		return s.backupClient.ExportArchive(ctx, w, *index, format, storageVault, restoreKey, nil)
+	}, nil
+}
+
+// isArchiveFormat reports whether recovery points can be downloaded as archives of format.
+func isArchiveFormat(format string) bool {
+	for _, f := range backupapi.ArchiveFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
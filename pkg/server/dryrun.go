@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// DryRunReport is what a backup of a backup directory would transfer. It is found by scanning the directory and
+// detecting the files changed since its latest recovery point, their content is neither read nor uploaded.
+type DryRunReport struct {
+	BackupDirectoryID string `json:"backup_directory_id"`
+	// LatestRecoveryPointID is the recovery point files are compared with, it is empty when all files are new.
+	LatestRecoveryPointID string `json:"latest_recovery_point_id,omitempty"`
+	// Files and Bytes are the files scanned, directories and links included.
+	Files int64  `json:"files"`
+	Bytes uint64 `json:"bytes"`
+	// ChangedFiles and ChangedBytes are the files which would be read and uploaded.
+	ChangedFiles int64  `json:"changed_files"`
+	ChangedBytes uint64 `json:"changed_bytes"`
+	// Chunks is the estimated number of chunks of changed files, by the average chunk size. Chunks already stored are
+	// not uploaded again, so fewer may be transferred.
+	Chunks uint64 `json:"chunks"`
+}
+
+// dryRunBackup scans backup directory backupDirectoryID like a backup by policyID and compares it with its latest
+// recovery point. The index of the latest recovery point is downloaded from storage vault storageVaultID when it is
+// not cached, no recovery point is created.
+func (s *Server) dryRunBackup(ctx context.Context, backupDirectoryID, policyID, storageVaultID string, onlyPaths []string) (*DryRunReport, error) {
+	bd, err := s.backupClient.GetBackupDirectory(backupDirectoryID)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := s.policyLimits(ctx, backupDirectoryID, policyID)
+	if err != nil {
+		return nil, err
+	}
+	chunking, err := s.policyChunking(ctx, backupDirectoryID, policyID)
+	if err != nil {
+		return nil, err
+	}
+	detector, err := cache.NewChangeDetector(viper.GetString("change_detection"))
+	if err != nil {
+		return nil, err
+	}
+
+	index := cache.NewIndex(bd.ID, "")
+	if _, _, err = ScanRoots(ctx, bd, limits, onlyPaths, index, nil, s.logger); err != nil {
+		return nil, err
+	}
+
+	latest := &cache.Index{}
+	lrp, err := s.backupClient.GetLatestRecoveryPointID(backupDirectoryID)
+	if err != nil {
+		return nil, err
+	}
+	if lrp.ID != "" {
+		if latest, err = s.loadIndex(ctx, lrp, storageVaultID); err != nil {
+			return nil, err
+		}
+	}
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return nil, err
+	}
+	checksums := cache.OpenChecksumCache(cachePath, s.backupClient.Id, bd.ID, storageVaultID)
+
+	report := s.dryRun(index, latest, checksums.Detector(detector), checksums, chunking)
+	report.BackupDirectoryID = bd.ID
+	report.LatestRecoveryPointID = lrp.ID
+	s.logger.Info("Dry run backup", zap.String("backup_directory_id", bd.ID), zap.Int64("changed_files", report.ChangedFiles),
+		zap.Uint64("changed_bytes", report.ChangedBytes), zap.Uint64("chunks", report.Chunks))
+	return &report, nil
+}
+
+// dryRun counts the items of index and the files a backup would upload, as it decides them against latest, the index
+// of latest recovery point.
+func (s *Server) dryRun(index, latest *cache.Index, detector cache.ChangeDetector, checksums *cache.ChecksumCache, chunking backupapi.Chunking) DryRunReport {
+	var report DryRunReport
+	for _, item := range index.Items {
+		report.Files++
+		report.Bytes += item.Size
+		if item.Type != "file" || index.HardLinkTarget(item) != nil {
+			// hard links are backed up with their target
+			continue
+		}
+		lastInfo := latest.Items[item.AbsolutePath]
+		if cached := checksums.Lookup(item); cached != nil {
+			lastInfo = cached
+		}
+		if s.backupClient.FileChanged(detector, lastInfo, item) {
+			report.ChangedFiles++
+			report.ChangedBytes += item.Size
+			report.Chunks += chunking.EstimateChunks(item.Size)
+		}
+	}
+	return report
+}
+
+// dryRunRestore previews a restore of recovery point recoveryPointID to destDir, selected by filter, with conflict.
+// The index of the recovery point is downloaded from storage vault storageVaultID when it is not cached, no file
+// content is downloaded.
+func (s *Server) dryRunRestore(ctx context.Context, recoveryPointID, storageVaultID, destDir string, filter backupapi.PathFilter, conflict backupapi.ConflictPolicy) (*backupapi.RestorePreview, error) {
+	if err := conflict.Check(); err != nil {
+		return nil, err
+	}
+	rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+	if err != nil {
+		return nil, err
+	}
+	index, err := s.loadIndex(ctx, rp, storageVaultID)
+	if err != nil {
+		return nil, err
+	}
+	if err := filter.Apply(index); err != nil {
+		return nil, err
+	}
+	if err := backupapi.CheckRestorePaths(*index, destDir); err != nil {
+		return nil, err
+	}
+	preview, err := backupapi.PreviewRestore(ctx, *index, filepath.Clean(destDir), conflict)
+	if err != nil {
+		return nil, err
+	}
+	preview.RecoveryPointID = rp.ID
+	s.logger.Info("Dry run restore", zap.String("recovery_point_id", rp.ID), zap.Int64("create_files", preview.Create.Files),
+		zap.Int64("overwrite_files", preview.Overwrite.Files), zap.Int64("rename_files", preview.Rename.Files),
+		zap.Int64("skip_files", preview.Skip.Files))
+	return preview, nil
+}
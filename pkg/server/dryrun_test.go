@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestServerDryRun(t *testing.T) {
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "same"), make([]byte, 10), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "changed"), make([]byte, 3<<20), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "new"), make([]byte, 100), 0600))
+	require.NoError(t, os.Link(filepath.Join(dir, "new"), filepath.Join(dir, "link")))
+
+	index := cache.NewIndex("bd", "")
+	_, _, err = WalkerDir(context.Background(), dir, index, nil, nil, zap.NewNop())
+	require.NoError(t, err)
+	latest := cache.NewIndex("bd", "rp")
+	same := *index.Items[filepath.Join(dir, "same")]
+	latest.Items[same.AbsolutePath] = &same
+	changed := *index.Items[filepath.Join(dir, "sub", "changed")]
+	changed.ModTime = changed.ModTime.Add(-time.Hour)
+	latest.Items[changed.AbsolutePath] = &changed
+
+	detector, err := cache.NewChangeDetector(cache.ChangeDetectionMtime)
+	require.NoError(t, err)
+	report := s.dryRun(index, latest, detector, nil, backupapi.DefaultChunking)
+	assert.Equal(t, int64(len(index.Items)), report.Files)
+	assert.Equal(t, int64(2), report.ChangedFiles, "unchanged files and hard links are not uploaded")
+	assert.Equal(t, uint64(3<<20+100), report.ChangedBytes)
+	assert.Equal(t, uint64(4), report.Chunks)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Types of action events.
+const (
+	eventStatus   = "status"
+	eventProgress = "progress"
+	eventEnd      = "end"
+)
+
+// eventKeepAlive is the interval of comments sent on an idle event stream, so proxies do not close it.
+const eventKeepAlive = 15 * time.Second
+
+// actionEvent is a message of an action published to the broker, sent to the event streams of the action.
+type actionEvent struct {
+	kind string
+	data []byte
+}
+
+// subscribe returns the events of running action actionID until it ends, and the function to stop receiving them.
+// It returns false when no such action runs.
+func (f *progressFeed) subscribe(actionID string) (<-chan actionEvent, func(), bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.actions[actionID]; !ok {
+		return nil, nil, false
+	}
+	ch := make(chan actionEvent, feedClientBuffer)
+	if f.events[actionID] == nil {
+		f.events[actionID] = make(map[chan actionEvent]bool)
+	}
+	f.events[actionID][ch] = true
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.events[actionID][ch] {
+			f.unsubscribeLocked(actionID, ch)
+		}
+	}, true
+}
+
+// unsubscribeLocked closes subscription ch of action actionID, f.mu is held.
+func (f *progressFeed) unsubscribeLocked(actionID string, ch chan actionEvent) {
+	delete(f.events[actionID], ch)
+	if len(f.events[actionID]) == 0 {
+		delete(f.events, actionID)
+	}
+	close(ch)
+}
+
+// event sends msg of kind to the subscribers of action actionID. Subscribers whose queue is full are dropped.
+func (f *progressFeed) event(actionID, kind string, msg map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.eventLocked(actionID, kind, msg)
+}
+
+func (f *progressFeed) eventLocked(actionID, kind string, msg map[string]string) {
+	if len(f.events[actionID]) == 0 {
+		return
+	}
+	data, _ := json.Marshal(msg)
+	for ch := range f.events[actionID] {
+		select {
+		case ch <- actionEvent{kind: kind, data: data}:
+		default:
+			f.logger.Debug("Drop action event stream not reading")
+			f.unsubscribeLocked(actionID, ch)
+		}
+	}
+}
+
+// progressEvent sends progress msg of recovery point recoveryPointID to the subscribers of its running actions.
+func (f *progressFeed) progressEvent(recoveryPointID string, msg map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.actions {
+		if a.RecoveryPointID == recoveryPointID {
+			f.eventLocked(a.ActionID, eventProgress, msg)
+		}
+	}
+}
+
+// endEventsLocked sends the end of action actionID to its subscribers and closes their streams, f.mu is held.
+func (f *progressFeed) endEventsLocked(actionID string) {
+	for ch := range f.events[actionID] {
+		select {
+		case ch <- actionEvent{kind: eventEnd, data: []byte("{}")}:
+		default:
+		}
+		f.unsubscribeLocked(actionID, ch)
+	}
+}
+
+// ActionEvents streams the status and progress messages the running action actionID publishes to the broker as
+// server-sent events, "status" and "progress" events whose data is the JSON message. An "end" event is sent when
+// the action ends, then the stream is closed.
+func (s *Server) ActionEvents(w http.ResponseWriter, r *http.Request) {
+	actionID := chi.URLParam(r, "actionID")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("streaming is not supported"))
+		return
+	}
+	events, stop, ok := s.feed.subscribe(actionID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(fmt.Sprintf("action %s is not running on this agent", actionID)))
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, _ = io.WriteString(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				// dropped as it did not read events
+				return
+			}
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.kind, e.data)
+			if e.kind == eventEnd {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// FollowActionEvents writes the data of the events of stream r, a response of ActionEvents, to w as lines of JSON
+// until the action ends. It returns an error when the action fails or the stream is closed before the action ends.
+func FollowActionEvents(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var kind, status string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			kind = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if kind == eventEnd {
+				if status == statusFailed {
+					return errors.New("action failed")
+				}
+				return nil
+			}
+			var msg map[string]string
+			if err := json.Unmarshal([]byte(data), &msg); err == nil && kind == eventStatus && msg["status"] != "" {
+				status = msg["status"]
+			}
+			if _, err := fmt.Fprintln(w, data); err != nil {
+				return err
+			}
+		case line == "":
+			kind = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream closed before the action ended")
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActionEvents(t *testing.T) {
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine", "agent/machine/progress"}
+	api := httptest.NewServer(s.router)
+	defer api.Close()
+
+	resp, err := http.Get(api.URL + "/actions/a1/events")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "action is not running")
+
+	s.feed.startAction("a1", feedActionRestore, "rp1", "")
+	resp, err = http.Get(api.URL + "/actions/a1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	s.notifyMsg(map[string]string{"action_id": "a1", "status": statusDownloading})
+	s.notifyMsg(map[string]string{"action_id": "other", "status": statusDownloading})
+	s.notifyMsgProgress("rp1", map[string]string{"percent": "50.00%", "recovery_point_id": "rp1"})
+	s.notifyMsgProgress("rp2", map[string]string{"percent": "10.00%", "recovery_point_id": "rp2"})
+	s.notifyMsg(map[string]string{"action_id": "a1", "status": statusComplete})
+	s.feed.endAction("a1")
+
+	var out bytes.Buffer
+	require.NoError(t, FollowActionEvents(resp.Body, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	var msgs []map[string]string
+	for _, line := range lines {
+		var msg map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &msg))
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, statusDownloading, msgs[0]["status"])
+	assert.Equal(t, "50.00%", msgs[1]["percent"])
+	assert.Equal(t, statusComplete, msgs[2]["status"])
+}
+
+func TestFollowActionEvents(t *testing.T) {
+	failed := "event: status\ndata: {\"action_id\":\"a1\",\"status\":\"FAILED\"}\n\nevent: end\ndata: {}\n\n"
+	var out bytes.Buffer
+	assert.Error(t, FollowActionEvents(strings.NewReader(failed), &out))
+	assert.Contains(t, out.String(), "FAILED")
+
+	assert.Error(t, FollowActionEvents(strings.NewReader(": keep-alive\n\n"), &out), "stream closed before the action ended")
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+// backupQueues are the queues files and chunks of a backup wait in for their turn in the pools shared by backups.
+type backupQueues struct {
+	files  *limiter.FairQueue
+	chunks *limiter.FairQueue
+}
+
+// policyPriority returns the priority of backups of policyID, 1 without policy.
+func (s *Server) policyPriority(ctx context.Context, backupDirectoryID, policyID string) int {
+	if policyID == "" {
+		return 1
+	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		s.logger.Warn("Get config for priority of policy error, use priority 1", zap.Error(err))
+		return 1
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil || policy.Priority < 1 {
+		return 1
+	}
+	return policy.Priority
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// feedClientBuffer is the number of states queued to a feed client, a client which does not read them is dropped.
+const feedClientBuffer = 16
+
+// Types of actions in the progress feed.
+const (
+	feedActionBackup  = "backup"
+	feedActionRestore = "restore"
+)
+
+// Phases of actions in the progress feed, in order.
+const (
+	phaseScanning         = "scanning"
+	phaseUploading        = "uploading"
+	phaseFinalizing       = "finalizing"
+	phaseDownloading      = "downloading"
+	phaseApplyingMetadata = "applying-metadata"
+)
+
+// feedListener accepts the clients of the progress feed, on a unix socket or a named pipe on Windows.
+type feedListener interface {
+	Accept() (io.WriteCloser, error)
+	Close() error
+}
+
+// feedSchedule is a scheduled backup of a policy of backup directory.
+type feedSchedule struct {
+	BackupDirectoryID string    `json:"backup_directory_id"`
+	PolicyID          string    `json:"policy_id"`
+	Path              string    `json:"path"`
+	Pattern           string    `json:"pattern"`
+	NextRun           time.Time `json:"next_run"`
+}
+
+// feedAction is a running action of agent. Percent and ETA are the ones of its current phase.
+type feedAction struct {
+	ActionID          string      `json:"action_id"`
+	Type              string      `json:"type"`
+	RecoveryPointID   string      `json:"recovery_point_id"`
+	BackupDirectoryID string      `json:"backup_directory_id,omitempty"`
+	Status            string      `json:"status"`
+	Phase             string      `json:"phase,omitempty"`
+	Percent           string      `json:"percent,omitempty"`
+	ETA               string      `json:"eta,omitempty"`
+	StartedAt         time.Time   `json:"started_at"`
+	Phases            []feedPhase `json:"phases,omitempty"`
+}
+
+// feedPhase is a phase of an action, the phases done have EndedAt.
+type feedPhase struct {
+	Name      string     `json:"name"`
+	Percent   string     `json:"percent,omitempty"`
+	ETA       string     `json:"eta,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// feedState is a line of the progress feed.
+type feedState struct {
+	Time     time.Time      `json:"time"`
+	Schedule []feedSchedule `json:"schedule"`
+	Actions  []feedAction   `json:"actions"`
+}
+
+// progressFeed publishes the backup schedule and the running actions of agent with their progress to local
+// clients, such as a desktop UI, without going through the broker. Each client gets the current state when it
+// connects, then the whole state again on every change, as a line of JSON.
+type progressFeed struct {
+	schedule func() []feedSchedule
+	logger   *zap.Logger
+
+	mu       sync.Mutex
+	listener feedListener
+	clients  map[chan []byte]bool
+	actions  map[string]*feedAction
+	// events are the subscribers of the events of running actions, by action id.
+	events map[string]map[chan actionEvent]bool
+}
+
+func newProgressFeed(schedule func() []feedSchedule, logger *zap.Logger) *progressFeed {
+	return &progressFeed{
+		schedule: schedule,
+		logger:   logger,
+		clients:  make(map[chan []byte]bool),
+		actions:  make(map[string]*feedAction),
+		events:   make(map[string]map[chan actionEvent]bool),
+	}
+}
+
+// serve accepts clients of l until the feed is closed.
+func (f *progressFeed) serve(l feedListener) error {
+	f.mu.Lock()
+	f.listener = l
+	f.mu.Unlock()
+	for {
+		w, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		ch := make(chan []byte, feedClientBuffer)
+		f.mu.Lock()
+		f.clients[ch] = true
+		ch <- f.stateLocked()
+		f.mu.Unlock()
+		go f.write(w, ch)
+	}
+}
+
+// write writes the states of ch to client w until it is dropped or fails.
+func (f *progressFeed) write(w io.WriteCloser, ch chan []byte) {
+	defer w.Close()
+	for line := range ch {
+		if _, err := w.Write(line); err != nil {
+			f.drop(ch)
+			return
+		}
+	}
+}
+
+func (f *progressFeed) drop(ch chan []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.clients[ch] {
+		delete(f.clients, ch)
+		close(ch)
+	}
+}
+
+// stateLocked returns the current state as a line of the feed, f.mu is held.
+func (f *progressFeed) stateLocked() []byte {
+	state := feedState{Time: time.Now(), Schedule: f.schedule(), Actions: make([]feedAction, 0, len(f.actions))}
+	if state.Schedule == nil {
+		state.Schedule = []feedSchedule{}
+	}
+	for _, a := range f.actions {
+		action := *a
+		action.Phases = append([]feedPhase(nil), a.Phases...)
+		state.Actions = append(state.Actions, action)
+	}
+	sort.Slice(state.Actions, func(i, j int) bool { return state.Actions[i].StartedAt.Before(state.Actions[j].StartedAt) })
+	buf, _ := json.Marshal(state)
+	return append(buf, '\n')
+}
+
+// broadcastLocked sends the current state to clients, f.mu is held. Clients whose queue is full are dropped.
+func (f *progressFeed) broadcastLocked() {
+	if len(f.clients) == 0 {
+		return
+	}
+	line := f.stateLocked()
+	for ch := range f.clients {
+		select {
+		case ch <- line:
+		default:
+			f.logger.Debug("Drop progress feed client not reading")
+			delete(f.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// startAction adds a running action of type kind.
+func (f *progressFeed) startAction(actionID, kind, recoveryPointID, backupDirectoryID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.actions[actionID] = &feedAction{
+		ActionID:          actionID,
+		Type:              kind,
+		RecoveryPointID:   recoveryPointID,
+		BackupDirectoryID: backupDirectoryID,
+		StartedAt:         time.Now(),
+	}
+	f.broadcastLocked()
+}
+
+// status sets status of running action actionID.
+func (f *progressFeed) status(actionID, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.actions[actionID]
+	if !ok || a.Status == status {
+		return
+	}
+	a.Status = status
+	f.broadcastLocked()
+}
+
+// phase starts phase of running action actionID, its previous phase is done.
+func (f *progressFeed) phase(actionID, phase string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	a, ok := f.actions[actionID]
+	if !ok || a.Phase == phase {
+		return
+	}
+	now := time.Now()
+	if n := len(a.Phases); n > 0 {
+		a.Phases[n-1].EndedAt = &now
+		a.Phases[n-1].ETA = ""
+	}
+	a.Phases = append(a.Phases, feedPhase{Name: phase, StartedAt: now})
+	a.Phase, a.Percent, a.ETA = phase, "", ""
+	f.broadcastLocked()
+}
+
+// progress sets the percent done and the estimated time left of the current phase of the running action of
+// recovery point recoveryPointID.
+func (f *progressFeed) progress(recoveryPointID, percent, eta string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, a := range f.actions {
+		if a.RecoveryPointID == recoveryPointID && (a.Percent != percent || a.ETA != eta) {
+			a.Percent, a.ETA = percent, eta
+			if n := len(a.Phases); n > 0 {
+				a.Phases[n-1].Percent, a.Phases[n-1].ETA = percent, eta
+			}
+			f.broadcastLocked()
+		}
+	}
+}
+
+// endAction removes action actionID once it is done, its last status was sent before.
+func (f *progressFeed) endAction(actionID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.actions[actionID]; !ok {
+		return
+	}
+	delete(f.actions, actionID)
+	f.endEventsLocked(actionID)
+	f.broadcastLocked()
+}
+
+// scheduleChanged sends the new schedule to clients.
+func (f *progressFeed) scheduleChanged() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.broadcastLocked()
+}
+
+// close stops accepting clients and disconnects them.
+func (f *progressFeed) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.clients {
+		delete(f.clients, ch)
+		close(ch)
+	}
+	for actionID := range f.events {
+		f.endEventsLocked(actionID)
+	}
+	if f.listener == nil {
+		return nil
+	}
+	return f.listener.Close()
+}
+
+// feedSchedule returns the scheduled backups of agent, in order of their next run.
+func (s *Server) feedSchedule() []feedSchedule {
+	var schedule []feedSchedule
+	for _, entry := range s.cronManager.Entries() {
+		job, ok := entry.Job.(scheduledBackup)
+		if !ok {
+			continue
+		}
+		schedule = append(schedule, feedSchedule{
+			BackupDirectoryID: job.backupDirectoryID,
+			PolicyID:          job.policyID,
+			Path:              job.path,
+			Pattern:           job.pattern,
+			NextRun:           entry.Next,
+		})
+	}
+	return schedule
+}
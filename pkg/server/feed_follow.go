@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// followBarWidth is the number of characters of the progress bar of a phase.
+const followBarWidth = 30
+
+// DialFeed connects to the progress feed of agent on path, see progress_socket.
+func DialFeed(path string) (io.ReadCloser, error) {
+	return dialFeed(path)
+}
+
+// FollowBackup renders the next backup of backup directory backupDirectoryID read from progress feed r to w, see
+// followFeed.
+func FollowBackup(r io.Reader, w io.Writer, backupDirectoryID string) error {
+	return followFeed(r, w, feedActionBackup, backupDirectoryID)
+}
+
+// FollowRestore renders the next restore of recovery point recoveryPointID read from progress feed r to w, see
+// followFeed.
+func FollowRestore(r io.Reader, w io.Writer, recoveryPointID string) error {
+	return followFeed(r, w, feedActionRestore, recoveryPointID)
+}
+
+// followFeed renders the action of type kind read from progress feed r to w, with a progress bar per phase. The action
+// is the backup of backup directory id, or the restore of recovery point id. It waits for the action to start and
+// returns once it is removed from the feed, with an error when it failed.
+func followFeed(r io.Reader, w io.Writer, kind, id string) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var last *feedAction
+	lines := 0
+	for {
+		var state feedState
+		if err := dec.Decode(&state); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("read progress feed: %w", err)
+		}
+		action := followedAction(state.Actions, last, kind, id)
+		if action == nil {
+			if last == nil {
+				continue
+			}
+			if last.Status == statusFailed {
+				return fmt.Errorf("%s %s failed", last.Type, last.RecoveryPointID)
+			}
+			return nil
+		}
+		last = action
+		lines = renderAction(w, action, lines)
+	}
+}
+
+// followedAction returns the action followed in actions, last is the one of the previous state.
+func followedAction(actions []feedAction, last *feedAction, kind, id string) *feedAction {
+	for i := range actions {
+		a := &actions[i]
+		if last != nil {
+			if a.ActionID == last.ActionID {
+				return a
+			}
+			continue
+		}
+		if a.Type == kind && (kind == feedActionBackup && a.BackupDirectoryID == id || kind == feedActionRestore && a.RecoveryPointID == id) {
+			return a
+		}
+	}
+	return nil
+}
+
+// renderAction writes the status of action and a line per phase to w, over the previous lines written.
+func renderAction(w io.Writer, action *feedAction, lines int) int {
+	var b strings.Builder
+	if lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", lines)
+	}
+	fmt.Fprintf(&b, "\r\x1b[K%s %s: %s\n", action.Type, action.RecoveryPointID, action.Status)
+	for _, phase := range action.Phases {
+		b.WriteString("\r\x1b[K" + renderPhase(phase) + "\n")
+	}
+	_, _ = io.WriteString(w, b.String())
+	return len(action.Phases) + 1
+}
+
+// renderPhase returns the line of phase, e.g. "uploading          [#########.....]  30.00%  ETA 0h:1m:5s".
+func renderPhase(phase feedPhase) string {
+	percent, _ := strconv.ParseFloat(strings.TrimSuffix(phase.Percent, "%"), 64)
+	if phase.EndedAt != nil {
+		percent = 100
+	}
+	filled := int(percent / 100 * followBarWidth)
+	if filled > followBarWidth {
+		filled = followBarWidth
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat(".", followBarWidth-filled)
+	line := fmt.Sprintf("%-18s [%s] %6.2f%%", phase.Name, bar, percent)
+	switch {
+	case phase.EndedAt != nil:
+		line += "  done in " + phase.EndedAt.Sub(phase.StartedAt).Round(time.Second).String()
+	case phase.ETA != "":
+		line += "  ETA " + phase.ETA
+	}
+	return line
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// feedSocketMode lets the users of the group of agent connect to the progress feed.
+const feedSocketMode = 0660
+
+// unixFeedListener accepts clients of the progress feed on a unix socket.
+type unixFeedListener struct {
+	net.Listener
+}
+
+func (l unixFeedListener) Accept() (io.WriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+// listenFeed listens on unix socket path, a socket left by a previous run is replaced.
+func listenFeed(path string) (feedListener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, feedSocketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return unixFeedListener{l}, nil
+}
+
+// dialFeed connects to the progress feed on unix socket path.
+func dialFeed(path string) (io.ReadCloser, error) {
+	return net.Dial("unix", path)
+}
@@ -0,0 +1,75 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// feedPipeBuffer is the size of the output buffer of a named pipe instance.
+const feedPipeBuffer = 64 * 1024
+
+var errFeedClosed = errors.New("progress feed is closed")
+
+// pipeFeedListener accepts clients of the progress feed on a named pipe, e.g. \\.\pipe\bizfly-backup-progress.
+type pipeFeedListener struct {
+	path string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// listenFeed listens on named pipe path.
+func listenFeed(path string) (feedListener, error) {
+	if _, err := windows.UTF16PtrFromString(path); err != nil {
+		return nil, err
+	}
+	return &pipeFeedListener{path: path}, nil
+}
+
+// Accept creates an instance of the pipe and waits for a client to connect to it.
+func (l *pipeFeedListener) Accept() (io.WriteCloser, error) {
+	name, _ := windows.UTF16PtrFromString(l.path)
+	h, err := windows.CreateNamedPipe(name, windows.PIPE_ACCESS_OUTBOUND,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, feedPipeBuffer, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := windows.ConnectNamedPipe(h, nil); err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		windows.CloseHandle(h)
+		return nil, errFeedClosed
+	}
+	return os.NewFile(uintptr(h), l.path), nil
+}
+
+// Close stops accepting clients, an Accept waiting for a client is released by connecting to its pipe.
+func (l *pipeFeedListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	name, _ := windows.UTF16PtrFromString(l.path)
+	h, err := windows.CreateFile(name, windows.GENERIC_READ, 0, nil, windows.OPEN_EXISTING, 0, 0)
+	if err == nil {
+		windows.CloseHandle(h)
+	}
+	return nil
+}
+
+// dialFeed connects to the progress feed on named pipe path.
+func dialFeed(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// chanFeedListener accepts the connections sent to it.
+type chanFeedListener chan io.WriteCloser
+
+func (l chanFeedListener) Accept() (io.WriteCloser, error) {
+	w, ok := <-l
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return w, nil
+}
+
+func (l chanFeedListener) Close() error {
+	close(l)
+	return nil
+}
+
+func readFeedState(t *testing.T, r *bufio.Reader) feedState {
+	t.Helper()
+	line, err := r.ReadBytes('\n')
+	require.NoError(t, err)
+	var state feedState
+	require.NoError(t, json.Unmarshal(line, &state))
+	return state
+}
+
+func TestProgressFeed(t *testing.T) {
+	next := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	schedule := []feedSchedule{{BackupDirectoryID: "bd1", PolicyID: "p1", Path: "/data", Pattern: "0 1 * * *", NextRun: next}}
+	f := newProgressFeed(func() []feedSchedule { return schedule }, zap.NewNop())
+	// actions before a client connects are in its first state
+	f.startAction("a1", feedActionBackup, "rp1", "bd1")
+
+	l := make(chanFeedListener)
+	done := make(chan error, 1)
+	go func() { done <- f.serve(l) }()
+	client, server := net.Pipe()
+	defer client.Close()
+	l <- server
+	r := bufio.NewReader(client)
+
+	state := readFeedState(t, r)
+	assert.Equal(t, schedule, state.Schedule)
+	require.Len(t, state.Actions, 1)
+	assert.Equal(t, "a1", state.Actions[0].ActionID)
+	assert.Equal(t, feedActionBackup, state.Actions[0].Type)
+
+	f.status("a1", statusUploadFile)
+	state = readFeedState(t, r)
+	assert.Equal(t, statusUploadFile, state.Actions[0].Status)
+
+	f.phase("a1", phaseUploading)
+	state = readFeedState(t, r)
+	assert.Equal(t, phaseUploading, state.Actions[0].Phase)
+	require.Len(t, state.Actions[0].Phases, 1)
+
+	f.progress("rp1", "42.00%", "0h:1m:0s")
+	state = readFeedState(t, r)
+	assert.Equal(t, "42.00%", state.Actions[0].Percent)
+	assert.Equal(t, "0h:1m:0s", state.Actions[0].ETA)
+	assert.Equal(t, "42.00%", state.Actions[0].Phases[0].Percent)
+
+	f.phase("a1", phaseFinalizing)
+	state = readFeedState(t, r)
+	require.Len(t, state.Actions[0].Phases, 2)
+	assert.NotNil(t, state.Actions[0].Phases[0].EndedAt, "previous phase is done")
+	assert.Empty(t, state.Actions[0].Phases[0].ETA)
+	assert.Nil(t, state.Actions[0].Phases[1].EndedAt)
+	assert.Empty(t, state.Actions[0].Percent)
+
+	// unknown actions and unchanged values are not sent
+	f.status("other", statusUploadFile)
+	f.phase("a1", phaseFinalizing)
+	f.progress("rp1", "", "")
+	f.status("a1", statusComplete)
+	state = readFeedState(t, r)
+	assert.Equal(t, statusComplete, state.Actions[0].Status)
+
+	f.endAction("a1")
+	state = readFeedState(t, r)
+	assert.Empty(t, state.Actions)
+
+	require.NoError(t, f.close())
+	assert.Error(t, <-done)
+	_, err := r.ReadBytes('\n')
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFollowFeed(t *testing.T) {
+	start := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Second)
+	other := feedAction{ActionID: "a0", Type: feedActionBackup, RecoveryPointID: "rp0", BackupDirectoryID: "bd2", Status: statusUploadFile}
+	backup := feedAction{ActionID: "a1", Type: feedActionBackup, RecoveryPointID: "rp1", BackupDirectoryID: "bd1", Status: statusUploadFile,
+		Phase: phaseUploading, Percent: "50.00%", ETA: "0h:0m:30s", StartedAt: start,
+		Phases: []feedPhase{
+			{Name: phaseScanning, StartedAt: start, EndedAt: &end},
+			{Name: phaseUploading, Percent: "50.00%", ETA: "0h:0m:30s", StartedAt: end},
+		}}
+	states := []feedState{
+		{Actions: []feedAction{other}},
+		{Actions: []feedAction{other, backup}},
+		{},
+	}
+	var feed bytes.Buffer
+	enc := json.NewEncoder(&feed)
+	for _, state := range states {
+		require.NoError(t, enc.Encode(state))
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, FollowBackup(&feed, &out, "bd1"))
+	assert.NotContains(t, out.String(), "rp0", "other actions are not rendered")
+	assert.Contains(t, out.String(), "backup rp1: UPLOADING")
+	assert.Contains(t, out.String(), "scanning           [##############################] 100.00%  done in 1m30s")
+	assert.Contains(t, out.String(), "uploading          [###############...............]  50.00%  ETA 0h:0m:30s")
+
+	// failed actions and feeds closed before the action ends are errors
+	backup.Status = statusFailed
+	feed.Reset()
+	require.NoError(t, enc.Encode(feedState{Actions: []feedAction{backup}}))
+	require.NoError(t, enc.Encode(feedState{}))
+	assert.Error(t, FollowBackup(&feed, &out, "bd1"))
+	require.NoError(t, enc.Encode(feedState{Actions: []feedAction{backup}}))
+	assert.Error(t, FollowRestore(&feed, &out, "rp1"), "restore does not follow backups")
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+// Page sizes of file listings.
+const (
+	defaultFilesLimit = 1000
+	maxFilesLimit     = 10000
+)
+
+// ListFiles returns a page of the items of a recovery point under "prefix" with their sizes and modification times,
+// from "offset", "limit" items, 1000 by default. The index is read from the cache or downloaded from storage vault
+// "storage_vault_id".
+func (s *Server) ListFiles(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	query := r.URL.Query()
+	offset, limit := 0, defaultFilesLimit
+	var err error
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("offset must be a non-negative number"))
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxFilesLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("limit must be a number from 1 to " + strconv.Itoa(maxFilesLimit)))
+			return
+		}
+	}
+
+	rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+	if err != nil {
+		s.logger.Error("Error get recoveryPointInfo", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	index, err := s.loadIndex(r.Context(), rp, query.Get("storage_vault_id"))
+	if err != nil {
+		s.logger.Error("Error load index", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+		if errors.Is(err, ErrIndexNotCached) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	listing := backupapi.ListFiles(*index, query.Get("prefix"), offset, limit)
+	listing.RecoveryPointID = rp.ID
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(listing)
+}
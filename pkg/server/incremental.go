@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// defaultIncrementalFullEvery is the default number of incremental recovery points between two full indexes.
+const defaultIncrementalFullEvery = 10
+
+// ErrDeltaBase is returned when the base of a delta index is not a full index.
+var ErrDeltaBase = errors.New("base of delta index is not a full index")
+
+// incrementalBackupEnabled reports whether backups make incremental recovery points, whose index only holds the
+// items changed since the latest full index.
+func incrementalBackupEnabled() bool {
+	return viper.GetBool("incremental_backup")
+}
+
+// incrementalFullEvery is the number of incremental recovery points after which a full index is made again.
+func incrementalFullEvery() int {
+	if n := viper.GetInt("incremental_full_every"); n > 0 {
+		return n
+	}
+	return defaultIncrementalFullEvery
+}
+
+// applyBase returns the full index of index, and the full index of its base when index is a delta index. The base
+// index is loaded like the index, from cache directory or the storage vault returned by getVault.
+func (s *Server) applyBase(ctx context.Context, index *cache.Index, getVault func() (storage_vault.StorageVault, error)) (full, base *cache.Index, err error) {
+	if !index.IsDelta() {
+		return index, nil, nil
+	}
+	base, err = s.readIndexFrom(ctx, &backupapi.RecoveryPointResponse{ID: index.Base, IndexHash: index.BaseHash}, getVault)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load base index of recovery point %s: %w", index.RecoveryPointID, err)
+	}
+	if base.IsDelta() {
+		return nil, nil, fmt.Errorf("%w: recovery point %s", ErrDeltaBase, index.Base)
+	}
+	return index.Apply(base), base, nil
+}
+
+// indexToSave returns the index stored for recovery point rpType: the delta of index against base, the full index
+// of recovery point baseID, for incremental recovery points, or index. A full index is made again after
+// incrementalFullEvery deltas, or when most items changed.
+func indexToSave(index *cache.Index, rpType string, base *cache.Index, baseID, baseHash string, previous int) *cache.Index {
+	if rpType != backupapi.RecoveryPointTypePoint || base == nil || previous >= incrementalFullEvery() {
+		return index
+	}
+	delta := index.Delta(base, baseID, baseHash, previous)
+	if len(delta.Items) > len(index.Items)/2 {
+		return index
+	}
+	return delta
+}
+
+// baseKept reports whether recovery point baseID is still a completed recovery point of backup server. The index of
+// a deleted base may be removed from storage vault, so a delta index is only saved against a kept base.
+func (s *Server) baseKept(baseID string) bool {
+	rp, err := s.backupClient.GetRecoveryPointInfo(baseID)
+	return err == nil && rp.Status == backupapi.RecoveryPointStatusCompleted
+}
+
+// machineRecoveryPoints returns the recovery points of all backup directories of the machine.
+func (s *Server) machineRecoveryPoints(ctx context.Context) ([]backupapi.RecoveryPointResponse, error) {
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rps []backupapi.RecoveryPointResponse
+	for _, bd := range c.BackupDirectories {
+		list, err := s.backupClient.ListRecoveryPoints(ctx, bd.ID)
+		if err != nil {
+			return nil, fmt.Errorf("list recovery points of backup directory %s: %w", bd.ID, err)
+		}
+		rps = append(rps, list.RecoveryPoints...)
+	}
+	return rps, nil
+}
+
+// deltasOf returns the IDs of recovery points of rps whose delta index depends on the index of recovery point baseID.
+func deltasOf(rps []backupapi.RecoveryPointResponse, baseID string) []string {
+	var ids []string
+	for _, rp := range rps {
+		if rp.BaseRecoveryPointID == baseID && rp.ID != baseID {
+			ids = append(ids, rp.ID)
+		}
+	}
+	return ids
+}
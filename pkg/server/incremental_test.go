@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestIndexToSave(t *testing.T) {
+	base := cache.NewIndex("bd", "rp1")
+	index := cache.NewIndex("bd", "rp2")
+	for _, path := range []string{"/a", "/b", "/c"} {
+		base.Items[path] = &cache.Node{AbsolutePath: path, Type: "file"}
+		index.Items[path] = &cache.Node{AbsolutePath: path, Type: "file"}
+	}
+	index.Items["/a"] = &cache.Node{AbsolutePath: "/a", Type: "file", Size: 1}
+
+	saved := indexToSave(index, backupapi.RecoveryPointTypePoint, base, "rp1", "hash", 0)
+	assert.True(t, saved.IsDelta())
+	assert.Len(t, saved.Items, 1)
+
+	assert.Same(t, index, indexToSave(index, backupapi.RecoveryPointTypeInitialReplica, base, "rp1", "hash", 0))
+	assert.Same(t, index, indexToSave(index, backupapi.RecoveryPointTypePoint, nil, "", "", 0))
+	assert.Same(t, index, indexToSave(index, backupapi.RecoveryPointTypePoint, base, "rp1", "hash", defaultIncrementalFullEvery), "full index after enough deltas")
+
+	viper.Set("incremental_full_every", 20)
+	defer viper.Set("incremental_full_every", 0)
+	assert.True(t, indexToSave(index, backupapi.RecoveryPointTypePoint, base, "rp1", "hash", defaultIncrementalFullEvery).IsDelta())
+
+	index.Items["/b"] = &cache.Node{AbsolutePath: "/b", Type: "file", Size: 1}
+	assert.Same(t, index, indexToSave(index, backupapi.RecoveryPointTypePoint, base, "rp1", "hash", 0), "full index when most items changed")
+}
+
+func TestPurgeDeltaBase(t *testing.T) {
+	viper.Set("cache_dir", t.TempDir())
+	defer viper.Set("cache_dir", "")
+	vaultPath := t.TempDir()
+
+	// rp1 is a full index, rp2 and rp3 are delta indexes against it
+	var mu sync.Mutex
+	rps := map[string]backupapi.RecoveryPointResponse{
+		"rp1": {ID: "rp1", Status: backupapi.RecoveryPointStatusCompleted},
+		"rp2": {ID: "rp2", Status: backupapi.RecoveryPointStatusCompleted, BaseRecoveryPointID: "rp1"},
+		"rp3": {ID: "rp3", Status: backupapi.RecoveryPointStatusCompleted, BaseRecoveryPointID: "rp1"},
+	}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/agent/config":
+			_, _ = w.Write([]byte("backup_directories:\n- id: bd1\n"))
+		case r.URL.Path == "/agent/backup-directories/bd1/recovery-points":
+			var list backupapi.ListRecoveryPointsResponse
+			for _, rp := range rps {
+				list.RecoveryPoints = append(list.RecoveryPoints, rp)
+			}
+			_ = json.NewEncoder(w).Encode(list)
+		case r.URL.Path == "/agent/storage_vaults/sv1/credential":
+			_ = json.NewEncoder(w).Encode(backupapi.StorageVault{ID: "sv1", StorageVaultType: "LOCAL", LocalPath: vaultPath})
+		case strings.HasPrefix(r.URL.Path, "/agent/recovery-points/"):
+			id := strings.TrimPrefix(r.URL.Path, "/agent/recovery-points/")
+			rp, ok := rps[id]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodDelete {
+				delete(rps, id)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(rp)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer api.Close()
+
+	client, err := backupapi.NewClient(backupapi.WithServerURL(api.URL), backupapi.WithID("machine1"))
+	require.NoError(t, err)
+	s, err := New(WithBroker(&offlineBroker{}), WithBackupClient(client))
+	require.NoError(t, err)
+	vault, err := s.NewStorageVault(backupapi.StorageVault{ID: "sv1", StorageVaultType: "LOCAL", LocalPath: vaultPath}, "", 0, 0)
+	require.NoError(t, err)
+	ctx := context.Background()
+	for _, id := range []string{"rp1", "rp2", "rp3"} {
+		for _, name := range recoveryPointObjects {
+			require.NoError(t, vault.PutObject(ctx, "machine1/"+id+"/"+name, []byte(id)))
+		}
+	}
+	exists := func(id, name string) bool {
+		ok, _, err := vault.HeadObject(ctx, "machine1/"+id+"/"+name)
+		require.NoError(t, err)
+		return ok
+	}
+	purge := func(id string) DeleteRecoveryPointResponse {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/recovery-points/%s?purge_storage=true&storage_vault_id=sv1", id), nil)
+		s.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp DeleteRecoveryPointResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	// deleting the base keeps its index for the delta indexes depending on it
+	resp := purge("rp1")
+	assert.ElementsMatch(t, []string{"rp2", "rp3"}, resp.DeltaRecoveryPoints)
+	assert.True(t, exists("rp1", "index.json"))
+	assert.False(t, exists("rp1", "chunk.json"))
+	assert.False(t, s.baseKept("rp1"), "next backups save a full index")
+
+	resp = purge("rp2")
+	assert.Empty(t, resp.DeltaRecoveryPoints)
+	assert.Contains(t, resp.RemovedObjects, "machine1/rp2/index.json")
+	assert.False(t, exists("rp2", "index.json"))
+	assert.True(t, exists("rp1", "index.json"), "rp3 still depends on the base")
+
+	// the base index is removed with the last delta index depending on it
+	resp = purge("rp3")
+	assert.Contains(t, resp.RemovedObjects, "machine1/rp1/index.json")
+	assert.False(t, exists("rp3", "index.json"))
+	assert.False(t, exists("rp1", "index.json"))
+}
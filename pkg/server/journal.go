@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/bizflycloud/bizfly-backup/pkg/usn"
+)
+
+// journalDir is the directory of change journal cursors in the cache directory of a machine.
+const journalDir = "journal"
+
+// treeReasons are the reasons of changes of a directory which change its content as a whole.
+const treeReasons = usn.ReasonFileCreate | usn.ReasonFileDelete | usn.ReasonRenameOldName | usn.ReasonRenameNewName
+
+// errJournalFilterChanged is returned when a changed file changes the filter of backup directory, all files must
+// be walked to apply it.
+var errJournalFilterChanged = errors.New("filter of backup directory changed")
+
+// journalState is the cursor of the change journal when the last full recovery point of a backup directory was
+// backed up, with the filter of its walk.
+type journalState struct {
+	Cursor          usn.Cursor `json:"cursor"`
+	RecoveryPointID string     `json:"recovery_point_id"`
+	Filter          string     `json:"filter"`
+}
+
+// journalScan finds the files of a backup directory changed since its latest recovery point by the change journal
+// of its volume, instead of walking the directory.
+type journalScan struct {
+	path   string
+	filter string
+	until  usn.Cursor
+	// changes are the changed paths with their reasons, nil when the directory must be walked.
+	changes map[string]uint32
+}
+
+func journalEnabled() bool {
+	return !viper.IsSet("usn_journal") || viper.GetBool("usn_journal")
+}
+
+// journalFilter identifies the filter of backup directory bd, items of the latest recovery point were filtered by it.
+func journalFilter(bd *backupapi.BackupDirectory, limits filter.Limits) string {
+	buf, _ := json.Marshal([]interface{}{bd.ExcludePatterns, bd.IncludePatterns, bd.OneFileSystem, bd.ExcludeCaches, bd.ExcludeNoDump, limits.MaxFileSize})
+	return string(buf)
+}
+
+// startJournalScan reads the changes of backup directory bd since latest recovery point lrp. It returns nil when
+// the change journal is disabled or not supported, or when files are skipped by age as they are excluded without
+// changing. Changes are nil when they are not known since lrp.
+func (s *Server) startJournalScan(mcID string, bd *backupapi.BackupDirectory, lrp *backupapi.RecoveryPointResponse, limits filter.Limits) *journalScan {
+	if !journalEnabled() || !limits.MinModTime.IsZero() {
+		return nil
+	}
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return nil
+	}
+	until, err := usn.Current(bd.Path)
+	if err != nil {
+		if !errors.Is(err, usn.ErrNotSupported) {
+			s.logger.Warn("Query change journal error, directory is walked", zap.Error(err), zap.String("path", bd.Path))
+		}
+		return nil
+	}
+	j := &journalScan{
+		path:   filepath.Join(cachePath, mcID, journalDir, bd.ID+".json"),
+		filter: journalFilter(bd, limits),
+		until:  until,
+	}
+	var state journalState
+	buf, err := ioutil.ReadFile(j.path)
+	if err != nil || json.Unmarshal(buf, &state) != nil {
+		return j
+	}
+	if lrp == nil || state.RecoveryPointID != lrp.ID || state.Filter != j.filter {
+		return j
+	}
+	changes, err := usn.Changes(bd.Path, state.Cursor, until)
+	if err != nil {
+		s.logger.Info("Changes since latest recovery point are not known from change journal, directory is walked", zap.Error(err), zap.String("path", bd.Path))
+		return j
+	}
+	j.changes = changes
+	return j
+}
+
+// usable reports whether the changes since the latest recovery point are known.
+func (j *journalScan) usable() bool {
+	return j != nil && j.changes != nil
+}
+
+// save keeps the cursor of the journal when the scan started for the next backup, after recovery point rpID is
+// backed up completely.
+func (j *journalScan) save(rpID string) error {
+	if j == nil {
+		return nil
+	}
+	buf, err := json.Marshal(journalState{Cursor: j.until, RecoveryPointID: rpID, Filter: j.filter})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+// journalIndex adds to index the items of latest index updated by changes, as WalkerDir would find them in dir.
+// Items of latest index which did not change are not read from the file system.
+func journalIndex(ctx context.Context, dir string, latest *cache.Index, changes map[string]uint32, index *cache.Index, f *filter.Filter, p *progress.Progress, logger *zap.Logger) (progress.Stat, int64, error) {
+	p.Start()
+	defer p.Done()
+
+	dir = filepath.Clean(dir)
+	changed := make([]string, 0, len(changes))
+	trees := make(map[string]bool)
+	// the modification time of a directory changes with its entries, which are recorded without it
+	parents := make(map[string]bool)
+	for path, reason := range changes {
+		if name := filepath.Base(path); name == filter.IgnoreFile || name == filter.CacheDirTag {
+			return progress.Stat{}, 0, errJournalFilterChanged
+		}
+		changed = append(changed, path)
+		if reason&treeReasons != 0 {
+			trees[path] = true
+		}
+		if parent := filepath.Dir(path); underPath(parent, dir) {
+			if _, ok := changes[parent]; !ok {
+				parents[parent] = true
+			}
+		}
+	}
+	reasons := make(map[string]uint32, len(changes)+len(parents))
+	for path, reason := range changes {
+		reasons[path] = reason
+	}
+	for parent := range parents {
+		reasons[parent] = 0
+		changed = append(changed, parent)
+	}
+	sort.Strings(changed)
+	// stale reports whether path changed or is under a directory whose content changed as a whole
+	stale := func(path string) bool {
+		if _, ok := reasons[path]; ok {
+			return true
+		}
+		for parent := path; parent != dir && underPath(parent, dir); parent = filepath.Dir(parent) {
+			if trees[parent] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var st progress.Stat
+	paths := make([]string, 0, len(latest.Items))
+	for path := range latest.Items {
+		paths = append(paths, path)
+	}
+	// hard links are tracked in the order of a walk
+	sort.Strings(paths)
+	for _, path := range paths {
+		if stale(path) {
+			continue
+		}
+		node := *latest.Items[path]
+		node.HardLink = ""
+		index.TrackHardLink(&node)
+		index.Items[path] = &node
+		if node.Type != "dir" {
+			index.TotalFiles++
+		}
+		s := progress.Stat{Items: 1, Bytes: node.Size}
+		p.Report(s)
+		st.Add(s)
+	}
+
+	for _, path := range changed {
+		if err := ctx.Err(); err != nil {
+			return progress.Stat{}, 0, err
+		}
+		if !underPath(path, dir) {
+			continue
+		}
+		fi, err := os.Lstat(path)
+		if err != nil {
+			// removed since
+			continue
+		}
+		if parent := filepath.Dir(path); path != dir && index.Items[parent] == nil {
+			// under an excluded directory, or walked with a new directory
+			continue
+		}
+		if last, ok := latest.Items[path]; ok && fi.IsDir() && last.Type == "dir" && reasons[path]&treeReasons == 0 {
+			// content of the directory is unchanged, or changed by its own records
+			node, err := cache.NodeFromFileInfo(dir, path, fi)
+			if err != nil {
+				return progress.Stat{}, 0, err
+			}
+			index.Items[path] = node
+			s := progress.Stat{Items: 1, Bytes: node.Size}
+			p.Report(s)
+			st.Add(s)
+			continue
+		}
+		if err := walkInto(ctx, dir, path, index, f, p, &st, logger); err != nil {
+			return progress.Stat{}, 0, err
+		}
+	}
+	return st, index.TotalFiles, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/usn"
+)
+
+func TestJournalIndex(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, path)), 0700))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, path), []byte(content), 0600))
+	}
+	write("same.txt", "same")
+	write("changed.txt", "old")
+	write("removed.txt", "removed")
+	write("old/a.txt", "a")
+	write("logs/app.log", "log")
+	f, err := filter.New([]string{"logs/"}, nil)
+	require.NoError(t, err)
+
+	latest := cache.NewIndex("bd", "rp1")
+	_, _, err = WalkerDir(context.Background(), dir, latest, f, progress.NewProgress(time.Second), zap.NewNop())
+	require.NoError(t, err)
+
+	write("changed.txt", "changed")
+	require.NoError(t, os.Remove(filepath.Join(dir, "removed.txt")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "old"), filepath.Join(dir, "new")))
+	write("new/b.txt", "b")
+	write("logs/other.log", "log")
+	changes := map[string]uint32{
+		filepath.Join(dir, "changed.txt"):       usn.ReasonDataOverwrite,
+		filepath.Join(dir, "removed.txt"):       usn.ReasonFileDelete,
+		filepath.Join(dir, "old"):               usn.ReasonRenameOldName,
+		filepath.Join(dir, "new"):               usn.ReasonRenameNewName,
+		filepath.Join(dir, "new", "b.txt"):      usn.ReasonFileCreate,
+		filepath.Join(dir, "logs", "other.log"): usn.ReasonFileCreate,
+	}
+
+	index := cache.NewIndex("bd", "rp2")
+	st, totalFiles, err := journalIndex(context.Background(), dir, latest, changes, index, f, progress.NewProgress(time.Second), zap.NewNop())
+	require.NoError(t, err)
+
+	walked := cache.NewIndex("bd", "rp2")
+	wantSt, wantFiles, err := WalkerDir(context.Background(), dir, walked, f, progress.NewProgress(time.Second), zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, wantFiles, totalFiles)
+	assert.Equal(t, wantSt.Items, st.Items)
+	assert.Equal(t, len(walked.Items), len(index.Items))
+	for path, item := range walked.Items {
+		if assert.Contains(t, index.Items, path) {
+			assert.Equal(t, item.Size, index.Items[path].Size, path)
+			assert.Equal(t, item.ModTime, index.Items[path].ModTime, path)
+		}
+	}
+	assert.NotSame(t, latest.Items[filepath.Join(dir, "same.txt")], index.Items[filepath.Join(dir, "same.txt")], "latest items are copied")
+
+	_, _, err = journalIndex(context.Background(), dir, latest, map[string]uint32{filepath.Join(dir, filter.IgnoreFile): usn.ReasonFileCreate}, cache.NewIndex("bd", "rp3"), f, progress.NewProgress(time.Second), zap.NewNop())
+	assert.ErrorIs(t, err, errJournalFilterChanged)
+}
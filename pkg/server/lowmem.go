@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultLowMemoryMb is the MiB of memory of machines below which agent runs in low-memory mode, when
+	// low_memory_mb is not set.
+	defaultLowMemoryMb = 2048
+
+	// lowMemoryGoroutines bounds the workers of each pool of agent in low-memory mode.
+	lowMemoryGoroutines = 2
+
+	// spillDir is the directory of the files items of indexes are spilled to in the cache directory of a machine.
+	spillDir = "spill"
+)
+
+// lowMemoryDefaults are the config of agent in low-memory mode, keys set in agent config keep their value.
+var lowMemoryDefaults = map[string]interface{}{
+	"num_goroutine":          lowMemoryGoroutines,
+	"max_memory_mb":          64,
+	"hash_workers":           1,
+	"upload_concurrency":     1,
+	"download_concurrency":   1,
+	"restore_chunk_workers":  1,
+	"restore_chunk_cache_mb": 8,
+}
+
+// MemoryMode is the memory mode of agent, reported by /status.
+type MemoryMode struct {
+	LowMemory bool `json:"low_memory"`
+	// TotalMemoryMb is the memory of machine, it is zero when it can not be detected.
+	TotalMemoryMb uint64 `json:"total_memory_mb,omitempty"`
+	// ThresholdMb is low_memory_mb, zero when low-memory mode is disabled.
+	ThresholdMb int `json:"threshold_mb"`
+}
+
+// NewMemoryMode returns the memory mode of machine with totalMemory bytes, zero when it is unknown. Agent runs in
+// low-memory mode when the memory of machine is below low_memory_mb, 2048 by default, 0 disables it.
+func NewMemoryMode(totalMemory uint64) MemoryMode {
+	threshold := defaultLowMemoryMb
+	if viper.IsSet("low_memory_mb") {
+		threshold = viper.GetInt("low_memory_mb")
+	}
+	mode := MemoryMode{TotalMemoryMb: totalMemory >> 20, ThresholdMb: threshold}
+	mode.LowMemory = totalMemory > 0 && threshold > 0 && mode.TotalMemoryMb < uint64(threshold)
+	return mode
+}
+
+// ApplyDefaults sets the config of low-memory mode which is not set in agent config, when mode is low-memory. It
+// must be called before the config is read, by backup client and storage vaults.
+func (m MemoryMode) ApplyDefaults() {
+	if !m.LowMemory {
+		return
+	}
+	for key, value := range lowMemoryDefaults {
+		viper.SetDefault(key, value)
+	}
+}
+
+// poolSize returns n bounded in low-memory mode.
+func (m MemoryMode) poolSize(n int) int {
+	if m.LowMemory && n > lowMemoryGoroutines {
+		return lowMemoryGoroutines
+	}
+	return n
+}
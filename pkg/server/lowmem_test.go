@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMemoryMode(t *testing.T) {
+	defer viper.Set("low_memory_mb", nil)
+	assert.True(t, NewMemoryMode(1<<30).LowMemory)
+	assert.False(t, NewMemoryMode(4<<30).LowMemory)
+	assert.False(t, NewMemoryMode(0).LowMemory, "unknown memory is not low")
+
+	viper.Set("low_memory_mb", 0)
+	assert.False(t, NewMemoryMode(1<<30).LowMemory, "disabled")
+	viper.Set("low_memory_mb", 8192)
+	mode := NewMemoryMode(4 << 30)
+	assert.Equal(t, MemoryMode{LowMemory: true, TotalMemoryMb: 4096, ThresholdMb: 8192}, mode)
+	assert.Equal(t, lowMemoryGoroutines, mode.poolSize(8))
+	assert.Equal(t, 1, mode.poolSize(1))
+	assert.Equal(t, 8, MemoryMode{}.poolSize(8))
+}
+
+func TestServerLowMemory(t *testing.T) {
+	viper.Set("max_memory_mb", 256)
+	defer viper.Set("max_memory_mb", nil)
+	mode := MemoryMode{LowMemory: true, TotalMemoryMb: 1024, ThresholdMb: defaultLowMemoryMb}
+	mode.ApplyDefaults()
+	assert.Equal(t, 256, viper.GetInt("max_memory_mb"), "config set is kept")
+	assert.Equal(t, 1, viper.GetInt("hash_workers"))
+
+	s, err := New(WithBroker(&offlineBroker{}), WithNumGoroutine(8), WithMemoryMode(mode))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	s.Status(w, httptest.NewRequest("GET", "/status", nil))
+	var status AgentStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, mode, status.Memory)
+	assert.Equal(t, lowMemoryGoroutines, status.NumGoroutine)
+}
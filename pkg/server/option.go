@@ -5,6 +5,7 @@ import (
 
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+	"github.com/bizflycloud/bizfly-backup/pkg/crash"
 )
 
 type Option func(s *Server) error
@@ -64,3 +65,19 @@ func WithNumGoroutine(num int) Option {
 		return nil
 	}
 }
+
+// WithMemoryMode returns an Option which set the memory mode of Server.
+func WithMemoryMode(mode MemoryMode) Option {
+	return func(s *Server) error {
+		s.memoryMode = mode
+		return nil
+	}
+}
+
+// WithCrashReporter returns an Option which set the crash reporter for Server.
+func WithCrashReporter(r *crash.Reporter) Option {
+	return func(s *Server) error {
+		s.crashReporter = r
+		return nil
+	}
+}
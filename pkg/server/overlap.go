@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+const (
+	// overlapSkip skips a backup of a backup directory which is being backed up, it is the default.
+	overlapSkip = "skip"
+	// overlapQueue runs a backup of a backup directory which is being backed up once the running backup is done. One
+	// backup is queued per backup directory, the next ones are skipped as it backs up the same data.
+	overlapQueue = "queue"
+
+	// eventTypeBackupSkipped notifies backup server a backup is skipped, e.g. as another one of its directory runs.
+	eventTypeBackupSkipped = "backup_skipped"
+)
+
+// ErrBackupRunning is returned when a backup of a backup directory is skipped, as another backup of it is running.
+var ErrBackupRunning = errors.New("another backup of the backup directory is running")
+
+// directoryLocks tracks the backup running for each backup directory, so backups of the same data do not overlap when
+// a backup is still running as the next one is scheduled.
+type directoryLocks struct {
+	mu      sync.Mutex
+	running map[string]*directoryLock
+}
+
+type directoryLock struct {
+	policyID string
+	queued   bool
+	done     chan struct{}
+}
+
+// lock marks a backup of policyID of backup directory bdID running, and returns the function ending it. When another
+// backup of bdID is running, lock waits for it to end if queue is true and no other backup waits already, otherwise
+// it returns ErrBackupRunning and the policy of the running backup.
+func (l *directoryLocks) lock(bdID, policyID string, queue bool) (func(), string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		running, ok := l.running[bdID]
+		if !ok {
+			break
+		}
+		if !queue || running.queued {
+			return nil, running.policyID, ErrBackupRunning
+		}
+		running.queued = true
+		queue = false
+		l.mu.Unlock()
+		<-running.done
+		l.mu.Lock()
+	}
+	if l.running == nil {
+		l.running = make(map[string]*directoryLock)
+	}
+	lock := &directoryLock{policyID: policyID, done: make(chan struct{})}
+	l.running[bdID] = lock
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.running, bdID)
+		close(lock.done)
+	}, "", nil
+}
+
+// lockDirectory marks a backup of policyID of backup directory bdID running, by overlapping_backups config. A skipped
+// backup is notified to backup server with its reason.
+func (s *Server) lockDirectory(bdID, policyID string) (func(), error) {
+	queue := viper.GetString("overlapping_backups") == overlapQueue
+	unlock, runningPolicyID, err := s.directories.lock(bdID, policyID, queue)
+	if err != nil {
+		s.notifySkipped(bdID, policyID, runningPolicyID, err)
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// notifySkipped notifies backup server a backup of policyID of backup directory bdID is skipped for reason, while a
+// backup of runningPolicyID runs when it is not empty.
+func (s *Server) notifySkipped(bdID, policyID, runningPolicyID string, reason error) {
+	s.logger.Info("Skip backup", zap.String("backup_directory_id", bdID), zap.String("policy_id", policyID),
+		zap.String("running_policy_id", runningPolicyID), zap.Error(reason))
+	msg := map[string]string{
+		"event_type":          eventTypeBackupSkipped,
+		"backup_directory_id": bdID,
+		"policy_id":           policyID,
+		"reason":              reason.Error(),
+	}
+	if runningPolicyID != "" {
+		msg["running_policy_id"] = runningPolicyID
+	}
+	s.notifyMsg(msg)
+}
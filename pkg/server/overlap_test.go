@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryLocks(t *testing.T) {
+	var l directoryLocks
+	unlock, _, err := l.lock("bd1", "p1", false)
+	require.NoError(t, err)
+
+	_, running, err := l.lock("bd1", "p2", false)
+	assert.ErrorIs(t, err, ErrBackupRunning)
+	assert.Equal(t, "p1", running)
+
+	// other directories are not locked
+	unlock2, _, err := l.lock("bd2", "p1", false)
+	require.NoError(t, err)
+	unlock2()
+
+	// a queued backup runs once the running one is done, the next ones are skipped
+	started := make(chan func())
+	go func() {
+		unlock, _, err := l.lock("bd1", "p2", true)
+		if err == nil {
+			started <- unlock
+		}
+	}()
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.running["bd1"].queued
+	}, time.Second, time.Millisecond)
+	_, _, err = l.lock("bd1", "p3", true)
+	assert.ErrorIs(t, err, ErrBackupRunning)
+
+	unlock()
+	unlockQueued := <-started
+	_, running, err = l.lock("bd1", "p3", false)
+	assert.ErrorIs(t, err, ErrBackupRunning)
+	assert.Equal(t, "p2", running)
+	unlockQueued()
+
+	unlock, _, err = l.lock("bd1", "p3", false)
+	require.NoError(t, err)
+	unlock()
+}
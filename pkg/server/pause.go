@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+// ErrActionNotPaused is returned when resuming an action which is not a paused restore.
+var ErrActionNotPaused = errors.New("action is not a paused restore")
+
+// restoreOptions are the options a restore is started with, a paused restore is resumed with them.
+type restoreOptions struct {
+	MachineID         string
+	ActionID          string
+	CreatedAt         string
+	RestoreSessionKey string
+	RecoveryPointID   string
+	DestDir           string
+	Target            *backupapi.RestoreTarget
+	StorageVaultID    string
+	PriorityPaths     []string
+	Filter            backupapi.PathFilter
+	Conflict          backupapi.ConflictPolicy
+	NoSymlinkEscape   bool
+	Owners            backupapi.OwnerMap
+	SkipXattrs        bool
+	Atomic            bool
+	Force             bool
+	LimitUpload       int
+	LimitDownload     int
+}
+
+// pausedRestore is a restore stopped as its destination can not be written.
+type pausedRestore struct {
+	opts     restoreOptions
+	restored *backupapi.RestoredItems
+	reason   error
+	pausedAt time.Time
+}
+
+// pauseRestore keeps the restore of opts to be resumed once its destination is fixed, and notifies it is paused
+// with reason.
+func (s *Server) pauseRestore(opts restoreOptions, restored *backupapi.RestoredItems, reason error) {
+	s.pausedMu.Lock()
+	s.pausedRestores[opts.ActionID] = &pausedRestore{
+		opts:     opts,
+		restored: restored,
+		reason:   reason,
+		pausedAt: time.Now(),
+	}
+	s.pausedMu.Unlock()
+	delete(s.mapActionContext, opts.ActionID)
+
+	s.logger.Warn("Restore paused, resume it once the destination is fixed",
+		zap.String("action_id", opts.ActionID), zap.Int("restored_items", restored.Len()), zap.Error(reason))
+	msg := map[string]string{
+		"action_id":      opts.ActionID,
+		"status":         statusPaused,
+		"reason":         reason.Error(),
+		"restored_items": strconv.Itoa(restored.Len()),
+	}
+	if code := errorCode(reason); code != "" {
+		msg["error_code"] = code
+	}
+	s.notifyMsg(msg)
+}
+
+// takePausedRestore removes the paused restore of actionID and returns it, or nil when the action is not paused.
+func (s *Server) takePausedRestore(actionID string) *pausedRestore {
+	s.pausedMu.Lock()
+	defer s.pausedMu.Unlock()
+	paused, ok := s.pausedRestores[actionID]
+	if !ok {
+		return nil
+	}
+	delete(s.pausedRestores, actionID)
+	return paused
+}
+
+// ResumeAction resumes a paused restore, the items it already restored are skipped.
+func (s *Server) ResumeAction(w http.ResponseWriter, r *http.Request) {
+	actionID := chi.URLParam(r, "actionID")
+	paused := s.takePausedRestore(actionID)
+	if paused == nil {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(ErrActionNotPaused.Error()))
+		return
+	}
+
+	s.logger.Info("Resume paused restore", zap.String("action_id", actionID), zap.Int("restored_items", paused.restored.Len()),
+		zap.Duration("paused", time.Since(paused.pausedAt)))
+	go func() {
+		defer s.crashReporter.Recover()
+		_ = s.restore(paused.opts, paused.restored, ioutil.Discard)
+	}()
+	_, _ = w.Write([]byte("Success"))
+}
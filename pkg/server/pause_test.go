@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+func TestServerPauseRestore(t *testing.T) {
+	ob := &offlineBroker{}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+
+	restored := backupapi.NewRestoredItems()
+	restored.Add("/data/a")
+	opts := restoreOptions{ActionID: "action1", RecoveryPointID: "rp1", DestDir: "/restore"}
+	s.pauseRestore(opts, restored, fmt.Errorf("%w: no space left on device", backupapi.ErrDestinationFull))
+
+	require.Len(t, ob.published, 1)
+	var msg map[string]string
+	require.NoError(t, json.Unmarshal([]byte(ob.published[0]), &msg))
+	assert.Equal(t, statusPaused, msg["status"])
+	assert.Equal(t, "DESTINATION_FULL", msg["error_code"])
+	assert.Equal(t, "1", msg["restored_items"])
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/actions/other/resume", nil)
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	paused := s.takePausedRestore("action1")
+	require.NotNil(t, paused)
+	assert.Equal(t, opts, paused.opts)
+	assert.Same(t, restored, paused.restored)
+	assert.Nil(t, s.takePausedRestore("action1"), "paused restore is resumed once")
+}
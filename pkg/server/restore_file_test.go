@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+func TestRequestRestoreFile(t *testing.T) {
+	requests := make(chan backupapi.CreateRestoreRequest, 1)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/agent/recovery-points/rp1/action", r.URL.Path)
+		var crr backupapi.CreateRestoreRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&crr))
+		requests <- crr
+	}))
+	defer api.Close()
+
+	client, err := backupapi.NewClient(backupapi.WithServerURL(api.URL), backupapi.WithID("machine1"))
+	require.NoError(t, err)
+	s, err := New(WithBroker(&offlineBroker{}), WithBackupClient(client))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/recovery-points/rp1/restore-file", strings.NewReader(`{"dest_directory": "/tmp/restore"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "path is required")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/recovery-points/rp1/restore-file",
+		strings.NewReader(`{"source_machine_id": "machine2", "path": "/etc/nginx/nginx.conf", "dest_directory": "/tmp/restore"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	crr := <-requests
+	assert.Equal(t, "machine1", crr.MachineID)
+	assert.Equal(t, "machine2", crr.SourceMachineID)
+	assert.Equal(t, "/tmp/restore", crr.Path)
+	assert.Equal(t, "/etc/nginx/nginx.conf", crr.File)
+	assert.Empty(t, crr.Include)
+	assert.Nil(t, crr.LimitDownload, "limit_download of agent is kept")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/recovery-points/rp1/restore-file",
+		strings.NewReader(`{"path": "/etc/nginx/nginx.conf", "dest_directory": "/tmp/restore", "limit_download": -1}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/recovery-points/rp1/restore-file",
+		strings.NewReader(`{"path": "/etc/nginx/nginx.conf", "dest_directory": "/tmp/restore", "limit_download": 0}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	crr = <-requests
+	require.NotNil(t, crr.LimitDownload)
+	assert.Equal(t, 0, *crr.LimitDownload)
+}
+
+func TestRestoreDownloadLimit(t *testing.T) {
+	assert.Equal(t, 512, restoreDownloadLimit(512))
+	assert.Equal(t, -1, restoreDownloadLimit(0), "unlimited restores do not fall back to limit_download")
+}
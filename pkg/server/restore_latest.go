@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+// ErrNoCompletedRecoveryPoint is returned when a backup directory has no completed recovery point to restore.
+var ErrNoCompletedRecoveryPoint = errors.New("no completed recovery point")
+
+// RequestRestoreLatest requests a restore of the most recent completed recovery point of backup directory backupID,
+// with the body of RequestRestore, a dry run with dry_run=true. The ID of the recovery point is returned in the
+// X-Recovery-Point-Id header.
+func (s *Server) RequestRestoreLatest(w http.ResponseWriter, r *http.Request) {
+	backupDirectoryID := chi.URLParam(r, "backupID")
+	rp, err := s.latestCompletedRecoveryPoint(r.Context(), backupDirectoryID)
+	if err != nil {
+		s.logger.Error("Get latest completed recovery point error", zap.Error(err), zap.String("backup_directory_id", backupDirectoryID))
+		if errors.Is(err, ErrNoCompletedRecoveryPoint) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	s.logger.Sugar().Infof("Restore latest recovery point %s of backup directory %s", rp.ID, backupDirectoryID)
+	w.Header().Set("X-Recovery-Point-Id", rp.ID)
+	chi.RouteContext(r.Context()).URLParams.Add("recoveryPointID", rp.ID)
+	s.RequestRestore(w, r)
+}
+
+// latestCompletedRecoveryPoint returns the most recent completed recovery point of backup directory
+// backupDirectoryID holding the whole directory. The latest recovery point is the one of backup server unless it is
+// still running, failed, partial or a stream, then the most recent completed one is looked up in the recovery points
+// of backup directory.
+func (s *Server) latestCompletedRecoveryPoint(ctx context.Context, backupDirectoryID string) (*backupapi.RecoveryPointResponse, error) {
+	lrp, err := s.backupClient.GetLatestRecoveryPointID(backupDirectoryID)
+	if err != nil {
+		return nil, err
+	}
+	if lrp.ID != "" && wholeDirectory(lrp) {
+		return lrp, nil
+	}
+	rps, err := s.backupClient.ListRecoveryPoints(ctx, backupDirectoryID)
+	if err != nil {
+		return nil, err
+	}
+	var latest *backupapi.RecoveryPointResponse
+	var latestAt time.Time
+	for i, rp := range rps.RecoveryPoints {
+		if !wholeDirectory(&rp) {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, rp.CreatedAt)
+		if err != nil {
+			continue
+		}
+		if latest == nil || createdAt.After(latestAt) {
+			latest, latestAt = &rps.RecoveryPoints[i], createdAt
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w of backup directory %s", ErrNoCompletedRecoveryPoint, backupDirectoryID)
+	}
+	return latest, nil
+}
+
+// wholeDirectory reports whether rp is a completed recovery point of all files of its backup directory, a partial
+// recovery point holds some paths only and a stream recovery point a single stream.
+func wholeDirectory(rp *backupapi.RecoveryPointResponse) bool {
+	return rp.Status == backupapi.RecoveryPointStatusCompleted &&
+		rp.RecoveryPointType != backupapi.RecoveryPointTypePartial && rp.RecoveryPointType != backupapi.RecoveryPointTypeStream
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+)
+
+func TestRequestRestoreLatest(t *testing.T) {
+	requests := make(chan string, 1)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent/backup-directories/bd1/latest-recovery-points":
+			// the latest recovery point is still running
+			_, _ = w.Write([]byte(`{"id": "rp3", "status": "CREATED", "created_at": "2022-06-03T01:00:00Z"}`))
+		case "/agent/backup-directories/bd1/recovery-points":
+			_, _ = w.Write([]byte(`{"recovery_points": [
+				{"id": "rp1", "status": "COMPLETED", "created_at": "2022-06-01T01:00:00Z"},
+				{"id": "rp3", "status": "CREATED", "created_at": "2022-06-03T01:00:00Z"},
+				{"id": "rp2", "status": "COMPLETED", "created_at": "2022-06-02T01:00:00Z"},
+				{"id": "rp0", "status": "FAILED", "created_at": "2022-06-02T12:00:00Z"}]}`))
+		case "/agent/backup-directories/bd2/latest-recovery-points":
+			_, _ = w.Write([]byte(`{"id": ""}`))
+		case "/agent/backup-directories/bd2/recovery-points":
+			_, _ = w.Write([]byte(`{"recovery_points": []}`))
+		default:
+			var crr backupapi.CreateRestoreRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&crr))
+			assert.Equal(t, "/srv/restore", crr.Path)
+			requests <- r.URL.Path
+		}
+	}))
+	defer api.Close()
+
+	client, err := backupapi.NewClient(backupapi.WithServerURL(api.URL), backupapi.WithID("machine1"))
+	require.NoError(t, err)
+	s, err := New(WithBroker(&offlineBroker{}), WithBackupClient(client))
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/backups/bd1/restore-latest", strings.NewReader(`{"path": "/srv/restore"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "rp2", w.Header().Get("X-Recovery-Point-Id"))
+	assert.Equal(t, "/agent/recovery-points/rp2/action", <-requests)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/backups/bd2/restore-latest", strings.NewReader(`{"path": "/srv/restore"}`))
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLatestCompletedRecoveryPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		latest  string
+		list    string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "latest completed",
+			latest: `{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "RECOVERY_POINT"}`,
+			want:   "rp2",
+		},
+		{
+			name:   "latest partial",
+			latest: `{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "PARTIAL"}`,
+			list: `[{"id": "rp1", "status": "COMPLETED", "recovery_point_type": "INITIAL_REPLICA", "created_at": "2022-06-01T01:00:00Z"},
+				{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "PARTIAL", "created_at": "2022-06-02T01:00:00Z"}]`,
+			want: "rp1",
+		},
+		{
+			name:   "latest stream",
+			latest: `{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "STREAM"}`,
+			list: `[{"id": "rp1", "status": "COMPLETED", "recovery_point_type": "RECOVERY_POINT", "created_at": "2022-06-01T01:00:00Z"},
+				{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "STREAM", "created_at": "2022-06-02T01:00:00Z"}]`,
+			want: "rp1",
+		},
+		{
+			name:   "partial and stream only",
+			latest: `{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "STREAM"}`,
+			list: `[{"id": "rp1", "status": "COMPLETED", "recovery_point_type": "PARTIAL", "created_at": "2022-06-01T01:00:00Z"},
+				{"id": "rp2", "status": "COMPLETED", "recovery_point_type": "STREAM", "created_at": "2022-06-02T01:00:00Z"}]`,
+			wantErr: ErrNoCompletedRecoveryPoint,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/agent/backup-directories/bd1/latest-recovery-points":
+					_, _ = w.Write([]byte(tt.latest))
+				case "/agent/backup-directories/bd1/recovery-points":
+					_, _ = w.Write([]byte(`{"recovery_points": ` + tt.list + `}`))
+				default:
+					t.Errorf("unexpected request %s", r.URL.Path)
+				}
+			}))
+			defer api.Close()
+			client, err := backupapi.NewClient(backupapi.WithServerURL(api.URL), backupapi.WithID("machine1"))
+			require.NoError(t, err)
+			s, err := New(WithBroker(&offlineBroker{}), WithBackupClient(client))
+			require.NoError(t, err)
+
+			rp, err := s.latestCompletedRecoveryPoint(context.Background(), "bd1")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rp.ID)
+		})
+	}
+}
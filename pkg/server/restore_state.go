@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+// restoreStateKey identifies restores of a recovery point which may resume each other's restore state, the ones to
+// the same destination with the same path filter, and both atomic or not as they write to different directories.
+func restoreStateKey(destDir string, target *backupapi.RestoreTarget, filter backupapi.PathFilter, atomic bool) string {
+	parts := []string{filepath.Clean(destDir)}
+	if target != nil {
+		parts = []string{target.Credential.AwsLocation, target.Bucket, target.Prefix}
+	}
+	parts = append(parts, filter.File)
+	parts = append(parts, filter.Include...)
+	parts = append(parts, "")
+	parts = append(parts, filter.Exclude...)
+	if atomic {
+		parts = append(parts, "", "atomic")
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// resumeRestoreState returns the items restored by an interrupted restore of key, saved in repo, or nil when there
+// is none.
+func (s *Server) resumeRestoreState(repo *cache.Repository, key string) *backupapi.RestoredItems {
+	state, err := repo.RestoreState()
+	if err != nil {
+		s.logger.Warn("failed to read restore state of interrupted restore", zap.Error(err))
+		return nil
+	}
+	if state == nil || state.Key != key {
+		return nil
+	}
+	if time.Since(state.SavedAt) > checkpointMaxAge {
+		s.logger.Info("Discard restore state of interrupted restore, it is too old", zap.String("action_id", state.ActionID))
+		return nil
+	}
+	restored := backupapi.NewRestoredItems()
+	if err := json.Unmarshal(state.Restored, restored); err != nil {
+		s.logger.Warn("failed to decode restore state of interrupted restore", zap.Error(err))
+		return nil
+	}
+	s.logger.Info("Resume interrupted restore", zap.String("action_id", state.ActionID), zap.Int("restored_items", restored.Len()))
+	return restored
+}
+
+// startRestoreState saves restored to repo now and every checkpointInterval. The returned function stops saving, it
+// removes the restore state when the restore is done and keeps it otherwise, so the next restore of key resumes it.
+func (s *Server) startRestoreState(repo *cache.Repository, key, actionID, rpID string, restored *backupapi.RestoredItems) func(done bool) {
+	save := func() {
+		buf, err := json.Marshal(restored)
+		if err == nil {
+			err = repo.SaveRestoreState(&cache.RestoreState{Key: key, ActionID: actionID, RecoveryPointID: rpID, Restored: buf})
+		}
+		if err != nil {
+			s.logger.Warn("failed to save restore state", zap.Error(err))
+		}
+	}
+	save()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				save()
+			}
+		}
+	}()
+	return func(done bool) {
+		cancel()
+		<-stopped
+		if !done {
+			save()
+			return
+		}
+		if err := repo.RemoveRestoreState(); err != nil {
+			s.logger.Warn("failed to remove restore state", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestRestoreState(t *testing.T) {
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+	repo, err := cache.NewRepository(t.TempDir(), "machine1", "rp1")
+	require.NoError(t, err)
+
+	key := restoreStateKey("/restore/", nil, backupapi.PathFilter{Include: []string{"/etc"}}, false)
+	assert.Equal(t, key, restoreStateKey("/restore", nil, backupapi.PathFilter{Include: []string{"/etc"}}, false))
+	assert.NotEqual(t, key, restoreStateKey("/restore", nil, backupapi.PathFilter{Exclude: []string{"/etc"}}, false))
+	assert.NotEqual(t, key, restoreStateKey("/restore", nil, backupapi.PathFilter{Include: []string{"/etc"}}, true),
+		"an atomic restore does not resume one which is not")
+	assert.NotEqual(t, key, restoreStateKey("/restore", &backupapi.RestoreTarget{Bucket: "restore"}, backupapi.PathFilter{Include: []string{"/etc"}}, false))
+	assert.Nil(t, s.resumeRestoreState(repo, key))
+
+	restored := backupapi.NewRestoredItems()
+	restored.Add("/etc/hosts")
+	stop := s.startRestoreState(repo, key, "action1", "rp1", restored)
+	restored.AddChunk("/etc/passwd", 0)
+	stop(false)
+
+	resumed := s.resumeRestoreState(repo, key)
+	require.NotNil(t, resumed)
+	assert.True(t, resumed.Has("/etc/hosts"))
+	assert.True(t, resumed.HasChunk("/etc/passwd", 0), "state is saved when the restore stops")
+	assert.Nil(t, s.resumeRestoreState(repo, restoreStateKey("/other", nil, backupapi.PathFilter{}, false)))
+
+	stop = s.startRestoreState(repo, key, "action2", "rp1", resumed)
+	stop(true)
+	assert.Nil(t, s.resumeRestoreState(repo, key), "state is removed when the restore is done")
+}
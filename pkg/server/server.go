@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -15,15 +14,18 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"golang.org/x/mod/semver"
 
@@ -38,20 +40,42 @@ import (
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 	"github.com/bizflycloud/bizfly-backup/pkg/broker"
 	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/crash"
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
+	"github.com/bizflycloud/bizfly-backup/pkg/instance"
+	"github.com/bizflycloud/bizfly-backup/pkg/inventory"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/manifest"
 	"github.com/bizflycloud/bizfly-backup/pkg/progress"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault/local"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault/s3"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault/swift"
 	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
 )
 
 var Version = "dev"
 
+// ErrAutoUpgradeDisabled is returned when upgrading agent while auto_upgrade is disabled.
+var ErrAutoUpgradeDisabled = errors.New("auto upgrade is disabled, upgrade agent by the OS package manager")
+
+// ErrIndexNotCached is returned when index of a recovery point is not in cache and no storage vault is given to download it.
+var ErrIndexNotCached = errors.New("index of recovery point is not cached, storage_vault_id is required to download it")
+
+// schemaVersion is the broker message schema version published in string map messages.
+var schemaVersion = strconv.Itoa(broker.SchemaVersion)
+
 const (
 	statusPendingFile = "PENDING"
 	statusUploadFile  = "UPLOADING"
 	statusComplete    = "COMPLETED"
 	statusDownloading = "DOWNLOADING"
 	statusFailed      = "FAILED"
+	// statusRetrieving is the status of restores waiting for archived chunks to be retrieved.
+	statusRetrieving = "RETRIEVING"
+	// statusPaused is the status of restores paused as their destination is full or read-only.
+	statusPaused = "PAUSED"
 )
 
 const (
@@ -66,15 +90,36 @@ const (
 	maxCacheAgeDefault = 24 * time.Hour * 30
 )
 
+const (
+	uploadBudgetFile = "upload_budget.json"
+)
+
+const (
+	// failureLogWindow is the time before failure of the log uploaded when the start of action is unknown.
+	failureLogWindow = 10 * time.Minute
+	// failureLogMargin is the time before start of action included in the log uploaded on failure.
+	failureLogMargin = time.Minute
+	// failureLogMaxSize bounds the log uploaded on failure, only its latest lines are kept.
+	failureLogMaxSize = 1 << 20
+)
+
 const (
 	intervalTimeCheckUpgrade     = 86400 * time.Second
 	intervalTimeCheckTaskRunning = 50 * time.Second
 	intervalPushProgress         = 20 * time.Second
+	timeoutProbeStorageVault     = 2 * time.Minute
+	intervalCheckRetrieval       = 15 * time.Minute
+)
+
+const (
+	// archiveRetrievalDays is how long retrieved copies of archived chunks stay readable.
+	archiveRetrievalDays = 3
 )
 
 type contextStruct struct {
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	startedAt time.Time
 }
 
 // Server defines parameters for running BizFly Backup HTTP server.
@@ -91,6 +136,8 @@ type Server struct {
 	mu                   sync.Mutex
 	cronManager          *cron.Cron
 	mappingToCronEntryID map[string]cron.EntryID
+	// cronEntryConfigs keeps config of each cron entry, refresh compares it to find changed entries.
+	cronEntryConfigs map[string]string
 
 	// signal chan use for testing.
 	testSignalCh chan os.Signal
@@ -99,14 +146,63 @@ type Server struct {
 	poolDir   *ants.Pool
 	pool      *ants.Pool
 	chunkPool *ants.Pool
+	// fileFair and chunkFair share pool and chunkPool between backups running at once.
+	fileFair  *limiter.FairPool
+	chunkFair *limiter.FairPool
 
 	// Num goroutine
 	numGoroutine int
+	// memoryMode bounds pools and the memory of backups in low-memory mode.
+	memoryMode MemoryMode
 
 	logger *zap.Logger
 
 	// map contains context of running worker
 	mapActionContext map[string]contextStruct
+
+	// uploadBudget limits bytes uploaded per day by initial replica backups.
+	uploadBudget *limiter.DailyBudget
+	// budgetMu guards budgetResumes.
+	budgetMu sync.Mutex
+	// budgetResumes are the backups stopped by uploadBudget waiting for it to renew, by checkpoint key.
+	budgetResumes map[string]*time.Timer
+	// diskLimiter paces files read by backups whose policy has no disk limits.
+	diskLimiter *limiter.DiskLimiter
+
+	// crashReporter writes crash report to cache directory when agent panics.
+	crashReporter *crash.Reporter
+
+	// stateMu guards cronLastRuns, pendingMessages and the state restored after restart.
+	stateMu sync.Mutex
+	// cronLastRuns keeps the last run of each cron entry, by its mapping ID.
+	cronLastRuns map[string]time.Time
+	// pendingMessages are the notifications failed to publish, in order.
+	pendingMessages []pendingMessage
+	// restarted is the state saved before agent restarted, until it is resumed once broker is connected.
+	restarted *agentState
+	// restartedAt is when agent restarted to upgrade, cron entries due since then are caught up.
+	restartedAt time.Time
+
+	// pausedMu guards pausedRestores.
+	pausedMu sync.Mutex
+	// pausedRestores are the restores paused as their destination can not be written, by action ID.
+	pausedRestores map[string]*pausedRestore
+
+	// digest batches non-critical notifications.
+	digest *notificationDigest
+
+	// watchMu guards watchers.
+	watchMu sync.Mutex
+	// watchers are the backup directories watched for changes, by backup directory ID.
+	watchers map[string]*watchedDirectory
+
+	// feed publishes schedule and progress of actions to local clients.
+	feed *progressFeed
+
+	// backups bounds the backups running at once, the others are queued.
+	backups actionQueue
+	// directories tracks the backup running for each backup directory.
+	directories directoryLocks
 }
 
 // New creates new server instance.
@@ -119,11 +215,15 @@ func New(opts ...Option) (*Server, error) {
 	}
 
 	s.router = chi.NewRouter()
-	s.cronManager = cron.New(cron.WithParser(cron.NewParser(
-		cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)))
+	s.cronManager = newCronManager()
 	s.cronManager.Start()
 	s.mappingToCronEntryID = make(map[string]cron.EntryID)
+	s.cronEntryConfigs = make(map[string]string)
 	s.mapActionContext = make(map[string]contextStruct)
+	s.cronLastRuns = make(map[string]time.Time)
+	s.pausedRestores = make(map[string]*pausedRestore)
+	s.watchers = make(map[string]*watchedDirectory)
+	s.diskLimiter = newDiskLimiter()
 
 	if s.logger == nil {
 		l, err := backupapi.WriteLog()
@@ -132,6 +232,12 @@ func New(opts ...Option) (*Server, error) {
 		}
 		s.logger = l
 	}
+	s.digest = newNotificationDigest(s.publish, s.logger)
+	s.feed = newProgressFeed(s.feedSchedule, s.logger)
+
+	if err := s.loadState(); err != nil {
+		s.logger.Warn("failed to load agent state saved before restart", zap.Error(err))
+	}
 
 	s.setupRoutes()
 
@@ -141,6 +247,7 @@ func New(opts ...Option) (*Server, error) {
 			s.numGoroutine = 2
 		}
 	}
+	s.numGoroutine = s.memoryMode.poolSize(s.numGoroutine)
 
 	s.useUnixSock = strings.HasPrefix(s.Addr, "unix://")
 	trimPrefix := "unix://"
@@ -150,22 +257,37 @@ func New(opts ...Option) (*Server, error) {
 	s.Addr = strings.TrimPrefix(s.Addr, trimPrefix)
 
 	var err error
-	s.poolDir, err = ants.NewPool(s.numGoroutine)
+	panicHandler := ants.WithPanicHandler(s.handlePanic)
+	s.poolDir, err = ants.NewPool(s.numGoroutine, panicHandler)
 	if err != nil {
 		s.logger.Error("err ", zap.Error(err))
 		return nil, err
 	}
 
-	s.pool, err = ants.NewPool(s.numGoroutine)
+	s.pool, err = ants.NewPool(s.numGoroutine, panicHandler)
 	if err != nil {
 		s.logger.Error("err ", zap.Error(err))
 		return nil, err
 	}
-	s.chunkPool, err = ants.NewPool(s.numGoroutine)
+	s.chunkPool, err = ants.NewPool(s.numGoroutine, panicHandler)
 	if err != nil {
 		s.logger.Error("err ", zap.Error(err))
 		return nil, err
 	}
+	s.fileFair = limiter.NewFairPool(s.pool)
+	s.chunkFair = limiter.NewFairPool(s.chunkPool)
+
+	if limitGB := viper.GetFloat64("daily_upload_limit_gb"); limitGB > 0 {
+		_, cachePath, err := support.CheckPath()
+		if err != nil {
+			return nil, err
+		}
+		s.uploadBudget, err = limiter.NewDailyBudget(int64(limitGB*(1<<30)), filepath.Join(cachePath, uploadBudgetFile))
+		if err != nil {
+			s.logger.Error("err ", zap.Error(err))
+			return nil, err
+		}
+	}
 	return s, nil
 }
 
@@ -175,11 +297,23 @@ func (s *Server) setupRoutes() {
 		r.Post("/", s.RequestBackup)
 		r.Get("/{backupID}/recovery-points", s.ListRecoveryPoints)
 		r.Post("/sync", s.SyncConfig)
+		r.Post("/{backupID}/stream", s.BackupStream)
+		r.Post("/{backupID}/restore-latest", s.RequestRestoreLatest)
 	})
 
 	s.router.Route("/recovery-points", func(r chi.Router) {
 		r.Delete("/{recoveryPointID}", s.DeleteRecoveryPoints)
 		r.Post("/{recoveryPointID}/restore", s.RequestRestore)
+		r.Post("/{recoveryPointID}/restore-file", s.RequestRestoreFile)
+		r.Get("/{recoveryPointID}/download", s.DownloadRecoveryPoint)
+		r.Get("/{recoveryPointID}/manifest", s.ExportManifest)
+		r.Get("/{recoveryPointID}/files", s.ListFiles)
+		r.Get("/{recoveryPointID}/inventory", s.GetInventory)
+		r.Get("/{recoveryPointID}/instance-metadata", s.GetInstanceMetadata)
+	})
+
+	s.router.Route("/storage-vaults", func(r chi.Router) {
+		r.Post("/test", s.TestStorageVault)
 	})
 
 	s.router.Route("/upgrade", func(r chi.Router) {
@@ -188,27 +322,30 @@ func (s *Server) setupRoutes() {
 	s.router.Route("/version", func(r chi.Router) {
 		r.Post("/", s.Version)
 	})
+	s.router.Get("/status", s.Status)
 	s.router.Route("/actions", func(r chi.Router) {
 		r.Get("/", s.ListAction)
 		r.Delete("/{actionID}", s.StopAction)
+		r.Post("/{actionID}/resume", s.ResumeAction)
+		r.Get("/{actionID}/events", s.ActionEvents)
 	})
 }
 
 func (s *Server) ListAction(w http.ResponseWriter, r *http.Request) {
-	c, err := s.backupClient.ListActivity(r.Context(), s.backupClient.Id, []string{statusDownloading, statusUploadFile})
+	c, err := s.backupClient.ListActivity(r.Context(), s.backupClient.Id, []string{statusDownloading, statusUploadFile, statusPaused})
 	if err != nil {
 		s.logger.Error("err ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
-	_ = json.NewEncoder(w).Encode(c)
+	_ = json.NewEncoder(w).Encode(ListActionResponse{ListActivity: *c, Queued: s.backups.queued()})
 }
 
 func (s *Server) StopAction(w http.ResponseWriter, r *http.Request) {
 	actionID := chi.URLParam(r, "actionID")
 
-	msg := map[string]string{"event_type": broker.StopAction, "action_id": actionID}
+	msg := map[string]string{"schema_version": schemaVersion, "event_type": broker.StopAction, "action_id": actionID}
 	payload, _ := json.Marshal(msg)
 	err := s.b.Publish("agent/"+s.backupClient.Id, payload)
 	if err != nil {
@@ -225,8 +362,9 @@ func (s *Server) handleBrokerEvent(e broker.Event) error {
 	defer s.mu.Unlock()
 	limitUpload := viper.GetInt("limit_upload")
 	limitDownload := viper.GetInt("limit_download")
-	var msg broker.Message
-	if err := json.Unmarshal(e.Payload, &msg); err != nil {
+	msg, err := broker.DecodeMessage(e.Payload)
+	if err != nil {
+		s.logger.Warn("Drop broker event", zap.Error(err), zap.String("topic", e.Topic))
 		return err
 	}
 	s.logger.Debug("Got broker event", zap.String("event_type", msg.EventType))
@@ -235,19 +373,48 @@ func (s *Server) handleBrokerEvent(e broker.Event) error {
 		limitDownload = 0
 		var err error
 		go func() {
-			err = s.backup(msg.BackupDirectoryID, msg.PolicyID, msg.Name, limitUpload, limitDownload, backupapi.RecoveryPointTypeInitialReplica, ioutil.Discard)
+			defer s.crashReporter.Recover()
+			err = s.backup(msg.BackupDirectoryID, msg.PolicyID, msg.Name, limitUpload, limitDownload, backupapi.RecoveryPointTypeInitialReplica, msg.ChangeDetection, msg.OnlyPaths, ioutil.Discard)
 		}()
 		return err
 	case broker.RestoreManual:
-		limitUpload = 0
+		if msg.RestoreTarget == nil {
+			// restores to a target upload the files
+			limitUpload = 0
+		}
+		if msg.LimitDownload != nil {
+			limitDownload = restoreDownloadLimit(*msg.LimitDownload)
+		}
 		var err error
 		go func() {
-			err = s.restore(msg.MachineID, msg.ActionId, msg.CreatedAt, msg.RestoreSessionKey, msg.RecoveryPointID, msg.DestinationDirectory, msg.StorageVaultId, limitUpload, limitDownload, ioutil.Discard)
+			defer s.crashReporter.Recover()
+			err = s.restore(restoreOptions{
+				MachineID:         msg.RestoreMachineID(),
+				ActionID:          msg.ActionId,
+				CreatedAt:         msg.CreatedAt,
+				RestoreSessionKey: msg.RestoreSessionKey,
+				RecoveryPointID:   msg.RecoveryPointID,
+				DestDir:           msg.DestinationDirectory,
+				Target:            msg.RestoreTarget,
+				StorageVaultID:    msg.StorageVaultId,
+				PriorityPaths:     msg.PriorityPaths,
+				Filter:            msg.PathFilter,
+				Conflict:          msg.Conflict,
+				NoSymlinkEscape:   msg.NoSymlinkEscape,
+				Owners:            msg.OwnerMap,
+				SkipXattrs:        msg.SkipXattrs,
+				Atomic:            msg.Atomic,
+				Force:             msg.Force,
+				LimitUpload:       limitUpload,
+				LimitDownload:     limitDownload,
+			}, nil, ioutil.Discard)
 		}()
 		return err
 	case broker.ConfigUpdate:
+		s.backupClient.InvalidateCache()
 		return s.handleConfigUpdate(msg)
 	case broker.ConfigRefresh:
+		s.backupClient.InvalidateCache()
 		return s.handleConfigRefresh(msg.BackupDirectories)
 	case broker.AgentUpgrade:
 	case broker.StatusNotify:
@@ -263,7 +430,10 @@ func (s *Server) handleBrokerEvent(e broker.Event) error {
 		if actionContext, ok := s.mapActionContext[msg.ActionId]; ok {
 			actionContext.cancel()
 		}
-		s.notifyStatusFailed(msg.ActionId, backupapi.ErrorGotCancelRequest.Error())
+		s.takePausedRestore(msg.ActionId)
+		s.notifyStatusFailed(msg.ActionId, backupapi.ErrorGotCancelRequest)
+	case broker.WatchDirectory:
+		return s.handleWatchDirectory(msg)
 	default:
 		s.logger.Debug("Got unknown event", zap.Any("message", msg))
 	}
@@ -282,16 +452,32 @@ func (s *Server) handleConfigUpdate(config broker.Message) error {
 		broker.ConfigUpdateActionDeactiveDirectory,
 		broker.ConfigUpdateActionDelDirectory:
 		s.removeFromCronManager(config.BackupDirectories)
+		if config.Action != broker.ConfigUpdateActionDelPolicy {
+			for _, bd := range config.BackupDirectories {
+				s.stopWatch(bd.ID)
+			}
+		}
 
 	case broker.UpdateNumGoroutine:
 		if config.NumGoroutine == 0 {
 			return nil
 		}
-		s.logger.Sugar().Debugf("handleConfigUpdate: updating num_goroutine to %d", config.NumGoroutine)
-		viper.Set("num_goroutine", config.NumGoroutine)
-		s.chunkPool.Tune(config.NumGoroutine)
-		s.pool.Tune(config.NumGoroutine)
-		s.poolDir.Tune(config.NumGoroutine)
+		numGoroutine := s.memoryMode.poolSize(config.NumGoroutine)
+		s.logger.Sugar().Debugf("handleConfigUpdate: updating num_goroutine to %d", numGoroutine)
+		viper.Set("num_goroutine", numGoroutine)
+		s.chunkPool.Tune(numGoroutine)
+		s.pool.Tune(numGoroutine)
+		s.poolDir.Tune(numGoroutine)
+
+	case broker.ConfigUpdateActionAutoUpgrade:
+		if config.AutoUpgrade == nil {
+			return nil
+		}
+		s.logger.Sugar().Infof("handleConfigUpdate: updating auto_upgrade to %t", *config.AutoUpgrade)
+		viper.Set("auto_upgrade", *config.AutoUpgrade)
+
+	case broker.ConfigUpdateActionDiskLimit:
+		s.updateDiskLimit(config)
 
 	default:
 		return fmt.Errorf("unhandled action: %s", config.Action)
@@ -299,18 +485,66 @@ func (s *Server) handleConfigUpdate(config broker.Message) error {
 	return nil
 }
 
+// newCronManager creates the cron manager parsing standard schedule patterns and descriptors like @daily.
+// cronParser parses schedule patterns of backup policies.
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+func newCronManager() *cron.Cron {
+	return cron.New(cron.WithParser(cronParser))
+}
+
+// handleConfigRefresh syncs cron entries with backupDirectories. Only entries of removed or changed policies are
+// removed and only new or changed ones are added, unchanged entries keep their schedule and running jobs.
 func (s *Server) handleConfigRefresh(backupDirectories []backupapi.BackupDirectoryConfig) error {
-	ctx := s.cronManager.Stop()
-	<-ctx.Done()
-	s.cronManager = cron.New()
-	s.cronManager.Start()
-	s.mappingToCronEntryID = make(map[string]cron.EntryID)
-	s.addToCronManager(backupDirectories)
+	desired := make(map[string]string)
+	for _, bd := range backupDirectories {
+		if !bd.Activated {
+			continue
+		}
+		for _, policy := range bd.Policies {
+			desired[mappingID(bd.ID, policy.ID)] = cronEntryConfig(bd, policy)
+		}
+	}
+
+	for id, entryID := range s.mappingToCronEntryID {
+		if config, ok := desired[id]; ok && config == s.cronEntryConfigs[id] {
+			continue
+		}
+		s.cronManager.Remove(entryID)
+		delete(s.mappingToCronEntryID, id)
+		delete(s.cronEntryConfigs, id)
+	}
+
+	added := make([]backupapi.BackupDirectoryConfig, 0, len(backupDirectories))
+	for _, bd := range backupDirectories {
+		policies := make([]backupapi.BackupDirectoryConfigPolicy, 0, len(bd.Policies))
+		for _, policy := range bd.Policies {
+			if _, ok := s.mappingToCronEntryID[mappingID(bd.ID, policy.ID)]; !ok {
+				policies = append(policies, policy)
+			}
+		}
+		bd.Policies = policies
+		added = append(added, bd)
+	}
+	s.addToCronManager(added)
 	return nil
 }
 
+// mappingIDSeparator separates backup directory ID and policy ID in keys of cron entries.
+const mappingIDSeparator = "|"
+
 func mappingID(backupDirectoryID, policyID string) string {
-	return backupDirectoryID + "|" + policyID
+	return backupDirectoryID + mappingIDSeparator + policyID
+}
+
+// cronEntryConfig returns the config which a cron entry of policy depends on.
+func cronEntryConfig(bd backupapi.BackupDirectoryConfig, policy backupapi.BackupDirectoryConfigPolicy) string {
+	buf, _ := json.Marshal(struct {
+		Path   string                                `json:"path"`
+		Paths  []string                              `json:"paths,omitempty"`
+		Policy backupapi.BackupDirectoryConfigPolicy `json:"policy"`
+	}{bd.Path, bd.Paths, policy})
+	return string(buf)
 }
 
 func (s *Server) removeFromCronManager(bdc []backupapi.BackupDirectoryConfig) {
@@ -320,9 +554,39 @@ func (s *Server) removeFromCronManager(bdc []backupapi.BackupDirectoryConfig) {
 			if entryID, ok := s.mappingToCronEntryID[mappingID]; ok {
 				s.cronManager.Remove(entryID)
 				delete(s.mappingToCronEntryID, mappingID)
+				delete(s.cronEntryConfigs, mappingID)
 			}
 		}
 	}
+	s.feed.scheduleChanged()
+}
+
+// removeDirectoryFromCron removes cron entries of all policies of backup directory backupDirectoryID.
+func (s *Server) removeDirectoryFromCron(backupDirectoryID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entryID := range s.mappingToCronEntryID {
+		if !strings.HasPrefix(id, backupDirectoryID+mappingIDSeparator) {
+			continue
+		}
+		s.cronManager.Remove(entryID)
+		delete(s.mappingToCronEntryID, id)
+		delete(s.cronEntryConfigs, id)
+	}
+	s.feed.scheduleChanged()
+}
+
+// scheduledBackup is the cron job of a policy of backup directory.
+type scheduledBackup struct {
+	backupDirectoryID string
+	policyID          string
+	path              string
+	pattern           string
+	run               func()
+}
+
+func (j scheduledBackup) Run() {
+	j.run()
 }
 
 func (s *Server) addToCronManager(bdc []backupapi.BackupDirectoryConfig) {
@@ -338,11 +602,25 @@ func (s *Server) addToCronManager(bdc []backupapi.BackupDirectoryConfig) {
 				limitUpload = viper.GetInt("limit_upload")
 			}
 			limitDownload := 0
-			entryID, err := s.cronManager.AddFunc(policy.SchedulePattern, func() {
+			changeDetection := policy.ChangeDetection
+			id := mappingID(bd.ID, policy.ID)
+			job := func() {
+				defer s.crashReporter.Recover()
+				s.recordCronRun(id, time.Now())
 				name := "auto-" + time.Now().Format(time.RFC3339)
 				// improve when support incremental backup
 				recoveryPointType := backupapi.RecoveryPointTypeInitialReplica
-				if err := s.backup(directoryID, policyID, name, limitUpload, limitDownload, recoveryPointType, ioutil.Discard); err != nil {
+				err := s.backup(directoryID, policyID, name, limitUpload, limitDownload, recoveryPointType, changeDetection, nil, ioutil.Discard)
+				switch {
+				case errors.Is(err, backupapi.ErrNotFound):
+					// the backup directory or policy was deleted while its config update was missed
+					s.logger.Warn("Backup directory not found, remove its cron entries", zap.String("backup_directory_id", directoryID))
+					s.removeDirectoryFromCron(directoryID)
+				case errors.Is(err, backupapi.ErrConflict):
+					s.logger.Info("Skip backup, another action of backup directory is running", zap.String("backup_directory_id", directoryID))
+				case errors.Is(err, ErrBackupRunning), errors.Is(err, backupapi.ErrOutsideBackupWindow):
+					// skipped and notified to backup server
+				case err != nil:
 					zapFields := []zap.Field{
 						zap.Error(err),
 						zap.String("service", "cron"),
@@ -351,21 +629,36 @@ func (s *Server) addToCronManager(bdc []backupapi.BackupDirectoryConfig) {
 					}
 					s.logger.Error("failed to run backup", zapFields...)
 				}
+			}
+			entryID, err := s.cronManager.AddJob(policy.SchedulePattern, scheduledBackup{
+				backupDirectoryID: directoryID,
+				policyID:          policyID,
+				path:              bd.Path,
+				pattern:           policy.SchedulePattern,
+				run:               job,
 			})
 			if err != nil {
 				s.logger.Error("failed to add cron entry", zap.Error(err))
 				continue
 			}
-			s.mappingToCronEntryID[mappingID(bd.ID, policy.ID)] = entryID
+			s.mappingToCronEntryID[id] = entryID
+			s.cronEntryConfigs[id] = cronEntryConfig(bd, policy)
+			if now := time.Now(); s.missedCronRun(id, policy.SchedulePattern, now) {
+				s.logger.Info("Run backup missed while agent restarted", zap.String("backup_directory_id", directoryID), zap.String("policy_id", policyID))
+				s.recordCronRun(id, now)
+				go job()
+			}
 		}
 	}
+	s.feed.scheduleChanged()
 }
 
 func (s *Server) RequestBackup(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		ID          string `json:"id"`
-		StorageType string `json:"storage_type"`
-		Name        string `json:"name"`
+		ID          string   `json:"id"`
+		StorageType string   `json:"storage_type"`
+		Name        string   `json:"name"`
+		OnlyPaths   []string `json:"only_paths"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -373,7 +666,23 @@ func (s *Server) RequestBackup(w http.ResponseWriter, r *http.Request) {
 		return
 
 	}
-	if err := s.requestBackup(body.ID, body.Name, body.StorageType); err != nil {
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		report, err := s.dryRunBackup(r.Context(), body.ID, r.URL.Query().Get("policy_id"), r.URL.Query().Get("storage_vault_id"), body.OnlyPaths)
+		if err != nil {
+			s.logger.Error("Dry run backup error", zap.Error(err), zap.String("backup_directory_id", body.ID))
+			if errors.Is(err, ErrIndexNotCached) {
+				w.WriteHeader(http.StatusNotFound)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+		return
+	}
+	if err := s.requestBackup(body.ID, body.Name, body.StorageType, body.OnlyPaths); err != nil {
 		return
 	}
 }
@@ -401,197 +710,796 @@ func (s *Server) ListRecoveryPoints(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(rps)
 }
 
-func (s *Server) DeleteRecoveryPoints(w http.ResponseWriter, r *http.Request) {
-	recoveryPointID := chi.URLParam(r, "recoveryPointID")
-	err := s.backupClient.DeleteRecoveryPoints(r.Context(), recoveryPointID)
-	if err != nil {
-		s.logger.Error("err ", zap.Error(err))
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	_, _ = w.Write([]byte("Delete recovery point successfully"))
+// DeleteRecoveryPointResponse reports what was removed by deleting a recovery point.
+type DeleteRecoveryPointResponse struct {
+	RecoveryPointID string `json:"recovery_point_id"`
+	// RemovedObjects are the keys of recovery point objects removed from storage vault.
+	RemovedObjects []string `json:"removed_objects,omitempty"`
+	// RemovedCache is the cache directory of recovery point, when it was removed.
+	RemovedCache string `json:"removed_cache,omitempty"`
+	// DeltaRecoveryPoints are the recovery points whose delta index depends on the index of recovery point, the index
+	// is kept in storage vault until the last of them is purged.
+	DeltaRecoveryPoints []string `json:"delta_recovery_points,omitempty"`
 }
 
-func (s *Server) RequestRestore(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		MachineID string `json:"machine_id"`
-		Path      string `json:"path"`
-	}
+// recoveryPointObjects are the objects stored per recovery point next to its chunks.
+var recoveryPointObjects = []string{"index.json", "chunk.json", "file.csv", "tier.json", "inventory.json"}
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+// DeleteRecoveryPoints deletes a recovery point on backup server. With "purge_storage" query, its index, chunk list
+// and file list are removed from storage vault "storage_vault_id" and from the cache directory. Chunks may be
+// shared with other recovery points, they are left to the backup server. The index is kept while delta indexes of
+// other recovery points depend on it, the base recorded by backup server; it is removed when the last of them is
+// purged.
+func (s *Server) DeleteRecoveryPoints(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	purge := r.URL.Query().Get("purge_storage") == "true"
+	storageVaultID := r.URL.Query().Get("storage_vault_id")
+	if purge && storageVaultID == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(`malformed body`))
+		_, _ = w.Write([]byte("storage_vault_id is required to purge storage"))
 		return
 	}
 
-	body.MachineID = s.backupClient.Id
-
-	recoveryPointID := chi.URLParam(r, "recoveryPointID")
-	if err := s.requestRestore(recoveryPointID, body.MachineID, body.Path); err != nil {
-		return
+	var baseID string
+	if purge {
+		rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+		if err != nil {
+			s.logger.Error("err ", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		baseID = rp.BaseRecoveryPointID
 	}
-}
 
-func (s *Server) SyncConfig(w http.ResponseWriter, r *http.Request) {
-	c, err := s.backupClient.GetConfig(r.Context())
+	err := s.backupClient.DeleteRecoveryPoints(r.Context(), recoveryPointID)
 	if err != nil {
 		s.logger.Error("err ", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if err := s.handleConfigRefresh(c.BackupDirectories); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
-	}
-}
-
-func (s *Server) Version(w http.ResponseWriter, r *http.Request) {
-	_, _ = w.Write([]byte(Version))
-}
-
-func (s *Server) UpgradeAgent(w http.ResponseWriter, r *http.Request) {
-	if err := s.doUpgrade(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+	resp := DeleteRecoveryPointResponse{RecoveryPointID: recoveryPointID}
+	if purge {
+		if err := s.purgeRecoveryPoint(r.Context(), recoveryPointID, baseID, storageVaultID, &resp); err != nil {
+			s.logger.Error("Purge recovery point error", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("recovery point %s is deleted, but purging storage failed: %v", recoveryPointID, err)))
+			return
+		}
 	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
-func (s *Server) doUpgrade() error {
-	if Version == "dev" {
-		// Do not upgrade dev version
-		return nil
-	}
-
-	lv, err := s.backupClient.LatestVersion()
+// purgeRecoveryPoint removes objects of a deleted recovery point from storage vault and its cache directory,
+// recording them in resp. The index is kept while delta indexes of other recovery points depend on it. The index of
+// baseID, the base of the recovery point, is removed too once its recovery point is deleted and no other delta index
+// depends on it.
+func (s *Server) purgeRecoveryPoint(ctx context.Context, recoveryPointID, baseID, storageVaultID string, resp *DeleteRecoveryPointResponse) error {
+	rps, err := s.machineRecoveryPoints(ctx)
 	if err != nil {
-		s.logger.Error("err ", zap.Error(err))
 		return err
 	}
-	latestVer := "v" + lv.Ver
-	currentVer := "v" + Version
-	fields := []zap.Field{zap.String("current_version", currentVer), zap.String("latest_version", latestVer)}
-	if semver.Compare(latestVer, currentVer) != 1 {
-		s.logger.Warn("Current version is latest version.", fields...)
-		return nil
+	// the recovery point is deleted, it is no longer a delta of its base
+	for i := range rps {
+		if rps[i].ID == recoveryPointID {
+			rps = append(rps[:i], rps[i+1:]...)
+			break
+		}
 	}
+	resp.DeltaRecoveryPoints = deltasOf(rps, recoveryPointID)
 
-	var binURL string
-	switch runtime.GOOS {
-	case "linux":
-		binURL = lv.Linux[runtime.GOARCH]
-	case "macos":
-		binURL = lv.Macos[runtime.GOARCH]
-	case "windows":
-		binURL = lv.Windows[runtime.GOARCH]
-	default:
-		return errors.New("unsupported OS")
-	}
-	if binURL == "" {
-		return errors.New("failed to get download url")
+	vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, "", nil)
+	if err != nil {
+		return err
 	}
-
-	s.logger.Info("Detect new version, downloading...", fields...)
-
-	resp, err := http.Get(binURL)
+	storageVault, err := s.NewStorageVault(*vault, "", 0, 0)
 	if err != nil {
-		s.logger.Error("err ", zap.Error(err))
 		return err
 	}
-	defer resp.Body.Close()
-
-	s.logger.Info("Finish downloading, perform upgrading...")
-	_ = update.Apply(resp.Body, update.Options{})
-
-	// check running backup task to do not auto upgrade
-	totalWait := 0 * time.Second
-	for s.chunkPool.Running() > 0 || s.pool.Running() > 0 || s.poolDir.Running() > 0 {
-		s.logger.Debug("Waiting all task done to auto restart")
-		totalWait += intervalTimeCheckTaskRunning
-		if totalWait >= intervalTimeCheckUpgrade {
-			return nil
+	for _, name := range recoveryPointObjects {
+		if name == "index.json" && len(resp.DeltaRecoveryPoints) > 0 {
+			continue
 		}
-		time.Sleep(intervalTimeCheckTaskRunning)
+		if err := s.purgeObject(ctx, storageVault, recoveryPointID, name, resp); err != nil {
+			return err
+		}
+	}
+	if resp.RemovedCache, err = s.purgeCache(recoveryPointID); err != nil {
+		return err
 	}
 
-	s.logger.Info("Cleaning...")
-	if s.useUnixSock {
-		//	Remove socket
-		err := os.Remove(s.Addr)
-		if err != nil {
-			s.logger.Error("err ", zap.Error(err))
+	if baseID == "" || len(deltasOf(rps, baseID)) > 0 {
+		return nil
+	}
+	for _, rp := range rps {
+		if rp.ID == baseID {
+			return nil
 		}
 	}
-
-	// do action restart application
-	s.logger.Info("Restarting...")
-	err = restartByExec()
-	if err != nil {
+	// the index of base was only kept for the recovery point
+	if err := s.purgeObject(ctx, storageVault, baseID, "index.json", resp); err != nil {
 		return err
 	}
+	_, err = s.purgeCache(baseID)
+	return err
+}
 
+// purgeObject removes object name of recovery point recoveryPointID from storageVault, recording it in resp.
+func (s *Server) purgeObject(ctx context.Context, storageVault storage_vault.StorageVault, recoveryPointID, name string, resp *DeleteRecoveryPointResponse) error {
+	// same key as the object was put with
+	key := filepath.Join(s.backupClient.Id, recoveryPointID, name)
+	if err := storageVault.DeleteObject(ctx, key); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	resp.RemovedObjects = append(resp.RemovedObjects, key)
 	return nil
 }
 
-// restartByExec calls `syscall.Exec()` to restart app
-func restartByExec() error {
-	executableArgs := os.Args
-	executableEnvs := os.Environ()
-
-	// searches for an executable path
-	executablePath, err := filepath.Abs(os.Args[0])
+// purgeCache removes the cache directory of recovery point recoveryPointID, it returns the directory when it was
+// removed.
+func (s *Server) purgeCache(recoveryPointID string) (string, error) {
+	_, cachePath, err := support.CheckPath()
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	// searches for an executable file in path
-	binary, err := exec.LookPath(executablePath)
-	if err != nil {
-		return err
+	dir := filepath.Join(cachePath, s.backupClient.Id, recoveryPointID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", nil
 	}
-
-	time.Sleep(1 * time.Second)
-
-	// calls `syscall.Exec()` to restart app
-	err = syscall.Exec(binary, executableArgs, executableEnvs)
-	if err != nil {
-		return err
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
 	}
-	return nil
+	return dir, nil
 }
 
-func (s *Server) upgradeLoop(ctx context.Context) {
-	ticker := time.NewTicker(intervalTimeCheckUpgrade)
-	defer ticker.Stop()
+func (s *Server) RequestRestore(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MachineID       string                   `json:"machine_id"`
+		SourceMachineID string                   `json:"source_machine_id"`
+		Path            string                   `json:"path"`
+		PriorityPaths   []string                 `json:"priority_paths"`
+		IncludePaths    []string                 `json:"include_paths"`
+		ExcludePaths    []string                 `json:"exclude_paths"`
+		Force           bool                     `json:"force"`
+		Target          *backupapi.RestoreTarget `json:"target"`
+		// Conflict is the policy for existing files which differ from the backed up ones.
+		Conflict backupapi.ConflictPolicy `json:"conflict"`
+		// NoSymlinkEscape restores no symlink pointing outside path and writes no item through one.
+		NoSymlinkEscape bool `json:"no_symlink_escape"`
+		// OwnerMap changes the owners of restored items, chown, uid_map and gid_map.
+		backupapi.OwnerMap
+		// SkipXattrs restores no extended attributes, POSIX ACLs and security descriptors.
+		SkipXattrs bool `json:"skip_xattrs"`
+		// Atomic restores into a staging directory swapped with path once the restore succeeds.
+		Atomic bool `json:"atomic"`
+		// LimitDownload is the KiB per second the restore downloads at instead of limit_download, 0 is unlimited.
+		LimitDownload *int `json:"limit_download"`
+	}
 
-	s.logger.Debug("Start auto upgrade loop.")
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case t := <-ticker.C:
-			if err := s.doUpgrade(); err != nil {
-				fields := []zap.Field{
-					zap.Error(err),
-					zap.Time("at", t),
-				}
-				s.logger.Error("Auto upgrade run", fields...)
-			}
-		}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`malformed body`))
+		return
 	}
-}
 
-func (s *Server) subscribeBrokerLoop(ctx context.Context) {
-	if len(s.subscribeTopics) == 0 {
+	body.MachineID = s.backupClient.Id
+
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	if err := body.Conflict.Check(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
-	b := &backoff.Backoff{Jitter: true}
-	for {
-		select {
-		case <-ctx.Done():
-			return
+	if err := body.OwnerMap.Check(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if body.LimitDownload != nil && *body.LimitDownload < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("limit_download must not be negative"))
+		return
+	}
+	if body.Target != nil {
+		if err := body.Target.Check(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		if body.Atomic {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("atomic restores apply to a directory only"))
+			return
+		}
+	}
+	filter := backupapi.PathFilter{Include: body.IncludePaths, Exclude: body.ExcludePaths}
+	if body.Atomic {
+		if err := backupapi.CheckAtomic(filter, body.PriorityPaths, ""); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run")); dryRun {
+		if body.Target != nil || (body.SourceMachineID != "" && body.SourceMachineID != s.backupClient.Id) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("dry run previews restores of this machine's recovery points to a directory only"))
+			return
+		}
+		preview, err := s.dryRunRestore(r.Context(), recoveryPointID, r.URL.Query().Get("storage_vault_id"), body.Path, filter, body.Conflict)
+		if err != nil {
+			s.logger.Error("Dry run restore error", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+			switch {
+			case errors.Is(err, ErrIndexNotCached):
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, backupapi.ErrNoPathMatched):
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(preview)
+		return
+	}
+	if err := s.requestRestore(recoveryPointID, body.MachineID, body.SourceMachineID, body.Path, body.PriorityPaths,
+		filter, body.Conflict, body.NoSymlinkEscape, body.OwnerMap, body.SkipXattrs, body.Atomic, body.Force, body.LimitDownload, body.Target); err != nil {
+		return
+	}
+}
+
+// RequestRestoreFile requests a restore of the single file at path of a recovery point to dest_directory, only the
+// chunks of the file are downloaded.
+func (s *Server) RequestRestoreFile(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		SourceMachineID string                   `json:"source_machine_id"`
+		Path            string                   `json:"path"`
+		DestDirectory   string                   `json:"dest_directory"`
+		Force           bool                     `json:"force"`
+		Conflict        backupapi.ConflictPolicy `json:"conflict"`
+		NoSymlinkEscape bool                     `json:"no_symlink_escape"`
+		backupapi.OwnerMap
+		SkipXattrs    bool `json:"skip_xattrs"`
+		LimitDownload *int `json:"limit_download"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Path == "" || body.DestDirectory == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`malformed body`))
+		return
+	}
+
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	if err := body.Conflict.Check(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if err := body.OwnerMap.Check(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	if body.LimitDownload != nil && *body.LimitDownload < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("limit_download must not be negative"))
+		return
+	}
+	filter := backupapi.PathFilter{File: body.Path}
+	if err := s.requestRestore(recoveryPointID, s.backupClient.Id, body.SourceMachineID, body.DestDirectory, nil, filter, body.Conflict, body.NoSymlinkEscape, body.OwnerMap, body.SkipXattrs, false, body.Force, body.LimitDownload, nil); err != nil {
+		s.logger.Error("Request restore file error", zap.Error(err), zap.String("path", body.Path))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+}
+
+// TestStorageVaultResponse is the result of testing a storage vault.
+type TestStorageVaultResponse struct {
+	StorageVaultID   string `json:"storage_vault_id"`
+	StorageVaultType string `json:"storage_vault_type"`
+	storage_vault.ProbeResult
+	// Scope is the result of checking the credential only gives access to the key prefix of storage vault.
+	Scope *storage_vault.ScopeResult `json:"scope,omitempty"`
+}
+
+// TestStorageVault performs a small put/head/get/delete round trip on a storage vault, given by ID or by its definition,
+// to report latency and permission problems before a real backup runs, and checks the scope of its credential.
+func (s *Server) TestStorageVault(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		StorageVaultID string                  `json:"storage_vault_id"`
+		StorageVault   *backupapi.StorageVault `json:"storage_vault"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`malformed body`))
+		return
+	}
+
+	vault := body.StorageVault
+	if vault == nil {
+		if body.StorageVaultID == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`storage_vault_id or storage_vault is required`))
+			return
+		}
+		var err error
+		vault, err = s.backupClient.GetCredentialStorageVault(body.StorageVaultID, "", nil)
+		if err != nil {
+			s.logger.Error("Get credential storage vault error", zap.Error(err))
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	storageVault, err := s.NewStorageVault(*vault, "", 0, 0)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	key := path.Join(s.backupClient.Id, ".vault-test", strconv.FormatInt(time.Now().UnixNano(), 10))
+	ctx, cancel := context.WithTimeout(r.Context(), timeoutProbeStorageVault)
+	defer cancel()
+	resultCh := make(chan TestStorageVaultResponse, 1)
+	go func() {
+		resp := TestStorageVaultResponse{
+			StorageVaultID:   vault.ID,
+			StorageVaultType: vault.StorageVaultType,
+			ProbeResult:      storage_vault.Probe(ctx, storageVault, key),
+		}
+		if scope, err := s.checkVaultScope(ctx, storageVault); err == nil {
+			resp.Scope = &scope
+		}
+		resultCh <- resp
+	}()
+
+	select {
+	case resp := <-resultCh:
+		_ = json.NewEncoder(w).Encode(resp)
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			_, _ = w.Write([]byte("storage vault test timed out"))
+		}
+	}
+}
+
+// ExportManifest writes the items protected by a recovery point as a manifest in "format" query (restic, borg or
+// sha256sum), so they can be audited by third-party tools. The index is read from cache directory, or downloaded
+// from storage vault given by "storage_vault_id" query.
+func (s *Server) ExportManifest(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = manifest.FormatRestic
+	}
+	if !manifest.IsSupported(format) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(fmt.Sprintf("unsupported format %q, supported formats: %s", format, strings.Join(manifest.Formats, ", "))))
+		return
+	}
+
+	rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+	if err != nil {
+		s.logger.Error("Error get recoveryPointInfo", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	index, err := s.loadIndex(r.Context(), rp, r.URL.Query().Get("storage_vault_id"))
+	if err != nil {
+		s.logger.Error("Error load index", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+		if errors.Is(err, ErrIndexNotCached) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	snapshot := manifest.Snapshot{RecoveryPointID: rp.ID}
+	snapshot.Hostname, _ = os.Hostname()
+	if createdAt, err := time.Parse(time.RFC3339, rp.CreatedAt); err == nil {
+		snapshot.Time = createdAt
+	}
+	w.Header().Set("Content-Type", manifest.ContentType(format))
+	if err := manifest.Export(w, index, snapshot, format); err != nil {
+		s.logger.Error("Error export manifest", zap.Error(err))
+	}
+}
+
+// GetInventory returns the system inventory captured with recovery point, read from storage vault "storage_vault_id".
+func (s *Server) GetInventory(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	storageVaultID := r.URL.Query().Get("storage_vault_id")
+	if storageVaultID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("storage_vault_id is required"))
+		return
+	}
+
+	inv, err := s.getInventory(r.Context(), recoveryPointID, storageVaultID)
+	if err != nil {
+		s.logger.Error("Error get inventory", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+		if errors.Is(err, backupapi.ErrNoInventory) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(inv)
+}
+
+func (s *Server) getInventory(ctx context.Context, recoveryPointID, storageVaultID string) (*inventory.Inventory, error) {
+	vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	storageVault, err := s.NewStorageVault(*vault, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.backupClient.GetInventory(ctx, storageVault, s.backupClient.Id, recoveryPointID)
+}
+
+// putInventory captures the system inventory of machine and stores it with recovery point when "inventory" config
+// is enabled. The backup does not fail when it can not be stored.
+func (s *Server) putInventory(ctx context.Context, storageVault storage_vault.StorageVault, mcID, rpID string) {
+	if !viper.GetBool("inventory") {
+		return
+	}
+	ctx, span := tracing.Start(ctx, "inventory")
+	inv := inventory.Collect(ctx)
+	for name, msg := range inv.Errors {
+		s.logger.Warn("Could not collect inventory", zap.String("collector", name), zap.String("error", msg))
+	}
+	s.logger.Sugar().Info("Put inventory.json to storage", zap.String("key", backupapi.InventoryKey(mcID, rpID)))
+	err := s.backupClient.PutInventory(ctx, storageVault, mcID, rpID, inv)
+	if err != nil {
+		s.logger.Warn("Could not store inventory", zap.Error(err))
+	}
+	tracing.End(span, err)
+}
+
+// GetInstanceMetadata returns the metadata of instance captured with recovery point, read from storage vault
+// "storage_vault_id".
+func (s *Server) GetInstanceMetadata(w http.ResponseWriter, r *http.Request) {
+	recoveryPointID := chi.URLParam(r, "recoveryPointID")
+	storageVaultID := r.URL.Query().Get("storage_vault_id")
+	if storageVaultID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("storage_vault_id is required"))
+		return
+	}
+
+	m, err := s.getInstanceMetadata(r.Context(), recoveryPointID, storageVaultID)
+	if err != nil {
+		s.logger.Error("Error get instance metadata", zap.Error(err), zap.String("recovery_point_id", recoveryPointID))
+		if errors.Is(err, backupapi.ErrNoInstanceMetadata) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(m)
+}
+
+func (s *Server) getInstanceMetadata(ctx context.Context, recoveryPointID, storageVaultID string) (*instance.Metadata, error) {
+	vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	storageVault, err := s.NewStorageVault(*vault, "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.backupClient.GetInstanceMetadata(ctx, storageVault, s.backupClient.Id, recoveryPointID)
+}
+
+// putInstanceMetadata captures the metadata of instance agent runs on and stores it with recovery point when
+// "instance_metadata" config is enabled. The backup does not fail when it can not be stored.
+func (s *Server) putInstanceMetadata(ctx context.Context, storageVault storage_vault.StorageVault, mcID, rpID string) {
+	if !viper.GetBool("instance_metadata") {
+		return
+	}
+	ctx, span := tracing.Start(ctx, "instance_metadata")
+	m := instance.Collect(ctx, instance.DefaultMetadataURL, instance.DefaultCloudDir)
+	for name, msg := range m.Errors {
+		s.logger.Warn("Could not collect instance metadata", zap.String("collector", name), zap.String("error", msg))
+	}
+	s.logger.Sugar().Info("Put instance.json to storage", zap.String("key", backupapi.InstanceMetadataKey(mcID, rpID)))
+	err := s.backupClient.PutInstanceMetadata(ctx, storageVault, mcID, rpID, m)
+	if err != nil {
+		s.logger.Warn("Could not store instance metadata", zap.Error(err))
+	}
+	tracing.End(span, err)
+}
+
+// loadIndex reads index of recovery point from cache directory, it is downloaded from storage vault when missing.
+// The index is checked against the hash stored in backup server.
+func (s *Server) loadIndex(ctx context.Context, rp *backupapi.RecoveryPointResponse, storageVaultID string) (*cache.Index, error) {
+	return s.loadIndexFrom(ctx, rp, func() (storage_vault.StorageVault, error) {
+		if storageVaultID == "" {
+			return nil, ErrIndexNotCached
+		}
+		vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		return s.NewStorageVault(*vault, "", 0, 0)
+	})
+}
+
+// loadIndexFrom is loadIndex downloading index from the storage vault returned by getVault. The delta index of an
+// incremental recovery point is applied to its base, so all items are returned.
+func (s *Server) loadIndexFrom(ctx context.Context, rp *backupapi.RecoveryPointResponse, getVault func() (storage_vault.StorageVault, error)) (*cache.Index, error) {
+	index, err := s.readIndexFrom(ctx, rp, getVault)
+	if err != nil {
+		return nil, err
+	}
+	index, _, err = s.applyBase(ctx, index, getVault)
+	return index, err
+}
+
+// readIndexFrom reads index.json of recovery point rp as it is stored.
+func (s *Server) readIndexFrom(ctx context.Context, rp *backupapi.RecoveryPointResponse, getVault func() (storage_vault.StorageVault, error)) (*cache.Index, error) {
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return nil, err
+	}
+	key := filepath.Join(s.backupClient.Id, rp.ID, "index.json")
+	name := filepath.Join(cachePath, key)
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		storageVault, err := getVault()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			return nil, err
+		}
+		if err := storage_vault.GetFile(ctx, storageVault, key, name, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	indexHash, err := sha256File(name)
+	if err != nil {
+		return nil, err
+	}
+	if rp.IndexHash != "" && indexHash != rp.IndexHash {
+		return nil, fmt.Errorf("index.json of recovery point %s is corrupted", rp.ID)
+	}
+	buf, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var index cache.Index
+	if err := json.Unmarshal(buf, &index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+func (s *Server) SyncConfig(w http.ResponseWriter, r *http.Request) {
+	s.backupClient.InvalidateCache()
+	c, err := s.backupClient.GetConfig(r.Context())
+	if err != nil {
+		s.logger.Error("err ", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.handleConfigRefresh(c.BackupDirectories); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+	}
+}
+
+func (s *Server) Version(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte(Version))
+}
+
+// AgentStatus is the status of agent returned by /status.
+type AgentStatus struct {
+	Version      string     `json:"version"`
+	NumGoroutine int        `json:"num_goroutine"`
+	Memory       MemoryMode `json:"memory"`
+}
+
+// Status returns the version of agent and its active modes.
+func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(AgentStatus{
+		Version:      Version,
+		NumGoroutine: s.poolDir.Cap(),
+		Memory:       s.memoryMode,
+	})
+}
+
+func (s *Server) UpgradeAgent(w http.ResponseWriter, r *http.Request) {
+	if !autoUpgradeEnabled() {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(ErrAutoUpgradeDisabled.Error()))
+		return
+	}
+	if err := s.doUpgrade(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+	}
+}
+
+// autoUpgradeEnabled reports whether agent is allowed to upgrade itself, it is enabled unless auto_upgrade is set to false.
+func autoUpgradeEnabled() bool {
+	if !viper.IsSet("auto_upgrade") {
+		return true
+	}
+	return viper.GetBool("auto_upgrade")
+}
+
+func (s *Server) doUpgrade() error {
+	if !autoUpgradeEnabled() {
+		return ErrAutoUpgradeDisabled
+	}
+	if Version == "dev" {
+		// Do not upgrade dev version
+		return nil
+	}
+
+	lv, err := s.backupClient.LatestVersion()
+	if err != nil {
+		s.logger.Error("err ", zap.Error(err))
+		return err
+	}
+	latestVer := "v" + lv.Ver
+	currentVer := "v" + Version
+	fields := []zap.Field{zap.String("current_version", currentVer), zap.String("latest_version", latestVer)}
+	if semver.Compare(latestVer, currentVer) != 1 {
+		s.logger.Warn("Current version is latest version.", fields...)
+		return nil
+	}
+
+	var binURL string
+	switch runtime.GOOS {
+	case "linux":
+		binURL = lv.Linux[runtime.GOARCH]
+	case "macos":
+		binURL = lv.Macos[runtime.GOARCH]
+	case "windows":
+		binURL = lv.Windows[runtime.GOARCH]
+	default:
+		return errors.New("unsupported OS")
+	}
+	if binURL == "" {
+		return errors.New("failed to get download url")
+	}
+
+	s.logger.Info("Detect new version, downloading...", fields...)
+
+	resp, err := http.Get(binURL)
+	if err != nil {
+		s.logger.Error("err ", zap.Error(err))
+		return err
+	}
+	defer resp.Body.Close()
+
+	s.logger.Info("Finish downloading, perform upgrading...")
+	_ = update.Apply(resp.Body, update.Options{})
+
+	// check running backup task to do not auto upgrade
+	totalWait := 0 * time.Second
+	for s.chunkPool.Running() > 0 || s.pool.Running() > 0 || s.poolDir.Running() > 0 {
+		s.logger.Debug("Waiting all task done to auto restart")
+		totalWait += intervalTimeCheckTaskRunning
+		if totalWait >= intervalTimeCheckUpgrade {
+			return nil
+		}
+		time.Sleep(intervalTimeCheckTaskRunning)
+	}
+
+	s.logger.Info("Cleaning...")
+	if err := s.feed.close(); err != nil {
+		s.logger.Error("err ", zap.Error(err))
+	}
+	if s.useUnixSock {
+		//	Remove socket
+		err := os.Remove(s.Addr)
+		if err != nil {
+			s.logger.Error("err ", zap.Error(err))
+		}
+	}
+
+	// batched notifications failed to publish are saved as pending
+	s.digest.flush()
+	if err := s.saveState(); err != nil {
+		s.logger.Error("failed to save agent state before restart", zap.Error(err))
+	}
+
+	// do action restart application
+	s.logger.Info("Restarting...")
+	err = restartByExec()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// restartByExec calls `syscall.Exec()` to restart app
+func restartByExec() error {
+	executableArgs := os.Args
+	executableEnvs := os.Environ()
+
+	// searches for an executable path
+	executablePath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return err
+	}
+
+	// searches for an executable file in path
+	binary, err := exec.LookPath(executablePath)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(1 * time.Second)
+
+	// calls `syscall.Exec()` to restart app
+	err = syscall.Exec(binary, executableArgs, executableEnvs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) upgradeLoop(ctx context.Context) {
+	ticker := time.NewTicker(intervalTimeCheckUpgrade)
+	defer ticker.Stop()
+
+	s.logger.Debug("Start auto upgrade loop.")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			if !autoUpgradeEnabled() {
+				s.logger.Debug("Auto upgrade is disabled, skip checking new version.")
+				continue
+			}
+			if err := s.doUpgrade(); err != nil {
+				fields := []zap.Field{
+					zap.Error(err),
+					zap.Time("at", t),
+				}
+				s.logger.Error("Auto upgrade run", fields...)
+			}
+		}
+	}
+}
+
+func (s *Server) subscribeBrokerLoop(ctx context.Context) {
+	if len(s.subscribeTopics) == 0 {
+		return
+	}
+	b := &backoff.Backoff{Jitter: true}
+	for {
+		select {
+		case <-ctx.Done():
+			return
 		default:
 		}
 		if err := s.b.ConnectAndSubscribe(s.handleBrokerEvent, s.subscribeTopics); err == nil {
@@ -604,11 +1512,13 @@ func (s *Server) subscribeBrokerLoop(ctx context.Context) {
 	}
 
 	// publish message to notify online status
-	msg := map[string]string{"status": "ONLINE", "event_type": broker.StatusNotify}
+	msg := map[string]string{"schema_version": schemaVersion, "status": "ONLINE", "event_type": broker.StatusNotify}
+	s.addLabels(msg)
 	payload, _ := json.Marshal(msg)
 	if err := s.b.Publish(s.publishTopics[0], payload); err != nil {
 		s.logger.Error("failed to notify server status online", zap.Error(err))
 	}
+	s.resumeAfterRestart()
 }
 
 func (s *Server) shutdownSignalLoop(ctx context.Context, valv *valve.Valve) {
@@ -655,6 +1565,9 @@ func (s *Server) signalHandler(c chan os.Signal, valv *valve.Valve, srv *http.Se
 	if err := srv.Shutdown(ctx); err != nil {
 		s.logger.Error("failed to shutdown http server")
 	}
+	if err := s.feed.close(); err != nil {
+		s.logger.Error("failed to close progress feed", zap.Error(err))
+	}
 
 	// verify, in worst case call cancel via defer
 	select {
@@ -673,6 +1586,21 @@ func (s *Server) Run() error {
 	go s.shutdownSignalLoop(baseCtx, valv)
 	go s.upgradeLoop(baseCtx)
 
+	s.removeTempFiles()
+	s.scheduleSavedBudgetResumes()
+
+	if path := viper.GetString("progress_socket"); path != "" {
+		if l, err := listenFeed(path); err != nil {
+			s.logger.Error("failed to listen progress feed", zap.Error(err), zap.String("path", path))
+		} else {
+			go func() {
+				if err := s.feed.serve(l); err != nil {
+					s.logger.Info("Progress feed stopped", zap.Error(err))
+				}
+			}()
+		}
+	}
+
 	srv := http.Server{Handler: chi.ServerBaseContext(baseCtx, s.router)}
 
 	c := make(chan os.Signal, 1)
@@ -682,91 +1610,567 @@ func (s *Server) Run() error {
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
 	go s.signalHandler(c, valv, &srv)
 
-	if s.useUnixSock {
-		unixListener, err := net.Listen("unix", s.Addr)
-		if err != nil {
-			s.logger.Error("err ", zap.Error(err))
+	if s.useUnixSock {
+		unixListener, err := net.Listen("unix", s.Addr)
+		if err != nil {
+			s.logger.Error("err ", zap.Error(err))
+			return err
+		}
+		return srv.Serve(unixListener)
+	}
+
+	srv.Addr = s.Addr
+	return srv.ListenAndServe()
+}
+
+func (s *Server) reportUploadCompleted(w io.Writer) {
+	_, _ = w.Write([]byte("Upload completed ..."))
+}
+
+// notifyMsg notifies the backend of status of an action or state of agent. Status changes of running actions are
+// batched into digests, see notificationDigest, the others are published at once and acknowledged by broker.
+func (s *Server) notifyMsg(msg interface{}) {
+	if m, ok := msg.(map[string]string); ok {
+		m["schema_version"] = schemaVersion
+		if m["status"] != "" {
+			s.addLabels(m)
+			s.feed.status(m["action_id"], m["status"])
+		}
+		if m["action_id"] != "" {
+			s.feed.event(m["action_id"], eventStatus, m)
+		}
+		if m["status"] != "" && !criticalStatus(m["status"]) {
+			s.digest.add(s.publishTopics[0], m["action_id"]+"/"+m["status"], m, digestInterval())
+			return
+		}
+	}
+	payload, _ := json.Marshal(msg)
+	if err := s.digest.publishNow(s.publishTopics[0], payload); err != nil {
+		s.logger.Warn("failed to notify server", zap.Error(err), zap.Any("message", msg))
+	}
+}
+
+// addLabels adds labels and groups of machine to status notification msg, so backend can group machines.
+func (s *Server) addLabels(msg map[string]string) {
+	if s.backupClient == nil {
+		return
+	}
+	if labels := formatLabels(s.backupClient.Labels()); labels != "" {
+		msg["labels"] = labels
+	}
+	if groups := s.backupClient.Groups(); len(groups) > 0 {
+		msg["groups"] = strings.Join(groups, ",")
+	}
+}
+
+func (s *Server) notifyMsgProgress(recoverypointID string, msg map[string]string) {
+	payload, _ := json.Marshal(msg)
+	floatPercent, _ := strconv.ParseFloat(strings.ReplaceAll(msg["percent"], "%", ""), 64)
+	if msg["percent"] != "" {
+		s.feed.progress(recoverypointID, msg["percent"], msg["eta"])
+	}
+	s.feed.progressEvent(recoverypointID, msg)
+
+	// progress of the priority items of a restore has its own percent, and its end is published too
+	priorityPercent, _ := strconv.ParseFloat(strings.ReplaceAll(msg["priority_percent"], "%", ""), 64)
+	_, priorityDone := msg["COMPLETE PRIORITY DOWNLOAD"]
+	if floatPercent > 0 || priorityPercent > 0 || priorityDone {
+		s.logger.Sugar().Infof("notifyMsgProgress: %s", msg)
+		if err := s.b.Publish(s.publishTopics[1]+"/"+recoverypointID, payload); err != nil {
+			s.logger.Warn("failed to notify server", zap.Error(err), zap.Any("message", msg))
+		}
+	}
+}
+
+// notifyBudgetExhausted returns a callback notifies once per day the bytes uploaded by the initial replica that day
+// and the time it resumes, when the daily upload budget is renewed.
+func (s *Server) notifyBudgetExhausted(actionID string) func(used int64, resumeAt time.Time) {
+	var mu sync.Mutex
+	var lastResumeAt time.Time
+	return func(used int64, resumeAt time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if resumeAt.Equal(lastResumeAt) {
+			return
+		}
+		lastResumeAt = resumeAt
+		s.logger.Sugar().Infof("Daily upload budget exhausted after %s, action %s resumes at %s", formatBytes(uint64(used)), actionID, resumeAt)
+		s.notifyMsg(map[string]string{
+			"action_id":      actionID,
+			"status":         statusUploadFile,
+			"daily_uploaded": strconv.FormatInt(used, 10),
+			"resume_at":      resumeAt.Format(time.RFC3339),
+		})
+	}
+}
+
+// handlePanic writes crash report when a task of goroutines pool panics, the pool recovers the worker itself.
+func (s *Server) handlePanic(v interface{}) {
+	s.logger.Error("Task panicked", zap.Any("panic", v))
+	path, err := s.crashReporter.Write(v)
+	if err != nil {
+		s.logger.Error("failed to write crash report", zap.Error(err))
+		return
+	}
+	if path != "" {
+		s.logger.Info("Wrote crash report", zap.String("path", path))
+	}
+}
+
+func (s *Server) notifyStatusFailed(actionID string, err error) {
+	msg := map[string]string{
+		"action_id": actionID,
+		"status":    statusFailed,
+		"reason":    err.Error(),
+	}
+	if code := errorCode(err); code != "" {
+		msg["error_code"] = code
+	}
+	if logID := s.uploadFailureLog(actionID, err); logID != "" {
+		msg["log_id"] = logID
+	}
+	s.notifyMsg(msg)
+}
+
+// uploadFailureLog uploads the agent log written while action actionID ran, returns its reference ID or an empty
+// string when it is not uploaded. Logs of stopped actions are not uploaded.
+func (s *Server) uploadFailureLog(actionID string, reason error) string {
+	if s.backupClient == nil || !failureLogUploadEnabled() || errors.Is(reason, backupapi.ErrorGotCancelRequest) {
+		return ""
+	}
+	to := time.Now()
+	from := to.Add(-failureLogWindow)
+	if actionContext, ok := s.mapActionContext[actionID]; ok && !actionContext.startedAt.IsZero() {
+		from = actionContext.startedAt.Add(-failureLogMargin)
+	}
+	_ = s.logger.Sync()
+	log, err := backupapi.ReadLog(from, to, failureLogMaxSize)
+	if err != nil || log == "" {
+		s.logger.Warn("Read failure log error", zap.Error(err), zap.String("action_id", actionID))
+		return ""
+	}
+	resp, err := s.backupClient.UploadActionLog(&backupapi.ActionLog{
+		ActionID: actionID,
+		Reason:   reason.Error(),
+		From:     from.UTC(),
+		To:       to.UTC(),
+		Log:      log,
+	})
+	if err != nil {
+		s.logger.Warn("Upload failure log error", zap.Error(err), zap.String("action_id", actionID))
+		return ""
+	}
+	return resp.ID
+}
+
+// failureLogUploadEnabled reports whether logs of failed actions are uploaded, it is enabled unless
+// upload_failure_log is set to false.
+func failureLogUploadEnabled() bool {
+	if !viper.IsSet("upload_failure_log") {
+		return true
+	}
+	return viper.GetBool("upload_failure_log")
+}
+
+// errorCode returns the code of failures the backup server reports specifically, or an empty string.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, cache.ErrDiskFull):
+		return "DISK_FULL"
+	case errors.Is(err, cache.ErrWriteTimeout):
+		return "WRITE_TIMEOUT"
+	case errors.Is(err, backupapi.ErrCorruptedChunk):
+		return "CORRUPTED_CHUNK"
+	case errors.Is(err, backupapi.ErrNotEnoughSpace):
+		return "NOT_ENOUGH_SPACE"
+	case errors.Is(err, backupapi.ErrDestinationFull):
+		return "DESTINATION_FULL"
+	case errors.Is(err, backupapi.ErrDestinationReadOnly):
+		return "DESTINATION_READ_ONLY"
+	case errors.Is(err, ErrRecoveryPointTampered):
+		return "RECOVERY_POINT_TAMPERED"
+	case errors.Is(err, backupapi.ErrInvalidRestoreTarget):
+		return "INVALID_RESTORE_TARGET"
+	case errors.Is(err, backupapi.ErrNoPathMatched):
+		return "NO_PATH_MATCHED"
+	case errors.Is(err, backupapi.ErrNotAFile):
+		return "NOT_A_FILE"
+	case errors.Is(err, backupapi.ErrInvalidConflictPolicy):
+		return "INVALID_CONFLICT_POLICY"
+	case errors.Is(err, backupapi.ErrUnsafePath):
+		return "UNSAFE_PATH"
+	case errors.Is(err, backupapi.ErrInvalidOwner):
+		return "INVALID_OWNER"
+	case errors.Is(err, backupapi.ErrAtomicRestore):
+		return "ATOMIC_RESTORE_FAILED"
+	case errors.Is(err, backupapi.ErrBackupWindowClosed):
+		return "BACKUP_WINDOW_CLOSED"
+	case errors.Is(err, backupapi.ErrMaxDurationReached):
+		return "MAX_DURATION_REACHED"
+	case errors.Is(err, limiter.ErrDailyBudgetExhausted):
+		return "DAILY_BUDGET_EXHAUSTED"
+	}
+	return ""
+}
+
+// backup performs backup flow. When onlyPaths is not empty, only these paths of the backup directory are backed up
+// to a recovery point of type PARTIAL.
+func (s *Server) backup(backupDirectoryID string, policyID string, name string, limitUpload, limitDownload int, recoveryPointType string, changeDetection string, onlyPaths []string, progressOutput io.Writer) (err error) {
+	chErr := make(chan error, 1)
+
+	if changeDetection == "" {
+		changeDetection = viper.GetString("change_detection")
+	}
+	detector, err := cache.NewChangeDetector(changeDetection)
+	if err != nil {
+		s.logger.Error("NewChangeDetector error", zap.Error(err))
+		return err
+	}
+
+	s.logger.Info("Backup directory ID: ", zap.String("backupDirectoryID", backupDirectoryID), zap.String("policyID", policyID), zap.String("name", name), zap.String("recoveryPointType", recoveryPointType))
+
+	// a backup of the directory still running when the next one is due is not overlapped
+	unlock, err := s.lockDirectory(backupDirectoryID, policyID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	requestedType := recoveryPointType
+	if len(onlyPaths) > 0 {
+		recoveryPointType = backupapi.RecoveryPointTypePartial
+		s.logger.Info("Partial backup", zap.Strings("onlyPaths", onlyPaths))
+	}
+	if recoveryPointType == backupapi.RecoveryPointTypeInitialReplica && incrementalBackupEnabled() {
+		// an incremental recovery point needs a latest one to compare with
+		if lrp, err := s.backupClient.GetLatestRecoveryPointID(backupDirectoryID); err == nil && lrp.ID != "" &&
+			lrp.Status == backupapi.RecoveryPointStatusCompleted {
+			recoveryPointType = backupapi.RecoveryPointTypePoint
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "backup",
+		attribute.String("backup_directory.id", backupDirectoryID),
+		attribute.String("policy.id", policyID),
+		attribute.String("recovery_point.type", recoveryPointType))
+	defer func() { tracing.End(span, err) }()
+
+	limits, err := s.policyLimits(ctx, backupDirectoryID, policyID)
+	if err != nil {
+		s.logger.Error("Get policy limits error", zap.Error(err))
+		return err
+	}
+	diskLimiter := s.policyDiskLimiter(ctx, backupDirectoryID, policyID)
+	chunking, err := s.policyChunking(ctx, backupDirectoryID, policyID)
+	if err != nil {
+		s.logger.Error("Get policy chunking error", zap.Error(err))
+		return err
+	}
+	// files and chunks of the backup take turns with those of other backups running at once
+	priority := s.policyPriority(ctx, backupDirectoryID, policyID)
+	queues := backupQueues{files: s.fileFair.Queue(priority), chunks: s.chunkFair.Queue(priority)}
+	deadline, err := s.policyDeadline(ctx, backupDirectoryID, policyID)
+	if errors.Is(err, backupapi.ErrOutsideBackupWindow) {
+		s.notifySkipped(backupDirectoryID, policyID, "", err)
+		return err
+	}
+	if err != nil {
+		s.logger.Error("Get policy deadline error", zap.Error(err))
+		return err
+	}
+
+	// Resume recovery point of a backup interrupted by agent restart, or create recovery point
+	key := checkpointKey(backupDirectoryID, policyID, recoveryPointType, onlyPaths)
+	checkpoint, actionCreateRP := s.resumeCheckpoint(key)
+	if actionCreateRP != nil {
+		s.logger.Info("Resuming interrupted backup", zap.String("recovery_point_id", actionCreateRP.RecoveryPoint.ID), zap.Int("files", len(checkpoint.Items)))
+	} else {
+		s.logger.Sugar().Infof("Creating recovery point %s", backupDirectoryID)
+		err = s.withReregister(func() error {
+			var err error
+			actionCreateRP, err = s.backupClient.CreateRecoveryPoint(ctx, backupDirectoryID, &backupapi.CreateRecoveryPointRequest{
+				PolicyID:          policyID,
+				Name:              name,
+				RecoveryPointType: recoveryPointType,
+				IncludedPaths:     onlyPaths,
+			})
+			return err
+		})
+		if err != nil {
+			s.logger.Error("CreateRecoveryPoint error", zap.Error(err))
+			chErr <- err
+			return <-chErr
+		}
+		action, _ := json.Marshal(actionCreateRP)
+		checkpoint = cache.NewCheckpoint(key, actionCreateRP.ID, actionCreateRP.RecoveryPoint.ID, action)
+	}
+	// the backup is run again by this request to resume the recovery point when it is stopped by the upload budget
+	resume := backupRequest{
+		BackupDirectoryID: backupDirectoryID,
+		PolicyID:          policyID,
+		Name:              name,
+		LimitUpload:       limitUpload,
+		LimitDownload:     limitDownload,
+		RecoveryPointType: requestedType,
+		ChangeDetection:   changeDetection,
+		OnlyPaths:         onlyPaths,
+	}
+	checkpoint.Resume, _ = json.Marshal(resume)
+
+	span.SetAttributes(attribute.String("action.id", actionCreateRP.ID), attribute.String("recovery_point.id", actionCreateRP.RecoveryPoint.ID))
+
+	// Save context of worker to map for manage
+	s.mapActionContext[actionCreateRP.ID] = contextStruct{ctx: ctx, cancel: cancel, startedAt: time.Now()}
+	s.crashReporter.RecordAction(actionCreateRP.ID)
+
+	// Notify status pending to backend
+	s.notifyMsg(map[string]string{
+		"action_id": actionCreateRP.ID,
+		"status":    statusPendingFile,
+	})
+
+	// the backup is stopped when the backup window of its policy closes or its max duration is reached, its
+	// checkpoint is kept so the next backup of the policy resumes the recovery point
+	if !deadline.At.IsZero() {
+		timer := time.AfterFunc(time.Until(deadline.At), func() {
+			s.logger.Info("Stop backup at deadline of policy", zap.String("action_id", actionCreateRP.ID), zap.Error(deadline.Reason))
+			checkpoint.Stop(deadline.Reason.Error())
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	stopped := func(err error) error {
+		switch checkpoint.StopReason() {
+		case "":
 			return err
+		case limiter.ErrDailyBudgetExhausted.Error():
+			// the recovery point is resumed when the budget is renewed
+			s.scheduleBudgetResume(key, checkpoint.ResumeTime(), func() error { return resume.run(s) })
+			s.notifyStatusFailed(actionCreateRP.ID, limiter.ErrDailyBudgetExhausted)
+			return limiter.ErrDailyBudgetExhausted
 		}
-		return srv.Serve(unixListener)
+		s.notifyStatusFailed(actionCreateRP.ID, deadline.Reason)
+		return deadline.Reason
 	}
 
-	srv.Addr = s.Addr
-	return srv.ListenAndServe()
-}
+	// backups beyond max_concurrent_backups wait for their turn, they can be stopped meanwhile
+	err = s.backups.acquire(ctx, QueuedBackup{
+		ActionID:          actionCreateRP.ID,
+		BackupDirectoryID: backupDirectoryID,
+		PolicyID:          policyID,
+		RecoveryPointID:   actionCreateRP.RecoveryPoint.ID,
+	})
+	if err != nil {
+		delete(s.mapActionContext, actionCreateRP.ID)
+		return stopped(backupapi.ErrorGotCancelRequest)
+	}
+	defer s.backups.release()
 
-func (s *Server) reportUploadCompleted(w io.Writer) {
-	_, _ = w.Write([]byte("Upload completed ..."))
+	_ = s.poolDir.Submit(s.backupWorker(ctx, actionCreateRP, checkpoint, backupDirectoryID, limitUpload, limitDownload, diskLimiter, &chunking, queues, detector, limits, onlyPaths, progressOutput, chErr))
+	if err := <-chErr; err != nil {
+		return stopped(err)
+	}
+	s.archiveRecoveryPoints(ctx, backupDirectoryID, policyID, actionCreateRP)
+	return nil
 }
 
-func (s *Server) notifyMsg(msg interface{}) {
-	payload, _ := json.Marshal(msg)
-	if err := s.b.Publish(s.publishTopics[0], payload); err != nil {
-		s.logger.Warn("failed to notify server", zap.Error(err), zap.Any("message", msg))
+// withReregister calls fn, and once more after registering the machine again when backup server rejected its
+// credential, e.g. after the machine was re-created on backup server with the same keys.
+func (s *Server) withReregister(fn func() error) error {
+	err := fn()
+	if !errors.Is(err, backupapi.ErrUnauthorized) {
+		return err
+	}
+	s.logger.Warn("Credential rejected by backup server, register machine again", zap.Error(err))
+	if _, errRegister := s.backupClient.UpdateMachine(); errRegister != nil {
+		s.logger.Error("Register machine error", zap.Error(errRegister))
+		return err
 	}
+	return fn()
 }
 
-func (s *Server) notifyMsgProgress(recoverypointID string, msg map[string]string) {
-	payload, _ := json.Marshal(msg)
-	floatPercent, _ := strconv.ParseFloat(strings.ReplaceAll(msg["percent"], "%", ""), 64)
-
-	if floatPercent > 0 {
-		s.logger.Sugar().Infof("notifyMsgProgress: %s", msg)
-		if err := s.b.Publish(s.publishTopics[1]+"/"+recoverypointID, payload); err != nil {
-			s.logger.Warn("failed to notify server", zap.Error(err), zap.Any("message", msg))
-		}
+// policyLimits returns the size and age limits of files backed up by policyID, which are none without policy.
+func (s *Server) policyLimits(ctx context.Context, backupDirectoryID, policyID string) (filter.Limits, error) {
+	if policyID == "" {
+		return filter.Limits{}, nil
 	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		return filter.Limits{}, err
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil {
+		return filter.Limits{}, nil
+	}
+	return policy.Limits(time.Now())
 }
 
-func (s *Server) notifyStatusFailed(actionID, reason string) {
-	s.notifyMsg(map[string]string{
-		"action_id": actionID,
-		"status":    statusFailed,
-		"reason":    reason,
-	})
+// policyDeadline returns the deadline of a backup of policyID started now, zero without policy.
+func (s *Server) policyDeadline(ctx context.Context, backupDirectoryID, policyID string) (backupapi.BackupDeadline, error) {
+	if policyID == "" {
+		return backupapi.BackupDeadline{}, nil
+	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		return backupapi.BackupDeadline{}, err
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil {
+		return backupapi.BackupDeadline{}, nil
+	}
+	return policy.Deadline(time.Now())
 }
 
-// backup performs backup flow.
-func (s *Server) backup(backupDirectoryID string, policyID string, name string, limitUpload, limitDownload int, recoveryPointType string, progressOutput io.Writer) error {
-	chErr := make(chan error, 1)
-
-	s.logger.Info("Backup directory ID: ", zap.String("backupDirectoryID", backupDirectoryID), zap.String("policyID", policyID), zap.String("name", name), zap.String("recoveryPointType", recoveryPointType))
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// policyChunking returns the chunking of files backed up by policyID, the chunking of agent config overridden by
+// the policy.
+func (s *Server) policyChunking(ctx context.Context, backupDirectoryID, policyID string) (backupapi.Chunking, error) {
+	chunking, err := backupapi.ConfigChunking()
+	if err != nil || policyID == "" {
+		return chunking, err
+	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		return backupapi.Chunking{}, err
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil {
+		return chunking, nil
+	}
+	return policy.Chunking(chunking)
+}
 
-	// Create recovery point
-	s.logger.Sugar().Infof("Creating recovery point %s", backupDirectoryID)
-	actionCreateRP, err := s.backupClient.CreateRecoveryPoint(ctx, backupDirectoryID, &backupapi.CreateRecoveryPointRequest{
-		PolicyID:          policyID,
-		Name:              name,
-		RecoveryPointType: recoveryPointType,
-	})
+// archiveRecoveryPoints moves completed recovery points of policyID older than its archive_after_days to its archive
+// storage class, after created is backed up. Chunks used by hot recovery points of the backup directory, created
+// included, stay in hot tier. Errors are logged, they do not fail the backup.
+func (s *Server) archiveRecoveryPoints(ctx context.Context, backupDirectoryID, policyID string, created *backupapi.CreateRecoveryPointResponse) {
+	if policyID == "" || created.StorageVault == nil || created.RecoveryPoint == nil {
+		return
+	}
+	c, err := s.backupClient.GetConfig(ctx)
+	if err != nil {
+		s.logger.Error("Get config to archive recovery points error", zap.Error(err))
+		return
+	}
+	policy := c.Policy(backupDirectoryID, policyID)
+	if policy == nil || policy.ArchiveAfterDays <= 0 {
+		return
+	}
+	storageVault, err := s.NewStorageVault(*created.StorageVault, created.ID, 0, 0)
 	if err != nil {
-		s.logger.Error("CreateRecoveryPoint error", zap.Error(err))
-		chErr <- err
-		return <-chErr
+		s.logger.Error("Storage vault to archive recovery points error", zap.Error(err))
+		return
+	}
+	rps, err := s.backupClient.ListRecoveryPoints(ctx, backupDirectoryID)
+	if err != nil {
+		s.logger.Error("List recovery points to archive error", zap.Error(err))
+		return
+	}
+	points := rps.RecoveryPoints
+	listed := false
+	for _, rp := range points {
+		listed = listed || rp.ID == created.RecoveryPoint.ID
+	}
+	if !listed {
+		points = append(points, backupapi.RecoveryPointResponse{ID: created.RecoveryPoint.ID})
 	}
 
-	// Save context of worker to map for manage
-	s.mapActionContext[actionCreateRP.ID] = contextStruct{ctx: ctx, cancel: cancel}
+	cutoff := time.Now().AddDate(0, 0, -policy.ArchiveAfterDays)
+	getVault := func() (storage_vault.StorageVault, error) { return storageVault, nil }
+	var candidates []backupapi.RecoveryPointResponse
+	keep := make(map[string]bool)
+	for _, rp := range points {
+		rp := rp
+		if rp.Status == backupapi.RecoveryPointStatusFAILED {
+			continue
+		}
+		tier, err := s.backupClient.GetRecoveryPointTier(ctx, storageVault, s.backupClient.Id, rp.ID)
+		if err != nil {
+			s.logger.Error("Get tier of recovery point error", zap.String("recovery_point_id", rp.ID), zap.Error(err))
+			return
+		}
+		if tier.Tier == backupapi.TierArchive {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, rp.CreatedAt)
+		if rp.Status == backupapi.RecoveryPointStatusCompleted && (rp.PolicyID == "" || rp.PolicyID == policyID) &&
+			err == nil && createdAt.Before(cutoff) {
+			candidates = append(candidates, rp)
+			continue
+		}
+		index, err := s.loadIndexFrom(ctx, &rp, getVault)
+		if err != nil {
+			s.logger.Error("Load index of hot recovery point error", zap.String("recovery_point_id", rp.ID), zap.Error(err))
+			return
+		}
+		keys, err := s.backupClient.IndexChunks(ctx, storageVault, index)
+		if err != nil {
+			s.logger.Error("List chunks of hot recovery point error", zap.String("recovery_point_id", rp.ID), zap.Error(err))
+			return
+		}
+		for key := range keys {
+			keep[key] = true
+		}
+	}
 
-	// Notify status pending to backend
-	s.notifyMsg(map[string]string{
-		"action_id": actionCreateRP.ID,
-		"status":    statusPendingFile,
-	})
+	for _, rp := range candidates {
+		rp := rp
+		index, err := s.loadIndexFrom(ctx, &rp, getVault)
+		if err != nil {
+			s.logger.Error("Load index of recovery point to archive error", zap.String("recovery_point_id", rp.ID), zap.Error(err))
+			continue
+		}
+		tier, err := s.backupClient.ArchiveRecoveryPoint(ctx, storageVault, s.backupClient.Id, rp.ID, index, keep, policy.ArchiveClass())
+		if err != nil {
+			s.logger.Error("Archive recovery point error", zap.String("recovery_point_id", rp.ID), zap.Error(err))
+			if errors.Is(err, storage_vault.ErrTieringUnsupported) {
+				return
+			}
+			continue
+		}
+		s.logger.Sugar().Infof("Archived recovery point %s, %d chunks moved to %s", rp.ID, tier.Chunks, tier.StorageClass)
+	}
+}
 
-	_ = s.poolDir.Submit(s.backupWorker(ctx, actionCreateRP, backupDirectoryID, limitUpload, limitDownload, progressOutput, chErr))
-	return <-chErr
+// retrieveArchived waits until archived chunks of index can be read, restores of archived recovery points are
+// reported as retrieving with the expected delay meanwhile.
+func (s *Server) retrieveArchived(ctx context.Context, actionID string, storageVault storage_vault.StorageVault, index *cache.Index, tier *backupapi.RecoveryPointTier, progressOutput io.Writer) error {
+	for notified := false; ; notified = true {
+		pending, err := s.backupClient.RetrieveChunks(ctx, storageVault, index, archiveRetrievalDays)
+		if err != nil {
+			return err
+		}
+		if pending == 0 {
+			return nil
+		}
+		if !notified {
+			delay := storage_vault.RetrievalDelay(tier.StorageClass)
+			s.logger.Sugar().Infof("Retrieving %d archived chunks from %s, expected in %s", pending, tier.StorageClass, delay)
+			_, _ = fmt.Fprintf(progressOutput, "Recovery point is archived in %s, retrieving %d chunks, expected in %s ...", tier.StorageClass, pending, delay)
+			s.notifyMsg(map[string]string{
+				"action_id":       actionID,
+				"status":          statusRetrieving,
+				"storage_class":   tier.StorageClass,
+				"pending_chunks":  strconv.Itoa(pending),
+				"retrieval_delay": strconv.Itoa(int(delay.Seconds())),
+			})
+		}
+		select {
+		case <-ctx.Done():
+			return backupapi.ErrorGotCancelRequest
+		case <-time.After(intervalCheckRetrieval):
+		}
+	}
 }
 
 // requestBackup performs a request backup flow.
-func (s *Server) requestBackup(backupDirectoryID string, name string, storageType string) error {
+func (s *Server) requestBackup(backupDirectoryID string, name string, storageType string, onlyPaths []string) error {
 	if err := s.backupClient.RequestBackupDirectory(backupDirectoryID, &backupapi.CreateManualBackupRequest{
 		Action:      "backup_manual",
 		StorageType: storageType,
 		Name:        name,
+		OnlyPaths:   onlyPaths,
 	}); err != nil {
 		return err
 	}
@@ -781,161 +2185,494 @@ func (s *Server) reportRestoreCompleted(w io.Writer) {
 	_, _ = w.Write([]byte("Restore completed."))
 }
 
-func (s *Server) restore(machineID, actionID string, createdAt string, restoreSessionKey string, recoveryPointID string, destDir string, storageVaultID string, limitUpload, limitDownload int, progressOutput io.Writer) error {
+// restore performs restore flow. Items in restored are skipped, when it is nil the restore resumes the restore state
+// of an interrupted restore to the same destination, or restores the whole recovery point. When the destination
+// becomes full or read-only, the restore is paused until it is resumed by ResumeAction.
+func (s *Server) restore(opts restoreOptions, restored *backupapi.RestoredItems, progressOutput io.Writer) (err error) {
+	resume := restored == nil
+	if restored == nil {
+		restored = backupapi.NewRestoredItems()
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	ctx, span := tracing.Start(ctx, "restore",
+		attribute.String("action.id", opts.ActionID),
+		attribute.String("recovery_point.id", opts.RecoveryPointID),
+		attribute.String("machine.id", opts.MachineID))
+	defer func() { tracing.End(span, err) }()
 
 	// Save context of worker to map for manage
-	s.mapActionContext[actionID] = contextStruct{ctx: ctx, cancel: cancel}
+	s.mapActionContext[opts.ActionID] = contextStruct{ctx: ctx, cancel: cancel, startedAt: time.Now()}
+	s.crashReporter.RecordAction(opts.ActionID)
+	s.feed.startAction(opts.ActionID, feedActionRestore, opts.RecoveryPointID, "")
+	defer s.feed.endAction(opts.ActionID)
 
 	_, cachePath, err := support.CheckPath()
 	if err != nil {
-		s.notifyStatusFailed(actionID, err.Error())
+		s.notifyStatusFailed(opts.ActionID, err)
+		return err
+	}
+	if err := opts.Conflict.Check(); err != nil {
+		s.logger.Error("Restore conflict policy error", zap.Error(err))
+		s.notifyStatusFailed(opts.ActionID, err)
+		return err
+	}
+	if err := opts.Owners.Check(); err != nil {
+		s.logger.Error("Restore owner mapping error", zap.Error(err))
+		s.notifyStatusFailed(opts.ActionID, err)
 		return err
 	}
 
 	// Get storage volume
 	restoreKey := &backupapi.AuthRestore{
-		RecoveryPointID:   recoveryPointID,
-		ActionID:          actionID,
-		CreatedAt:         createdAt,
-		RestoreSessionKey: restoreSessionKey,
+		RecoveryPointID:   opts.RecoveryPointID,
+		ActionID:          opts.ActionID,
+		CreatedAt:         opts.CreatedAt,
+		RestoreSessionKey: opts.RestoreSessionKey,
 	}
 
-	s.logger.Sugar().Info("Get credential storage vault", storageVaultID)
-	vault, err := s.backupClient.GetCredentialStorageVault(storageVaultID, actionID, restoreKey)
+	s.logger.Sugar().Info("Get credential storage vault", opts.StorageVaultID)
+	var vault *backupapi.StorageVault
+	err = s.withReregister(func() error {
+		var err error
+		vault, err = s.backupClient.GetCredentialStorageVault(opts.StorageVaultID, opts.ActionID, restoreKey)
+		return err
+	})
 	if err != nil {
 		s.logger.Error("Get credential storage vault error", zap.Error(err))
-		s.notifyStatusFailed(actionID, err.Error())
+		s.notifyStatusFailed(opts.ActionID, err)
 		return err
 	}
-	storageVault, _ := s.NewStorageVault(*vault, actionID, limitUpload, limitDownload)
+	storageVault, _ := s.NewStorageVault(*vault, opts.ActionID, opts.LimitUpload, opts.LimitDownload)
 
-	s.logger.Sugar().Info("Get recovery point info", recoveryPointID)
-	rp, err := s.backupClient.GetRecoveryPointInfo(recoveryPointID)
+	var targetVault storage_vault.StorageVault
+	if opts.Target != nil {
+		targetVault, err = s.newRestoreTargetVault(*opts.Target, opts.ActionID, opts.LimitUpload, opts.LimitDownload)
+		if err != nil {
+			s.logger.Error("Restore target error", zap.Error(err))
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+	}
+
+	s.logger.Sugar().Info("Get recovery point info", opts.RecoveryPointID)
+	rp, err := s.backupClient.GetRecoveryPointInfo(opts.RecoveryPointID)
 	if err != nil {
 		s.logger.Error("Error get recoveryPointInfo", zap.Error(err))
-		s.notifyStatusFailed(actionID, err.Error())
+		s.notifyStatusFailed(opts.ActionID, err)
 		return err
 	}
+	if targetVault == nil && opts.Atomic {
+		if err := backupapi.CheckAtomic(opts.Filter, opts.PriorityPaths, rp.RecoveryPointType); err != nil {
+			s.logger.Error("Atomic restore error", zap.Error(err))
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+	}
 
-	_, err = os.Stat(filepath.Join(cachePath, machineID, recoveryPointID, "index.json"))
+	_, err = os.Stat(filepath.Join(cachePath, opts.MachineID, opts.RecoveryPointID, "index.json"))
 	if err != nil {
 		if os.IsNotExist(err) {
-			s.logger.Sugar().Info("Get index.json from storage", zap.String("key", filepath.Join(machineID, recoveryPointID, "index.json")))
-			buf, err := storageVault.GetObject(filepath.Join(machineID, recoveryPointID, "index.json"))
-			if err == nil {
-				_ = os.MkdirAll(filepath.Join(cachePath, machineID, recoveryPointID), 0700)
-				if err := ioutil.WriteFile(filepath.Join(cachePath, machineID, recoveryPointID, "index.json"), buf, 0700); err != nil {
-					s.logger.Error("Error writing index.json file", zap.Error(err), zap.String("key", filepath.Join(machineID, recoveryPointID, "index.json")))
-					s.notifyStatusFailed(actionID, err.Error())
-					return err
-				}
-			} else {
-				s.logger.Error("Error get index.json from storage", zap.Error(err), zap.String("key", filepath.Join(machineID, recoveryPointID, "index.json")))
-				s.notifyStatusFailed(actionID, err.Error())
+			s.logger.Sugar().Info("Get index.json from storage", zap.String("key", filepath.Join(opts.MachineID, opts.RecoveryPointID, "index.json")))
+			_ = os.MkdirAll(filepath.Join(cachePath, opts.MachineID, opts.RecoveryPointID), 0700)
+			err := storage_vault.GetFile(ctx, storageVault, filepath.Join(opts.MachineID, opts.RecoveryPointID, "index.json"), filepath.Join(cachePath, opts.MachineID, opts.RecoveryPointID, "index.json"), 0700)
+			if err != nil {
+				s.logger.Error("Error get index.json from storage", zap.Error(err), zap.String("key", filepath.Join(opts.MachineID, opts.RecoveryPointID, "index.json")))
+				s.notifyStatusFailed(opts.ActionID, err)
 				return err
 			}
 		} else {
 			s.logger.Error("Error stat index.json file", zap.Error(err))
-			s.notifyStatusFailed(actionID, err.Error())
+			s.notifyStatusFailed(opts.ActionID, err)
 			return err
 		}
 	}
 
 	index := cache.Index{}
 
-	buf, err := ioutil.ReadFile(filepath.Join(cachePath, machineID, recoveryPointID, "index.json"))
+	buf, err := ioutil.ReadFile(filepath.Join(cachePath, opts.MachineID, opts.RecoveryPointID, "index.json"))
 	if err != nil {
-		s.logger.Error("Error read index.json file", zap.Error(err), zap.String("key", filepath.Join(machineID, recoveryPointID, "index.json")))
-		s.notifyStatusFailed(actionID, err.Error())
+		s.logger.Error("Error read index.json file", zap.Error(err), zap.String("key", filepath.Join(opts.MachineID, opts.RecoveryPointID, "index.json")))
+		s.notifyStatusFailed(opts.ActionID, err)
 		return err
 	} else {
 		_ = json.Unmarshal([]byte(buf), &index)
 	}
 
 	hash := sha256.Sum256(buf)
-	if hex.EncodeToString(hash[:]) != rp.IndexHash {
-		s.logger.Error("index.json is corrupted", zap.Error(err))
-		s.notifyStatusFailed(actionID, err.Error())
+	if indexHash := hex.EncodeToString(hash[:]); indexHash != rp.IndexHash {
+		err := fmt.Errorf("%w: index.json hash %s, backup server records %s", ErrRecoveryPointTampered, indexHash, rp.IndexHash)
+		if s.distrust(opts.ActionID, err, opts.Force) {
+			return err
+		}
+	}
+
+	chunkHash := index.ChunkHash
+	if index.IsDelta() {
+		full, _, err := s.applyBase(ctx, &index, func() (storage_vault.StorageVault, error) { return storageVault, nil })
+		if err != nil {
+			s.logger.Error("Load base index error", zap.Error(err))
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+		index = *full
+	}
+
+	if err := opts.Filter.Apply(&index); err != nil {
+		s.logger.Error("Filter restore items error", zap.Error(err), zap.Strings("include_paths", opts.Filter.Include),
+			zap.Strings("exclude_paths", opts.Filter.Exclude))
+		s.notifyStatusFailed(opts.ActionID, err)
+		return err
+	}
+	if err := opts.Owners.Apply(&index); err != nil {
+		s.logger.Error("Map owners of restore items error", zap.Error(err))
+		s.notifyStatusFailed(opts.ActionID, err)
+		return err
+	}
+	if opts.SkipXattrs {
+		backupapi.DropExtendedAttributes(&index)
+	}
+
+	tier, err := s.backupClient.GetRecoveryPointTier(ctx, storageVault, opts.MachineID, opts.RecoveryPointID)
+	if err != nil {
+		s.logger.Error("Get tier of recovery point error", zap.Error(err))
+		s.notifyStatusFailed(opts.ActionID, err)
 		return err
 	}
+	if tier.Tier == backupapi.TierArchive {
+		if err := s.retrieveArchived(ctx, opts.ActionID, storageVault, &index, tier, progressOutput); err != nil {
+			if ctx.Err() != nil {
+				return backupapi.ErrorGotCancelRequest
+			}
+			s.logger.Error("Retrieve archived recovery point error", zap.Error(err))
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+	}
+
+	if err := s.trustCheck(ctx, storageVault, restoreKey, cachePath, opts.MachineID, opts.RecoveryPointID, chunkHash, index); err != nil {
+		if ctx.Err() != nil {
+			return backupapi.ErrorGotCancelRequest
+		}
+		if s.distrust(opts.ActionID, err, opts.Force) {
+			return err
+		}
+	}
+
+	// a restore paused for space resumes with the items it restored
+	resumed := !resume
+	if repo, err := cache.NewRepository(cachePath, opts.MachineID, opts.RecoveryPointID); err != nil {
+		s.logger.Warn("failed to create repository of restore state", zap.Error(err))
+	} else {
+		key := restoreStateKey(opts.DestDir, opts.Target, opts.Filter, opts.Atomic)
+		if resume {
+			if state := s.resumeRestoreState(repo, key); state != nil {
+				restored, resumed = state, true
+			}
+		}
+		stop := s.startRestoreState(repo, key, opts.ActionID, opts.RecoveryPointID, restored)
+		defer func() { stop(err == nil) }()
+	}
+
+	restoreDir := filepath.Clean(opts.DestDir)
+	if targetVault == nil && opts.Atomic {
+		// a resumed restore continues in the staging directory it left
+		restoreDir, err = backupapi.PrepareStaging(opts.DestDir, resumed)
+		if err != nil {
+			s.logger.Error("Prepare staging directory of atomic restore error", zap.Error(err))
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+	}
+
+	if targetVault == nil {
+		usages, err := backupapi.CheckRestoreSpace(ctx, index, restoreDir)
+		if ctx.Err() != nil {
+			return backupapi.ErrorGotCancelRequest
+		}
+		if err != nil {
+			s.logger.Error("Check restore space error", zap.Error(err))
+			if restored.Len() > 0 && errors.Is(err, backupapi.ErrNotEnoughSpace) {
+				s.pauseRestore(opts, restored, err)
+				return err
+			}
+			s.notifyStatusFailed(opts.ActionID, err)
+			return err
+		}
+		for _, usage := range usages {
+			s.logger.Sugar().Infof("Restore to %s", usage)
+		}
+	}
 
 	s.notifyMsg(map[string]string{
-		"action_id": actionID,
+		"action_id": opts.ActionID,
 		"status":    statusDownloading,
 	})
 
 	s.reportStartDownload(progressOutput)
 
-	progressScan := s.newProgressScanDir(recoveryPointID)
-	itemTodo, err := WalkerItem(&index, progressScan, s.logger)
+	progressScan := s.newProgressScanDir(opts.RecoveryPointID)
+	itemTodo, err := WalkerItem(ctx, &index, progressScan, s.logger)
+	if ctx.Err() != nil {
+		return backupapi.ErrorGotCancelRequest
+	}
+	if err != nil {
+		s.notifyStatusFailed(opts.ActionID, err)
+		return err
+	}
+	progressRestore := s.newDownloadProgress(opts.RecoveryPointID, itemTodo)
+	s.feed.phase(opts.ActionID, phaseDownloading)
+	progressRestore.Start()
+	defer progressRestore.Done()
+	// chunks shared by several files are downloaded once
+	defer s.backupClient.CacheChunks()()
+
+	var progressPriority *progress.Progress
+	if len(opts.PriorityPaths) > 0 {
+		priorityItems, _ := backupapi.SplitPriorityItems(index, opts.PriorityPaths)
+		var priorityTodo progress.Stat
+		for _, item := range priorityItems {
+			priorityTodo.Add(progress.Stat{Items: 1, Bytes: item.Size})
+		}
+		progressPriority = s.newPriorityDownloadProgress(opts.RecoveryPointID, priorityTodo)
+		progressPriority.SetParent(progressRestore)
+		progressPriority.Start()
+		defer progressPriority.Done()
+	}
+
+	if targetVault != nil {
+		s.logger.Sugar().Infof("Restore to bucket %s prefix %q", opts.Target.Bucket, opts.Target.Prefix)
+		if err := s.backupClient.RestoreToVault(ctx, index, targetVault, storageVault, restoreKey, progressRestore, restored); err != nil {
+			s.logger.Error("Restore to bucket error", zap.Error(err))
+			cancel()
+			s.notifyStatusFailed(opts.ActionID, err)
+			progressRestore.Done()
+			return err
+		}
+	} else {
+		s.logger.Sugar().Info("Restore directory", restoreDir)
+		if err := s.backupClient.RestoreDirectory(ctx, index, restoreDir, storageVault, restoreKey, progressRestore, opts.PriorityPaths, progressPriority, restored, opts.Conflict, opts.NoSymlinkEscape); err != nil {
+			s.logger.Error("failed to download file", zap.Error(err))
+			cancel()
+			if backupapi.IsDestinationUnwritable(err) {
+				s.pauseRestore(opts, restored, err)
+				progressRestore.Done()
+				return err
+			}
+			s.notifyStatusFailed(opts.ActionID, err)
+			progressRestore.Done()
+			return err
+		}
+		progressRestore.Done()
+
+		var dirTodo progress.Stat
+		for _, item := range index.Items {
+			if item.Type == "dir" {
+				dirTodo.Items++
+			}
+		}
+		progressMetadata := s.newMetadataProgress(opts.RecoveryPointID, dirTodo)
+		s.feed.phase(opts.ActionID, phaseApplyingMetadata)
+		progressMetadata.Start()
+		err := s.backupClient.RestoreDirectoryTimes(ctx, index, restoreDir, progressMetadata)
+		progressMetadata.Done()
+		if err != nil {
+			return err
+		}
+		if opts.Atomic {
+			s.logger.Sugar().Infof("Swap %s with restored %s", filepath.Clean(opts.DestDir), restoreDir)
+			if err := backupapi.SwapStaging(opts.DestDir); err != nil {
+				s.logger.Error("Swap staging directory of atomic restore error", zap.Error(err))
+				s.notifyStatusFailed(opts.ActionID, err)
+				return err
+			}
+			if err := backupapi.RemoveStaging(opts.DestDir); err != nil {
+				s.logger.Warn("failed to remove previous content of restore destination", zap.Error(err),
+					zap.String("path", backupapi.StagingDir(opts.DestDir)))
+			}
+		}
+	}
+
+	// remove worker out of manage context mapping
+	delete(s.mapActionContext, opts.ActionID)
+
+	select {
+	case <-ctx.Done():
+		return backupapi.ErrorGotCancelRequest
+	default:
+		s.reportRestoreCompleted(progressOutput)
+		progressRestore.Done()
+		s.notifyMsg(map[string]string{
+			"action_id": opts.ActionID,
+			"status":    statusComplete,
+		})
+	}
+
+	return nil
+}
+
+// requestRestore performs a request restore flow.
+func (s *Server) requestRestore(recoveryPointID string, machineID string, sourceMachineID string, path string, priorityPaths []string, filter backupapi.PathFilter, conflict backupapi.ConflictPolicy, noSymlinkEscape bool, owners backupapi.OwnerMap, skipXattrs bool, atomic bool, force bool, limitDownload *int, target *backupapi.RestoreTarget) error {
+	if err := s.backupClient.RequestRestore(recoveryPointID, &backupapi.CreateRestoreRequest{
+		MachineID:       machineID,
+		SourceMachineID: sourceMachineID,
+		Path:            path,
+		PriorityPaths:   priorityPaths,
+		PathFilter:      filter,
+		Conflict:        conflict,
+		NoSymlinkEscape: noSymlinkEscape,
+		OwnerMap:        owners,
+		SkipXattrs:      skipXattrs,
+		Atomic:          atomic,
+		Force:           force,
+		LimitDownload:   limitDownload,
+		Target:          target,
+	}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// restoreDownloadLimit returns the KiB per second a restore overriding limit_download with limitKb downloads at. An
+// unlimited restore has a negative limit, so storage vaults do not fall back to limit_download.
+func restoreDownloadLimit(limitKb int) int {
+	if limitKb <= 0 {
+		return -1
+	}
+	return limitKb
+}
+
+// newRestoreTargetVault creates the storage vault files are restored to by a restore to target. Storage vault
+// middlewares do not apply, the objects are the plain files.
+func (s *Server) newRestoreTargetVault(target backupapi.RestoreTarget, actionID string, limitUpload, limitDownload int) (storage_vault.StorageVault, error) {
+	if err := target.Check(); err != nil {
+		return nil, err
+	}
+	vault, err := s.newStorageVaultBackend(target.StorageVault(), actionID)
+	if err != nil {
+		return nil, err
+	}
+	return storage_vault.WithLimit(storage_vault.WithTracing(vault), bandwidthLimiter(limitUpload, limitDownload)), nil
+}
+
+// NewStorageVault creates storage vault of given type. When the vault has mirrors,
+// objects are written to all of them and read from the first healthy one. Corrupted chunks are downloaded again
+// through the alternate endpoint of S3 storage vault given in storage_vault_alternate_endpoint config.
+func (s *Server) NewStorageVault(storageVault backupapi.StorageVault, actionID string, limitUpload, limitDownload int) (storage_vault.StorageVault, error) {
+	primary, err := s.newStorageVault(storageVault, actionID, limitUpload, limitDownload)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := viper.GetStringMapString("storage_vault_alternate_endpoint")[strings.ToLower(storageVault.ID)]
+	if endpoint != "" && storageVault.StorageVaultType == "S3" {
+		alt := storageVault
+		alt.Credential.AwsLocation = endpoint
+		alternate, err := s.newStorageVault(alt, actionID, limitUpload, limitDownload)
+		if err != nil {
+			return nil, fmt.Errorf("alternate endpoint of storage vault %s: %w", storageVault.ID, err)
+		}
+		primary = storage_vault.WithAlternates(primary, alternate)
+	}
+	mirrors := make([]storage_vault.StorageVault, 0, len(storageVault.Mirrors))
+	for _, m := range storageVault.Mirrors {
+		mirror, err := s.newStorageVault(m, actionID, limitUpload, limitDownload)
+		if err != nil {
+			return nil, fmt.Errorf("mirror storage vault %s: %w", m.ID, err)
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return storage_vault.NewReplicated(primary, mirrors...), nil
+}
+
+// newStorageVault creates storage vault of given type, wrapped with middlewares given to the vault in
+// storage_vault_middleware config then with the limit stage, so objects go through the middlewares, are limited to
+// limitUpload and limitDownload KiB per second and are stored. Operations of the backend are traced.
+func (s *Server) newStorageVault(storageVault backupapi.StorageVault, actionID string, limitUpload, limitDownload int) (storage_vault.StorageVault, error) {
+	vault, err := s.newStorageVaultBackend(storageVault, actionID)
 	if err != nil {
-		s.notifyStatusFailed(actionID, err.Error())
-		return err
+		return nil, err
 	}
-	progressRestore := s.newDownloadProgress(recoveryPointID, itemTodo)
-	progressRestore.Start()
-	defer progressRestore.Done()
-
-	s.logger.Sugar().Info("Restore directory", filepath.Clean(destDir))
-	if err := s.backupClient.RestoreDirectory(ctx, index, filepath.Clean(destDir), storageVault, restoreKey, progressRestore); err != nil {
-		s.logger.Error("failed to download file", zap.Error(err))
-		cancel()
-		s.notifyStatusFailed(actionID, err.Error())
-		progressRestore.Done()
-		return err
+	specs, err := storageVaultMiddleware(storageVault.ID)
+	if err != nil {
+		return nil, err
 	}
+	limited := storage_vault.WithLimit(storage_vault.WithTracing(vault), bandwidthLimiter(limitUpload, limitDownload))
+	return storage_vault.Chain(limited, specs)
+}
 
-	// remove worker out of manage context mapping
-	delete(s.mapActionContext, actionID)
-
-	select {
-	case <-ctx.Done():
-		return backupapi.ErrorGotCancelRequest
-	default:
-		s.reportRestoreCompleted(progressOutput)
-		progressRestore.Done()
-		s.notifyMsg(map[string]string{
-			"action_id": actionID,
-			"status":    statusComplete,
-		})
+// bandwidthLimiter returns the limiter of limitUpload and limitDownload KiB per second, 0 is limit_upload and
+// limit_download of config and a negative limit is unlimited.
+func bandwidthLimiter(limitUpload, limitDownload int) limiter.Limiter {
+	if limitUpload == 0 {
+		limitUpload = viper.GetInt("limit_upload")
+	}
+	if limitDownload == 0 {
+		limitDownload = viper.GetInt("limit_download")
 	}
+	return limiter.NewStaticLimiter(limitUpload, limitDownload)
+}
 
-	return nil
+// checkVaultScope checks the credential of storageVault only gives access to its key prefix, an over-privileged
+// credential is a misconfiguration of backup server which is logged as an error.
+func (s *Server) checkVaultScope(ctx context.Context, storageVault storage_vault.StorageVault) (storage_vault.ScopeResult, error) {
+	scope, err := storage_vault.CheckScope(ctx, storageVault)
+	if err != nil {
+		s.logger.Warn("Could not check scope of storage vault credential", zap.Error(err))
+		return scope, err
+	}
+	if scope.OverPrivileged {
+		storageVaultID, _ := storageVault.ID()
+		s.logger.Error("Storage vault credential is over-privileged, it gives access outside the key prefix of machine. Check the credential policy on backup server",
+			zap.String("storage_vault_id", storageVaultID), zap.String("prefix", scope.Prefix), zap.Strings("allowed", scope.Allowed))
+	}
+	return scope, nil
 }
 
-// requestRestore performs a request restore flow.
-func (s *Server) requestRestore(recoveryPointID string, machineID string, path string) error {
-	if err := s.backupClient.RequestRestore(recoveryPointID, &backupapi.CreateRestoreRequest{
-		MachineID: machineID,
-		Path:      path,
-	}); err != nil {
-		return err
+// storageVaultMiddleware returns middlewares of storage vault vaultID in storage_vault_middleware config, which
+// maps storage vault ID to the list of middlewares.
+func storageVaultMiddleware(vaultID string) ([]storage_vault.MiddlewareSpec, error) {
+	var config map[string][]storage_vault.MiddlewareSpec
+	if err := viper.UnmarshalKey("storage_vault_middleware", &config); err != nil {
+		return nil, fmt.Errorf("invalid storage_vault_middleware: %w", err)
 	}
-	return nil
+	return config[strings.ToLower(vaultID)], nil
 }
 
-func (s *Server) NewStorageVault(storageVault backupapi.StorageVault, actionID string, limitUpload, limitDownload int) (storage_vault.StorageVault, error) {
+func (s *Server) newStorageVaultBackend(storageVault backupapi.StorageVault, actionID string) (storage_vault.StorageVault, error) {
 	switch storageVault.StorageVaultType {
 	case "S3":
-		newS3Default, err := s3.NewS3Default(storageVault, actionID, limitUpload, limitDownload, s.backupClient)
+		newS3Default, err := s3.NewS3Default(storageVault, actionID, s.backupClient)
 		if err != nil {
 			return nil, err
 		}
 		return newS3Default, nil
+	case "LOCAL":
+		newLocal, err := local.NewLocal(storageVault, actionID)
+		if err != nil {
+			return nil, err
+		}
+		return newLocal, nil
+	case "SWIFT":
+		newSwift, err := swift.NewSwift(storageVault, actionID, s.backupClient)
+		if err != nil {
+			return nil, err
+		}
+		return newSwift, nil
 	default:
 		return nil, fmt.Errorf(fmt.Sprintf("storage vault type not supported %s", storageVault.StorageVaultType))
 	}
 }
 
-func WalkerItem(index *cache.Index, p *progress.Progress, logger *zap.Logger) (progress.Stat, error) {
+func WalkerItem(ctx context.Context, index *cache.Index, p *progress.Progress, logger *zap.Logger) (progress.Stat, error) {
 	p.Start()
 	defer p.Done()
 	var lastDir string
 
 	var st progress.Stat
 	for _, itemInfo := range index.Items {
+		if err := ctx.Err(); err != nil {
+			return progress.Stat{}, err
+		}
 		if filepath.Dir(itemInfo.AbsolutePath) != lastDir {
 			lastDir = filepath.Dir(itemInfo.AbsolutePath)
 			logger.Sugar().Infof("WalkerItem scanning: %s", lastDir)
@@ -951,17 +2688,81 @@ func WalkerItem(index *cache.Index, p *progress.Progress, logger *zap.Logger) (p
 	return st, nil
 }
 
-func WalkerDir(dir string, index *cache.Index, p *progress.Progress, logger *zap.Logger) (progress.Stat, int64, error) {
+// WalkerDir adds items of dir to index, except the ones excluded by f. It stops with the error of ctx when ctx is done.
+func WalkerDir(ctx context.Context, dir string, index *cache.Index, f *filter.Filter, p *progress.Progress, logger *zap.Logger) (progress.Stat, int64, error) {
 	p.Start()
 	defer p.Done()
 
-	var lastDir string
+	var st progress.Stat
+	if err := walkInto(ctx, dir, dir, index, f, p, &st, logger); err != nil {
+		return progress.Stat{}, 0, err
+	}
+	return st, index.TotalFiles, nil
+}
+
+// WalkerPaths is like WalkerDir, but scans only the given paths of dir. Paths are relative to dir or absolute,
+// they must be inside dir.
+func WalkerPaths(ctx context.Context, dir string, paths []string, index *cache.Index, f *filter.Filter, p *progress.Progress, logger *zap.Logger) (progress.Stat, int64, error) {
+	p.Start()
+	defer p.Done()
 
 	var st progress.Stat
-	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return progress.Stat{}, 0, fmt.Errorf("%s is not inside backup directory %s", path, dir)
+		}
+		if err := walkInto(ctx, dir, path, index, f, p, &st, logger); err != nil {
+			return progress.Stat{}, 0, err
+		}
+	}
+	return st, index.TotalFiles, nil
+}
+
+// walkInto adds items under root to index, with paths relative to dir. Items excluded by f, on another filesystem
+// than dir when f is restricted to it, tagged as cache or nodump, or files outside the size and age limits of f are
+// skipped, with all their content for directories.
+func walkInto(ctx context.Context, dir, root string, index *cache.Index, f *filter.Filter, p *progress.Progress, st *progress.Stat, logger *zap.Logger) error {
+	var lastDir string
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		if err != nil {
 			return err
 		}
+		if _, ok := index.Items[path]; ok {
+			// already scanned by an overlapping path
+			return nil
+		}
+		if f.OtherFileSystem(fi) {
+			logger.Sugar().Infof("WalkerDir skipping %s on another filesystem", path)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.Tagged(path, fi) {
+			logger.Sugar().Infof("WalkerDir skipping %s tagged as cache or nodump", path)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if f.ExceedsLimits(fi) {
+			logger.Sugar().Debugf("WalkerDir skipping %s by size or age", path)
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil && f.Excluded(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
 		if filepath.Dir(path) != lastDir {
 			lastDir = filepath.Dir(path)
@@ -977,6 +2778,7 @@ func WalkerDir(dir string, index *cache.Index, p *progress.Progress, logger *zap
 		if err != nil {
 			return err
 		}
+		index.TrackHardLink(node)
 		index.Items[path] = node
 
 		if !fi.IsDir() {
@@ -987,16 +2789,117 @@ func WalkerDir(dir string, index *cache.Index, p *progress.Progress, logger *zap
 		st.Add(s)
 		return nil
 	})
+}
+
+// ScanRoots adds the items of the roots of backup directory bd to index like WalkerDir, or only the given paths
+// like WalkerPaths when paths is not empty. Each root is filtered by its own IgnoreFile. With several roots, paths are
+// absolute or relative to the parent of a root, e.g. "etc/nginx" for root "/etc".
+func ScanRoots(ctx context.Context, bd *backupapi.BackupDirectory, limits filter.Limits, paths []string, index *cache.Index, p *progress.Progress, logger *zap.Logger) (progress.Stat, int64, error) {
+	roots := bd.Roots()
+	if err := checkRoots(roots); err != nil {
+		return progress.Stat{}, 0, err
+	}
+	selected, err := rootPaths(roots, paths)
 	if err != nil {
 		return progress.Stat{}, 0, err
 	}
+	p.Start()
+	defer p.Done()
+
+	var st progress.Stat
+	for _, root := range roots {
+		walked := []string{root}
+		if len(paths) > 0 {
+			walked = selected[root]
+		}
+		if len(walked) == 0 {
+			continue
+		}
+		f, err := backupFilter(bd, root, limits)
+		if err != nil {
+			return progress.Stat{}, 0, err
+		}
+		for _, path := range walked {
+			if err := walkInto(ctx, root, path, index, f, p, &st, logger); err != nil {
+				return progress.Stat{}, 0, err
+			}
+		}
+	}
 	return st, index.TotalFiles, nil
 }
 
+// checkRoots returns an error when two roots have the same name, as their items would be restored to the same paths.
+func checkRoots(roots []string) error {
+	names := make(map[string]string, len(roots))
+	for _, root := range roots {
+		name := filepath.Base(filepath.Clean(root))
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("roots %s and %s of backup directory have the same name", other, root)
+		}
+		names[name] = root
+	}
+	return nil
+}
+
+// rootPaths returns the paths by the root they are inside. Relative paths are relative to the root when there is one,
+// otherwise to the parent of roots.
+func rootPaths(roots, paths []string) (map[string][]string, error) {
+	selected := make(map[string][]string, len(roots))
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			if len(roots) == 1 {
+				path = filepath.Join(roots[0], path)
+			} else {
+				for _, root := range roots {
+					if underPath(path, filepath.Base(filepath.Clean(root))) {
+						path = filepath.Join(filepath.Dir(filepath.Clean(root)), path)
+						break
+					}
+				}
+			}
+		}
+		path = filepath.Clean(path)
+		found := false
+		for _, root := range roots {
+			if underPath(path, root) {
+				selected[root] = append(selected[root], path)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not inside backup directory %s", path, strings.Join(roots, ", "))
+		}
+	}
+	return selected, nil
+}
+
+// backupFilter returns the filter of the paths of root of backup directory bd which are backed up, within limits of
+// its policy.
+func backupFilter(bd *backupapi.BackupDirectory, root string, limits filter.Limits) (*filter.Filter, error) {
+	f, err := filter.Load(root, bd.ExcludePatterns, bd.IncludePatterns)
+	if err != nil {
+		return nil, err
+	}
+	if bd.OneFileSystem {
+		if err := f.OneFileSystem(root); err != nil {
+			return nil, err
+		}
+	}
+	f.SetLimits(limits)
+	if bd.ExcludeCaches {
+		f.ExcludeCaches()
+	}
+	if bd.ExcludeNoDump {
+		f.ExcludeNoDump()
+	}
+	return f, nil
+}
+
 type backupJob func()
 
-func (s *Server) uploadFileWorker(ctx context.Context, itemInfo *cache.Node, latestInfo *cache.Node, cacheWriter *cache.Repository, storageVault storage_vault.StorageVault,
-	wg *sync.WaitGroup, size *uint64, errCh *error, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) backupJob {
+func (s *Server) uploadFileWorker(ctx context.Context, itemInfo *cache.Node, latestInfo *cache.Node, cacheWriter *cache.Repository, fileList *cache.FileList, checkpoint *cache.Checkpoint, storageVault storage_vault.StorageVault, diskLimiter *limiter.DiskLimiter, chunking *backupapi.Chunking, packer *backupapi.Packer, chunks *limiter.FairQueue, detector cache.ChangeDetector,
+	contents *cache.ContentMap, wg *sync.WaitGroup, size *uint64, errCh *error, p *progress.Progress, pipe chan<- *cache.Chunk, rpID, bdID string) backupJob {
 	return func() {
 		defer wg.Done()
 		select {
@@ -1005,7 +2908,15 @@ func (s *Server) uploadFileWorker(ctx context.Context, itemInfo *cache.Node, lat
 		default:
 			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
-			storageSize, err := s.backupClient.UploadFile(ctx, s.chunkPool, latestInfo, itemInfo, cacheWriter, storageVault, p, pipe, rpID, bdID)
+			ctx, span := tracing.Start(ctx, "upload_file",
+				attribute.String("path", itemInfo.AbsolutePath),
+				attribute.Int64("size", int64(itemInfo.Size)))
+			storageSize, err := s.backupClient.UploadFile(ctx, chunks, latestInfo, itemInfo, cacheWriter, storageVault, diskLimiter, chunking, packer, detector, contents, p, pipe, rpID, bdID)
+			if errAdd := fileList.Add(itemInfo); errAdd != nil && err == nil {
+				err = errAdd
+			}
+			span.SetAttributes(attribute.Int64("storage_size", int64(storageSize)))
+			tracing.End(span, err)
 			if err != nil {
 				s.logger.Error("uploadFileWorker error", zap.Error(err))
 				*errCh = err
@@ -1013,13 +2924,17 @@ func (s *Server) uploadFileWorker(ctx context.Context, itemInfo *cache.Node, lat
 				return
 			}
 
+			checkpoint.AddItem(itemInfo)
 			*size += storageSize
 		}
 	}
 }
 
-func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.CreateRecoveryPointResponse, backupDirectoryID string, limitUpload, limitDownload int, progressOutput io.Writer, errCh chan<- error) backupJob {
+func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.CreateRecoveryPointResponse, checkpoint *cache.Checkpoint, backupDirectoryID string, limitUpload, limitDownload int, diskLimiter *limiter.DiskLimiter, chunking *backupapi.Chunking, queues backupQueues, detector cache.ChangeDetector, limits filter.Limits, onlyPaths []string, progressOutput io.Writer, errCh chan<- error) backupJob {
 	return func() {
+		s.feed.startAction(actionCreateRP.ID, feedActionBackup, actionCreateRP.RecoveryPoint.ID, backupDirectoryID)
+		defer s.feed.endAction(actionCreateRP.ID)
+		s.feed.phase(actionCreateRP.ID, phaseScanning)
 		s.notifyMsg(map[string]string{
 			"action_id": actionCreateRP.ID,
 			"status":    statusUploadFile,
@@ -1027,6 +2942,12 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
+		// a backup stopped by its policy or the upload budget is failed once with the stop reason by backup
+		failed := func(err error) {
+			if checkpoint.StopReason() == "" {
+				s.notifyStatusFailed(actionCreateRP.ID, err)
+			}
+		}
 
 		// Get BackupDirectory
 		s.logger.Sugar().Info("Get backup directory", zap.String("backupDirectoryID", backupDirectoryID))
@@ -1041,11 +2962,15 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 		s.logger.Sugar().Info("Get latest recovery point", zap.String("backupDirectoryID", backupDirectoryID))
 		lrp, err := s.backupClient.GetLatestRecoveryPointID(backupDirectoryID)
 		if err != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, err.Error())
+			failed(err)
 			s.logger.Error("GetLatestRecoveryPointID error", zap.Error(err))
 			errCh <- err
 			return
 		}
+		if lrp != nil && lrp.ID == actionCreateRP.RecoveryPoint.ID {
+			// the resumed recovery point is not complete
+			lrp = nil
+		}
 
 		// Get storage vault
 		storageVault, err := s.NewStorageVault(*actionCreateRP.StorageVault, actionCreateRP.ID, limitUpload, limitDownload)
@@ -1054,6 +2979,21 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			errCh <- err
 			return
 		}
+		// the index and the other objects of the recovery point are not counted in the daily upload budget, so a
+		// backup is not stopped once its data is uploaded
+		metadataVault := storageVault
+		if s.uploadBudget != nil && actionCreateRP.RecoveryPoint.RecoveryPointType == backupapi.RecoveryPointTypeInitialReplica {
+			storageVault = storage_vault.WithDailyBudget(storageVault, s.uploadBudget, s.stopOnBudgetExhausted(actionCreateRP.ID, checkpoint, cancel))
+		}
+		// chunks stored before the backup was interrupted are not uploaded again
+		storageVault = storage_vault.SkipStored(storageVault, checkpoint.Stored)
+		// the request rate of a bucket is shared by actions, only throttles during this backup are reported
+		throttledBefore := storage_vault.ThrottledRequests(storageVault)
+		immutable, err := storage_vault.Immutable(ctx, storageVault)
+		if err != nil {
+			s.logger.Warn("Could not check object lock of storage vault", zap.Error(err))
+		}
+		scope, _ := s.checkVaultScope(ctx, storageVault)
 
 		// Scaning failed backup list
 		s.logger.Sugar().Info("Scanning failed backup list")
@@ -1067,7 +3007,7 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 		if listBackupFailed != nil {
 			// Uploading failed backup list to storage
 			s.logger.Sugar().Info("Uploading failed backup list to storage")
-			errUploadListBackupFailed := s.uploadListBackupFailed(listBackupFailed, storageVault)
+			errUploadListBackupFailed := s.uploadListBackupFailed(ctx, listBackupFailed, storageVault)
 			if errUploadListBackupFailed != nil {
 				errCh <- errUploadListBackupFailed
 				return
@@ -1082,10 +3022,35 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 		index := cache.NewIndex(bd.ID, rpID)
 		chunks := cache.NewChunk(bdID, rpID)
 
-		s.logger.Sugar().Infof("Scanning directory %s", backupDirectoryID)
-		itemTodo, totalFiles, err := WalkerDir(bd.Path, index, progressScan, s.logger)
+		var itemTodo progress.Stat
+		var totalFiles int64
+		f, err := backupFilter(bd, bd.Path, limits)
+		// files changed since the latest recovery point are found by the change journal of the volume, if any
+		var journal *journalScan
+		if err == nil && len(onlyPaths) == 0 && len(bd.Roots()) == 1 {
+			journal = s.startJournalScan(mcID, bd, lrp, limits)
+		}
+		scanCtx, scanSpan := tracing.Start(ctx, "scan", attribute.String("path", strings.Join(bd.Roots(), ",")))
+		if err == nil && len(onlyPaths) > 0 {
+			s.logger.Sugar().Infof("Scanning %d paths of directory %s", len(onlyPaths), backupDirectoryID)
+			itemTodo, totalFiles, err = ScanRoots(scanCtx, bd, limits, onlyPaths, index, progressScan, s.logger)
+		} else if journal.usable() {
+			// scanned once the latest index is loaded
+			s.logger.Sugar().Infof("Found %d paths changed in directory %s by change journal", len(journal.changes), backupDirectoryID)
+		} else if err == nil {
+			s.logger.Sugar().Infof("Scanning directory %s", backupDirectoryID)
+			itemTodo, totalFiles, err = ScanRoots(scanCtx, bd, limits, nil, index, progressScan, s.logger)
+		}
+		scanSpan.SetAttributes(attribute.Int64("files", totalFiles), attribute.Int64("bytes", int64(itemTodo.Bytes)))
+		tracing.End(scanSpan, err)
+		if ctx.Err() != nil {
+			// stopped by StopAction, which already notified the status
+			s.logger.Sugar().Infof("Scanning directory %s stopped", backupDirectoryID)
+			errCh <- backupapi.ErrorGotCancelRequest
+			return
+		}
 		if err != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, err.Error())
+			failed(err)
 			s.logger.Error("WalkerDir error", zap.Error(err))
 			errCh <- err
 			return
@@ -1102,12 +3067,17 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			errCh <- err
 			return
 		}
+		stopCheckpoint := s.startCheckpoint(cacheWriter, checkpoint)
+		defer stopCheckpoint()
+		// files unchanged since they were cached are not read again, even when the latest index is not available
+		checksums := cache.OpenChecksumCache(cachePath, mcID, bdID, actionCreateRP.StorageVault.ID)
+		detector = checksums.Detector(detector)
 
 		if lrp != nil {
 			// Store index
-			errStoreIndexs := s.storeIndexs(cachePath, mcID, lrp, storageVault)
+			errStoreIndexs := s.storeIndexs(ctx, cachePath, mcID, lrp, storageVault)
 			if errStoreIndexs != nil {
-				s.notifyStatusFailed(actionCreateRP.ID, errStoreIndexs.Error())
+				failed(errStoreIndexs)
 				errCh <- errStoreIndexs
 				return
 			}
@@ -1123,28 +3093,91 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			}
 		}
 
+		// base is the latest full index, the index of an incremental recovery point is stored as a delta against it
+		var base *cache.Index
+		var baseID, baseHash string
+		var previousDeltas int
+		if lrp != nil && latestIndex.IsDelta() {
+			full, fullBase, err := s.applyBase(ctx, &latestIndex, func() (storage_vault.StorageVault, error) { return storageVault, nil })
+			if err != nil {
+				s.logger.Warn("Load base index of latest recovery point error, all files are backed up", zap.Error(err))
+				lrp = nil
+				latestIndex = cache.Index{}
+			} else {
+				base, baseID, baseHash, previousDeltas = fullBase, latestIndex.Base, latestIndex.BaseHash, latestIndex.Sequence
+				latestIndex = *full
+			}
+		} else if lrp != nil {
+			base, baseID, baseHash = &latestIndex, lrp.ID, lrp.IndexHash
+		}
+		if base != nil && !s.baseKept(baseID) {
+			s.logger.Info("Base recovery point is deleted, a full index is saved", zap.String("base", baseID))
+			base = nil
+		}
+
+		if journal.usable() {
+			scanCtx, scanSpan := tracing.Start(ctx, "scan", attribute.String("path", bd.Path), attribute.Bool("journal", true))
+			err = errJournalFilterChanged
+			if lrp != nil {
+				itemTodo, totalFiles, err = journalIndex(scanCtx, bd.Path, &latestIndex, journal.changes, index, f, progressScan, s.logger)
+			}
+			if err != nil && ctx.Err() == nil {
+				s.logger.Info("Scan by change journal error, directory is walked", zap.Error(err), zap.String("backup_directory_id", backupDirectoryID))
+				index = cache.NewIndex(bd.ID, rpID)
+				itemTodo, totalFiles, err = WalkerDir(scanCtx, bd.Path, index, f, s.newProgressScanDir(rpID), s.logger)
+			}
+			scanSpan.SetAttributes(attribute.Int64("files", totalFiles), attribute.Int64("bytes", int64(itemTodo.Bytes)))
+			tracing.End(scanSpan, err)
+			if ctx.Err() != nil {
+				errCh <- backupapi.ErrorGotCancelRequest
+				return
+			}
+			if err != nil {
+				failed(err)
+				s.logger.Error("WalkerDir error", zap.Error(err))
+				errCh <- err
+				return
+			}
+		}
+
+		// in low-memory mode the latest index is spilled to disk, its items are read back one at a time
+		var spilled *cache.SpilledItems
+		if s.memoryMode.LowMemory && len(latestIndex.Items) > 0 {
+			spilled, err = cache.SpillItems(filepath.Join(cachePath, mcID, spillDir), latestIndex.Items)
+			if err != nil {
+				s.logger.Warn("Spill latest index error, it is kept in memory", zap.Error(err))
+			} else {
+				defer spilled.Close()
+				// the index is saved whole, as a delta index needs all items of its base in memory
+				latestIndex.Items, base = nil, nil
+			}
+		}
+
+		// changed files with the content of a file backed up reuse its chunks
+		contents := cache.NewContentMap()
+		contents.AddIndex(&latestIndex)
+		contents.AddChecksums(checksums)
+
 		pipe := make(chan *cache.Chunk)
+		packer := s.backupClient.NewPacker(storageVault, viper.GetInt("pack_size"), pipe, rpID, bdID)
+		// small chunks of the latest recovery point are reused from its packfiles
+		packer.AddIndex(&latestIndex)
 		done := make(chan bool)
 		go func() {
 			for {
 				receiver, more := <-pipe
 				if more {
-					key := reflect.ValueOf(receiver.Chunks).MapKeys()[0].Interface().(string)
-					if value, ok := chunks.Chunks[key]; ok {
-						count, errParseInt := strconv.Atoi(strings.Split(value[0], "-")[0])
-						if errParseInt != nil {
-							errCh <- errParseInt
-						}
-						chunks.Chunks[key] = []string{fmt.Sprintf("%s-%s", strconv.Itoa(count+1), receiver.Chunks[key][1])}
-					} else {
-						chunks.Chunks[key] = []string{fmt.Sprintf("%s-%s", strconv.Itoa(1), receiver.Chunks[key][1])}
+					key, errParseInt := addChunk(chunks, receiver)
+					checkpoint.AddChunk(key)
+					if errParseInt != nil {
+						errCh <- errParseInt
 					}
 
 					if time.Now().Minute()%5 == 0 && time.Now().Second() == 0 {
 						// Save chunks to chunk.json
 						errSaveChunks := cacheWriter.SaveChunk(chunks)
 						if errSaveChunks != nil {
-							s.notifyStatusFailed(actionCreateRP.ID, errSaveChunks.Error())
+							failed(errSaveChunks)
 							errCh <- errSaveChunks
 							return
 						}
@@ -1157,12 +3190,23 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			}
 		}()
 
+		// Rows of file.csv are written as items are uploaded
+		fileList, err := cacheWriter.NewFileList()
+		if err != nil {
+			failed(err)
+			errCh <- err
+			return
+		}
+		defer fileList.Close()
+
 		var storageSize uint64
 		var errFileWorker error
 		progressUpload := s.newUploadProgress(rpID, itemTodo)
 
 		var wg sync.WaitGroup
+		var hardLinks []*cache.Node
 
+		s.feed.phase(actionCreateRP.ID, phaseUploading)
 		progressUpload.Start()
 		defer progressUpload.Cancel()
 
@@ -1183,31 +3227,67 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 				st.Items = 1
 				progressUpload.Report(st)
 
-				if itemInfo.Type == "file" {
+				if index.HardLinkTarget(itemInfo) != nil {
+					// content is backed up with the target of hard link
+					hardLinks = append(hardLinks, itemInfo)
+					progressUpload.Report(progress.Stat{Bytes: itemInfo.Size})
+				} else if itemInfo.Type == "file" {
 					lastInfo := latestIndex.Items[itemInfo.AbsolutePath]
+					if spilled != nil {
+						lastInfo = spilled.Get(itemInfo.AbsolutePath)
+					}
+					if cached := checksums.Lookup(itemInfo); cached != nil {
+						lastInfo = cached
+					}
+					if item := checkpoint.Item(itemInfo.AbsolutePath); item != nil {
+						// backed up before the backup was interrupted
+						lastInfo = item
+					}
 					wg.Add(1)
-					_ = s.pool.Submit(s.uploadFileWorker(ctx, itemInfo, lastInfo, cacheWriter, storageVault, &wg, &storageSize, &errFileWorker, progressUpload, pipe, rpID, bdID))
+					_ = queues.files.Submit(s.uploadFileWorker(ctx, itemInfo, lastInfo, cacheWriter, fileList, checkpoint, storageVault, diskLimiter, chunking, packer, queues.chunks, detector, contents, &wg, &storageSize, &errFileWorker, progressUpload, pipe, rpID, bdID))
+				} else if err := fileList.Add(itemInfo); err != nil {
+					errFileWorker = err
 				}
 			}
 		}
 		go func() {
 			wg.Wait()
+			// chunks of files backed up are stored once the open packfile is
+			if err := packer.Flush(ctx); err != nil && errFileWorker == nil {
+				errFileWorker = err
+			}
 			close(pipe)
 		}()
 		<-done
+		s.feed.phase(actionCreateRP.ID, phaseFinalizing)
+
+		for _, link := range hardLinks {
+			target := index.HardLinkTarget(link)
+			link.Content, link.Sha256Hash, link.Sparse = target.Content, target.Sha256Hash, target.Sparse
+			link.ChunkMap, link.Chunks = target.ChunkMap, target.Chunks
+			if err := fileList.Add(link); err != nil && errFileWorker == nil {
+				errFileWorker = err
+			}
+		}
 
 		s.logger.Sugar().Info("Save all chunks to chunk.json")
 		errSaveChunks := cacheWriter.SaveChunk(chunks)
 		if errSaveChunks != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, errSaveChunks.Error())
+			failed(errSaveChunks)
 			errCh <- errSaveChunks
 			return
 		}
+		chunkHash, errHashChunks := sha256File(filepath.Join(cachePath, mcID, rpID, "chunk.json"))
+		if errHashChunks != nil {
+			failed(errHashChunks)
+			errCh <- errHashChunks
+			return
+		}
 
 		// Store files
-		errWriterCSV := s.storeFiles(cachePath, mcID, rpID, index, storageVault)
+		errWriterCSV := fileList.Close()
 		if errWriterCSV != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, errWriterCSV.Error())
+			failed(errWriterCSV)
 			errCh <- errWriterCSV
 			return
 		}
@@ -1234,18 +3314,18 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 
 		// Put chunks
 		s.logger.Sugar().Info("Put chunk.json to storage", zap.String("key", filepath.Join(mcID, rpID, "chunk.json")))
-		errPutChunks := s.putChunks(cachePath, mcID, rpID, chunkFailedPath, storageVault)
+		errPutChunks := s.putChunks(ctx, cachePath, mcID, rpID, chunkFailedPath, metadataVault)
 		if errPutChunks != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, errPutChunks.Error())
+			failed(errPutChunks)
 			errCh <- errPutChunks
 			return
 		}
 
 		// Put file.csv
 		s.logger.Sugar().Info("Put file.csv to storage", zap.String("key", filepath.Join(mcID, rpID, "file.csv")))
-		errPutFiles := s.putFiles(cachePath, mcID, rpID, fileFailedPath, storageVault)
+		errPutFiles := s.putFiles(ctx, cachePath, mcID, rpID, fileFailedPath, metadataVault)
 		if errPutFiles != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, errPutFiles.Error())
+			failed(errPutFiles)
 			errCh <- errPutFiles
 			return
 		}
@@ -1260,9 +3340,9 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 
 		if errFileWorker != nil {
 			if err != nil {
-				s.notifyStatusFailed(actionCreateRP.ID, err.Error())
+				failed(err)
 			} else {
-				s.notifyStatusFailed(actionCreateRP.ID, errFileWorker.Error())
+				failed(errFileWorker)
 			}
 			s.logger.Error("Error uploadFileWorker error", zap.Error(errFileWorker))
 			progressUpload.Done()
@@ -1270,23 +3350,39 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			return
 		}
 
+		s.putInventory(ctx, metadataVault, mcID, rpID)
+		s.putInstanceMetadata(ctx, metadataVault, mcID, rpID)
+
 		// Save Indexs
-		err = cacheWriter.SaveIndex(index)
+		savedIndex := indexToSave(index, actionCreateRP.RecoveryPoint.RecoveryPointType, base, baseID, baseHash, previousDeltas)
+		savedIndex.ChunkHash = chunkHash
+		if savedIndex.IsDelta() {
+			s.logger.Info("Save delta index", zap.String("base", baseID), zap.Int("items", len(savedIndex.Items)), zap.Int("removed", len(savedIndex.Removed)))
+		}
+		err = cacheWriter.SaveIndex(savedIndex)
 		if err != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, err.Error())
+			failed(err)
 			errCh <- err
 			return
 		}
 
 		// Put indexs
 		s.logger.Sugar().Info("Put index.json to storage", zap.String("key", filepath.Join(mcID, rpID, "index.json")))
-		indexHash, errPutIndexs := s.putIndexs(storageVault, latestIndex, cachePath, mcID, rpID)
+		indexHash, errPutIndexs := s.putIndexs(ctx, metadataVault, latestIndex, cachePath, mcID, rpID)
 		if errPutIndexs != nil {
-			s.notifyStatusFailed(actionCreateRP.ID, errPutIndexs.Error())
+			failed(errPutIndexs)
 			errCh <- errPutIndexs
 			return
 		}
-		if lrp != nil {
+		checksums.Update(index, len(onlyPaths) > 0)
+		if err := checksums.Save(); err != nil {
+			s.logger.Warn("Save checksum cache error, files are read again by next backup", zap.Error(err))
+		}
+		if err := journal.save(rpID); err != nil {
+			s.logger.Warn("Save change journal cursor error, directory is walked by next backup", zap.Error(err))
+		}
+		if lrp != nil && lrp.ID != savedIndex.Base {
+			// the base index is kept in cache for next incremental recovery points
 			err := os.RemoveAll(filepath.Join(cachePath, mcID, lrp.ID))
 			if err != nil {
 				errCh <- err
@@ -1306,12 +3402,16 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 			s.reportUploadCompleted(progressOutput)
 			progressUpload.Done()
 			s.notifyMsg(map[string]string{
-				"action_id":    actionCreateRP.ID,
-				"status":       statusComplete,
-				"index_hash":   indexHash,
-				"storage_size": strconv.FormatUint(storageSize, 10),
-				"total":        strconv.FormatUint(itemTodo.Bytes, 10),
-				"total_files":  strconv.Itoa(int(totalFiles)),
+				"action_id":              actionCreateRP.ID,
+				"status":                 statusComplete,
+				"index_hash":             indexHash,
+				"base_recovery_point_id": savedIndex.Base,
+				"storage_size":           strconv.FormatUint(storageSize, 10),
+				"total":                  strconv.FormatUint(itemTodo.Bytes, 10),
+				"total_files":            strconv.Itoa(int(totalFiles)),
+				"throttled_requests":     strconv.FormatInt(storage_vault.ThrottledRequests(storageVault)-throttledBefore, 10),
+				"immutable":              strconv.FormatBool(immutable),
+				"over_privileged":        strconv.FormatBool(scope.OverPrivileged),
 			})
 		}
 
@@ -1319,65 +3419,87 @@ func (s *Server) backupWorker(ctx context.Context, actionCreateRP *backupapi.Cre
 	}
 }
 
-func (s *Server) storeIndexs(cachePath, mcID string, lrp *backupapi.RecoveryPointResponse, storageVault storage_vault.StorageVault) error {
+// addChunk counts chunk received from the pipe of a backup in chunks, saved as chunk.json, and returns its key.
+func addChunk(chunks, receiver *cache.Chunk) (string, error) {
+	key := reflect.ValueOf(receiver.Chunks).MapKeys()[0].Interface().(string)
+	value, ok := chunks.Chunks[key]
+	if !ok {
+		chunks.Chunks[key] = []string{fmt.Sprintf("%s-%s", strconv.Itoa(1), receiver.Chunks[key][1])}
+		return key, nil
+	}
+	parts := strings.Split(value[0], "-")
+	count, errParseInt := strconv.Atoi(parts[0])
+	size := receiver.Chunks[key][1]
+	if receiver.Packed && len(parts) == 2 {
+		// chunks of a packfile report their own size, the size recorded for the packfile is kept
+		size = parts[1]
+	}
+	chunks.Chunks[key] = []string{fmt.Sprintf("%s-%s", strconv.Itoa(count+1), size)}
+	return key, errParseInt
+}
+
+func (s *Server) storeIndexs(ctx context.Context, cachePath, mcID string, lrp *backupapi.RecoveryPointResponse, storageVault storage_vault.StorageVault) error {
 	_, err := os.Stat(filepath.Join(cachePath, mcID, lrp.ID, "index.json"))
 	if err != nil {
 		if os.IsNotExist(err) {
-			buf, err := storageVault.GetObject(filepath.Join(mcID, lrp.ID, "index.json"))
-			if err == nil {
-				_ = os.MkdirAll(filepath.Join(cachePath, mcID, lrp.ID), 0700)
-				if err := ioutil.WriteFile(filepath.Join(cachePath, mcID, lrp.ID, "index.json"), buf, 0700); err != nil {
-					return err
-				}
-			} else {
+			_ = os.MkdirAll(filepath.Join(cachePath, mcID, lrp.ID), 0700)
+			err := storage_vault.GetFile(ctx, storageVault, filepath.Join(mcID, lrp.ID, "index.json"), filepath.Join(cachePath, mcID, lrp.ID, "index.json"), 0700)
+			if err != nil {
 				lrp = nil
 			}
 		} else {
 			return err
 		}
 	} else {
-		buf, err := ioutil.ReadFile(filepath.Join(cachePath, mcID, lrp.ID, "index.json"))
+		indexHash, err := sha256File(filepath.Join(cachePath, mcID, lrp.ID, "index.json"))
 		if err != nil {
 			return err
 		}
-		hash := sha256.Sum256(buf)
-		if hex.EncodeToString(hash[:]) != lrp.IndexHash {
+		if indexHash != lrp.IndexHash {
 			lrp = nil
 		}
 	}
 	return nil
 }
 
-func (s *Server) putIndexs(storageVault storage_vault.StorageVault, latestIndex cache.Index, cachePath, mcID, rpID string) (string, error) {
-	buf, err := ioutil.ReadFile(filepath.Join(cachePath, mcID, rpID, "index.json"))
+// sha256File returns hex encoded sha256 of the file content, the file is read in a streaming manner.
+func sha256File(name string) (string, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		s.logger.Error("Read indexs error", zap.Error(err))
 		return "", err
 	}
-	err = storageVault.PutObject(filepath.Join(mcID, rpID, "index.json"), buf)
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *Server) putIndexs(ctx context.Context, storageVault storage_vault.StorageVault, latestIndex cache.Index, cachePath, mcID, rpID string) (string, error) {
+	name := filepath.Join(cachePath, mcID, rpID, "index.json")
+	err := storage_vault.PutFile(ctx, storageVault, filepath.Join(mcID, rpID, "index.json"), name)
 	if err != nil {
 		s.logger.Error("Put indexs to storage error", zap.Error(err))
 		os.RemoveAll(filepath.Join(cachePath, mcID, rpID))
 		return "", err
 	}
-	hash := sha256.Sum256(buf)
-	indexHash := hex.EncodeToString(hash[:])
+	indexHash, err := sha256File(name)
+	if err != nil {
+		s.logger.Error("Read indexs error", zap.Error(err))
+		return "", err
+	}
 
 	return indexHash, nil
 }
 
-func (s *Server) putChunks(cachePath, mcID, rpID, chunkPath string, storageVault storage_vault.StorageVault) error {
+func (s *Server) putChunks(ctx context.Context, cachePath, mcID, rpID, chunkPath string, storageVault storage_vault.StorageVault) error {
 	if chunkPath == "" {
 		chunkPath = filepath.Join(cachePath, mcID, rpID, "chunk.json")
 	} else {
 		chunkPath = filepath.Join(BACKUP_FAILED_PATH, mcID, rpID, "chunk.json")
 	}
-	buf, err := ioutil.ReadFile(chunkPath)
-	if err != nil {
-		s.logger.Error("Read chunk.json error", zap.Error(err))
-		return err
-	}
-	err = storageVault.PutObject(filepath.Join(mcID, rpID, "chunk.json"), buf)
+	err := storage_vault.PutFile(ctx, storageVault, filepath.Join(mcID, rpID, "chunk.json"), chunkPath)
 	if err != nil {
 		s.logger.Error("Put chunk.json to storage error", zap.Error(err))
 		return err
@@ -1386,14 +3508,9 @@ func (s *Server) putChunks(cachePath, mcID, rpID, chunkPath string, storageVault
 }
 
 // Upload list backup failed to storage
-func (s *Server) uploadListBackupFailed(listBackupFailed []string, storageVault storage_vault.StorageVault) error {
+func (s *Server) uploadListBackupFailed(ctx context.Context, listBackupFailed []string, storageVault storage_vault.StorageVault) error {
 	for _, fileFailed := range listBackupFailed {
-		buf, err := ioutil.ReadFile(filepath.Join(BACKUP_FAILED_PATH, fileFailed))
-		if err != nil {
-			s.logger.Error("Read file error ", zap.Error(err))
-			return err
-		}
-		err = storageVault.PutObject(fileFailed, buf)
+		err := storage_vault.PutFile(ctx, storageVault, fileFailed, filepath.Join(BACKUP_FAILED_PATH, fileFailed))
 		if err != nil {
 			s.logger.Error("Put file to storage error ", zap.Error(err))
 			return err
@@ -1406,54 +3523,13 @@ func (s *Server) uploadListBackupFailed(listBackupFailed []string, storageVault
 	return nil
 }
 
-func (s *Server) storeFiles(cachePath, mcID string, rpID string, index *cache.Index, storageVault storage_vault.StorageVault) error {
-	if _, err := os.Stat(filepath.Dir(filepath.Join(cachePath, mcID, rpID, "file.csv"))); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(filepath.Join(cachePath, mcID, rpID, "file.csv")), 0700); err != nil {
-			s.logger.Error("Err make dir dir file.csv", zap.Error(err))
-			return err
-		}
-	}
-	file, err := os.Create(filepath.Join(cachePath, mcID, rpID, "file.csv"))
-	if err != nil {
-		s.logger.Error("Err Create file.csv", zap.Error(err))
-		return err
-	}
-	defer file.Close()
-	writerCSV := csv.NewWriter(file)
-	defer writerCSV.Flush()
-	errWriteCSV := writerCSV.Write([]string{"name", "hash", "path", "size", "type", "modify_time"})
-	if errWriteCSV != nil {
-		return errWriteCSV
-	}
-	for _, itemInfo := range index.Items {
-		itemHash := ""
-		var itemSize uint64
-		itemModifiedTime := itemInfo.ModTime.String()
-		if itemInfo.Type == "file" {
-			itemHash = itemInfo.Sha256Hash.String()
-			itemSize = itemInfo.Size
-		}
-		err := writerCSV.Write([]string{itemInfo.Name, itemHash, itemInfo.AbsolutePath, strconv.FormatUint(itemSize, 10), itemInfo.Type, itemModifiedTime})
-		if err != nil {
-			s.logger.Error("Err writer file.csv", zap.Error(err))
-			return err
-		}
-	}
-	return nil
-}
-
-func (s *Server) putFiles(cachePath, mcID, rpID string, filePath string, storageVault storage_vault.StorageVault) error {
+func (s *Server) putFiles(ctx context.Context, cachePath, mcID, rpID string, filePath string, storageVault storage_vault.StorageVault) error {
 	if filePath == "" {
 		filePath = filepath.Join(cachePath, mcID, rpID, "file.csv")
 	} else {
 		filePath = filepath.Join(BACKUP_FAILED_PATH, mcID, rpID, "file.csv")
 	}
-	buf, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		s.logger.Error("Read file.csv error", zap.Error(err))
-		return err
-	}
-	err = storageVault.PutObject(filepath.Join(mcID, rpID, "file.csv"), buf)
+	err := storage_vault.PutFile(ctx, storageVault, filepath.Join(mcID, rpID, "file.csv"), filePath)
 	if err != nil {
 		s.logger.Error("Put file.csv error", zap.Error(err))
 		return err
@@ -1463,15 +3539,17 @@ func (s *Server) putFiles(cachePath, mcID, rpID string, filePath string, storage
 
 func (s *Server) newProgressScanDir(recoverypointID string) *progress.Progress {
 	p := progress.NewProgress(time.Second)
+	// scan stats have no percent, they are batched into digests of progress topic
+	topic := s.publishTopics[1] + "/" + recoverypointID
 	p.OnUpdate = func(stat progress.Stat, d time.Duration, ticker bool) {
-		s.notifyMsgProgress(recoverypointID, map[string]string{
+		s.digest.add(topic, "scan", map[string]string{
 			"STATISTIC": stat.String(),
-		})
+		}, digestInterval())
 	}
 	p.OnDone = func(stat progress.Stat, d time.Duration, ticker bool) {
-		s.notifyMsgProgress(recoverypointID, map[string]string{
+		s.digest.add(topic, "scan", map[string]string{
 			"SCANNED": stat.String(),
-		})
+		}, digestInterval())
 	}
 	return p
 }
@@ -1505,6 +3583,7 @@ func (s *Server) newUploadProgress(recoveryPointID string, todo progress.Stat) *
 				"speed":             formatBytes(bps),
 				"total":             fmt.Sprintf("%s/%s", formatBytes(stat.Bytes), formatBytes(todo.Bytes)),
 				"push_storage":      formatBytes(stat.Storage),
+				"phase":             phaseUploading,
 				"items":             fmt.Sprintf("%s/%s", strItemsDone, strItemsTodo),
 				"erros":             strconv.FormatBool(stat.Errors),
 				"eta":               formatSeconds(eta),
@@ -1558,6 +3637,7 @@ func (s *Server) newDownloadProgress(recoveryPointID string, todo progress.Stat)
 				"speed":             formatBytes(bps),
 				"total":             fmt.Sprintf("%s/%s", formatBytes(stat.Bytes), formatBytes(todo.Bytes)),
 				"pull_storage":      formatBytes(stat.Storage),
+				"phase":             phaseDownloading,
 				"items":             fmt.Sprintf("%s/%s", strItemsDone, strItemsTodo),
 				"erros":             strconv.FormatBool(stat.Errors),
 				"eta":               formatSeconds(eta),
@@ -1582,6 +3662,67 @@ func (s *Server) newDownloadProgress(recoveryPointID string, todo progress.Stat)
 	return p
 }
 
+// newPriorityDownloadProgress reports progress of the priority items of a restore separately.
+func (s *Server) newPriorityDownloadProgress(recoveryPointID string, todo progress.Stat) *progress.Progress {
+	p := progress.NewProgress(intervalPushProgress)
+
+	p.OnUpdate = func(stat progress.Stat, d time.Duration, ticker bool) {
+		if !ticker {
+			return
+		}
+		s.notifyMsgProgress(recoveryPointID, map[string]string{
+			"priority_duration": formatDuration(d),
+			"priority_percent":  formatPercent(stat.Bytes, todo.Bytes),
+			"priority_total":    fmt.Sprintf("%s/%s", formatBytes(stat.Bytes), formatBytes(todo.Bytes)),
+			"priority_items":    fmt.Sprintf("%d/%d", stat.Items, todo.Items),
+			"priority_errors":   strconv.FormatBool(stat.Errors),
+			"recovery_point_id": recoveryPointID,
+		})
+	}
+
+	p.OnDone = func(stat progress.Stat, d time.Duration, ticker bool) {
+		message := fmt.Sprintf("Duration: %s, %d/%d items", d, stat.Items, todo.Items)
+		s.notifyMsgProgress(recoveryPointID, map[string]string{
+			"COMPLETE PRIORITY DOWNLOAD": message,
+		})
+	}
+	return p
+}
+
+// newMetadataProgress reports progress of applying metadata to the directories restored, by items.
+func (s *Server) newMetadataProgress(recoveryPointID string, todo progress.Stat) *progress.Progress {
+	p := progress.NewProgress(intervalPushProgress)
+
+	p.OnUpdate = func(stat progress.Stat, d time.Duration, ticker bool) {
+		if !ticker {
+			return
+		}
+		var eta uint64
+		if sec := uint64(d / time.Second); stat.Items > 0 && stat.Items < todo.Items {
+			eta = (todo.Items - stat.Items) * sec / stat.Items
+		}
+		s.notifyMsgProgress(recoveryPointID, map[string]string{
+			"duration":          formatDuration(d),
+			"percent":           formatPercent(stat.Items, todo.Items),
+			"phase":             phaseApplyingMetadata,
+			"items":             fmt.Sprintf("%d/%d", stat.Items, todo.Items),
+			"eta":               formatSeconds(eta),
+			"recovery_point_id": recoveryPointID,
+		})
+	}
+	return p
+}
+
+// formatLabels returns labels as "key=value" pairs sorted by key and separated by comma.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 func formatBytes(c uint64) string {
 	b := float64(c)
 
@@ -1709,17 +3850,19 @@ func (s *Server) getDirectorySize() error {
 
 	if len(lbd.Directories) != 0 {
 		for _, item := range lbd.Directories {
-			err := filepath.Walk(item.Path, func(_ string, fi os.FileInfo, err error) error {
+			for _, root := range item.Roots() {
+				err := filepath.Walk(root, func(_ string, fi os.FileInfo, err error) error {
+					if err != nil {
+						return err
+					}
+					if !fi.IsDir() {
+						size += fi.Size()
+					}
+					return nil
+				})
 				if err != nil {
 					return err
 				}
-				if !fi.IsDir() {
-					size += fi.Size()
-				}
-				return nil
-			})
-			if err != nil {
-				return err
 			}
 
 			dir := backupapi.Directories{
@@ -1730,15 +3873,32 @@ func (s *Server) getDirectorySize() error {
 		}
 		state.EventType = "agent_update_state"
 
-		// Send msg to server via mqtt
-		s.notifyMsg(state)
+		// Send msg to server via mqtt, only the latest sizes of a digest are kept
+		s.digest.add(s.publishTopics[0], state.EventType, state, digestInterval())
 	}
 	return nil
 }
 
+// removeTempFiles removes temp files left in cache directory by failed writes.
+func (s *Server) removeTempFiles() {
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		s.logger.Error("CheckPath error", zap.Error(err))
+		return
+	}
+	n, err := cache.RemoveTempFiles(cachePath, cache.TempFileMaxAge)
+	if err != nil {
+		s.logger.Error("RemoveTempFiles error", zap.Error(err))
+	}
+	if n > 0 {
+		s.logger.Info("Removed orphaned cache temp files", zap.Int("count", n))
+	}
+}
+
 func (s *Server) schedule(timeSchedule time.Duration, index int) {
 	ticker := time.NewTicker(timeSchedule)
 	go func() {
+		defer s.crashReporter.Recover()
 		for {
 			switch index {
 			case 1:
@@ -1747,6 +3907,7 @@ func (s *Server) schedule(timeSchedule time.Duration, index int) {
 				if err := cache.RemoveOldCache(maxCacheAgeDefault); err != nil {
 					s.logger.Error(err.Error())
 				}
+				s.removeTempFiles()
 			case 2:
 				<-ticker.C
 				s.logger.Sugar().Info("Update size of directory")
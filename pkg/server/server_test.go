@@ -1,12 +1,17 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"syscall"
 	"testing"
@@ -16,12 +21,12 @@ import (
 	"github.com/bizflycloud/bizfly-backup/pkg/broker"
 	"github.com/bizflycloud/bizfly-backup/pkg/broker/mqtt"
 	"github.com/bizflycloud/bizfly-backup/pkg/cache"
-	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+	"github.com/bizflycloud/bizfly-backup/pkg/filter"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
 
-	"github.com/go-chi/chi"
 	"github.com/ory/dockertest/v3"
-	"github.com/panjf2000/ants/v2"
 	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -236,75 +241,163 @@ func TestServerCron(t *testing.T) {
 	}
 }
 
-func TestServer_storeFiles(t *testing.T) {
-	type fields struct {
-		Addr                 string
-		router               *chi.Mux
-		b                    broker.Broker
-		subscribeTopics      []string
-		publishTopics        []string
-		useUnixSock          bool
-		backupClient         *backupapi.Client
-		cronManager          *cron.Cron
-		mappingToCronEntryID map[string]cron.EntryID
-		testSignalCh         chan os.Signal
-		poolDir              *ants.Pool
-		pool                 *ants.Pool
-		chunkPool            *ants.Pool
-		logger               *zap.Logger
+func TestServerCronRefresh(t *testing.T) {
+	s, err := New()
+	require.NoError(t, err)
+
+	bd := func(id string, activated bool, policies ...backupapi.BackupDirectoryConfigPolicy) backupapi.BackupDirectoryConfig {
+		return backupapi.BackupDirectoryConfig{ID: id, Path: "/" + id, Policies: policies, Activated: activated}
 	}
-	type args struct {
-		cachePath    string
-		mcID         string
-		rpID         string
-		index        *cache.Index
-		storageVault storage_vault.StorageVault
+	policy1 := backupapi.BackupDirectoryConfigPolicy{ID: "policy_1", SchedulePattern: "@daily"}
+	policy2 := backupapi.BackupDirectoryConfigPolicy{ID: "policy_2", SchedulePattern: "0 1 * * *"}
+
+	require.NoError(t, s.handleConfigRefresh([]backupapi.BackupDirectoryConfig{bd("dir1", true, policy1), bd("dir2", true, policy2)}))
+	require.Len(t, s.mappingToCronEntryID, 2)
+	unchanged := s.mappingToCronEntryID[mappingID("dir1", "policy_1")]
+	changed := s.mappingToCronEntryID[mappingID("dir2", "policy_2")]
+
+	policy2.SchedulePattern = "0 2 * * *"
+	policy3 := backupapi.BackupDirectoryConfigPolicy{ID: "policy_3", SchedulePattern: "@weekly"}
+	require.NoError(t, s.handleConfigRefresh([]backupapi.BackupDirectoryConfig{bd("dir1", true, policy1), bd("dir2", true, policy2), bd("dir3", false, policy3)}))
+	require.Len(t, s.mappingToCronEntryID, 2)
+	assert.Equal(t, unchanged, s.mappingToCronEntryID[mappingID("dir1", "policy_1")])
+	assert.NotEqual(t, changed, s.mappingToCronEntryID[mappingID("dir2", "policy_2")])
+	assert.Len(t, s.cronManager.Entries(), 2)
+
+	require.NoError(t, s.handleConfigRefresh(nil))
+	assert.Empty(t, s.mappingToCronEntryID)
+	assert.Empty(t, s.cronEntryConfigs)
+	assert.Empty(t, s.cronManager.Entries())
+}
+
+func TestServer_UpgradeAgentDisabled(t *testing.T) {
+	s, err := New(WithAddr("http://localhost:"+strconv.Itoa(defaultTestPort)), WithBroker(b))
+	require.NoError(t, err)
+
+	autoUpgrade := false
+	require.NoError(t, s.handleConfigUpdate(broker.Message{
+		EventType:   broker.ConfigUpdate,
+		Action:      broker.ConfigUpdateActionAutoUpgrade,
+		AutoUpgrade: &autoUpgrade,
+	}))
+	defer viper.Set("auto_upgrade", true)
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/upgrade/", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, ErrAutoUpgradeDisabled.Error(), w.Body.String())
+	assert.ErrorIs(t, s.doUpgrade(), ErrAutoUpgradeDisabled)
+}
+
+func TestWalkerPaths(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "sub"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "b"), 0700))
+	for _, name := range []string{"a/sub/1", "a/2", "b/3", "4"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600))
 	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		{
-			name: "test witer file csv",
-			fields: fields{
-				Addr: "http://localhost:" + strconv.Itoa(defaultTestPort),
-			},
-			args: args{
-				cachePath: "cache",
-				mcID:      "1",
-				rpID:      "1",
-				index: &cache.Index{
-					BackupDirectoryID: "1",
-					RecoveryPointID:   "1",
-					TotalFiles:        1,
-				},
-			},
-			wantErr: false,
-		},
+
+	index := cache.NewIndex("bd", "rp")
+	st, totalFiles, err := WalkerPaths(context.Background(), dir, []string{"a", filepath.Join(dir, "a", "sub"), "4"}, index, nil, progress.NewProgress(time.Second), zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), totalFiles)
+	assert.Equal(t, uint64(5), st.Items)
+	assert.Contains(t, index.Items, filepath.Join(dir, "a", "sub", "1"))
+	assert.Contains(t, index.Items, filepath.Join(dir, "4"))
+	assert.NotContains(t, index.Items, filepath.Join(dir, "b", "3"))
+	assert.Equal(t, filepath.Join(filepath.Base(dir), "a", "2"), index.Items[filepath.Join(dir, "a", "2")].RelativePath)
+
+	_, _, err = WalkerPaths(context.Background(), dir, []string{"../outside"}, cache.NewIndex("bd", "rp"), nil, progress.NewProgress(time.Second), zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestScanRoots(t *testing.T) {
+	parent := t.TempDir()
+	etc, www := filepath.Join(parent, "etc"), filepath.Join(parent, "srv", "www")
+	require.NoError(t, os.MkdirAll(filepath.Join(etc, "nginx"), 0700))
+	require.NoError(t, os.MkdirAll(www, 0700))
+	for _, name := range []string{"etc/nginx/nginx.conf", "etc/hosts", "etc/app.log", "srv/www/index.html"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(parent, name), []byte(name), 0600))
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &Server{
-				Addr:                 tt.fields.Addr,
-				router:               tt.fields.router,
-				b:                    tt.fields.b,
-				subscribeTopics:      tt.fields.subscribeTopics,
-				publishTopics:        tt.fields.publishTopics,
-				useUnixSock:          tt.fields.useUnixSock,
-				backupClient:         tt.fields.backupClient,
-				cronManager:          tt.fields.cronManager,
-				mappingToCronEntryID: tt.fields.mappingToCronEntryID,
-				testSignalCh:         tt.fields.testSignalCh,
-				poolDir:              tt.fields.poolDir,
-				pool:                 tt.fields.pool,
-				chunkPool:            tt.fields.chunkPool,
-				logger:               tt.fields.logger,
-			}
-			if err := s.storeFiles(tt.args.cachePath, tt.args.mcID, tt.args.rpID, tt.args.index, tt.args.storageVault); (err != nil) != tt.wantErr {
-				t.Errorf("Server.writeFileCSV() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+	require.NoError(t, ioutil.WriteFile(filepath.Join(www, filter.IgnoreFile), []byte("*.html\n"), 0600))
+	bd := &backupapi.BackupDirectory{ID: "bd", Path: etc, Paths: []string{etc, www}, ExcludePatterns: []string{"*.log"}}
+
+	// roots are backed up into one index, each filtered by its own ignore file
+	index := cache.NewIndex("bd", "rp")
+	_, totalFiles, err := ScanRoots(context.Background(), bd, filter.Limits{}, nil, index, nil, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), totalFiles)
+	assert.Equal(t, filepath.Join("etc", "nginx", "nginx.conf"), index.Items[filepath.Join(etc, "nginx", "nginx.conf")].RelativePath)
+	assert.Equal(t, filepath.Join("www", filter.IgnoreFile), index.Items[filepath.Join(www, filter.IgnoreFile)].RelativePath)
+	assert.NotContains(t, index.Items, filepath.Join(etc, "app.log"))
+	assert.NotContains(t, index.Items, filepath.Join(www, "index.html"))
+
+	// paths are relative to the parent of roots
+	index = cache.NewIndex("bd", "rp")
+	_, totalFiles, err = ScanRoots(context.Background(), bd, filter.Limits{}, []string{filepath.Join("etc", "nginx"), www}, index, nil, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), totalFiles)
+	assert.Contains(t, index.Items, filepath.Join(etc, "nginx", "nginx.conf"))
+	assert.NotContains(t, index.Items, filepath.Join(etc, "hosts"))
+
+	_, _, err = ScanRoots(context.Background(), bd, filter.Limits{}, []string{"nginx"}, cache.NewIndex("bd", "rp"), nil, zap.NewNop())
+	assert.Error(t, err)
+
+	// roots with the same name would be restored to the same paths
+	bd.Paths = []string{etc, filepath.Join(www, "etc")}
+	_, _, err = ScanRoots(context.Background(), bd, filter.Limits{}, nil, cache.NewIndex("bd", "rp"), nil, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestWalkerDirFilter(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "node_modules", "pkg"), 0700))
+	for _, name := range []string{"node_modules/pkg/index.js", "app.log", "keep.log", "main.go"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0600))
 	}
+	f, err := filter.New([]string{"*.log", "node_modules/"}, []string{"keep.log"})
+	require.NoError(t, err)
+
+	index := cache.NewIndex("bd", "rp")
+	_, totalFiles, err := WalkerDir(context.Background(), dir, index, f, progress.NewProgress(time.Second), zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), totalFiles)
+	assert.Contains(t, index.Items, filepath.Join(dir, "main.go"))
+	assert.Contains(t, index.Items, filepath.Join(dir, "keep.log"))
+	assert.NotContains(t, index.Items, filepath.Join(dir, "app.log"))
+	assert.NotContains(t, index.Items, filepath.Join(dir, "node_modules"))
+}
+
+func TestWalkerDirCanceled(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a"), []byte("a"), 0600))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := WalkerDir(ctx, dir, cache.NewIndex("bd", "rp"), nil, progress.NewProgress(time.Second), zap.NewNop())
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	index := cache.NewIndex("bd", "rp")
+	index.Items[filepath.Join(dir, "a")] = &cache.Node{Type: "file", AbsolutePath: filepath.Join(dir, "a")}
+	_, err = WalkerItem(ctx, index, progress.NewProgress(time.Second), zap.NewNop())
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestPriorityDownloadProgress(t *testing.T) {
+	ob := &offlineBroker{}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine", "agent/machine/progress"}
+
+	p := s.newPriorityDownloadProgress("rp1", progress.Stat{Items: 2, Bytes: 100})
+	p.OnUpdate(progress.Stat{Items: 1, Bytes: 50}, time.Second, true)
+	p.OnDone(progress.Stat{Items: 2, Bytes: 100}, 2*time.Second, false)
+
+	require.Len(t, ob.published, 2)
+	var msg map[string]string
+	require.NoError(t, json.Unmarshal([]byte(ob.published[0]), &msg))
+	assert.Equal(t, "50.00%", msg["priority_percent"])
+	assert.Equal(t, "1/2", msg["priority_items"])
+	require.NoError(t, json.Unmarshal([]byte(ob.published[1]), &msg))
+	assert.Contains(t, msg["COMPLETE PRIORITY DOWNLOAD"], "2/2 items")
 }
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// agentStateFile keeps in-memory state of agent in cache directory while agent restarts to upgrade.
+const agentStateFile = "agent_state.json"
+
+const (
+	// maxPendingMessages bounds the notifications kept while broker is unreachable, the oldest are dropped.
+	maxPendingMessages = 1000
+	// maxCatchUpDelay is how long after a restart a backup scheduled while agent restarted is still run.
+	maxCatchUpDelay = time.Hour
+)
+
+// ErrInterruptedByRestart is reported for actions which were running when agent restarted.
+var ErrInterruptedByRestart = errors.New("interrupted by agent restart")
+
+// agentState is the in-memory state of agent saved before it restarts to upgrade and loaded by the new agent.
+type agentState struct {
+	SavedAt time.Time `json:"saved_at"`
+	Version string    `json:"version"`
+	// Actions are start times of actions running by their ID.
+	Actions map[string]time.Time `json:"actions,omitempty"`
+	// CronLastRuns are the last runs of cron entries by their mapping ID.
+	CronLastRuns map[string]time.Time `json:"cron_last_runs,omitempty"`
+	// Pending are notifications not published yet, in order.
+	Pending []pendingMessage `json:"pending,omitempty"`
+}
+
+// pendingMessage is a notification failed to publish, it is published again when broker is reachable.
+type pendingMessage struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+func agentStatePath() (string, error) {
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cachePath, agentStateFile), nil
+}
+
+// saveState writes the running actions, last runs of cron entries and pending notifications to cache directory.
+func (s *Server) saveState() error {
+	path, err := agentStatePath()
+	if err != nil {
+		return err
+	}
+	state := s.snapshotState()
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Server) snapshotState() *agentState {
+	state := &agentState{SavedAt: time.Now(), Version: Version, Actions: make(map[string]time.Time)}
+	for id, actionContext := range s.mapActionContext {
+		if actionContext.ctx != nil && actionContext.ctx.Err() == nil {
+			state.Actions[id] = actionContext.startedAt
+		}
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	state.CronLastRuns = make(map[string]time.Time, len(s.cronLastRuns))
+	for id, t := range s.cronLastRuns {
+		state.CronLastRuns[id] = t
+	}
+	state.Pending = append(state.Pending, s.pendingMessages...)
+	return state
+}
+
+// loadState restores the state saved before agent restarted, the state file is removed so it is loaded once.
+func (s *Server) loadState() error {
+	path, err := agentStatePath()
+	if err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_ = os.Remove(path)
+	var state agentState
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return err
+	}
+	s.restoreState(&state)
+	return nil
+}
+
+func (s *Server) restoreState(state *agentState) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.restarted = state
+	s.restartedAt = state.SavedAt
+	for id, t := range state.CronLastRuns {
+		s.cronLastRuns[id] = t
+	}
+	s.pendingMessages = append(state.Pending, s.pendingMessages...)
+	s.trimPendingMessages()
+}
+
+// publish publishes payload to topic after the pending notifications. When it fails, payload is kept to be published
+// when broker is reachable again.
+func (s *Server) publish(topic string, payload []byte) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	err := s.flushPendingMessages()
+	if err == nil {
+		err = s.b.Publish(topic, payload)
+	}
+	if err != nil {
+		s.pendingMessages = append(s.pendingMessages, pendingMessage{Topic: topic, Payload: payload})
+		s.trimPendingMessages()
+	}
+	return err
+}
+
+// flushPendingMessages publishes pending notifications in order, until one fails. stateMu must be held.
+func (s *Server) flushPendingMessages() error {
+	for len(s.pendingMessages) > 0 {
+		msg := s.pendingMessages[0]
+		if err := s.b.Publish(msg.Topic, []byte(msg.Payload)); err != nil {
+			return err
+		}
+		s.pendingMessages = s.pendingMessages[1:]
+	}
+	s.pendingMessages = nil
+	return nil
+}
+
+// trimPendingMessages drops the oldest pending notifications above maxPendingMessages. stateMu must be held.
+func (s *Server) trimPendingMessages() {
+	if n := len(s.pendingMessages) - maxPendingMessages; n > 0 {
+		s.logger.Warn("Too many pending notifications, drop the oldest", zap.Int("dropped", n))
+		s.pendingMessages = append([]pendingMessage(nil), s.pendingMessages[n:]...)
+	}
+}
+
+// resumeAfterRestart publishes notifications pending before agent restarted and reports actions interrupted by the
+// restart as failed, except backups resumed from their checkpoint. It is called once broker is connected.
+func (s *Server) resumeAfterRestart() {
+	s.stateMu.Lock()
+	err := s.flushPendingMessages()
+	state := s.restarted
+	s.restarted = nil
+	s.stateMu.Unlock()
+	if err != nil {
+		s.logger.Warn("failed to publish pending notifications", zap.Error(err))
+	}
+	if state == nil {
+		return
+	}
+	resumable := s.checkpointedActions()
+	for actionID := range state.Actions {
+		if resumable[actionID] {
+			s.logger.Info("Backup interrupted by agent restart is resumed by next backup", zap.String("action_id", actionID))
+			continue
+		}
+		s.logger.Info("Action interrupted by agent restart", zap.String("action_id", actionID))
+		s.notifyStatusFailed(actionID, ErrInterruptedByRestart)
+	}
+}
+
+// recordCronRun records the last run of cron entry id.
+func (s *Server) recordCronRun(id string, t time.Time) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.cronLastRuns[id] = t
+}
+
+// missedCronRun reports whether cron entry id with schedule pattern was due while agent restarted and did not run.
+// Only entries which ran before the restart and restarts within maxCatchUpDelay before now are caught up.
+func (s *Server) missedCronRun(id string, pattern string, now time.Time) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	restartedAt := s.restartedAt
+	if restartedAt.IsZero() || now.Sub(restartedAt) > maxCatchUpDelay {
+		return false
+	}
+	schedule, err := cronParser.Parse(pattern)
+	if err != nil {
+		return false
+	}
+	due := schedule.Next(restartedAt)
+	if due.After(now) {
+		return false
+	}
+	lastRun, ok := s.cronLastRuns[id]
+	return ok && lastRun.Before(due)
+}
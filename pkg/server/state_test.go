@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+)
+
+// offlineBroker is a broker whose publishes fail while it is offline.
+type offlineBroker struct {
+	broker.Broker
+	offline   bool
+	published []string
+}
+
+func (b *offlineBroker) Publish(topic string, payload interface{}) error {
+	if b.offline {
+		return errors.New("broker is offline")
+	}
+	b.published = append(b.published, string(payload.([]byte)))
+	return nil
+}
+
+func TestServerPendingMessages(t *testing.T) {
+	ob := &offlineBroker{offline: true}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+
+	require.Error(t, s.publish("agent/machine", []byte("1")))
+	require.Error(t, s.publish("agent/machine", []byte("2")))
+	assert.Len(t, s.pendingMessages, 2)
+
+	ob.offline = false
+	require.NoError(t, s.publish("agent/machine", []byte("3")))
+	assert.Equal(t, []string{"1", "2", "3"}, ob.published)
+	assert.Empty(t, s.pendingMessages)
+}
+
+func TestServerStateRestart(t *testing.T) {
+	viper.Set("cache_dir", t.TempDir())
+	defer viper.Set("cache_dir", "")
+
+	ob := &offlineBroker{offline: true}
+	s, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	s.publishTopics = []string{"agent/machine"}
+
+	lastRun := time.Date(2022, 6, 1, 1, 0, 0, 0, time.Local)
+	s.recordCronRun(mappingID("dir1", "policy_1"), lastRun)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.mapActionContext["running"] = contextStruct{ctx: ctx, cancel: cancel, startedAt: lastRun}
+	done, doneCancel := context.WithCancel(context.Background())
+	doneCancel()
+	s.mapActionContext["done"] = contextStruct{ctx: done, cancel: doneCancel, startedAt: lastRun}
+	require.Error(t, s.publish("agent/machine", []byte(`{"status":"COMPLETED"}`)))
+	require.NoError(t, s.saveState())
+
+	ob = &offlineBroker{}
+	restarted, err := New(WithBroker(ob))
+	require.NoError(t, err)
+	restarted.publishTopics = []string{"agent/machine"}
+	require.NotNil(t, restarted.restarted)
+	require.Len(t, restarted.restarted.Actions, 1)
+	assert.True(t, lastRun.Equal(restarted.restarted.Actions["running"]))
+	assert.True(t, lastRun.Equal(restarted.cronLastRuns[mappingID("dir1", "policy_1")]))
+	assert.NoFileExists(t, filepath.Join(viper.GetString("cache_dir"), agentStateFile))
+
+	restarted.resumeAfterRestart()
+	require.Len(t, ob.published, 2)
+	assert.Equal(t, `{"status":"COMPLETED"}`, ob.published[0])
+	assert.Contains(t, ob.published[1], ErrInterruptedByRestart.Error())
+	assert.Nil(t, restarted.restarted)
+}
+
+func TestServerMissedCronRun(t *testing.T) {
+	s, err := New()
+	require.NoError(t, err)
+	restartedAt := time.Date(2022, 6, 2, 0, 59, 0, 0, time.Local)
+	id := mappingID("dir1", "policy_1")
+
+	assert.False(t, s.missedCronRun(id, "0 1 * * *", restartedAt.Add(10*time.Minute)), "agent did not restart")
+
+	s.restartedAt = restartedAt
+	s.cronLastRuns[id] = time.Date(2022, 6, 1, 1, 0, 0, 0, time.Local)
+	assert.True(t, s.missedCronRun(id, "0 1 * * *", restartedAt.Add(10*time.Minute)))
+	assert.False(t, s.missedCronRun(id, "0 1 * * *", restartedAt.Add(30*time.Second)), "not due yet")
+	assert.False(t, s.missedCronRun(id, "0 1 * * *", restartedAt.Add(2*maxCatchUpDelay)), "restarted too long ago")
+	assert.False(t, s.missedCronRun(mappingID("dir2", "policy_2"), "0 1 * * *", restartedAt.Add(10*time.Minute)), "never ran")
+
+	s.cronLastRuns[id] = restartedAt.Add(time.Minute)
+	assert.False(t, s.missedCronRun(id, "0 1 * * *", restartedAt.Add(10*time.Minute)), "already ran")
+}
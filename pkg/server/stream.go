@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/progress"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+)
+
+// errInvalidStreamName is returned for the name of a stream which is not a file name.
+var errInvalidStreamName = errors.New("name of stream must be a file name")
+
+// StreamBackupResponse is the recovery point created by the backup of a stream.
+type StreamBackupResponse struct {
+	RecoveryPointID string `json:"recovery_point_id"`
+	// Path is the path of the file holding the stream in the recovery point, it is restored there.
+	Path        string `json:"path"`
+	Size        uint64 `json:"size"`
+	StorageSize uint64 `json:"storage_size"`
+}
+
+// BackupStream backs up the body of request, e.g. the output of a database dump, as file "name" of backup directory
+// backupID to a new recovery point. It responds once the stream is read to its end and stored.
+func (s *Server) BackupStream(w http.ResponseWriter, r *http.Request) {
+	backupID := chi.URLParam(r, "backupID")
+	name := r.URL.Query().Get("name")
+	resp, err := s.backupStream(r.Context(), backupID, r.URL.Query().Get("policy_id"), name, r.Body)
+	if err != nil {
+		s.logger.Error("Backup stream error", zap.Error(err), zap.String("backup_directory_id", backupID), zap.String("name", name))
+		if errors.Is(err, errInvalidStreamName) {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// streamItem returns the item of stream name in backup directory bd, it is a file directly in the directory.
+func streamItem(bd *backupapi.BackupDirectory, name string) (*cache.Node, error) {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return nil, errInvalidStreamName
+	}
+	now := time.Now()
+	return &cache.Node{
+		Name:         name,
+		Type:         "file",
+		Mode:         0600,
+		ModTime:      now,
+		AccessTime:   now,
+		ChangeTime:   now,
+		AbsolutePath: filepath.Join(bd.Path, name),
+		BasePath:     bd.Path,
+		RelativePath: filepath.Join(filepath.Base(bd.Path), name),
+	}, nil
+}
+
+// backupStream backs up stream r as file name of backup directory backupDirectoryID to a new recovery point, chunked
+// by policyID. The recovery point holds this file only, the stream is not compared with previous recovery points.
+func (s *Server) backupStream(ctx context.Context, backupDirectoryID, policyID, name string, r io.Reader) (*StreamBackupResponse, error) {
+	bd, err := s.backupClient.GetBackupDirectory(backupDirectoryID)
+	if err != nil {
+		return nil, err
+	}
+	item, err := streamItem(bd, name)
+	if err != nil {
+		return nil, err
+	}
+	chunking, err := s.policyChunking(ctx, backupDirectoryID, policyID)
+	if err != nil {
+		return nil, err
+	}
+	chunks := s.chunkFair.Queue(s.policyPriority(ctx, backupDirectoryID, policyID))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var actionCreateRP *backupapi.CreateRecoveryPointResponse
+	err = s.withReregister(func() error {
+		var err error
+		actionCreateRP, err = s.backupClient.CreateRecoveryPoint(ctx, backupDirectoryID, &backupapi.CreateRecoveryPointRequest{
+			PolicyID:          policyID,
+			Name:              name,
+			RecoveryPointType: backupapi.RecoveryPointTypeStream,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mapActionContext[actionCreateRP.ID] = contextStruct{ctx: ctx, cancel: cancel, startedAt: time.Now()}
+	defer delete(s.mapActionContext, actionCreateRP.ID)
+	s.feed.startAction(actionCreateRP.ID, feedActionBackup, actionCreateRP.RecoveryPoint.ID, backupDirectoryID)
+	defer s.feed.endAction(actionCreateRP.ID)
+	s.feed.phase(actionCreateRP.ID, phaseUploading)
+	s.notifyMsg(map[string]string{
+		"action_id": actionCreateRP.ID,
+		"status":    statusUploadFile,
+	})
+
+	s.logger.Info("Backup stream", zap.String("backup_directory_id", backupDirectoryID), zap.String("recovery_point_id", actionCreateRP.RecoveryPoint.ID), zap.String("path", item.AbsolutePath))
+	storageSize, indexHash, err := s.streamWorker(ctx, actionCreateRP, bd.ID, item, r, &chunking, chunks)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = backupapi.ErrorGotCancelRequest
+		}
+		s.notifyStatusFailed(actionCreateRP.ID, err)
+		return nil, err
+	}
+	s.notifyMsg(map[string]string{
+		"action_id":    actionCreateRP.ID,
+		"status":       statusComplete,
+		"index_hash":   indexHash,
+		"storage_size": strconv.FormatUint(storageSize, 10),
+		"total":        strconv.FormatUint(item.Size, 10),
+		"total_files":  "1",
+	})
+	return &StreamBackupResponse{
+		RecoveryPointID: actionCreateRP.RecoveryPoint.ID,
+		Path:            item.AbsolutePath,
+		Size:            item.Size,
+		StorageSize:     storageSize,
+	}, nil
+}
+
+// streamWorker uploads the chunks of stream r, the content of item, and stores chunk.json, file.csv and index.json of
+// the recovery point of actionCreateRP. It returns the size stored and the hash of index.
+func (s *Server) streamWorker(ctx context.Context, actionCreateRP *backupapi.CreateRecoveryPointResponse, bdID string, item *cache.Node, r io.Reader,
+	chunking *backupapi.Chunking, pool limiter.Pool) (uint64, string, error) {
+	storageVault, err := s.NewStorageVault(*actionCreateRP.StorageVault, actionCreateRP.ID, viper.GetInt("limit_upload"), viper.GetInt("limit_download"))
+	if err != nil {
+		return 0, "", err
+	}
+	if s.uploadBudget != nil {
+		storageVault = storage_vault.WithDailyBudget(storageVault, s.uploadBudget, s.notifyBudgetExhausted(actionCreateRP.ID))
+	}
+
+	_, cachePath, err := support.CheckPath()
+	if err != nil {
+		return 0, "", err
+	}
+	mcID := s.backupClient.Id
+	rpID := actionCreateRP.RecoveryPoint.ID
+	cacheWriter, err := cache.NewRepository(cachePath, mcID, rpID)
+	if err != nil {
+		return 0, "", err
+	}
+	fileList, err := cacheWriter.NewFileList()
+	if err != nil {
+		return 0, "", err
+	}
+	defer fileList.Close()
+
+	chunks := cache.NewChunk(bdID, rpID)
+	pipe := make(chan *cache.Chunk)
+	var wg sync.WaitGroup
+	var errChunks error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for receiver := range pipe {
+			if _, err := addChunk(chunks, receiver); err != nil && errChunks == nil {
+				errChunks = err
+			}
+		}
+	}()
+
+	progressUpload := s.newUploadProgress(rpID, progress.Stat{Items: 1})
+	progressUpload.Start()
+	defer progressUpload.Cancel()
+	packer := s.backupClient.NewPacker(storageVault, viper.GetInt("pack_size"), pipe, rpID, bdID)
+	storageSize, err := s.backupClient.ChunkReaderToBackup(ctx, pool, item, r, storageVault, chunking, packer, progressUpload, pipe, rpID, bdID)
+	if err == nil {
+		err = packer.Flush(ctx)
+	}
+	close(pipe)
+	wg.Wait()
+	if err == nil {
+		err = errChunks
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	progressUpload.Report(progress.Stat{Items: 1})
+	s.feed.phase(actionCreateRP.ID, phaseFinalizing)
+
+	if err := fileList.Add(item); err != nil {
+		return 0, "", err
+	}
+	if err := fileList.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := cacheWriter.SaveChunk(chunks); err != nil {
+		return 0, "", err
+	}
+	chunkHash, err := sha256File(filepath.Join(cachePath, mcID, rpID, "chunk.json"))
+	if err != nil {
+		return 0, "", err
+	}
+	if err := s.putChunks(ctx, cachePath, mcID, rpID, "", storageVault); err != nil {
+		return 0, "", err
+	}
+	if err := s.putFiles(ctx, cachePath, mcID, rpID, "", storageVault); err != nil {
+		return 0, "", err
+	}
+
+	index := cache.NewIndex(bdID, rpID)
+	index.Items[item.AbsolutePath] = item
+	index.TotalFiles = 1
+	index.ChunkHash = chunkHash
+	if err := cacheWriter.SaveIndex(index); err != nil {
+		return 0, "", err
+	}
+	indexHash, err := s.putIndexs(ctx, storageVault, cache.Index{}, cachePath, mcID, rpID)
+	if err != nil {
+		return 0, "", err
+	}
+	progressUpload.Done()
+	return storageSize, indexHash, nil
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+)
+
+func TestStreamItem(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dumps")
+	bd := &backupapi.BackupDirectory{ID: "bd", Path: dir}
+
+	item, err := streamItem(bd, "db.sql")
+	require.NoError(t, err)
+	assert.Equal(t, "file", item.Type)
+	assert.Equal(t, filepath.Join(dir, "db.sql"), item.AbsolutePath)
+	assert.Equal(t, filepath.Join("dumps", "db.sql"), item.RelativePath)
+
+	for _, name := range []string{"", ".", "..", filepath.Join("..", "db.sql"), filepath.Join("sub", "db.sql")} {
+		_, err := streamItem(bd, name)
+		assert.ErrorIs(t, err, errInvalidStreamName, name)
+	}
+}
+
+func TestAddChunk(t *testing.T) {
+	chunks := cache.NewChunk("bd", "rp")
+	received := func(key, size string) *cache.Chunk {
+		c := cache.NewChunk("bd", "rp")
+		c.Chunks[key] = []string{"1", size}
+		return c
+	}
+
+	key, err := addChunk(chunks, received("k1", "100"))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", key)
+	_, err = addChunk(chunks, received("k1", "100"))
+	require.NoError(t, err)
+	_, err = addChunk(chunks, received("k2", "50"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2-100"}, chunks.Chunks["k1"])
+	assert.Equal(t, []string{"1-50"}, chunks.Chunks["k2"])
+
+	// a packfile keeps its own size, not the sizes reported by its chunks
+	packed := func(key, size string) *cache.Chunk {
+		c := received(key, size)
+		c.Packed = true
+		return c
+	}
+	_, err = addChunk(chunks, packed("pack", "10"))
+	require.NoError(t, err)
+	_, err = addChunk(chunks, received("pack", "1000"))
+	require.NoError(t, err)
+	_, err = addChunk(chunks, packed("pack", "20"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3-1000"}, chunks.Chunks["pack"])
+}
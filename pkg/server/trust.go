@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/cache"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// defaultTrustCheckSamples is the number of chunks of a recovery point downloaded and checked before it is restored.
+const defaultTrustCheckSamples = 16
+
+// ErrRecoveryPointTampered is returned before a restore when index.json, chunk.json or chunks of a recovery point do
+// not match their hashes, the recovery point was modified since it was backed up or is corrupted.
+var ErrRecoveryPointTampered = errors.New("recovery point is tampered or corrupted")
+
+func trustCheckSamples() int {
+	if !viper.IsSet("trust_check_samples") {
+		return defaultTrustCheckSamples
+	}
+	return viper.GetInt("trust_check_samples")
+}
+
+// checkChunkList downloads chunk.json of recovery point rpID to cachePath and checks it against hash recorded in its
+// index. Indexes of recovery points made by older agents have no hash, their chunk.json is not checked.
+func checkChunkList(ctx context.Context, storageVault storage_vault.StorageVault, cachePath, machineID, rpID, hash string) error {
+	if hash == "" {
+		return nil
+	}
+	key := filepath.Join(machineID, rpID, "chunk.json")
+	name := filepath.Join(cachePath, key)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	if err := storage_vault.GetFile(ctx, storageVault, key, name, 0700); err != nil {
+		return err
+	}
+	defer os.Remove(name)
+	got, err := sha256File(name)
+	if err != nil {
+		return err
+	}
+	if got != hash {
+		return fmt.Errorf("%w: chunk.json hash %s, index records %s", ErrRecoveryPointTampered, got, hash)
+	}
+	return nil
+}
+
+// trustCheck checks chunk.json of recovery point rpID against chunkHash and a random sample of chunks of its full
+// index against their keys, before it is restored.
+func (s *Server) trustCheck(ctx context.Context, storageVault storage_vault.StorageVault, restoreKey *backupapi.AuthRestore, cachePath, machineID, rpID, chunkHash string, index cache.Index) error {
+	if err := checkChunkList(ctx, storageVault, cachePath, machineID, rpID, chunkHash); err != nil {
+		return err
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	err := s.backupClient.SpotCheckChunks(ctx, storageVault, index, trustCheckSamples(), r, restoreKey)
+	if errors.Is(err, backupapi.ErrCorruptedChunk) {
+		return fmt.Errorf("%w: %v", ErrRecoveryPointTampered, err)
+	}
+	return err
+}
+
+// distrust reports whether a restore stops on error err of its trust check. A recovery point which shows tampering
+// is still restored when force is set.
+func (s *Server) distrust(actionID string, err error, force bool) bool {
+	if force && errors.Is(err, ErrRecoveryPointTampered) {
+		s.logger.Warn("Trust check of recovery point failed, restore is forced", zap.String("action_id", actionID), zap.Error(err))
+		return false
+	}
+	s.logger.Error("Trust check of recovery point failed", zap.String("action_id", actionID), zap.Error(err))
+	s.notifyStatusFailed(actionID, err)
+	return true
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// objectVault serves objects by key, other methods of storage vault are not used.
+type objectVault struct {
+	storage_vault.StorageVault
+	objects map[string][]byte
+}
+
+func (v *objectVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	data, ok := v.objects[key]
+	if !ok {
+		return errors.New("not found")
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func TestCheckChunkList(t *testing.T) {
+	cachePath := t.TempDir()
+	chunks := []byte(`{"chunks":{"a":["k1"]}}`)
+	sum := sha256.Sum256(chunks)
+	hash := hex.EncodeToString(sum[:])
+	vault := &objectVault{objects: map[string][]byte{"mc/rp/chunk.json": chunks}}
+
+	require.NoError(t, checkChunkList(context.Background(), vault, cachePath, "mc", "rp", hash))
+	// older recovery points have no hash
+	require.NoError(t, checkChunkList(context.Background(), vault, cachePath, "mc", "old", ""))
+
+	vault.objects["mc/rp/chunk.json"] = []byte(`{"chunks":{"a":["k2"]}}`)
+	err := checkChunkList(context.Background(), vault, cachePath, "mc", "rp", hash)
+	assert.True(t, errors.Is(err, ErrRecoveryPointTampered))
+	assert.Equal(t, "RECOVERY_POINT_TAMPERED", errorCode(err))
+
+	err = checkChunkList(context.Background(), vault, cachePath, "mc", "missing", hash)
+	assert.False(t, errors.Is(err, ErrRecoveryPointTampered))
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+	"github.com/bizflycloud/bizfly-backup/pkg/support"
+	"github.com/bizflycloud/bizfly-backup/pkg/watch"
+)
+
+// defaultWatchDebounce is how long changes of a watched backup directory are accumulated before they are backed up.
+const defaultWatchDebounce = 5 * time.Minute
+
+// watchedDirectory is a backup directory whose changes are backed up by micro-backups of a policy.
+type watchedDirectory struct {
+	policyID string
+	watcher  *watch.Watcher
+}
+
+// watchDebounce returns the debounce of a watch_directory event asking for seconds, 0 is watch_debounce config.
+func watchDebounce(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = viper.GetInt("watch_debounce")
+	}
+	if seconds <= 0 {
+		return defaultWatchDebounce
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// handleWatchDirectory starts or stops watching the backup directory of a watch_directory event.
+func (s *Server) handleWatchDirectory(msg broker.Message) error {
+	if msg.Watch != nil && !*msg.Watch {
+		s.stopWatch(msg.BackupDirectoryID)
+		return nil
+	}
+	go func() {
+		defer s.crashReporter.Recover()
+		if err := s.startWatch(msg.BackupDirectoryID, msg.PolicyID, watchDebounce(msg.WatchDebounce)); err != nil {
+			s.logger.Error("failed to watch backup directory", zap.Error(err), zap.String("backup_directory_id", msg.BackupDirectoryID))
+		}
+	}()
+	return nil
+}
+
+// startWatch watches backup directory bdID, its changes are backed up by policyID debounce after the first of them.
+// A directory already watched is watched again with the new policy and debounce.
+func (s *Server) startWatch(bdID, policyID string, debounce time.Duration) error {
+	bd, err := s.backupClient.GetBackupDirectory(bdID)
+	if err != nil {
+		return err
+	}
+	ignore := func(string) bool { return false }
+	if _, cachePath, err := support.CheckPath(); err == nil {
+		// the cache directory changes with every backup
+		ignore = func(path string) bool { return underPath(path, cachePath) }
+	}
+	roots := bd.Roots()
+	w, err := watch.New(roots[0], debounce, ignore, s.watchBackup(bdID, policyID), s.logger)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots[1:] {
+		if err := w.Add(root); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	s.watchMu.Lock()
+	old := s.watchers[bdID]
+	s.watchers[bdID] = &watchedDirectory{policyID: policyID, watcher: w}
+	s.watchMu.Unlock()
+	if old != nil {
+		_ = old.watcher.Close()
+	}
+	s.logger.Info("Watch backup directory", zap.String("backup_directory_id", bdID), zap.Strings("paths", roots), zap.Duration("debounce", debounce))
+	return nil
+}
+
+// stopWatch stops watching backup directory bdID, changes not backed up yet are backed up by its next backup.
+func (s *Server) stopWatch(bdID string) {
+	s.watchMu.Lock()
+	wd := s.watchers[bdID]
+	delete(s.watchers, bdID)
+	s.watchMu.Unlock()
+	if wd == nil {
+		return
+	}
+	if err := wd.watcher.Close(); err != nil {
+		s.logger.Warn("failed to stop watching backup directory", zap.Error(err), zap.String("backup_directory_id", bdID))
+	}
+	s.logger.Info("Stop watching backup directory", zap.String("backup_directory_id", bdID))
+}
+
+// watchBackup returns the handler of changes of watched backup directory bdID. The changed paths are backed up to
+// a partial recovery point, the whole directory when they overflowed. Failed backups are retried with the next
+// changes, but a directory which does not exist anymore is not watched.
+func (s *Server) watchBackup(bdID, policyID string) func(watch.Changes) error {
+	return func(changes watch.Changes) error {
+		defer s.crashReporter.Recover()
+		paths := changes.Paths
+		if changes.Overflow {
+			paths = nil
+		}
+		name := "cdp-" + time.Now().Format(time.RFC3339)
+		err := s.backup(bdID, policyID, name, viper.GetInt("limit_upload"), 0, backupapi.RecoveryPointTypeInitialReplica, "", paths, ioutil.Discard)
+		switch {
+		case errors.Is(err, backupapi.ErrNotFound):
+			s.logger.Warn("Backup directory not found, stop watching it", zap.String("backup_directory_id", bdID))
+			s.stopWatch(bdID)
+			return nil
+		case errors.Is(err, backupapi.ErrConflict), errors.Is(err, ErrBackupRunning):
+			s.logger.Info("Delay backup of watched changes, another action of backup directory is running", zap.String("backup_directory_id", bdID))
+		}
+		return err
+	}
+}
+
+// underPath reports whether path is dir or under it.
+func underPath(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/broker"
+	"github.com/bizflycloud/bizfly-backup/pkg/watch"
+)
+
+func TestWatchDebounce(t *testing.T) {
+	assert.Equal(t, defaultWatchDebounce, watchDebounce(0))
+	viper.Set("watch_debounce", 60)
+	defer viper.Set("watch_debounce", 0)
+	assert.Equal(t, time.Minute, watchDebounce(0))
+	assert.Equal(t, 10*time.Second, watchDebounce(10))
+}
+
+func TestServerStopWatch(t *testing.T) {
+	s, err := New(WithBroker(&offlineBroker{}))
+	require.NoError(t, err)
+	w, err := watch.New(t.TempDir(), time.Hour, nil, func(watch.Changes) error { return nil }, zap.NewNop())
+	require.NoError(t, err)
+	s.watchers["bd1"] = &watchedDirectory{policyID: "policy", watcher: w}
+	s.watchers["bd2"] = &watchedDirectory{policyID: "policy", watcher: w}
+
+	payload, _ := json.Marshal(map[string]interface{}{"event_type": broker.WatchDirectory, "backup_directory_id": "bd1", "watch": false})
+	require.NoError(t, s.handleBrokerEvent(broker.Event{Payload: payload}))
+	assert.NotContains(t, s.watchers, "bd1")
+
+	require.NoError(t, s.handleConfigUpdate(broker.Message{
+		Action:            broker.ConfigUpdateActionDelDirectory,
+		BackupDirectories: []backupapi.BackupDirectoryConfig{{ID: "bd2"}},
+	}))
+	assert.Empty(t, s.watchers)
+}
+
+func TestUnderPath(t *testing.T) {
+	dir := filepath.Join("var", "cache")
+	assert.True(t, underPath(dir, dir))
+	assert.True(t, underPath(filepath.Join(dir, "a"), dir))
+	assert.False(t, underPath(dir+"2", dir))
+}
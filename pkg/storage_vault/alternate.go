@@ -0,0 +1,34 @@
+package storage_vault
+
+// alternateVault is a StorageVault which can download objects again through alternate endpoints of the same bucket.
+type alternateVault struct {
+	StorageVault
+	alternates []StorageVault
+}
+
+// WithAlternates wraps vault with alternate endpoints, they are only used by callers asking Alternates for them
+// (e.g. to download again an object whose content is corrupted).
+func WithAlternates(vault StorageVault, alternates ...StorageVault) StorageVault {
+	if len(alternates) == 0 {
+		return vault
+	}
+	return &alternateVault{StorageVault: vault, alternates: alternates}
+}
+
+func (v *alternateVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+// Alternates returns the vaults an object of vault can be downloaded again from: alternate endpoints of vault,
+// then mirrors of a replicated vault.
+func Alternates(vault StorageVault) []StorageVault {
+	switch v := vault.(type) {
+	case *alternateVault:
+		return append(append([]StorageVault{}, v.alternates...), Alternates(v.StorageVault)...)
+	case *replicatedVault:
+		return append(Alternates(v.vaults[0]), v.vaults[1:]...)
+	case Wrapper:
+		return Alternates(v.Unwrap())
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package storage_vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlternates(t *testing.T) {
+	primary, endpoint, mirror := newFakeVault("primary"), newFakeVault("endpoint"), newFakeVault("mirror")
+
+	assert.Nil(t, Alternates(primary))
+	assert.Equal(t, StorageVault(primary), WithAlternates(primary))
+	assert.Equal(t, []StorageVault{endpoint}, Alternates(WithAlternates(primary, endpoint)))
+
+	vault := NewReplicated(WithAlternates(primary, endpoint), mirror)
+	assert.Equal(t, []StorageVault{endpoint, mirror}, Alternates(vault))
+	assert.Equal(t, []StorageVault{endpoint, mirror}, Alternates(WithDailyBudget(vault, nil, nil)))
+}
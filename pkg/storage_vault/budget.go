@@ -0,0 +1,54 @@
+package storage_vault
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+// budgetVault is a StorageVault which spends the daily upload budget before putting objects.
+type budgetVault struct {
+	StorageVault
+	budget      *limiter.DailyBudget
+	onExhausted func(used int64, resumeAt time.Time)
+}
+
+// WithDailyBudget wraps vault so uploads spend the daily budget. When budget of the day is exhausted, PutObject fails
+// with limiter.ErrDailyBudgetExhausted and onExhausted is called with the bytes uploaded in the day and the time the
+// budget is renewed, so the backup stops instead of holding its workers until then.
+func WithDailyBudget(vault StorageVault, budget *limiter.DailyBudget, onExhausted func(used int64, resumeAt time.Time)) StorageVault {
+	return &budgetVault{
+		StorageVault: vault,
+		budget:       budget,
+		onExhausted:  onExhausted,
+	}
+}
+
+func (v *budgetVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+func (v *budgetVault) spend(n int64) error {
+	err := v.budget.Spend(n)
+	if errors.Is(err, limiter.ErrDailyBudgetExhausted) && v.onExhausted != nil {
+		v.onExhausted(v.budget.Used(), v.budget.RenewsAt())
+	}
+	return err
+}
+
+func (v *budgetVault) PutObject(ctx context.Context, key string, data []byte) error {
+	if err := v.spend(int64(len(data))); err != nil {
+		return err
+	}
+	return v.StorageVault.PutObject(ctx, key, data)
+}
+
+func (v *budgetVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := v.spend(size); err != nil {
+		return err
+	}
+	return v.StorageVault.PutObjectStream(ctx, key, r, size)
+}
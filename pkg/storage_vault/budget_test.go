@@ -0,0 +1,31 @@
+package storage_vault
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+func TestWithDailyBudget(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeVault("store")
+	budget, err := limiter.NewDailyBudget(10, "")
+	require.NoError(t, err)
+	exhausted := 0
+	vault := WithDailyBudget(store, budget, func(used int64, resumeAt time.Time) {
+		exhausted++
+		assert.Equal(t, int64(8), used)
+		assert.True(t, resumeAt.After(time.Now()))
+	})
+
+	require.NoError(t, vault.PutObject(ctx, "a", []byte("12345678")))
+	assert.ErrorIs(t, vault.PutObject(ctx, "b", []byte("12345678")), limiter.ErrDailyBudgetExhausted)
+	assert.Equal(t, 1, exhausted)
+	assert.Contains(t, store.objects, "a")
+	assert.NotContains(t, store.objects, "b", "nothing is uploaded over the budget")
+}
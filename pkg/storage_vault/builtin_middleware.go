@@ -0,0 +1,125 @@
+package storage_vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/compression"
+)
+
+func init() {
+	RegisterMiddleware("compress", newCompressMiddleware)
+	RegisterMiddleware("encrypt", newEncryptMiddleware)
+}
+
+// compressTransform compresses objects, algorithm option defaults to lz4.
+type compressTransform struct {
+	algorithm string
+}
+
+func newCompressMiddleware(options map[string]string) (Middleware, error) {
+	algorithm := options["algorithm"]
+	if algorithm == "" {
+		algorithm = compression.LZ4
+	}
+	if algorithm == compression.None {
+		return nil, errors.New("algorithm is required")
+	}
+	if err := compression.Check(algorithm); err != nil {
+		return nil, err
+	}
+	return NewTransform("compress-"+algorithm, &compressTransform{algorithm: algorithm}), nil
+}
+
+// Encode prepends the length of data to compressed data.
+func (t *compressTransform) Encode(data []byte) ([]byte, error) {
+	compressed, err := compression.Compress(t.algorithm, data)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(compressed))
+	out = append(out[:binary.PutUvarint(out, uint64(len(data)))], compressed...)
+	return out, nil
+}
+
+func (t *compressTransform) Decode(data []byte) ([]byte, error) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: invalid length of compressed object", ErrChecksumMismatch)
+	}
+	out, err := compression.Decompress(t.algorithm, data[n:], int(length))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, err)
+	}
+	return out, nil
+}
+
+// encryptTransform encrypts objects by AES-256-GCM with the base64 encoded 256-bit key option. The encryption is
+// deterministic: the nonce is the HMAC-SHA256 of content, so the same content is stored as the same object and already
+// stored objects are reused. It reveals which objects have the same content, but nothing of the content. The key of
+// the MAC and the key of AES-GCM are separate subkeys derived from the key option by HKDF-SHA256.
+type encryptTransform struct {
+	macKey []byte
+	aead   cipher.AEAD
+}
+
+// Info of the subkeys derived from the key option of encrypt middleware.
+const (
+	encryptKeyInfo = "bizfly-backup encrypt-aes256gcm encryption key"
+	encryptMACInfo = "bizfly-backup encrypt-aes256gcm nonce key"
+)
+
+// hkdfSHA256 derives a 256-bit subkey for info from secret by HKDF-SHA256 (RFC 5869) without salt. A single block is
+// expanded, as the subkey is the size of the hash.
+func hkdfSHA256(secret []byte, info string) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	_, _ = extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	_, _ = expand.Write([]byte(info))
+	_, _ = expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func newEncryptMiddleware(options map[string]string) (Middleware, error) {
+	key, err := base64.StdEncoding.DecodeString(options["key"])
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, errors.New("key must be a base64 encoded 256-bit key")
+	}
+	block, err := aes.NewCipher(hkdfSHA256(key, encryptKeyInfo))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return NewTransform("encrypt-aes256gcm", &encryptTransform{macKey: hkdfSHA256(key, encryptMACInfo), aead: aead}), nil
+}
+
+func (t *encryptTransform) Encode(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, t.macKey)
+	_, _ = mac.Write(data)
+	nonce := mac.Sum(nil)[:t.aead.NonceSize()]
+	return t.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (t *encryptTransform) Decode(data []byte) ([]byte, error) {
+	n := t.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("encrypted object is too short")
+	}
+	out, err := t.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decrypt: %v", ErrChecksumMismatch, err)
+	}
+	return out, nil
+}
@@ -3,6 +3,7 @@ package storage_vault
 import (
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -16,13 +17,19 @@ type TransportOptions struct {
 	MaxHostIdleConns int
 	ResponseHeader   time.Duration
 	TLSHandshake     time.Duration
+	// Proxy selects the proxy of requests, HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when nil.
+	Proxy func(*http.Request) (*url.URL, error)
 }
 
 // Transport returns a new http.RoundTripper with default settings applied.
 func Transport(opts TransportOptions) (http.RoundTripper, error) {
+	proxy := opts.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
 	tr := &http.Transport{
 		ResponseHeaderTimeout: opts.ResponseHeader,
-		Proxy:                 http.ProxyFromEnvironment,
+		Proxy:                 proxy,
 		DialContext: (&net.Dialer{
 			KeepAlive: opts.ConnKeepAlive,
 			DualStack: true,
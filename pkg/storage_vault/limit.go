@@ -0,0 +1,86 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+)
+
+// limitedVault is a StorageVault whose objects are put and got at the bandwidth of a limiter.
+type limitedVault struct {
+	StorageVault
+	limiter limiter.Limiter
+}
+
+// WithLimit wraps vault so the bytes of objects put to it are limited by the upstream of lim, and the bytes of
+// objects got from it by the downstream of lim. It is the limit stage of the storage path, between the middlewares
+// and the backend: objects are limited as they are stored, after they are compressed and encrypted.
+func WithLimit(vault StorageVault, lim limiter.Limiter) StorageVault {
+	return &limitedVault{StorageVault: vault, limiter: lim}
+}
+
+func (v *limitedVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+// wait returns once the bytes of data are read through r, a limited reader of data.
+func wait(r io.Reader) error {
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+func (v *limitedVault) PutObject(ctx context.Context, key string, data []byte) error {
+	if err := wait(v.limiter.Upstream(bytes.NewReader(data))); err != nil {
+		return err
+	}
+	return v.StorageVault.PutObject(ctx, key, data)
+}
+
+// limitedReadSeeker is a limited reader of a reader which seeks, so uploads of the reader can still be retried.
+type limitedReadSeeker struct {
+	io.Reader
+	io.Seeker
+}
+
+func (v *limitedVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	limited := v.limiter.Upstream(r)
+	if seeker, ok := r.(io.Seeker); ok {
+		limited = &limitedReadSeeker{Reader: limited, Seeker: seeker}
+	}
+	return v.StorageVault.PutObjectStream(ctx, key, limited, size)
+}
+
+func (v *limitedVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	data, err := v.StorageVault.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(v.limiter.Downstream(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (v *limitedVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	return v.StorageVault.GetObjectStream(ctx, key, v.limiter.DownstreamWriter(w))
+}
+
+// limitedRangeGetter downloads parts of objects at the bandwidth of a limiter.
+type limitedRangeGetter struct {
+	RangeGetter
+	limiter limiter.Limiter
+}
+
+func (g *limitedRangeGetter) GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	data, err := g.RangeGetter.GetObjectRange(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if err := wait(g.limiter.Downstream(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
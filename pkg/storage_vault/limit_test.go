@@ -0,0 +1,106 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingLimiter counts the bytes going through it instead of limiting them.
+type countingLimiter struct {
+	up, down int
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += n
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += n
+	return n, err
+}
+
+func (l *countingLimiter) Upstream(r io.Reader) io.Reader {
+	return &countingReader{r: r, n: &l.up}
+}
+
+func (l *countingLimiter) UpstreamWriter(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &l.up}
+}
+
+func (l *countingLimiter) Downstream(r io.Reader) io.Reader {
+	return &countingReader{r: r, n: &l.down}
+}
+
+func (l *countingLimiter) DownstreamWriter(w io.Writer) io.Writer {
+	return &countingWriter{w: w, n: &l.down}
+}
+
+func (l *countingLimiter) Transport(rt http.RoundTripper) http.RoundTripper {
+	return rt
+}
+
+func TestWithLimit(t *testing.T) {
+	ctx := context.Background()
+	store := &rangeVault{fakeVault: newFakeVault("store")}
+	lim := &countingLimiter{}
+	vault, err := Chain(WithLimit(store, lim), []MiddlewareSpec{{Name: "compress"}})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("compressible content "), 1000)
+	require.NoError(t, vault.PutObject(ctx, "key", data))
+	stored := len(store.objects["key"])
+	assert.Equal(t, stored, lim.up, "stored bytes are limited, after middlewares")
+
+	got, err := vault.GetObject(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Equal(t, stored, lim.down)
+
+	// uploads of a reader which seeks can still be retried
+	var seeks bool
+	limited := WithLimit(&seekCheckVault{fakeVault: newFakeVault("seek"), seeks: &seeks}, lim)
+	require.NoError(t, limited.PutObjectStream(ctx, "stream", bytes.NewReader(data), int64(len(data))))
+	assert.True(t, seeks)
+	var buf bytes.Buffer
+	require.NoError(t, limited.GetObjectStream(ctx, "stream", &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// ranges are downloaded by the backend at the limit
+	lim.down = 0
+	require.NoError(t, store.PutObject(ctx, "pack", []byte("foo bar baz")))
+	got, err = GetObjectRange(ctx, WithLimit(store, lim), "pack", 4, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), got)
+	assert.Equal(t, 1, store.ranges)
+	assert.Equal(t, 3, lim.down)
+}
+
+// seekCheckVault is a fakeVault recording whether streams are put with a reader which seeks.
+type seekCheckVault struct {
+	*fakeVault
+	seeks *bool
+}
+
+func (v *seekCheckVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, *v.seeks = r.(io.Seeker)
+	return v.fakeVault.PutObjectStream(ctx, key, r, size)
+}
@@ -0,0 +1,219 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+const (
+	dirMode  = 0700
+	fileMode = 0600
+)
+
+// ErrEmptyPath is returned when the local storage vault has no root path configured.
+var ErrEmptyPath = errors.New("local storage vault path is empty")
+
+// Local is the storage vault stores objects in local directory or mounted network filesystem (NFS, SMB, ...).
+type Local struct {
+	Id               string
+	ActionID         string
+	Name             string
+	Path             string
+	KeyPrefix        string
+	CredentialType   string
+	StorageVaultType string
+
+	logger *zap.Logger
+}
+
+var _ storage_vault.StorageVault = (*Local)(nil)
+
+// NewLocal creates a local storage vault. The root directory is taken from storage vault,
+// if it is empty, the value of "local_vault_path" in config file is used.
+func NewLocal(vault backupapi.StorageVault, actionID string) (*Local, error) {
+	l := &Local{
+		Id:               vault.ID,
+		ActionID:         actionID,
+		Name:             vault.Name,
+		Path:             vault.LocalPath,
+		KeyPrefix:        vault.KeyPrefix,
+		CredentialType:   vault.CredentialType,
+		StorageVaultType: vault.StorageVaultType,
+	}
+	if l.Path == "" {
+		l.Path = viper.GetString("local_vault_path")
+	}
+	if l.Path == "" {
+		return nil, ErrEmptyPath
+	}
+	if vault.StorageBucket != "" {
+		l.Path = filepath.Join(l.Path, vault.StorageBucket)
+	}
+	if l.KeyPrefix == "" {
+		l.KeyPrefix = viper.GetString("key_prefix")
+	}
+
+	if l.logger == nil {
+		logger, err := backupapi.WriteLog()
+		if err != nil {
+			return nil, err
+		}
+		l.logger = logger
+	}
+
+	if err := os.MkdirAll(l.Path, dirMode); err != nil {
+		l.logger.Error("Create local storage vault directory error", zap.Error(err), zap.String("path", l.Path))
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Local) Type() storage_vault.Type {
+	return storage_vault.Type{
+		StorageVaultType: l.StorageVaultType,
+		CredentialType:   l.CredentialType,
+	}
+}
+
+func (l *Local) ID() (string, string) {
+	return l.Id, l.ActionID
+}
+
+// filename returns the path of object in local storage vault.
+func (l *Local) filename(key string) string {
+	return filepath.Join(l.Path, filepath.FromSlash(storage_vault.ObjectKey(l.KeyPrefix, key)))
+}
+
+// HeadObject returns whether object existing, the etag of object is md5 of its content like S3.
+func (l *Local) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	buf, err := ioutil.ReadFile(l.filename(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, "", nil
+		}
+		l.logger.Error("HeadObject error", zap.Error(err), zap.String("key", key))
+		return false, "", err
+	}
+	hash := md5.Sum(buf)
+	return true, hex.EncodeToString(hash[:]), nil
+}
+
+func (l *Local) PutObject(ctx context.Context, key string, data []byte) error {
+	return l.PutObjectStream(ctx, key, bytes.NewReader(data), int64(len(data)))
+}
+
+// PutObjectStream writes r to a temporary file then renames it, so a partial write never appears as object.
+func (l *Local) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	name := l.filename(key)
+	if err := os.MkdirAll(filepath.Dir(name), dirMode); err != nil {
+		l.logger.Error("PutObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), ".temp-")
+	if err != nil {
+		l.logger.Error("PutObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		l.logger.Error("PutObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	if n != size {
+		err := fmt.Errorf("short write: %d of %d bytes", n, size)
+		l.logger.Error("PutObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), fileMode); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (l *Local) GetObject(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadFile(l.filename(key))
+	if err != nil {
+		l.logger.Error("GetObject error", zap.Error(err), zap.String("key", key))
+		return nil, err
+	}
+	return buf, nil
+}
+
+// GetObjectRange reads length bytes of the object file from offset.
+func (l *Local) GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(l.filename(key))
+	if err != nil {
+		l.logger.Error("GetObject error", zap.Error(err), zap.String("key", key))
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("read range %d-%d of %s: %w", offset, offset+length, key, err)
+	}
+	return data, nil
+}
+
+// GetObjectStream copies the object file to w.
+func (l *Local) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f, err := os.Open(l.filename(key))
+	if err != nil {
+		l.logger.Error("GetObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		l.logger.Error("GetObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	return nil
+}
+
+func (l *Local) DeleteObject(ctx context.Context, key string) error {
+	err := os.Remove(l.filename(key))
+	if err != nil && !os.IsNotExist(err) {
+		l.logger.Error("DeleteObject error", zap.Error(err), zap.String("key", key))
+		return err
+	}
+	return nil
+}
+
+// RefreshCredential does nothing, local storage vault has no credential.
+func (l *Local) RefreshCredential(credential storage_vault.Credential) error {
+	return nil
+}
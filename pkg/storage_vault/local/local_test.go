@@ -0,0 +1,91 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestLocal(t *testing.T, prefix string) *Local {
+	return &Local{
+		Id:        "vault",
+		ActionID:  "action",
+		Path:      t.TempDir(),
+		KeyPrefix: prefix,
+		logger:    zap.NewNop(),
+	}
+}
+
+func TestLocal_PutGetObject(t *testing.T) {
+	l := newTestLocal(t, "")
+	data := []byte("foo bar")
+	hash := md5.Sum(data)
+
+	isExist, _, err := l.HeadObject(context.Background(), "machine/rp/index.json")
+	require.NoError(t, err)
+	assert.False(t, isExist)
+
+	require.NoError(t, l.PutObject(context.Background(), "machine/rp/index.json", data))
+
+	isExist, etag, err := l.HeadObject(context.Background(), "machine/rp/index.json")
+	require.NoError(t, err)
+	assert.True(t, isExist)
+	assert.Equal(t, hex.EncodeToString(hash[:]), etag)
+
+	got, err := l.GetObject(context.Background(), "machine/rp/index.json")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	_, err = l.GetObject(context.Background(), "machine/rp/chunk.json")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocal_PutGetObjectStream(t *testing.T) {
+	l := newTestLocal(t, "")
+	data := []byte("foo bar")
+
+	require.NoError(t, l.PutObjectStream(context.Background(), "machine/rp/file.csv", bytes.NewReader(data), int64(len(data))))
+	var buf bytes.Buffer
+	require.NoError(t, l.GetObjectStream(context.Background(), "machine/rp/file.csv", &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// a short stream is not stored as object
+	assert.Error(t, l.PutObjectStream(context.Background(), "machine/rp/short", bytes.NewReader(data), 100))
+	_, err := os.Stat(l.filename("machine/rp/short"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLocal_GetObjectRange(t *testing.T) {
+	l := newTestLocal(t, "")
+	require.NoError(t, l.PutObject(context.Background(), "pack", []byte("foo bar baz")))
+
+	got, err := l.GetObjectRange(context.Background(), "pack", 4, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), got)
+
+	_, err = l.GetObjectRange(context.Background(), "pack", 8, 10)
+	assert.Error(t, err, "range past the end of object")
+}
+
+func TestLocal_KeyPrefix(t *testing.T) {
+	l := newTestLocal(t, "tenant/prod")
+	require.NoError(t, l.PutObject(context.Background(), "abc", []byte("foo")))
+
+	_, err := os.Stat(filepath.Join(l.Path, "tenant", "prod", "abc"))
+	assert.NoError(t, err)
+}
+
+func TestLocal_ID(t *testing.T) {
+	l := newTestLocal(t, "")
+	id, actionID := l.ID()
+	assert.Equal(t, "vault", id)
+	assert.Equal(t, "action", actionID)
+}
@@ -0,0 +1,72 @@
+package storage_vault
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Middleware wraps a storage vault to change objects on their way to and from it (e.g. compression, encryption).
+type Middleware func(next StorageVault) StorageVault
+
+// MiddlewareFactory creates a middleware from its options in config.
+type MiddlewareFactory func(options map[string]string) (Middleware, error)
+
+// MiddlewareSpec is a middleware of a storage vault in config.
+type MiddlewareSpec struct {
+	Name    string            `mapstructure:"name"`
+	Options map[string]string `mapstructure:"options"`
+}
+
+var (
+	middlewaresMu sync.RWMutex
+	middlewares   = make(map[string]MiddlewareFactory)
+)
+
+// RegisterMiddleware makes a middleware available by name in config. It panics when name is already registered.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	if _, ok := middlewares[name]; ok {
+		panic("storage_vault: middleware " + name + " is already registered")
+	}
+	middlewares[name] = factory
+}
+
+// Middlewares returns the sorted names of registered middlewares.
+func Middlewares() []string {
+	middlewaresMu.RLock()
+	defer middlewaresMu.RUnlock()
+	names := make([]string, 0, len(middlewares))
+	for name := range middlewares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain wraps vault with middlewares of specs. The first middleware sees data first on writes and last on reads,
+// so compress then encrypt stores compressed then encrypted objects.
+func Chain(vault StorageVault, specs []MiddlewareSpec) (StorageVault, error) {
+	chain := make([]Middleware, len(specs))
+	middlewaresMu.RLock()
+	for i, spec := range specs {
+		factory, ok := middlewares[spec.Name]
+		if !ok {
+			middlewaresMu.RUnlock()
+			return nil, fmt.Errorf("unknown storage vault middleware %q", spec.Name)
+		}
+		m, err := factory(spec.Options)
+		if err != nil {
+			middlewaresMu.RUnlock()
+			return nil, fmt.Errorf("storage vault middleware %s: %w", spec.Name, err)
+		}
+		chain[i] = m
+	}
+	middlewaresMu.RUnlock()
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		vault = chain[i](vault)
+	}
+	return vault, nil
+}
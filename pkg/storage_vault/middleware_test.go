@@ -0,0 +1,93 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeVault("store")
+	vault, err := Chain(store, []MiddlewareSpec{
+		{Name: "compress"},
+		{Name: "encrypt", Options: map[string]string{"key": testKey}},
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("compressible content "), 1000)
+	require.NoError(t, vault.PutObject(ctx, "key", data))
+
+	stored := store.objects["key"]
+	assert.Less(t, len(stored), len(data)/5, "compressed before encrypted")
+	assert.False(t, bytes.Contains(stored, []byte("compressible")))
+
+	got, err := vault.GetObject(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// the same content is stored as the same object
+	require.NoError(t, vault.PutObject(ctx, "key2", data))
+	assert.Equal(t, stored, store.objects["key2"])
+
+	var buf bytes.Buffer
+	require.NoError(t, vault.PutObjectStream(ctx, "stream", bytes.NewReader(data), int64(len(data))))
+	require.NoError(t, vault.GetObjectStream(ctx, "stream", &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// objects stored before middlewares were configured are read as they are
+	require.NoError(t, store.PutObject(ctx, "plain", []byte("plain")))
+	got, err = vault.GetObject(ctx, "plain")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("plain"), got)
+
+	assert.True(t, transformed(vault))
+	assert.False(t, transformed(store))
+	assert.Equal(t, StorageVault(store), vault.(Wrapper).Unwrap().(Wrapper).Unwrap())
+}
+
+func TestChainErrors(t *testing.T) {
+	_, err := Chain(newFakeVault("store"), []MiddlewareSpec{{Name: "unknown"}})
+	assert.Error(t, err)
+	_, err = Chain(newFakeVault("store"), []MiddlewareSpec{{Name: "encrypt", Options: map[string]string{"key": "short"}}})
+	assert.Error(t, err)
+	_, err = Chain(newFakeVault("store"), []MiddlewareSpec{{Name: "compress", Options: map[string]string{"algorithm": "zip"}}})
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { RegisterMiddleware("compress", newCompressMiddleware) })
+	assert.Equal(t, []string{"compress", "encrypt"}, Middlewares())
+}
+
+func TestEncryptWrongKey(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeVault("store")
+	vault, err := Chain(store, []MiddlewareSpec{{Name: "encrypt", Options: map[string]string{"key": testKey}}})
+	require.NoError(t, err)
+	require.NoError(t, vault.PutObject(ctx, "key", []byte("secret")))
+
+	otherKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{8}, 32))
+	other, err := Chain(store, []MiddlewareSpec{{Name: "encrypt", Options: map[string]string{"key": otherKey}}})
+	require.NoError(t, err)
+	_, err = other.GetObject(ctx, "key")
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestHKDFSHA256(t *testing.T) {
+	// RFC 5869 test case 3, the first 32 bytes of OKM
+	okm := hkdfSHA256(bytes.Repeat([]byte{0x0b}, 22), "")
+	assert.Equal(t, "8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d", hex.EncodeToString(okm))
+
+	key := bytes.Repeat([]byte{7}, 32)
+	encKey, macKey := hkdfSHA256(key, encryptKeyInfo), hkdfSHA256(key, encryptMACInfo)
+	assert.NotEqual(t, key, encKey)
+	assert.NotEqual(t, key, macKey)
+	assert.NotEqual(t, encKey, macKey, "nonces and encryption use separate subkeys")
+}
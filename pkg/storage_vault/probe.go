@@ -0,0 +1,111 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const probeSize = 4 * 1024
+
+// Probe operations.
+const (
+	ProbePut    = "put"
+	ProbeHead   = "head"
+	ProbeGet    = "get"
+	ProbeDelete = "delete"
+)
+
+// ProbeStep is the result of one operation of a probe.
+type ProbeStep struct {
+	Operation        string `json:"operation"`
+	LatencyMs        int64  `json:"latency_ms"`
+	Error            string `json:"error,omitempty"`
+	PermissionDenied bool   `json:"permission_denied,omitempty"`
+}
+
+// ProbeResult is the result of a probe round trip.
+type ProbeResult struct {
+	OK    bool        `json:"ok"`
+	Steps []ProbeStep `json:"steps"`
+}
+
+// Probe checks connectivity and permissions of vault by putting, heading, getting then deleting a small object at key.
+func Probe(ctx context.Context, vault StorageVault, key string) ProbeResult {
+	data := make([]byte, probeSize)
+	_, _ = rand.Read(data)
+	hash := md5.Sum(data)
+	etag := hex.EncodeToString(hash[:])
+
+	result := ProbeResult{OK: true}
+	run := func(operation string, fn func() error) bool {
+		start := time.Now()
+		err := fn()
+		step := ProbeStep{Operation: operation, LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.OK = false
+			step.Error = err.Error()
+			step.PermissionDenied = IsPermissionError(err)
+		}
+		result.Steps = append(result.Steps, step)
+		return err == nil
+	}
+
+	if !run(ProbePut, func() error { return vault.PutObject(ctx, key, data) }) {
+		return result
+	}
+	run(ProbeHead, func() error {
+		isExist, objectEtag, err := vault.HeadObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !isExist {
+			return errors.New("object not found after put")
+		}
+		// ETag of an object stored by a transform is not the MD5 of probe data
+		if !transformed(vault) && !strings.Contains(objectEtag, etag) {
+			return fmt.Errorf("etag mismatch: got %s, want %s", objectEtag, etag)
+		}
+		return nil
+	})
+	run(ProbeGet, func() error {
+		buf, err := vault.GetObject(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, data) {
+			return errors.New("content mismatch")
+		}
+		return nil
+	})
+	run(ProbeDelete, func() error { return vault.DeleteObject(ctx, key) })
+	return result
+}
+
+// IsPermissionError reports whether err is caused by missing permission or invalid credential of storage vault.
+func IsPermissionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return true
+	}
+	switch ErrorCode(err) {
+	case "AccessDenied", "Forbidden", "SignatureDoesNotMatch", "InvalidAccessKeyId", "ExpiredToken":
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"accessdenied", "forbidden", "signaturedoesnotmatch", "invalidaccesskeyid", "unauthorized", "expiredtoken"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package storage_vault
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// md5Vault is fakeVault returning md5 of content as etag.
+type md5Vault struct {
+	*fakeVault
+}
+
+func (v md5Vault) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	isExist, _, err := v.fakeVault.HeadObject(ctx, key)
+	if !isExist || err != nil {
+		return isExist, "", err
+	}
+	return true, fmt.Sprintf("\"%x\"", md5.Sum(v.objects[key])), nil
+}
+
+func TestProbe(t *testing.T) {
+	vault := md5Vault{newFakeVault("vault")}
+	result := Probe(context.Background(), vault, "machine/.vault-test/1")
+	assert.True(t, result.OK)
+	require.Len(t, result.Steps, 4)
+	for i, op := range []string{ProbePut, ProbeHead, ProbeGet, ProbeDelete} {
+		assert.Equal(t, op, result.Steps[i].Operation)
+		assert.Empty(t, result.Steps[i].Error)
+	}
+	assert.Empty(t, vault.objects)
+
+	vault.down = true
+	result = Probe(context.Background(), vault, "machine/.vault-test/2")
+	assert.False(t, result.OK)
+	require.Len(t, result.Steps, 1)
+	assert.Equal(t, errUnavailable.Error(), result.Steps[0].Error)
+	assert.False(t, result.Steps[0].PermissionDenied)
+}
+
+func TestIsPermissionError(t *testing.T) {
+	assert.True(t, IsPermissionError(errors.New("AccessDenied: Access Denied status code: 403")))
+	assert.True(t, IsPermissionError(fmt.Errorf("put: %w", os.ErrPermission)))
+	assert.False(t, IsPermissionError(errors.New("connection refused")))
+	assert.False(t, IsPermissionError(nil))
+}
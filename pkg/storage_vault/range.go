@@ -0,0 +1,49 @@
+package storage_vault
+
+import (
+	"context"
+	"fmt"
+)
+
+// RangeGetter is implemented by storage vaults which can download a part of an object without the whole of it.
+type RangeGetter interface {
+	// GetObjectRange downloads length bytes of object key from offset.
+	GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error)
+}
+
+// rangeGetter returns the vault downloading parts of objects of vault. Objects stored encoded by a transform have no
+// range of their content, nor have objects of a replicated vault whose primary misses them. Parts are downloaded at
+// the bandwidth of a limited vault.
+func rangeGetter(vault StorageVault) (RangeGetter, bool) {
+	switch v := vault.(type) {
+	case *transformVault:
+		return nil, false
+	case *limitedVault:
+		g, ok := rangeGetter(v.StorageVault)
+		if !ok {
+			return nil, false
+		}
+		return &limitedRangeGetter{RangeGetter: g, limiter: v.limiter}, true
+	case RangeGetter:
+		return v, true
+	case Wrapper:
+		return rangeGetter(v.Unwrap())
+	}
+	return nil, false
+}
+
+// GetObjectRange downloads length bytes of object key of vault from offset. The whole object is downloaded when the
+// backend of vault can not download a part of it.
+func GetObjectRange(ctx context.Context, vault StorageVault, key string, offset, length int64) ([]byte, error) {
+	if g, ok := rangeGetter(vault); ok {
+		return g.GetObjectRange(ctx, key, offset, length)
+	}
+	data, err := vault.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(data)) {
+		return nil, fmt.Errorf("range %d-%d out of object %s of %d bytes", offset, offset+length, key, len(data))
+	}
+	return data[offset : offset+length], nil
+}
@@ -0,0 +1,47 @@
+package storage_vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rangeVault is a fakeVault downloading parts of objects.
+type rangeVault struct {
+	*fakeVault
+	ranges int
+}
+
+func (v *rangeVault) GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	v.ranges++
+	data, err := v.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return data[offset : offset+length], nil
+}
+
+func TestGetObjectRange(t *testing.T) {
+	ctx := context.Background()
+	store := &rangeVault{fakeVault: newFakeVault("store")}
+	require.NoError(t, store.PutObject(ctx, "pack", []byte("foo bar baz")))
+
+	got, err := GetObjectRange(ctx, SkipStored(store, func(string) bool { return false }), "pack", 4, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), got)
+	assert.Equal(t, 1, store.ranges, "range is downloaded by backend under wrappers")
+
+	// encoded objects are downloaded whole
+	vault, err := Chain(store, []MiddlewareSpec{{Name: "encrypt", Options: map[string]string{"key": testKey}}})
+	require.NoError(t, err)
+	require.NoError(t, vault.PutObject(ctx, "encrypted", []byte("foo bar baz")))
+	got, err = GetObjectRange(ctx, vault, "encrypted", 8, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("baz"), got)
+	assert.Equal(t, 1, store.ranges)
+
+	_, err = GetObjectRange(ctx, vault, "encrypted", 8, 10)
+	assert.Error(t, err, "range past the end of object")
+}
@@ -0,0 +1,193 @@
+package storage_vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// replicatedVault is a StorageVault which writes every object to primary and mirror vaults.
+type replicatedVault struct {
+	vaults []StorageVault
+}
+
+// NewReplicated returns a StorageVault which fans out PutObject to primary and all mirrors, so each of them keeps
+// a full copy of recovery points. Objects are read from the first vault which has them, primary first.
+// ID, Type and RefreshCredential are the ones of primary, mirrors refresh their own credential.
+func NewReplicated(primary StorageVault, mirrors ...StorageVault) StorageVault {
+	if len(mirrors) == 0 {
+		return primary
+	}
+	return &replicatedVault{vaults: append([]StorageVault{primary}, mirrors...)}
+}
+
+func (r *replicatedVault) primary() StorageVault {
+	return r.vaults[0]
+}
+
+func (r *replicatedVault) vaultName(v StorageVault) string {
+	id, _ := v.ID()
+	return fmt.Sprintf("%s (%s)", id, v.Type().StorageVaultType)
+}
+
+// HeadObject returns true only when object exists in all vaults, so missing copies are uploaded again.
+func (r *replicatedVault) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	var etag string
+	for i, v := range r.vaults {
+		isExist, vEtag, err := v.HeadObject(ctx, key)
+		if err != nil {
+			return false, "", fmt.Errorf("storage vault %s: %w", r.vaultName(v), err)
+		}
+		if !isExist {
+			return false, "", nil
+		}
+		if i == 0 {
+			etag = vEtag
+		}
+	}
+	return true, etag, nil
+}
+
+// PutObject stores data to all vaults concurrently, it fails when any of them fails.
+func (r *replicatedVault) PutObject(ctx context.Context, key string, data []byte) error {
+	errs := make([]error, len(r.vaults))
+	var wg sync.WaitGroup
+	for i, v := range r.vaults {
+		wg.Add(1)
+		go func(i int, v StorageVault) {
+			defer wg.Done()
+			if err := v.PutObject(ctx, key, data); err != nil {
+				errs[i] = fmt.Errorf("storage vault %s: %w", r.vaultName(v), err)
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutObjectStream streams r to all vaults concurrently through pipes, so r is read once and is not buffered.
+// It fails when any of them fails.
+func (r *replicatedVault) PutObjectStream(ctx context.Context, key string, reader io.Reader, size int64) error {
+	errs := make([]error, len(r.vaults))
+	writers := make([]io.Writer, len(r.vaults))
+	pipes := make([]*io.PipeWriter, len(r.vaults))
+	var wg sync.WaitGroup
+	for i, v := range r.vaults {
+		pr, pw := io.Pipe()
+		writers[i], pipes[i] = pw, pw
+		wg.Add(1)
+		go func(i int, v StorageVault, pr *io.PipeReader) {
+			defer wg.Done()
+			err := v.PutObjectStream(ctx, key, pr, size)
+			if err != nil {
+				errs[i] = fmt.Errorf("storage vault %s: %w", r.vaultName(v), err)
+				// unblock the writer when vault gives up before reading all data
+				_ = pr.CloseWithError(err)
+				return
+			}
+			// drain data the vault did not read, so other vaults get the rest
+			_, _ = io.Copy(ioutil.Discard, pr)
+		}(i, v, pr)
+	}
+	_, errCopy := io.Copy(io.MultiWriter(writers...), reader)
+	for _, pw := range pipes {
+		_ = pw.CloseWithError(errCopy)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return errCopy
+}
+
+// GetObject returns object from the first healthy vault.
+func (r *replicatedVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	var errs []error
+	for _, v := range r.vaults {
+		data, err := v.GetObject(ctx, key)
+		if err == nil {
+			return data, nil
+		}
+		errs = append(errs, fmt.Errorf("storage vault %s: %w", r.vaultName(v), err))
+	}
+	return nil, joinErrors(errs)
+}
+
+// GetObjectStream writes object from the first healthy vault to w. Another vault is only tried when
+// nothing has been written to w yet.
+func (r *replicatedVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	var errs []error
+	for _, v := range r.vaults {
+		cw := &countWriter{w: w}
+		err := v.GetObjectStream(ctx, key, cw)
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("storage vault %s: %w", r.vaultName(v), err)
+		if cw.n > 0 {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return joinErrors(errs)
+}
+
+// DeleteObject removes object from all vaults.
+func (r *replicatedVault) DeleteObject(ctx context.Context, key string) error {
+	var errs []error
+	for _, v := range r.vaults {
+		if err := v.DeleteObject(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("storage vault %s: %w", r.vaultName(v), err))
+		}
+	}
+	if len(errs) > 0 {
+		return joinErrors(errs)
+	}
+	return nil
+}
+
+func (r *replicatedVault) RefreshCredential(credential Credential) error {
+	return r.primary().RefreshCredential(credential)
+}
+
+func (r *replicatedVault) ID() (string, string) {
+	return r.primary().ID()
+}
+
+func (r *replicatedVault) Type() Type {
+	return r.primary().Type()
+}
+
+// joinErrors returns the first error which also reports the others.
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return errors.New("no storage vault")
+	}
+	err := errs[0]
+	for _, e := range errs[1:] {
+		err = fmt.Errorf("%w; %v", err, e)
+	}
+	return err
+}
+
+// countWriter counts bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,158 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+type fakeVault struct {
+	id      string
+	mu      sync.Mutex
+	objects map[string][]byte
+	down    bool
+}
+
+func newFakeVault(id string) *fakeVault {
+	return &fakeVault{id: id, objects: make(map[string][]byte)}
+}
+
+func (f *fakeVault) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return false, "", errUnavailable
+	}
+	_, ok := f.objects[key]
+	return ok, f.id, nil
+}
+
+func (f *fakeVault) PutObject(ctx context.Context, key string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errUnavailable
+	}
+	f.objects[key] = data
+	return nil
+}
+
+func (f *fakeVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	f.mu.Lock()
+	down := f.down
+	f.mu.Unlock()
+	if down {
+		return errUnavailable
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return f.PutObject(ctx, key, data)
+}
+
+func (f *fakeVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errUnavailable
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (f *fakeVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	data, err := f.GetObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (f *fakeVault) DeleteObject(ctx context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errUnavailable
+	}
+	delete(f.objects, key)
+	return nil
+}
+
+func (f *fakeVault) RefreshCredential(Credential) error { return nil }
+func (f *fakeVault) ID() (string, string)               { return f.id, "action" }
+func (f *fakeVault) Type() Type                         { return Type{StorageVaultType: "FAKE"} }
+
+func TestReplicatedVault(t *testing.T) {
+	primary, mirror := newFakeVault("primary"), newFakeVault("mirror")
+	vault := NewReplicated(primary, mirror)
+
+	id, _ := vault.ID()
+	assert.Equal(t, "primary", id)
+
+	require.NoError(t, vault.PutObject(context.Background(), "key", []byte("data")))
+	assert.Equal(t, []byte("data"), primary.objects["key"])
+	assert.Equal(t, []byte("data"), mirror.objects["key"])
+
+	isExist, etag, err := vault.HeadObject(context.Background(), "key")
+	require.NoError(t, err)
+	assert.True(t, isExist)
+	assert.Equal(t, "primary", etag)
+
+	// object missing in mirror must be uploaded again
+	delete(mirror.objects, "key")
+	isExist, _, err = vault.HeadObject(context.Background(), "key")
+	require.NoError(t, err)
+	assert.False(t, isExist)
+
+	// read from mirror when primary is down
+	primary.down = true
+	mirror.objects["key"] = []byte("data")
+	data, err := vault.GetObject(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+
+	err = vault.PutObject(context.Background(), "other", []byte("data"))
+	assert.ErrorIs(t, err, errUnavailable)
+
+	mirror.down = true
+	_, err = vault.GetObject(context.Background(), "key")
+	assert.ErrorIs(t, err, errUnavailable)
+}
+
+func TestReplicatedVault_Stream(t *testing.T) {
+	primary, mirror := newFakeVault("primary"), newFakeVault("mirror")
+	vault := NewReplicated(primary, mirror)
+
+	data := bytes.Repeat([]byte("data"), 64*1024)
+	require.NoError(t, vault.PutObjectStream(context.Background(), "key", bytes.NewReader(data), int64(len(data))))
+	assert.Equal(t, data, primary.objects["key"])
+	assert.Equal(t, data, mirror.objects["key"])
+
+	primary.down = true
+	var buf bytes.Buffer
+	require.NoError(t, vault.GetObjectStream(context.Background(), "key", &buf))
+	assert.Equal(t, data, buf.Bytes())
+
+	// a vault failing before reading must not block the others
+	err := vault.PutObjectStream(context.Background(), "other", bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, errUnavailable)
+}
+
+func TestNewReplicated_NoMirror(t *testing.T) {
+	primary := newFakeVault("primary")
+	assert.Same(t, primary, NewReplicated(primary))
+}
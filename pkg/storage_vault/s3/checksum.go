@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// checksumMetadata is the user metadata (x-amz-meta-sha256) holding the SHA-256 of object content. Unlike ETag,
+// it does not depend on multipart upload or server-side encryption.
+const checksumMetadata = "sha256"
+
+// checksum returns the hex encoded SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumMeta returns user metadata of an object with checksum sum, nil when sum is empty.
+func checksumMeta(sum string) map[string]string {
+	if sum == "" {
+		return nil
+	}
+	return map[string]string{checksumMetadata: sum}
+}
+
+// storedChecksum returns the checksum in user metadata of object, empty when it was uploaded without one.
+func storedChecksum(metadata map[string]string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, checksumMetadata) {
+			return v
+		}
+	}
+	return ""
+}
+
+// verifyChecksum checks downloaded data of key against the checksum in metadata of object.
+// Objects uploaded without checksum are not checked.
+func verifyChecksum(key string, metadata map[string]string, data []byte) error {
+	want := storedChecksum(metadata)
+	if want == "" {
+		return nil
+	}
+	return matchChecksum(key, checksum(data), want)
+}
+
+func matchChecksum(key, got, want string) error {
+	if got != want {
+		return fmt.Errorf("%w: %s sha256 %s, want %s", storage_vault.ErrChecksumMismatch, key, got, want)
+	}
+	return nil
+}
+
+// sameContent reports whether the stored object with metadata and etag has content data. Objects uploaded without
+// checksum are compared by ETag, which is only the MD5 of content for single part uploads without SSE-KMS or SSE-C.
+func (s3 *S3) sameContent(metadata map[string]string, etag string, data []byte) bool {
+	if sum := storedChecksum(metadata); sum != "" {
+		return sum == checksum(data)
+	}
+	etag = strings.Trim(etag, `"`)
+	if s3.sse.opaqueETag() || strings.Contains(etag, "-") {
+		return false
+	}
+	sum := md5.Sum(data)
+	return etag == hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// dataSHA256 is the SHA-256 of "data".
+const dataSHA256 = "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+
+func newChecksumTestS3(t *testing.T, h http.HandlerFunc) *S3 {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.downloadConcurrency = 1
+	return s3
+}
+
+func TestS3_PutObjectChecksum(t *testing.T) {
+	var stored string
+	s3 := newChecksumTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+			if stored == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("X-Amz-Meta-Sha256", stored)
+			// multipart ETag can not be compared with content
+			w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e-2"`)
+		case http.MethodPut:
+			stored = r.Header.Get("X-Amz-Meta-Sha256")
+		}
+	})
+	if err := s3.PutObject(context.Background(), "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if stored != dataSHA256 {
+		t.Fatalf("stored checksum = %q, want %q", stored, dataSHA256)
+	}
+	isExist, integrity, _, err := s3.VerifyObject(context.Background(), "key", []byte("data"))
+	if err != nil || !isExist || !integrity {
+		t.Errorf("VerifyObject() = %v, %v, %v, want exist with integrity", isExist, integrity, err)
+	}
+	_, integrity, _, _ = s3.VerifyObject(context.Background(), "key", []byte("other"))
+	if integrity {
+		t.Error("VerifyObject() of other content has integrity")
+	}
+}
+
+func TestS3_GetObjectChecksumMismatch(t *testing.T) {
+	s3 := newChecksumTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Meta-Sha256", dataSHA256)
+		_, _ = w.Write([]byte("tampered"))
+	})
+	if _, err := s3.GetObject(context.Background(), "key"); !errors.Is(err, storage_vault.ErrChecksumMismatch) {
+		t.Errorf("GetObject() error = %v, want checksum mismatch", err)
+	}
+	if err := s3.GetObjectStream(context.Background(), "key", ioutil.Discard); !errors.Is(err, storage_vault.ErrChecksumMismatch) {
+		t.Errorf("GetObjectStream() error = %v, want checksum mismatch", err)
+	}
+}
+
+func TestS3_sameContentLegacy(t *testing.T) {
+	s3 := &S3{}
+	// md5 of "data"
+	etag := `"8d777f385d3dfec8815d20f7496026dc"`
+	if !s3.sameContent(nil, etag, []byte("data")) {
+		t.Error("sameContent() of matching ETag = false")
+	}
+	if s3.sameContent(nil, etag, []byte("other")) {
+		t.Error("sameContent() of other content = true")
+	}
+	if s3.sameContent(nil, `"8d777f385d3dfec8815d20f7496026dc-2"`, []byte("data")) {
+		t.Error("sameContent() of multipart ETag = true")
+	}
+}
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	storage "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// objectLock is the retention and legal hold set on objects uploaded to an Object Lock enabled bucket.
+type objectLock struct {
+	mode      types.ObjectLockMode
+	retention time.Duration
+	legalHold bool
+
+	// enabled is 1 when Object Lock is found enabled on the bucket, objects uploaded to it must carry Content-MD5.
+	enabled int32
+}
+
+// newObjectLock returns the object lock given by storage vault.
+func newObjectLock(vault backupapi.StorageVault) (objectLock, error) {
+	l := objectLock{
+		mode:      types.ObjectLockMode(strings.ToUpper(vault.ObjectLockMode)),
+		retention: time.Duration(vault.ObjectLockRetentionDays) * 24 * time.Hour,
+		legalHold: vault.ObjectLockLegalHold,
+	}
+	switch l.mode {
+	case "":
+		if l.retention != 0 {
+			return objectLock{}, errors.New("object lock retention is given without mode")
+		}
+	case types.ObjectLockModeGovernance, types.ObjectLockModeCompliance:
+		if l.retention <= 0 {
+			return objectLock{}, fmt.Errorf("object lock mode %s is given without retention days", l.mode)
+		}
+	default:
+		return objectLock{}, fmt.Errorf("object lock mode not supported %s", l.mode)
+	}
+	return l, nil
+}
+
+// retainUntil returns the retention date of an object uploaded now, nil when no retention is set.
+func (l *objectLock) retainUntil() *time.Time {
+	if l.mode == "" {
+		return nil
+	}
+	return aws.Time(time.Now().Add(l.retention))
+}
+
+// legalHoldStatus returns the legal hold of uploaded objects, empty when it is not set.
+func (l *objectLock) legalHoldStatus() types.ObjectLockLegalHoldStatus {
+	if !l.legalHold {
+		return ""
+	}
+	return types.ObjectLockLegalHoldStatusOn
+}
+
+// needContentMD5 reports whether uploads must carry Content-MD5, which S3 requires for objects stored with Object Lock.
+func (l *objectLock) needContentMD5() bool {
+	return l.mode != "" || l.legalHold || atomic.LoadInt32(&l.enabled) == 1
+}
+
+// Immutable reports whether Object Lock is enabled on the bucket, so stored objects can not be deleted or
+// overwritten before their retention expires.
+func (s3 *S3) Immutable(ctx context.Context) (bool, error) {
+	resp, err := s3.S3Session.GetObjectLockConfiguration(ctx, &storage.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s3.StorageBucket),
+	})
+	if err != nil {
+		if storage_vault.ErrorCode(err) == "ObjectLockConfigurationNotFoundError" {
+			return false, nil
+		}
+		return false, err
+	}
+	enabled := resp.ObjectLockConfiguration != nil &&
+		resp.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled
+	if enabled {
+		atomic.StoreInt32(&s3.lock.enabled, 1)
+	}
+	return enabled, nil
+}
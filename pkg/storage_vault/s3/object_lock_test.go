@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+func Test_newObjectLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		vault   backupapi.StorageVault
+		want    types.ObjectLockMode
+		wantErr bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:  "governance",
+			vault: backupapi.StorageVault{ObjectLockMode: "governance", ObjectLockRetentionDays: 30},
+			want:  types.ObjectLockModeGovernance,
+		},
+		{
+			name:  "legal hold only",
+			vault: backupapi.StorageVault{ObjectLockLegalHold: true},
+		},
+		{
+			name:    "mode without retention",
+			vault:   backupapi.StorageVault{ObjectLockMode: "COMPLIANCE"},
+			wantErr: true,
+		},
+		{
+			name:    "retention without mode",
+			vault:   backupapi.StorageVault{ObjectLockRetentionDays: 30},
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			vault:   backupapi.StorageVault{ObjectLockMode: "FOREVER", ObjectLockRetentionDays: 30},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newObjectLock(tt.vault)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newObjectLock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.mode != tt.want {
+				t.Errorf("newObjectLock() mode = %v, want %v", got.mode, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3_PutObjectLocked(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+		ObjectLockMode:          "COMPLIANCE",
+		ObjectLockRetentionDays: 30,
+		ObjectLockLegalHold:     true,
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.putObject(context.Background(), "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("X-Amz-Object-Lock-Mode"); got != "COMPLIANCE" {
+		t.Errorf("object lock mode header = %q", got)
+	}
+	until, err := time.Parse(time.RFC3339, header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(until); d < 29*24*time.Hour || d > 30*24*time.Hour {
+		t.Errorf("retain until date = %v", until)
+	}
+	if got := header.Get("X-Amz-Object-Lock-Legal-Hold"); got != "ON" {
+		t.Errorf("legal hold header = %q", got)
+	}
+	if got := header.Get("Content-Md5"); got != "jXd/OF09/siBXSD3SWAm3A==" {
+		t.Errorf("Content-MD5 header = %q", got)
+	}
+}
+
+func TestS3_Immutable(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{
+			name:   "enabled",
+			status: http.StatusOK,
+			body:   `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`,
+			want:   true,
+		},
+		{
+			name:   "not configured",
+			status: http.StatusNotFound,
+			body:   `<Error><Code>ObjectLockConfigurationNotFoundError</Code></Error>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := r.URL.Query()["object-lock"]; !ok {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer ts.Close()
+
+			s3, err := NewS3Default(backupapi.StorageVault{
+				StorageBucket: "bucket",
+				Credential: storage_vault.Credential{
+					AwsAccessKeyId:     "access",
+					AwsSecretAccessKey: "secret",
+					AwsLocation:        ts.URL,
+				},
+			}, "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := storage_vault.Immutable(context.Background(), s3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Immutable() = %v, want %v", got, tt.want)
+			}
+			if s3.lock.needContentMD5() != tt.want {
+				t.Errorf("needContentMD5() = %v, want %v", s3.lock.needContentMD5(), tt.want)
+			}
+		})
+	}
+}
@@ -2,28 +2,43 @@ package s3
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	storage "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	storage "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/cenkalti/backoff"
+	"github.com/panjf2000/ants/v2"
 	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 
 	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 	"github.com/bizflycloud/bizfly-backup/pkg/limiter"
+	"github.com/bizflycloud/bizfly-backup/pkg/proxy"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
 )
 
+// defaultRegion is used to sign requests when storage vault has no region.
+const defaultRegion = "us-east-1"
+
 type S3 struct {
 	Id               string
 	ActionID         string
@@ -34,7 +49,22 @@ type S3 struct {
 	StorageVaultType string
 	Location         string
 	Region           string
-	S3Session        *storage.S3
+	KeyPrefix        string
+	S3Session        *storage.Client
+
+	// downloadConcurrency is the number of parallel ranged requests downloading an object larger than
+	// downloadPartSize, objects are downloaded by a single request when it is not greater than 1.
+	downloadConcurrency int
+	// uploadConcurrency is the number of parts of a multipart upload uploaded at the same time.
+	uploadConcurrency int
+	// requestRate limits requests when the bucket throttles them, it is shared by all actions using the bucket.
+	requestRate *limiter.AdaptiveRate
+	// sse is the server-side encryption given by credential of storage vault.
+	sse sse
+	// storageClass of uploaded objects, the bucket default when empty.
+	storageClass types.StorageClass
+	// lock is the retention and legal hold of uploaded objects when the bucket has Object Lock enabled.
+	lock objectLock
 
 	logger       *zap.Logger
 	backupClient *backupapi.Client
@@ -52,14 +82,34 @@ func (s3 *S3) ID() (string, string) {
 	return s3.Id, s3.ActionID
 }
 
+// objectKey returns the key of object in bucket with key prefix of storage vault.
+func (s3 *S3) objectKey(key string) string {
+	return storage_vault.ObjectKey(s3.KeyPrefix, key)
+}
+
 var _ storage_vault.StorageVault = (*S3)(nil)
-var uploadKb, downloadKb int
 
-var maxPartSize        = int64(50 * 1024 * 1024)
+var maxPartSize = int64(50 * 1024 * 1024)
 
-func NewS3Default(vault backupapi.StorageVault, actionID string, limitUpload, limitDownload int, backupClient *backupapi.Client) (*S3, error) {
-	uploadKb, downloadKb = limitUpload, limitDownload
+// downloadPartSize is the size of ranges an object is downloaded in parallel.
+var downloadPartSize = int64(2 * 1024 * 1024)
+
+// defaultDownloadConcurrency and defaultUploadConcurrency are used when "download_concurrency"
+// and "upload_concurrency" are not set.
+const (
+	defaultDownloadConcurrency = 4
+	defaultUploadConcurrency   = 4
+)
 
+// requestRates holds the request rate limiter of each bucket.
+var requestRates sync.Map
+
+func bucketRequestRate(location, bucket string) *limiter.AdaptiveRate {
+	rate, _ := requestRates.LoadOrStore(location+"/"+bucket, limiter.NewAdaptiveRate())
+	return rate.(*limiter.AdaptiveRate)
+}
+
+func NewS3Default(vault backupapi.StorageVault, actionID string, backupClient *backupapi.Client) (*S3, error) {
 	s3 := &S3{
 		Id:               vault.ID,
 		ActionID:         actionID,
@@ -70,9 +120,23 @@ func NewS3Default(vault backupapi.StorageVault, actionID string, limitUpload, li
 		StorageVaultType: vault.StorageVaultType,
 		Location:         vault.Credential.AwsLocation,
 		Region:           vault.Credential.Region,
+		KeyPrefix:        vault.KeyPrefix,
+		requestRate:      bucketRequestRate(vault.Credential.AwsLocation, vault.StorageBucket),
 		backupClient:     backupClient,
 	}
 
+	if s3.KeyPrefix == "" {
+		s3.KeyPrefix = viper.GetString("key_prefix")
+	}
+	s3.downloadConcurrency = defaultDownloadConcurrency
+	if viper.IsSet("download_concurrency") {
+		s3.downloadConcurrency = viper.GetInt("download_concurrency")
+	}
+	s3.uploadConcurrency = defaultUploadConcurrency
+	if viper.IsSet("upload_concurrency") {
+		s3.uploadConcurrency = viper.GetInt("upload_concurrency")
+	}
+
 	if s3.logger == nil {
 		l, err := backupapi.WriteLog()
 		if err != nil {
@@ -81,12 +145,36 @@ func NewS3Default(vault backupapi.StorageVault, actionID string, limitUpload, li
 		s3.logger = l
 	}
 
-	cred := credentials.NewStaticCredentials(vault.Credential.AwsAccessKeyId, vault.Credential.AwsSecretAccessKey, vault.Credential.Token)
-	_, err := cred.Get()
+	sse, err := newSSE(vault.Credential)
+	if err != nil {
+		return nil, err
+	}
+	s3.sse = sse
+	storageClass, err := parseStorageClass(vault.StorageClass)
+	if err != nil {
+		return nil, err
+	}
+	s3.storageClass = storageClass
+	lock, err := newObjectLock(vault)
 	if err != nil {
-		s3.logger.Error("Bad credentials", zap.Error(err))
+		return nil, err
 	}
+	s3.lock = lock
 
+	s3.S3Session, err = s3.newClient(vault.Credential)
+	if err != nil {
+		return nil, err
+	}
+	return s3, nil
+}
+
+// newClient creates S3 client with given credential. Its throughput is not limited, objects are limited by the limit
+// stage of the storage path, see storage_vault.WithLimit.
+func (s3 *S3) newClient(credential storage_vault.Credential) (*storage.Client, error) {
+	proxyFunc, err := proxy.ForStorageVault(s3.Id)
+	if err != nil {
+		return nil, err
+	}
 	// using a Custom HTTP Transport
 	rt, err := storage_vault.Transport(storage_vault.TransportOptions{
 		Connect:          30 * time.Second,
@@ -97,40 +185,102 @@ func NewS3Default(vault backupapi.StorageVault, actionID string, limitUpload, li
 		MaxHostIdleConns: 100,
 		ResponseHeader:   10 * time.Second,
 		TLSHandshake:     10 * time.Second,
+		Proxy:            proxyFunc,
 	})
 	if err != nil {
 		s3.logger.Error("Got an error creating custom HTTP client", zap.Error(err))
 	}
 
-	// wrap the transport so that the throughput via HTTP is limited
-	lim := limiter.NewStaticLimiter(limitUpload, limitDownload)
-	rt = lim.Transport(rt)
-
-	sess := storage.New(session.Must(session.NewSession(&aws.Config{
-		DisableSSL:       aws.Bool(false),
-		Credentials:      cred,
-		Endpoint:         aws.String(vault.Credential.AwsLocation),
-		Region:           aws.String(vault.Credential.Region),
-		S3ForcePathStyle: aws.Bool(true),
-		LogLevel: 		  aws.LogLevel(aws.LogDebug),
+	region := s3.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	return storage.New(storage.Options{
+		Credentials:      aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(credential.AwsAccessKeyId, credential.AwsSecretAccessKey, credential.Token)),
+		EndpointResolver: storage.EndpointResolverFromURL(endpointURL(s3.Location)),
+		Region:           region,
+		UsePathStyle:     true,
 		HTTPClient:       &http.Client{Transport: rt},
-	})))
-	s3.S3Session = sess
-	return s3, nil
+		APIOptions:       []func(*middleware.Stack) error{s3.throttleMiddleware},
+	}), nil
+}
+
+// throttleMiddleware makes each attempt of a request wait for requestRate, and adapts the rate to
+// throttled responses.
+func (s3 *S3) throttleMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("RequestRate", func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		if err := s3.requestRate.Wait(ctx); err != nil {
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+		}
+		out, md, err := next.HandleFinalize(ctx, in)
+		switch {
+		case storage_vault.IsThrottled(err):
+			s3.requestRate.Throttled()
+			s3.logger.Warn("Request throttled by storage", zap.String("bucket", s3.StorageBucket), zap.Float64("requestsPerSecond", s3.requestRate.Rate()))
+		case err == nil:
+			s3.requestRate.Succeeded()
+		}
+		return out, md, err
+	}), middleware.After)
+}
 
+// ThrottledRequests returns the number of requests to the bucket throttled by storage.
+func (s3 *S3) ThrottledRequests() int64 {
+	return s3.requestRate.Count()
 }
 
-type HTTPClient struct{}
+// parseStorageClass checks storage class of storage vault. Objects in GLACIER and DEEP_ARCHIVE must be restored
+// before they can be read, so these classes are not supported.
+func parseStorageClass(class string) (types.StorageClass, error) {
+	storageClass := types.StorageClass(strings.ToUpper(class))
+	switch storageClass {
+	case "":
+		return "", nil
+	case types.StorageClassGlacier, types.StorageClassDeepArchive:
+		return "", fmt.Errorf("storage class %s can not be read without restoring objects", storageClass)
+	}
+	for _, c := range storageClass.Values() {
+		if c == storageClass {
+			return storageClass, nil
+		}
+	}
+	return "", fmt.Errorf("storage class not supported %s", class)
+}
 
-var (
-	HttpClient = HTTPClient{}
-)
+// endpointURL adds https scheme to location without scheme.
+func endpointURL(location string) string {
+	if location == "" || strings.Contains(location, "://") {
+		return location
+	}
+	return "https://" + location
+}
 
 const (
 	maxRetry = 3 * time.Minute
 )
 
-func (s3 *S3) VerifyObject(key string) (bool, bool, string, error) {
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// sleepRandom waits for up to 3 seconds before retrying a request denied by storage.
+func sleepRandom(ctx context.Context) error {
+	rand.Seed(time.Now().UnixNano())
+	n := rand.Intn(3)
+	return sleep(ctx, time.Duration(n)*time.Second)
+}
+
+// VerifyObject returns whether object exists, whether its content is data by the SHA-256 checksum it was uploaded
+// with, and its ETag.
+func (s3 *S3) VerifyObject(ctx context.Context, key string, data []byte) (bool, bool, string, error) {
 	var isExist bool
 	var integrity bool
 	var etag string
@@ -140,20 +290,25 @@ func (s3 *S3) VerifyObject(key string) (bool, bool, string, error) {
 	bo.MaxElapsedTime = maxRetry
 
 	for {
-		isExist, etag, err = s3.HeadObject(key)
+		var headObject *storage.HeadObjectOutput
+		headObject, err = s3.headObject(ctx, key)
 		if err == nil {
-			if isExist {
-				integrity = strings.Contains(etag, key)
-			}
+			isExist = true
+			etag = aws.ToString(headObject.ETag)
+			integrity = s3.sameContent(headObject.Metadata, etag, data)
 			break
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NotFound" {
-				err = nil
-				break
-			}
-			s3.logger.Sugar().Errorf("VerifyObject error: %s %s", aerr.Code(), aerr.Message())
-			if (aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" || aerr.Code() == "SignatureDoesNotMatch" ) && s3.Type().CredentialType == "DEFAULT" {
+		if ctx.Err() != nil {
+			return false, false, "", ctx.Err()
+		}
+		code := storage_vault.ErrorCode(err)
+		if code == "NotFound" {
+			err = nil
+			break
+		}
+		if code != "" {
+			s3.logger.Sugar().Errorf("VerifyObject error: %s %s", code, err)
+			if (code == "AccessDenied" || code == "Forbidden" || code == "SignatureDoesNotMatch") && s3.Type().CredentialType == "DEFAULT" {
 				s3.logger.Sugar().Info("GetCredential in head object ", key)
 				storageVaultID, actID := s3.ID()
 				vault, err := s3.backupClient.GetCredentialStorageVault(storageVaultID, actID, nil)
@@ -177,63 +332,71 @@ func (s3 *S3) VerifyObject(key string) (bool, bool, string, error) {
 			break
 		}
 		s3.logger.Sugar().Info("VerifyObject. Retry in ", d)
+		if err := sleep(ctx, d); err != nil {
+			return false, false, "", err
+		}
 	}
 	return isExist, integrity, etag, err
 }
 
-func (s3 *S3) PutObject(key string, data []byte) error {
+// putObject uploads data in one request, or in multiple parts when it is larger than maxPartSize.
+func (s3 *S3) putObject(ctx context.Context, key string, data []byte) error {
+	if int64(len(data)) > maxPartSize {
+		return s3.putObjectMultiPart(ctx, key, data)
+	}
+	input := &storage.PutObjectInput{
+		Bucket:               aws.String(s3.StorageBucket),
+		Key:                  aws.String(s3.objectKey(key)),
+		Body:                 bytes.NewReader(data),
+		Metadata:             checksumMeta(checksum(data)),
+		StorageClass:         s3.storageClass,
+		ServerSideEncryption: s3.sse.encryption,
+		SSEKMSKeyId:          s3.sse.kmsKeyID,
+		SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+		SSECustomerKey:       s3.sse.customerKey,
+		SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+
+		ObjectLockMode:            s3.lock.mode,
+		ObjectLockRetainUntilDate: s3.lock.retainUntil(),
+		ObjectLockLegalHoldStatus: s3.lock.legalHoldStatus(),
+	}
+	if s3.sse.opaqueETag() || s3.lock.needContentMD5() {
+		sum := md5.Sum(data)
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	_, err := s3.S3Session.PutObject(ctx, input)
+	return err
+}
+
+func (s3 *S3) PutObject(ctx context.Context, key string, data []byte) error {
 	var err error
 	var once bool
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = maxRetry
 	bo.MaxElapsedTime = maxRetry
 	for {
-		isExist, integrity, _, _ := s3.VerifyObject(key)
+		isExist, integrity, _, errVerify := s3.VerifyObject(ctx, key, data)
+		if errVerify != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if isExist {
-			if !integrity {
-				if int64(len(data)) > maxPartSize {
-					err = s3.putObjectMultiPart(key, data)
-				} else {
-					_, err = s3.S3Session.PutObject(&storage.PutObjectInput{
-						Bucket: aws.String(s3.StorageBucket),
-						Key:    aws.String(key),
-						Body:   bytes.NewReader(data),
-					})
-				}
-
-				if err == nil {
-					break
-				}
-			} else {
+			if integrity {
 				break
 			}
-		} else {
-			if int64(len(data)) > maxPartSize {
-				err = s3.putObjectMultiPart(key, data)
-			} else {
-				_, err = s3.S3Session.PutObject(&storage.PutObjectInput{
-					Bucket: aws.String(s3.StorageBucket),
-					Key:    aws.String(key),
-					Body:   bytes.NewReader(data),
-				})
+			err = s3.putObject(ctx, key, data)
+			if err == nil {
+				break
 			}
+		} else {
+			err = s3.putObject(ctx, key, data)
 			if !strings.Contains(key, "chunk.json") && !strings.Contains(key, "index.json") && !strings.Contains(key, "file.csv") {
-				isExist, integrity, _, _ = s3.VerifyObject(key)
+				isExist, integrity, _, _ = s3.VerifyObject(ctx, key, data)
 				if isExist {
-					if !integrity {
-						if int64(len(data)) > maxPartSize {
-							err = s3.putObjectMultiPart(key, data)
-						} else {
-							_, err = s3.S3Session.PutObject(&storage.PutObjectInput{
-								Bucket: aws.String(s3.StorageBucket),
-								Key:    aws.String(key),
-								Body:   bytes.NewReader(data),
-							})
-						}
-						if err == nil {
-							break
-						}
-					} else {
+					if integrity {
+						break
+					}
+					err = s3.putObject(ctx, key, data)
+					if err == nil {
 						break
 					}
 				}
@@ -242,18 +405,21 @@ func (s3 *S3) PutObject(key string, data []byte) error {
 				break
 			}
 		}
-		if aerr, ok := err.(awserr.Error); ok {
-			s3.logger.Sugar().Errorf("PutObject error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" || aerr.Code() == "SignatureDoesNotMatch" {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if code := storage_vault.ErrorCode(err); code != "" {
+			s3.logger.Sugar().Errorf("PutObject error: %s %s", code, err)
+			if code == "AccessDenied" || code == "Forbidden" || code == "SignatureDoesNotMatch" {
 				if once {
-					s3.logger.Error("Return false cause in put object: ", zap.Error(err), zap.String("code", aerr.Code()), zap.String("key", key))
+					s3.logger.Error("Return false cause in put object: ", zap.Error(err), zap.String("code", code), zap.String("key", key))
 					return err
 				}
 				s3.logger.Info("Put object one more time")
 				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
+				if err := sleepRandom(ctx); err != nil {
+					return err
+				}
 			}
 		}
 		s3.logger.Debug("PutObject error. Retrying")
@@ -263,54 +429,309 @@ func (s3 *S3) PutObject(key string, data []byte) error {
 			break
 		}
 		s3.logger.Sugar().Info("PutObject error. Retry in ", d)
-		time.Sleep(d)
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
 	}
 
 	return err
 }
 
+// putObjectMultiPart uploads data in parts of maxPartSize, up to uploadConcurrency parts are uploaded at the same time.
+func (s3 *S3) putObjectMultiPart(ctx context.Context, key string, data []byte) error {
+	respMPU, err := s3.createMultiPartUpload(ctx, key, checksum(data))
+	if err != nil {
+		return err
+	}
 
-func (s3 *S3) putObjectMultiPart(key string, data []byte) (error) {
-	respMPU, err := s3.createMultiPartUpload(key)
+	concurrency := s3.uploadConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pool, err := ants.NewPool(concurrency)
 	if err != nil {
 		return err
 	}
-	var curr, partLength int64
-	var remaining = int64(len(data))
-	var completedParts []*storage.CompletedPart
+	defer pool.Release()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	numParts := (int64(len(data)) + maxPartSize - 1) / maxPartSize
+	completedParts := make([]types.CompletedPart, numParts)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var errUpload error
+	for i := int64(0); i < numParts; i++ {
+		start, end := i*maxPartSize, (i+1)*maxPartSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		partNumber := int(i) + 1
+		wg.Add(1)
+		err := pool.Submit(func() {
+			defer wg.Done()
+			if ctx.Err() != nil {
+				return
+			}
+			completedPart, err := s3.uploadPart(ctx, respMPU, data[start:end], partNumber)
+			if err != nil {
+				errOnce.Do(func() {
+					errUpload = err
+					cancel()
+				})
+				return
+			}
+			completedParts[partNumber-1] = *completedPart
+		})
+		if err != nil {
+			wg.Done()
+			errOnce.Do(func() {
+				errUpload = err
+				cancel()
+			})
+			break
+		}
+	}
+	wg.Wait()
+
+	if errUpload != nil {
+		s3.logger.Error("uploadPart error", zap.Error(errUpload), zap.String("key", key))
+		if errAbort := s3.abortMultiPartUpload(ctx, respMPU); errAbort != nil {
+			s3.logger.Sugar().Error(errAbort.Error())
+		}
+		return errUpload
+	}
+
+	completeResponse, err := s3.completeMultiPartUpload(ctx, respMPU, completedParts)
+	if err != nil {
+		s3.logger.Sugar().Error(err.Error())
+		return err
+	}
+
+	s3.logger.Sugar().Info("Successfully uploaded file: ", aws.ToString(completeResponse.Key))
+	return nil
+}
+
+// PutObjectStream uploads size bytes read from r. Objects larger than maxPartSize are uploaded in parts one by one,
+// so at most one part is held in memory.
+func (s3 *S3) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > maxPartSize {
+		return s3.putObjectMultiPartStream(ctx, key, r, size)
+	}
+	rewind := storage_vault.Rewinder(r)
+	if rewind == nil {
+		// the request body must be seekable to be signed, a small object is read into memory instead
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+		rewind = storage_vault.Rewinder(r)
+	}
+	md5Hash, sha256Hash := md5.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash), r); err != nil {
+		return err
+	}
+	var contentMD5 *string
+	if s3.lock.needContentMD5() {
+		contentMD5 = aws.String(base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)))
+	}
+	metadata := checksumMeta(hex.EncodeToString(sha256Hash.Sum(nil)))
+	return s3.retry(ctx, "PutObjectStream", key, func() error {
+		if err := rewind(); err != nil {
+			return err
+		}
+		_, err := s3.S3Session.PutObject(ctx, &storage.PutObjectInput{
+			Bucket:               aws.String(s3.StorageBucket),
+			Key:                  aws.String(s3.objectKey(key)),
+			Body:                 r,
+			ContentLength:        size,
+			ContentMD5:           contentMD5,
+			Metadata:             metadata,
+			StorageClass:         s3.storageClass,
+			ServerSideEncryption: s3.sse.encryption,
+			SSEKMSKeyId:          s3.sse.kmsKeyID,
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+
+			ObjectLockMode:            s3.lock.mode,
+			ObjectLockRetainUntilDate: s3.lock.retainUntil(),
+			ObjectLockLegalHoldStatus: s3.lock.legalHoldStatus(),
+		})
+		return err
+	})
+}
+
+func (s3 *S3) putObjectMultiPartStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	// the checksum is sent when the upload is created, so a seekable stream is read twice to have it,
+	// objects uploaded from other streams have no checksum
+	var sum string
+	if rewind := storage_vault.Rewinder(r); rewind != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		if err := rewind(); err != nil {
+			return err
+		}
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	respMPU, err := s3.createMultiPartUpload(ctx, key, sum)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, maxPartSize)
+	var completedParts []types.CompletedPart
 	partNumber := 1
-	for curr = 0; remaining != 0; curr += partLength {
-		if remaining < maxPartSize {
+	for remaining := size; remaining > 0; {
+		partLength := maxPartSize
+		if remaining < partLength {
 			partLength = remaining
-		} else {
-			partLength = maxPartSize
 		}
-		completedPart, err := s3.uploadPart(respMPU, data[curr:curr+partLength], partNumber)
+		if _, err := io.ReadFull(r, buf[:partLength]); err == nil {
+			var completedPart *types.CompletedPart
+			completedPart, err = s3.uploadPart(ctx, respMPU, buf[:partLength], partNumber)
+			if err == nil {
+				remaining -= partLength
+				partNumber++
+				completedParts = append(completedParts, *completedPart)
+				continue
+			}
+		}
+		s3.logger.Error("uploadPart error", zap.Error(err), zap.String("key", key))
+		if errAbort := s3.abortMultiPartUpload(ctx, respMPU); errAbort != nil {
+			s3.logger.Sugar().Error(errAbort.Error())
+		}
+		return err
+	}
+
+	if _, err := s3.completeMultiPartUpload(ctx, respMPU, completedParts); err != nil {
+		s3.logger.Sugar().Error(err.Error())
+		return err
+	}
+	s3.logger.Sugar().Info("Successfully uploaded file: ", key)
+	return nil
+}
+
+// GetObject downloads the object, its content is checked against the checksum it was uploaded with.
+func (s3 *S3) GetObject(ctx context.Context, key string) ([]byte, error) {
+	if s3.downloadConcurrency <= 1 {
+		obj, err := s3.getObject(ctx, key, "")
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		data, err := ioutil.ReadAll(obj.Body)
 		if err != nil {
-			fmt.Println(err.Error())
-			err := s3.abortMultiPartUpload(respMPU)
+			return nil, err
+		}
+		if err := verifyChecksum(key, obj.Metadata, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return s3.getObjectRanged(ctx, key)
+}
+
+// getObjectRanged downloads the first downloadPartSize bytes of object, the rest of a larger object is downloaded
+// in ranges of downloadPartSize by up to downloadConcurrency requests in parallel.
+func (s3 *S3) getObjectRanged(ctx context.Context, key string) ([]byte, error) {
+	obj, err := s3.getObject(ctx, key, byteRange(0, downloadPartSize))
+	if storage_vault.ErrorCode(err) == "InvalidRange" {
+		// empty object has no range to satisfy
+		obj, err = s3.getObject(ctx, key, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	first, err := ioutil.ReadAll(obj.Body)
+	obj.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	total, ok := totalSize(aws.ToString(obj.ContentRange))
+	if !ok || total <= int64(len(first)) {
+		// the whole object is in the first response
+		if err := verifyChecksum(key, obj.Metadata, first); err != nil {
+			return nil, err
+		}
+		return first, nil
+	}
+
+	buf := make([]byte, total)
+	copy(buf, first)
+	sem := semaphore.NewWeighted(int64(s3.downloadConcurrency))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for start := int64(len(first)); start < total; start += downloadPartSize {
+		start, end := start, start+downloadPartSize
+		if end > total {
+			end = total
+		}
+		if err := sem.Acquire(groupCtx, 1); err != nil {
+			break
+		}
+		group.Go(func() error {
+			defer sem.Release(1)
+			obj, err := s3.getObject(groupCtx, key, byteRange(start, end))
 			if err != nil {
-				s3.logger.Sugar().Error(err.Error())
 				return err
 			}
+			defer obj.Body.Close()
+			if _, err := io.ReadFull(obj.Body, buf[start:end]); err != nil {
+				return fmt.Errorf("read range %d-%d of %s: %w", start, end, key, err)
+			}
 			return nil
-		}
-		remaining -= partLength
-		partNumber++
-		completedParts = append(completedParts, completedPart)
+		})
+	}
+	if err := group.Wait(); err != nil {
+		s3.logger.Error("GetObject ranged error", zap.Error(err), zap.String("key", key))
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := verifyChecksum(key, obj.Metadata, buf); err != nil {
+		return nil, err
 	}
+	return buf, nil
+}
 
-	completeResponse, err := s3.completeMultiPartUpload(respMPU, completedParts)
+// GetObjectRange downloads length bytes of the object from offset, they are not checked against the checksum of
+// the whole object.
+func (s3 *S3) GetObjectRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	obj, err := s3.getObject(ctx, key, byteRange(offset, offset+length))
 	if err != nil {
-		s3.logger.Sugar().Error(err.Error())
-		return err
+		return nil, err
 	}
+	defer obj.Body.Close()
+	data := make([]byte, length)
+	if _, err := io.ReadFull(obj.Body, data); err != nil {
+		return nil, fmt.Errorf("read range %d-%d of %s: %w", offset, offset+length, key, err)
+	}
+	return data, nil
+}
 
-	s3.logger.Sugar().Info("Successfully uploaded file: %s\n", completeResponse.String())
-	return nil
+// byteRange returns value of Range header for bytes [start, end).
+func byteRange(start, end int64) string {
+	return fmt.Sprintf("bytes=%d-%d", start, end-1)
+}
+
+// totalSize returns the object size in Content-Range header, e.g. "bytes 0-1023/4096".
+func totalSize(contentRange string) (int64, bool) {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
 }
 
-func (s3 *S3) GetObject(key string) ([]byte, error) {
+// getObject requests the object, or the part of object in byteRange when it is not empty.
+func (s3 *S3) getObject(ctx context.Context, key string, rng string) (*storage.GetObjectOutput, error) {
 	var err error
 	var once bool
 	bo := backoff.NewExponentialBackOff()
@@ -318,30 +739,40 @@ func (s3 *S3) GetObject(key string) ([]byte, error) {
 	bo.MaxElapsedTime = maxRetry
 	var obj *storage.GetObjectOutput
 	for {
-		obj, err = s3.S3Session.GetObject(&storage.GetObjectInput{
-			Bucket: aws.String(s3.StorageBucket),
-			Key:    aws.String(key),
-		})
+		input := &storage.GetObjectInput{
+			Bucket:               aws.String(s3.StorageBucket),
+			Key:                  aws.String(s3.objectKey(key)),
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+		}
+		if rng != "" {
+			input.Range = aws.String(rng)
+		}
+		obj, err = s3.S3Session.GetObject(ctx, input)
 		if err == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NoSuchKey" {
+		if code := storage_vault.ErrorCode(err); code != "" {
+			if code == "NoSuchKey" {
 				return nil, err
 			}
 
-			s3.logger.Sugar().Errorf("GetObject error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" {
+			s3.logger.Sugar().Errorf("GetObject error: %s %s", code, err)
+			if code == "AccessDenied" || code == "Forbidden" {
 				if once {
-					s3.logger.Error("Return false cause in get object: ", zap.Error(err), zap.String("code", aerr.Code()), zap.String("key", key))
+					s3.logger.Error("Return false cause in get object: ", zap.Error(err), zap.String("code", code), zap.String("key", key))
 					return nil, err
 				}
 				s3.logger.Sugar().Info("Get object one more time ", key)
 				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
+				if err := sleepRandom(ctx); err != nil {
+					return nil, err
+				}
 			} else {
 				return nil, err
 			}
@@ -350,18 +781,67 @@ func (s3 *S3) GetObject(key string) ([]byte, error) {
 		d := bo.NextBackOff()
 		if d == backoff.Stop {
 			s3.logger.Debug("GetObject error. Retry time out")
-			break
+			return nil, err
 		}
 		s3.logger.Sugar().Info("GetObject error. Retry in ", d)
-		time.Sleep(d)
+		if err := sleep(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// GetObjectStream copies the object to w, only the request is retried as w may have got part of object.
+// A checksum mismatch is returned after whole content is copied.
+func (s3 *S3) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	var obj *storage.GetObjectOutput
+	err := s3.retry(ctx, "GetObjectStream", key, func() error {
+		var err error
+		obj, err = s3.S3Session.GetObject(ctx, &storage.GetObjectInput{
+			Bucket:               aws.String(s3.StorageBucket),
+			Key:                  aws.String(s3.objectKey(key)),
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+		})
+		return err
+	})
+	if err != nil {
+		return err
 	}
+	defer obj.Body.Close()
+	if storedChecksum(obj.Metadata) == "" {
+		_, err = io.Copy(w, obj.Body)
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), obj.Body); err != nil {
+		return err
+	}
+	return matchChecksum(key, hex.EncodeToString(h.Sum(nil)), storedChecksum(obj.Metadata))
+}
 
-	body, err := ioutil.ReadAll(obj.Body)
+func (s3 *S3) DeleteObject(ctx context.Context, key string) error {
+	_, err := s3.S3Session.DeleteObject(ctx, &storage.DeleteObjectInput{
+		Bucket: aws.String(s3.StorageBucket),
+		Key:    aws.String(s3.objectKey(key)),
+	})
+	if err != nil {
+		s3.logger.Error("DeleteObject error", zap.Error(err), zap.String("key", key))
+	}
+	return err
+}
 
-	return body, err
+func (s3 *S3) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	headObject, err := s3.headObject(ctx, key)
+	if err != nil {
+		return false, "", err
+	}
+	return true, aws.ToString(headObject.ETag), nil
 }
 
-func (s3 *S3) HeadObject(key string) (bool, string, error) {
+// headObject requests metadata of object, a missing object gives "NotFound" error.
+func (s3 *S3) headObject(ctx context.Context, key string) (*storage.HeadObjectOutput, error) {
 	var err error
 	var headObject *storage.HeadObjectOutput
 	var once bool
@@ -369,30 +849,36 @@ func (s3 *S3) HeadObject(key string) (bool, string, error) {
 	bo.MaxInterval = maxRetry
 	bo.MaxElapsedTime = maxRetry
 	for {
-		headObject, err = s3.S3Session.HeadObject(&storage.HeadObjectInput{
-			Bucket: aws.String(s3.StorageBucket),
-			Key:    aws.String(key),
+		headObject, err = s3.S3Session.HeadObject(ctx, &storage.HeadObjectInput{
+			Bucket:               aws.String(s3.StorageBucket),
+			Key:                  aws.String(s3.objectKey(key)),
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
 		})
 		if err == nil {
-			return true, *headObject.ETag, nil
+			return headObject, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
 
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NotFound" {
-				return false, "", err
+		if code := storage_vault.ErrorCode(err); code != "" {
+			if code == "NotFound" {
+				return nil, err
 			}
 
-			s3.logger.Sugar().Errorf("HeadObject error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" {
+			s3.logger.Sugar().Errorf("HeadObject error: %s %s", code, err)
+			if code == "AccessDenied" || code == "Forbidden" {
 				if once {
-					s3.logger.Error("Return false cause in head object: ", zap.Error(err), zap.String("code", aerr.Code()), zap.String("key", key))
-					return false, "", err
+					s3.logger.Error("Return false cause in head object: ", zap.Error(err), zap.String("code", code), zap.String("key", key))
+					return nil, err
 				}
 				s3.logger.Sugar().Info("Head object one more time ", key)
 				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
+				if err := sleepRandom(ctx); err != nil {
+					return nil, err
+				}
 			}
 		}
 		s3.logger.Debug("Head object error. Retrying")
@@ -402,239 +888,179 @@ func (s3 *S3) HeadObject(key string) (bool, string, error) {
 			break
 		}
 		s3.logger.Sugar().Info("Head object error. Retry in ", d)
-		time.Sleep(d)
-
+		if err := sleep(ctx, d); err != nil {
+			return nil, err
+		}
 	}
-	return false, "", err
+	return nil, err
 }
 
-
-func (s3 *S3) createMultiPartUpload(key string) (*storage.CreateMultipartUploadOutput, error) {
+// retry calls fn until it succeeds, object is not found, ctx is done or retry time out. Access denied is retried once.
+func (s3 *S3) retry(ctx context.Context, name, key string, fn func() error) error {
 	var err error
 	var once bool
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = maxRetry
 	bo.MaxElapsedTime = maxRetry
 	for {
-		resp, err := s3.S3Session.CreateMultipartUpload(&storage.CreateMultipartUploadInput{
-			Bucket: aws.String(s3.StorageBucket),
-			Key:    aws.String(key),
-		})
+		err = fn()
 		if err == nil {
-			s3.logger.Sugar().Info("Created MultiPartUpload for ", key)
-			return resp, nil
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NotFound" {
-				return nil, err
+		if code := storage_vault.ErrorCode(err); code != "" {
+			if code == "NotFound" || code == "NoSuchKey" {
+				return err
 			}
 
-			s3.logger.Sugar().Errorf("CreateMultipartUpload error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" {
+			s3.logger.Sugar().Errorf("%s error: %s %s", name, code, err)
+			if code == "AccessDenied" || code == "Forbidden" {
 				if once {
-					s3.logger.Error("Return false cause in CreateMultipartUpload object: ", zap.Error(err), zap.String("code", aerr.Code()), zap.String("key", key))
-					return nil, err
+					s3.logger.Error("Return false cause in "+name+": ", zap.Error(err), zap.String("code", code), zap.String("key", key))
+					return err
 				}
-				s3.logger.Sugar().Info("CreateMultipartUpload one more time ", key)
+				s3.logger.Sugar().Info(name+" one more time ", key)
 				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
+				if err := sleepRandom(ctx); err != nil {
+					return err
+				}
 			}
 		}
-		s3.logger.Debug("CreateMultipartUpload  error. Retrying")
+		s3.logger.Debug(name + " error. Retrying")
 		d := bo.NextBackOff()
 		if d == backoff.Stop {
-			s3.logger.Debug("CreateMultipartUpload error. Retry time out", zap.Error(err))
-			break
+			s3.logger.Debug(name+" error. Retry time out", zap.Error(err))
+			return err
+		}
+		s3.logger.Sugar().Info(name+" error. Retry in ", d)
+		if err := sleep(ctx, d); err != nil {
+			return err
 		}
-		s3.logger.Sugar().Info("CreateMultipartUpload error. Retry in ", d)
-		time.Sleep(d)
-
 	}
-	return  nil, err
 }
 
+// createMultiPartUpload starts a multipart upload of key, sum is the checksum of whole content if it is known.
+func (s3 *S3) createMultiPartUpload(ctx context.Context, key, sum string) (*storage.CreateMultipartUploadOutput, error) {
+	var resp *storage.CreateMultipartUploadOutput
+	err := s3.retry(ctx, "CreateMultipartUpload", key, func() error {
+		var err error
+		resp, err = s3.S3Session.CreateMultipartUpload(ctx, &storage.CreateMultipartUploadInput{
+			Bucket:               aws.String(s3.StorageBucket),
+			Key:                  aws.String(s3.objectKey(key)),
+			Metadata:             checksumMeta(sum),
+			StorageClass:         s3.storageClass,
+			ServerSideEncryption: s3.sse.encryption,
+			SSEKMSKeyId:          s3.sse.kmsKeyID,
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+
+			ObjectLockMode:            s3.lock.mode,
+			ObjectLockRetainUntilDate: s3.lock.retainUntil(),
+			ObjectLockLegalHoldStatus: s3.lock.legalHoldStatus(),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s3.logger.Sugar().Info("Created MultiPartUpload for ", key)
+	return resp, nil
+}
 
-func (s3 *S3) completeMultiPartUpload( mpuOut *storage.CreateMultipartUploadOutput, parts []*storage.CompletedPart) (*storage.CompleteMultipartUploadOutput, error) {
-	var err error
-	var once bool
-	bo := backoff.NewExponentialBackOff()
-	bo.MaxInterval = maxRetry
-	bo.MaxElapsedTime = maxRetry
-	for {
-		resp, err := s3.S3Session.CompleteMultipartUpload(&storage.CompleteMultipartUploadInput{
-			Bucket: aws.String(s3.StorageBucket),
-			Key:    mpuOut.Key,
+func (s3 *S3) completeMultiPartUpload(ctx context.Context, mpuOut *storage.CreateMultipartUploadOutput, parts []types.CompletedPart) (*storage.CompleteMultipartUploadOutput, error) {
+	var resp *storage.CompleteMultipartUploadOutput
+	err := s3.retry(ctx, "CompleteMultipartUpload", aws.ToString(mpuOut.Key), func() error {
+		var err error
+		resp, err = s3.S3Session.CompleteMultipartUpload(ctx, &storage.CompleteMultipartUploadInput{
+			Bucket:   aws.String(s3.StorageBucket),
+			Key:      mpuOut.Key,
 			UploadId: mpuOut.UploadId,
-			MultipartUpload: &storage.CompletedMultipartUpload{
+			MultipartUpload: &types.CompletedMultipartUpload{
 				Parts: parts,
 			},
 		})
-		if err == nil {
-			s3.logger.Sugar().Info("Completed Multipart Upload ", mpuOut.Key)
-			return resp, nil
-		}
-
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NotFound" {
-				return nil, err
-			}
-
-			s3.logger.Sugar().Errorf("CompleteMultipartUpload error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" {
-				if once {
-					s3.logger.Error("Return false cause in CompleteMultipartUpload: ", zap.Error(err), zap.String("code", aerr.Code()),  zap.String("key", *mpuOut.Key))
-					return nil, err
-				}
-				s3.logger.Sugar().Info("CompleteMultipartUpload one more time ", mpuOut.Key)
-				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
-			}
-		}
-		s3.logger.Debug("CompleteMultipartUpload  error. Retrying")
-		d := bo.NextBackOff()
-		if d == backoff.Stop {
-			s3.logger.Debug("CompleteMultipartUpload error. Retry time out", zap.Error(err))
-			break
-		}
-		s3.logger.Sugar().Info("CompleteMultipartUpload error. Retry in ", d)
-		time.Sleep(d)
-
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	s3.logger.Sugar().Info("Completed Multipart Upload ", aws.ToString(mpuOut.Key))
+	return resp, nil
 }
 
-
-func (s3 *S3) abortMultiPartUpload(mpuOut *storage.CreateMultipartUploadOutput) (error) {
-	var err error
-	var once bool
-	bo := backoff.NewExponentialBackOff()
-	bo.MaxInterval = maxRetry
-	bo.MaxElapsedTime = maxRetry
-	for {
-		_, err := s3.S3Session.AbortMultipartUpload(&storage.AbortMultipartUploadInput{
-			Bucket: aws.String(s3.StorageBucket),
-			Key:    mpuOut.Key,
+func (s3 *S3) abortMultiPartUpload(ctx context.Context, mpuOut *storage.CreateMultipartUploadOutput) error {
+	// abort even when ctx is canceled, so the uploaded parts do not stay in bucket
+	ctx = context.Background()
+	err := s3.retry(ctx, "AbortMultipartUpload", aws.ToString(mpuOut.Key), func() error {
+		_, err := s3.S3Session.AbortMultipartUpload(ctx, &storage.AbortMultipartUploadInput{
+			Bucket:   aws.String(s3.StorageBucket),
+			Key:      mpuOut.Key,
 			UploadId: mpuOut.UploadId,
 		})
-		if err == nil {
-			s3.logger.Sugar().Info("AbortMultipartUpload Upload ", mpuOut.Key)
-			return  nil
-		}
-
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == "NotFound" {
-				return err
-			}
-
-			s3.logger.Sugar().Errorf("AbortMultipartUpload error: %s %s", aerr.Code(), aerr.Message())
-			if aerr.Code() == "AccessDenied" || aerr.Code() == "Forbidden" {
-				if once {
-					s3.logger.Error("Return false cause in AbortMultipartUpload: ", zap.Error(err), zap.String("code", aerr.Code()), zap.String("key", *mpuOut.Key))
-					return err
-				}
-				s3.logger.Sugar().Info("AbortMultipartUpload one more time ", mpuOut.Key)
-				once = true
-				rand.Seed(time.Now().UnixNano())
-				n := rand.Intn(3) // n will be between 0 and 10
-				time.Sleep(time.Duration(n) * time.Second)
-			}
-		}
-		s3.logger.Debug("AbortMultipartUpload  error. Retrying")
-		d := bo.NextBackOff()
-		if d == backoff.Stop {
-			s3.logger.Debug("AbortMultipartUpload error. Retry time out", zap.Error(err))
-			break
-		}
-		s3.logger.Sugar().Info("AbortMultipartUpload error. Retry in ", d)
-		time.Sleep(d)
-
+		return err
+	})
+	if err != nil {
+		return err
 	}
-	return err
+	s3.logger.Sugar().Info("AbortMultipartUpload Upload ", aws.ToString(mpuOut.Key))
+	return nil
 }
 
-func (s3 *S3) uploadPart(resp *storage.CreateMultipartUploadOutput, fileBytes []byte, partNum int) (*storage.CompletedPart, error) {
+func (s3 *S3) uploadPart(ctx context.Context, resp *storage.CreateMultipartUploadOutput, fileBytes []byte, partNum int) (*types.CompletedPart, error) {
 	tryNum := 1
-	maxRetries         := 3
-	partInput := &storage.UploadPartInput{
-		Body:          bytes.NewReader(fileBytes),
-		Bucket:        resp.Bucket,
-		Key:           resp.Key,
-		PartNumber:    aws.Int64(int64(partNum)),
-		UploadId:      resp.UploadId,
-		ContentLength: aws.Int64(int64(len(fileBytes))),
-	}
-
-	for tryNum <= maxRetries {
-		uploadResult, err := s3.S3Session.UploadPart(partInput)
-		if err != nil {
-			if tryNum == maxRetries {
-				if aerr, ok := err.(awserr.Error); ok {
-					return nil, aerr
-				}
-				return nil, err
-			}
-			s3.logger.Sugar().Info("Retrying to upload part #%v\n", partNum)
-			tryNum++
-		} else {
-			s3.logger.Sugar().Info("Uploaded part #%v\n", partNum)
-			return &storage.CompletedPart{
+	maxRetries := 3
+
+	var contentMD5 *string
+	if s3.lock.needContentMD5() {
+		sum := md5.Sum(fileBytes)
+		contentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	for {
+		uploadResult, err := s3.S3Session.UploadPart(ctx, &storage.UploadPartInput{
+			Body:          bytes.NewReader(fileBytes),
+			Bucket:        resp.Bucket,
+			Key:           resp.Key,
+			PartNumber:    int32(partNum),
+			UploadId:      resp.UploadId,
+			ContentLength: int64(len(fileBytes)),
+			ContentMD5:    contentMD5,
+			// SSE-C key of multipart upload is sent with every part
+			SSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			SSECustomerKey:       s3.sse.customerKey,
+			SSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+		})
+		if err == nil {
+			s3.logger.Sugar().Info("Uploaded part #", partNum)
+			return &types.CompletedPart{
 				ETag:       uploadResult.ETag,
-				PartNumber: aws.Int64(int64(partNum)),
+				PartNumber: int32(partNum),
 			}, nil
 		}
+		if tryNum == maxRetries || ctx.Err() != nil {
+			return nil, fmt.Errorf("upload part #%d: %w", partNum, err)
+		}
+		s3.logger.Sugar().Info("Retrying to upload part #", partNum)
+		tryNum++
 	}
-	return nil, nil
 }
 
-
 func (s3 *S3) RefreshCredential(credential storage_vault.Credential) error {
-	cred := credentials.NewStaticCredentials(credential.AwsAccessKeyId, credential.AwsSecretAccessKey, credential.Token)
-	_, err := cred.Get()
-	if err != nil {
+	if credential.AwsAccessKeyId == "" || credential.AwsSecretAccessKey == "" {
+		err := fmt.Errorf("static credentials are empty")
 		s3.logger.Error("err ", zap.Error(err))
 		return err
 	}
 
-	// using a Custom HTTP Transport
-	rt, err := storage_vault.Transport(storage_vault.TransportOptions{
-		Connect:          30 * time.Second,
-		ExpectContinue:   1 * time.Second,
-		IdleConn:         90 * time.Second,
-		ConnKeepAlive:    30 * time.Second,
-		MaxAllIdleConns:  100,
-		MaxHostIdleConns: 100,
-		ResponseHeader:   10 * time.Second,
-		TLSHandshake:     10 * time.Second,
-	})
+	client, err := s3.newClient(credential)
 	if err != nil {
-		s3.logger.Error("Got an error creating custom HTTP client", zap.Error(err))
-	}
-
-	if uploadKb == 0 {
-		uploadKb = viper.GetInt("limit_upload")
-	}
-	if downloadKb == 0 {
-		downloadKb = viper.GetInt("limit_download")
+		s3.logger.Error("err ", zap.Error(err))
+		return err
 	}
-
-	// wrap the transport so that the throughput via HTTP is limited
-	lim := limiter.NewStaticLimiter(uploadKb, downloadKb)
-	rt = lim.Transport(rt)
-
-	sess := storage.New(session.Must(session.NewSession(&aws.Config{
-		DisableSSL:       aws.Bool(false),
-		Credentials:      cred,
-		Endpoint:         aws.String(s3.Location),
-		Region:           aws.String(s3.Region),
-		S3ForcePathStyle: aws.Bool(true),
-		HTTPClient:       &http.Client{Transport: rt},
-	})))
-	s3.S3Session = sess
+	s3.S3Session = client
 	s3.logger.Info("Refresh credential success")
 	return nil
 }
@@ -1,11 +1,25 @@
 package s3
 
 import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
-	storage "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	storage "github.com/aws/aws-sdk-go-v2/service/s3"
 
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
 	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
 
 	"go.uber.org/zap"
@@ -22,7 +36,7 @@ func TestS3_Type(t *testing.T) {
 		StorageVaultType string
 		Location         string
 		Region           string
-		S3Session        *storage.S3
+		S3Session        *storage.Client
 		logger           *zap.Logger
 	}
 	tests := []struct {
@@ -72,7 +86,7 @@ func TestS3_ID(t *testing.T) {
 		StorageVaultType string
 		Location         string
 		Region           string
-		S3Session        *storage.S3
+		S3Session        *storage.Client
 		logger           *zap.Logger
 	}
 	tests := []struct {
@@ -112,3 +126,333 @@ func TestS3_ID(t *testing.T) {
 		})
 	}
 }
+
+func TestS3_HeadObjectCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = s3.HeadObject(ctx, "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("S3.HeadObject() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("S3.HeadObject() returned after %v, want it to stop retrying when context is done", d)
+	}
+}
+
+func Test_endpointURL(t *testing.T) {
+	for location, want := range map[string]string{
+		"":                         "",
+		"hn.ss.bfcplatform.vn":     "https://hn.ss.bfcplatform.vn",
+		"http://localhost:9000":    "http://localhost:9000",
+		"https://s3.amazonaws.com": "https://s3.amazonaws.com",
+	} {
+		if got := endpointURL(location); got != want {
+			t.Errorf("endpointURL(%q) = %q, want %q", location, got, want)
+		}
+	}
+}
+
+func TestS3_GetObjectRanged(t *testing.T) {
+	data := make([]byte, 5*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	var mu sync.Mutex
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.downloadConcurrency = 2
+
+	got, err := s3.GetObject(context.Background(), "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("S3.GetObject() returned %d bytes, want the %d bytes of object", len(got), len(data))
+	}
+	sort.Strings(ranges)
+	want := []string{"bytes=0-2097151", "bytes=2097152-4194303", "bytes=4194304-5243002"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("S3.GetObject() requested ranges %v, want %v", ranges, want)
+	}
+}
+
+func TestS3_GetObjectRange(t *testing.T) {
+	data := []byte("foo bar baz")
+	var rng string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng = r.Header.Get("Range")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s3.GetObjectRange(context.Background(), "key", 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "bar" || rng != "bytes=4-6" {
+		t.Errorf("S3.GetObjectRange() = %q by range %q, want %q by range %q", got, rng, "bar", "bytes=4-6")
+	}
+}
+
+func Test_totalSize(t *testing.T) {
+	for contentRange, want := range map[string]int64{
+		"bytes 0-1023/4096": 4096,
+		"bytes 0-1023/*":    -1,
+		"":                  -1,
+	} {
+		got, ok := totalSize(contentRange)
+		if want < 0 {
+			if ok {
+				t.Errorf("totalSize(%q) = %d, want not ok", contentRange, got)
+			}
+			continue
+		}
+		if !ok || got != want {
+			t.Errorf("totalSize(%q) = %d, want %d", contentRange, got, want)
+		}
+	}
+}
+
+func TestS3_PutObjectMultiPartConcurrent(t *testing.T) {
+	defer func(size int64) { maxPartSize = size }(maxPartSize)
+	maxPartSize = 1024
+
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	parts := make(map[string][]byte)
+	var completed struct {
+		Parts []struct {
+			ETag       string `xml:"ETag"`
+			PartNumber int    `xml:"PartNumber"`
+		} `xml:"Part"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, initiate := query["uploads"]
+		switch {
+		case r.Method == http.MethodPost && initiate:
+			_, _ = w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`))
+		case r.Method == http.MethodPut && query.Get("uploadId") == "upload":
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			body, _ := ioutil.ReadAll(r.Body)
+			mu.Lock()
+			inFlight--
+			parts[query.Get("partNumber")] = body
+			mu.Unlock()
+			w.Header().Set("ETag", `"etag-`+query.Get("partNumber")+`"`)
+		case r.Method == http.MethodPost && query.Get("uploadId") == "upload":
+			if err := xml.NewDecoder(r.Body).Decode(&completed); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><ETag>"etag"</ETag></CompleteMultipartUploadResult>`))
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.uploadConcurrency = 3
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := s3.putObjectMultiPart(context.Background(), "key", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parts) != 10 {
+		t.Fatalf("uploaded %d parts, want 10", len(parts))
+	}
+	if maxInFlight < 2 || maxInFlight > 3 {
+		t.Errorf("uploaded up to %d parts at the same time, want 2-3", maxInFlight)
+	}
+	var got []byte
+	for i, part := range completed.Parts {
+		if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+			t.Errorf("completed part %d = %+v, want part number %d", i, part, i+1)
+		}
+		got = append(got, parts[strconv.Itoa(part.PartNumber)]...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("content of uploaded parts differs from data")
+	}
+}
+
+func TestS3_Throttled(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`))
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Header().Set("ETag", `"etag"`)
+		}
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func() error {
+		_, err := s3.S3Session.PutObject(context.Background(), &storage.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("key"),
+			Body:   bytes.NewReader([]byte("data")),
+		}, func(o *storage.Options) {
+			// leave retries to the test
+			o.Retryer = aws.NopRetryer{}
+		})
+		return err
+	}
+	if err := put(); !storage_vault.IsThrottled(err) {
+		t.Fatalf("SlowDown error = %v, want throttled", err)
+	}
+	if err := put(); !storage_vault.IsThrottled(err) {
+		t.Fatalf("429 error = %v, want throttled", err)
+	}
+	if err := put(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage_vault.ThrottledRequests(storage_vault.NewReplicated(s3)); got != 2 {
+		t.Errorf("ThrottledRequests() = %d, want 2", got)
+	}
+	if got := s3.requestRate.Rate(); got <= 0 {
+		t.Errorf("request rate = %v, want limited", got)
+	}
+}
+
+func Test_parseStorageClass(t *testing.T) {
+	tests := []struct {
+		class   string
+		want    string
+		wantErr bool
+	}{
+		{class: "", want: ""},
+		{class: "STANDARD_IA", want: "STANDARD_IA"},
+		{class: "glacier_ir", want: "GLACIER_IR"},
+		{class: "GLACIER", wantErr: true},
+		{class: "DEEP_ARCHIVE", wantErr: true},
+		{class: "COLD", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			got, err := parseStorageClass(tt.class)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStorageClass() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("parseStorageClass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3_PutObjectStorageClass(t *testing.T) {
+	var classes []string
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		classes = append(classes, r.Header.Get("X-Amz-Storage-Class"))
+		mu.Unlock()
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		StorageClass:  "STANDARD_IA",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.putObject(context.Background(), "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.PutObjectStream(context.Background(), "key", bytes.NewReader([]byte("data")), 4); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, []string{"STANDARD_IA", "STANDARD_IA"}) {
+		t.Errorf("storage class headers = %v", classes)
+	}
+}
@@ -0,0 +1,71 @@
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	storage "github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+var _ storage_vault.ScopeChecker = (*S3)(nil)
+
+// scopeCheckPrefix is the prefix of the key read outside key prefix of storage vault to check its credential.
+const scopeCheckPrefix = "bizfly-backup-scope-check"
+
+// Operations attempted outside key prefix by CheckScope.
+const (
+	ScopeListBucket = "list_bucket"
+	ScopeHeadObject = "head_object"
+)
+
+// CheckScope lists the bucket root and heads a missing object outside key prefix, a credential scoped to the key
+// prefix is denied both. Finding the object missing means the credential can read and list objects of other
+// prefixes. Nothing is written.
+func (s3 *S3) CheckScope(ctx context.Context) (storage_vault.ScopeResult, error) {
+	prefix := strings.Trim(s3.KeyPrefix, "/")
+	result := storage_vault.ScopeResult{Prefix: prefix}
+	if prefix == "" {
+		return result, nil
+	}
+	result.Checked = true
+
+	_, err := s3.S3Session.ListObjectsV2(ctx, &storage.ListObjectsV2Input{
+		Bucket:  aws.String(s3.StorageBucket),
+		MaxKeys: 1,
+	})
+	if err == nil {
+		result.Allowed = append(result.Allowed, ScopeListBucket)
+	} else if !storage_vault.IsPermissionError(err) {
+		return result, err
+	}
+
+	_, err = s3.S3Session.HeadObject(ctx, &storage.HeadObjectInput{
+		Bucket: aws.String(s3.StorageBucket),
+		Key:    aws.String(scopeCheckKey(prefix)),
+	})
+	if err == nil || storage_vault.ErrorCode(err) == "NotFound" || storage_vault.ErrorCode(err) == "NoSuchKey" {
+		result.Allowed = append(result.Allowed, ScopeHeadObject)
+	} else if !storage_vault.IsPermissionError(err) {
+		return result, err
+	}
+
+	result.OverPrivileged = len(result.Allowed) > 0
+	return result, nil
+}
+
+// scopeCheckKey returns a random key which is not under prefix.
+func scopeCheckKey(prefix string) string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	dir := scopeCheckPrefix
+	if strings.HasPrefix(dir, prefix) {
+		dir = "." + dir
+	}
+	return path.Join(dir, hex.EncodeToString(buf))
+}
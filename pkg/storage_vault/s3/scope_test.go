@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+func TestS3_CheckScope(t *testing.T) {
+	const accessDenied = `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`
+	tests := []struct {
+		name    string
+		prefix  string
+		handler http.HandlerFunc
+		want    storage_vault.ScopeResult
+	}{
+		{
+			name:   "no prefix",
+			prefix: "",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			},
+			want: storage_vault.ScopeResult{},
+		},
+		{
+			name:   "scoped",
+			prefix: "tenant/",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/bucket/tenant/") {
+					t.Errorf("request inside prefix %s", r.URL.Path)
+				}
+				w.WriteHeader(http.StatusForbidden)
+				if r.Method != http.MethodHead {
+					_, _ = w.Write([]byte(accessDenied))
+				}
+			},
+			want: storage_vault.ScopeResult{Prefix: "tenant", Checked: true},
+		},
+		{
+			name:   "over privileged",
+			prefix: "tenant",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					_, _ = w.Write([]byte(`<ListBucketResult><Name>bucket</Name><KeyCount>0</KeyCount></ListBucketResult>`))
+				case http.MethodHead:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			},
+			want: storage_vault.ScopeResult{Prefix: "tenant", Checked: true, OverPrivileged: true, Allowed: []string{ScopeListBucket, ScopeHeadObject}},
+		},
+		{
+			name:   "reads without listing",
+			prefix: "tenant",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(accessDenied))
+				case http.MethodHead:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			},
+			want: storage_vault.ScopeResult{Prefix: "tenant", Checked: true, OverPrivileged: true, Allowed: []string{ScopeHeadObject}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := newChecksumTestS3(t, tt.handler)
+			s3.KeyPrefix = tt.prefix
+			got, err := s3.CheckScope(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Prefix != tt.want.Prefix || got.Checked != tt.want.Checked || got.OverPrivileged != tt.want.OverPrivileged ||
+				strings.Join(got.Allowed, ",") != strings.Join(tt.want.Allowed, ",") {
+				t.Errorf("CheckScope() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3_CheckScopeError(t *testing.T) {
+	s3 := newChecksumTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	s3.KeyPrefix = "tenant"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s3.CheckScope(ctx); err == nil {
+		t.Error("CheckScope() must fail when the bucket can not be reached")
+	}
+}
@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+// sse is the server-side encryption requested for objects of storage vault.
+type sse struct {
+	encryption types.ServerSideEncryption
+	kmsKeyID   *string
+
+	// SSE-C key must be sent with every request reading or writing an object.
+	customerAlgorithm *string
+	customerKey       *string
+	customerKeyMD5    *string
+}
+
+// newSSE returns the server-side encryption given by credential of storage vault.
+func newSSE(credential storage_vault.Credential) (sse, error) {
+	var e sse
+	if credential.SSECustomerKey != "" {
+		if credential.ServerSideEncryption != "" || credential.SSEKMSKeyID != "" {
+			return sse{}, errors.New("SSE-C can not be used with SSE-S3 or SSE-KMS")
+		}
+		key, err := base64.StdEncoding.DecodeString(credential.SSECustomerKey)
+		if err != nil || len(key) != 32 {
+			return sse{}, errors.New("SSE-C key must be a base64 encoded 256-bit key")
+		}
+		sum := md5.Sum(key)
+		e.customerAlgorithm = aws.String(string(types.ServerSideEncryptionAes256))
+		e.customerKey = aws.String(credential.SSECustomerKey)
+		e.customerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		return e, nil
+	}
+
+	e.encryption = types.ServerSideEncryption(credential.ServerSideEncryption)
+	if credential.SSEKMSKeyID != "" {
+		if e.encryption == "" {
+			e.encryption = types.ServerSideEncryptionAwsKms
+		}
+		e.kmsKeyID = aws.String(credential.SSEKMSKeyID)
+	}
+	switch e.encryption {
+	case "", types.ServerSideEncryptionAes256:
+		if e.kmsKeyID != nil {
+			return sse{}, errors.New("SSE-KMS key is given with SSE-S3")
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return sse{}, fmt.Errorf("server-side encryption not supported %s", e.encryption)
+	}
+	return e, nil
+}
+
+// opaqueETag reports whether ETag of objects is not the md5 of their content, which is the case with SSE-KMS and SSE-C.
+func (e sse) opaqueETag() bool {
+	return e.encryption == types.ServerSideEncryptionAwsKms || e.customerKey != nil
+}
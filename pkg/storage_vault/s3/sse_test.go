@@ -0,0 +1,116 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+var testCustomerKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))
+
+func Test_newSSE(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential storage_vault.Credential
+		want       types.ServerSideEncryption
+		opaqueETag bool
+		wantErr    bool
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:       "SSE-S3",
+			credential: storage_vault.Credential{ServerSideEncryption: "AES256"},
+			want:       types.ServerSideEncryptionAes256,
+		},
+		{
+			name:       "SSE-KMS by key ID",
+			credential: storage_vault.Credential{SSEKMSKeyID: "key"},
+			want:       types.ServerSideEncryptionAwsKms,
+			opaqueETag: true,
+		},
+		{
+			name:       "SSE-C",
+			credential: storage_vault.Credential{SSECustomerKey: testCustomerKey},
+			opaqueETag: true,
+		},
+		{
+			name:       "SSE-C key too short",
+			credential: storage_vault.Credential{SSECustomerKey: base64.StdEncoding.EncodeToString([]byte("short"))},
+			wantErr:    true,
+		},
+		{
+			name:       "SSE-C with SSE-KMS",
+			credential: storage_vault.Credential{SSECustomerKey: testCustomerKey, SSEKMSKeyID: "key"},
+			wantErr:    true,
+		},
+		{
+			name:       "SSE-S3 with KMS key",
+			credential: storage_vault.Credential{ServerSideEncryption: "AES256", SSEKMSKeyID: "key"},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown",
+			credential: storage_vault.Credential{ServerSideEncryption: "rot13"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newSSE(tt.credential)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSSE() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.encryption != tt.want {
+				t.Errorf("newSSE() encryption = %v, want %v", got.encryption, tt.want)
+			}
+			if got.opaqueETag() != tt.opaqueETag {
+				t.Errorf("opaqueETag() = %v, want %v", got.opaqueETag(), tt.opaqueETag)
+			}
+		})
+	}
+}
+
+func TestS3_PutObjectSSECustomerKey(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		w.Header().Set("ETag", `"opaque"`)
+	}))
+	defer ts.Close()
+
+	s3, err := NewS3Default(backupapi.StorageVault{
+		StorageBucket: "bucket",
+		Credential: storage_vault.Credential{
+			AwsAccessKeyId:     "access",
+			AwsSecretAccessKey: "secret",
+			AwsLocation:        ts.URL,
+			SSECustomerKey:     testCustomerKey,
+		},
+	}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.putObject(context.Background(), "key", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("X-Amz-Server-Side-Encryption-Customer-Key"); got != testCustomerKey {
+		t.Errorf("customer key header = %q, want %q", got, testCustomerKey)
+	}
+	if got := header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"); got != aws.ToString(s3.sse.customerKeyMD5) {
+		t.Errorf("customer key MD5 header = %q", got)
+	}
+	if got := header.Get("Content-Md5"); got != "jXd/OF09/siBXSD3SWAm3A==" {
+		t.Errorf("Content-MD5 header = %q", got)
+	}
+}
@@ -0,0 +1,102 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	storage "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+var _ storage_vault.Tierer = (*S3)(nil)
+
+// parseArchiveClass checks the storage class objects are archived to, unlike the storage class of uploads it may
+// be GLACIER or DEEP_ARCHIVE.
+func parseArchiveClass(class string) (types.StorageClass, error) {
+	storageClass := types.StorageClass(strings.ToUpper(class))
+	for _, c := range storageClass.Values() {
+		if c == storageClass {
+			return storageClass, nil
+		}
+	}
+	return "", fmt.Errorf("storage class not supported %s", class)
+}
+
+// needsRestore reports whether objects of storage class must be restored before they can be read.
+func needsRestore(class types.StorageClass) bool {
+	return class == types.StorageClassGlacier || class == types.StorageClassDeepArchive
+}
+
+// copySource returns the URL encoded source of copying key in bucket.
+func copySource(bucket, key string) string {
+	segments := strings.Split(bucket+"/"+key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Archive copies object key over itself in storage class.
+func (s3 *S3) Archive(ctx context.Context, key, class string) error {
+	storageClass, err := parseArchiveClass(class)
+	if err != nil {
+		return err
+	}
+	return s3.retry(ctx, "CopyObject", key, func() error {
+		_, err := s3.S3Session.CopyObject(ctx, &storage.CopyObjectInput{
+			Bucket:                         aws.String(s3.StorageBucket),
+			Key:                            aws.String(s3.objectKey(key)),
+			CopySource:                     aws.String(copySource(s3.StorageBucket, s3.objectKey(key))),
+			MetadataDirective:              types.MetadataDirectiveCopy,
+			StorageClass:                   storageClass,
+			ServerSideEncryption:           s3.sse.encryption,
+			SSEKMSKeyId:                    s3.sse.kmsKeyID,
+			SSECustomerAlgorithm:           s3.sse.customerAlgorithm,
+			SSECustomerKey:                 s3.sse.customerKey,
+			SSECustomerKeyMD5:              s3.sse.customerKeyMD5,
+			CopySourceSSECustomerAlgorithm: s3.sse.customerAlgorithm,
+			CopySourceSSECustomerKey:       s3.sse.customerKey,
+			CopySourceSSECustomerKeyMD5:    s3.sse.customerKeyMD5,
+
+			ObjectLockMode:            s3.lock.mode,
+			ObjectLockRetainUntilDate: s3.lock.retainUntil(),
+			ObjectLockLegalHoldStatus: s3.lock.legalHoldStatus(),
+		})
+		return err
+	})
+}
+
+// Retrieve restores archived object key for days with the standard retrieval tier, unless it is restored or being
+// restored already.
+func (s3 *S3) Retrieve(ctx context.Context, key string, days int) (bool, error) {
+	head, err := s3.headObject(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if !needsRestore(head.StorageClass) {
+		return true, nil
+	}
+	if restore := aws.ToString(head.Restore); restore != "" {
+		return strings.Contains(restore, `ongoing-request="false"`), nil
+	}
+	err = s3.retry(ctx, "RestoreObject", key, func() error {
+		_, err := s3.S3Session.RestoreObject(ctx, &storage.RestoreObjectInput{
+			Bucket: aws.String(s3.StorageBucket),
+			Key:    aws.String(s3.objectKey(key)),
+			RestoreRequest: &types.RestoreRequest{
+				Days:                 int32(days),
+				GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+			},
+		})
+		if storage_vault.ErrorCode(err) == "RestoreAlreadyInProgress" {
+			return nil
+		}
+		return err
+	})
+	return false, err
+}
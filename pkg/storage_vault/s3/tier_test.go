@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestS3_Archive(t *testing.T) {
+	var header http.Header
+	var path string
+	s3 := newChecksumTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+		header, path = r.Header, r.URL.Path
+		_, _ = w.Write([]byte(`<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`))
+	})
+	s3.KeyPrefix = "tenant"
+
+	if err := s3.Archive(context.Background(), "chunk key", "glacier"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bucket/tenant/chunk key" {
+		t.Errorf("path = %q", path)
+	}
+	if got := header.Get("X-Amz-Copy-Source"); got != "bucket/tenant/chunk%20key" {
+		t.Errorf("copy source header = %q", got)
+	}
+	if got := header.Get("X-Amz-Storage-Class"); got != "GLACIER" {
+		t.Errorf("storage class header = %q", got)
+	}
+	if got := header.Get("X-Amz-Metadata-Directive"); got != "COPY" {
+		t.Errorf("metadata directive header = %q", got)
+	}
+
+	if err := s3.Archive(context.Background(), "key", "COLD"); err == nil {
+		t.Error("unknown storage class must be rejected")
+	}
+}
+
+func TestS3_Retrieve(t *testing.T) {
+	tests := []struct {
+		name      string
+		class     string
+		restore   string
+		want      bool
+		requested bool
+	}{
+		{name: "hot", class: "STANDARD", want: true},
+		{name: "glacier instant retrieval", class: "GLACIER_IR", want: true},
+		{name: "archived", class: "GLACIER", requested: true},
+		{name: "restoring", class: "DEEP_ARCHIVE", restore: `ongoing-request="true"`},
+		{name: "restored", class: "GLACIER", restore: `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var restoreBody string
+			s3 := newChecksumTestS3(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodHead:
+					w.Header().Set("X-Amz-Storage-Class", tt.class)
+					if tt.restore != "" {
+						w.Header().Set("X-Amz-Restore", tt.restore)
+					}
+				case http.MethodPost:
+					buf, _ := ioutil.ReadAll(r.Body)
+					restoreBody = string(buf)
+					w.WriteHeader(http.StatusAccepted)
+				}
+			})
+			ready, err := s3.Retrieve(context.Background(), "key", 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready != tt.want {
+				t.Errorf("ready = %v, want %v", ready, tt.want)
+			}
+			if requested := restoreBody != ""; requested != tt.requested {
+				t.Errorf("restore requested = %v, want %v", requested, tt.requested)
+			}
+			if tt.requested && (!strings.Contains(restoreBody, "<Days>3</Days>") || !strings.Contains(restoreBody, "<Tier>Standard</Tier>")) {
+				t.Errorf("restore request = %s", restoreBody)
+			}
+		})
+	}
+}
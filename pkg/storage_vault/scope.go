@@ -0,0 +1,36 @@
+package storage_vault
+
+import "context"
+
+// ScopeChecker is implemented by storage vaults whose credential is expected to only give access to objects under
+// their key prefix.
+type ScopeChecker interface {
+	// CheckScope attempts to read outside the key prefix of storage vault, which a scoped credential is denied.
+	CheckScope(ctx context.Context) (ScopeResult, error)
+}
+
+// ScopeResult is the result of checking the scope of a storage vault credential.
+type ScopeResult struct {
+	Prefix string `json:"prefix,omitempty"`
+	// Checked is false when the storage vault has no key prefix to check its credential against, or its backend
+	// can not tell.
+	Checked bool `json:"checked"`
+	// OverPrivileged reports the credential gives access outside the key prefix, Allowed are the operations which
+	// were not denied.
+	OverPrivileged bool     `json:"over_privileged"`
+	Allowed        []string `json:"allowed,omitempty"`
+}
+
+// CheckScope checks the credential of vault only gives access to its key prefix. A replicated vault checks its
+// primary.
+func CheckScope(ctx context.Context, vault StorageVault) (ScopeResult, error) {
+	switch v := vault.(type) {
+	case ScopeChecker:
+		return v.CheckScope(ctx)
+	case *replicatedVault:
+		return CheckScope(ctx, v.vaults[0])
+	case Wrapper:
+		return CheckScope(ctx, v.Unwrap())
+	}
+	return ScopeResult{}, nil
+}
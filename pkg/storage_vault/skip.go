@@ -0,0 +1,39 @@
+package storage_vault
+
+import (
+	"context"
+	"io"
+)
+
+// skipVault is a StorageVault which does not put objects already stored.
+type skipVault struct {
+	StorageVault
+	stored func(key string) bool
+}
+
+// SkipStored wraps vault so putting an object whose key is reported stored does nothing, e.g. chunks uploaded by a
+// backup before it was interrupted. Only content addressed objects may be skipped.
+func SkipStored(vault StorageVault, stored func(key string) bool) StorageVault {
+	return &skipVault{
+		StorageVault: vault,
+		stored:       stored,
+	}
+}
+
+func (v *skipVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+func (v *skipVault) PutObject(ctx context.Context, key string, data []byte) error {
+	if v.stored(key) {
+		return nil
+	}
+	return v.StorageVault.PutObject(ctx, key, data)
+}
+
+func (v *skipVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	if v.stored(key) {
+		return nil
+	}
+	return v.StorageVault.PutObjectStream(ctx, key, r, size)
+}
@@ -1,15 +1,37 @@
 package storage_vault
 
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/smithy-go"
+)
+
 // storageVault ...
+// Object operations stop retrying and return ctx.Err() when ctx is done.
 type StorageVault interface {
 	// HeadObject a boolean value whether object name existing in storage.
-	HeadObject(key string) (bool, string, error)
+	HeadObject(ctx context.Context, key string) (bool, string, error)
 
 	// PutObject stores the data to the storage backend.
-	PutObject(key string, data []byte) error
+	PutObject(ctx context.Context, key string, data []byte) error
+
+	// PutObjectStream stores size bytes read from r to the storage backend without holding whole object in memory.
+	// A failed upload is only retried when r is an io.Seeker.
+	PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error
 
 	// GetObject downloads the object by name in storage.
-	GetObject(key string) ([]byte, error)
+	GetObject(ctx context.Context, key string) ([]byte, error)
+
+	// GetObjectStream downloads the object by name in storage to w.
+	GetObjectStream(ctx context.Context, key string, w io.Writer) error
+
+	// DeleteObject removes the object by name in storage, deleting a missing object is not an error.
+	DeleteObject(ctx context.Context, key string) error
 
 	// SetCredential sets a new credential with backend credential not constant.
 	RefreshCredential(credential Credential) error
@@ -32,4 +54,107 @@ type Credential struct {
 	AwsLocation        string `json:"aws_location,omitempty"`
 	Token              string `json:"token,omitempty"`
 	Region             string `json:"region,omitempty"`
+	StorageURL         string `json:"storage_url,omitempty"`
+
+	// ServerSideEncryption requests S3 to encrypt stored objects, "AES256" (SSE-S3) or "aws:kms" (SSE-KMS).
+	// It defaults to "aws:kms" when SSEKMSKeyID is set.
+	ServerSideEncryption string `json:"server_side_encryption,omitempty"`
+	// SSEKMSKeyID is the KMS key encrypting objects with SSE-KMS.
+	SSEKMSKeyID string `json:"sse_kms_key_id,omitempty"`
+	// SSECustomerKey is the base64 encoded 256-bit key encrypting objects with SSE-C.
+	SSECustomerKey string `json:"sse_customer_key,omitempty"`
+}
+
+// ObjectKey returns the key of object in storage backend with the optional prefix prepended,
+// e.g. prefix "tenant/prod" and key "<machine_id>/<rp_id>/index.json" gives "tenant/prod/<machine_id>/<rp_id>/index.json".
+func ObjectKey(prefix, key string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return key
+	}
+	return path.Join(prefix, key)
+}
+
+// ErrChecksumMismatch is returned when content of a downloaded object does not match the checksum it was uploaded with.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrorCode returns the error code of S3 API error in err chain (e.g. "NoSuchKey", "AccessDenied"),
+// or empty string when err is not an API error.
+func ErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// throttleCodes are error codes of requests rejected because the backend is overloaded.
+var throttleCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"RequestThrottled":         true,
+	"TooManyRequests":          true,
+	"TooManyRequestsException": true,
+}
+
+// IsThrottled reports whether err is a request throttled by storage backend, by S3 "SlowDown" like error
+// code or HTTP status 429.
+func IsThrottled(err error) bool {
+	if throttleCodes[ErrorCode(err)] {
+		return true
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusTooManyRequests
+}
+
+// Wrapper is implemented by storage vaults which wrap another one to change some of its operations.
+type Wrapper interface {
+	// Unwrap returns the wrapped storage vault.
+	Unwrap() StorageVault
+}
+
+// Throttler is implemented by storage vaults limiting their request rate when the backend throttles requests.
+type Throttler interface {
+	// ThrottledRequests returns the number of requests throttled by the backend.
+	ThrottledRequests() int64
+}
+
+// ThrottledRequests returns the number of requests throttled by backends of vault, 0 when it does not count them.
+func ThrottledRequests(vault StorageVault) int64 {
+	switch v := vault.(type) {
+	case Throttler:
+		return v.ThrottledRequests()
+	case *replicatedVault:
+		var n int64
+		for _, vault := range v.vaults {
+			n += ThrottledRequests(vault)
+		}
+		return n
+	case Wrapper:
+		return ThrottledRequests(v.Unwrap())
+	}
+	return 0
+}
+
+// Locker is implemented by storage vaults which can tell whether their backend protects stored objects from
+// deletion and overwrite (e.g. S3 Object Lock).
+type Locker interface {
+	// Immutable reports whether stored objects can not be deleted or overwritten.
+	Immutable(ctx context.Context) (bool, error)
+}
+
+// Immutable reports whether objects stored by vault can not be deleted or overwritten, false when its backend
+// can not tell. A replicated vault is immutable when its primary is.
+func Immutable(ctx context.Context, vault StorageVault) (bool, error) {
+	switch v := vault.(type) {
+	case Locker:
+		return v.Immutable(ctx)
+	case *replicatedVault:
+		return Immutable(ctx, v.vaults[0])
+	case Wrapper:
+		return Immutable(ctx, v.Unwrap())
+	}
+	return false, nil
 }
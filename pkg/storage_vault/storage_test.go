@@ -0,0 +1,37 @@
+package storage_vault
+
+import "testing"
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		key    string
+		want   string
+	}{
+		{
+			name: "empty prefix",
+			key:  "machine/rp/index.json",
+			want: "machine/rp/index.json",
+		},
+		{
+			name:   "prefix",
+			prefix: "tenant/prod",
+			key:    "machine/rp/index.json",
+			want:   "tenant/prod/machine/rp/index.json",
+		},
+		{
+			name:   "prefix with slashes",
+			prefix: "/tenant/prod/",
+			key:    "a1b2c3",
+			want:   "tenant/prod/a1b2c3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ObjectKey(tt.prefix, tt.key); got != tt.want {
+				t.Errorf("ObjectKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package storage_vault
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Rewinder returns a function which seeks r back to its current offset, so a failed upload of r can be retried.
+// It returns nil when r is not an io.Seeker.
+func Rewinder(r io.Reader) func() error {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	return func() error {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+}
+
+// PutFile uploads the file at name to vault without reading it into memory.
+func PutFile(ctx context.Context, vault StorageVault, key, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return vault.PutObjectStream(ctx, key, f, fi.Size())
+}
+
+// GetFile downloads the object to the file at name. The object is written to a temporary file which is renamed
+// when download completes, so a failed download never leaves a truncated file.
+func GetFile(ctx context.Context, vault StorageVault, key, name string, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := vault.GetObjectStream(ctx, key, f); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
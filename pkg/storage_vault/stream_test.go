@@ -0,0 +1,48 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewinder(t *testing.T) {
+	r := bytes.NewReader([]byte("0123456789"))
+	_, _ = r.Seek(3, 0)
+	rewind := Rewinder(r)
+	require.NotNil(t, rewind)
+	_, _ = ioutil.ReadAll(r)
+	require.NoError(t, rewind())
+	rest, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "3456789", string(rest))
+
+	assert.Nil(t, Rewinder(ioutil.NopCloser(r)))
+}
+
+func TestPutGetFile(t *testing.T) {
+	vault := newFakeVault("vault")
+	dir := t.TempDir()
+	src := filepath.Join(dir, "index.json")
+	require.NoError(t, ioutil.WriteFile(src, []byte(`{"items":{}}`), 0600))
+
+	require.NoError(t, PutFile(context.Background(), vault, "machine/rp/index.json", src))
+	assert.Equal(t, `{"items":{}}`, string(vault.objects["machine/rp/index.json"]))
+
+	dst := filepath.Join(dir, "restored.json")
+	require.NoError(t, GetFile(context.Background(), vault, "machine/rp/index.json", dst, 0600))
+	buf, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, `{"items":{}}`, string(buf))
+
+	// a failed download leaves no file
+	missing := filepath.Join(dir, "missing.json")
+	assert.Error(t, GetFile(context.Background(), vault, "machine/rp/missing.json", missing, 0600))
+	_, err = os.Stat(missing)
+	assert.True(t, os.IsNotExist(err))
+}
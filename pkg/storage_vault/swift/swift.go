@@ -0,0 +1,352 @@
+package swift
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/backupapi"
+	"github.com/bizflycloud/bizfly-backup/pkg/proxy"
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+const (
+	maxRetry = 3 * time.Minute
+
+	authTokenHeader = "X-Auth-Token"
+)
+
+var (
+	// ErrUnauthorized is returned when the keystone token is expired or invalid.
+	ErrUnauthorized = errors.New("swift: unauthorized")
+	// ErrNotFound is returned when the object does not exist.
+	ErrNotFound = errors.New("swift: object not found")
+)
+
+// Swift is the storage vault of OpenStack Swift object storage.
+// The credential of storage vault contains the keystone token and the storage url of account.
+type Swift struct {
+	Id               string
+	ActionID         string
+	Name             string
+	Container        string
+	CredentialType   string
+	StorageVaultType string
+	KeyPrefix        string
+
+	mu         sync.RWMutex
+	token      string
+	storageURL string
+
+	client       *http.Client
+	logger       *zap.Logger
+	backupClient *backupapi.Client
+}
+
+var _ storage_vault.StorageVault = (*Swift)(nil)
+
+// NewSwift creates a Swift storage vault.
+func NewSwift(vault backupapi.StorageVault, actionID string, backupClient *backupapi.Client) (*Swift, error) {
+	sw := &Swift{
+		Id:               vault.ID,
+		ActionID:         actionID,
+		Name:             vault.Name,
+		Container:        vault.StorageBucket,
+		CredentialType:   vault.CredentialType,
+		StorageVaultType: vault.StorageVaultType,
+		KeyPrefix:        vault.KeyPrefix,
+		backupClient:     backupClient,
+	}
+	if sw.KeyPrefix == "" {
+		sw.KeyPrefix = viper.GetString("key_prefix")
+	}
+
+	if sw.logger == nil {
+		l, err := backupapi.WriteLog()
+		if err != nil {
+			return nil, err
+		}
+		sw.logger = l
+	}
+
+	if err := sw.RefreshCredential(vault.Credential); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *Swift) Type() storage_vault.Type {
+	return storage_vault.Type{
+		StorageVaultType: sw.StorageVaultType,
+		CredentialType:   sw.CredentialType,
+	}
+}
+
+func (sw *Swift) ID() (string, string) {
+	return sw.Id, sw.ActionID
+}
+
+// RefreshCredential sets a new keystone token and storage url, the http client is recreated. Its throughput is not
+// limited, objects are limited by the limit stage of the storage path, see storage_vault.WithLimit.
+func (sw *Swift) RefreshCredential(credential storage_vault.Credential) error {
+	storageURL := credential.StorageURL
+	if storageURL == "" {
+		storageURL = credential.AwsLocation
+	}
+	if storageURL == "" || credential.Token == "" {
+		return errors.New("swift: storage url and token are required")
+	}
+
+	proxyFunc, err := proxy.ForStorageVault(sw.Id)
+	if err != nil {
+		return err
+	}
+	rt, err := storage_vault.Transport(storage_vault.TransportOptions{
+		Connect:          30 * time.Second,
+		ExpectContinue:   1 * time.Second,
+		IdleConn:         90 * time.Second,
+		ConnKeepAlive:    30 * time.Second,
+		MaxAllIdleConns:  100,
+		MaxHostIdleConns: 100,
+		ResponseHeader:   10 * time.Second,
+		TLSHandshake:     10 * time.Second,
+		Proxy:            proxyFunc,
+	})
+	if err != nil {
+		sw.logger.Error("Got an error creating custom HTTP client", zap.Error(err))
+		return err
+	}
+	sw.mu.Lock()
+	sw.token = credential.Token
+	sw.storageURL = strings.TrimSuffix(storageURL, "/")
+	sw.client = &http.Client{Transport: rt}
+	sw.mu.Unlock()
+	return nil
+}
+
+// objectURL returns url of object in container.
+func (sw *Swift) objectURL(key string) string {
+	sw.mu.RLock()
+	defer sw.mu.RUnlock()
+	u := url.URL{Path: sw.Container + "/" + storage_vault.ObjectKey(sw.KeyPrefix, key)}
+	return sw.storageURL + "/" + u.EscapedPath()
+}
+
+func (sw *Swift) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sw.objectURL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	sw.mu.RLock()
+	req.Header.Set(authTokenHeader, sw.token)
+	client := sw.client
+	sw.mu.RUnlock()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		resp.Body.Close()
+		return nil, ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	case resp.StatusCode >= 300:
+		buf, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("swift: %s %s: status %d: %s", method, key, resp.StatusCode, string(buf))
+	}
+	return resp, nil
+}
+
+// retry calls fn until it succeeds, object is not found, ctx is done or retry time out.
+// When token is expired, a new credential is got from backup server once.
+func (sw *Swift) retry(ctx context.Context, name, key string, fn func() error) error {
+	var err error
+	var refreshed bool
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = maxRetry
+	bo.MaxElapsedTime = maxRetry
+	for {
+		err = fn()
+		if err == nil || errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		sw.logger.Error(name+" error", zap.Error(err), zap.String("key", key))
+		if errors.Is(err, ErrUnauthorized) {
+			if refreshed || sw.backupClient == nil {
+				return err
+			}
+			refreshed = true
+			storageVaultID, actID := sw.ID()
+			vault, errCred := sw.backupClient.GetCredentialStorageVault(storageVaultID, actID, nil)
+			if errCred != nil {
+				sw.logger.Error("Error get credential", zap.Error(errCred))
+				return err
+			}
+			if errCred := sw.RefreshCredential(vault.Credential); errCred != nil {
+				sw.logger.Error("Error refresh credential ", zap.Error(errCred))
+				return err
+			}
+			continue
+		}
+		d := bo.NextBackOff()
+		if d == backoff.Stop {
+			sw.logger.Debug(name + " error. Retry time out")
+			return err
+		}
+		sw.logger.Sugar().Info(name+" error. Retry in ", d)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// HeadObject returns whether object existing and its etag (md5 of content).
+func (sw *Swift) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	var etag string
+	err := sw.retry(ctx, "HeadObject", key, func() error {
+		resp, err := sw.do(ctx, http.MethodHead, key, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		etag = strings.Trim(resp.Header.Get("Etag"), `"`)
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, etag, nil
+}
+
+// PutObject uploads data, it is skipped when an object with same content is already stored.
+// The md5 of data is sent as ETag so swift verifies integrity of uploaded object.
+func (sw *Swift) PutObject(ctx context.Context, key string, data []byte) error {
+	hash := md5.Sum(data)
+	etag := hex.EncodeToString(hash[:])
+
+	isExist, storedEtag, err := sw.HeadObject(ctx, key)
+	if err == nil && isExist && storedEtag == etag {
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("Etag", etag)
+	header.Set("Content-Type", "application/octet-stream")
+	return sw.retry(ctx, "PutObject", key, func() error {
+		resp, err := sw.do(ctx, http.MethodPut, key, bytes.NewReader(data), int64(len(data)), header)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+}
+
+// PutObjectStream uploads size bytes read from r, it is retried only when r is an io.Seeker.
+func (sw *Swift) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	put := func() error {
+		// the body is not closed by http client, so r can be rewound and reused
+		resp, err := sw.do(ctx, http.MethodPut, key, ioutil.NopCloser(r), size, header)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	rewind := storage_vault.Rewinder(r)
+	if rewind == nil {
+		return put()
+	}
+	return sw.retry(ctx, "PutObjectStream", key, func() error {
+		if err := rewind(); err != nil {
+			return err
+		}
+		return put()
+	})
+}
+
+func (sw *Swift) GetObject(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := sw.retry(ctx, "GetObject", key, func() error {
+		resp, err := sw.do(ctx, http.MethodGet, key, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		data, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetObjectStream copies the object to w, only the request is retried as w may have got part of object.
+func (sw *Swift) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	var resp *http.Response
+	err := sw.retry(ctx, "GetObjectStream", key, func() error {
+		var err error
+		resp, err = sw.do(ctx, http.MethodGet, key, nil, 0, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (sw *Swift) DeleteObject(ctx context.Context, key string) error {
+	err := sw.retry(ctx, "DeleteObject", key, func() error {
+		resp, err := sw.do(ctx, http.MethodDelete, key, nil, 0, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
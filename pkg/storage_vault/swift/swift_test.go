@@ -0,0 +1,103 @@
+package swift
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/storage_vault"
+)
+
+type fakeSwift struct {
+	mu      sync.Mutex
+	token   string
+	objects map[string][]byte
+	puts    int
+}
+
+func (f *fakeSwift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get(authTokenHeader) != f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/v1/AUTH_test/")
+	switch r.Method {
+	case http.MethodPut:
+		buf, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = buf
+		f.puts++
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead, http.MethodGet:
+		buf, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Etag", "acbd18db4cc2f85cedef654fccc4a4d8")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(buf)
+		}
+	}
+}
+
+func newTestSwift(t *testing.T, f *fakeSwift) *Swift {
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	sw := &Swift{Container: "bucket", KeyPrefix: "tenant", logger: zap.NewNop()}
+	require.NoError(t, sw.RefreshCredential(storage_vault.Credential{
+		Token:      "token",
+		StorageURL: srv.URL + "/v1/AUTH_test/",
+	}))
+	return sw
+}
+
+func TestSwift_PutGetObject(t *testing.T) {
+	f := &fakeSwift{token: "token", objects: map[string][]byte{}}
+	sw := newTestSwift(t, f)
+
+	isExist, _, err := sw.HeadObject(context.Background(), "acbd18db4cc2f85cedef654fccc4a4d8")
+	require.NoError(t, err)
+	assert.False(t, isExist)
+
+	require.NoError(t, sw.PutObject(context.Background(), "acbd18db4cc2f85cedef654fccc4a4d8", []byte("foo")))
+	assert.Contains(t, f.objects, "bucket/tenant/acbd18db4cc2f85cedef654fccc4a4d8")
+
+	// same content is not uploaded again
+	require.NoError(t, sw.PutObject(context.Background(), "acbd18db4cc2f85cedef654fccc4a4d8", []byte("foo")))
+	assert.Equal(t, 1, f.puts)
+
+	data, err := sw.GetObject(context.Background(), "acbd18db4cc2f85cedef654fccc4a4d8")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("foo"), data)
+
+	_, err = sw.GetObject(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestSwift_RefreshCredential(t *testing.T) {
+	f := &fakeSwift{token: "token", objects: map[string][]byte{}}
+	sw := newTestSwift(t, f)
+
+	f.token = "new-token"
+	_, _, err := sw.HeadObject(context.Background(), "foo")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	require.NoError(t, sw.RefreshCredential(storage_vault.Credential{
+		Token:      "new-token",
+		StorageURL: sw.storageURL,
+	}))
+	_, _, err = sw.HeadObject(context.Background(), "foo")
+	assert.NoError(t, err)
+
+	assert.Error(t, sw.RefreshCredential(storage_vault.Credential{}))
+}
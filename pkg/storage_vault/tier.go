@@ -0,0 +1,68 @@
+package storage_vault
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrTieringUnsupported is returned when archiving objects of a storage vault whose backend has no archive tier.
+var ErrTieringUnsupported = errors.New("storage vault does not support archive tier")
+
+// Tierer is implemented by storage vaults which can move stored objects to an archive storage class and make
+// archived objects readable again.
+type Tierer interface {
+	// Archive moves object key to storage class, keeping its content and metadata.
+	Archive(ctx context.Context, key, class string) error
+
+	// Retrieve requests a readable copy of archived object key for days, it reports whether the object can be read
+	// already. Objects which are not archived are readable.
+	Retrieve(ctx context.Context, key string, days int) (bool, error)
+}
+
+// tierer returns the vault moving objects of vault between storage classes. Only objects of the primary of a
+// replicated vault are archived, mirrors keep their copies.
+func tierer(vault StorageVault) (Tierer, bool) {
+	switch v := vault.(type) {
+	case Tierer:
+		return v, true
+	case *replicatedVault:
+		return tierer(v.vaults[0])
+	case Wrapper:
+		return tierer(v.Unwrap())
+	}
+	return nil, false
+}
+
+// Archive moves object key of vault to storage class, ErrTieringUnsupported is returned when the backend of
+// vault can not.
+func Archive(ctx context.Context, vault StorageVault, key, class string) error {
+	t, ok := tierer(vault)
+	if !ok {
+		return ErrTieringUnsupported
+	}
+	return t.Archive(ctx, key, class)
+}
+
+// Retrieve requests a readable copy of object key of vault for days, it reports whether the object can be read
+// already. Objects of vaults without archive tier are always readable.
+func Retrieve(ctx context.Context, vault StorageVault, key string, days int) (bool, error) {
+	t, ok := tierer(vault)
+	if !ok {
+		return true, nil
+	}
+	return t.Retrieve(ctx, key, days)
+}
+
+// RetrievalDelay returns how long retrieving an object of archive storage class is expected to take, 0 for
+// classes whose objects are readable at once.
+func RetrievalDelay(class string) time.Duration {
+	switch strings.ToUpper(class) {
+	case "GLACIER":
+		return 5 * time.Hour
+	case "DEEP_ARCHIVE":
+		return 12 * time.Hour
+	}
+	return 0
+}
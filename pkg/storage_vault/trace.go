@@ -0,0 +1,82 @@
+package storage_vault
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/bizflycloud/bizfly-backup/pkg/tracing"
+)
+
+// tracedVault is a StorageVault which records a span of every object operation.
+type tracedVault struct {
+	StorageVault
+	attrs []attribute.KeyValue
+}
+
+// WithTracing wraps vault so its object operations are traced as "storage.<Operation>" spans, children of the
+// span in ctx of the operation.
+func WithTracing(vault StorageVault) StorageVault {
+	id, name := vault.ID()
+	return &tracedVault{
+		StorageVault: vault,
+		attrs: []attribute.KeyValue{
+			attribute.String("storage_vault.id", id),
+			attribute.String("storage_vault.name", name),
+			attribute.String("storage_vault.type", vault.Type().StorageVaultType),
+		},
+	}
+}
+
+func (v *tracedVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+func (v *tracedVault) start(ctx context.Context, op, key string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	attrs = append(append(attrs, attribute.String("storage.key", key)), v.attrs...)
+	ctx, span := tracing.Start(ctx, "storage."+op, attrs...)
+	return ctx, func(err error) { tracing.End(span, err) }
+}
+
+func (v *tracedVault) HeadObject(ctx context.Context, key string) (bool, string, error) {
+	ctx, end := v.start(ctx, "HeadObject", key)
+	exist, etag, err := v.StorageVault.HeadObject(ctx, key)
+	end(err)
+	return exist, etag, err
+}
+
+func (v *tracedVault) PutObject(ctx context.Context, key string, data []byte) error {
+	ctx, end := v.start(ctx, "PutObject", key, attribute.Int("storage.size", len(data)))
+	err := v.StorageVault.PutObject(ctx, key, data)
+	end(err)
+	return err
+}
+
+func (v *tracedVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	ctx, end := v.start(ctx, "PutObjectStream", key, attribute.Int64("storage.size", size))
+	err := v.StorageVault.PutObjectStream(ctx, key, r, size)
+	end(err)
+	return err
+}
+
+func (v *tracedVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	ctx, end := v.start(ctx, "GetObject", key)
+	data, err := v.StorageVault.GetObject(ctx, key)
+	end(err)
+	return data, err
+}
+
+func (v *tracedVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	ctx, end := v.start(ctx, "GetObjectStream", key)
+	err := v.StorageVault.GetObjectStream(ctx, key, w)
+	end(err)
+	return err
+}
+
+func (v *tracedVault) DeleteObject(ctx context.Context, key string) error {
+	ctx, end := v.start(ctx, "DeleteObject", key)
+	err := v.StorageVault.DeleteObject(ctx, key)
+	end(err)
+	return err
+}
@@ -0,0 +1,36 @@
+package storage_vault
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWithTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+
+	store := newFakeVault("store")
+	vault := WithTracing(store)
+	require.NoError(t, vault.PutObject(context.Background(), "key", []byte("data")))
+	_, err := vault.GetObject(context.Background(), "missing")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "storage.PutObject", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String("storage.key", "key"))
+	assert.Contains(t, spans[0].Attributes(), attribute.Int("storage.size", 4))
+	assert.Equal(t, "storage.GetObject", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, StorageVault(store), vault.(Wrapper).Unwrap())
+}
@@ -0,0 +1,119 @@
+package storage_vault
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// transformMagic starts objects stored by a transform, followed by the length of transform name and the name.
+// Objects without it were stored before the transform was configured and are read as they are.
+var transformMagic = []byte("\x00bzbk\x00")
+
+// Transform encodes whole objects before they are stored and decodes them after they are read. Objects are encoded
+// and decoded in memory as a whole, streams included.
+type Transform interface {
+	Encode(data []byte) ([]byte, error)
+	Decode(data []byte) ([]byte, error)
+}
+
+// transformVault is a StorageVault which stores objects encoded by a transform.
+type transformVault struct {
+	StorageVault
+	name      string
+	transform Transform
+}
+
+// NewTransform returns a middleware storing objects encoded by t, marked with name so objects stored without it
+// can still be read. Streams are held in memory to be encoded, as transforms work on whole objects.
+func NewTransform(name string, t Transform) Middleware {
+	return func(next StorageVault) StorageVault {
+		return &transformVault{StorageVault: next, name: name, transform: t}
+	}
+}
+
+func (v *transformVault) Unwrap() StorageVault {
+	return v.StorageVault
+}
+
+func (v *transformVault) header() []byte {
+	h := make([]byte, 0, len(transformMagic)+1+len(v.name))
+	h = append(h, transformMagic...)
+	h = append(h, byte(len(v.name)))
+	return append(h, v.name...)
+}
+
+func (v *transformVault) encode(data []byte) ([]byte, error) {
+	encoded, err := v.transform.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(v.header(), encoded...), nil
+}
+
+func (v *transformVault) decode(data []byte) ([]byte, error) {
+	h := v.header()
+	if !bytes.HasPrefix(data, h) {
+		return data, nil
+	}
+	return v.transform.Decode(data[len(h):])
+}
+
+func (v *transformVault) PutObject(ctx context.Context, key string, data []byte) error {
+	encoded, err := v.encode(data)
+	if err != nil {
+		return err
+	}
+	return v.StorageVault.PutObject(ctx, key, encoded)
+}
+
+// PutObjectStream reads the whole of r in memory to encode it, then stores the encoded object. Backups put chunks and
+// indexes, the files of restore targets are put to vaults without middlewares.
+func (v *transformVault) PutObjectStream(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	encoded, err := v.encode(data)
+	if err != nil {
+		return err
+	}
+	return v.StorageVault.PutObjectStream(ctx, key, bytes.NewReader(encoded), int64(len(encoded)))
+}
+
+func (v *transformVault) GetObject(ctx context.Context, key string) ([]byte, error) {
+	data, err := v.StorageVault.GetObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return v.decode(data)
+}
+
+// GetObjectStream downloads the whole object in memory to decode it, then writes the decoded object to w.
+func (v *transformVault) GetObjectStream(ctx context.Context, key string, w io.Writer) error {
+	var buf bytes.Buffer
+	if err := v.StorageVault.GetObjectStream(ctx, key, &buf); err != nil {
+		return err
+	}
+	data, err := v.decode(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// transformed reports whether objects of vault are stored encoded by a transform, so their ETag does not match
+// their content.
+func transformed(vault StorageVault) bool {
+	switch v := vault.(type) {
+	case *transformVault:
+		return true
+	case *replicatedVault:
+		return transformed(v.vaults[0])
+	case Wrapper:
+		return transformed(v.Unwrap())
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// MountPoint returns the mount point of the filesystem which holds path, or would hold it when it does not exist.
+func MountPoint(path string) (string, error) {
+	path, fi, err := existingParent(path)
+	if err != nil {
+		return "", err
+	}
+	device, ok := DeviceID(fi)
+	if !ok {
+		return "/", nil
+	}
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		pfi, err := os.Stat(parent)
+		if err != nil {
+			return "", err
+		}
+		if d, _ := DeviceID(pfi); d != device {
+			return path, nil
+		}
+		path = parent
+	}
+}
+
+// FreeSpace returns the number of bytes available to the agent on the filesystem holding path.
+func FreeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,29 @@
+package support
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// MountPoint returns the root of the volume which holds path, or would hold it when it does not exist.
+func MountPoint(path string) (string, error) {
+	path, _, err := existingParent(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.VolumeName(path) + `\`, nil
+}
+
+// FreeSpace returns the number of bytes available to the agent on the volume holding path.
+func FreeSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
@@ -0,0 +1,20 @@
+package support
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// renameExchange swaps a and b by renaming b aside, then a to b and b to a. b is renamed back when a cannot be
+// renamed, so a failed swap leaves both in place.
+func renameExchange(a, b string) error {
+	aside := filepath.Join(filepath.Dir(b), "."+filepath.Base(b)+".exchange")
+	if err := os.Rename(b, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(a, b); err != nil {
+		_ = os.Rename(aside, b)
+		return err
+	}
+	return os.Rename(aside, a)
+}
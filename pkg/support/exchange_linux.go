@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package support
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExchangePaths atomically swaps the files or directories at a and b, which must be on the same filesystem. Both
+// exist after the swap. When the filesystem does not support exchanging, they are swapped by renames.
+func ExchangePaths(a, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		return renameExchange(a, b)
+	}
+	return err
+}
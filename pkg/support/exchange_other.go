@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package support
+
+// ExchangePaths swaps the files or directories at a and b, which must be on the same filesystem, by renames. Both
+// exist after the swap.
+func ExchangePaths(a, b string) error {
+	return renameExchange(a, b)
+}
@@ -0,0 +1,37 @@
+package support
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestExchangePaths(t *testing.T) {
+	for name, exchange := range map[string]func(a, b string) error{"exchange": ExchangePaths, "rename": renameExchange} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+			if err := ioutil.WriteFile(a, []byte("a"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(b, []byte("b"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := exchange(a, b); err != nil {
+				t.Fatal(err)
+			}
+			for path, want := range map[string]string{a: "b", b: "a"} {
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", path, data, want)
+				}
+			}
+			if err := exchange(a, filepath.Join(dir, "missing")); err == nil {
+				t.Error("exchange with missing path succeeded")
+			}
+		})
+	}
+}
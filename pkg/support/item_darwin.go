@@ -8,17 +8,41 @@ import (
 	"time"
 )
 
-func ItemLocal(fi fs.FileInfo) (time.Time, time.Time, time.Time, uint32, uint32, int64) {
-	var atimeLocal, ctimeLocal, mtimeLocal time.Time
-	var uid, gid uint32
-	var size int64
+// errNoXattr is the error of reading a missing extended attribute.
+var errNoXattr = syscall.ENOATTR
+
+// ufNoDump is the nodump flag of chflags.
+const ufNoDump = 0x1
+
+// FileMetadata returns the metadata of fi, times are in UTC.
+func FileMetadata(fi fs.FileInfo) Metadata {
+	var md Metadata
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		md.Atime = time.Unix(stat.Atimespec.Unix()).UTC()
+		md.Ctime = time.Unix(stat.Ctimespec.Unix()).UTC()
+		md.Mtime = time.Unix(stat.Mtimespec.Unix()).UTC()
+		md.UID = stat.Uid
+		md.GID = stat.Gid
+		md.Size = stat.Size
+		md.Device = uint64(stat.Dev)
+		md.Inode = stat.Ino
+		md.Links = uint64(stat.Nlink)
+	}
+	return md
+}
+
+// DeviceID returns the ID of the device holding fi.
+func DeviceID(fi fs.FileInfo) (uint64, bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), true
+	}
+	return 0, false
+}
+
+// NoDump reports whether the file or directory path with info fi has the nodump flag set by chflags.
+func NoDump(path string, fi fs.FileInfo) bool {
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
-		atimeLocal = time.Unix(stat.Atimespec.Unix()).UTC()
-		ctimeLocal = time.Unix(stat.Ctimespec.Unix()).UTC()
-		mtimeLocal = time.Unix(stat.Mtimespec.Unix()).UTC()
-		uid = stat.Uid
-		gid = stat.Gid
-		size = stat.Size
+		return stat.Flags&ufNoDump != 0
 	}
-	return atimeLocal, ctimeLocal, mtimeLocal, uid, gid, size
+	return false
 }
@@ -4,21 +4,54 @@ package support
 
 import (
 	"io/fs"
+	"os"
 	"syscall"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
-func ItemLocal(fi fs.FileInfo) (time.Time, time.Time, time.Time, uint32, uint32, int64) {
-	var atimeLocal, ctimeLocal, mtimeLocal time.Time
-	var uid, gid uint32
-	var size int64
+// errNoXattr is the error of reading a missing extended attribute.
+var errNoXattr = unix.ENODATA
+
+// fsNoDumpFl is the nodump flag of chattr.
+const fsNoDumpFl = 0x40
+
+// FileMetadata returns the metadata of fi, times are in UTC.
+func FileMetadata(fi fs.FileInfo) Metadata {
+	var md Metadata
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
-		atimeLocal = time.Unix(stat.Atim.Unix()).UTC()
-		ctimeLocal = time.Unix(stat.Ctim.Unix()).UTC()
-		mtimeLocal = time.Unix(stat.Mtim.Unix()).UTC()
-		uid = stat.Uid
-		gid = stat.Gid
-		size = stat.Size
+		md.Atime = time.Unix(stat.Atim.Unix()).UTC()
+		md.Ctime = time.Unix(stat.Ctim.Unix()).UTC()
+		md.Mtime = time.Unix(stat.Mtim.Unix()).UTC()
+		md.UID = stat.Uid
+		md.GID = stat.Gid
+		md.Size = stat.Size
+		md.Device = uint64(stat.Dev)
+		md.Inode = stat.Ino
+		md.Links = uint64(stat.Nlink)
+	}
+	return md
+}
+
+// DeviceID returns the ID of the device holding fi.
+func DeviceID(fi fs.FileInfo) (uint64, bool) {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Dev), true
+	}
+	return 0, false
+}
+
+// NoDump reports whether the file or directory path with info fi has the nodump flag set by chattr.
+func NoDump(path string, fi fs.FileInfo) bool {
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		return false
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return false
 	}
-	return atimeLocal, ctimeLocal, mtimeLocal, uid, gid, size
+	defer f.Close()
+	flags, err := unix.IoctlGetUint32(int(f.Fd()), unix.FS_IOC_GETFLAGS)
+	return err == nil && flags&fsNoDumpFl != 0
 }
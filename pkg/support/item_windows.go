@@ -6,17 +6,25 @@ import (
 	"time"
 )
 
-func ItemLocal(fi fs.FileInfo) (time.Time, time.Time, time.Time, uint32, uint32, int64) {
-	var atimeLocal, ctimeLocal, mtimeLocal time.Time
-	var size int64
+// FileMetadata returns the metadata of fi, times are in UTC. Files are not identified by file info on Windows, hard
+// links are backed up as separate files.
+func FileMetadata(fi fs.FileInfo) Metadata {
+	var md Metadata
 	if stat, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
-		atimeLocal = time.Unix(0, stat.LastAccessTime.Nanoseconds()).UTC()
-		ctimeLocal = time.Unix(0, stat.LastWriteTime.Nanoseconds()).UTC()
-		mtimeLocal = time.Unix(0, stat.LastWriteTime.Nanoseconds()).UTC()
-		size = fi.Size()
+		md.Atime = time.Unix(0, stat.LastAccessTime.Nanoseconds()).UTC()
+		md.Ctime = time.Unix(0, stat.LastWriteTime.Nanoseconds()).UTC()
+		md.Mtime = time.Unix(0, stat.LastWriteTime.Nanoseconds()).UTC()
+		md.Size = fi.Size()
 	}
-	uid := uint32(0)
-	gid := uint32(0)
+	return md
+}
+
+// DeviceID is not known on Windows, where the walk does not follow junctions to other volumes.
+func DeviceID(fi fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
 
-	return atimeLocal, ctimeLocal, mtimeLocal, uid, gid, size
+// NoDump is always false on Windows, which has no nodump flag.
+func NoDump(path string, fi fs.FileInfo) bool {
+	return false
 }
@@ -0,0 +1,11 @@
+//go:build darwin
+// +build darwin
+
+package support
+
+import "golang.org/x/sys/unix"
+
+// TotalMemory returns the bytes of physical memory of machine.
+func TotalMemory() (uint64, error) {
+	return unix.SysctlUint64("hw.memsize")
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package support
+
+import "errors"
+
+// TotalMemory returns an error, the memory of machine is not detected on this platform.
+func TotalMemory() (uint64, error) {
+	return 0, errors.New("memory of machine is not detected on this platform")
+}
@@ -0,0 +1,16 @@
+//go:build linux || darwin || windows
+// +build linux darwin windows
+
+package support
+
+import "testing"
+
+func TestTotalMemory(t *testing.T) {
+	total, err := TotalMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total < 64<<20 {
+		t.Fatalf("TotalMemory() = %d, want at least 64 MiB", total)
+	}
+}
@@ -0,0 +1,28 @@
+package support
+
+import "unsafe"
+
+var procGlobalMemoryStatusEx = kernel32.NewProc("GlobalMemoryStatusEx")
+
+// memoryStatusEx is MEMORYSTATUSEX.
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// TotalMemory returns the bytes of physical memory of machine.
+func TotalMemory() (uint64, error) {
+	status := memoryStatusEx{}
+	status.Length = uint32(unsafe.Sizeof(status))
+	if r, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); r == 0 {
+		return 0, err
+	}
+	return status.TotalPhys, nil
+}
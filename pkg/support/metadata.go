@@ -0,0 +1,18 @@
+package support
+
+import "time"
+
+// Metadata is the file system metadata of a file, directory or symlink. Fields the platform does not report are
+// zero: Windows has no UID, GID, Device, Inode and Links in file info, and its Ctime is the last write time.
+type Metadata struct {
+	Atime time.Time
+	Ctime time.Time
+	Mtime time.Time
+	UID   uint32
+	GID   uint32
+	Size  int64
+	// Device and Inode identify the file, Links is its number of hard links.
+	Device uint64
+	Inode  uint64
+	Links  uint64
+}
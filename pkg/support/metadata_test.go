@@ -0,0 +1,53 @@
+package support
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFileMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2022, 6, 1, 1, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := FileMetadata(fi)
+	if md.Size != 7 || !md.Mtime.Equal(mtime) || md.Ctime.IsZero() {
+		t.Errorf("FileMetadata() = %+v, want size 7, mtime %s and ctime", md, mtime)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Link(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	lfi, err := os.Stat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, lmd := FileMetadata(fi), FileMetadata(lfi)
+	if md.Inode == 0 || md.Inode != lmd.Inode || md.Device != lmd.Device || md.Links != 2 {
+		t.Errorf("hard links have metadata %+v and %+v, want the same device and inode and 2 links", md, lmd)
+	}
+	if md.UID != uint32(os.Getuid()) {
+		t.Errorf("FileMetadata().UID = %d, want %d", md.UID, os.Getuid())
+	}
+}
@@ -0,0 +1,20 @@
+package support
+
+// NTFS file attributes (FILE_ATTRIBUTE_*) kept in backup of items on Windows.
+const (
+	FileAttributeReadonly     = 0x00000001
+	FileAttributeHidden       = 0x00000002
+	FileAttributeSystem       = 0x00000004
+	FileAttributeArchive      = 0x00000020
+	FileAttributeReparsePoint = 0x00000400
+	FileAttributeCompressed   = 0x00000800
+)
+
+// ReparseTagMountPoint is the reparse tag of directory junctions and volume mount points.
+const ReparseTagMountPoint = 0xA0000003
+
+// Stream is a named NTFS alternate data stream of a file, e.g. Zone.Identifier.
+type Stream struct {
+	Name string
+	Size int64
+}
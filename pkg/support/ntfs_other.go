@@ -0,0 +1,54 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// NTFSSupported reports whether NTFS streams, attributes and reparse points can be restored on this platform.
+const NTFSSupported = false
+
+var errNotNTFS = errors.New("not supported on this platform")
+
+// FileAttributes returns NTFS attributes of fi, 0 on this platform.
+func FileAttributes(fi fs.FileInfo) uint32 {
+	return 0
+}
+
+// SetFileAttributes does nothing on this platform.
+func SetFileAttributes(name string, attrs uint32) error {
+	return nil
+}
+
+// AlternateStreams returns no stream on this platform.
+func AlternateStreams(name string) ([]Stream, error) {
+	return nil, nil
+}
+
+// StreamPath returns an empty path, streams can not be opened on this platform.
+func StreamPath(name, stream string) string {
+	return ""
+}
+
+// ReadReparsePoint is not supported on this platform.
+func ReadReparsePoint(name string) (uint32, []byte, error) {
+	return 0, nil, errNotNTFS
+}
+
+// WriteReparsePoint is not supported on this platform.
+func WriteReparsePoint(name string, data []byte) error {
+	return errNotNTFS
+}
+
+// SecurityDescriptor returns no security descriptor on this platform.
+func SecurityDescriptor(name string) (string, error) {
+	return "", nil
+}
+
+// SetSecurityDescriptor is not supported on this platform.
+func SetSecurityDescriptor(name, sddl string) error {
+	return errNotNTFS
+}
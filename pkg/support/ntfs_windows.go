@@ -0,0 +1,210 @@
+package support
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// NTFSSupported reports whether NTFS streams, attributes and reparse points can be restored on this platform.
+const NTFSSupported = true
+
+// settableAttributes are the attributes set by SetFileAttributes, the others are set by the file system.
+const settableAttributes = FileAttributeReadonly | FileAttributeHidden | FileAttributeSystem | FileAttributeArchive |
+	windows.FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+
+var (
+	kernel32             = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// FileAttributes returns NTFS attributes (FILE_ATTRIBUTE_*) of fi.
+func FileAttributes(fi fs.FileInfo) uint32 {
+	if stat, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		return stat.FileAttributes
+	}
+	return 0
+}
+
+// SetFileAttributes sets read-only, hidden, system, archive and not content indexed attributes of name, and
+// compresses it when attrs has the compressed attribute.
+func SetFileAttributes(name string, attrs uint32) error {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	if attrs&FileAttributeCompressed != 0 {
+		if err := setCompression(p); err != nil {
+			return err
+		}
+	}
+	attrs &= settableAttributes
+	if attrs == 0 {
+		attrs = windows.FILE_ATTRIBUTE_NORMAL
+	}
+	return windows.SetFileAttributes(p, attrs)
+}
+
+func setCompression(name *uint16) error {
+	h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, windows.FILE_SHARE_READ, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	format := uint16(1) // COMPRESSION_FORMAT_DEFAULT
+	var n uint32
+	return windows.DeviceIoControl(h, windows.FSCTL_SET_COMPRESSION, (*byte)(unsafe.Pointer(&format)), 2, nil, 0, &n, nil)
+}
+
+// AlternateStreams returns named data streams of file name, the unnamed stream of file content is not included.
+func AlternateStreams(name string) ([]Stream, error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	h, _, errFind := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(h) == windows.InvalidHandle {
+		if errors.Is(errFind, windows.ERROR_HANDLE_EOF) {
+			return nil, nil
+		}
+		return nil, errFind
+	}
+	defer windows.FindClose(windows.Handle(h))
+
+	var streams []Stream
+	for {
+		// names are ":<name>:$DATA", the unnamed stream is "::$DATA"
+		s := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if s != "" {
+			streams = append(streams, Stream{Name: s, Size: data.StreamSize})
+		}
+		ok, _, errNext := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if errors.Is(errNext, windows.ERROR_HANDLE_EOF) {
+				return streams, nil
+			}
+			return nil, errNext
+		}
+	}
+}
+
+// StreamPath returns the path opening stream of file name.
+func StreamPath(name, stream string) string {
+	return name + ":" + stream
+}
+
+// ReadReparsePoint returns the tag and the reparse data buffer of reparse point name.
+func ReadReparsePoint(name string) (uint32, []byte, error) {
+	h, err := openReparsePoint(name, windows.GENERIC_READ)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]byte, windows.MAXIMUM_REPARSE_DATA_BUFFER_SIZE)
+	var n uint32
+	if err := windows.DeviceIoControl(h, windows.FSCTL_GET_REPARSE_POINT, nil, 0, &buf[0], uint32(len(buf)), &n, nil); err != nil {
+		return 0, nil, err
+	}
+	if n < 4 {
+		return 0, nil, errors.New("invalid reparse data")
+	}
+	tag := *(*uint32)(unsafe.Pointer(&buf[0]))
+	return tag, buf[:n], nil
+}
+
+// WriteReparsePoint sets the reparse data buffer read by ReadReparsePoint on name, an existing empty directory
+// for a junction.
+func WriteReparsePoint(name string, data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty reparse data")
+	}
+	h, err := openReparsePoint(name, windows.GENERIC_WRITE)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	var n uint32
+	return windows.DeviceIoControl(h, windows.FSCTL_SET_REPARSE_POINT, &data[0], uint32(len(data)), nil, 0, &n, nil)
+}
+
+func openReparsePoint(name string, access uint32) (windows.Handle, error) {
+	p, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateFile(p, access, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING,
+		windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+}
+
+// securityInformation are the parts of security descriptors of files backed up, the SACL needs SeSecurityPrivilege
+// to be read and is left out.
+const securityInformation = windows.OWNER_SECURITY_INFORMATION | windows.GROUP_SECURITY_INFORMATION |
+	windows.DACL_SECURITY_INFORMATION
+
+// SecurityDescriptor returns the owner, group and DACL of name in SDDL, e.g. "O:BAG:SYD:PAI(A;;FA;;;SY)".
+func SecurityDescriptor(name string) (string, error) {
+	sd, err := windows.GetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, securityInformation)
+	if err != nil {
+		return "", err
+	}
+	return sd.String(), nil
+}
+
+// SetSecurityDescriptor sets the owner, group and DACL in SDDL returned by SecurityDescriptor on name. A DACL
+// which was protected stays protected, otherwise ACEs inherited from the parent directory are added to it. When the
+// owner can not be set, e.g. without SeRestorePrivilege, the DACL is set alone and ERROR_INVALID_OWNER is returned.
+func SetSecurityDescriptor(name, sddl string) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return err
+	}
+	group, _, err := sd.Group()
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	control, _, err := sd.Control()
+	if err != nil {
+		return err
+	}
+	var info windows.SECURITY_INFORMATION = windows.DACL_SECURITY_INFORMATION
+	if control&windows.SE_DACL_PROTECTED != 0 {
+		info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+	} else {
+		info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+	ownerInfo := info
+	if owner != nil {
+		ownerInfo |= windows.OWNER_SECURITY_INFORMATION
+	}
+	if group != nil {
+		ownerInfo |= windows.GROUP_SECURITY_INFORMATION
+	}
+	err = windows.SetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, ownerInfo, owner, group, dacl, nil)
+	if ownerInfo != info && (errors.Is(err, windows.ERROR_INVALID_OWNER) || errors.Is(err, windows.ERROR_ACCESS_DENIED)) {
+		if errDACL := windows.SetNamedSecurityInfo(name, windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil); errDACL != nil {
+			return errDACL
+		}
+	}
+	return err
+}
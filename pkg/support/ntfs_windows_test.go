@@ -0,0 +1,63 @@
+package support
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAlternateStreams(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(name, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(StreamPath(name, "Zone.Identifier"), []byte("[ZoneTransfer]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	streams, err := AlternateStreams(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 1 || streams[0].Name != "Zone.Identifier" || streams[0].Size != 14 {
+		t.Errorf("AlternateStreams() = %+v", streams)
+	}
+}
+
+func TestSetFileAttributes(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFileAttributes(name, FileAttributeHidden|FileAttributeArchive); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs := FileAttributes(fi); attrs&FileAttributeHidden == 0 {
+		t.Errorf("FileAttributes() = %#x, want hidden", attrs)
+	}
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// protected DACL granting full access to SYSTEM and the creator
+	const sddl = "D:P(A;;FA;;;SY)(A;;FA;;;OW)"
+	if err := SetSecurityDescriptor(name, sddl); err != nil {
+		t.Fatal(err)
+	}
+	got, err := SecurityDescriptor(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "D:P(A;;FA;;;SY)") {
+		t.Errorf("SecurityDescriptor() = %q, want protected DACL of %q", got, sddl)
+	}
+}
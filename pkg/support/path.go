@@ -0,0 +1,35 @@
+package support
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// cachePathOr returns cache_dir config when it is set, otherwise the default cache path def of platform.
+func cachePathOr(def string) string {
+	if dir := viper.GetString("cache_dir"); dir != "" {
+		return dir
+	}
+	return def
+}
+
+// existingParent returns the absolute path of path or its nearest parent which exists.
+func existingParent(path string) (string, os.FileInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, err
+	}
+	for {
+		fi, err := os.Stat(path)
+		if err == nil {
+			return path, fi, nil
+		}
+		parent := filepath.Dir(path)
+		if !os.IsNotExist(err) || parent == path {
+			return "", nil, err
+		}
+		path = parent
+	}
+}
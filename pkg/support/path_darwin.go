@@ -20,5 +20,5 @@ func CheckPath() (string, string, error) {
 		cachePath = ".cache"
 	}
 
-	return logPath, cachePath, nil
+	return logPath, cachePathOr(cachePath), nil
 }
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Range is a byte range of a file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// DataRanges returns the ranges holding data of file f with the given size, found by SEEK_DATA and SEEK_HOLE.
+// sparse is false when f has no holes or they can not be detected, the ranges are then not given.
+// The offset of f is changed.
+func DataRanges(f *os.File, size int64) (ranges []Range, sparse bool, err error) {
+	fd := int(f.Fd())
+	var offset int64
+	for offset < size {
+		data, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// hole until the end of file
+			break
+		}
+		if errors.Is(err, unix.EINVAL) {
+			// holes are not supported by the filesystem
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if data >= size {
+			break
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false, err
+		}
+		if hole > size {
+			hole = size
+		}
+		ranges = append(ranges, Range{Offset: data, Length: hole - data})
+		offset = hole
+	}
+	if size == 0 || len(ranges) == 1 && ranges[0].Offset == 0 && ranges[0].Length == size {
+		return nil, false, nil
+	}
+	return ranges, true, nil
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	ranges, sparse, err := DataRanges(f, 0)
+	if err != nil || sparse || ranges != nil {
+		t.Fatalf("DataRanges() of empty file = %v, %v, %v", ranges, sparse, err)
+	}
+
+	const size = 64 << 20
+	if err := f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = 1
+	}
+	if _, err := f.WriteAt(data, 16<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges, sparse, err = DataRanges(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sparse {
+		t.Skip("holes are not detected on this filesystem")
+	}
+	var total int64
+	for _, r := range ranges {
+		if r.Offset > 16<<20 || r.Offset+r.Length < 17<<20 {
+			continue
+		}
+		total += r.Length
+	}
+	if total < 1<<20 || total >= size {
+		t.Errorf("DataRanges() = %v, want a range holding data at 16MiB", ranges)
+	}
+}
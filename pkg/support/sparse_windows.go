@@ -0,0 +1,14 @@
+package support
+
+import "os"
+
+// Range is a byte range of a file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// DataRanges does not detect holes on Windows, files are backed up with their full content.
+func DataRanges(f *os.File, size int64) (ranges []Range, sparse bool, err error) {
+	return nil, false, nil
+}
@@ -0,0 +1,13 @@
+package support
+
+// POSIX ACLs are stored by Linux in these extended attributes.
+const (
+	XattrPosixACLAccess  = "system.posix_acl_access"
+	XattrPosixACLDefault = "system.posix_acl_default"
+)
+
+// ExtendedAttribute is a named extended attribute of a file.
+type ExtendedAttribute struct {
+	Name  string
+	Value []byte
+}
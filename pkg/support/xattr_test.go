@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedAttributes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := ExtendedAttributes(path)
+	if err != nil || len(attrs) != 0 {
+		t.Fatalf("ExtendedAttributes() of new file = %v, %v", attrs, err)
+	}
+
+	attr := ExtendedAttribute{Name: "user.bizfly.test", Value: []byte("value")}
+	if err := SetExtendedAttribute(path, attr); err != nil {
+		if UnsupportedXattr(err) {
+			t.Skip("filesystem does not support user extended attributes")
+		}
+		t.Fatal(err)
+	}
+	attrs, err = ExtendedAttributes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, []ExtendedAttribute{attr}) {
+		t.Errorf("ExtendedAttributes() = %v, want %v", attrs, []ExtendedAttribute{attr})
+	}
+}
@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+package support
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// UnsupportedXattr reports whether err tells the filesystem has no extended attributes.
+func UnsupportedXattr(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+// ExtendedAttributes returns extended attributes of name, with POSIX ACLs on Linux. Symlinks are not followed.
+// A filesystem without extended attributes gives none.
+func ExtendedAttributes(name string) ([]ExtendedAttribute, error) {
+	buf, err := readXattr(func(dest []byte) (int, error) {
+		return unix.Llistxattr(name, dest)
+	})
+	if err != nil {
+		if UnsupportedXattr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var attrs []ExtendedAttribute
+	for _, attr := range bytes.Split(buf, []byte{0}) {
+		if len(attr) == 0 {
+			continue
+		}
+		value, err := readXattr(func(dest []byte) (int, error) {
+			return unix.Lgetxattr(name, string(attr), dest)
+		})
+		if errors.Is(err, errNoXattr) {
+			// removed since listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, ExtendedAttribute{Name: string(attr), Value: value})
+	}
+	return attrs, nil
+}
+
+// readXattr calls read with a buffer of the size it returns for a nil one, again when the attribute grew meanwhile.
+func readXattr(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// SetExtendedAttribute sets extended attribute attr of name. Symlinks are not followed.
+func SetExtendedAttribute(name string, attr ExtendedAttribute) error {
+	return unix.Lsetxattr(name, attr.Name, attr.Value, 0)
+}
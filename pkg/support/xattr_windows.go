@@ -0,0 +1,16 @@
+package support
+
+// ExtendedAttributes returns no attribute on Windows.
+func ExtendedAttributes(name string) ([]ExtendedAttribute, error) {
+	return nil, nil
+}
+
+// SetExtendedAttribute does nothing on Windows.
+func SetExtendedAttribute(name string, attr ExtendedAttribute) error {
+	return nil
+}
+
+// UnsupportedXattr reports false on Windows, where no extended attribute is set.
+func UnsupportedXattr(err error) bool {
+	return false
+}
@@ -0,0 +1,117 @@
+// Package tracing traces backup and restore flows of agent with OpenTelemetry spans, exported to an OTLP/HTTP
+// collector when it is configured.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of tracer of agent spans.
+const instrumentationName = "github.com/bizflycloud/bizfly-backup"
+
+// serviceName is the service.name of agent spans.
+const serviceName = "bizfly-backup-agent"
+
+// otlpTracesPath is the path of traces on OTLP/HTTP collectors.
+const otlpTracesPath = "/v1/traces"
+
+// Config is the tracing config of agent.
+type Config struct {
+	// Endpoint is the base URL of OTLP/HTTP collector (e.g. http://localhost:4318), spans are dropped when it is empty.
+	Endpoint string
+	// Headers are sent with every export request, e.g. an authorization token of the collector.
+	Headers map[string]string
+	// SampleRatio is the ratio of traces recorded, spans of a traced parent are always recorded.
+	SampleRatio float64
+	// ServiceVersion and MachineID are attributes of every span.
+	ServiceVersion string
+	MachineID      string
+}
+
+// Setup installs the tracer provider exporting spans to cfg.Endpoint in batches, and the W3C trace context
+// propagator. The returned function flushes spans not exported yet, it must be called before agent exits.
+// Without endpoint, spans are no-op and cost nothing.
+func Setup(cfg Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be between 0 and 1, got %v", cfg.SampleRatio)
+	}
+	opts, err := otlpOptions(cfg.Endpoint, cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", cfg.ServiceVersion),
+			attribute.String("service.instance.id", cfg.MachineID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// otlpOptions returns the options of OTLP/HTTP exporter sending spans to the collector at base URL endpoint, the
+// traces path is added unless endpoint ends with it.
+func otlpOptions(endpoint string, headers map[string]string) ([]otlptracehttp.Option, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", endpoint)
+	}
+	path := u.Path
+	if !strings.HasSuffix(path, otlpTracesPath) {
+		path = strings.TrimSuffix(path, "/") + otlpTracesPath
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(path),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(30 * time.Second),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return opts, nil
+}
+
+// Start starts span name, child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, with error status when err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject adds the trace context of ctx to headers of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSetup(t *testing.T) {
+	var requests []*coltracepb.ExportTraceServiceRequest
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var req coltracepb.ExportTraceServiceRequest
+		assert.NoError(t, proto.Unmarshal(body, &req))
+		requests = append(requests, &req)
+	}))
+	defer srv.Close()
+
+	shutdown, err := Setup(Config{
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		SampleRatio: 1,
+		MachineID:   "machine1",
+	})
+	require.NoError(t, err)
+	ctx, parent := Start(context.Background(), "backup")
+	_, child := Start(ctx, "upload_file",
+		attribute.String("path", "/data/a"), attribute.Int64("size", 42),
+		attribute.StringSlice("paths", []string{"/data/a", "/data/b"}))
+	End(child, errors.New("failed"))
+	End(parent, nil)
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Equal(t, otlpTracesPath, path)
+	assert.Equal(t, "Bearer token", auth)
+	var spans []*tracepb.Span
+	for _, req := range requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	require.Len(t, spans, 2)
+	var span *tracepb.Span
+	for _, s := range spans {
+		if s.Name == "upload_file" {
+			span = s
+		}
+	}
+	require.NotNil(t, span, "upload_file span is not exported")
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, span.Status.GetCode())
+	assert.Equal(t, "failed", span.Status.GetMessage())
+	attrs := make(map[string]interface{})
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value.GetValue()
+	}
+	if assert.IsType(t, &commonpb.AnyValue_StringValue{}, attrs["path"]) {
+		assert.Equal(t, "/data/a", attrs["path"].(*commonpb.AnyValue_StringValue).StringValue)
+	}
+	if assert.IsType(t, &commonpb.AnyValue_IntValue{}, attrs["size"]) {
+		assert.EqualValues(t, 42, attrs["size"].(*commonpb.AnyValue_IntValue).IntValue)
+	}
+	if assert.IsType(t, &commonpb.AnyValue_ArrayValue{}, attrs["paths"]) {
+		assert.Len(t, attrs["paths"].(*commonpb.AnyValue_ArrayValue).ArrayValue.GetValues(), 2)
+	}
+}
+
+func TestOTLPOptions(t *testing.T) {
+	for _, endpoint := range []string{"http://collector:4318", "https://collector/otlp/", "http://collector:4318/v1/traces"} {
+		_, err := otlpOptions(endpoint, nil)
+		assert.NoError(t, err, endpoint)
+	}
+	for _, endpoint := range []string{"collector:4318", "ftp://collector", "http://"} {
+		_, err := otlpOptions(endpoint, nil)
+		assert.Error(t, err, endpoint)
+	}
+}
@@ -0,0 +1,166 @@
+// Package usn enumerates the paths changed under a directory since a position in the NTFS update sequence number
+// (USN) change journal of its volume, so a backup does not walk millions of unchanged files. The journal is only
+// read on Windows, other platforms return ErrNotSupported.
+package usn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// Reasons of changes recorded in the journal, changes of a path have the reasons of all its records.
+const (
+	ReasonDataOverwrite  = 0x00000001
+	ReasonDataExtend     = 0x00000002
+	ReasonDataTruncation = 0x00000004
+	ReasonFileCreate     = 0x00000100
+	ReasonFileDelete     = 0x00000200
+	ReasonRenameOldName  = 0x00001000
+	ReasonRenameNewName  = 0x00002000
+)
+
+// maxDepth bounds the parents followed to resolve the path of a record, in case of a cycle in journal records.
+const maxDepth = 256
+
+var (
+	// ErrNotSupported is returned when the volume of a directory has no change journal, or on other platforms than
+	// Windows.
+	ErrNotSupported = errors.New("change journal is not supported")
+	// ErrJournalReset is returned when the journal was recreated or its records since the cursor were purged, the
+	// changes since the cursor are not known.
+	ErrJournalReset = errors.New("change journal was reset since cursor")
+	// ErrUnresolved is returned when the path of a changed file can not be resolved.
+	ErrUnresolved = errors.New("path of changed file can not be resolved")
+)
+
+// Cursor is a position in the change journal of a volume.
+type Cursor struct {
+	Volume    string `json:"volume"`
+	JournalID uint64 `json:"journal_id"`
+	USN       int64  `json:"usn"`
+}
+
+// record is a USN_RECORD_V2 of the journal.
+type record struct {
+	Ref       uint64
+	ParentRef uint64
+	USN       int64
+	Reason    uint32
+	Name      string
+}
+
+// usnRecordV2 is the size of USN_RECORD_V2 before its file name.
+const usnRecordV2 = 60
+
+// parseRecords parses the USN_RECORD_V2 records of buf, as returned by FSCTL_READ_USN_JOURNAL after the next USN.
+func parseRecords(buf []byte) ([]record, error) {
+	var records []record
+	for len(buf) > 0 {
+		if len(buf) < usnRecordV2 {
+			return nil, fmt.Errorf("truncated USN record of %d bytes", len(buf))
+		}
+		length := int(binary.LittleEndian.Uint32(buf[0:]))
+		if length < usnRecordV2 || length > len(buf) {
+			return nil, fmt.Errorf("invalid USN record length %d", length)
+		}
+		if major := binary.LittleEndian.Uint16(buf[4:]); major != 2 {
+			return nil, fmt.Errorf("unsupported USN record version %d", major)
+		}
+		nameLength := int(binary.LittleEndian.Uint16(buf[56:]))
+		nameOffset := int(binary.LittleEndian.Uint16(buf[58:]))
+		if nameOffset+nameLength > length || nameLength%2 != 0 {
+			return nil, fmt.Errorf("invalid USN record file name at %d", nameOffset)
+		}
+		name := make([]uint16, nameLength/2)
+		for i := range name {
+			name[i] = binary.LittleEndian.Uint16(buf[nameOffset+2*i:])
+		}
+		records = append(records, record{
+			Ref:       binary.LittleEndian.Uint64(buf[8:]),
+			ParentRef: binary.LittleEndian.Uint64(buf[16:]),
+			USN:       int64(binary.LittleEndian.Uint64(buf[24:])),
+			Reason:    binary.LittleEndian.Uint32(buf[40:]),
+			Name:      string(utf16.Decode(name)),
+		})
+		buf = buf[length:]
+	}
+	return records, nil
+}
+
+// resolver resolves file reference numbers to paths, by opening the files or by the names of their records when
+// they do not exist anymore.
+type resolver struct {
+	open  func(ref uint64) (string, error)
+	names map[uint64]record
+	paths map[uint64]string
+}
+
+func newResolver(records []record, open func(ref uint64) (string, error)) *resolver {
+	r := &resolver{open: open, names: make(map[uint64]record), paths: make(map[uint64]string)}
+	for _, rec := range records {
+		// the latest name of a file, before it was deleted
+		r.names[rec.Ref] = rec
+	}
+	return r
+}
+
+func (r *resolver) path(ref uint64, depth int) (string, error) {
+	if path, ok := r.paths[ref]; ok {
+		return path, nil
+	}
+	path, err := r.open(ref)
+	if err != nil {
+		rec, ok := r.names[ref]
+		if !ok || depth > maxDepth {
+			return "", fmt.Errorf("%w: file reference %#x: %v", ErrUnresolved, ref, err)
+		}
+		parent, err := r.path(rec.ParentRef, depth+1)
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(parent, rec.Name)
+	}
+	r.paths[ref] = path
+	return path, nil
+}
+
+// changedPaths returns the paths under root changed by records with the reasons of their changes. A renamed file
+// has its old and new paths. Paths are root joined with their path relative to root, which is compared case
+// insensitively as NTFS does.
+func changedPaths(root string, records []record, open func(ref uint64) (string, error)) (map[string]uint32, error) {
+	r := newResolver(records, open)
+	root = filepath.Clean(root)
+	changes := make(map[string]uint32)
+	for _, rec := range records {
+		parent, err := r.path(rec.ParentRef, 0)
+		if err != nil {
+			return nil, err
+		}
+		rel, ok := relative(root, filepath.Join(parent, rec.Name))
+		if !ok {
+			continue
+		}
+		path := filepath.Join(root, rel)
+		changes[path] |= rec.Reason
+	}
+	return changes, nil
+}
+
+// relative returns path relative to root when it is under root, ignoring case.
+func relative(root, path string) (string, bool) {
+	if len(path) <= len(root) || !strings.EqualFold(path[:len(root)], root) {
+		return "", false
+	}
+	rest := path[len(root):]
+	if strings.HasSuffix(root, string(filepath.Separator)) {
+		return rest, true
+	}
+	if rest[0] != filepath.Separator {
+		return "", false
+	}
+	return rest[1:], true
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package usn
+
+// Current returns ErrNotSupported, there is no change journal on this platform.
+func Current(root string) (Cursor, error) {
+	return Cursor{}, ErrNotSupported
+}
+
+// Changes returns ErrNotSupported, there is no change journal on this platform.
+func Changes(root string, since, until Cursor) (map[string]uint32, error) {
+	return nil, ErrNotSupported
+}
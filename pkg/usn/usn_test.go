@@ -0,0 +1,101 @@
+package usn
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeRecord encodes rec as USN_RECORD_V2, padded to 8 bytes as in the journal.
+func encodeRecord(rec record) []byte {
+	name := utf16.Encode([]rune(rec.Name))
+	length := (usnRecordV2 + 2*len(name) + 7) &^ 7
+	buf := make([]byte, length)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(length))
+	binary.LittleEndian.PutUint16(buf[4:], 2)
+	binary.LittleEndian.PutUint64(buf[8:], rec.Ref)
+	binary.LittleEndian.PutUint64(buf[16:], rec.ParentRef)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(rec.USN))
+	binary.LittleEndian.PutUint32(buf[40:], rec.Reason)
+	binary.LittleEndian.PutUint16(buf[56:], uint16(2*len(name)))
+	binary.LittleEndian.PutUint16(buf[58:], usnRecordV2)
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(buf[usnRecordV2+2*i:], c)
+	}
+	return buf
+}
+
+func TestParseRecords(t *testing.T) {
+	want := []record{
+		{Ref: 10, ParentRef: 5, USN: 100, Reason: ReasonFileCreate, Name: "a.txt"},
+		{Ref: 11, ParentRef: 5, USN: 200, Reason: ReasonDataExtend, Name: "tài liệu.docx"},
+	}
+	buf := append(encodeRecord(want[0]), encodeRecord(want[1])...)
+	got, err := parseRecords(buf)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = parseRecords(buf[:len(buf)-1])
+	assert.Error(t, err, "truncated record")
+	v3 := encodeRecord(want[0])
+	binary.LittleEndian.PutUint16(v3[4:], 3)
+	_, err = parseRecords(v3)
+	assert.Error(t, err, "unsupported version")
+}
+
+func TestChangedPaths(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "Data")
+	existing := map[uint64]string{
+		5: root,
+		6: filepath.Join(root, "new"),
+		7: filepath.Join(string(filepath.Separator), "Other"),
+	}
+	open := func(ref uint64) (string, error) {
+		if path, ok := existing[ref]; ok {
+			return path, nil
+		}
+		return "", errors.New("not found")
+	}
+	records := []record{
+		{Ref: 10, ParentRef: 5, Reason: ReasonDataExtend, Name: "a.txt"},
+		{Ref: 10, ParentRef: 5, Reason: ReasonDataOverwrite, Name: "a.txt"},
+		// directory renamed from old to new
+		{Ref: 6, ParentRef: 5, Reason: ReasonRenameOldName, Name: "old"},
+		{Ref: 6, ParentRef: 5, Reason: ReasonRenameNewName, Name: "new"},
+		// file deleted in a deleted directory
+		{Ref: 12, ParentRef: 8, Reason: ReasonFileDelete, Name: "b.txt"},
+		{Ref: 8, ParentRef: 5, Reason: ReasonFileDelete, Name: "gone"},
+		{Ref: 13, ParentRef: 7, Reason: ReasonFileCreate, Name: "c.txt"},
+	}
+	got, err := changedPaths(root, records, open)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint32{
+		filepath.Join(root, "a.txt"):         ReasonDataExtend | ReasonDataOverwrite,
+		filepath.Join(root, "old"):           ReasonRenameOldName,
+		filepath.Join(root, "new"):           ReasonRenameNewName,
+		filepath.Join(root, "gone", "b.txt"): ReasonFileDelete,
+		filepath.Join(root, "gone"):          ReasonFileDelete,
+	}, got)
+
+	_, err = changedPaths(root, []record{{Ref: 14, ParentRef: 9, Name: "d.txt"}}, open)
+	assert.True(t, errors.Is(err, ErrUnresolved))
+}
+
+func TestRelative(t *testing.T) {
+	sep := string(filepath.Separator)
+	rel, ok := relative(sep+"Data", sep+"data"+sep+"a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "a.txt", rel)
+	_, ok = relative(sep+"Data", sep+"Data2"+sep+"a.txt")
+	assert.False(t, ok)
+	_, ok = relative(sep+"Data", sep+"Data")
+	assert.False(t, ok)
+	rel, ok = relative(sep, sep+"a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "a.txt", rel)
+}
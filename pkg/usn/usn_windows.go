@@ -0,0 +1,183 @@
+//go:build windows
+// +build windows
+
+package usn
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	fsctlQueryUSNJournal = 0x000900f4
+	fsctlReadUSNJournal  = 0x000900bb
+
+	errorJournalNotActive    = windows.Errno(1179)
+	errorJournalEntryDeleted = windows.Errno(1181)
+
+	// readBufferSize is the size of journal records read at once.
+	readBufferSize = 64 * 1024
+)
+
+var (
+	kernel32         = windows.NewLazySystemDLL("kernel32.dll")
+	procOpenFileByID = kernel32.NewProc("OpenFileById")
+)
+
+// usnJournalData is USN_JOURNAL_DATA_V0.
+type usnJournalData struct {
+	UsnJournalID    uint64
+	FirstUsn        int64
+	NextUsn         int64
+	LowestValidUsn  int64
+	MaxUsn          int64
+	MaximumSize     uint64
+	AllocationDelta uint64
+}
+
+// readUSNJournalData is READ_USN_JOURNAL_DATA_V0.
+type readUSNJournalData struct {
+	StartUsn          int64
+	ReasonMask        uint32
+	ReturnOnlyOnClose uint32
+	Timeout           uint64
+	BytesToWaitFor    uint64
+	UsnJournalID      uint64
+}
+
+// fileIDDescriptor is FILE_ID_DESCRIPTOR of a 64-bit file reference number.
+type fileIDDescriptor struct {
+	Size   uint32
+	Type   uint32
+	FileID uint64
+	_      uint64
+}
+
+// volume returns the volume of root, e.g. C: of C:\data.
+func volume(root string) (string, error) {
+	vol := filepath.VolumeName(root)
+	if len(vol) != 2 || vol[1] != ':' {
+		// UNC paths are read through the server, which does not share its journal
+		return "", ErrNotSupported
+	}
+	return strings.ToUpper(vol), nil
+}
+
+func openVolume(vol string) (windows.Handle, error) {
+	p, err := windows.UTF16PtrFromString(`\\.\` + vol)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateFile(p, windows.GENERIC_READ, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil,
+		windows.OPEN_EXISTING, 0, 0)
+}
+
+func queryJournal(h windows.Handle) (usnJournalData, error) {
+	var data usnJournalData
+	var n uint32
+	err := windows.DeviceIoControl(h, fsctlQueryUSNJournal, nil, 0, (*byte)(unsafe.Pointer(&data)), uint32(unsafe.Sizeof(data)), &n, nil)
+	if errors.Is(err, errorJournalNotActive) || errors.Is(err, windows.ERROR_INVALID_FUNCTION) {
+		return data, ErrNotSupported
+	}
+	return data, err
+}
+
+// Current returns the current position in the change journal of the volume of root.
+func Current(root string) (Cursor, error) {
+	vol, err := volume(root)
+	if err != nil {
+		return Cursor{}, err
+	}
+	h, err := openVolume(vol)
+	if err != nil {
+		return Cursor{}, err
+	}
+	defer windows.CloseHandle(h)
+	data, err := queryJournal(h)
+	if err != nil {
+		return Cursor{}, err
+	}
+	return Cursor{Volume: vol, JournalID: data.UsnJournalID, USN: data.NextUsn}, nil
+}
+
+// Changes returns the paths under root changed between cursors since and until, with the reasons of their changes.
+// It returns ErrJournalReset when the records since are not in the journal anymore.
+func Changes(root string, since, until Cursor) (map[string]uint32, error) {
+	vol, err := volume(root)
+	if err != nil {
+		return nil, err
+	}
+	if since.Volume != vol || since.JournalID != until.JournalID {
+		return nil, ErrJournalReset
+	}
+	h, err := openVolume(vol)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	data, err := queryJournal(h)
+	if err != nil {
+		return nil, err
+	}
+	if data.UsnJournalID != since.JournalID || since.USN < data.FirstUsn {
+		return nil, ErrJournalReset
+	}
+
+	var records []record
+	in := readUSNJournalData{StartUsn: since.USN, ReasonMask: 0xffffffff, UsnJournalID: since.JournalID}
+	buf := make([]byte, readBufferSize)
+	for in.StartUsn < until.USN {
+		var n uint32
+		err := windows.DeviceIoControl(h, fsctlReadUSNJournal, (*byte)(unsafe.Pointer(&in)), uint32(unsafe.Sizeof(in)), &buf[0], uint32(len(buf)), &n, nil)
+		if errors.Is(err, errorJournalEntryDeleted) {
+			return nil, ErrJournalReset
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n <= 8 {
+			break
+		}
+		read, err := parseRecords(buf[8:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range read {
+			if rec.USN < until.USN {
+				records = append(records, rec)
+			}
+		}
+		in.StartUsn = int64(binary.LittleEndian.Uint64(buf[0:]))
+	}
+	return changedPaths(root, records, func(ref uint64) (string, error) {
+		return openByID(h, ref)
+	})
+}
+
+// openByID returns the path of file reference ref of volume h.
+func openByID(vol windows.Handle, ref uint64) (string, error) {
+	id := fileIDDescriptor{Type: 0, FileID: ref}
+	id.Size = uint32(unsafe.Sizeof(id))
+	r, _, errOpen := procOpenFileByID.Call(uintptr(vol), uintptr(unsafe.Pointer(&id)), 0,
+		uintptr(windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE), 0,
+		uintptr(windows.FILE_FLAG_BACKUP_SEMANTICS))
+	h := windows.Handle(r)
+	if h == windows.InvalidHandle {
+		return "", errOpen
+	}
+	defer windows.CloseHandle(h)
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n, err := windows.GetFinalPathNameByHandle(h, &buf[0], uint32(len(buf)), 0)
+	if err != nil {
+		return "", err
+	}
+	if int(n) > len(buf) {
+		return "", windows.ERROR_INSUFFICIENT_BUFFER
+	}
+	return strings.TrimPrefix(windows.UTF16ToString(buf[:n]), `\\?\`), nil
+}
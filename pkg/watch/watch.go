@@ -0,0 +1,235 @@
+// Package watch watches directory trees for changes, so they are backed up continuously instead of waiting for
+// the schedule of their policies.
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// MaxPaths is the number of changed paths above which a change set overflows.
+const MaxPaths = 1000
+
+// Changes are the paths changed under the watched directory during a debounce interval. Paths under a changed
+// directory are not listed, removed paths neither. Overflow reports more than MaxPaths paths changed or events were
+// lost, then the whole directory must be backed up.
+type Changes struct {
+	Paths    []string
+	Overflow bool
+}
+
+// Watcher accumulates the changes of a directory tree and hands them to its handler a debounce interval after the
+// first of them, so a burst of writes makes one backup. It is safe for concurrent use.
+type Watcher struct {
+	root     string
+	debounce time.Duration
+	ignore   func(path string) bool
+	onChange func(Changes) error
+	logger   *zap.Logger
+
+	w    *fsnotify.Watcher
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	changed  map[string]bool
+	overflow bool
+	timer    *time.Timer
+	closed   bool
+}
+
+// New watches root and its subdirectories. onChange is called with the changes accumulated during debounce, when it
+// fails they are kept and handed again with the next changes. Paths for which ignore returns true are not watched.
+func New(root string, debounce time.Duration, ignore func(path string) bool, onChange func(Changes) error, logger *zap.Logger) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if ignore == nil {
+		ignore = func(string) bool { return false }
+	}
+	w := &Watcher{
+		root:     filepath.Clean(root),
+		debounce: debounce,
+		ignore:   ignore,
+		onChange: onChange,
+		logger:   logger,
+		w:        fw,
+		done:     make(chan struct{}),
+		changed:  make(map[string]bool),
+	}
+	if err := w.addTree(w.root); err != nil {
+		_ = fw.Close()
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Add watches root and its subdirectories too, their changes are handed with the changes of the other trees.
+func (w *Watcher) Add(root string) error {
+	return w.addTree(filepath.Clean(root))
+}
+
+// Root returns the directory watched by New.
+func (w *Watcher) Root() string {
+	return w.root
+}
+
+// Close stops watching, changes not handed to the handler yet are dropped.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	close(w.done)
+	err := w.w.Close()
+	w.wg.Wait()
+	return err
+}
+
+// addTree watches dir and its subdirectories. Only a failure to watch dir itself is returned, subdirectories
+// which can not be watched are logged and their changes are missed.
+func (w *Watcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			w.logger.Warn("Can not watch directory", zap.String("path", path), zap.Error(err))
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if path != dir && w.ignore(path) {
+			return filepath.SkipDir
+		}
+		if err := w.w.Add(path); err != nil {
+			if path == dir {
+				return err
+			}
+			w.logger.Warn("Can not watch directory", zap.String("path", path), zap.Error(err))
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.logger.Warn("Watch events overflowed, the whole directory is backed up", zap.String("root", w.root))
+				w.record("", true)
+				continue
+			}
+			w.logger.Warn("Watch error", zap.String("root", w.root), zap.Error(err))
+		}
+	}
+}
+
+func (w *Watcher) handleEvent(event fsnotify.Event) {
+	if w.ignore(event.Name) {
+		return
+	}
+	if event.Op&fsnotify.Create != 0 {
+		if fi, err := os.Lstat(event.Name); err == nil && fi.IsDir() {
+			// files written in the new directory before it is watched are backed up with it
+			_ = w.addTree(event.Name)
+		}
+	}
+	w.record(event.Name, false)
+}
+
+// record adds path to the changes, and starts the debounce interval on the first of them.
+func (w *Watcher) record(path string, overflow bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if path != "" {
+		w.changed[path] = true
+	}
+	if overflow || len(w.changed) > MaxPaths {
+		w.overflow = true
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.debounce, w.flush)
+	}
+}
+
+// flush hands the accumulated changes to the handler.
+func (w *Watcher) flush() {
+	w.mu.Lock()
+	changed, overflow := w.changed, w.overflow
+	w.changed, w.overflow, w.timer = make(map[string]bool), false, nil
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return
+	}
+
+	changes := Changes{Overflow: overflow}
+	if !overflow {
+		changes.Paths = topPaths(changed)
+		if len(changes.Paths) == 0 {
+			return
+		}
+	}
+	if err := w.onChange(changes); err != nil {
+		w.logger.Warn("Handle watched changes error, retry with the next changes", zap.String("root", w.root), zap.Error(err))
+		if overflow {
+			w.record("", true)
+		}
+		for path := range changed {
+			w.record(path, false)
+		}
+	}
+}
+
+// topPaths returns the paths of changed which still exist and are not under another of them, sorted.
+func topPaths(changed map[string]bool) []string {
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		if _, err := os.Lstat(path); err == nil {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+	sort.Strings(paths)
+	top := paths[:0]
+	for _, path := range paths {
+		if n := len(top); n > 0 && (path == top[n-1] || strings.HasPrefix(path, top[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		top = append(top, path)
+	}
+	return top
+}
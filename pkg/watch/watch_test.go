@@ -0,0 +1,117 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	ignored := filepath.Join(root, "ignored")
+	require.NoError(t, os.Mkdir(ignored, 0700))
+
+	changesCh := make(chan Changes, 10)
+	failed := false
+	w, err := New(root, 100*time.Millisecond, func(path string) bool {
+		return path == ignored || filepath.Dir(path) == ignored
+	}, func(changes Changes) error {
+		if !failed {
+			failed = true
+			return errors.New("backup failed")
+		}
+		changesCh <- changes
+		return nil
+	}, zap.NewNop())
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, root, w.Root())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(ignored, "b.txt"), []byte("b"), 0600))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "dir", "c.txt"), []byte("c"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "removed.txt"), []byte("d"), 0600))
+	require.NoError(t, os.Remove(filepath.Join(root, "removed.txt")))
+
+	select {
+	case changes := <-changesCh:
+		assert.False(t, changes.Overflow)
+		assert.Equal(t, []string{filepath.Join(root, "a.txt"), filepath.Join(root, "dir")}, changes.Paths, "failed changes are handed again")
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes are not handed")
+	}
+
+	// the new directory is watched
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "dir", "e.txt"), []byte("e"), 0600))
+	select {
+	case changes := <-changesCh:
+		assert.Equal(t, []string{filepath.Join(root, "dir", "e.txt")}, changes.Paths)
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes of new directory are not handed")
+	}
+
+	require.NoError(t, w.Close())
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "f.txt"), []byte("f"), 0600))
+	select {
+	case changes := <-changesCh:
+		t.Fatalf("changes handed after close: %v", changes)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestWatcherAdd(t *testing.T) {
+	root, other := t.TempDir(), t.TempDir()
+	changesCh := make(chan Changes, 10)
+	w, err := New(root, 100*time.Millisecond, nil, func(changes Changes) error {
+		changesCh <- changes
+		return nil
+	}, zap.NewNop())
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Add(other))
+	assert.Error(t, w.Add(filepath.Join(other, "missing")))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(other, "b.txt"), []byte("b"), 0600))
+	select {
+	case changes := <-changesCh:
+		assert.ElementsMatch(t, []string{filepath.Join(root, "a.txt"), filepath.Join(other, "b.txt")}, changes.Paths)
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes are not handed")
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	root := t.TempDir()
+	w := &Watcher{root: root, debounce: time.Hour, changed: make(map[string]bool)}
+	for i := 0; i <= MaxPaths; i++ {
+		w.record(filepath.Join(root, fmt.Sprintf("%d.txt", i)), false)
+	}
+	assert.True(t, w.overflow)
+	w.timer.Stop()
+}
+
+func TestTopPaths(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "a", "b", "c.txt"), nil, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "ab.txt"), nil, 0600))
+
+	got := topPaths(map[string]bool{
+		filepath.Join(root, "a", "b", "c.txt"): true,
+		filepath.Join(root, "a"):               true,
+		filepath.Join(root, "ab.txt"):          true,
+		filepath.Join(root, "missing.txt"):     true,
+	})
+	assert.Equal(t, []string{filepath.Join(root, "a"), filepath.Join(root, "ab.txt")}, got)
+}